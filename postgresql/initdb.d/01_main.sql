@@ -37,7 +37,12 @@ VALUES (0, now(), 'Created with version'),
        (20, now(), 'Deprecate file_event_log.correlation_id column and migrate data where file_id != correlation_id'),
        (21, now(), 'Drop functions set_verified, and set_archived'),
        (22, now(), 'Add file_headers_backup table for key rotation safekeeping'),
-       (23, now(), 'Expand files table with storage locations');
+       (23, now(), 'Expand files table with storage locations'),
+       (24, now(), 'Add last_scrubbed_at to files and create scrub role'),
+       (25, now(), 'Add multipart_uploads and multipart_upload_parts tables for resumable uploads'),
+       (26, now(), 'Add outbox table for messages to be published by the pipeline services'),
+       (27, now(), 'Add inbox_quotas table for per-user and per-group inbox quotas'),
+       (28, now(), 'Add inbox_upload_parts table for checksums of multipart uploads to the inbox');
 
 -- Datasets are used to group files, and permissions are set on the dataset
 -- level
@@ -74,6 +79,7 @@ CREATE TABLE files (
     decrypted_file_size  BIGINT,
     backup_location      TEXT,
     backup_path          TEXT,
+    last_scrubbed_at     TIMESTAMP WITH TIME ZONE,
 
     header               TEXT,
     encryption_method    TEXT,
@@ -92,6 +98,7 @@ CREATE INDEX files_submission_user_submission_file_path_idx ON files(submission_
 CREATE INDEX files_submission_location_idx ON files(submission_location);
 CREATE INDEX files_archive_location_idx ON files(archive_location);
 CREATE INDEX files_backup_location_idx ON files(backup_location);
+CREATE INDEX files_last_scrubbed_at_idx ON files(last_scrubbed_at NULLS FIRST);
 
 -- The user info is used by auth to be able to link users to their name and email
 CREATE TABLE userinfo (
@@ -214,3 +221,65 @@ CREATE TABLE sda.file_headers_backup (
     key_hash    TEXT REFERENCES sda.encryption_keys(key_hash),
     backup_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
 );
+
+-- `multipart_uploads` stores the state of ongoing multipart uploads of files to
+-- the archive, such that an interrupted upload can be resumed.
+-- Rows are removed once the upload has been completed or aborted.
+CREATE TABLE sda.multipart_uploads (
+    file_id     UUID REFERENCES sda.files(id) PRIMARY KEY,
+    upload_id   TEXT NOT NULL,
+    location    TEXT NOT NULL,
+    object_key  TEXT NOT NULL,
+    part_size   BIGINT NOT NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+-- The parts of ongoing multipart uploads which have been completed
+CREATE TABLE sda.multipart_upload_parts (
+    file_id     UUID REFERENCES sda.multipart_uploads(file_id) ON DELETE CASCADE,
+    part_number INTEGER NOT NULL,
+    etag        TEXT NOT NULL,
+    size        BIGINT NOT NULL,
+    PRIMARY KEY (file_id, part_number)
+);
+
+-- `outbox` holds messages to be published to the message broker, written in
+-- the same transaction as the state change they announce. The messages are
+-- published, oldest first, by the outbox relay of the services, and removed
+-- once the broker has confirmed them.
+CREATE TABLE sda.outbox (
+    id             BIGSERIAL PRIMARY KEY,
+    correlation_id TEXT NOT NULL,
+    exchange       TEXT NOT NULL,
+    routing_key    TEXT NOT NULL,
+    body           BYTEA NOT NULL,
+    attempts       INTEGER NOT NULL DEFAULT 0,
+    last_error     TEXT,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+-- `inbox_quotas` limits the number of bytes and objects a user, or the members
+-- of a group together, may keep in the inbox. A NULL limit means unlimited.
+CREATE TABLE sda.inbox_quotas (
+    subject_type  TEXT NOT NULL CHECK (subject_type IN ('user', 'group')),
+    subject       TEXT NOT NULL,
+    max_bytes     BIGINT CHECK (max_bytes >= 0),
+    max_objects   BIGINT CHECK (max_objects >= 0),
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (subject_type, subject)
+);
+
+-- `inbox_upload_parts` holds the checksums of the parts of ongoing multipart
+-- uploads to the inbox, recorded by s3inbox as the parts pass through it. The
+-- sha256_state is the SHA256 of the object up to and including the part, kept
+-- while the parts are uploaded in order.
+CREATE TABLE sda.inbox_upload_parts (
+    upload_id    TEXT NOT NULL,
+    part_number  INTEGER NOT NULL,
+    size         BIGINT NOT NULL,
+    md5          TEXT NOT NULL,
+    sha256_state BYTEA,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (upload_id, part_number)
+);
@@ -42,3 +42,21 @@ VALUES (file_uuid, 'registered', submission_user);
 RETURN file_uuid;
 END;
 $register_file$ LANGUAGE plpgsql;
+
+-- Function for recording the checksum of a file as it was uploaded to the
+-- inbox, an empty checksum removes it. The inbox can not write to the
+-- checksums table, so that it can not change the checksums of archived files.
+CREATE FUNCTION sda.set_uploaded_checksum(fid UUID, uploaded_checksum TEXT)
+    RETURNS void AS $set_uploaded_checksum$
+BEGIN
+    IF uploaded_checksum = '' THEN
+        DELETE FROM sda.checksums WHERE file_id = fid AND type = 'SHA256' AND source = 'UPLOADED';
+    ELSE
+        INSERT INTO sda.checksums(file_id, checksum, type, source)
+        VALUES(fid, uploaded_checksum, 'SHA256', 'UPLOADED')
+        ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;
+    END IF;
+END;
+$set_uploaded_checksum$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = sda, pg_temp;
+
+REVOKE EXECUTE ON FUNCTION sda.set_uploaded_checksum(UUID, TEXT) FROM PUBLIC;
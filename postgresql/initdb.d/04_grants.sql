@@ -18,11 +18,18 @@ GRANT SELECT ON sda.dbschema_version TO base;
 GRANT SELECT ON local_ega.dbschema_version TO base;
 
 CREATE ROLE inbox;
--- uses: db.InsertFile
+-- uses: db.InsertFile, db.GetApplicableInboxQuotas, db.GetInboxUsage, db.AddInboxUploadPart, and db.SetUploadedChecksum
 GRANT USAGE ON SCHEMA sda TO inbox;
 GRANT SELECT, INSERT, UPDATE ON sda.files TO inbox;
 GRANT SELECT, INSERT ON sda.file_event_log TO inbox;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO inbox;
+GRANT SELECT ON sda.file_dataset TO inbox;
+GRANT SELECT ON sda.userinfo TO inbox;
+GRANT SELECT ON sda.inbox_quotas TO inbox;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_upload_parts TO inbox;
+GRANT SELECT ON sda.checksums TO inbox;
+-- uploaded checksums are only written through sda.set_uploaded_checksum
+GRANT EXECUTE ON FUNCTION sda.set_uploaded_checksum(UUID, TEXT) TO inbox;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO inbox;
@@ -47,6 +54,10 @@ GRANT SELECT ON sda.file_event_log TO ingest;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO ingest;
 GRANT SELECT ON sda.encryption_keys TO ingest;
 GRANT INSERT ON sda.encryption_keys TO ingest;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.multipart_uploads TO ingest;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.multipart_upload_parts TO ingest;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.outbox TO ingest;
+GRANT USAGE, SELECT ON SEQUENCE sda.outbox_id_seq TO ingest;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO ingest;
@@ -71,6 +82,8 @@ GRANT INSERT ON sda.file_event_log TO verify;
 GRANT SELECT ON sda.file_event_log TO verify;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO verify;
 GRANT SELECT ON sda.file_dataset TO verify;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.outbox TO verify;
+GRANT USAGE, SELECT ON SEQUENCE sda.outbox_id_seq TO verify;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO verify;
@@ -94,6 +107,8 @@ GRANT INSERT ON sda.file_event_log TO finalize;
 GRANT SELECT ON sda.file_event_log TO finalize;
 GRANT SELECT ON sda.file_dataset TO finalize;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO finalize;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.outbox TO finalize;
+GRANT USAGE, SELECT ON SEQUENCE sda.outbox_id_seq TO finalize;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO finalize;
@@ -145,6 +160,17 @@ GRANT INSERT, SELECT, UPDATE ON sda.file_headers_backup TO rotatekey;
 
 --------------------------------------------------------------------------------
 
+CREATE ROLE scrub;
+-- uses: db.GetFilesToScrub, db.SetScrubbed, and db.UpdateFileEventLog
+GRANT USAGE ON SCHEMA sda TO scrub;
+GRANT SELECT ON sda.files TO scrub;
+GRANT UPDATE (last_scrubbed_at) ON sda.files TO scrub;
+GRANT SELECT ON sda.checksums TO scrub;
+GRANT SELECT, INSERT ON sda.file_event_log TO scrub;
+GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO scrub;
+
+--------------------------------------------------------------------------------
+
 CREATE ROLE sync;
 -- uses: db.GetArchived
 GRANT USAGE ON SCHEMA sda TO sync;
@@ -192,6 +218,8 @@ GRANT SELECT ON sda.dataset_event_log TO api;
 GRANT INSERT ON sda.encryption_keys TO api;
 GRANT UPDATE ON sda.encryption_keys TO api;
 GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO api;
+GRANT SELECT ON sda.userinfo TO api;
+GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_quotas TO api;
 
 -- legacy schema
 GRANT USAGE ON SCHEMA local_ega TO api;
@@ -207,9 +235,9 @@ GRANT SELECT, INSERT, UPDATE ON sda.userinfo TO auth;
 --------------------------------------------------------------------------------
 
 -- lega_in permissions
-GRANT base, ingest, verify, finalize, sync, api TO lega_in;
+GRANT base, ingest, verify, finalize, sync, api, scrub TO lega_in;
 
 -- lega_out permissions
 GRANT mapper, download, api TO lega_out;
 
-GRANT base TO api, download, inbox, ingest, finalize, mapper, verify, auth;
+GRANT base TO api, download, inbox, ingest, finalize, mapper, verify, auth, scrub;
@@ -0,0 +1,55 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 23;
+  changes VARCHAR := 'Add last_scrubbed_at to files and create scrub role';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    ALTER TABLE sda.files
+        ADD COLUMN last_scrubbed_at TIMESTAMP WITH TIME ZONE;
+
+    CREATE INDEX files_last_scrubbed_at_idx ON sda.files(last_scrubbed_at NULLS FIRST);
+
+    -- Temporary function for creating roles if they do not already exist.
+    CREATE FUNCTION create_role_if_not_exists(role_name NAME) RETURNS void AS $created$
+    BEGIN
+        IF EXISTS (
+            SELECT FROM pg_catalog.pg_roles
+            WHERE  rolname = role_name) THEN
+                RAISE NOTICE 'Role "%" already exists. Skipping.', role_name;
+        ELSE
+            BEGIN
+                EXECUTE format('CREATE ROLE %I', role_name);
+            EXCEPTION
+                WHEN duplicate_object THEN
+                    RAISE NOTICE 'Role "%" was just created by a concurrent transaction. Skipping.', role_name;
+            END;
+        END IF;
+    END;
+    $created$ LANGUAGE plpgsql;
+
+    PERFORM create_role_if_not_exists('scrub');
+
+    GRANT USAGE ON SCHEMA sda TO scrub;
+    GRANT SELECT ON sda.files TO scrub;
+    GRANT UPDATE (last_scrubbed_at) ON sda.files TO scrub;
+    GRANT SELECT ON sda.checksums TO scrub;
+    GRANT SELECT, INSERT ON sda.file_event_log TO scrub;
+    GRANT USAGE, SELECT ON SEQUENCE sda.file_event_log_id_seq TO scrub;
+    GRANT base TO scrub;
+    GRANT scrub TO lega_in;
+
+    -- Drop temporary user creation function
+    DROP FUNCTION create_role_if_not_exists;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$
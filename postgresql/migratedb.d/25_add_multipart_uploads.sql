@@ -0,0 +1,38 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 24;
+  changes VARCHAR := 'Add multipart_uploads and multipart_upload_parts tables for resumable uploads';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.multipart_uploads (
+        file_id     UUID REFERENCES sda.files(id) PRIMARY KEY,
+        upload_id   TEXT NOT NULL,
+        location    TEXT NOT NULL,
+        object_key  TEXT NOT NULL,
+        part_size   BIGINT NOT NULL,
+        created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+    );
+
+    CREATE TABLE IF NOT EXISTS sda.multipart_upload_parts (
+        file_id     UUID REFERENCES sda.multipart_uploads(file_id) ON DELETE CASCADE,
+        part_number INTEGER NOT NULL,
+        etag        TEXT NOT NULL,
+        size        BIGINT NOT NULL,
+        PRIMARY KEY (file_id, part_number)
+    );
+
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.multipart_uploads TO ingest;
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.multipart_upload_parts TO ingest;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$
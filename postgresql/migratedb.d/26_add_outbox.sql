@@ -0,0 +1,32 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 25;
+  changes VARCHAR := 'Add outbox table for messages to be published by the pipeline services';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.outbox (
+        id             BIGSERIAL PRIMARY KEY,
+        correlation_id TEXT NOT NULL,
+        exchange       TEXT NOT NULL,
+        routing_key    TEXT NOT NULL,
+        body           BYTEA NOT NULL,
+        attempts       INTEGER NOT NULL DEFAULT 0,
+        last_error     TEXT,
+        created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+    );
+
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.outbox TO ingest, verify, finalize;
+    GRANT USAGE, SELECT ON SEQUENCE sda.outbox_id_seq TO ingest, verify, finalize;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$
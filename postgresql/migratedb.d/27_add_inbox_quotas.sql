@@ -0,0 +1,34 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 26;
+  changes VARCHAR := 'Add inbox_quotas table for per-user and per-group inbox quotas';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.inbox_quotas (
+        subject_type  TEXT NOT NULL CHECK (subject_type IN ('user', 'group')),
+        subject       TEXT NOT NULL,
+        max_bytes     BIGINT CHECK (max_bytes >= 0),
+        max_objects   BIGINT CHECK (max_objects >= 0),
+        created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        last_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        PRIMARY KEY (subject_type, subject)
+    );
+
+    GRANT SELECT ON sda.inbox_quotas TO inbox;
+    GRANT SELECT ON sda.file_dataset TO inbox;
+    GRANT SELECT ON sda.userinfo TO inbox;
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_quotas TO api;
+    GRANT SELECT ON sda.userinfo TO api;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$
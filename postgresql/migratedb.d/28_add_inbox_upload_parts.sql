@@ -0,0 +1,48 @@
+DO
+$$
+DECLARE
+-- The version we know how to do migration from, at the end of a successful migration
+-- we will no longer be at this version.
+  sourcever INTEGER := 27;
+  changes VARCHAR := 'Add inbox_upload_parts table for checksums of multipart uploads to the inbox';
+BEGIN
+  IF (SELECT max(version) FROM sda.dbschema_version) = sourcever THEN
+    RAISE NOTICE 'Doing migration from schema version % to %', sourcever, sourcever+1;
+    RAISE NOTICE 'Changes: %', changes;
+    INSERT INTO sda.dbschema_version VALUES(sourcever+1, now(), changes);
+
+    CREATE TABLE IF NOT EXISTS sda.inbox_upload_parts (
+        upload_id    TEXT NOT NULL,
+        part_number  INTEGER NOT NULL,
+        size         BIGINT NOT NULL,
+        md5          TEXT NOT NULL,
+        sha256_state BYTEA,
+        created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+        PRIMARY KEY (upload_id, part_number)
+    );
+
+    GRANT SELECT, INSERT, UPDATE, DELETE ON sda.inbox_upload_parts TO inbox;
+    -- The inbox records the checksums of uploads through a function limited to
+    -- the uploaded checksums, so that it can not change those of archived files
+    CREATE OR REPLACE FUNCTION sda.set_uploaded_checksum(fid UUID, uploaded_checksum TEXT)
+        RETURNS void AS $set_uploaded_checksum$
+    BEGIN
+        IF uploaded_checksum = '' THEN
+            DELETE FROM sda.checksums WHERE file_id = fid AND type = 'SHA256' AND source = 'UPLOADED';
+        ELSE
+            INSERT INTO sda.checksums(file_id, checksum, type, source)
+            VALUES(fid, uploaded_checksum, 'SHA256', 'UPLOADED')
+            ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;
+        END IF;
+    END;
+    $set_uploaded_checksum$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = sda, pg_temp;
+
+    REVOKE EXECUTE ON FUNCTION sda.set_uploaded_checksum(UUID, TEXT) FROM PUBLIC;
+    GRANT SELECT ON sda.checksums TO inbox;
+    GRANT EXECUTE ON FUNCTION sda.set_uploaded_checksum(UUID, TEXT) TO inbox;
+
+  ELSE
+    RAISE NOTICE 'Schema migration from % to % does not apply now, skipping', sourcever, sourcever+1;
+  END IF;
+END
+$$
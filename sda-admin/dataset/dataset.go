@@ -7,6 +7,7 @@ import (
 	"path"
 
 	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+	"github.com/tidwall/pretty"
 )
 
 type RequestBodyDataset struct {
@@ -73,3 +74,23 @@ func RotateKey(apiURI, token, datasetID string) error {
 
 	return nil
 }
+
+// History returns the event history of a dataset
+func History(apiURI, token, datasetID string, filter helpers.HistoryFilter) error {
+	parsedURL, err := url.Parse(apiURI)
+	if err != nil {
+		return err
+	}
+	// the dataset ID is appended as is, as path.Join would collapse the slashes of IDs such as DOI URLs
+	parsedURL.Path = path.Join(parsedURL.Path, "dataset/history") + "/" + datasetID
+	parsedURL.RawQuery = filter.Query().Encode()
+
+	response, err := helpers.GetResponseBody(parsedURL.String(), token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(pretty.Pretty(response)))
+
+	return nil
+}
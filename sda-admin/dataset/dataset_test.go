@@ -139,3 +139,45 @@ func TestRotateKey_Failure(t *testing.T) {
 	assert.Contains(t, err.Error(), "rotation failed")
 	mockHelpers.AssertExpectations(t)
 }
+
+func TestHistory_Success(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	expectedURL := "http://example.com/dataset/history/dataset-123?offset=100"
+	mockHelpers.On("GetResponseBody", expectedURL, "test-token").Return([]byte(`{"events":[]}`), nil)
+
+	err := History("http://example.com", "test-token", "dataset-123", helpers.HistoryFilter{Offset: 100})
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestHistory_Failure(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	expectedURL := "http://example.com/dataset/history/dataset-123"
+	mockHelpers.On("GetResponseBody", expectedURL, "test-token").Return([]byte(nil), errors.New("dataset not found"))
+
+	err := History("http://example.com", "test-token", "dataset-123", helpers.HistoryFilter{})
+	assert.EqualError(t, err, "dataset not found")
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestHistory_DOI(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	expectedURL := "http://example.com/dataset/history/https://doi.example.org/10.1234/dataset-123?limit=10"
+	mockHelpers.On("GetResponseBody", expectedURL, "test-token").Return([]byte(`{"events":[]}`), nil)
+
+	err := History("http://example.com", "test-token", "https://doi.example.org/10.1234/dataset-123", helpers.HistoryFilter{Limit: 10})
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
@@ -134,3 +134,22 @@ func RotateKey(apiURI, token, fileID string) error {
 
 	return nil
 }
+
+// History returns the event history of a file
+func History(apiURI, token, fileID string, filter helpers.HistoryFilter) error {
+	parsedURL, err := url.Parse(apiURI)
+	if err != nil {
+		return err
+	}
+	parsedURL.Path = path.Join(parsedURL.Path, "file", fileID, "history")
+	parsedURL.RawQuery = filter.Query().Encode()
+
+	response, err := helpers.GetResponseBody(parsedURL.String(), token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(pretty.Pretty(response)))
+
+	return nil
+}
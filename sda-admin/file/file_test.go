@@ -246,3 +246,31 @@ func TestRotateKey_Failure(t *testing.T) {
 	assert.Contains(t, err.Error(), "post request failed")
 	mockHelpers.AssertExpectations(t)
 }
+
+func TestHistory_Success(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	expectedURL := "http://example.com/file/dd813b8e-2235-4e6b-9bbd-1a6d33e3bd7f/history?event=error&limit=10"
+	mockHelpers.On("GetResponseBody", expectedURL, "test-token").Return([]byte(`{"events":[]}`), nil)
+
+	err := History("http://example.com", "test-token", "dd813b8e-2235-4e6b-9bbd-1a6d33e3bd7f", helpers.HistoryFilter{Event: "error", Limit: 10})
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestHistory_Failure(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	expectedURL := "http://example.com/file/dd813b8e-2235-4e6b-9bbd-1a6d33e3bd7f/history"
+	mockHelpers.On("GetResponseBody", expectedURL, "test-token").Return([]byte(nil), errors.New("file not found"))
+
+	err := History("http://example.com", "test-token", "dd813b8e-2235-4e6b-9bbd-1a6d33e3bd7f", helpers.HistoryFilter{})
+	assert.EqualError(t, err, "file not found")
+	mockHelpers.AssertExpectations(t)
+}
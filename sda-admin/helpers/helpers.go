@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +23,37 @@ type FileInfo struct {
 	Accession string
 }
 
+// HistoryFilter holds the filter and paging options of the history commands
+type HistoryFilter struct {
+	Event  string
+	Since  string
+	Until  string
+	Limit  int
+	Offset int
+}
+
+// Query returns the filter as URL query parameters, unset options are left out
+func (f HistoryFilter) Query() url.Values {
+	query := url.Values{}
+	if f.Event != "" {
+		query.Set("event", f.Event)
+	}
+	if f.Since != "" {
+		query.Set("since", f.Since)
+	}
+	if f.Until != "" {
+		query.Set("until", f.Until)
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Offset > 0 {
+		query.Set("offset", strconv.Itoa(f.Offset))
+	}
+
+	return query
+}
+
 // GetBody sends a GET request to the given URL and returns the body of the response
 func GetBody(url, token string) ([]byte, error) {
 	req, err := http.NewRequest("GET", url, nil)
@@ -90,6 +123,56 @@ func PostReq(url, token string, jsonBody []byte) ([]byte, error) {
 	return resBody, nil
 }
 
+// necessary for mocking in unit tests
+var PutRequest = PutReq
+
+// PutReq sends a PUT request to the server with a JSON body and returns the response body or an error.
+func PutReq(url, token string, jsonBody []byte) ([]byte, error) {
+	return sendRequest(http.MethodPut, url, token, jsonBody)
+}
+
+// necessary for mocking in unit tests
+var DeleteRequest = DeleteReq
+
+// DeleteReq sends a DELETE request to the server and returns the response body or an error.
+func DeleteReq(url, token string) ([]byte, error) {
+	return sendRequest(http.MethodDelete, url, token, nil)
+}
+
+// sendRequest sends a request with an optional JSON body and returns the response body, responses other than
+// 200 OK are returned as errors
+func sendRequest(method, url, token string, jsonBody []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the request, reason: %v", err)
+	}
+
+	// Add headers
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+
+	// Send the request
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request, reason: %v", err)
+	}
+	defer res.Body.Close()
+
+	// Read the response body
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body, reason: %v", err)
+	}
+
+	// Check the status code
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d: %s", res.StatusCode, string(resBody))
+	}
+
+	return resBody, nil
+}
+
 // Check for invalid characters for filepath
 func CheckValidChars(filename string) error {
 	re := regexp.MustCompile(`[\\<>"\|\x00-\x1F\x7F\!\*\'\(\)\;\:\@\&\=\+\$\,\?\%\#\[\]]`)
@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -71,6 +72,38 @@ func TestPostReq(t *testing.T) {
 	assert.JSONEq(t, mockResponse, string(body))
 }
 
+func TestPutAndDeleteReq(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer mock_token", req.Header.Get("Authorization"))
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		switch req.Method {
+		case http.MethodPut:
+			assert.JSONEq(t, `{"maxBytes":10}`, string(body))
+		case http.MethodDelete:
+			assert.Empty(t, body)
+		default:
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	_, err := PutReq(server.URL, "mock_token", []byte(`{"maxBytes":10}`))
+	assert.NoError(t, err)
+
+	_, err = DeleteReq(server.URL, "mock_token")
+	assert.NoError(t, err)
+
+	serverError := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer serverError.Close()
+
+	_, err = DeleteReq(serverError.URL, "mock_token")
+	assert.ErrorContains(t, err, "server returned status 404")
+}
+
 func TestInvalidCharacters(t *testing.T) {
 	// Test that file paths with invalid characters trigger errors
 	for _, badc := range "\x00\x7F\x1A:*?\\<>\"|!'();@&=+$,%#[]" {
@@ -82,3 +115,10 @@ func TestInvalidCharacters(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("filepath '%v' contains disallowed characters: %+v", testfilepath, badchar), err.Error())
 	}
 }
+
+func TestHistoryFilterQuery(t *testing.T) {
+	assert.Empty(t, HistoryFilter{}.Query().Encode())
+
+	filter := HistoryFilter{Event: "error", Since: "2025-01-01T00:00:00Z", Limit: 10, Offset: 20}
+	assert.Equal(t, "event=error&limit=10&offset=20&since=2025-01-01T00%3A00%3A00Z", filter.Query().Encode())
+}
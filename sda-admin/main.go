@@ -10,6 +10,7 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/sda-admin/dataset"
 	"github.com/neicnordic/sensitive-data-archive/sda-admin/file"
 	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+	"github.com/neicnordic/sensitive-data-archive/sda-admin/pipeline"
 	"github.com/neicnordic/sensitive-data-archive/sda-admin/user"
 )
 
@@ -25,6 +26,9 @@ const usage = `usage: sda-admin [-uri URI] [-token TOKEN] <command> [options]
 
 Commands:
   user list                     List all users.
+  user quota set|get|delete -user USERNAME | -group GROUP [-max-bytes N] [-max-objects N]
+                                Manage the inbox quota of a user or group.
+  user quota list               List all inbox quotas with their usage.
   file list -user USERNAME      List all files for a specified user.
   file ingest -filepath FILEPATH -user USERNAME
                                 Trigger ingestion of a given file.
@@ -32,12 +36,17 @@ Commands:
                                 Assign accession ID to a file.
   file rotatekey -file-id FILEUUID
                                 Rotate encryption key for a specific file.
+  file history -file-id FILEUUID
+                                List the events of a file.
   dataset create -user SUBMISSION_USER -dataset-id DATASET_ID accessionID [accessionID ...]
                                 Create a dataset from a list of accession IDs and a dataset ID.
   dataset release -dataset-id DATASET_ID
                                 Release a dataset for downloading.
   dataset rotatekey -dataset-id DATASET_ID
                                 Rotate encryption keys for all files in a dataset.
+  dataset history -dataset-id DATASET_ID
+                                List the events of a dataset.
+  pipeline status               Show files per state, stuck files and queue depths.
   
 Global Options:
   -uri URI         Set the URI for the API server (optional if API_HOST is set).
@@ -50,11 +59,39 @@ Additional Commands:
 
 var userUsage = `List Users:
   Usage: sda-admin user list 
-    List all users in the system with ongoing submissions.`
+    List all users in the system with ongoing submissions.
+
+Manage inbox quotas:
+  Usage: sda-admin user quota set|get|delete -user USERNAME | -group GROUP [-max-bytes N] [-max-objects N]
+    Set, show or remove the inbox quota of a user or group.
+
+  Usage: sda-admin user quota list
+    List all inbox quotas with their usage.
+
+Use 'sda-admin help user <command>' for information on a specific command.`
 
 var userListUsage = `Usage: sda-admin user list 
   List all users in the system with ongoing submissions.`
 
+var userQuotaUsage = `Usage: sda-admin user quota set -user USERNAME | -group GROUP [-max-bytes N] [-max-objects N]
+  Set the inbox quota of a user, or of the members of a group together, replacing an existing quota.
+  Uploads to the inbox that would exceed the quota are rejected.
+
+Usage: sda-admin user quota get -user USERNAME | -group GROUP
+  Show the inbox quota of a user or group with its current usage.
+
+Usage: sda-admin user quota delete -user USERNAME | -group GROUP
+  Remove the inbox quota of a user or group.
+
+Usage: sda-admin user quota list
+  List all inbox quotas with their current usage.
+
+Options:
+  -user USERNAME      The user ID, as in the sub of the user's token.
+  -group GROUP        The name of the group.
+  -max-bytes N        Maximum number of bytes in the inbox, unlimited if not set.
+  -max-objects N      Maximum number of objects in the inbox, unlimited if not set.`
+
 var fileUsage = `List all files for a user:
   Usage: sda-admin file list -user USERNAME
 	List all files for a specified user.
@@ -71,11 +108,15 @@ Rotate key for a file:
   Usage: sda-admin file rotatekey -file-id FILEUUID
     Rotate encryption key for a specific file.
 
+Show the history of a file:
+  Usage: sda-admin file history -file-id FILEUUID [-event EVENT] [-since TIME] [-until TIME] [-limit N] [-offset N]
+    List the events of a file, oldest first.
+
 Options:
   -user USERNAME       Specify the username associated with the file.
   -filepath FILEPATH   Specify the path of the file to ingest.
   -accession-id ID     Specify the accession ID to assign to the file.
-  -file-id FILEUUID    Specify the file ID of the file to rotate key.
+  -file-id FILEUUID    Specify the file ID of the file to rotate key or show the history of.
 
 Use 'sda-admin help file <command>' for information on a specific command.`
 
@@ -112,6 +153,19 @@ var fileRotateKeyUsage = `Usage: sda-admin file rotatekey -file-id FILEUUID
 Options:
   -file-id FILEUUID     Specify the file ID of the file to rotate key.`
 
+var fileHistoryUsage = `Usage: sda-admin file history -file-id FILEUUID [-event EVENT] [-since TIME] [-until TIME] [-limit N] [-offset N]
+  List the events of a file, oldest first, with user, message, details and timestamps.
+
+Options:
+  -file-id FILEUUID     Specify the file ID of the file.
+` + historyOptionsUsage
+
+var historyOptionsUsage = `  -event EVENT          Only list events of this type, e.g. uploaded or error.
+  -since TIME           Only list events from this time, as an RFC3339 timestamp.
+  -until TIME           Only list events before this time, as an RFC3339 timestamp.
+  -limit N              Maximum number of events to list (1-1000, default 100).
+  -offset N             Number of events to skip, use nextOffset from the output to get the next page.`
+
 var datasetUsage = `Create a dataset:
   Usage: sda-admin dataset create -user SUBMISSION_USER -dataset-id DATASET_ID [ACCESSION_ID ...]
     Create a dataset from a list of accession IDs and a dataset ID.
@@ -124,6 +178,10 @@ Rotate keys for a dataset:
   Usage: sda-admin dataset rotatekey -dataset-id DATASET_ID
     Rotate encryption keys for all files in a dataset.
 
+Show the history of a dataset:
+  Usage: sda-admin dataset history -dataset-id DATASET_ID [-event EVENT] [-since TIME] [-until TIME] [-limit N] [-offset N]
+    List the events of a dataset, oldest first.
+
 Options:
   -dataset-id DATASET_ID   Specify the unique identifier for the dataset.
   [ACCESSION_ID ...]       (For dataset create) Specify one or more accession IDs to include in the dataset.
@@ -149,6 +207,25 @@ var datasetRotateKeyUsage = `Usage: sda-admin dataset rotatekey -dataset-id DATA
 Options:
   -dataset-id DATASET_ID    Specify the unique identifier for the dataset.`
 
+var datasetHistoryUsage = `Usage: sda-admin dataset history -dataset-id DATASET_ID [-event EVENT] [-since TIME] [-until TIME] [-limit N] [-offset N]
+  List the events of a dataset, oldest first, with message and timestamp.
+
+Options:
+  -dataset-id DATASET_ID    Specify the unique identifier for the dataset.
+` + historyOptionsUsage
+
+var pipelineUsage = `Show the pipeline status:
+  Usage: sda-admin pipeline status
+    Show the number of files per state, the files that have been in a state for too long
+    and the number of messages and consumers of the broker queues.
+
+Use 'sda-admin help pipeline <command>' for information on a specific command.`
+
+var pipelineStatusUsage = `Usage: sda-admin pipeline status
+  Show the number of files per state, the files that have been in a state for too long
+  and the number of messages and consumers of the broker queues. Queues without consumers
+  point to a service that is down.`
+
 var c4ghHashUsage = `Handles the crypt4gh keys in the system.
 
 Usage: sda-admin c4gh-hash add -filepath FILEPATH -description DESCRIPTION
@@ -250,6 +327,10 @@ func handleHelpCommand() error {
 		if err := handleHelpC4ghKeyHash(); err != nil {
 			return err
 		}
+	case "pipeline":
+		if err := handleHelpPipeline(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown command '%s'.\n%s", flag.Arg(1), usage)
 	}
@@ -263,6 +344,8 @@ func handleHelpUser() error {
 		fmt.Println(userUsage)
 	case flag.Arg(2) == "list":
 		fmt.Println(userListUsage)
+	case flag.Arg(2) == "quota":
+		fmt.Println(userQuotaUsage)
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(2), flag.Arg(1), userUsage)
 	}
@@ -282,6 +365,8 @@ func handleHelpFile() error {
 		fmt.Println(fileAccessionUsage)
 	case flag.Arg(2) == "rotatekey":
 		fmt.Println(fileRotateKeyUsage)
+	case flag.Arg(2) == "history":
+		fmt.Println(fileHistoryUsage)
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(2), flag.Arg(1), fileUsage)
 	}
@@ -299,6 +384,8 @@ func handleHelpDataset() error {
 		fmt.Println(datasetReleaseUsage)
 	case flag.Arg(2) == "rotatekey":
 		fmt.Println(datasetRotateKeyUsage)
+	case flag.Arg(2) == "history":
+		fmt.Println(datasetHistoryUsage)
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(2), flag.Arg(1), datasetUsage)
 	}
@@ -308,7 +395,7 @@ func handleHelpDataset() error {
 
 func handleUserCommand() error {
 	if flag.NArg() < 2 {
-		return fmt.Errorf("error: 'user' requires a subcommand (list).\n%s", userUsage)
+		return fmt.Errorf("error: 'user' requires a subcommand (list, quota).\n%s", userUsage)
 	}
 	switch flag.Arg(1) {
 	case "list":
@@ -316,6 +403,10 @@ func handleUserCommand() error {
 		if err != nil {
 			return fmt.Errorf("error: failed to get users, reason: %v", err)
 		}
+	case "quota":
+		if err := handleUserQuotaCommand(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(1), flag.Arg(0), userUsage)
 	}
@@ -323,6 +414,71 @@ func handleUserCommand() error {
 	return nil
 }
 
+func handleUserQuotaCommand() error {
+	if flag.NArg() < 3 {
+		return fmt.Errorf("error: 'user quota' requires a subcommand (set, get, list, delete).\n%s", userQuotaUsage)
+	}
+
+	if flag.Arg(2) == "list" {
+		if err := user.ListQuotas(apiURI, token); err != nil {
+			return fmt.Errorf("error: failed to list quotas, reason: %v", err)
+		}
+
+		return nil
+	}
+
+	quotaCmd := flag.NewFlagSet(flag.Arg(2), flag.ExitOnError)
+	var username, group string
+	var maxBytes, maxObjects int64
+	quotaCmd.StringVar(&username, "user", "", "User ID of the quota")
+	quotaCmd.StringVar(&group, "group", "", "Group name of the quota")
+	quotaCmd.Int64Var(&maxBytes, "max-bytes", -1, "Maximum number of bytes in the inbox")
+	quotaCmd.Int64Var(&maxObjects, "max-objects", -1, "Maximum number of objects in the inbox")
+
+	if err := quotaCmd.Parse(flag.Args()[3:]); err != nil {
+		return fmt.Errorf("error: failed to parse command line arguments, reason: %v", err)
+	}
+
+	subjectType, name := "user", username
+	switch {
+	case username != "" && group != "":
+		return fmt.Errorf("error: choose either -user or -group.\n%s", userQuotaUsage)
+	case group != "":
+		subjectType, name = "group", group
+	case username == "":
+		return fmt.Errorf("error: either -user or -group is required.\n%s", userQuotaUsage)
+	}
+
+	switch flag.Arg(2) {
+	case "set":
+		var quota user.Quota
+		if maxBytes >= 0 {
+			quota.MaxBytes = &maxBytes
+		}
+		if maxObjects >= 0 {
+			quota.MaxObjects = &maxObjects
+		}
+		if quota.MaxBytes == nil && quota.MaxObjects == nil {
+			return fmt.Errorf("error: -max-bytes and/or -max-objects is required.\n%s", userQuotaUsage)
+		}
+		if err := user.SetQuota(apiURI, token, subjectType, name, quota); err != nil {
+			return fmt.Errorf("error: failed to set quota, reason: %v", err)
+		}
+	case "get":
+		if err := user.GetQuota(apiURI, token, subjectType, name); err != nil {
+			return fmt.Errorf("error: failed to get quota, reason: %v", err)
+		}
+	case "delete":
+		if err := user.DeleteQuota(apiURI, token, subjectType, name); err != nil {
+			return fmt.Errorf("error: failed to delete quota, reason: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown subcommand '%s' for 'user quota'.\n%s", flag.Arg(2), userQuotaUsage)
+	}
+
+	return nil
+}
+
 func handleFileListCommand() error {
 	listFilesCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	var username string
@@ -346,7 +502,7 @@ func handleFileListCommand() error {
 
 func handleFileCommand() error {
 	if flag.NArg() < 2 {
-		return fmt.Errorf("error: 'file' requires a subcommand (list, ingest, set-accession).\n%s", fileUsage)
+		return fmt.Errorf("error: 'file' requires a subcommand (list, ingest, set-accession, rotatekey, history).\n%s", fileUsage)
 	}
 	switch flag.Arg(1) {
 	case "list":
@@ -365,6 +521,10 @@ func handleFileCommand() error {
 		if err := handleFileRotateKeyCommand(); err != nil {
 			return err
 		}
+	case "history":
+		if err := handleFileHistoryCommand(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(1), flag.Arg(0), fileUsage)
 	}
@@ -460,9 +620,41 @@ func handleFileRotateKeyCommand() error {
 	return nil
 }
 
+// historyFlags adds the filter and paging flags of the history commands to the flag set
+func historyFlags(cmd *flag.FlagSet, filter *helpers.HistoryFilter) {
+	cmd.StringVar(&filter.Event, "event", "", "Only list events of this type")
+	cmd.StringVar(&filter.Since, "since", "", "Only list events from this time (RFC3339)")
+	cmd.StringVar(&filter.Until, "until", "", "Only list events before this time (RFC3339)")
+	cmd.IntVar(&filter.Limit, "limit", 0, "Maximum number of events to list")
+	cmd.IntVar(&filter.Offset, "offset", 0, "Number of events to skip")
+}
+
+func handleFileHistoryCommand() error {
+	fileHistoryCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	var fileID string
+	var filter helpers.HistoryFilter
+	fileHistoryCmd.StringVar(&fileID, "file-id", "", "File ID (UUID) to show the history of")
+	historyFlags(fileHistoryCmd, &filter)
+
+	if err := fileHistoryCmd.Parse(flag.Args()[2:]); err != nil {
+		return fmt.Errorf("error: failed to parse command line arguments, reason: %v", err)
+	}
+
+	if fileID == "" {
+		return fmt.Errorf("error: -file-id is required.\n%s", fileHistoryUsage)
+	}
+
+	err := file.History(apiURI, token, fileID, filter)
+	if err != nil {
+		return fmt.Errorf("error: failed to get file history, reason: %v", err)
+	}
+
+	return nil
+}
+
 func handleDatasetCommand() error {
 	if flag.NArg() < 2 {
-		return fmt.Errorf("error: 'dataset' requires a subcommand (create, release, rotatekey).\n%s", datasetUsage)
+		return fmt.Errorf("error: 'dataset' requires a subcommand (create, release, rotatekey, history).\n%s", datasetUsage)
 	}
 
 	switch flag.Arg(1) {
@@ -478,6 +670,10 @@ func handleDatasetCommand() error {
 		if err := handleDatasetRotateKeyCommand(); err != nil {
 			return err
 		}
+	case "history":
+		if err := handleDatasetHistoryCommand(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(1), flag.Arg(0), datasetUsage)
 	}
@@ -555,6 +751,58 @@ func handleDatasetRotateKeyCommand() error {
 	return nil
 }
 
+func handleDatasetHistoryCommand() error {
+	datasetHistoryCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	var datasetID string
+	var filter helpers.HistoryFilter
+	datasetHistoryCmd.StringVar(&datasetID, "dataset-id", "", "ID of the dataset to show the history of")
+	historyFlags(datasetHistoryCmd, &filter)
+
+	if err := datasetHistoryCmd.Parse(flag.Args()[2:]); err != nil {
+		return fmt.Errorf("error: failed to parse command line arguments, reason: %v", err)
+	}
+
+	if datasetID == "" {
+		return fmt.Errorf("error: -dataset-id is required.\n%s", datasetHistoryUsage)
+	}
+
+	err := dataset.History(apiURI, token, datasetID, filter)
+	if err != nil {
+		return fmt.Errorf("error: failed to get dataset history, reason: %v", err)
+	}
+
+	return nil
+}
+
+func handleHelpPipeline() error {
+	switch {
+	case flag.NArg() == 2:
+		fmt.Println(pipelineUsage)
+	case flag.Arg(2) == "status":
+		fmt.Println(pipelineStatusUsage)
+	default:
+		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(2), flag.Arg(1), pipelineUsage)
+	}
+
+	return nil
+}
+
+func handlePipelineCommand() error {
+	if flag.NArg() < 2 {
+		return fmt.Errorf("error: 'pipeline' requires a subcommand (status).\n%s", pipelineUsage)
+	}
+	switch flag.Arg(1) {
+	case "status":
+		if err := pipeline.ShowStatus(apiURI, token); err != nil {
+			return fmt.Errorf("error: failed to get pipeline status, reason: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown subcommand '%s' for '%s'.\n%s", flag.Arg(1), flag.Arg(0), pipelineUsage)
+	}
+
+	return nil
+}
+
 func handleHelpC4ghKeyHash() error {
 	switch {
 	case flag.NArg() == 2:
@@ -684,6 +932,11 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	case "pipeline":
+		if err := handlePipelineCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command '%s'.\n%s\n", flag.Arg(0), usage)
 		os.Exit(1)
@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+)
+
+// Status is the pipeline status returned by the SDA API
+type Status struct {
+	Files  map[string]int        `json:"files"`
+	Stuck  map[string]StuckFiles `json:"stuck"`
+	Queues []Queue               `json:"queues"`
+}
+
+// StuckFiles are the files that have been in a state for longer than StuckAfter
+type StuckFiles struct {
+	StuckAfter string      `json:"stuckAfter"`
+	Files      []StuckFile `json:"files"`
+}
+
+type StuckFile struct {
+	FileID    string    `json:"fileID"`
+	User      string    `json:"user"`
+	InboxPath string    `json:"inboxPath"`
+	Since     time.Time `json:"since"`
+}
+
+type Queue struct {
+	Name      string `json:"name"`
+	Messages  int    `json:"messages"`
+	Consumers int    `json:"consumers"`
+	Error     string `json:"error"`
+}
+
+// states are the file events in pipeline order, other events are listed after these
+var states = []string{"registered", "uploaded", "submitted", "ingested", "archived", "verified", "ready", "downloaded", "disabled", "enabled", "error"}
+
+// ShowStatus prints the pipeline status as tables
+func ShowStatus(apiURI, token string) error {
+	parsedURL, err := url.Parse(apiURI)
+	if err != nil {
+		return err
+	}
+	parsedURL.Path = path.Join(parsedURL.Path, "admin", "pipeline", "status")
+
+	response, err := helpers.GetResponseBody(parsedURL.String(), token)
+	if err != nil {
+		return err
+	}
+
+	var status Status
+	if err := json.Unmarshal(response, &status); err != nil {
+		return fmt.Errorf("failed to parse the pipeline status, reason: %v", err)
+	}
+
+	return render(os.Stdout, status, time.Now())
+}
+
+// render writes the file counts, stuck files and queues as tables
+func render(w io.Writer, status Status, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "STATE\tFILES")
+	for _, state := range ordered(status.Files) {
+		fmt.Fprintf(tw, "%s\t%d\n", state, status.Files[state])
+	}
+
+	fmt.Fprintln(tw, "\nSTATE\tSTUCK AFTER\tWAITING\tFILE ID\tUSER\tINBOX PATH")
+	for _, state := range ordered(status.Stuck) {
+		for _, file := range status.Stuck[state].Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", state, status.Stuck[state].StuckAfter, now.Sub(file.Since).Round(time.Minute), file.FileID, file.User, file.InboxPath)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nQUEUE\tMESSAGES\tCONSUMERS\tSTATUS")
+	for _, queue := range status.Queues {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", queue.Name, queue.Messages, queue.Consumers, queueStatus(queue))
+	}
+
+	return tw.Flush()
+}
+
+// queueStatus flags queues that could not be inspected and queues that no service reads from
+func queueStatus(queue Queue) string {
+	switch {
+	case queue.Error != "":
+		return queue.Error
+	case queue.Consumers == 0:
+		return "no consumers"
+	default:
+		return "ok"
+	}
+}
+
+// ordered returns the keys of m in pipeline order
+func ordered[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := slices.Index(states, keys[i]), slices.Index(states, keys[j])
+		switch {
+		case a == -1 && b == -1:
+			return keys[i] < keys[j]
+		case a == -1 || b == -1:
+			return b == -1
+		default:
+			return a < b
+		}
+	})
+
+	return keys
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockHelpers is a mock implementation of the helpers package functions
+type MockHelpers struct {
+	mock.Mock
+}
+
+func (m *MockHelpers) GetResponseBody(url, token string) ([]byte, error) {
+	args := m.Called(url, token)
+
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func TestShowStatus_Success(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	mockHelpers.On("GetResponseBody", "http://example.com/admin/pipeline/status", "test-token").Return([]byte(`{"files":{"uploaded":1},"stuck":{},"queues":[]}`), nil)
+
+	err := ShowStatus("http://example.com", "test-token")
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestShowStatus_Failure(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	originalFunc := helpers.GetResponseBody
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }() // Restore original after test
+
+	mockHelpers.On("GetResponseBody", "http://example.com/admin/pipeline/status", "test-token").Return([]byte(nil), errors.New("not authorized"))
+
+	err := ShowStatus("http://example.com", "test-token")
+	assert.EqualError(t, err, "not authorized")
+
+	mockHelpers.On("GetResponseBody", "http://example.com/admin/pipeline/status", "bad-json").Return([]byte(`[]`), nil)
+	err = ShowStatus("http://example.com", "bad-json")
+	assert.ErrorContains(t, err, "failed to parse the pipeline status")
+}
+
+func TestRender(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	status := Status{
+		Files: map[string]int{"verified": 3, "custom": 2, "uploaded": 12, "ready": 1024},
+		Stuck: map[string]StuckFiles{
+			"verified": {StuckAfter: "72h0m0s", Files: []StuckFile{{FileID: "c2acecc6-f208-441c-877a-2670e4cbb040", User: "submitter@example.org", InboxPath: "submission-1/file.c4gh", Since: now.Add(-80 * time.Hour)}}},
+		},
+		Queues: []Queue{{Name: "verified", Messages: 0, Consumers: 1}, {Name: "accession", Messages: 17}, {Name: "gone", Error: "NOT_FOUND"}},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, render(&out, status, now))
+	expected := `STATE     FILES
+uploaded  12
+verified  3
+ready     1024
+custom    2
+
+STATE     STUCK AFTER  WAITING  FILE ID                               USER                   INBOX PATH
+verified  72h0m0s      80h0m0s  c2acecc6-f208-441c-877a-2670e4cbb040  submitter@example.org  submission-1/file.c4gh
+
+QUEUE      MESSAGES  CONSUMERS  STATUS
+verified   0         1          ok
+accession  17        0          no consumers
+gone       0         0          NOT_FOUND
+`
+	assert.Equal(t, expected, out.String())
+}
@@ -0,0 +1,91 @@
+package user // nolint:revive
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+	"github.com/tidwall/pretty"
+)
+
+// Quota holds the limits of an inbox quota, a nil limit is unlimited
+type Quota struct {
+	MaxBytes   *int64 `json:"maxBytes"`
+	MaxObjects *int64 `json:"maxObjects"`
+}
+
+// quotaURL returns the URL of the quotas, or of the quota of a user or group if subjectType and name are given
+func quotaURL(apiURI string, subject ...string) (string, error) {
+	parsedURL, err := url.Parse(apiURI)
+	if err != nil {
+		return "", err
+	}
+	parsedURL.Path = path.Join(append([]string{parsedURL.Path, "quotas"}, subject...)...)
+
+	return parsedURL.String(), nil
+}
+
+// ListQuotas prints the inbox quotas of all users and groups with their usage
+func ListQuotas(apiURI, token string) error {
+	requestURL, err := quotaURL(apiURI)
+	if err != nil {
+		return err
+	}
+
+	response, err := helpers.GetResponseBody(requestURL, token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(pretty.Pretty(response)))
+
+	return nil
+}
+
+// GetQuota prints the inbox quota of a user or group with its usage
+func GetQuota(apiURI, token, subjectType, name string) error {
+	requestURL, err := quotaURL(apiURI, subjectType, name)
+	if err != nil {
+		return err
+	}
+
+	response, err := helpers.GetResponseBody(requestURL, token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(pretty.Pretty(response)))
+
+	return nil
+}
+
+// SetQuota sets the inbox quota of a user or group
+func SetQuota(apiURI, token, subjectType, name string, quota Quota) error {
+	requestURL, err := quotaURL(apiURI, subjectType, name)
+	if err != nil {
+		return err
+	}
+
+	jsonBody, err := json.Marshal(quota)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON, reason: %v", err)
+	}
+
+	_, err = helpers.PutRequest(requestURL, token, jsonBody)
+
+	return err
+}
+
+// DeleteQuota removes the inbox quota of a user or group
+func DeleteQuota(apiURI, token, subjectType, name string) error {
+	requestURL, err := quotaURL(apiURI, subjectType, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = helpers.DeleteRequest(requestURL, token)
+
+	return err
+}
@@ -0,0 +1,75 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/sda-admin/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func (m *MockHelpers) PutRequest(url, token string, jsonBody []byte) ([]byte, error) {
+	args := m.Called(url, token, jsonBody)
+
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockHelpers) DeleteRequest(url, token string) ([]byte, error) {
+	args := m.Called(url, token)
+
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func TestListQuotas(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	mockHelpers.On("GetResponseBody", "http://example.com/quotas", "test-token").Return([]byte(`[]`), nil)
+
+	originalFunc := helpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }()
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+
+	err := ListQuotas("http://example.com", "test-token")
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestGetQuota(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	mockHelpers.On("GetResponseBody", "http://example.com/quotas/user/submitter@example.org", "test-token").Return([]byte(`{"type":"user","name":"submitter@example.org","maxBytes":10,"maxObjects":null,"usage":{"bytes":0,"objects":0}}`), nil)
+	mockHelpers.On("GetResponseBody", "http://example.com/quotas/group/missing", "test-token").Return([]byte(nil), errors.New("server returned status 404"))
+
+	originalFunc := helpers.GetResponseBody
+	defer func() { helpers.GetResponseBody = originalFunc }()
+	helpers.GetResponseBody = mockHelpers.GetResponseBody
+
+	assert.NoError(t, GetQuota("http://example.com", "test-token", "user", "submitter@example.org"))
+	assert.EqualError(t, GetQuota("http://example.com", "test-token", "group", "missing"), "server returned status 404")
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestSetQuota(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	mockHelpers.On("PutRequest", "http://example.com/quotas/group/project-1", "test-token", []byte(`{"maxBytes":1000,"maxObjects":null}`)).Return([]byte(nil), nil)
+
+	originalFunc := helpers.PutRequest
+	defer func() { helpers.PutRequest = originalFunc }()
+	helpers.PutRequest = mockHelpers.PutRequest
+
+	maxBytes := int64(1000)
+	err := SetQuota("http://example.com", "test-token", "group", "project-1", Quota{MaxBytes: &maxBytes})
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
+
+func TestDeleteQuota(t *testing.T) {
+	mockHelpers := new(MockHelpers)
+	mockHelpers.On("DeleteRequest", "http://example.com/quotas/user/submitter", "test-token").Return([]byte(nil), nil)
+
+	originalFunc := helpers.DeleteRequest
+	defer func() { helpers.DeleteRequest = originalFunc }()
+	helpers.DeleteRequest = mockHelpers.DeleteRequest
+
+	err := DeleteQuota("http://example.com", "test-token", "user", "submitter")
+	assert.NoError(t, err)
+	mockHelpers.AssertExpectations(t)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -15,6 +16,8 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -26,6 +29,7 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -53,6 +57,13 @@ var (
 	auth        *userauth.ValidateFromToken
 	inboxReader storage.Reader
 	inboxWriter storage.Writer
+	auditLogger audit.Logger = audit.NoopLogger{}
+)
+
+// Context keys of the audit event of a request and of the authenticated user
+const (
+	auditEventKey = "auditEvent"
+	actorKey      = "actor"
 )
 
 func main() {
@@ -74,8 +85,8 @@ func run() error {
 		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
 	}
 	defer Conf.API.DB.Close()
-	if Conf.API.DB.Version < 23 {
-		return errors.New("database schema v23 is required")
+	if Conf.API.DB.Version < 27 {
+		return errors.New("database schema v27 is required")
 	}
 
 	Conf.API.MQ, err = broker.NewMQ(Conf.Broker)
@@ -111,6 +122,19 @@ func run() error {
 		return fmt.Errorf("failed to initialize inbox reader, reason: %v", err)
 	}
 
+	if len(Conf.Audit.Sinks) > 0 {
+		sinkLogger, err := audit.NewLogger(Conf.Audit)
+		if err != nil {
+			return fmt.Errorf("failed to initialize audit logger, due to: %v", err)
+		}
+		defer func() {
+			if err := sinkLogger.Close(); err != nil {
+				log.Errorf("failed to close audit logger due to: %v", err)
+			}
+		}()
+		auditLogger = sinkLogger
+	}
+
 	if err := setupJwtAuth(); err != nil {
 		return fmt.Errorf("error when setting up JWT auth, reason %s", err.Error())
 	}
@@ -193,27 +217,36 @@ func setup(conf *config.Config) (*http.Server, error) {
 	}
 
 	r.GET("/ready", readinessResponse)
-	r.GET("/files", rbac(e), getFiles)
-	r.GET("/datasets", rbac(e), listDatasets)
+	r.GET("/files", audited(audit.EventAdminList), rbac(e), getFiles)
+	r.GET("/datasets", audited(audit.EventAdminList), rbac(e), listDatasets)
 	// admin endpoints below here
-	r.POST("/c4gh-keys/add", rbac(e), addC4ghHash)                      // Adds a key hash to the database
-	r.GET("/c4gh-keys/list", rbac(e), listC4ghHashes)                   // Lists key hashes in the database
-	r.POST("/c4gh-keys/deprecate/*keyHash", rbac(e), deprecateC4ghHash) // Deprecate a given key hash
-	r.DELETE("/file/:username/:fileid", rbac(e), deleteFile)            // Delete a file from inbox
+	r.POST("/c4gh-keys/add", audited(audit.EventAdminKeyAdd), rbac(e), addC4ghHash)                            // Adds a key hash to the database
+	r.GET("/c4gh-keys/list", audited(audit.EventAdminList), rbac(e), listC4ghHashes)                           // Lists key hashes in the database
+	r.POST("/c4gh-keys/deprecate/*keyHash", audited(audit.EventAdminKeyDeprecate), rbac(e), deprecateC4ghHash) // Deprecate a given key hash
+	r.DELETE("/file/:username/:fileid", audited(audit.EventAdminFileDelete), rbac(e), deleteFile)              // Delete a file from inbox
+	r.GET("/messages/parked", audited(audit.EventAdminList), rbac(e), listParkedMessages)                      // Lists messages which ran out of retry attempts
+	r.POST("/messages/parked/replay", audited(audit.EventAdminMessagesReplay), rbac(e), replayParkedMessages)  // Replays parked messages to their queues
 	// submission endpoints below here
-	r.POST("/file/ingest", rbac(e), ingestFile)                      // start ingestion of a file
-	r.POST("/file/accession", rbac(e), setAccession)                 // assign accession ID to a file
-	r.PUT("/file/verify/:accession", rbac(e), reVerifyFile)          // trigger reverification of a file
-	r.POST("/file/rotatekey/:fileid", rbac(e), rotateKeyFile)        // trigger key rotation for a file
-	r.POST("/dataset/create", rbac(e), createDataset)                // maps a set of files to a dataset
-	r.POST("/dataset/rotatekey/:dataset", rbac(e), rotateKeyDataset) // trigger key rotation for all files in a dataset
-	r.POST("/dataset/release/*dataset", rbac(e), releaseDataset)     // Releases a dataset to be accessible
-	r.PUT("/dataset/verify/*dataset", rbac(e), reVerifyDataset)      // Re-verify all files in the dataset
-	r.GET("/datasets/list", rbac(e), listAllDatasets)                // Lists all datasets with their status
-	r.GET("/datasets/list/:username", rbac(e), listUserDatasets)     // Lists datasets with their status for a specific user
-	r.GET("/users", rbac(e), listActiveUsers)                        // Lists all users
-	r.GET("/users/:username/files", rbac(e), listUserFiles)          // Lists all unmapped files for a user
-	r.GET("/users/:username/file/:fileid", rbac(e), downloadFile)    // Download a file from a users inbox
+	r.POST("/file/ingest", audited(audit.EventAdminFileIngest), rbac(e), ingestFile)                         // start ingestion of a file
+	r.POST("/file/accession", audited(audit.EventAdminFileAccession), rbac(e), setAccession)                 // assign accession ID to a file
+	r.PUT("/file/verify/:accession", audited(audit.EventAdminFileVerify), rbac(e), reVerifyFile)             // trigger reverification of a file
+	r.POST("/file/rotatekey/:fileid", audited(audit.EventAdminFileRotateKey), rbac(e), rotateKeyFile)        // trigger key rotation for a file
+	r.POST("/dataset/create", audited(audit.EventAdminDatasetCreate), rbac(e), createDataset)                // maps a set of files to a dataset
+	r.POST("/dataset/rotatekey/:dataset", audited(audit.EventAdminDatasetRotate), rbac(e), rotateKeyDataset) // trigger key rotation for all files in a dataset
+	r.POST("/dataset/release/*dataset", audited(audit.EventAdminDatasetRelease), rbac(e), releaseDataset)    // Releases a dataset to be accessible
+	r.PUT("/dataset/verify/*dataset", audited(audit.EventAdminDatasetVerify), rbac(e), reVerifyDataset)      // Re-verify all files in the dataset
+	r.GET("/datasets/list", audited(audit.EventAdminList), rbac(e), listAllDatasets)                         // Lists all datasets with their status
+	r.GET("/datasets/list/:username", audited(audit.EventAdminList), rbac(e), listUserDatasets)              // Lists datasets with their status for a specific user
+	r.GET("/users", audited(audit.EventAdminList), rbac(e), listActiveUsers)                                 // Lists all users
+	r.GET("/users/:username/files", audited(audit.EventAdminList), rbac(e), listUserFiles)                   // Lists all unmapped files for a user
+	r.GET("/users/:username/file/:fileid", audited(audit.EventAdminFileDownload), rbac(e), downloadFile)     // Download a file from a users inbox
+	r.GET("/file/:fileid/history", audited(audit.EventAdminList), rbac(e), fileHistory)                      // Lists the events of a file
+	r.GET("/dataset/history/*dataset", audited(audit.EventAdminList), rbac(e), datasetHistory)               // Lists the events of a dataset
+	r.GET("/admin/pipeline/status", audited(audit.EventAdminList), rbac(e), getPipelineStatus)               // Counts files per state and lists stuck files and queue depths
+	r.GET("/quotas", audited(audit.EventAdminList), rbac(e), listQuotas)                                     // Lists the inbox quotas with their usage
+	r.GET("/quotas/:type/:name", audited(audit.EventAdminList), rbac(e), getQuota)                           // Shows the inbox quota of a user or group with its usage
+	r.PUT("/quotas/:type/:name", audited(audit.EventAdminQuotaSet), rbac(e), setQuota)                       // Sets the inbox quota of a user or group
+	r.DELETE("/quotas/:type/:name", audited(audit.EventAdminQuotaDelete), rbac(e), deleteQuota)              // Removes the inbox quota of a user or group
 
 	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
 
@@ -289,59 +322,67 @@ func checkDB(ctx context.Context, db *database.SDAdb, timeout time.Duration) err
 	return db.DB.PingContext(ctx)
 }
 
-func auditLog(auditFields log.Fields) {
-	auditFields["audit"] = true
-	Conf.API.AuditLogger.WithFields(auditFields).Info("Incoming audit event")
+// audited records an audit event with the actor, target and outcome of the
+// request once it has been handled. Targets given by the route are recorded
+// here, handlers add those only known from the request body with auditEvent.
+func audited(name audit.EventName) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event := &audit.Event{
+			Event:         name,
+			CorrelationID: uuid.NewString(),
+			Path:          c.Request.URL.Path,
+			FileID:        strings.TrimPrefix(c.Param("fileid"), "/"),
+			DatasetID:     strings.TrimPrefix(c.Param("dataset"), "/"),
+			TargetUser:    strings.TrimPrefix(c.Param("username"), "/"),
+		}
+		c.Set(auditEventKey, event)
+
+		c.Next()
+
+		event.UserID = c.GetString(actorKey)
+		event.HTTPStatus = c.Writer.Status()
+		auditLogger.Log(c.Request.Context(), *event)
+	}
+}
+
+// auditEvent returns the audit event of the request. Requests which are not
+// audited get an event which is discarded.
+func auditEvent(c *gin.Context) *audit.Event {
+	if event, ok := c.Get(auditEventKey); ok {
+		if e, ok := event.(*audit.Event); ok {
+			return e
+		}
+	}
+
+	return &audit.Event{}
 }
 
 func rbac(e *casbin.Enforcer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, err := auth.Authenticate(c.Request)
 		if err != nil {
-			if Conf.API.AuditLogger != nil {
-				auditLog(log.Fields{
-					"authentication error": err.Error(),
-					"path":                 c.Request.URL.Path,
-				})
-			}
+			auditEvent(c).ErrorReason = "authentication failed: " + err.Error()
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 
 			return
 		}
+		c.Set(actorKey, token.Subject())
 
 		ok, err := e.Enforce(token.Subject(), c.Request.URL.Path, c.Request.Method)
 		if err != nil {
-			if Conf.API.AuditLogger != nil {
-				auditLog(log.Fields{
-					"authorization": "error (err.Error())",
-					"user":          token.Subject(),
-					"path":          c.Request.URL.Path,
-				})
-			}
+			auditEvent(c).ErrorReason = "authorization failed: " + err.Error()
 			log.Debugf("rbac enforcement failed, reason: %s\n", err.Error())
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 
 			return
 		}
 		if !ok {
-			if Conf.API.AuditLogger != nil {
-				auditLog(log.Fields{
-					"authorization": "failed",
-					"user":          token.Subject(),
-					"path":          c.Request.URL.Path,
-				})
-			}
+			auditEvent(c).ErrorReason = "not authorized"
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
 
 			return
 		}
 
-		if Conf.API.AuditLogger != nil {
-			auditLog(log.Fields{
-				"user": token.Subject(),
-				"path": c.Request.URL.Path,
-			})
-		}
 		log.Debugln("authorized")
 	}
 }
@@ -434,6 +475,11 @@ func ingestFile(c *gin.Context) {
 
 		return
 	}
+	event := auditEvent(c)
+	event.FileID = fileID
+	event.TargetUser = ingest.User
+	event.Target = ingest.FilePath
+
 	// Add type in message payload
 	ingest.Type = "ingest"
 
@@ -485,6 +531,8 @@ func deleteFile(c *gin.Context) {
 		return
 	}
 
+	auditEvent(c).Target = filePath
+
 	filePath = helper.UnanonymizeFilepath(filePath, submissionUser)
 	for count := 1; count <= 5; count++ {
 		err = inboxWriter.RemoveFile(c, location, filePath)
@@ -577,6 +625,7 @@ func downloadFile(c *gin.Context) {
 
 		return
 	}
+	auditEvent(c).Target = filePath
 
 	// Get inbox file handle #noqa
 	file, err := inboxReader.NewFileReader(c, location,
@@ -617,7 +666,8 @@ func downloadFile(c *gin.Context) {
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(filePath)))
 
 	reader := io.MultiReader(bytes.NewReader(newHeader), file)
-	_, err = io.Copy(c.Writer, reader)
+	n, err := io.Copy(c.Writer, reader)
+	auditEvent(c).BytesTransferred = n
 	if err != nil {
 		log.Errorf("error occurred while sending stream, reason: %v", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to stream data to client")
@@ -718,6 +768,11 @@ func setAccession(c *gin.Context) {
 
 		return
 	}
+	event := auditEvent(c)
+	event.FileID = fileID
+	event.TargetUser = accession.User
+	event.Target = accession.AccessionID
+
 	// Add type in the message payload
 	accession.Type = "accession"
 
@@ -754,6 +809,10 @@ func createDataset(c *gin.Context) {
 		return
 	}
 
+	event := auditEvent(c)
+	event.DatasetID = dataset.DatasetID
+	event.TargetUser = dataset.User
+
 	if len(dataset.AccessionIDs) == 0 {
 		c.AbortWithStatusJSON(http.StatusBadRequest, "at least one accessionID is required")
 
@@ -1002,6 +1061,112 @@ func listUserFiles(c *gin.Context) {
 	c.JSON(200, files)
 }
 
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// parseEventFilter reads the event, since, until, limit and offset query parameters of a history request
+func parseEventFilter(c *gin.Context) (database.EventFilter, error) {
+	filter := database.EventFilter{Event: c.Query("event"), Limit: defaultHistoryLimit}
+
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("since must be an RFC3339 timestamp: %v", err)
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("until must be an RFC3339 timestamp: %v", err)
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 || filter.Limit > maxHistoryLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			return filter, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	return filter, nil
+}
+
+// historyPage is the response of the history endpoints, nextOffset is set when there are more events
+func historyPage[T any](events []T, filter database.EventFilter, more bool) gin.H {
+	if events == nil {
+		events = []T{}
+	}
+	page := gin.H{"events": events}
+	if more {
+		page["nextOffset"] = filter.Offset + filter.Limit
+	}
+
+	return page
+}
+
+// fileHistory returns the event log of a file, oldest event first
+func fileHistory(c *gin.Context) {
+	fileID := c.Param("fileid")
+	if _, err := uuid.Parse(fileID); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "file ID not a proper UUID")
+
+		return
+	}
+
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	events, more, err := Conf.API.DB.GetFileHistory(c.Request.Context(), fileID, filter)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.AbortWithStatusJSON(http.StatusNotFound, "file not found")
+
+		return
+	case err != nil:
+		log.Errorf("failed to get history of file %s, reason: %v", fileID, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get file history")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, historyPage(events, filter, more))
+}
+
+// datasetHistory returns the event log of a dataset, oldest event first
+func datasetHistory(c *gin.Context) {
+	datasetID := strings.TrimPrefix(c.Param("dataset"), "/")
+
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	events, more, err := Conf.API.DB.GetDatasetHistory(c.Request.Context(), datasetID, filter)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.AbortWithStatusJSON(http.StatusNotFound, "dataset not found")
+
+		return
+	case err != nil:
+		log.Errorf("failed to get history of dataset %s, reason: %v", datasetID, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get dataset history")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, historyPage(events, filter, more))
+}
+
 // addC4ghHash handles the addition of a hashed public key to the database.
 // It expects a JSON payload containing the base64 encoded public key and its description.
 // If the JSON payload is invalid, it responds with a 400 Bad Request status.
@@ -1054,6 +1219,7 @@ func addC4ghHash(c *gin.Context) {
 		return
 	}
 
+	auditEvent(c).Target = hex.EncodeToString(pubKey[:])
 	err = Conf.API.DB.AddKeyHash(hex.EncodeToString(pubKey[:]), c4gh.Description)
 	if err != nil {
 		if strings.Contains(err.Error(), "key hash already exists") {
@@ -1105,6 +1271,7 @@ func listC4ghHashes(c *gin.Context) {
 
 func deprecateC4ghHash(c *gin.Context) {
 	keyHash := strings.TrimPrefix(c.Param("keyHash"), "/")
+	auditEvent(c).Target = keyHash
 	err = Conf.API.DB.DeprecateKeyHash(keyHash)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
@@ -1186,6 +1353,7 @@ func reVerify(c *gin.Context, accessionID string) (*gin.Context, error) {
 
 func reVerifyFile(c *gin.Context) {
 	accessionID := strings.TrimPrefix(c.Param("accession"), "/")
+	auditEvent(c).Target = accessionID
 	c, err = reVerify(c, accessionID)
 	if err != nil {
 		return
@@ -1217,3 +1385,240 @@ func reVerifyDataset(c *gin.Context) {
 
 	c.Status(http.StatusOK)
 }
+
+func listParkedMessages(c *gin.Context) {
+	parked, err := Conf.API.MQ.ParkedMessages()
+	if err != nil {
+		log.Errorf("failed to list parked messages, reason: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to list parked messages")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, parked)
+}
+
+func replayParkedMessages(c *gin.Context) {
+	var request struct {
+		CorrelationIDs []string `json:"correlation_ids"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "json decoding : " + err.Error(), "status": http.StatusBadRequest})
+
+		return
+	}
+	if len(request.CorrelationIDs) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "correlation_ids is required")
+
+		return
+	}
+	auditEvent(c).Target = strings.Join(request.CorrelationIDs, ",")
+
+	replayed, err := Conf.API.MQ.ReplayParked(func(m broker.ParkedMessage) bool {
+		return slices.Contains(request.CorrelationIDs, m.CorrelationID)
+	})
+	if err != nil {
+		log.Errorf("failed to replay parked messages, reason: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to replay parked messages", "replayed": replayed})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": replayed})
+}
+
+// pipelineStates are the file events after which a file waits for the next pipeline step, in pipeline order
+var pipelineStates = []string{"uploaded", "submitted", "archived", "verified"}
+
+type stuckFiles struct {
+	StuckAfter string                `json:"stuckAfter"`
+	Files      []*database.StuckFile `json:"files"`
+}
+
+type pipelineStatus struct {
+	Files  map[string]int        `json:"files"`
+	Stuck  map[string]stuckFiles `json:"stuck"`
+	Queues []broker.QueueStatus  `json:"queues"`
+}
+
+// getPipelineStatus returns the number of files per state, the files that have been in a state for longer than
+// configured and the depth of the broker queues
+func getPipelineStatus(c *gin.Context) {
+	counts, err := Conf.API.DB.GetFileStatusCounts(c.Request.Context())
+	if err != nil {
+		log.Errorf("failed to count files per state, reason: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get pipeline status")
+
+		return
+	}
+
+	status := pipelineStatus{Files: counts, Stuck: make(map[string]stuckFiles)}
+	for _, state := range pipelineStates {
+		stuckAfter := Conf.API.Pipeline.StuckAfter[state]
+		if stuckAfter == 0 {
+			continue
+		}
+
+		files, err := Conf.API.DB.GetStuckFiles(c.Request.Context(), state, time.Now().Add(-stuckAfter), Conf.API.Pipeline.MaxStuckFiles)
+		if err != nil {
+			log.Errorf("failed to get files stuck in %s, reason: %v", state, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get pipeline status")
+
+			return
+		}
+		if files == nil {
+			files = []*database.StuckFile{}
+		}
+		status.Stuck[state] = stuckFiles{StuckAfter: stuckAfter.String(), Files: files}
+	}
+
+	status.Queues = Conf.API.MQ.QueueStatus(Conf.API.Pipeline.Queues)
+
+	c.JSON(http.StatusOK, status)
+}
+
+// inboxQuota is an inbox quota together with the current usage of the user or group
+type inboxQuota struct {
+	database.InboxQuota
+	Usage database.InboxUsage `json:"usage"`
+}
+
+// quotaLimits are the limits of an inbox quota, a null limit is unlimited
+type quotaLimits struct {
+	MaxBytes   *int64 `json:"maxBytes"`
+	MaxObjects *int64 `json:"maxObjects"`
+}
+
+// quotaSubject returns the subject type and name of the quota in the path, or false if the type is not user or group
+func quotaSubject(c *gin.Context) (string, string, bool) {
+	subjectType, name := c.Param("type"), c.Param("name")
+	auditEvent(c).Target = subjectType + "/" + name
+	if subjectType != "user" && subjectType != "group" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "quota type must be user or group")
+
+		return "", "", false
+	}
+
+	return subjectType, name, true
+}
+
+func withUsage(c *gin.Context, quota *database.InboxQuota) (inboxQuota, error) {
+	usage, err := Conf.API.DB.GetInboxUsage(c.Request.Context(), quota.SubjectType, quota.Subject, "")
+	if err != nil {
+		return inboxQuota{}, err
+	}
+
+	return inboxQuota{InboxQuota: *quota, Usage: usage}, nil
+}
+
+func listQuotas(c *gin.Context) {
+	quotas, err := Conf.API.DB.ListInboxQuotas(c.Request.Context())
+	if err != nil {
+		log.Errorf("failed to list inbox quotas, reason: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to list quotas")
+
+		return
+	}
+
+	response := []inboxQuota{}
+	for _, quota := range quotas {
+		q, err := withUsage(c, quota)
+		if err != nil {
+			log.Errorf("failed to get inbox usage of %s %s, reason: %v", quota.SubjectType, quota.Subject, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to list quotas")
+
+			return
+		}
+		response = append(response, q)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func getQuota(c *gin.Context) {
+	subjectType, name, ok := quotaSubject(c)
+	if !ok {
+		return
+	}
+
+	quota, err := Conf.API.DB.GetInboxQuota(c.Request.Context(), subjectType, name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.AbortWithStatusJSON(http.StatusNotFound, "quota not found")
+
+		return
+	case err != nil:
+		log.Errorf("failed to get inbox quota of %s %s, reason: %v", subjectType, name, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get quota")
+
+		return
+	}
+
+	response, err := withUsage(c, quota)
+	if err != nil {
+		log.Errorf("failed to get inbox usage of %s %s, reason: %v", subjectType, name, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to get quota")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func setQuota(c *gin.Context) {
+	subjectType, name, ok := quotaSubject(c)
+	if !ok {
+		return
+	}
+
+	var limits quotaLimits
+	if err := c.BindJSON(&limits); err != nil {
+		c.AbortWithStatusJSON(
+			http.StatusBadRequest,
+			gin.H{
+				"error":  "json decoding : " + err.Error(),
+				"status": http.StatusBadRequest,
+			},
+		)
+
+		return
+	}
+
+	if (limits.MaxBytes != nil && *limits.MaxBytes < 0) || (limits.MaxObjects != nil && *limits.MaxObjects < 0) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "quota limits can not be negative")
+
+		return
+	}
+
+	quota := database.InboxQuota{SubjectType: subjectType, Subject: name, MaxBytes: limits.MaxBytes, MaxObjects: limits.MaxObjects}
+	if err := Conf.API.DB.SetInboxQuota(c.Request.Context(), quota); err != nil {
+		log.Errorf("failed to set inbox quota of %s %s, reason: %v", subjectType, name, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to set quota")
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func deleteQuota(c *gin.Context) {
+	subjectType, name, ok := quotaSubject(c)
+	if !ok {
+		return
+	}
+
+	err := Conf.API.DB.DeleteInboxQuota(c.Request.Context(), subjectType, name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.AbortWithStatusJSON(http.StatusNotFound, "quota not found")
+
+		return
+	case err != nil:
+		log.Errorf("failed to delete inbox quota of %s %s, reason: %v", subjectType, name, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to delete quota")
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
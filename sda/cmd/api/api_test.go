@@ -26,9 +26,11 @@ import (
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -451,6 +453,11 @@ func (s *TestSuite) SetupSuite() {
 	{"role":"submission","path":"/users","action":"GET"},
 	{"role":"submission","path":"/users/:username/files","action":"GET"},
 	{"role":"submission","path":"/users/:username/file/:fileid","action":"GET"},
+	{"role":"admin","path":"/file/:fileid/history","action":"GET"},
+	{"role":"admin","path":"/dataset/history/*dataset","action":"GET"},
+	{"role":"admin","path":"/admin/pipeline/status","action":"GET"},
+	{"role":"admin","path":"/quotas","action":"GET"},
+	{"role":"admin","path":"/quotas/:type/:name","action":"(GET)|(PUT)|(DELETE)"},
 	{"role":"*","path":"/files","action":"GET"}],
 	"roles":[{"role":"admin","rolebinding":"submission"},
 	{"role":"dummy","rolebinding":"admin"}]}`)
@@ -1074,6 +1081,67 @@ func (s *TestSuite) TestRBAC_badUser() {
 	assert.Equal(s.T(), http.StatusUnauthorized, okResponse.StatusCode)
 }
 
+// capturingLogger records audit events for test assertions.
+type capturingLogger struct {
+	events []audit.Event
+}
+
+func (l *capturingLogger) Log(_ context.Context, event audit.Event) {
+	l.events = append(l.events, event)
+}
+
+func (s *TestSuite) TestAudited() {
+	gin.SetMode(gin.ReleaseMode)
+	assert.NoError(s.T(), setupJwtAuth())
+	Conf.API.RBACpolicy = []byte(`{"policy":[{"role":"admin","path":"/dataset/*","action":"(GET)|(POST)|(PUT)"}],
+	"roles":[{"role":"admin","rolebinding":"dummy"}]}`)
+	m, err := model.NewModelFromString(jsonadapter.Model)
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC model")
+	}
+	e, err := casbin.NewEnforcer(m, jsonadapter.NewAdapter(&Conf.API.RBACpolicy))
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC enforcer")
+	}
+
+	logger := &capturingLogger{}
+	auditLogger = logger
+	defer func() { auditLogger = audit.NoopLogger{} }()
+
+	_, router := gin.CreateTestContext(httptest.NewRecorder())
+	router.POST("/dataset/release/*dataset", audited(audit.EventAdminDatasetRelease), rbac(e), testEndpoint)
+	router.GET("/users", audited(audit.EventAdminList), rbac(e), testEndpoint)
+
+	// Authorized requests are audited with the actor and the target
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/dataset/release/API:dataset-01", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	assert.Len(s.T(), logger.events, 1)
+	assert.Equal(s.T(), audit.EventAdminDatasetRelease, logger.events[0].Event)
+	assert.Equal(s.T(), "dummy", logger.events[0].UserID)
+	assert.Equal(s.T(), "API:dataset-01", logger.events[0].DatasetID)
+	assert.Equal(s.T(), http.StatusOK, logger.events[0].HTTPStatus)
+
+	// Requests rejected by the policy are audited as denied
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/users", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusUnauthorized, res.StatusCode)
+	assert.Len(s.T(), logger.events, 2)
+	assert.Equal(s.T(), "dummy", logger.events[1].UserID)
+	assert.Equal(s.T(), "not authorized", logger.events[1].ErrorReason)
+	assert.Equal(s.T(), http.StatusUnauthorized, logger.events[1].HTTPStatus)
+}
+
 func (s *TestSuite) TestRBAC_noToken() {
 	gin.SetMode(gin.ReleaseMode)
 	assert.NoError(s.T(), setupJwtAuth())
@@ -3121,3 +3189,329 @@ func (s *TestSuite) TestReencryptHeader_failedToConnect() {
 	assert.Equal(s.T(), newHeader, []uint8([]byte(nil)), "expected header to be nil")
 	assert.ErrorContains(s.T(), err, "connection refused")
 }
+
+func (s *TestSuite) TestParkedMessages() {
+	for _, queue := range []string{broker.ParkingLot, "TestParkedMessages"} {
+		_, err := Conf.API.MQ.Channel.QueueDeclare(queue, true, false, false, false, nil)
+		assert.NoError(s.T(), err)
+	}
+	parked, _ := json.Marshal(broker.ParkedMessage{
+		InfoError:     broker.InfoError{Error: "message could not be handled in 5 attempts", Reason: "database unavailable", OriginalMessage: `{"parked":true}`},
+		CorrelationID: "parked-message",
+		Queue:         "TestParkedMessages",
+		Attempts:      5,
+	})
+	assert.NoError(s.T(), Conf.API.MQ.SendMessage("parked-message", "", broker.ParkingLot, parked))
+
+	gin.SetMode(gin.ReleaseMode)
+	_, router := gin.CreateTestContext(httptest.NewRecorder())
+	router.GET("/messages/parked", listParkedMessages)
+	router.POST("/messages/parked/replay", replayParkedMessages)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/messages/parked", http.NoBody))
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	var listed []broker.ParkedMessage
+	assert.NoError(s.T(), json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(s.T(), listed, 1)
+	assert.Equal(s.T(), "parked-message", listed[0].CorrelationID)
+	assert.Equal(s.T(), "database unavailable", listed[0].Reason)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/messages/parked/replay", strings.NewReader(`{"correlation_ids":[]}`)))
+	assert.Equal(s.T(), http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/messages/parked/replay", strings.NewReader(`{"correlation_ids":["parked-message"]}`)))
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	assert.JSONEq(s.T(), `{"replayed":1}`, w.Body.String())
+
+	replayed, ok, err := Conf.API.MQ.Channel.Get("TestParkedMessages", true)
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), ok, "the parked message should be replayed to its queue")
+	assert.Equal(s.T(), `{"parked":true}`, string(replayed.Body))
+	assert.Equal(s.T(), "parked-message", replayed.CorrelationId)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/messages/parked", http.NoBody))
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	assert.JSONEq(s.T(), `[]`, w.Body.String())
+}
+
+func (s *TestSuite) TestFileHistory() {
+	fileID, err := Conf.API.DB.RegisterFile(nil, s.inboxDir, "/history/file.c4gh", s.User)
+	assert.NoError(s.T(), err, "failed to register file in database")
+	assert.NoError(s.T(), Conf.API.DB.UpdateFileEventLog(fileID, "uploaded", s.User, "{}", "{}"))
+	assert.NoError(s.T(), Conf.API.DB.UpdateFileEventLog(fileID, "submitted", s.User, "{}", "{\"type\":\"ingest\"}"))
+
+	gin.SetMode(gin.ReleaseMode)
+	assert.NoError(s.T(), setupJwtAuth())
+	m, err := model.NewModelFromString(jsonadapter.Model)
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC model")
+	}
+	e, err := casbin.NewEnforcer(m, jsonadapter.NewAdapter(&s.RBAC))
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC enforcer")
+	}
+
+	_, router := gin.CreateTestContext(httptest.NewRecorder())
+	router.GET("/file/:fileid/history", rbac(e), fileHistory)
+
+	type historyResponse struct {
+		Events     []database.FileEvent `json:"events"`
+		NextOffset int                  `json:"nextOffset"`
+	}
+
+	// First page
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/file/"+fileID+"/history?limit=2", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	var page historyResponse
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&page))
+	assert.Len(s.T(), page.Events, 2)
+	assert.Equal(s.T(), "registered", page.Events[0].Event)
+	assert.Equal(s.T(), 2, page.NextOffset)
+
+	// Filtered by event
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/file/"+fileID+"/history?event=submitted", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	page = historyResponse{}
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&page))
+	assert.Len(s.T(), page.Events, 1)
+	assert.Equal(s.T(), s.User, page.Events[0].User)
+	assert.JSONEq(s.T(), `{"type":"ingest"}`, string(page.Events[0].Message))
+	assert.Zero(s.T(), page.NextOffset)
+
+	// Bad requests
+	for _, query := range []string{"/file/not-a-uuid/history", "/file/" + fileID + "/history?limit=0", "/file/" + fileID + "/history?since=yesterday"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, query, http.NoBody)
+		r.Header.Add("Authorization", "Bearer "+s.Token)
+		router.ServeHTTP(w, r)
+		res = w.Result()
+		defer res.Body.Close()
+		assert.Equal(s.T(), http.StatusBadRequest, res.StatusCode, query)
+	}
+
+	// Unknown file
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/file/"+uuid.NewString()+"/history", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusNotFound, res.StatusCode)
+}
+
+func (s *TestSuite) TestDatasetHistory() {
+	fileID, err := Conf.API.DB.RegisterFile(nil, s.inboxDir, "/history/dataset-file.c4gh", s.User)
+	assert.NoError(s.T(), err, "failed to register file in database")
+	assert.NoError(s.T(), Conf.API.DB.SetAccessionID("accession-history-01", fileID))
+	assert.NoError(s.T(), Conf.API.DB.MapFilesToDataset("dataset-history-01", []string{"accession-history-01"}))
+	assert.NoError(s.T(), Conf.API.DB.UpdateDatasetEvent("dataset-history-01", "registered", "{\"type\": \"mapping\"}"))
+	assert.NoError(s.T(), Conf.API.DB.UpdateDatasetEvent("dataset-history-01", "released", "{\"type\": \"release\"}"))
+
+	gin.SetMode(gin.ReleaseMode)
+	assert.NoError(s.T(), setupJwtAuth())
+	m, err := model.NewModelFromString(jsonadapter.Model)
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC model")
+	}
+	e, err := casbin.NewEnforcer(m, jsonadapter.NewAdapter(&s.RBAC))
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC enforcer")
+	}
+
+	_, router := gin.CreateTestContext(httptest.NewRecorder())
+	router.GET("/dataset/history/*dataset", rbac(e), datasetHistory)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/dataset/history/dataset-history-01", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	var page struct {
+		Events []database.DatasetEvent `json:"events"`
+	}
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&page))
+	assert.Len(s.T(), page.Events, 2)
+	assert.Equal(s.T(), "registered", page.Events[0].Event)
+	assert.Equal(s.T(), "released", page.Events[1].Event)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/dataset/history/no-such-dataset", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusNotFound, res.StatusCode)
+
+	// Dataset IDs can be DOIs, which contain slashes
+	datasetID := "https://doi.example.org/10.1234/dataset-history-02"
+	fileID, err = Conf.API.DB.RegisterFile(nil, s.inboxDir, "/history/doi-file.c4gh", s.User)
+	assert.NoError(s.T(), err, "failed to register file in database")
+	assert.NoError(s.T(), Conf.API.DB.SetAccessionID("accession-history-02", fileID))
+	assert.NoError(s.T(), Conf.API.DB.MapFilesToDataset(datasetID, []string{"accession-history-02"}))
+	assert.NoError(s.T(), Conf.API.DB.UpdateDatasetEvent(datasetID, "registered", "{\"type\": \"mapping\"}"))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/dataset/history/"+datasetID, http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	router.ServeHTTP(w, r)
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&page))
+	assert.Len(s.T(), page.Events, 1)
+	assert.Equal(s.T(), "registered", page.Events[0].Event)
+}
+
+func (s *TestSuite) TestPipelineStatus() {
+	_, err := Conf.API.MQ.Channel.QueueDeclare("TestPipelineStatus", true, false, false, false, nil)
+	assert.NoError(s.T(), err)
+
+	fileID, err := Conf.API.DB.RegisterFile(nil, s.inboxDir, "/pipeline/file.c4gh", s.User)
+	assert.NoError(s.T(), err, "failed to register file in database")
+	assert.NoError(s.T(), Conf.API.DB.UpdateFileEventLog(fileID, "submitted", s.User, "{}", "{}"))
+
+	Conf.API.Pipeline = config.PipelineStatusConf{
+		StuckAfter:    map[string]time.Duration{"submitted": time.Nanosecond},
+		MaxStuckFiles: 1000,
+		Queues:        []string{"TestPipelineStatus", "no-such-queue"},
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	assert.NoError(s.T(), setupJwtAuth())
+	m, err := model.NewModelFromString(jsonadapter.Model)
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC model")
+	}
+	e, err := casbin.NewEnforcer(m, jsonadapter.NewAdapter(&s.RBAC))
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC enforcer")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/pipeline/status", http.NoBody)
+	r.Header.Add("Authorization", "Bearer "+s.Token)
+	_, router := gin.CreateTestContext(w)
+	router.GET("/admin/pipeline/status", rbac(e), getPipelineStatus)
+	router.ServeHTTP(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+
+	var status pipelineStatus
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&status))
+	assert.GreaterOrEqual(s.T(), status.Files["submitted"], 1)
+
+	// Only states with a threshold are checked for stuck files
+	assert.NotContains(s.T(), status.Stuck, "uploaded")
+	assert.Equal(s.T(), "1ns", status.Stuck["submitted"].StuckAfter)
+	found := false
+	for _, file := range status.Stuck["submitted"].Files {
+		if file.FileID == fileID {
+			found = true
+		}
+	}
+	assert.True(s.T(), found, "submitted file not reported as stuck")
+
+	assert.Len(s.T(), status.Queues, 2)
+	assert.Equal(s.T(), "TestPipelineStatus", status.Queues[0].Name)
+	assert.Empty(s.T(), status.Queues[0].Error)
+	assert.NotEmpty(s.T(), status.Queues[1].Error)
+}
+
+func (s *TestSuite) TestInboxQuotas() {
+	fileID, err := Conf.API.DB.RegisterFile(nil, s.inboxDir, "/quota/file.c4gh", "quota-api-user")
+	assert.NoError(s.T(), err, "failed to register file in database")
+	assert.NoError(s.T(), Conf.API.DB.SetSubmissionFileSize(fileID, 42))
+
+	gin.SetMode(gin.ReleaseMode)
+	assert.NoError(s.T(), setupJwtAuth())
+	m, err := model.NewModelFromString(jsonadapter.Model)
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC model")
+	}
+	e, err := casbin.NewEnforcer(m, jsonadapter.NewAdapter(&s.RBAC))
+	if err != nil {
+		s.T().Logf("failure: %v", err)
+		s.FailNow("failed to setup RBAC enforcer")
+	}
+
+	_, router := gin.CreateTestContext(httptest.NewRecorder())
+	router.GET("/quotas", rbac(e), listQuotas)
+	router.GET("/quotas/:type/:name", rbac(e), getQuota)
+	router.PUT("/quotas/:type/:name", rbac(e), setQuota)
+	router.DELETE("/quotas/:type/:name", rbac(e), deleteQuota)
+
+	request := func(method, path, body string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Authorization", "Bearer "+s.Token)
+		router.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	res := request(http.MethodPut, "/quotas/user/quota-api-user", `{"maxBytes": 1000, "maxObjects": null}`)
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+
+	res = request(http.MethodGet, "/quotas/user/quota-api-user", "")
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	var quota inboxQuota
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&quota))
+	assert.Equal(s.T(), int64(1000), *quota.MaxBytes)
+	assert.Nil(s.T(), quota.MaxObjects)
+	assert.Equal(s.T(), database.InboxUsage{Bytes: 42, Objects: 1}, quota.Usage)
+
+	res = request(http.MethodGet, "/quotas", "")
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+	var quotas []inboxQuota
+	assert.NoError(s.T(), json.NewDecoder(res.Body).Decode(&quotas))
+	assert.Contains(s.T(), quotas, quota)
+
+	for path, body := range map[string]string{
+		"/quotas/role/quota-api-user":   `{"maxBytes": 1000}`,
+		"/quotas/user/quota-api-user":   `{"maxBytes": -1}`,
+		"/quotas/group/quota-api-group": `{"maxBytes": "lots"}`,
+	} {
+		res = request(http.MethodPut, path, body)
+		res.Body.Close()
+		assert.Equal(s.T(), http.StatusBadRequest, res.StatusCode, path)
+	}
+
+	res = request(http.MethodDelete, "/quotas/user/quota-api-user", "")
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusOK, res.StatusCode)
+
+	res = request(http.MethodDelete, "/quotas/user/quota-api-user", "")
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusNotFound, res.StatusCode)
+
+	res = request(http.MethodGet, "/quotas/user/quota-api-user", "")
+	defer res.Body.Close()
+	assert.Equal(s.T(), http.StatusNotFound, res.StatusCode)
+}
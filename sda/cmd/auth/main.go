@@ -17,6 +17,7 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 )
@@ -112,7 +113,7 @@ func (auth AuthHandler) postEGA(ctx iris.Context) {
 	username := userform["username"][0]
 	password := userform["password"][0]
 
-	res, err := authenticateWithCEGA(auth.Config.Cega, username)
+	res, err := userauth.AuthenticateWithCEGA(auth.Config.Cega, username)
 
 	if err != nil {
 		log.Errorf("No response from cega, error: %v", err)
@@ -125,7 +126,7 @@ func (auth AuthHandler) postEGA(ctx iris.Context) {
 
 	switch res.StatusCode {
 	case 200:
-		var ur CegaUserResponse
+		var ur userauth.CegaUserResponse
 		err := json.NewDecoder(res.Body).Decode(&ur)
 
 		if err != nil {
@@ -138,7 +139,7 @@ func (auth AuthHandler) postEGA(ctx iris.Context) {
 
 		hash := ur.PasswordHash
 
-		ok := verifyPassword(password, hash)
+		ok := userauth.VerifyPassword(password, hash)
 
 		if ok {
 			log.WithFields(log.Fields{"authType": "cega", "user": username}).Info("Valid password entered by user")
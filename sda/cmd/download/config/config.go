@@ -9,10 +9,11 @@ import (
 
 var (
 	// Server configuration
-	apiHost       string
-	apiPort       int
-	apiServerCert string
-	apiServerKey  string
+	apiHost         string
+	apiPort         int
+	apiServerCert   string
+	apiServerKey    string
+	apiClientCACert string
 
 	// Service info
 	serviceID      string
@@ -65,6 +66,10 @@ var (
 	// Permission model configuration
 	permissionModel string // "ownership" | "visa" | "combined"
 
+	// Decrypted streaming configuration
+	decryptedEnabled        bool
+	decryptedAllowedClients []string
+
 	// Auth configuration
 	authAllowOpaque bool // Allow userinfo-based auth for opaque tokens
 
@@ -89,7 +94,27 @@ var (
 	appEnvironment string
 
 	// Audit configuration
-	auditRequired bool
+	auditRequired       bool
+	auditSinks          []string
+	auditFilePath       string
+	auditFileMaxSize    int
+	auditFileMaxBackups int
+	auditSyslogNetwork  string
+	auditSyslogAddress  string
+	auditSyslogAppName  string
+	auditAMQPHost       string
+	auditAMQPPort       int
+	auditAMQPUser       string
+	auditAMQPPassword   string
+	auditAMQPVhost      string
+	auditAMQPExchange   string
+	auditAMQPRoutingKey string
+	auditAMQPSSL        bool
+	auditAMQPVerifyPeer bool
+	auditAMQPCACert     string
+	auditAMQPClientCert string
+	auditAMQPClientKey  string
+	auditAMQPServerName string
 
 	// Pagination configuration
 	paginationHMACSecret string
@@ -138,6 +163,16 @@ func init() {
 				apiServerKey = viper.GetString(flagName)
 			},
 		},
+		&config.Flag{
+			Name: "api.client-ca-cert",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Path to CA certificate for verifying client certificates (mTLS)")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				apiClientCACert = viper.GetString(flagName)
+			},
+		},
 		// Service info flags
 		&config.Flag{
 			Name: "service.id",
@@ -485,6 +520,28 @@ func init() {
 			},
 		},
 
+		// Decrypted streaming flags
+		&config.Flag{
+			Name: "decrypted.enabled",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "Enable streaming of decrypted files to trusted internal clients over mTLS")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				decryptedEnabled = viper.GetBool(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "decrypted.allowed-clients",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.StringSlice(flagName, []string{}, "Common names of the client certificates allowed to stream decrypted files")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				decryptedAllowedClients = viper.GetStringSlice(flagName)
+			},
+		},
+
 		// Auth flags
 		&config.Flag{
 			Name: "auth.allow-opaque",
@@ -672,12 +729,212 @@ func init() {
 				auditRequired = viper.GetBool(flagName)
 			},
 		},
+		&config.Flag{
+			Name: "audit.sinks",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.StringSlice(flagName, []string{}, "Audit sinks to write events to: stdout, file, syslog and/or amqp")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditSinks = viper.GetStringSlice(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.file.path",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Path of the audit log file")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditFilePath = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.file.max-size",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 100, "Size in MB at which the audit log file is rotated (0 disables rotation)")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditFileMaxSize = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.file.max-backups",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 10, "Number of rotated audit log files to keep")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditFileMaxBackups = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.syslog.network",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "udp", "Network of the syslog server: udp, tcp, unix or unixgram")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditSyslogNetwork = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.syslog.address",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Address of the syslog server (empty uses the local syslog socket)")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditSyslogAddress = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.syslog.app-name",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "sda-download", "APP-NAME of the syslog messages")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditSyslogAppName = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.host",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Host of the message broker receiving audit events")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPHost = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.port",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Int(flagName, 5672, "Port of the message broker")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPPort = viper.GetInt(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.user",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Message broker username")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPUser = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.password",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Message broker password")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPPassword = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.vhost",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "/", "Message broker virtual host")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPVhost = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.exchange",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "sda.audit", "Exchange audit events are published to")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPExchange = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.routing-key",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "download.audit", "Routing key of published audit events")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPRoutingKey = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.ssl",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "Connect to the message broker over TLS")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPSSL = viper.GetBool(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.verify-peer",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.Bool(flagName, false, "Present a client certificate to the message broker")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPVerifyPeer = viper.GetBool(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.cacert",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "CA certificate of the message broker")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPCACert = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.client-cert",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Client certificate for the message broker")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPClientCert = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.client-key",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Client key for the message broker")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPClientKey = viper.GetString(flagName)
+			},
+		},
+		&config.Flag{
+			Name: "audit.amqp.server-name",
+			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
+				flagSet.String(flagName, "", "Server name to verify the message broker certificate against")
+			},
+			Required: false,
+			AssignFunc: func(flagName string) {
+				auditAMQPServerName = viper.GetString(flagName)
+			},
+		},
 
 		// Pagination flags
 		&config.Flag{
 			Name: "pagination.hmac-secret",
 			RegisterFunc: func(flagSet *pflag.FlagSet, flagName string) {
-				flagSet.String(flagName, "", "HMAC secret for signing page tokens (must be same across replicas)")
+				flagSet.String(flagName, "", "HMAC secret for signing page tokens and signed URLs (must be same across replicas)")
 			},
 			Required: false,
 			AssignFunc: func(flagName string) {
@@ -707,6 +964,11 @@ func APIServerKey() string {
 	return apiServerKey
 }
 
+// APIClientCACert returns the path to the CA certificate for verifying client certificates.
+func APIClientCACert() string {
+	return apiClientCACert
+}
+
 // ServiceID returns the GA4GH service-info ID.
 func ServiceID() string {
 	return serviceID
@@ -873,6 +1135,17 @@ func PermissionModel() string {
 	return permissionModel
 }
 
+// DecryptedEnabled returns whether streaming of decrypted files is enabled.
+func DecryptedEnabled() bool {
+	return decryptedEnabled
+}
+
+// DecryptedAllowedClients returns the common names of the client certificates
+// allowed to stream decrypted files.
+func DecryptedAllowedClients() []string {
+	return decryptedAllowedClients
+}
+
 // AuthAllowOpaque returns whether opaque (non-JWT) tokens are allowed via userinfo.
 func AuthAllowOpaque() bool {
 	return authAllowOpaque
@@ -964,6 +1237,106 @@ func AuditRequired() bool {
 	return auditRequired
 }
 
+// AuditSinks returns the names of the configured audit sinks.
+func AuditSinks() []string {
+	return auditSinks
+}
+
+// AuditFilePath returns the path of the audit log file.
+func AuditFilePath() string {
+	return auditFilePath
+}
+
+// AuditFileMaxSize returns the size in MB at which the audit log file is rotated.
+func AuditFileMaxSize() int {
+	return auditFileMaxSize
+}
+
+// AuditFileMaxBackups returns the number of rotated audit log files to keep.
+func AuditFileMaxBackups() int {
+	return auditFileMaxBackups
+}
+
+// AuditSyslogNetwork returns the network of the syslog server.
+func AuditSyslogNetwork() string {
+	return auditSyslogNetwork
+}
+
+// AuditSyslogAddress returns the address of the syslog server.
+func AuditSyslogAddress() string {
+	return auditSyslogAddress
+}
+
+// AuditSyslogAppName returns the APP-NAME of the syslog messages.
+func AuditSyslogAppName() string {
+	return auditSyslogAppName
+}
+
+// AuditAMQPHost returns the host of the audit message broker.
+func AuditAMQPHost() string {
+	return auditAMQPHost
+}
+
+// AuditAMQPPort returns the port of the audit message broker.
+func AuditAMQPPort() int {
+	return auditAMQPPort
+}
+
+// AuditAMQPUser returns the audit message broker username.
+func AuditAMQPUser() string {
+	return auditAMQPUser
+}
+
+// AuditAMQPPassword returns the audit message broker password.
+func AuditAMQPPassword() string {
+	return auditAMQPPassword
+}
+
+// AuditAMQPVhost returns the audit message broker virtual host.
+func AuditAMQPVhost() string {
+	return auditAMQPVhost
+}
+
+// AuditAMQPExchange returns the exchange audit events are published to.
+func AuditAMQPExchange() string {
+	return auditAMQPExchange
+}
+
+// AuditAMQPRoutingKey returns the routing key of published audit events.
+func AuditAMQPRoutingKey() string {
+	return auditAMQPRoutingKey
+}
+
+// AuditAMQPSSL returns whether to connect to the audit message broker over TLS.
+func AuditAMQPSSL() bool {
+	return auditAMQPSSL
+}
+
+// AuditAMQPVerifyPeer returns whether to present a client certificate to the audit message broker.
+func AuditAMQPVerifyPeer() bool {
+	return auditAMQPVerifyPeer
+}
+
+// AuditAMQPCACert returns the CA certificate of the audit message broker.
+func AuditAMQPCACert() string {
+	return auditAMQPCACert
+}
+
+// AuditAMQPClientCert returns the client certificate for the audit message broker.
+func AuditAMQPClientCert() string {
+	return auditAMQPClientCert
+}
+
+// AuditAMQPClientKey returns the client key for the audit message broker.
+func AuditAMQPClientKey() string {
+	return auditAMQPClientKey
+}
+
+// AuditAMQPServerName returns the server name of the audit message broker certificate.
+func AuditAMQPServerName() string {
+	return auditAMQPServerName
+}
+
 // PaginationHMACSecret returns the HMAC secret for signing pagination tokens.
 func PaginationHMACSecret() string {
 	return paginationHMACSecret
@@ -287,6 +287,11 @@ func (c *CachedDB) GetFileChecksums(ctx context.Context, fileID string, source s
 	return c.db.GetFileChecksums(ctx, fileID, source)
 }
 
+// GetDatasetChecksums delegates to the underlying database without caching.
+func (c *CachedDB) GetDatasetChecksums(ctx context.Context, datasetID string, source string) (map[string][]Checksum, error) {
+	return c.db.GetDatasetChecksums(ctx, datasetID, source)
+}
+
 // GetDatasetFilesPaginated delegates to the underlying database without caching.
 // Paginated queries use ephemeral cursors, making caching impractical.
 func (c *CachedDB) GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error) {
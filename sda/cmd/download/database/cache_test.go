@@ -103,6 +103,15 @@ func (m *MockDatabase) GetFileChecksums(ctx context.Context, fileID string, sour
 	return args.Get(0).([]Checksum), args.Error(1)
 }
 
+func (m *MockDatabase) GetDatasetChecksums(ctx context.Context, datasetID string, source string) (map[string][]Checksum, error) {
+	args := m.Called(ctx, datasetID, source)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(map[string][]Checksum), args.Error(1)
+}
+
 func (m *MockDatabase) GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error) {
 	args := m.Called(ctx, datasetID, opts)
 	if args.Get(0) == nil {
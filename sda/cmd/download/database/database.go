@@ -30,6 +30,8 @@ const (
 	getDatasetFilesPageByPathQuery   = "getDatasetFilesPageByPath"
 	getDatasetFilesPageByPrefixQuery = "getDatasetFilesPageByPrefix"
 	getFileChecksumsQuery            = "getFileChecksums"
+	getDatasetChecksumsQuery         = "getDatasetChecksums"
+	getArchivedChecksumQuery         = "getArchivedChecksum"
 )
 
 // paginatedFileBase is the shared SELECT+JOIN+LATERAL block for keyset-paginated
@@ -150,6 +152,23 @@ var queries = map[string]string{
 		INNER JOIN sda.files f ON c.file_id = f.id
 		WHERE f.stable_id = $1 AND c.source = $2`,
 
+	// getDatasetChecksums returns checksums of all files in a dataset filtered by source.
+	getDatasetChecksumsQuery: `
+		SELECT f.stable_id, c.checksum, c.type
+		FROM sda.files f
+		INNER JOIN sda.file_dataset fd ON f.id = fd.file_id
+		INNER JOIN sda.datasets d ON fd.dataset_id = d.id
+		INNER JOIN sda.checksums c ON c.file_id = f.id
+		WHERE d.stable_id = $1 AND c.source = $2
+		  AND f.stable_id IS NOT NULL`,
+
+	// getArchivedChecksum returns the ARCHIVED checksum of a file by its archive location and path.
+	getArchivedChecksumQuery: `
+		SELECT c.type, c.checksum
+		FROM sda.checksums c
+		INNER JOIN sda.files f ON c.file_id = f.id
+		WHERE f.archive_location = $1 AND f.archive_file_path = $2 AND c.source = 'ARCHIVED'`,
+
 	// Keyset-paginated file queries compose from paginatedFileBase (defined below).
 
 	// getDatasetFilesPage returns paginated files in a dataset (no path filter).
@@ -224,6 +243,9 @@ type Database interface {
 	// GetFileChecksums returns checksums for a file filtered by source (e.g., "ARCHIVED", "UNENCRYPTED").
 	GetFileChecksums(ctx context.Context, fileID string, source string) ([]Checksum, error)
 
+	// GetDatasetChecksums returns checksums of all files in a dataset filtered by source, keyed by file ID.
+	GetDatasetChecksums(ctx context.Context, datasetID string, source string) (map[string][]Checksum, error)
+
 	// GetDatasetFilesPaginated returns files in a dataset with keyset cursor pagination.
 	// Files are returned with aggregated checksums. Use FileListOptions to filter and paginate.
 	GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error)
@@ -646,6 +668,49 @@ func (p *PostgresDB) GetFileChecksums(ctx context.Context, fileID string, source
 	return checksums, nil
 }
 
+// GetDatasetChecksums returns checksums of all files in a dataset filtered by source, keyed by file ID.
+func (p *PostgresDB) GetDatasetChecksums(ctx context.Context, datasetID string, source string) (map[string][]Checksum, error) {
+	stmt := p.preparedStatements[getDatasetChecksumsQuery]
+	rows, err := stmt.QueryContext(ctx, datasetID, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dataset checksums: %w", err)
+	}
+	defer rows.Close()
+
+	checksums := make(map[string][]Checksum)
+	for rows.Next() {
+		var fileID string
+		var c Checksum
+		if err := rows.Scan(&fileID, &c.Checksum, &c.Type); err != nil {
+			return nil, fmt.Errorf("failed to scan dataset checksum row: %w", err)
+		}
+		checksums[fileID] = append(checksums[fileID], c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dataset checksum rows: %w", err)
+	}
+
+	return checksums, nil
+}
+
+// GetArchivedChecksum returns the type and value of the ARCHIVED checksum of the file archived at the archive path in
+// the location, used by the storage reader to verify downloaded content. Empty strings are returned if there is none.
+func (p *PostgresDB) GetArchivedChecksum(ctx context.Context, location, archivePath string) (string, string, error) {
+	stmt := p.preparedStatements[getArchivedChecksumQuery]
+
+	var checksumType, checksum string
+	if err := stmt.QueryRowContext(ctx, location, archivePath).Scan(&checksumType, &checksum); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", nil
+		}
+
+		return "", "", fmt.Errorf("failed to query archived checksum: %w", err)
+	}
+
+	return strings.ToLower(checksumType), checksum, nil
+}
+
 // GetDatasetFilesPaginated returns files with keyset cursor pagination and aggregated checksums.
 func (p *PostgresDB) GetDatasetFilesPaginated(ctx context.Context, datasetID string, opts FileListOptions) ([]File, error) {
 	var rows *sql.Rows
@@ -509,6 +509,41 @@ func TestGetFileByPath_NotFound(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestGetArchivedChecksum(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	rows := sqlmock.NewRows([]string{"type", "checksum"}).
+		AddRow("SHA256", "abc123")
+
+	mock.ExpectQuery(queries[getArchivedChecksumQuery]).
+		WithArgs("s3://s3.example.com/archive", "file-1").
+		WillReturnRows(rows)
+
+	checksumType, checksum, err := db.GetArchivedChecksum(context.Background(), "s3://s3.example.com/archive", "file-1")
+
+	require.NoError(t, err)
+	assert.Equal(t, "sha256", checksumType)
+	assert.Equal(t, "abc123", checksum)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetArchivedChecksum_NotFound(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	mock.ExpectQuery(queries[getArchivedChecksumQuery]).
+		WithArgs("/archive", "file-1").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "checksum"}))
+
+	checksumType, checksum, err := db.GetArchivedChecksum(context.Background(), "/archive", "file-1")
+
+	require.NoError(t, err)
+	assert.Empty(t, checksumType)
+	assert.Empty(t, checksum)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestCheckFilePermission_QueryError(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
@@ -609,10 +644,36 @@ func (m *mockTestDatabase) GetFileChecksums(_ context.Context, _ string, _ strin
 	return nil, nil
 }
 
+func (m *mockTestDatabase) GetDatasetChecksums(_ context.Context, _ string, _ string) (map[string][]Checksum, error) {
+	return nil, nil
+}
+
 func (m *mockTestDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, _ FileListOptions) ([]File, error) {
 	return nil, nil
 }
 
+func TestGetDatasetChecksums(t *testing.T) {
+	db, mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	rows := sqlmock.NewRows([]string{"stable_id", "checksum", "type"}).
+		AddRow("file-1", "abc123", "SHA256").
+		AddRow("file-2", "def456", "SHA256")
+
+	mock.ExpectQuery(queries[getDatasetChecksumsQuery]).
+		WithArgs("dataset-1", "ARCHIVED").
+		WillReturnRows(rows)
+
+	checksums, err := db.GetDatasetChecksums(context.Background(), "dataset-1", "ARCHIVED")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]Checksum{
+		"file-1": {{Type: "SHA256", Checksum: "abc123"}},
+		"file-2": {{Type: "SHA256", Checksum: "def456"}},
+	}, checksums)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGetDatasetFilesPaginated_NoFilter(t *testing.T) {
 	db, mock, cleanup := setupMockDB(t)
 	defer cleanup()
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxArchiveFiles bounds the number of files of a dataset archive.
+const maxArchiveFiles = 10000
+
+// archivePageSize is the number of files listed per query when selecting files by path prefix.
+const archivePageSize = 1000
+
+// archiveManifestName is the name of the manifest entry, the last entry of the archive.
+const archiveManifestName = "manifest.json"
+
+// archiveRequest is the request body of POST /datasets/:datasetId/archive.
+type archiveRequest struct {
+	FileIDs    []string `json:"fileIds"`
+	PathPrefix string   `json:"pathPrefix"`
+}
+
+// archiveManifest lists the files of a dataset archive with their checksums.
+type archiveManifest struct {
+	DatasetID string                 `json:"datasetId"`
+	Files     []archiveManifestEntry `json:"files"`
+}
+
+// archiveManifestEntry describes a file of a dataset archive.
+type archiveManifestEntry struct {
+	FileID   string `json:"fileId"`
+	FilePath string `json:"filePath"`
+	// Name is the name of the entry of the crypt4gh file in the archive.
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the checksum of the crypt4gh file as included in the archive.
+	SHA256                string `json:"sha256"`
+	DecryptedSize         int64  `json:"decryptedSize"`
+	DecryptedChecksum     string `json:"decryptedChecksum,omitempty"`
+	DecryptedChecksumType string `json:"decryptedChecksumType,omitempty"`
+}
+
+// archiveEntryName returns the name of the entry of a file in the archive:
+// the submitted path, made relative and without parent directory references,
+// with the .c4gh extension.
+func archiveEntryName(submittedPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+submittedPath), "/")
+	if !strings.HasSuffix(name, ".c4gh") {
+		name += ".c4gh"
+	}
+
+	return name
+}
+
+// DownloadDatasetArchive streams a tar archive of files of a dataset, each
+// re-encrypted for the recipient, followed by a manifest of checksums.
+// POST /datasets/:datasetId/archive
+//
+// All files are resolved and permission checked before streaming starts, so
+// that errors are reported with a status code. Once streaming has started,
+// errors end the response without the end of archive marker.
+func (h *Handlers) DownloadDatasetArchive(c *gin.Context) {
+	datasetID := c.Param("datasetId")
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		problemJSON(c, http.StatusUnauthorized, "authentication required")
+
+		return
+	}
+
+	if !hasDatasetAccess(authCtx.Datasets, datasetID) {
+		problemJSON(c, http.StatusForbidden, "access denied")
+		h.auditDenied(c)
+
+		return
+	}
+
+	var request archiveRequest
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, 1<<20)).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		problemJSON(c, http.StatusBadRequest, "invalid request body")
+
+		return
+	}
+	if len(request.FileIDs) > 0 && request.PathPrefix != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, "fileIds and pathPrefix are mutually exclusive", "FILTER_CONFLICT")
+
+		return
+	}
+	if len(request.FileIDs) > maxArchiveFiles {
+		problemJSON(c, http.StatusBadRequest, fmt.Sprintf("at most %d files can be downloaded as an archive", maxArchiveFiles))
+
+		return
+	}
+
+	exists, err := h.db.CheckDatasetExists(c.Request.Context(), datasetID)
+	if err != nil {
+		log.Errorf("failed to check dataset existence: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to check dataset")
+
+		return
+	}
+	if !exists {
+		problemJSON(c, http.StatusForbidden, "access denied")
+		h.auditDenied(c)
+
+		return
+	}
+
+	fileIDs := request.FileIDs
+	if len(fileIDs) == 0 {
+		fileIDs, err = h.datasetFileIDs(c.Request.Context(), datasetID, request.PathPrefix)
+		if errors.Is(err, errTooManyFiles) {
+			problemJSON(c, http.StatusBadRequest, fmt.Sprintf("at most %d files can be downloaded as an archive", maxArchiveFiles))
+
+			return
+		}
+		if err != nil {
+			log.Errorf("failed to retrieve dataset files: %v", err)
+			problemJSON(c, http.StatusInternalServerError, "failed to retrieve dataset files")
+
+			return
+		}
+	}
+	if len(fileIDs) == 0 {
+		problemJSON(c, http.StatusNotFound, "no files selected")
+
+		return
+	}
+
+	files, ok := h.resolveArchiveFiles(c, authCtx, datasetID, fileIDs)
+	if !ok {
+		return
+	}
+
+	if h.reencryptClient == nil || h.storageReader == nil {
+		log.Error("reencrypt client or storage reader not configured")
+		problemJSON(c, http.StatusInternalServerError, "archive download not configured")
+
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(datasetID) + ".tar"}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+
+	tw := tar.NewWriter(c.Writer)
+	manifest := archiveManifest{DatasetID: datasetID, Files: make([]archiveManifestEntry, 0, len(files))}
+	for _, file := range files {
+		entry, err := h.writeArchiveFile(c.Request.Context(), tw, file, publicKey)
+		if err != nil {
+			log.Errorf("failed to add file %s to archive of dataset %s: %v", file.ID, datasetID, err)
+			h.auditFailed(c, authCtx, file, "archive streaming error")
+
+			return
+		}
+		manifest.Files = append(manifest.Files, entry)
+
+		h.auditLogger.Log(c.Request.Context(), audit.Event{
+			Event:            audit.EventArchive,
+			UserID:           authCtx.Subject,
+			FileID:           file.ID,
+			DatasetID:        datasetID,
+			CorrelationID:    c.GetString("correlationId"),
+			Path:             c.Request.URL.Path,
+			HTTPStatus:       c.Writer.Status(),
+			BytesTransferred: entry.Size,
+		})
+	}
+
+	if err := writeArchiveManifest(tw, manifest); err != nil {
+		log.Errorf("failed to write manifest of archive of dataset %s: %v", datasetID, err)
+
+		return
+	}
+	if err := tw.Close(); err != nil {
+		log.Errorf("failed to close archive of dataset %s: %v", datasetID, err)
+	}
+}
+
+// errTooManyFiles is returned when more than maxArchiveFiles files are selected.
+var errTooManyFiles = errors.New("too many files")
+
+// datasetFiles returns the files of the dataset with the path prefix, at most maxArchiveFiles.
+func (h *Handlers) datasetFiles(ctx context.Context, datasetID, pathPrefix string) ([]database.File, error) {
+	var files []database.File
+	opts := database.FileListOptions{PathPrefix: pathPrefix, Limit: archivePageSize}
+	for {
+		page, err := h.db.GetDatasetFilesPaginated(ctx, datasetID, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		if len(files) > maxArchiveFiles {
+			return nil, errTooManyFiles
+		}
+		if len(page) < archivePageSize {
+			return files, nil
+		}
+
+		last := page[len(page)-1]
+		opts.CursorPath, opts.CursorID = last.SubmittedPath, last.ID
+	}
+}
+
+// datasetFileIDs returns the IDs of the files of the dataset with the path prefix.
+func (h *Handlers) datasetFileIDs(ctx context.Context, datasetID, pathPrefix string) ([]string, error) {
+	files, err := h.datasetFiles(ctx, datasetID, pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	fileIDs := make([]string, len(files))
+	for i, f := range files {
+		fileIDs[i] = f.ID
+	}
+
+	return fileIDs, nil
+}
+
+// resolveArchiveFiles checks the permission of the user to download each of the
+// files and looks them up. Files the user may not download, that do not exist
+// or that belong to another dataset deny the whole request.
+func (h *Handlers) resolveArchiveFiles(c *gin.Context, authCtx middleware.AuthContext, datasetID string, fileIDs []string) ([]*database.File, bool) {
+	files := make([]*database.File, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		if !config.JWTAllowAllData() {
+			hasPermission, err := h.db.CheckFilePermission(c.Request.Context(), fileID, authCtx.Datasets)
+			if err != nil {
+				log.Errorf("failed to check file permission: %v", err)
+				problemJSON(c, http.StatusInternalServerError, "failed to check file permission")
+
+				return nil, false
+			}
+			if !hasPermission {
+				problemJSON(c, http.StatusForbidden, "access denied")
+				h.auditDenied(c)
+
+				return nil, false
+			}
+		}
+
+		file, err := h.db.GetFileByID(c.Request.Context(), fileID)
+		if err != nil {
+			log.Errorf("failed to retrieve file info: %v", err)
+			problemJSON(c, http.StatusInternalServerError, "failed to retrieve file info")
+
+			return nil, false
+		}
+		if file == nil || file.DatasetID != datasetID {
+			problemJSON(c, http.StatusForbidden, "access denied")
+			h.auditDenied(c)
+
+			return nil, false
+		}
+		if file.ArchivePath == "" || len(file.Header) == 0 {
+			log.Errorf("file %s has no archive path or header", file.ID)
+			problemJSON(c, http.StatusInternalServerError, "file not in archive")
+
+			return nil, false
+		}
+
+		files = append(files, file)
+	}
+
+	return files, true
+}
+
+// writeArchiveFile writes the file re-encrypted for the public key to the
+// archive, streaming the body from storage.
+func (h *Handlers) writeArchiveFile(ctx context.Context, tw *tar.Writer, file *database.File, publicKey string) (archiveManifestEntry, error) {
+	newHeader, err := h.reencryptClient.ReencryptHeader(ctx, file.Header, publicKey)
+	if err != nil {
+		return archiveManifestEntry{}, fmt.Errorf("failed to reencrypt header: %w", err)
+	}
+
+	location := file.ArchiveLocation
+	if location == "" {
+		location, err = h.storageReader.FindFile(ctx, file.ArchivePath)
+		if err != nil {
+			return archiveManifestEntry{}, fmt.Errorf("failed to find file in storage: %w", err)
+		}
+	}
+	body, err := h.storageReader.NewFileReader(ctx, location, file.ArchivePath)
+	if err != nil {
+		return archiveManifestEntry{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer body.Close()
+
+	entry := archiveManifestEntry{
+		FileID:                file.ID,
+		FilePath:              file.SubmittedPath,
+		Name:                  archiveEntryName(file.SubmittedPath),
+		Size:                  int64(len(newHeader)) + file.ArchiveSize,
+		DecryptedSize:         file.DecryptedSize,
+		DecryptedChecksum:     file.DecryptedChecksum,
+		DecryptedChecksumType: file.DecryptedChecksumType,
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Size:     entry.Size,
+		Mode:     0o644,
+		ModTime:  file.CreatedAt,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return archiveManifestEntry{}, fmt.Errorf("failed to write tar header: %w", err)
+	}
+
+	hash := sha256.New()
+	w := io.MultiWriter(tw, hash)
+	if _, err := w.Write(newHeader); err != nil {
+		return archiveManifestEntry{}, fmt.Errorf("failed to stream header: %w", err)
+	}
+	if _, err := io.CopyN(w, body, file.ArchiveSize); err != nil {
+		return archiveManifestEntry{}, fmt.Errorf("failed to stream body: %w", err)
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return entry, nil
+}
+
+// writeArchiveManifest writes the manifest as the last entry of the archive.
+func writeArchiveManifest(tw *tar.Writer, manifest archiveManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveManifestName,
+		Size:     int64(len(data)),
+		Mode:     0o644,
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
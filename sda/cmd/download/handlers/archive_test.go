@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	re "github.com/neicnordic/sensitive-data-archive/internal/reencrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeReencryptServer "re-encrypts" headers by prefixing them with the public key.
+type fakeReencryptServer struct {
+	re.UnimplementedReencryptServer
+}
+
+func (fakeReencryptServer) ReencryptHeader(_ context.Context, req *re.ReencryptRequest) (*re.ReencryptResponse, error) {
+	return &re.ReencryptResponse{Header: append([]byte(req.GetPublickey()+":"), req.GetOldheader()...)}, nil
+}
+
+// startReencryptServer starts a fake reencrypt service and returns a client of it.
+func startReencryptServer(t *testing.T) *reencrypt.Client {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	re.RegisterReencryptServer(srv, fakeReencryptServer{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	client := reencrypt.NewClient("localhost", lis.Addr().(*net.TCPAddr).Port)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func archiveTestDatabase() *mockDatabase {
+	return &mockDatabase{
+		hasPermission: true,
+		filesByID: map[string]*database.File{
+			"file-1": {ID: "file-1", DatasetID: "test-dataset", SubmittedPath: "dir/a.bam.c4gh", ArchivePath: "archive/file-1", ArchiveLocation: "/archive", ArchiveSize: 5, DecryptedSize: 3, DecryptedChecksum: "abc", DecryptedChecksumType: "SHA256", Header: []byte("h1")},
+			"file-2": {ID: "file-2", DatasetID: "test-dataset", SubmittedPath: "../b.txt", ArchivePath: "archive/file-2", ArchiveLocation: "/archive", ArchiveSize: 7, Header: []byte("h2")},
+			"other":  {ID: "other", DatasetID: "other-dataset", SubmittedPath: "c.txt", ArchivePath: "archive/other", ArchiveLocation: "/archive", ArchiveSize: 1, Header: []byte("h3")},
+		},
+		datasetFilesPaged: []database.File{{ID: "file-1", SubmittedPath: "dir/a.bam.c4gh"}, {ID: "file-2", SubmittedPath: "../b.txt"}},
+	}
+}
+
+func archiveTestRouter(t *testing.T, db *mockDatabase, logger audit.Logger) *gin.Engine {
+	t.Helper()
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	storageReader := &mockStorageReader{files: map[string][]byte{
+		"archive/file-1": []byte("body1"),
+		"archive/file-2": []byte("body-22"),
+	}}
+	h, err := New(WithDatabase(db), WithStorageReader(storageReader), WithReencryptClient(startReencryptServer(t)), WithAuditLogger(logger))
+	require.NoError(t, err)
+	router.POST("/datasets/:datasetId/archive", h.DownloadDatasetArchive)
+
+	return router
+}
+
+func postArchive(router *gin.Engine, datasetID, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPost, "/datasets/"+datasetID+"/archive", strings.NewReader(body))
+	req.Header.Set("X-C4GH-Public-Key", "key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// readArchive returns the contents of the entries of a tar archive by name, in order.
+func readArchive(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	t.Helper()
+	var names []string
+	contents := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		contents[header.Name] = content
+	}
+}
+
+func TestDownloadDatasetArchive_FileIDs(t *testing.T) {
+	logger := &capturingLogger{}
+	router := archiveTestRouter(t, archiveTestDatabase(), logger)
+
+	w := postArchive(router, "test-dataset", `{"fileIds": ["file-1", "file-2"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/x-tar", w.Header().Get("Content-Type"))
+
+	names, contents := readArchive(t, w.Body.Bytes())
+	assert.Equal(t, []string{"dir/a.bam.c4gh", "b.txt.c4gh", "manifest.json"}, names)
+	assert.Equal(t, "key:h1body1", string(contents["dir/a.bam.c4gh"]))
+	assert.Equal(t, "key:h2body-22", string(contents["b.txt.c4gh"]))
+
+	var manifest archiveManifest
+	require.NoError(t, json.Unmarshal(contents["manifest.json"], &manifest))
+	assert.Equal(t, "test-dataset", manifest.DatasetID)
+	require.Len(t, manifest.Files, 2)
+	sum := sha256.Sum256([]byte("key:h1body1"))
+	assert.Equal(t, archiveManifestEntry{
+		FileID:                "file-1",
+		FilePath:              "dir/a.bam.c4gh",
+		Name:                  "dir/a.bam.c4gh",
+		Size:                  11,
+		SHA256:                hex.EncodeToString(sum[:]),
+		DecryptedSize:         3,
+		DecryptedChecksum:     "abc",
+		DecryptedChecksumType: "SHA256",
+	}, manifest.Files[0])
+
+	require.Len(t, logger.events, 2)
+	for i, fileID := range []string{"file-1", "file-2"} {
+		assert.Equal(t, audit.EventArchive, logger.events[i].Event)
+		assert.Equal(t, fileID, logger.events[i].FileID)
+		assert.Equal(t, "test-dataset", logger.events[i].DatasetID)
+	}
+}
+
+func TestDownloadDatasetArchive_PathPrefix(t *testing.T) {
+	router := archiveTestRouter(t, archiveTestDatabase(), audit.NoopLogger{})
+
+	w := postArchive(router, "test-dataset", `{"pathPrefix": "dir/"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The mock database ignores the prefix and lists both files
+	names, _ := readArchive(t, w.Body.Bytes())
+	assert.Equal(t, []string{"dir/a.bam.c4gh", "b.txt.c4gh", "manifest.json"}, names)
+}
+
+func TestDownloadDatasetArchive_Denied(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		datasetID string
+		body      string
+		db        func(*mockDatabase)
+	}{
+		{"no dataset access", "other-dataset", `{}`, nil},
+		{"file of other dataset", "test-dataset", `{"fileIds": ["file-1", "other"]}`, nil},
+		{"missing file", "test-dataset", `{"fileIds": ["missing"]}`, nil},
+		{"no file permission", "test-dataset", `{"fileIds": ["file-1"]}`, func(db *mockDatabase) { db.hasPermission = false }},
+	} {
+		db := archiveTestDatabase()
+		if tc.db != nil {
+			tc.db(db)
+		}
+		logger := &capturingLogger{}
+		router := archiveTestRouter(t, db, logger)
+
+		w := postArchive(router, tc.datasetID, tc.body)
+		assert.Equal(t, http.StatusForbidden, w.Code, tc.name)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"), tc.name)
+		require.Len(t, logger.events, 1, tc.name)
+		assert.Equal(t, audit.EventDenied, logger.events[0].Event, tc.name)
+	}
+}
+
+func TestDownloadDatasetArchive_InvalidRequest(t *testing.T) {
+	router := archiveTestRouter(t, archiveTestDatabase(), audit.NoopLogger{})
+
+	w := postArchive(router, "test-dataset", `{"fileIds": ["file-1"], "pathPrefix": "dir/"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response ProblemDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "FILTER_CONFLICT", response.ErrorCode)
+
+	w = postArchive(router, "test-dataset", `not json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDownloadDatasetArchive_MissingPublicKey(t *testing.T) {
+	router := archiveTestRouter(t, archiveTestDatabase(), audit.NoopLogger{})
+
+	req, _ := http.NewRequest(http.MethodPost, "/datasets/test-dataset/archive", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestArchiveEntryName(t *testing.T) {
+	assert.Equal(t, "dir/a.bam.c4gh", archiveEntryName("dir/a.bam.c4gh"))
+	assert.Equal(t, "a.txt.c4gh", archiveEntryName("/a.txt"))
+	assert.Equal(t, "etc/passwd.c4gh", archiveEntryName("../../etc/passwd"))
+}
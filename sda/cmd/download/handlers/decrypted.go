@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolvedDecryptedFile holds the result of resolving a file for decrypted streaming.
+type resolvedDecryptedFile struct {
+	resolvedBase
+	client        string
+	decryptedSize int64
+	etag          string
+}
+
+// decryptedETag returns the ETag of the decrypted file, stable for a given file.
+func decryptedETag(fileID string, decryptedSize int64) string {
+	hash := sha256.Sum256([]byte(fileID + ":decrypted:" + strconv.FormatInt(decryptedSize, 10)))
+
+	return fmt.Sprintf(`"%x"`, hash[:])
+}
+
+// decryptedContentDisposition returns the Content-Disposition header value for
+// a decrypted file, the submitted file name without the .c4gh extension.
+func decryptedContentDisposition(submittedPath string) string {
+	filename := strings.TrimSuffix(filepath.Base(submittedPath), ".c4gh")
+
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// verifiedClient returns the common name of the verified client certificate
+// of the request, or an empty string if the request was not made over mTLS.
+func verifiedClient(c *gin.Context) string {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return c.Request.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// resolveFileForDecrypted checks that the request is made by an allowed client
+// over mTLS, then resolves the file like resolveFileBase. Plaintext is only
+// served from datasets the user owns, visas never grant access to it.
+func (h *Handlers) resolveFileForDecrypted(c *gin.Context) (*resolvedDecryptedFile, bool) {
+	client := verifiedClient(c)
+	if client == "" || !slices.Contains(h.decryptedClients, client) {
+		log.Warnf("decrypted streaming denied for client %q", client)
+		problemJSONWithCode(c, http.StatusForbidden, "a verified client certificate of an allowed client is required", "CLIENT_NOT_ALLOWED")
+		h.auditDenied(c)
+
+		return nil, false
+	}
+
+	base, ok := h.resolveFileWithDatasets(c, func(authCtx middleware.AuthContext) []string {
+		return authCtx.OwnedDatasets
+	})
+	if !ok {
+		return nil, false
+	}
+
+	decryptedSize := streaming.DecryptedSize(base.file.ArchiveSize)
+
+	return &resolvedDecryptedFile{
+		resolvedBase:  *base,
+		client:        client,
+		decryptedSize: decryptedSize,
+		etag:          decryptedETag(base.file.ID, decryptedSize),
+	}, true
+}
+
+// GetDecryptedFile streams the decrypted file to trusted internal clients.
+// GET /files/:fileId/decrypted
+func (h *Handlers) GetDecryptedFile(c *gin.Context) {
+	resolved, ok := h.resolveFileForDecrypted(c)
+	if !ok {
+		return
+	}
+
+	file := resolved.file
+
+	if len(file.Header) == 0 {
+		log.Errorf("file %s has no header", file.ID)
+		problemJSON(c, http.StatusInternalServerError, "file header not available")
+
+		return
+	}
+	if h.reencryptClient == nil || h.storageReader == nil {
+		log.Error("reencrypt client or storage reader not configured")
+		problemJSON(c, http.StatusInternalServerError, "decrypted streaming not configured")
+
+		return
+	}
+
+	// If-Range check
+	honorRange := streaming.CheckIfRange(c.GetHeader("If-Range"), resolved.etag, time.Time{})
+
+	// Parse Range header
+	var rangeSpec *streaming.RangeSpec
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" && honorRange {
+		var rangeErr error
+		rangeSpec, rangeErr = streaming.ParseRangeHeader(rangeHeader, resolved.decryptedSize)
+		if errors.Is(rangeErr, streaming.ErrRangeInvalid) {
+			problemJSONWithCode(c, http.StatusBadRequest, "invalid range header", "RANGE_INVALID")
+
+			return
+		}
+		if errors.Is(rangeErr, streaming.ErrRangeNotSatisfiable) {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", resolved.decryptedSize))
+			problemJSON(c, http.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+
+			return
+		}
+	}
+
+	header, err := h.reencryptClient.ReencryptHeader(c.Request.Context(), file.Header, h.servicePublicKey)
+	if err != nil {
+		log.Errorf("failed to reencrypt header: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to prepare file for download")
+		h.auditFailed(c, resolved.authCtx, file, "reencrypt error")
+
+		return
+	}
+
+	fileReader, err := h.storageReader.NewFileReadSeeker(c.Request.Context(), resolved.location, file.ArchivePath)
+	if err != nil {
+		log.Errorf("failed to open file: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to open file")
+		h.auditFailed(c, resolved.authCtx, file, "failed to open file")
+
+		return
+	}
+
+	decrypted, err := streaming.NewDecryptingReader(header, fileReader, h.servicePrivateKey)
+	if err != nil {
+		fileReader.Close()
+		log.Errorf("failed to decrypt file %s: %v", file.ID, err)
+		problemJSON(c, http.StatusInternalServerError, "failed to decrypt file")
+		h.auditFailed(c, resolved.authCtx, file, "decryption error")
+
+		return
+	}
+
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("ETag", resolved.etag)
+	c.Header("Content-Disposition", decryptedContentDisposition(file.SubmittedPath))
+	c.Header("Cache-Control", "private, no-store")
+
+	err = streaming.StreamBodyOnly(streaming.StreamBodyConfig{
+		Writer:          c.Writer,
+		FileReader:      decrypted,
+		ArchiveFileSize: resolved.decryptedSize,
+		Range:           rangeSpec,
+	})
+	if err != nil {
+		if errors.Is(err, storageerrors.ErrorChecksumMismatch) {
+			log.Errorf("archived file does not match its checksum, file: %s, reason: %v", file.ID, err)
+			h.auditFailed(c, resolved.authCtx, file, "checksum mismatch")
+
+			return
+		}
+		log.Errorf("error streaming decrypted file: %v", err)
+		h.auditFailed(c, resolved.authCtx, file, "streaming error")
+
+		return
+	}
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventDecrypted,
+		UserID:        resolved.authCtx.Subject,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    c.Writer.Status(),
+		AuthType:      "mtls",
+		ClientID:      resolved.client,
+	})
+}
+
+// HeadDecryptedFile returns the metadata of the decrypted file.
+// HEAD /files/:fileId/decrypted
+func (h *Handlers) HeadDecryptedFile(c *gin.Context) {
+	resolved, ok := h.resolveFileForDecrypted(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Length", fmt.Sprintf("%d", resolved.decryptedSize))
+	c.Header("ETag", resolved.etag)
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Disposition", decryptedContentDisposition(resolved.file.SubmittedPath))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+}
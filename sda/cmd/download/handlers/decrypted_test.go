@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decryptedTestRouter returns a router serving decrypted files to the pipeline
+// client, for a user owning one dataset and granted another by a visa.
+func decryptedTestRouter(t *testing.T, db *mockDatabase, logger audit.Logger) *gin.Engine {
+	t.Helper()
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.ContextKey, middleware.AuthContext{
+			Subject:       "pipeline",
+			OwnedDatasets: []string{"owned-dataset"},
+			VisaDatasets:  []string{"visa-dataset"},
+			Datasets:      []string{"owned-dataset", "visa-dataset"},
+		})
+		c.Next()
+	})
+
+	h, err := New(WithDatabase(db), WithAuditLogger(logger), WithDecryptedStreaming([]string{"pipeline"}))
+	require.NoError(t, err)
+	router.HEAD("/files/:fileId/decrypted", h.HeadDecryptedFile)
+	router.GET("/files/:fileId/decrypted", h.GetDecryptedFile)
+
+	return router
+}
+
+// withClientCertificate marks the request as made over mTLS with a verified
+// client certificate with the common name.
+func withClientCertificate(req *http.Request, commonName string) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func decryptedTestDatabase() *mockDatabase {
+	return &mockDatabase{
+		hasPermission: true,
+		fileByID: &database.File{
+			ID:              "test-file",
+			DatasetID:       "owned-dataset",
+			SubmittedPath:   "dir/sample.bam.c4gh",
+			ArchivePath:     "archive/test-file",
+			ArchiveLocation: "/archive",
+			ArchiveSize:     2*streaming.EncryptedSegmentSize + 128,
+			Header:          []byte("header"),
+		},
+	}
+}
+
+func TestDecryptedFile_ClientNotAllowed(t *testing.T) {
+	for _, commonName := range []string{"", "other-client"} {
+		logger := &capturingLogger{}
+		router := decryptedTestRouter(t, decryptedTestDatabase(), logger)
+
+		req, _ := http.NewRequest(http.MethodGet, "/files/test-file/decrypted", nil)
+		if commonName != "" {
+			withClientCertificate(req, commonName)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, commonName)
+
+		var response ProblemDetails
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "CLIENT_NOT_ALLOWED", response.ErrorCode)
+		require.Len(t, logger.events, 1)
+		assert.Equal(t, audit.EventDenied, logger.events[0].Event)
+	}
+}
+
+func TestHeadDecryptedFile(t *testing.T) {
+	db := decryptedTestDatabase()
+	router := decryptedTestRouter(t, db, audit.NoopLogger{})
+
+	req, _ := http.NewRequest(http.MethodHead, "/files/test-file/decrypted", nil)
+	withClientCertificate(req, "pipeline")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "131172", w.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, `attachment; filename=sample.bam`, w.Header().Get("Content-Disposition"))
+	// Visas never grant access to decrypted files
+	assert.Equal(t, []string{"owned-dataset"}, db.checkedDatasets)
+}
+
+func TestGetDecryptedFile_AccessDenied(t *testing.T) {
+	db := decryptedTestDatabase()
+	db.hasPermission = false
+	router := decryptedTestRouter(t, db, audit.NoopLogger{})
+
+	req, _ := http.NewRequest(http.MethodGet, "/files/test-file/decrypted", nil)
+	withClientCertificate(req, "pipeline")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGetDecryptedFile_NotConfigured(t *testing.T) {
+	router := decryptedTestRouter(t, decryptedTestDatabase(), audit.NoopLogger{})
+
+	req, _ := http.NewRequest(http.MethodGet, "/files/test-file/decrypted", nil)
+	withClientCertificate(req, "pipeline")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRegisterRoutes_DecryptedDisabled(t *testing.T) {
+	router := gin.New()
+	h := newTestHandlers(t)
+	h.RegisterRoutes(router)
+
+	req, _ := http.NewRequest(http.MethodGet, "/files/test-file/decrypted", nil)
+	withClientCertificate(req, "pipeline")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
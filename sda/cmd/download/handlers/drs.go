@@ -1,23 +1,46 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	log "github.com/sirupsen/logrus"
 )
 
-// DrsObject represents a GA4GH DRS object response.
+// drsAccessID is the access ID of the single access method of DRS blobs.
+const drsAccessID = "https"
+
+// DrsObject represents a GA4GH DRS object response, a blob (file) or a bundle (dataset).
 type DrsObject struct {
-	ID            string            `json:"id"`
-	SelfURI       string            `json:"self_uri"`
-	Size          int64             `json:"size"`
-	CreatedTime   string            `json:"created_time"`
-	Checksums     []DrsChecksum     `json:"checksums"`
-	AccessMethods []DrsAccessMethod `json:"access_methods"`
+	ID            string              `json:"id"`
+	Name          string              `json:"name,omitempty"`
+	SelfURI       string              `json:"self_uri"`
+	Size          int64               `json:"size"`
+	CreatedTime   string              `json:"created_time"`
+	Description   string              `json:"description,omitempty"`
+	Checksums     []DrsChecksum       `json:"checksums"`
+	AccessMethods []DrsAccessMethod   `json:"access_methods,omitempty"`
+	Contents      []DrsContentsObject `json:"contents,omitempty"`
+}
+
+// DrsContentsObject represents an object contained in a DRS bundle.
+type DrsContentsObject struct {
+	Name   string   `json:"name"`
+	ID     string   `json:"id"`
+	DrsURI []string `json:"drs_uri"`
 }
 
 // DrsChecksum represents a checksum in a DRS object.
@@ -26,15 +49,24 @@ type DrsChecksum struct {
 	Type     string `json:"type"`
 }
 
-// DrsAccessMethod represents an access method in a DRS object.
+// DrsAccessMethod represents an access method in a DRS object, with either
+// an access URL or an access ID to resolve into one.
 type DrsAccessMethod struct {
-	Type      string       `json:"type"`
-	AccessURL DrsAccessURL `json:"access_url"`
+	Type      string        `json:"type"`
+	AccessURL *DrsAccessURL `json:"access_url,omitempty"`
+	AccessID  string        `json:"access_id,omitempty"`
 }
 
 // DrsAccessURL represents an access URL in a DRS access method.
 type DrsAccessURL struct {
-	URL string `json:"url"`
+	URL     string   `json:"url"`
+	Headers []string `json:"headers,omitempty"`
+}
+
+// drsRequest is the request body of the DRS POST endpoints. Passports are
+// read by middleware.PassportMiddleware.
+type drsRequest struct {
+	Expand bool `json:"expand"`
 }
 
 // drsChecksumType normalises an SDA checksum type to the DRS/GA4GH lowercase form.
@@ -95,47 +127,28 @@ func (h *Handlers) GetDrsObject(c *gin.Context) {
 		return
 	}
 
-	host := c.Request.Host
 	scheme := "https"
 	if c.Request.TLS == nil {
 		scheme = "http"
 	}
 
-	// Fetch ARCHIVED checksums (over the encrypted blob, per DRS 1.5 spec)
-	archivedChecksums, err := h.db.GetFileChecksums(c.Request.Context(), file.ID, "ARCHIVED")
-	if err != nil {
-		log.Errorf("failed to get file checksums: %v", err)
-		problemJSON(c, http.StatusInternalServerError, "failed to retrieve checksums")
-
-		return
-	}
-
-	if len(archivedChecksums) == 0 {
-		log.Errorf("file %s has no ARCHIVED checksums", file.ID)
-		problemJSON(c, http.StatusInternalServerError, "file has no checksums")
-
+	// ARCHIVED checksums are over the encrypted blob, per DRS 1.5 spec
+	checksums, ok := h.drsFileChecksums(c, file.ID)
+	if !ok {
 		return
 	}
 
-	checksums := make([]DrsChecksum, len(archivedChecksums))
-	for i, ac := range archivedChecksums {
-		checksums[i] = DrsChecksum{
-			Checksum: ac.Checksum,
-			Type:     drsChecksumType(ac.Type),
-		}
-	}
-
 	obj := DrsObject{
 		ID:          file.ID,
-		SelfURI:     fmt.Sprintf("drs://%s/%s", host, file.ID),
+		SelfURI:     drsSelfURI(c, file.ID),
 		Size:        file.ArchiveSize,
 		CreatedTime: file.CreatedAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
 		Checksums:   checksums,
 		AccessMethods: []DrsAccessMethod{
 			{
 				Type: scheme,
-				AccessURL: DrsAccessURL{
-					URL: fmt.Sprintf("%s://%s/files/%s/content", scheme, host, file.ID),
+				AccessURL: &DrsAccessURL{
+					URL: fmt.Sprintf("%s://%s/files/%s/content", scheme, c.Request.Host, file.ID),
 				},
 			},
 		},
@@ -144,3 +157,265 @@ func (h *Handlers) GetDrsObject(c *gin.Context) {
 	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
 	c.JSON(http.StatusOK, obj)
 }
+
+// drsSelfURI returns the DRS URI of an object served by this host.
+func drsSelfURI(c *gin.Context, objectID string) string {
+	return fmt.Sprintf("drs://%s/%s", c.Request.Host, objectID)
+}
+
+// drsObjectRequest reads the request body of the DRS POST endpoints.
+// GET requests have none.
+func drsObjectRequest(c *gin.Context) (drsRequest, bool) {
+	var request drsRequest
+	if c.Request.Method != http.MethodPost {
+		return request, true
+	}
+
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, 1<<20)).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		problemJSON(c, http.StatusBadRequest, "invalid request body")
+
+		return request, false
+	}
+
+	return request, true
+}
+
+// resolveDrsFile checks the permission of the user to download the file and looks it up.
+func (h *Handlers) resolveDrsFile(c *gin.Context, authCtx middleware.AuthContext, fileID string) (*database.File, bool) {
+	if !config.JWTAllowAllData() {
+		hasPermission, err := h.db.CheckFilePermission(c.Request.Context(), fileID, authCtx.Datasets)
+		if err != nil {
+			log.Errorf("failed to check file permission: %v", err)
+			problemJSON(c, http.StatusInternalServerError, "failed to check file permission")
+
+			return nil, false
+		}
+		if !hasPermission {
+			problemJSON(c, http.StatusForbidden, "access denied")
+			h.auditDenied(c)
+
+			return nil, false
+		}
+	}
+
+	file, err := h.db.GetFileByID(c.Request.Context(), fileID)
+	if err != nil {
+		log.Errorf("failed to retrieve file info: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to retrieve file info")
+
+		return nil, false
+	}
+	if file == nil {
+		problemJSON(c, http.StatusForbidden, "access denied")
+		h.auditDenied(c)
+
+		return nil, false
+	}
+
+	return file, true
+}
+
+// GetDrsObjectByID returns the GA4GH DRS object with the ID: a blob for a
+// file or a bundle of its files for a dataset. The POST variant accepts
+// passports in the request body instead of a token.
+// GET /ga4gh/drs/v1/objects/:objectId
+// POST /ga4gh/drs/v1/objects/:objectId
+func (h *Handlers) GetDrsObjectByID(c *gin.Context) {
+	objectID := c.Param("objectId")
+
+	// Bundles are flat, expanding them changes nothing
+	if _, ok := drsObjectRequest(c); !ok {
+		return
+	}
+
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		problemJSON(c, http.StatusUnauthorized, "authentication required")
+
+		return
+	}
+
+	if hasDatasetAccess(authCtx.Datasets, objectID) {
+		info, err := h.db.GetDatasetInfo(c.Request.Context(), objectID)
+		if err != nil {
+			log.Errorf("failed to get dataset info: %v", err)
+			problemJSON(c, http.StatusInternalServerError, "failed to retrieve dataset")
+
+			return
+		}
+		if info != nil {
+			h.drsBundle(c, info)
+
+			return
+		}
+	}
+
+	file, ok := h.resolveDrsFile(c, authCtx, objectID)
+	if !ok {
+		return
+	}
+
+	// The size and checksums are those of the blob as archived, as for the legacy object endpoint. The access URL
+	// serves the blob behind a header re-encrypted for every request, which the checksums do not cover.
+	checksums, ok := h.drsFileChecksums(c, file.ID)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+	c.JSON(http.StatusOK, DrsObject{
+		ID:            file.ID,
+		Name:          file.SubmittedPath,
+		SelfURI:       drsSelfURI(c, file.ID),
+		Size:          file.ArchiveSize,
+		CreatedTime:   file.CreatedAt.UTC().Format(time.RFC3339),
+		Checksums:     checksums,
+		AccessMethods: []DrsAccessMethod{{Type: "https", AccessID: drsAccessID}},
+	})
+}
+
+// drsFileChecksums returns the ARCHIVED checksums of a file, over the encrypted blob.
+func (h *Handlers) drsFileChecksums(c *gin.Context, fileID string) ([]DrsChecksum, bool) {
+	archivedChecksums, err := h.db.GetFileChecksums(c.Request.Context(), fileID, "ARCHIVED")
+	if err != nil {
+		log.Errorf("failed to get file checksums: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to retrieve checksums")
+
+		return nil, false
+	}
+	if len(archivedChecksums) == 0 {
+		log.Errorf("file %s has no ARCHIVED checksums", fileID)
+		problemJSON(c, http.StatusInternalServerError, "file has no checksums")
+
+		return nil, false
+	}
+
+	checksums := make([]DrsChecksum, len(archivedChecksums))
+	for i, ac := range archivedChecksums {
+		checksums[i] = DrsChecksum{Checksum: ac.Checksum, Type: drsChecksumType(ac.Type)}
+	}
+
+	return checksums, true
+}
+
+// drsBundle writes the DRS bundle of a dataset. Its checksum is the SHA-256
+// of the sorted concatenation of the SHA-256 checksums of its files, per the
+// DRS specification.
+func (h *Handlers) drsBundle(c *gin.Context, info *database.DatasetInfo) {
+	files, err := h.datasetFiles(c.Request.Context(), info.ID, "")
+	if errors.Is(err, errTooManyFiles) {
+		problemJSONWithCode(c, http.StatusBadRequest, fmt.Sprintf("bundles of more than %d files are not supported", maxArchiveFiles), "BUNDLE_TOO_LARGE")
+
+		return
+	}
+	if err != nil {
+		log.Errorf("failed to retrieve dataset files: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to retrieve dataset files")
+
+		return
+	}
+
+	archivedChecksums, err := h.db.GetDatasetChecksums(c.Request.Context(), info.ID, "ARCHIVED")
+	if err != nil {
+		log.Errorf("failed to get dataset checksums: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to retrieve checksums")
+
+		return
+	}
+
+	var size int64
+	contents := make([]DrsContentsObject, len(files))
+	fileChecksums := make([]string, 0, len(files))
+	for i, f := range files {
+		size += f.ArchiveSize
+		contents[i] = DrsContentsObject{Name: f.SubmittedPath, ID: f.ID, DrsURI: []string{drsSelfURI(c, f.ID)}}
+
+		idx := slices.IndexFunc(archivedChecksums[f.ID], func(ac database.Checksum) bool {
+			return drsChecksumType(ac.Type) == "sha-256"
+		})
+		if idx < 0 {
+			log.Errorf("file %s has no ARCHIVED sha-256 checksum", f.ID)
+			problemJSON(c, http.StatusInternalServerError, "file has no checksums")
+
+			return
+		}
+		fileChecksums = append(fileChecksums, archivedChecksums[f.ID][idx].Checksum)
+	}
+	slices.Sort(fileChecksums)
+	bundleChecksum := sha256.Sum256([]byte(strings.Join(fileChecksums, "")))
+
+	name := info.Title
+	if name == "" {
+		name = info.ID
+	}
+
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+	c.JSON(http.StatusOK, DrsObject{
+		ID:          info.ID,
+		Name:        name,
+		SelfURI:     drsSelfURI(c, info.ID),
+		Size:        size,
+		CreatedTime: info.CreatedAt.UTC().Format(time.RFC3339),
+		Description: info.Description,
+		Checksums:   []DrsChecksum{{Checksum: hex.EncodeToString(bundleChecksum[:]), Type: "sha-256"}},
+		Contents:    contents,
+	})
+}
+
+// GetDrsAccessURL returns a signed URL downloading the file of a DRS blob,
+// re-encrypted for the public key of the request. The POST variant accepts
+// passports in the request body instead of a token.
+// GET /ga4gh/drs/v1/objects/:objectId/access/:accessId
+// POST /ga4gh/drs/v1/objects/:objectId/access/:accessId
+func (h *Handlers) GetDrsAccessURL(c *gin.Context) {
+	if _, ok := drsObjectRequest(c); !ok {
+		return
+	}
+
+	if c.Param("accessId") != drsAccessID {
+		problemJSON(c, http.StatusNotFound, "access ID not found")
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	authCtx, ok := middleware.GetAuthContext(c)
+	if !ok {
+		problemJSON(c, http.StatusUnauthorized, "authentication required")
+
+		return
+	}
+
+	file, ok := h.resolveDrsFile(c, authCtx, c.Param("objectId"))
+	if !ok {
+		return
+	}
+
+	signedURL, _, err := signedFileURL(c, authCtx, file, publicKey, defaultSignedURLExpiry)
+	if err != nil {
+		log.Errorf("failed to sign URL: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to sign URL")
+
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, DrsAccessURL{URL: signedURL})
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventSignedURL,
+		UserID:        authCtx.Subject,
+		AuthType:      authCtx.AuthSource,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    c.Writer.Status(),
+	})
+}
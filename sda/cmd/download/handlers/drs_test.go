@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/signing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -281,3 +288,206 @@ func TestDrsChecksumType(t *testing.T) {
 		})
 	}
 }
+
+// setupDrsRouter registers the GA4GH DRS object routes behind mocked auth.
+func setupDrsRouter(t *testing.T, datasets []string, mockDB *mockDatabase) *gin.Engine {
+	t.Helper()
+	SetPaginationSecret([]byte("test-secret-key-for-drs"))
+
+	router := setupTestRouterWithAuth(datasets)
+	h, err := New(WithDatabase(mockDB))
+	require.NoError(t, err)
+
+	router.GET("/ga4gh/drs/v1/objects/:objectId", h.GetDrsObjectByID)
+	router.POST("/ga4gh/drs/v1/objects/:objectId", h.GetDrsObjectByID)
+	router.GET("/ga4gh/drs/v1/objects/:objectId/access/:accessId", h.GetDrsAccessURL)
+	router.POST("/ga4gh/drs/v1/objects/:objectId/access/:accessId", h.GetDrsAccessURL)
+
+	return router
+}
+
+func drsTestFile() *database.File {
+	return &database.File{
+		ID:            "urn:neic:001-002-003",
+		DatasetID:     "EGAD00001000001",
+		SubmittedPath: "samples/controls/sample1.bam.c4gh",
+		ArchiveSize:   2097152,
+		CreatedAt:     time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestGetDrsObjectByID_Blob(t *testing.T) {
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+		hasPermission: true,
+		fileByID:      drsTestFile(),
+		fileChecksums: []database.Checksum{{Type: "SHA256", Checksum: "a1b2c3d4"}},
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequest(method, "/ga4gh/drs/v1/objects/urn:neic:001-002-003", bytes.NewBufferString(`{"expand": true}`))
+		req.Host = "download.example.org"
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, method)
+
+		var obj DrsObject
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &obj))
+		assert.Equal(t, "urn:neic:001-002-003", obj.ID)
+		assert.Equal(t, "samples/controls/sample1.bam.c4gh", obj.Name)
+		assert.Equal(t, "drs://download.example.org/urn:neic:001-002-003", obj.SelfURI)
+		assert.Equal(t, int64(2097152), obj.Size)
+		assert.Equal(t, "2026-01-15T10:30:00Z", obj.CreatedTime)
+		assert.Equal(t, []DrsChecksum{{Checksum: "a1b2c3d4", Type: "sha-256"}}, obj.Checksums)
+		assert.Equal(t, []DrsAccessMethod{{Type: "https", AccessID: "https"}}, obj.AccessMethods)
+		assert.Empty(t, obj.Contents)
+	}
+}
+
+func TestGetDrsObjectByID_Bundle(t *testing.T) {
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+		datasetInfo: &database.DatasetInfo{
+			ID:          "EGAD00001000001",
+			Title:       "Controls",
+			Description: "Control samples",
+			CreatedAt:   time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC),
+		},
+		datasetFilesPaged: []database.File{
+			{ID: "file-1", SubmittedPath: "a.bam.c4gh", ArchiveSize: 100},
+			{ID: "file-2", SubmittedPath: "b.bam.c4gh", ArchiveSize: 200},
+		},
+		datasetChecksums: map[string][]database.Checksum{
+			"file-1": {{Type: "SHA256", Checksum: "bbbb"}},
+			"file-2": {{Type: "MD5", Checksum: "cccc"}, {Type: "SHA256", Checksum: "aaaa"}},
+		},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/ga4gh/drs/v1/objects/EGAD00001000001", nil)
+	req.Host = "download.example.org"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var obj DrsObject
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &obj))
+	assert.Equal(t, "EGAD00001000001", obj.ID)
+	assert.Equal(t, "Controls", obj.Name)
+	assert.Equal(t, "Control samples", obj.Description)
+	assert.Equal(t, int64(300), obj.Size)
+	assert.Empty(t, obj.AccessMethods)
+
+	expected := sha256.Sum256([]byte("aaaabbbb"))
+	assert.Equal(t, []DrsChecksum{{Checksum: hex.EncodeToString(expected[:]), Type: "sha-256"}}, obj.Checksums)
+	assert.Equal(t, []DrsContentsObject{
+		{Name: "a.bam.c4gh", ID: "file-1", DrsURI: []string{"drs://download.example.org/file-1"}},
+		{Name: "b.bam.c4gh", ID: "file-2", DrsURI: []string{"drs://download.example.org/file-2"}},
+	}, obj.Contents)
+}
+
+func TestGetDrsObjectByID_BundleMissingChecksum_Returns500(t *testing.T) {
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+		datasetInfo:       &database.DatasetInfo{ID: "EGAD00001000001"},
+		datasetFilesPaged: []database.File{{ID: "file-1", SubmittedPath: "a.bam.c4gh"}},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/ga4gh/drs/v1/objects/EGAD00001000001", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetDrsObjectByID_NoAccess_Returns403(t *testing.T) {
+	// Neither a dataset nor a file the user may access
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+		datasetInfo: &database.DatasetInfo{ID: "EGAD00009999999"},
+	})
+
+	for _, objectID := range []string{"EGAD00009999999", "urn:neic:001-002-003"} {
+		req, _ := http.NewRequest(http.MethodGet, "/ga4gh/drs/v1/objects/"+objectID, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, objectID)
+	}
+}
+
+func TestGetDrsObjectByID_InvalidBody_Returns400(t *testing.T) {
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{})
+
+	req, _ := http.NewRequest(http.MethodPost, "/ga4gh/drs/v1/objects/urn:neic:001-002-003", bytes.NewBufferString("{"))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetDrsAccessURL(t *testing.T) {
+	router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+		hasPermission: true,
+		fileByID:      drsTestFile(),
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequest(method, "/ga4gh/drs/v1/objects/urn:neic:001-002-003/access/https", http.NoBody)
+		req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+		req.Host = "download.example.org"
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, method)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var accessURL DrsAccessURL
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accessURL))
+
+		signedURL, err := url.Parse(accessURL.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "download.example.org", signedURL.Host)
+		assert.Equal(t, "/files/urn:neic:001-002-003", signedURL.Path)
+
+		var claims middleware.SignedURLClaims
+		require.NoError(t, signing.Decode(signedURL.Query().Get(middleware.SignedURLParam), &claims))
+		assert.Equal(t, "urn:neic:001-002-003", claims.FileID)
+		assert.Equal(t, "EGAD00001000001", claims.DatasetID)
+		assert.Equal(t, "dGVzdC1wdWJsaWMta2V5", claims.PublicKey)
+	}
+}
+
+func TestGetDrsAccessURL_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		path          string
+		publicKey     string
+		hasPermission bool
+		status        int
+	}{
+		{"unknown access ID", "/ga4gh/drs/v1/objects/urn:neic:001-002-003/access/s3", "dGVzdC1wdWJsaWMta2V5", true, http.StatusNotFound},
+		{"missing key", "/ga4gh/drs/v1/objects/urn:neic:001-002-003/access/https", "", true, http.StatusBadRequest},
+		{"no access", "/ga4gh/drs/v1/objects/urn:neic:001-002-003/access/https", "dGVzdC1wdWJsaWMta2V5", false, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setupDrsRouter(t, []string{"EGAD00001000001"}, &mockDatabase{
+				hasPermission: tc.hasPermission,
+				fileByID:      drsTestFile(),
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.publicKey != "" {
+				req.Header.Set("X-C4GH-Public-Key", tc.publicKey)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
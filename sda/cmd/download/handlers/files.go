@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
 	"path/filepath"
@@ -12,11 +13,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -33,12 +35,23 @@ type resolvedFile struct {
 	publicKey string
 	newHeader []byte
 	etag      string
+	// segments is the part of the archived body to serve when the request selects
+	// a byte range of the decrypted file, nil for the whole file.
+	segments *streaming.ByteRange
 }
 
 // resolveFileBase performs auth, permission check, file lookup,
 // and storage resolution common to both full-download and content-only endpoints.
 // Returns (nil, false) if an error response was already sent.
 func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
+	return h.resolveFileWithDatasets(c, func(authCtx middleware.AuthContext) []string {
+		return authCtx.Datasets
+	})
+}
+
+// resolveFileWithDatasets is resolveFileBase granting access to the datasets
+// selected from the auth context.
+func (h *Handlers) resolveFileWithDatasets(c *gin.Context, datasets func(middleware.AuthContext) []string) (*resolvedBase, bool) {
 	fileID := c.Param("fileId")
 
 	// Get auth context
@@ -51,7 +64,7 @@ func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
 
 	// Permission check: return 403 for both "no access" AND "not found" (no existence leakage)
 	if !config.JWTAllowAllData() {
-		hasPermission, err := h.db.CheckFilePermission(c.Request.Context(), fileID, authCtx.Datasets)
+		hasPermission, err := h.db.CheckFilePermission(c.Request.Context(), fileID, datasets(authCtx))
 		if err != nil {
 			log.Errorf("failed to check file permission: %v", err)
 			problemJSON(c, http.StatusInternalServerError, "failed to check file permission")
@@ -122,8 +135,11 @@ func (h *Handlers) resolveFileBase(c *gin.Context) (*resolvedBase, bool) {
 }
 
 // resolveFileForDownload wraps resolveFileBase, extracts a public key,
-// and invokes gRPC re-encryption.
-func (h *Handlers) resolveFileForDownload(c *gin.Context) (*resolvedFile, bool) {
+// and invokes gRPC re-encryption. With withCoordinates, a byte range of the
+// decrypted file selected by the startCoordinate and endCoordinate query
+// parameters is resolved to the segments holding it, and the re-encrypted
+// header carries a data edit list trimming the segments to the range.
+func (h *Handlers) resolveFileForDownload(c *gin.Context, withCoordinates bool) (*resolvedFile, bool) {
 	// Extract public key from headers
 	publicKey, errorCode, detail := extractPublicKey(c)
 	if errorCode != "" {
@@ -145,6 +161,22 @@ func (h *Handlers) resolveFileForDownload(c *gin.Context) (*resolvedFile, bool)
 		return nil, false
 	}
 
+	var coordinates *streaming.ByteRange
+	if withCoordinates {
+		var err error
+		coordinates, err = streaming.ParseCoordinates(c.Query("startCoordinate"), c.Query("endCoordinate"), streaming.DecryptedSize(base.file.ArchiveSize))
+		if errors.Is(err, streaming.ErrRangeInvalid) {
+			problemJSONWithCode(c, http.StatusBadRequest, "startCoordinate and endCoordinate must select a non-empty byte range", "COORDINATES_INVALID")
+
+			return nil, false
+		}
+		if errors.Is(err, streaming.ErrRangeNotSatisfiable) {
+			problemJSON(c, http.StatusRequestedRangeNotSatisfiable, "coordinates not satisfiable")
+
+			return nil, false
+		}
+	}
+
 	// Re-encrypt header
 	if h.reencryptClient == nil {
 		log.Error("reencrypt client not configured")
@@ -153,7 +185,17 @@ func (h *Handlers) resolveFileForDownload(c *gin.Context) (*resolvedFile, bool)
 		return nil, false
 	}
 
-	newHeader, err := h.reencryptClient.ReencryptHeader(c.Request.Context(), base.file.Header, publicKey)
+	var newHeader []byte
+	var segments *streaming.ByteRange
+	var err error
+	if coordinates == nil {
+		newHeader, err = h.reencryptClient.ReencryptHeader(c.Request.Context(), base.file.Header, publicKey)
+	} else {
+		// A single byte range is held by a single run of segments
+		selection := streaming.SelectSegments([]streaming.ByteRange{*coordinates}, base.file.ArchiveSize)
+		segments = &selection.Ranges[0]
+		newHeader, err = h.reencryptClient.ReencryptHeaderWithEditList(c.Request.Context(), base.file.Header, publicKey, selection.EditList)
+	}
 	if err != nil {
 		log.Errorf("failed to reencrypt header: %v", err)
 		problemJSON(c, http.StatusInternalServerError, "failed to prepare file for download")
@@ -170,6 +212,7 @@ func (h *Handlers) resolveFileForDownload(c *gin.Context) (*resolvedFile, bool)
 		publicKey:    publicKey,
 		newHeader:    newHeader,
 		etag:         etag,
+		segments:     segments,
 	}, true
 }
 
@@ -187,7 +230,7 @@ func contentDisposition(submittedPath string) string {
 // DownloadFile handles file download by stable ID.
 // GET /files/:fileId
 func (h *Handlers) DownloadFile(c *gin.Context) {
-	resolved, ok := h.resolveFileForDownload(c)
+	resolved, ok := h.resolveFileForDownload(c, true)
 	if !ok {
 		return
 	}
@@ -212,6 +255,12 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 		return
 	}
 
+	if resolved.segments != nil {
+		h.streamSegments(c, resolved, fileReader)
+
+		return
+	}
+
 	// Calculate total size
 	newHeaderSize := int64(len(resolved.newHeader))
 	totalSize := newHeaderSize + file.ArchiveSize
@@ -257,6 +306,12 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 		Range:              rangeSpec,
 	})
 	if err != nil {
+		if errors.Is(err, storageerrors.ErrorChecksumMismatch) {
+			log.Errorf("archived file does not match its checksum, file: %s, reason: %v", file.ID, err)
+			h.auditFailed(c, resolved.authCtx, file, "checksum mismatch")
+
+			return
+		}
 		log.Errorf("error streaming file: %v", err)
 		h.auditFailed(c, resolved.authCtx, file, "streaming error")
 
@@ -267,6 +322,44 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventCompleted,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    c.Writer.Status(),
+	})
+}
+
+// streamSegments serves the segments holding a byte range of the decrypted file, selected by
+// the startCoordinate and endCoordinate query parameters, as a crypt4gh file of its own.
+// Range requests are not supported on top of coordinates.
+func (h *Handlers) streamSegments(c *gin.Context, resolved *resolvedFile, fileReader io.ReadSeekCloser) {
+	file := resolved.file
+
+	if c.GetHeader("Range") != "" {
+		fileReader.Close()
+		problemJSONWithCode(c, http.StatusBadRequest, "Range can not be combined with startCoordinate and endCoordinate", "RANGE_CONFLICT")
+
+		return
+	}
+
+	c.Header("ETag", resolved.etag)
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", contentDisposition(file.SubmittedPath))
+	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
+
+	if err := streaming.StreamSegments(c.Writer, resolved.newHeader, fileReader, *resolved.segments); err != nil {
+		log.Errorf("error streaming file segments: %v", err)
+		h.auditFailed(c, resolved.authCtx, file, "streaming error")
+
+		return
+	}
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventCompleted,
+		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -278,20 +371,25 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 // HeadFile handles HEAD requests for file metadata.
 // HEAD /files/:fileId
 func (h *Handlers) HeadFile(c *gin.Context) {
-	resolved, ok := h.resolveFileForDownload(c)
+	resolved, ok := h.resolveFileForDownload(c, true)
 	if !ok {
 		return
 	}
 
 	file := resolved.file
 
-	// Calculate total size: re-encrypted header + archive body
+	// Calculate total size: re-encrypted header + archive body, or the selected segments of it
 	totalSize := int64(len(resolved.newHeader)) + file.ArchiveSize
+	if resolved.segments != nil {
+		totalSize = int64(len(resolved.newHeader)) + resolved.segments.End - resolved.segments.Start
+	}
 
 	// Set response headers
 	c.Header("Content-Length", fmt.Sprintf("%d", totalSize))
 	c.Header("ETag", resolved.etag)
-	c.Header("Accept-Ranges", "bytes")
+	if resolved.segments == nil {
+		c.Header("Accept-Ranges", "bytes")
+	}
 	c.Header("Content-Disposition", contentDisposition(file.SubmittedPath))
 	c.Header("Content-Type", "application/octet-stream")
 	c.Header("Cache-Control", "private, max-age=60, must-revalidate")
@@ -331,7 +429,7 @@ func (h *Handlers) resolveFileForContent(c *gin.Context) (*resolvedContentFile,
 // GetFileHeader handles requests for the re-encrypted file header only.
 // GET /files/:fileId/header
 func (h *Handlers) GetFileHeader(c *gin.Context) {
-	resolved, ok := h.resolveFileForDownload(c)
+	resolved, ok := h.resolveFileForDownload(c, false)
 	if !ok {
 		return
 	}
@@ -349,6 +447,7 @@ func (h *Handlers) GetFileHeader(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventHeader,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -360,7 +459,7 @@ func (h *Handlers) GetFileHeader(c *gin.Context) {
 // HeadFileHeader handles HEAD requests for the file header metadata.
 // HEAD /files/:fileId/header
 func (h *Handlers) HeadFileHeader(c *gin.Context) {
-	resolved, ok := h.resolveFileForDownload(c)
+	resolved, ok := h.resolveFileForDownload(c, false)
 	if !ok {
 		return
 	}
@@ -440,6 +539,12 @@ func (h *Handlers) GetFileContent(c *gin.Context) {
 		Range:           rangeSpec,
 	})
 	if err != nil {
+		if errors.Is(err, storageerrors.ErrorChecksumMismatch) {
+			log.Errorf("archived file does not match its checksum, file: %s, reason: %v", file.ID, err)
+			h.auditFailed(c, resolved.authCtx, file, "checksum mismatch")
+
+			return
+		}
 		log.Errorf("error streaming file content: %v", err)
 		h.auditFailed(c, resolved.authCtx, file, "streaming error")
 
@@ -450,6 +555,7 @@ func (h *Handlers) GetFileContent(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventContent,
 		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
 		FileID:        file.ID,
 		DatasetID:     file.DatasetID,
 		CorrelationID: c.GetString("correlationId"),
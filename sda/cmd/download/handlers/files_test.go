@@ -210,6 +210,51 @@ func TestDownloadFile_PublicKeyConflict(t *testing.T) {
 	assert.Equal(t, "KEY_CONFLICT", response.ErrorCode)
 }
 
+func TestDownloadFile_InvalidCoordinates(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		status   int
+		errorKey string
+	}{
+		{"startCoordinate=20&endCoordinate=10", http.StatusBadRequest, "COORDINATES_INVALID"},
+		{"startCoordinate=abc", http.StatusBadRequest, "COORDINATES_INVALID"},
+		{"startCoordinate=-1&endCoordinate=10", http.StatusBadRequest, "COORDINATES_INVALID"},
+		{"startCoordinate=5000", http.StatusRequestedRangeNotSatisfiable, ""},
+	} {
+		router := setupTestRouterWithAuth([]string{"test-dataset"})
+		mockDB := &mockDatabase{
+			hasPermission: true,
+			fileByID: &database.File{
+				ID:              "test-file",
+				Header:          make([]byte, 20),
+				ArchivePath:     "/archive/test.c4gh",
+				ArchiveLocation: "s3:9000/archive",
+				ArchiveSize:     1028,
+			},
+		}
+		h, err := New(WithDatabase(mockDB))
+		require.NoError(t, err)
+
+		router.GET("/files/:fileId", h.DownloadFile)
+		router.HEAD("/files/:fileId", h.HeadFile)
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			req, _ := http.NewRequest(method, "/files/test-file?"+tc.query, nil)
+			req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code, "%s %s", method, tc.query)
+			if method == http.MethodGet && tc.errorKey != "" {
+				var response ProblemDetails
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tc.errorKey, response.ErrorCode, tc.query)
+			}
+		}
+	}
+}
+
 // HeadFile tests
 
 func TestHeadFile_MissingPublicKey(t *testing.T) {
@@ -2,14 +2,18 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
 
 	"github.com/gin-gonic/gin"
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
+	"github.com/neicnordic/crypt4gh/keys"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	storage "github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 )
 
@@ -25,6 +29,15 @@ type Handlers struct {
 	serviceID       string
 	serviceOrgName  string
 	serviceOrgURL   string
+
+	// The key pair the service decrypts files with, to resolve htsget regions
+	// and to stream decrypted files
+	servicePublicKey  string
+	servicePrivateKey [32]byte
+
+	// decryptedClients holds the common names of the client certificates
+	// allowed to stream decrypted files, nil when decrypted streaming is disabled
+	decryptedClients []string
 }
 
 // New creates a new Handlers instance with the given options.
@@ -42,6 +55,17 @@ func New(options ...func(*Handlers)) (*Handlers, error) {
 		return nil, errors.New("database is required")
 	}
 
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate service key pair: %w", err)
+	}
+	var pem bytes.Buffer
+	if err := keys.WriteCrypt4GHX25519PublicKey(&pem, publicKey); err != nil {
+		return nil, fmt.Errorf("failed to encode service public key: %w", err)
+	}
+	h.servicePublicKey = base64.StdEncoding.EncodeToString(pem.Bytes())
+	h.servicePrivateKey = privateKey
+
 	return h, nil
 }
 
@@ -51,6 +75,7 @@ func (h *Handlers) auditDenied(c *gin.Context) {
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventDenied,
 		UserID:        authCtx.Subject,
+		AuthType:      authCtx.AuthSource,
 		CorrelationID: c.GetString("correlationId"),
 		Path:          c.Request.URL.Path,
 		HTTPStatus:    c.Writer.Status(),
@@ -68,6 +93,7 @@ func (h *Handlers) auditFailed(c *gin.Context, authCtx middleware.AuthContext, f
 	h.auditLogger.Log(c.Request.Context(), audit.Event{
 		Event:         audit.EventFailed,
 		UserID:        authCtx.Subject,
+		AuthType:      authCtx.AuthSource,
 		FileID:        fileID,
 		DatasetID:     datasetID,
 		CorrelationID: c.GetString("correlationId"),
@@ -99,6 +125,7 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 		datasets.GET("", h.ListDatasets)
 		datasets.GET("/:datasetId", h.GetDataset)
 		datasets.GET("/:datasetId/files", h.ListDatasetFiles)
+		datasets.POST("/:datasetId/archive", h.DownloadDatasetArchive)
 	}
 
 	// Files (auth required)
@@ -107,10 +134,27 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 	{
 		files.HEAD("/:fileId", h.HeadFile)
 		files.GET("/:fileId", h.DownloadFile)
+		files.POST("/:fileId/url", h.CreateSignedURL)
 		files.HEAD("/:fileId/header", h.HeadFileHeader)
 		files.GET("/:fileId/header", h.GetFileHeader)
 		files.HEAD("/:fileId/content", h.HeadFileContent)
 		files.GET("/:fileId/content", h.GetFileContent)
+		if h.decryptedClients != nil {
+			files.HEAD("/:fileId/decrypted", h.HeadDecryptedFile)
+			files.GET("/:fileId/decrypted", h.GetDecryptedFile)
+		}
+	}
+
+	// htsget (auth required)
+	reads := r.Group("/reads")
+	reads.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
+	{
+		reads.GET("/:fileId", h.GetReads)
+	}
+	variants := r.Group("/variants")
+	variants.Use(middleware.TokenMiddleware(h.db, h.visaValidator, h.auditLogger))
+	{
+		variants.GET("/:fileId", h.GetVariants)
 	}
 
 	// DRS objects (auth required)
@@ -119,4 +163,18 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 	{
 		objects.GET("/*path", h.GetDrsObject)
 	}
+
+	// GA4GH DRS 1.2 (auth required, by token or by passports in POST bodies)
+	drs := r.Group("/ga4gh/drs/v1")
+	{
+		drs.GET("/service-info", h.ServiceInfo)
+	}
+	drsObjects := drs.Group("/objects")
+	drsObjects.Use(middleware.PassportMiddleware(h.db, h.visaValidator, h.auditLogger))
+	{
+		drsObjects.GET("/:objectId", h.GetDrsObjectByID)
+		drsObjects.POST("/:objectId", h.GetDrsObjectByID)
+		drsObjects.GET("/:objectId/access/:accessId", h.GetDrsAccessURL)
+		drsObjects.POST("/:objectId/access/:accessId", h.GetDrsAccessURL)
+	}
 }
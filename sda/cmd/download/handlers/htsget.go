@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	crypt4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/htsget"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// htsgetContentType is the media type of htsget tickets and errors.
+const htsgetContentType = "application/vnd.ga4gh.htsget.v1.3.0+json"
+
+// htsgetFormat describes a file format served by the htsget endpoints.
+type htsgetFormat struct {
+	name           string
+	extension      string
+	indexExtension string
+}
+
+var (
+	formatBAM  = htsgetFormat{name: "BAM", extension: ".bam", indexExtension: ".bai"}
+	formatCRAM = htsgetFormat{name: "CRAM", extension: ".cram", indexExtension: ".crai"}
+	formatVCF  = htsgetFormat{name: "VCF", extension: ".vcf.gz", indexExtension: ".tbi"}
+)
+
+// htsgetReadFormats and htsgetVariantFormats are the formats served by /reads and /variants.
+var (
+	htsgetReadFormats    = []htsgetFormat{formatBAM, formatCRAM}
+	htsgetVariantFormats = []htsgetFormat{formatVCF}
+)
+
+// HtsgetResponse is a GA4GH htsget ticket.
+type HtsgetResponse struct {
+	Htsget HtsgetTicket `json:"htsget"`
+}
+
+// HtsgetTicket lists the URLs which concatenated make up the requested data.
+type HtsgetTicket struct {
+	Format string      `json:"format"`
+	URLs   []HtsgetURL `json:"urls"`
+}
+
+// HtsgetURL is a URL of a htsget ticket, with the headers to send when fetching it.
+type HtsgetURL struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// htsgetErrorResponse is a GA4GH htsget error.
+type htsgetErrorResponse struct {
+	Htsget struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	} `json:"htsget"`
+}
+
+// htsgetError sends a htsget error response.
+func htsgetError(c *gin.Context, status int, errorType, message string) {
+	var response htsgetErrorResponse
+	response.Htsget.Error = errorType
+	response.Htsget.Message = message
+
+	c.Header("Content-Type", htsgetContentType)
+	c.JSON(status, response)
+}
+
+// htsgetQuery holds the parameters of a htsget request.
+type htsgetQuery struct {
+	format        string
+	class         string
+	referenceName string
+	start         int64
+	end           int64
+}
+
+// parseHtsgetQuery validates the query parameters of a htsget request.
+// Returns the error type and message of the htsget error to send if invalid.
+func parseHtsgetQuery(c *gin.Context) (htsgetQuery, string, string) {
+	query := htsgetQuery{
+		format:        strings.ToUpper(c.Query("format")),
+		class:         c.Query("class"),
+		referenceName: c.Query("referenceName"),
+	}
+
+	if query.class != "" && query.class != "header" {
+		return query, "InvalidInput", fmt.Sprintf("unsupported class: %s", query.class)
+	}
+	if query.class == "header" && query.referenceName != "" {
+		return query, "InvalidInput", "referenceName can not be combined with class header"
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{{"start", &query.start}, {"end", &query.end}} {
+		raw, ok := c.GetQuery(param.name)
+		if !ok {
+			continue
+		}
+		if query.referenceName == "" || query.referenceName == "*" {
+			return query, "InvalidInput", fmt.Sprintf("%s requires a referenceName", param.name)
+		}
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 0 {
+			return query, "InvalidInput", fmt.Sprintf("%s must be a non-negative integer", param.name)
+		}
+		*param.value = value
+	}
+	if query.end > 0 && query.start > query.end {
+		return query, "InvalidRange", "start must not be greater than end"
+	}
+
+	return query, "", ""
+}
+
+// fileFormat returns the format of a file among the formats, judged by the extension of the submitted path.
+func fileFormat(submittedPath string, formats []htsgetFormat) (htsgetFormat, bool) {
+	path := strings.ToLower(strings.TrimSuffix(submittedPath, ".c4gh"))
+	for _, format := range formats {
+		if strings.HasSuffix(path, format.extension) {
+			return format, true
+		}
+	}
+
+	return htsgetFormat{}, false
+}
+
+// indexPaths returns the submitted paths the index of a file may be stored at,
+// in order of preference: file.bam.bai before file.bai, encrypted as the file.
+func indexPaths(submittedPath string, format htsgetFormat) []string {
+	path, encrypted := strings.CutSuffix(submittedPath, ".c4gh")
+	names := []string{path + format.indexExtension}
+	if stem := path[:len(path)-len(format.extension)]; stem+format.indexExtension != names[0] {
+		names = append(names, stem+format.indexExtension)
+	}
+
+	var paths []string
+	for _, name := range names {
+		if encrypted {
+			paths = append(paths, name+".c4gh")
+		}
+		paths = append(paths, name)
+	}
+
+	return paths
+}
+
+// GetReads handles htsget requests for alignment data.
+// GET /reads/:fileId
+func (h *Handlers) GetReads(c *gin.Context) {
+	h.serveHtsget(c, htsgetReadFormats)
+}
+
+// GetVariants handles htsget requests for variant data.
+// GET /variants/:fileId
+func (h *Handlers) GetVariants(c *gin.Context) {
+	h.serveHtsget(c, htsgetVariantFormats)
+}
+
+// serveHtsget returns a htsget ticket for a region of an indexed file.
+//
+// The first URL of the ticket is a data URI holding the crypt4gh header,
+// re-encrypted for the public key of the request. The following URLs fetch
+// the data segments holding the region from /files/:fileId/content. The
+// header carries a data edit list trimming the decrypted segments to the
+// region, so the concatenated URLs make up a crypt4gh file which decrypts to
+// a valid file of the requested format.
+func (h *Handlers) serveHtsget(c *gin.Context, formats []htsgetFormat) {
+	query, errorType, message := parseHtsgetQuery(c)
+	if errorType != "" {
+		htsgetError(c, http.StatusBadRequest, errorType, message)
+
+		return
+	}
+
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	base, ok := h.resolveFileBase(c)
+	if !ok {
+		return
+	}
+	file := base.file
+
+	format, ok := fileFormat(file.SubmittedPath, formats)
+	if !ok || (query.format != "" && query.format != format.name) {
+		htsgetError(c, http.StatusBadRequest, "UnsupportedFormat", "the file is not available in the requested format")
+
+		return
+	}
+	if query.referenceName == "*" && format == formatVCF {
+		htsgetError(c, http.StatusBadRequest, "InvalidInput", "variants without a reference are not supported")
+
+		return
+	}
+
+	if len(file.Header) == 0 {
+		log.Errorf("file %s has no header", file.ID)
+		problemJSON(c, http.StatusInternalServerError, "file header not available")
+
+		return
+	}
+	if h.reencryptClient == nil || h.storageReader == nil {
+		log.Error("reencrypt client or storage reader not configured")
+		problemJSON(c, http.StatusInternalServerError, "htsget not configured")
+
+		return
+	}
+
+	ticket := HtsgetTicket{Format: format.name}
+	contentURL := fmt.Sprintf("%s/files/%s/content", requestBaseURL(c), file.ID)
+	headers := map[string]string{}
+	if authorization := c.GetHeader("Authorization"); authorization != "" {
+		headers["Authorization"] = authorization
+	}
+
+	var newHeader []byte
+	var err error
+	if query.referenceName == "" && query.class == "" {
+		// The whole file, no index needed
+		newHeader, err = h.reencryptClient.ReencryptHeader(c.Request.Context(), file.Header, publicKey)
+		ticket.URLs = []HtsgetURL{{URL: contentURL, Headers: headers}}
+	} else {
+		var ranges []streaming.ByteRange
+		ranges, err = h.htsgetRanges(c.Request.Context(), file, base.location, format, query)
+		if errors.Is(err, errHtsgetNotFound) {
+			htsgetError(c, http.StatusNotFound, "NotFound", err.Error())
+
+			return
+		}
+		if err != nil {
+			log.Errorf("failed to resolve htsget request for file %s: %v", file.ID, err)
+			problemJSON(c, http.StatusInternalServerError, "failed to resolve region")
+			h.auditFailed(c, base.authCtx, file, "failed to resolve region")
+
+			return
+		}
+
+		selection := streaming.SelectSegments(ranges, file.ArchiveSize)
+		newHeader, err = h.reencryptClient.ReencryptHeaderWithEditList(c.Request.Context(), file.Header, publicKey, selection.EditList)
+		for _, r := range selection.Ranges {
+			rangeHeaders := map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1)}
+			for key, value := range headers {
+				rangeHeaders[key] = value
+			}
+			ticket.URLs = append(ticket.URLs, HtsgetURL{URL: contentURL, Headers: rangeHeaders})
+		}
+	}
+	if err != nil {
+		log.Errorf("failed to reencrypt header: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to prepare file for download")
+		h.auditFailed(c, base.authCtx, file, "failed to reencrypt header")
+
+		return
+	}
+
+	headerURL := HtsgetURL{URL: "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(newHeader)}
+	ticket.URLs = append([]HtsgetURL{headerURL}, ticket.URLs...)
+
+	c.Header("Content-Type", htsgetContentType)
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, HtsgetResponse{Htsget: ticket})
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventHtsget,
+		UserID:        base.authCtx.Subject,
+		AuthType:      base.authCtx.AuthSource,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    c.Writer.Status(),
+	})
+}
+
+// errHtsgetNotFound is returned when the index of a file, or a reference, is not found.
+var errHtsgetNotFound = errors.New("not found")
+
+// htsgetRanges resolves the region of the query to byte ranges of the
+// decrypted file, using the index stored alongside the file. The ranges
+// start with the file header and, unless only the header is requested, end
+// with the end-of-file marker.
+func (h *Handlers) htsgetRanges(ctx context.Context, file *database.File, location string, format htsgetFormat, query htsgetQuery) ([]streaming.ByteRange, error) {
+	indexFile, err := h.findIndex(ctx, file, format)
+	if err != nil {
+		return nil, err
+	}
+	if indexFile == nil {
+		return nil, fmt.Errorf("%w: no index found for the file", errHtsgetNotFound)
+	}
+
+	index, err := h.openDecrypted(ctx, indexFile, indexFile.ArchiveLocation)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	size := streaming.DecryptedSize(file.ArchiveSize)
+
+	var header, eof streaming.ByteRange
+	var references []string
+	// region returns the ranges of the region on the reference, -1 for records without a reference
+	var region func(refID int) []streaming.ByteRange
+	switch format {
+	case formatCRAM:
+		idx, err := htsget.ReadCRAI(index)
+		if err != nil {
+			return nil, err
+		}
+		cram, err := readHeader(ctx, h, file, location, htsget.ReadCRAMHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		references = cram.References
+		eof = streaming.ByteRange{Start: size - cram.EOFSize(), End: size}
+		header = streaming.ByteRange{Start: 0, End: idx.HeaderEnd(eof.Start)}
+		region = func(refID int) []streaming.ByteRange {
+			return idx.Ranges(refID, query.start, query.end, eof.Start)
+		}
+	default:
+		var idx *htsget.BinningIndex
+		if format == formatBAM {
+			if idx, err = htsget.ReadBAI(index); err != nil {
+				return nil, err
+			}
+			if references, err = readHeader(ctx, h, file, location, htsget.ReadBAMReferences); err != nil {
+				return nil, err
+			}
+		} else {
+			if idx, err = htsget.ReadTabix(index); err != nil {
+				return nil, err
+			}
+			references = idx.Names
+		}
+
+		eof = idx.EOF(size)
+		header = streaming.ByteRange{Start: 0, End: idx.HeaderEnd(size)}
+		region = func(refID int) []streaming.ByteRange {
+			if refID < 0 {
+				return idx.Unmapped(size)
+			}
+
+			return idx.Ranges(refID, query.start, query.end, size)
+		}
+	}
+
+	if query.class == "header" {
+		return []streaming.ByteRange{header}, nil
+	}
+
+	refID := -1
+	if query.referenceName != "*" {
+		if refID = slices.Index(references, query.referenceName); refID < 0 {
+			return nil, fmt.Errorf("%w: reference %s", errHtsgetNotFound, query.referenceName)
+		}
+	}
+
+	ranges := append([]streaming.ByteRange{header}, region(refID)...)
+
+	return streaming.MergeRanges(append(ranges, eof)), nil
+}
+
+// findIndex returns the index file stored alongside the file in its dataset, or nil if there is none.
+func (h *Handlers) findIndex(ctx context.Context, file *database.File, format htsgetFormat) (*database.File, error) {
+	for _, path := range indexPaths(file.SubmittedPath, format) {
+		index, err := h.db.GetFileByPath(ctx, file.DatasetID, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up index %s: %w", path, err)
+		}
+		if index == nil || len(index.Header) == 0 || index.ArchivePath == "" {
+			continue
+		}
+		if index.ArchiveLocation == "" {
+			index.ArchiveLocation, err = h.storageReader.FindFile(ctx, index.ArchivePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find index in storage: %w", err)
+			}
+		}
+
+		return index, nil
+	}
+
+	return nil, nil
+}
+
+// readHeader reads the header of the decrypted file with read, the rest of the file is not read.
+func readHeader[T any](ctx context.Context, h *Handlers, file *database.File, location string, read func(io.Reader) (T, error)) (T, error) {
+	decrypted, err := h.openDecrypted(ctx, file, location)
+	if err != nil {
+		var zero T
+
+		return zero, err
+	}
+	defer decrypted.Close()
+
+	return read(decrypted)
+}
+
+// readCloser combines a reader and the closer of its source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openDecrypted opens an archived file for decryption by the service. The
+// header of the file is re-encrypted for the key pair of the service, which
+// only ever decrypts the indexes and headers needed to resolve regions.
+func (h *Handlers) openDecrypted(ctx context.Context, file *database.File, location string) (io.ReadCloser, error) {
+	header, err := h.reencryptClient.ReencryptHeader(ctx, file.Header, h.servicePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reencrypt header of %s: %w", file.ID, err)
+	}
+
+	body, err := h.storageReader.NewFileReader(ctx, location, file.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.ID, err)
+	}
+
+	decrypted, err := crypt4ghstreaming.NewCrypt4GHReader(readCloser{io.MultiReader(bytes.NewReader(header), body), body}, h.servicePrivateKey, nil)
+	if err != nil {
+		body.Close()
+
+		return nil, fmt.Errorf("failed to decrypt %s: %w", file.ID, err)
+	}
+
+	return decrypted, nil
+}
+
+// requestBaseURL returns the scheme and host the request was made to.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "https"
+	if c.Request.TLS == nil {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHtsgetQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query     string
+		errorType string
+		expected  htsgetQuery
+	}{
+		{"", "", htsgetQuery{}},
+		{"format=bam&referenceName=chr1&start=10&end=20", "", htsgetQuery{format: "BAM", referenceName: "chr1", start: 10, end: 20}},
+		{"referenceName=*", "", htsgetQuery{referenceName: "*"}},
+		{"class=header", "", htsgetQuery{class: "header"}},
+		{"class=body", "InvalidInput", htsgetQuery{}},
+		{"class=header&referenceName=chr1", "InvalidInput", htsgetQuery{}},
+		{"start=10", "InvalidInput", htsgetQuery{}},
+		{"referenceName=*&end=10", "InvalidInput", htsgetQuery{}},
+		{"referenceName=chr1&start=-1", "InvalidInput", htsgetQuery{}},
+		{"referenceName=chr1&start=abc", "InvalidInput", htsgetQuery{}},
+		{"referenceName=chr1&start=20&end=10", "InvalidRange", htsgetQuery{}},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/reads/file?"+tc.query, nil)
+
+		query, errorType, _ := parseHtsgetQuery(c)
+		assert.Equal(t, tc.errorType, errorType, "query: %s", tc.query)
+		if tc.errorType == "" {
+			assert.Equal(t, tc.expected, query, "query: %s", tc.query)
+		}
+	}
+}
+
+func TestFileFormat(t *testing.T) {
+	format, ok := fileFormat("dir/sample.BAM.c4gh", htsgetReadFormats)
+	assert.True(t, ok)
+	assert.Equal(t, formatBAM, format)
+
+	format, ok = fileFormat("sample.cram", htsgetReadFormats)
+	assert.True(t, ok)
+	assert.Equal(t, formatCRAM, format)
+
+	_, ok = fileFormat("sample.vcf.gz.c4gh", htsgetReadFormats)
+	assert.False(t, ok)
+
+	format, ok = fileFormat("sample.vcf.gz.c4gh", htsgetVariantFormats)
+	assert.True(t, ok)
+	assert.Equal(t, formatVCF, format)
+}
+
+func TestIndexPaths(t *testing.T) {
+	assert.Equal(t,
+		[]string{"dir/sample.bam.bai.c4gh", "dir/sample.bam.bai", "dir/sample.bai.c4gh", "dir/sample.bai"},
+		indexPaths("dir/sample.bam.c4gh", formatBAM))
+	assert.Equal(t,
+		[]string{"sample.cram.crai", "sample.crai"},
+		indexPaths("sample.cram", formatCRAM))
+	assert.Equal(t,
+		[]string{"sample.vcf.gz.tbi.c4gh", "sample.vcf.gz.tbi", "sample.tbi.c4gh", "sample.tbi"},
+		indexPaths("sample.vcf.gz.c4gh", formatVCF))
+}
+
+// htsgetTestHandlers returns handlers serving the file, without a reencrypt client.
+func htsgetTestHandlers(t *testing.T, submittedPath string) *Handlers {
+	t.Helper()
+	mockDB := &mockDatabase{
+		hasPermission: true,
+		fileByID: &database.File{
+			ID:              "test-file-id",
+			DatasetID:       "test-dataset",
+			SubmittedPath:   submittedPath,
+			ArchivePath:     "archive/test-file-id",
+			ArchiveLocation: "/archive",
+			ArchiveSize:     1000,
+			Header:          []byte("header"),
+		},
+	}
+	h, err := New(WithDatabase(mockDB), WithStorageReader(&mockStorageReader{}))
+	require.NoError(t, err)
+
+	return h
+}
+
+func TestGetReads_InvalidInput(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	h := htsgetTestHandlers(t, "sample.bam.c4gh")
+	router.GET("/reads/:fileId", h.GetReads)
+
+	req, _ := http.NewRequest(http.MethodGet, "/reads/test-file-id?start=10", nil)
+	req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, htsgetContentType, w.Header().Get("Content-Type"))
+
+	var response htsgetErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "InvalidInput", response.Htsget.Error)
+}
+
+func TestGetReads_MissingPublicKey(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	h := htsgetTestHandlers(t, "sample.bam.c4gh")
+	router.GET("/reads/:fileId", h.GetReads)
+
+	req, _ := http.NewRequest(http.MethodGet, "/reads/test-file-id?referenceName=chr1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response ProblemDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "KEY_MISSING", response.ErrorCode)
+}
+
+func TestGetReads_UnsupportedFormat(t *testing.T) {
+	for _, tc := range []struct {
+		path          string
+		submittedPath string
+	}{
+		{"/reads/test-file-id", "sample.vcf.gz.c4gh"},
+		{"/reads/test-file-id?format=CRAM", "sample.bam.c4gh"},
+		{"/variants/test-file-id", "sample.bam.c4gh"},
+		{"/variants/test-file-id?format=BCF", "sample.vcf.gz.c4gh"},
+	} {
+		router := setupTestRouterWithAuth([]string{"test-dataset"})
+		h := htsgetTestHandlers(t, tc.submittedPath)
+		router.GET("/reads/:fileId", h.GetReads)
+		router.GET("/variants/:fileId", h.GetVariants)
+
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, tc.path)
+
+		var response htsgetErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "UnsupportedFormat", response.Htsget.Error, tc.path)
+	}
+}
+
+func TestGetReads_AccessDenied(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"other-dataset"})
+	h, err := New(WithDatabase(&mockDatabase{hasPermission: false}))
+	require.NoError(t, err)
+	router.GET("/reads/:fileId", h.GetReads)
+
+	req, _ := http.NewRequest(http.MethodGet, "/reads/test-file-id", nil)
+	req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestGetReads_ReencryptNotConfigured(t *testing.T) {
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	h := htsgetTestHandlers(t, "sample.bam.c4gh")
+	router.GET("/reads/:fileId", h.GetReads)
+
+	req, _ := http.NewRequest(http.MethodGet, "/reads/test-file-id?referenceName=chr1", nil)
+	req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
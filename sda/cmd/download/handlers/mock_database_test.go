@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"sync"
 
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 )
 
 // capturingLogger records audit events for test assertions.
@@ -28,10 +29,13 @@ type mockDatabase struct {
 	datasetInfo       *database.DatasetInfo
 	datasetFilesPaged []database.File
 	fileByID          *database.File
+	filesByID         map[string]*database.File // looked up by ID instead of fileByID when set
 	fileByPath        *database.File
 	hasPermission     bool
+	checkedDatasets   []string
 	datasetNotFound   bool
 	fileChecksums     []database.Checksum
+	datasetChecksums  map[string][]database.Checksum
 	err               error
 	pingErr           error
 }
@@ -76,10 +80,13 @@ func (m *mockDatabase) GetDatasetInfo(_ context.Context, _ string) (*database.Da
 	return m.datasetInfo, nil
 }
 
-func (m *mockDatabase) GetFileByID(_ context.Context, _ string) (*database.File, error) {
+func (m *mockDatabase) GetFileByID(_ context.Context, fileID string) (*database.File, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
+	if m.filesByID != nil {
+		return m.filesByID[fileID], nil
+	}
 
 	return m.fileByID, nil
 }
@@ -92,7 +99,8 @@ func (m *mockDatabase) GetFileByPath(_ context.Context, _, _ string) (*database.
 	return m.fileByPath, nil
 }
 
-func (m *mockDatabase) CheckFilePermission(_ context.Context, _ string, _ []string) (bool, error) {
+func (m *mockDatabase) CheckFilePermission(_ context.Context, _ string, datasets []string) (bool, error) {
+	m.checkedDatasets = datasets
 	if m.err != nil {
 		return false, m.err
 	}
@@ -116,6 +124,14 @@ func (m *mockDatabase) GetFileChecksums(_ context.Context, _ string, _ string) (
 	return m.fileChecksums, nil
 }
 
+func (m *mockDatabase) GetDatasetChecksums(_ context.Context, _ string, _ string) (map[string][]database.Checksum, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return m.datasetChecksums, nil
+}
+
 func (m *mockDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, _ database.FileListOptions) ([]database.File, error) {
 	if m.err != nil {
 		return nil, m.err
@@ -127,9 +143,14 @@ func (m *mockDatabase) GetDatasetFilesPaginated(_ context.Context, _ string, _ d
 // mockStorageReader is a mock implementation of storage.Reader for testing.
 type mockStorageReader struct {
 	pingErr error
+	files   map[string][]byte // file contents by path
 }
 
-func (m *mockStorageReader) NewFileReader(_ context.Context, _, _ string) (io.ReadCloser, error) {
+func (m *mockStorageReader) NewFileReader(_ context.Context, _, filePath string) (io.ReadCloser, error) {
+	if data, ok := m.files[filePath]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
 	return nil, nil
 }
 
@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	storage "github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 )
 
@@ -52,6 +52,14 @@ func WithServiceInfo(id, orgName, orgURL string) func(*Handlers) {
 	}
 }
 
+// WithDecryptedStreaming enables streaming of decrypted files to clients
+// presenting a verified certificate with one of the common names.
+func WithDecryptedStreaming(allowedClients []string) func(*Handlers) {
+	return func(h *Handlers) {
+		h.decryptedClients = append([]string{}, allowedClients...)
+	}
+}
+
 // WithGRPCReencryptHost sets the gRPC reencrypt service host (deprecated, use WithReencryptClient).
 func WithGRPCReencryptHost(host string) func(*Handlers) {
 	return func(h *Handlers) {
@@ -1,17 +1,14 @@
 package handlers
 
 import (
-	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/signing"
 )
 
 type pageToken struct {
@@ -22,12 +19,9 @@ type pageToken struct {
 	Exp       int64  `json:"e"`           // Unix timestamp expiry
 }
 
-// paginationSecret holds the HMAC key. Set at startup.
-var paginationSecret []byte
-
-// SetPaginationSecret sets the HMAC signing key for page tokens.
+// SetPaginationSecret sets the HMAC signing key for page tokens and signed URLs.
 func SetPaginationSecret(secret []byte) {
-	paginationSecret = secret
+	signing.SetSecret(secret)
 }
 
 // parsePageSize validates the "pageSize" query parameter.
@@ -61,35 +55,19 @@ func encodePageToken(cursor, cursorID string, pageSize int, queryHash string) st
 		Exp:       time.Now().Add(1 * time.Hour).Unix(),
 	}
 
-	payload, _ := json.Marshal(tok)
-	sig := computeHMAC(payload)
+	token, _ := signing.Encode(tok)
 
-	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return token
 }
 
 // decodePageToken verifies the HMAC signature, checks expiry, and returns the parsed token.
 func decodePageToken(token string) (*pageToken, error) {
-	parts := strings.SplitN(token, ".", 2)
-	if len(parts) != 2 {
-		return nil, errors.New("malformed page token")
-	}
-
-	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return nil, errors.New("malformed page token")
-	}
-
-	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, errors.New("malformed page token")
-	}
-
-	if !hmac.Equal(computeHMAC(payload), sig) {
-		return nil, errors.New("invalid page token signature")
-	}
-
 	var tok pageToken
-	if err := json.Unmarshal(payload, &tok); err != nil {
+	if err := signing.Decode(token, &tok); err != nil {
+		if errors.Is(err, signing.ErrSignature) {
+			return nil, errors.New("invalid page token signature")
+		}
+
 		return nil, errors.New("malformed page token")
 	}
 
@@ -114,10 +92,7 @@ func queryFingerprint(parts ...string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))[:16]
 }
 
-// computeHMAC returns the HMAC-SHA256 of data using paginationSecret.
+// computeHMAC returns the HMAC-SHA256 of data with the signing key.
 func computeHMAC(data []byte) []byte {
-	mac := hmac.New(sha256.New, paginationSecret)
-	mac.Write(data)
-
-	return mac.Sum(nil)
+	return signing.MAC(data)
 }
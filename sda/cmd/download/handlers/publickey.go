@@ -1,14 +1,26 @@
 package handlers
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+)
 
 // extractPublicKey reads the client's crypt4gh public key from request headers.
-// Exactly one of X-C4GH-Public-Key or Htsget-Context-Public-Key must be present.
+// Exactly one of X-C4GH-Public-Key or Htsget-Context-Public-Key must be present,
+// unless the request is made to a signed URL, which is bound to a public key.
 // Returns the raw header value (base64-encoded string) or ("", errorCode, detail).
 func extractPublicKey(c *gin.Context) (string, string, string) {
 	primary := c.GetHeader("X-C4GH-Public-Key")
 	secondary := c.GetHeader("Htsget-Context-Public-Key")
 
+	if authCtx, ok := middleware.GetAuthContext(c); ok && authCtx.PublicKey != "" {
+		if (primary != "" && primary != authCtx.PublicKey) || (secondary != "" && secondary != authCtx.PublicKey) {
+			return "", "KEY_CONFLICT", "the public key does not match the key bound to the signed URL"
+		}
+
+		return authCtx.PublicKey, "", ""
+	}
+
 	switch {
 	case primary != "" && secondary != "":
 		return "", "KEY_CONFLICT", "only one of X-C4GH-Public-Key or Htsget-Context-Public-Key may be provided"
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,3 +57,25 @@ func TestExtractPublicKey_NeitherPresent(t *testing.T) {
 	assert.Equal(t, "KEY_MISSING", errCode)
 	assert.Contains(t, detail, "header is required")
 }
+
+func TestExtractPublicKey_SignedURL(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+	c.Set(middleware.ContextKey, middleware.AuthContext{AuthSource: "signed-url", PublicKey: "Ym91bmQta2V5"})
+
+	key, errCode, detail := extractPublicKey(c)
+	assert.Equal(t, "Ym91bmQta2V5", key)
+	assert.Empty(t, errCode)
+	assert.Empty(t, detail)
+
+	c.Request.Header.Set("X-C4GH-Public-Key", "Ym91bmQta2V5")
+	key, errCode, _ = extractPublicKey(c)
+	assert.Equal(t, "Ym91bmQta2V5", key)
+	assert.Empty(t, errCode)
+
+	c.Request.Header.Set("X-C4GH-Public-Key", "b3RoZXIta2V5")
+	key, errCode, _ = extractPublicKey(c)
+	assert.Empty(t, key)
+	assert.Equal(t, "KEY_CONFLICT", errCode)
+}
@@ -36,7 +36,7 @@ func (h *Handlers) ServiceInfo(c *gin.Context) {
 		Type: serviceInfoType{
 			Group:    "org.ga4gh",
 			Artifact: "drs",
-			Version:  "1.0.0",
+			Version:  "1.2.0",
 		},
 		Organization: serviceInfoOrg{
 			Name: h.serviceOrgName,
@@ -35,7 +35,7 @@ func TestServiceInfo_Response(t *testing.T) {
 	assert.Equal(t, "SDA Download", response.Name)
 	assert.Equal(t, "org.ga4gh", response.Type.Group)
 	assert.Equal(t, "drs", response.Type.Artifact)
-	assert.Equal(t, "1.0.0", response.Type.Version)
+	assert.Equal(t, "1.2.0", response.Type.Version)
 	assert.Equal(t, "CSC", response.Organization.Name)
 	assert.Equal(t, "https://csc.fi", response.Organization.URL)
 	assert.Equal(t, "2.0.0", response.Version)
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSignedURLExpiry is the lifetime of a signed URL when the request does not set one.
+const defaultSignedURLExpiry = time.Hour
+
+// maxSignedURLExpiry bounds the lifetime of a signed URL. Access is checked
+// when the URL is issued, so this is how long a revoked user may keep using it.
+const maxSignedURLExpiry = 24 * time.Hour
+
+// signedURLRequest is the request body of POST /files/:fileId/url.
+type signedURLRequest struct {
+	// ExpiresIn is the lifetime of the URL in seconds.
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// SignedURLResponse is the response body of POST /files/:fileId/url.
+type SignedURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// signedFileURL returns a URL of GET /files/:fileId signed for the user, valid until the returned time.
+func signedFileURL(c *gin.Context, authCtx middleware.AuthContext, file *database.File, publicKey string, expiry time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(expiry).Truncate(time.Second).UTC()
+
+	signature, err := middleware.SignURL(middleware.SignedURLClaims{
+		FileID:    file.ID,
+		DatasetID: file.DatasetID,
+		Issuer:    authCtx.Issuer,
+		Subject:   authCtx.Subject,
+		PublicKey: publicKey,
+		Exp:       expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	query := url.Values{middleware.SignedURLParam: {signature}}
+
+	return requestBaseURL(c) + "/files/" + url.PathEscape(file.ID) + "?" + query.Encode(), expiresAt, nil
+}
+
+// CreateSignedURL issues a time-limited URL downloading the file without a
+// token. The URL is bound to the file, the user and the public key of the
+// request, and supports Range requests so that downloads can be resumed.
+// POST /files/:fileId/url
+func (h *Handlers) CreateSignedURL(c *gin.Context) {
+	publicKey, errorCode, detail := extractPublicKey(c)
+	if errorCode != "" {
+		problemJSONWithCode(c, http.StatusBadRequest, detail, errorCode)
+
+		return
+	}
+
+	var request signedURLRequest
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, 1<<10)).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		problemJSON(c, http.StatusBadRequest, "invalid request body")
+
+		return
+	}
+
+	expiry := defaultSignedURLExpiry
+	if request.ExpiresIn != 0 {
+		if request.ExpiresIn < 0 || request.ExpiresIn > int64(maxSignedURLExpiry/time.Second) {
+			problemJSONWithCode(c, http.StatusBadRequest, "expiresIn must be between 1 and 86400 seconds", "EXPIRY_INVALID")
+
+			return
+		}
+		expiry = time.Duration(request.ExpiresIn) * time.Second
+	}
+
+	resolved, ok := h.resolveFileBase(c)
+	if !ok {
+		return
+	}
+
+	file := resolved.file
+	signedURL, expiresAt, err := signedFileURL(c, resolved.authCtx, file, publicKey, expiry)
+	if err != nil {
+		log.Errorf("failed to sign URL: %v", err)
+		problemJSON(c, http.StatusInternalServerError, "failed to sign URL")
+
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, SignedURLResponse{URL: signedURL, ExpiresAt: expiresAt})
+
+	h.auditLogger.Log(c.Request.Context(), audit.Event{
+		Event:         audit.EventSignedURL,
+		UserID:        resolved.authCtx.Subject,
+		AuthType:      resolved.authCtx.AuthSource,
+		FileID:        file.ID,
+		DatasetID:     file.DatasetID,
+		CorrelationID: c.GetString("correlationId"),
+		Path:          c.Request.URL.Path,
+		HTTPStatus:    c.Writer.Status(),
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/signing"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSignedURLTestHandlers(t *testing.T, hasPermission bool, logger audit.Logger) *Handlers {
+	t.Helper()
+	SetPaginationSecret([]byte("test-secret-key-for-signed-urls"))
+
+	mockDB := &mockDatabase{
+		hasPermission: hasPermission,
+		fileByID: &database.File{
+			ID:              "test-file",
+			DatasetID:       "test-dataset",
+			ArchivePath:     "/archive/test.c4gh",
+			ArchiveLocation: "s3:9000/archive",
+		},
+	}
+	h, err := New(WithDatabase(mockDB), WithAuditLogger(logger))
+	require.NoError(t, err)
+
+	return h
+}
+
+func TestCreateSignedURL(t *testing.T) {
+	logger := &capturingLogger{}
+	h := newSignedURLTestHandlers(t, true, logger)
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.POST("/files/:fileId/url", h.CreateSignedURL)
+
+	req, _ := http.NewRequest(http.MethodPost, "/files/test-file/url", bytes.NewBufferString(`{"expiresIn": 600}`))
+	req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var response SignedURLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), response.ExpiresAt, 5*time.Second)
+
+	signedURL, err := url.Parse(response.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/files/test-file", signedURL.Path)
+
+	var claims middleware.SignedURLClaims
+	require.NoError(t, signing.Decode(signedURL.Query().Get(middleware.SignedURLParam), &claims))
+	assert.Equal(t, "test-file", claims.FileID)
+	assert.Equal(t, "test-dataset", claims.DatasetID)
+	assert.Equal(t, "dGVzdC1wdWJsaWMta2V5", claims.PublicKey)
+	assert.Equal(t, response.ExpiresAt.Unix(), claims.Exp)
+
+	require.Len(t, logger.events, 1)
+	assert.Equal(t, audit.EventSignedURL, logger.events[0].Event)
+	assert.Equal(t, "test-file", logger.events[0].FileID)
+}
+
+func TestCreateSignedURL_DefaultExpiry(t *testing.T) {
+	h := newSignedURLTestHandlers(t, true, audit.NoopLogger{})
+	router := setupTestRouterWithAuth([]string{"test-dataset"})
+	router.POST("/files/:fileId/url", h.CreateSignedURL)
+
+	req, _ := http.NewRequest(http.MethodPost, "/files/test-file/url", http.NoBody)
+	req.Header.Set("X-C4GH-Public-Key", "dGVzdC1wdWJsaWMta2V5")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response SignedURLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.WithinDuration(t, time.Now().Add(defaultSignedURLExpiry), response.ExpiresAt, 5*time.Second)
+}
+
+func TestCreateSignedURL_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		body          string
+		publicKey     string
+		hasPermission bool
+		status        int
+		errorCode     string
+	}{
+		{"missing key", "", "", true, http.StatusBadRequest, "KEY_MISSING"},
+		{"invalid body", "{", "dGVzdC1wdWJsaWMta2V5", true, http.StatusBadRequest, ""},
+		{"negative expiry", `{"expiresIn": -1}`, "dGVzdC1wdWJsaWMta2V5", true, http.StatusBadRequest, "EXPIRY_INVALID"},
+		{"expiry too long", `{"expiresIn": 86401}`, "dGVzdC1wdWJsaWMta2V5", true, http.StatusBadRequest, "EXPIRY_INVALID"},
+		{"no access", "", "dGVzdC1wdWJsaWMta2V5", false, http.StatusForbidden, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newSignedURLTestHandlers(t, tc.hasPermission, audit.NoopLogger{})
+			router := setupTestRouterWithAuth([]string{"test-dataset"})
+			router.POST("/files/:fileId/url", h.CreateSignedURL)
+
+			req, _ := http.NewRequest(http.MethodPost, "/files/test-file/url", bytes.NewBufferString(tc.body))
+			if tc.publicKey != "" {
+				req.Header.Set("X-C4GH-Public-Key", tc.publicKey)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			if tc.errorCode != "" {
+				var response ProblemDetails
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tc.errorCode, response.ErrorCode)
+			}
+		})
+	}
+}
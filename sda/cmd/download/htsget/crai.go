@@ -0,0 +1,125 @@
+package htsget
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+)
+
+// unmappedRefID is the reference id of records without a reference.
+const unmappedRefID = -1
+
+// CRAIEntry is an entry of a CRAM index, a slice holding records of a reference.
+type CRAIEntry struct {
+	RefID int
+	// AlignmentStart is the 1-based position of the first record of the slice.
+	AlignmentStart int64
+	AlignmentSpan  int64
+	// ContainerOffset is the offset of the container holding the slice in the file.
+	ContainerOffset int64
+}
+
+// CRAI is a CRAM index.
+type CRAI struct {
+	Entries []CRAIEntry
+	// containers holds the sorted offsets of the containers of the file.
+	containers []int64
+}
+
+// ReadCRAI parses a gzipped CRAM index.
+func ReadCRAI(r io.Reader) (*CRAI, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress index: %v", ErrInvalidIndex, err)
+	}
+	defer gz.Close()
+
+	index := &CRAI{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("%w: expected 6 fields in CRAI entry, got %d", ErrInvalidIndex, len(fields))
+		}
+		values := make([]int64, 4)
+		for i := range values {
+			values[i], err = strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid CRAI entry: %v", ErrInvalidIndex, err)
+			}
+		}
+		index.Entries = append(index.Entries, CRAIEntry{
+			RefID:           int(values[0]),
+			AlignmentStart:  values[1],
+			AlignmentSpan:   values[2],
+			ContainerOffset: values[3],
+		})
+		index.containers = append(index.containers, values[3])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to read index: %v", ErrInvalidIndex, err)
+	}
+
+	slices.Sort(index.containers)
+	index.containers = slices.Compact(index.containers)
+
+	return index, nil
+}
+
+// Ranges returns the byte ranges of the decrypted file holding the containers
+// with records of the reference overlapping the 0-based, half-open region
+// [start, end). An end of zero means the end of the reference. A refID of -1
+// selects the records without a reference. eofStart is the offset of the
+// container terminating the decrypted file.
+func (idx *CRAI) Ranges(refID int, start, end, eofStart int64) []streaming.ByteRange {
+	var ranges []streaming.ByteRange
+	for _, entry := range idx.Entries {
+		if entry.RefID != refID {
+			continue
+		}
+		if refID != unmappedRefID {
+			entryStart := entry.AlignmentStart - 1
+			if entryStart+entry.AlignmentSpan <= start || (end > 0 && entryStart >= end) {
+				continue
+			}
+		}
+		ranges = append(ranges, streaming.ByteRange{Start: entry.ContainerOffset, End: idx.containerEnd(entry.ContainerOffset, eofStart)})
+	}
+
+	return streaming.MergeRanges(ranges)
+}
+
+// HeaderEnd returns the offset of the first container holding records, the
+// file definition and the header container are stored before it.
+func (idx *CRAI) HeaderEnd(eofStart int64) int64 {
+	if len(idx.containers) == 0 {
+		return eofStart
+	}
+
+	return idx.containers[0]
+}
+
+// containerEnd returns the offset just after the container at the offset,
+// which is the offset of the next container.
+func (idx *CRAI) containerEnd(offset, eofStart int64) int64 {
+	i, found := slices.BinarySearch(idx.containers, offset)
+	if found {
+		i++
+	}
+	if i < len(idx.containers) {
+		return idx.containers[i]
+	}
+
+	return eofStart
+}
@@ -0,0 +1,262 @@
+package htsget
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxNameLength bounds the length of reference names read from file headers.
+const maxNameLength = 1 << 16
+
+// ReadBAMReferences returns the reference sequence names of a BAM file, in
+// the order used by its index. Only the header of the file is read.
+func ReadBAMReferences(r io.Reader) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress BAM header: %v", ErrInvalidIndex, err)
+	}
+	defer gz.Close()
+
+	br := bufio.NewReader(gz)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("%w: failed to read BAM magic: %v", ErrInvalidIndex, err)
+	}
+	if !bytes.Equal(magic, []byte("BAM\x01")) {
+		return nil, fmt.Errorf("%w: not a BAM file", ErrInvalidIndex)
+	}
+
+	var textLength int32
+	if err := binary.Read(br, binary.LittleEndian, &textLength); err != nil || textLength < 0 {
+		return nil, fmt.Errorf("%w: failed to read BAM header length", ErrInvalidIndex)
+	}
+	if _, err := br.Discard(int(textLength)); err != nil {
+		return nil, fmt.Errorf("%w: failed to read BAM header text: %v", ErrInvalidIndex, err)
+	}
+
+	nRef, err := readCount(br)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, min(nRef, 1024))
+	for range nRef {
+		var nameLength int32
+		if err := binary.Read(br, binary.LittleEndian, &nameLength); err != nil || nameLength < 1 || nameLength > maxNameLength {
+			return nil, fmt.Errorf("%w: invalid reference name length", ErrInvalidIndex)
+		}
+		name := make([]byte, nameLength+4)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, fmt.Errorf("%w: failed to read reference: %v", ErrInvalidIndex, err)
+		}
+		// The name is NUL terminated and followed by the length of the reference
+		names = append(names, string(name[:nameLength-1]))
+	}
+
+	return names, nil
+}
+
+// CRAMHeader holds what is needed from the header of a CRAM file to resolve regions.
+type CRAMHeader struct {
+	// MajorVersion is the major version of the CRAM format.
+	MajorVersion byte
+	// References holds the reference sequence names, in the order used by the index.
+	References []string
+}
+
+// EOFSize returns the size of the container terminating the file.
+func (h CRAMHeader) EOFSize() int64 {
+	switch {
+	case h.MajorVersion >= 3:
+		return 38
+	case h.MajorVersion == 2:
+		return 30
+	}
+
+	return 0
+}
+
+// ReadCRAMHeader reads the file definition and the SAM header of a CRAM file.
+// Only the beginning of the file is read.
+func ReadCRAMHeader(r io.Reader) (*CRAMHeader, error) {
+	br := bufio.NewReader(r)
+
+	// File definition: magic, major and minor version and a 20 byte file id
+	definition := make([]byte, 26)
+	if _, err := io.ReadFull(br, definition); err != nil {
+		return nil, fmt.Errorf("%w: failed to read CRAM file definition: %v", ErrInvalidIndex, err)
+	}
+	if !bytes.Equal(definition[:4], []byte("CRAM")) {
+		return nil, fmt.Errorf("%w: not a CRAM file", ErrInvalidIndex)
+	}
+	header := &CRAMHeader{MajorVersion: definition[4]}
+	if header.MajorVersion < 2 || header.MajorVersion > 3 {
+		return nil, fmt.Errorf("%w: unsupported CRAM version: %d", ErrInvalidIndex, header.MajorVersion)
+	}
+
+	// Header container: length, ref id, start, span, records, record counter,
+	// bases, blocks, landmarks and, from version 3, a CRC32
+	var length int32
+	if err := binary.Read(br, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("%w: failed to read CRAM container: %v", ErrInvalidIndex, err)
+	}
+	for range 4 {
+		if _, err := readITF8(br); err != nil {
+			return nil, err
+		}
+	}
+	for range 2 {
+		if _, err := readLTF8(br); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := readITF8(br); err != nil {
+		return nil, err
+	}
+	landmarks, err := readITF8(br)
+	if err != nil {
+		return nil, err
+	}
+	for range landmarks {
+		if _, err := readITF8(br); err != nil {
+			return nil, err
+		}
+	}
+	if header.MajorVersion >= 3 {
+		if _, err := br.Discard(4); err != nil {
+			return nil, fmt.Errorf("%w: failed to read CRAM container: %v", ErrInvalidIndex, err)
+		}
+	}
+
+	text, err := readCRAMHeaderBlock(br)
+	if err != nil {
+		return nil, err
+	}
+	for line := range strings.SplitSeq(text, "\n") {
+		if !strings.HasPrefix(line, "@SQ\t") {
+			continue
+		}
+		for field := range strings.SplitSeq(line, "\t") {
+			if name, ok := strings.CutPrefix(field, "SN:"); ok {
+				header.References = append(header.References, name)
+			}
+		}
+	}
+
+	return header, nil
+}
+
+// readCRAMHeaderBlock reads the block holding the SAM header text of a CRAM file.
+func readCRAMHeaderBlock(br *bufio.Reader) (string, error) {
+	method, err := br.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read CRAM block: %v", ErrInvalidIndex, err)
+	}
+	// Content type
+	if _, err := br.ReadByte(); err != nil {
+		return "", fmt.Errorf("%w: failed to read CRAM block: %v", ErrInvalidIndex, err)
+	}
+	// Content id
+	if _, err := readITF8(br); err != nil {
+		return "", err
+	}
+	size, err := readITF8(br)
+	if err != nil {
+		return "", err
+	}
+	if _, err := readITF8(br); err != nil {
+		return "", err
+	}
+	if size < 0 || size > maxNamesLength {
+		return "", fmt.Errorf("%w: invalid CRAM block size: %d", ErrInvalidIndex, size)
+	}
+
+	data := io.LimitReader(br, int64(size))
+	switch method {
+	case 0:
+	case 1:
+		gz, err := gzip.NewReader(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to decompress CRAM header: %v", ErrInvalidIndex, err)
+		}
+		defer gz.Close()
+		data = gz
+	case 2:
+		data = bzip2.NewReader(data)
+	default:
+		return "", fmt.Errorf("%w: unsupported compression of CRAM header: %d", ErrInvalidIndex, method)
+	}
+
+	var textLength int32
+	if err := binary.Read(data, binary.LittleEndian, &textLength); err != nil || textLength < 0 || textLength > maxNamesLength {
+		return "", fmt.Errorf("%w: failed to read CRAM header length", ErrInvalidIndex)
+	}
+	text := make([]byte, textLength)
+	if _, err := io.ReadFull(data, text); err != nil {
+		return "", fmt.Errorf("%w: failed to read CRAM header: %v", ErrInvalidIndex, err)
+	}
+
+	return string(text), nil
+}
+
+// readITF8 reads a CRAM ITF8 encoded integer.
+func readITF8(br *bufio.Reader) (int32, error) {
+	b0, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to read integer: %v", ErrInvalidIndex, err)
+	}
+
+	// The number of leading ones is the number of bytes following the first
+	extra := 0
+	for extra < 4 && b0&(0x80>>extra) != 0 {
+		extra++
+	}
+	mask := uint32(0xff >> (extra + 1))
+	if extra == 4 {
+		mask = 0x0f
+	}
+	value := uint32(b0) & mask
+	for i := range extra {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: failed to read integer: %v", ErrInvalidIndex, err)
+		}
+		if i == 3 {
+			// The fifth byte only contributes its lower four bits
+			value = value<<4 | uint32(b&0x0f)
+
+			continue
+		}
+		value = value<<8 | uint32(b)
+	}
+
+	return int32(value), nil
+}
+
+// readLTF8 reads a CRAM LTF8 encoded integer.
+func readLTF8(br *bufio.Reader) (int64, error) {
+	b0, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to read integer: %v", ErrInvalidIndex, err)
+	}
+
+	extra := 0
+	for extra < 8 && b0&(0x80>>extra) != 0 {
+		extra++
+	}
+	value := uint64(b0) & (0xff >> (extra + 1))
+	for range extra {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: failed to read integer: %v", ErrInvalidIndex, err)
+		}
+		value = value<<8 | uint64(b)
+	}
+
+	return int64(value), nil
+}
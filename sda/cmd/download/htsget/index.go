@@ -0,0 +1,335 @@
+// Package htsget resolves genomic regions of indexed BAM, CRAM and VCF files
+// to byte ranges, following the GA4GH htsget protocol.
+//
+// Indexes (BAI, CRAI, TBI) and file headers are read from the decrypted
+// files; the byte ranges returned are offsets into the decrypted file.
+package htsget
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+)
+
+// bgzfEOFSize is the size of the empty BGZF block terminating BAM and bgzipped VCF files.
+const bgzfEOFSize = 28
+
+// pseudoBin is the bin holding index metadata rather than alignments in BAI and TBI indexes.
+const pseudoBin = 37450
+
+// maxBinningPosition is the largest position representable by the binning scheme of BAI and TBI indexes.
+const maxBinningPosition = 1 << 29
+
+// ErrInvalidIndex is returned when an index or file header can not be parsed.
+var ErrInvalidIndex = errors.New("invalid index")
+
+// VirtualOffset is a BGZF virtual file offset, the offset of a compressed
+// block in the upper 48 bits and the offset within the uncompressed block in
+// the lower 16 bits.
+type VirtualOffset uint64
+
+// Compressed returns the offset of the BGZF block in the file.
+func (v VirtualOffset) Compressed() int64 {
+	return int64(v >> 16)
+}
+
+// Uncompressed returns the offset within the uncompressed BGZF block.
+func (v VirtualOffset) Uncompressed() int64 {
+	return int64(v & 0xffff)
+}
+
+// Chunk is a range of virtual offsets holding records of a bin.
+type Chunk struct {
+	Begin VirtualOffset
+	End   VirtualOffset
+}
+
+// reference holds the binning and linear index of a reference sequence.
+type reference struct {
+	bins      map[uint32][]Chunk
+	intervals []VirtualOffset
+}
+
+// BinningIndex is a BAI or TBI index.
+type BinningIndex struct {
+	// Names holds the reference sequence names of TBI indexes, BAI indexes
+	// do not store names, they are read from the BAM header.
+	Names      []string
+	references []reference
+	// offsets holds the sorted block offsets known from the index.
+	offsets []int64
+}
+
+// ReadBAI parses a BAM index.
+func ReadBAI(r io.Reader) (*BinningIndex, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("%w: failed to read magic: %v", ErrInvalidIndex, err)
+	}
+	if !bytes.Equal(magic, []byte("BAI\x01")) {
+		return nil, fmt.Errorf("%w: not a BAI index", ErrInvalidIndex)
+	}
+
+	return readBinningIndex(br, nil)
+}
+
+// ReadTabix parses a bgzipped tabix index.
+func ReadTabix(r io.Reader) (*BinningIndex, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress index: %v", ErrInvalidIndex, err)
+	}
+	defer gz.Close()
+
+	br := bufio.NewReader(gz)
+	var header struct {
+		Magic                                [4]byte
+		NRef, Format, ColSeq, ColBeg, ColEnd int32
+		Meta, Skip, NameLength               int32
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: failed to read header: %v", ErrInvalidIndex, err)
+	}
+	if !bytes.Equal(header.Magic[:], []byte("TBI\x01")) {
+		return nil, fmt.Errorf("%w: not a tabix index", ErrInvalidIndex)
+	}
+	if header.NRef < 0 || header.NRef > maxCount || header.NameLength < 0 || header.NameLength > maxNamesLength {
+		return nil, fmt.Errorf("%w: invalid reference count or length of names", ErrInvalidIndex)
+	}
+
+	names := make([]byte, header.NameLength)
+	if _, err := io.ReadFull(br, names); err != nil {
+		return nil, fmt.Errorf("%w: failed to read names: %v", ErrInvalidIndex, err)
+	}
+	nameList := make([]string, 0, header.NRef)
+	if len(names) > 0 {
+		for name := range bytes.SplitSeq(bytes.TrimSuffix(names, []byte{0}), []byte{0}) {
+			nameList = append(nameList, string(name))
+		}
+	}
+	if len(nameList) != int(header.NRef) {
+		return nil, fmt.Errorf("%w: %d names for %d references", ErrInvalidIndex, len(nameList), header.NRef)
+	}
+
+	// The reference count has already been read as part of the header
+	return readReferences(br, int(header.NRef), nameList)
+}
+
+// readBinningIndex reads the reference count and the references of a BAI or TBI index.
+func readBinningIndex(r io.Reader, names []string) (*BinningIndex, error) {
+	nRef, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return readReferences(r, nRef, names)
+}
+
+// readReferences reads the binning and linear indexes of nRef references.
+func readReferences(r io.Reader, nRef int, names []string) (*BinningIndex, error) {
+	index := &BinningIndex{Names: names, references: make([]reference, 0, min(nRef, 1024))}
+	known := map[int64]struct{}{}
+
+	for range nRef {
+		ref := reference{bins: map[uint32][]Chunk{}}
+
+		nBin, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		for range nBin {
+			var bin uint32
+			if err := binary.Read(r, binary.LittleEndian, &bin); err != nil {
+				return nil, fmt.Errorf("%w: failed to read bin: %v", ErrInvalidIndex, err)
+			}
+			nChunk, err := readCount(r)
+			if err != nil {
+				return nil, err
+			}
+			chunks := make([]Chunk, nChunk)
+			if err := binary.Read(r, binary.LittleEndian, chunks); err != nil {
+				return nil, fmt.Errorf("%w: failed to read chunks: %v", ErrInvalidIndex, err)
+			}
+			if bin == pseudoBin {
+				continue
+			}
+			ref.bins[bin] = chunks
+			for _, chunk := range chunks {
+				known[chunk.Begin.Compressed()] = struct{}{}
+				known[chunk.End.Compressed()] = struct{}{}
+			}
+		}
+
+		nIntv, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		ref.intervals = make([]VirtualOffset, nIntv)
+		if err := binary.Read(r, binary.LittleEndian, ref.intervals); err != nil {
+			return nil, fmt.Errorf("%w: failed to read linear index: %v", ErrInvalidIndex, err)
+		}
+		for _, offset := range ref.intervals {
+			known[offset.Compressed()] = struct{}{}
+		}
+
+		index.references = append(index.references, ref)
+	}
+
+	for offset := range known {
+		index.offsets = append(index.offsets, offset)
+	}
+	slices.Sort(index.offsets)
+
+	return index, nil
+}
+
+// maxCount bounds the counts read from indexes, so that a corrupt index can
+// not make us allocate unbounded memory.
+const maxCount = 1 << 24
+
+// maxNamesLength bounds the length of the reference names of tabix indexes.
+const maxNamesLength = 1 << 28
+
+// readCount reads a non-negative int32 count.
+func readCount(r io.Reader) (int, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return 0, fmt.Errorf("%w: failed to read count: %v", ErrInvalidIndex, err)
+	}
+	if n < 0 || n > maxCount {
+		return 0, fmt.Errorf("%w: invalid count: %d", ErrInvalidIndex, n)
+	}
+
+	return int(n), nil
+}
+
+// reg2bins returns the bins which may hold records overlapping the 0-based,
+// half-open region [beg, end), see the SAM specification.
+func reg2bins(beg, end int64) []uint32 {
+	end--
+	bins := []uint32{0}
+	for _, level := range []struct {
+		offset int64
+		shift  uint
+	}{{1, 26}, {9, 23}, {73, 20}, {585, 17}, {4681, 14}} {
+		for k := level.offset + beg>>level.shift; k <= level.offset+end>>level.shift; k++ {
+			bins = append(bins, uint32(k))
+		}
+	}
+
+	return bins
+}
+
+// Ranges returns the byte ranges of the decrypted file holding the records of
+// the reference overlapping the 0-based, half-open region [start, end). An
+// end of zero means the end of the reference. fileSize is the size of the
+// decrypted file.
+func (idx *BinningIndex) Ranges(refID int, start, end, fileSize int64) []streaming.ByteRange {
+	if refID < 0 || refID >= len(idx.references) {
+		return nil
+	}
+	if end <= 0 || end > maxBinningPosition {
+		end = maxBinningPosition
+	}
+	if start >= end {
+		return nil
+	}
+
+	ref := idx.references[refID]
+	var minOffset VirtualOffset
+	if n := len(ref.intervals); n > 0 {
+		minOffset = ref.intervals[min(int(start>>14), n-1)]
+	}
+
+	var ranges []streaming.ByteRange
+	for _, bin := range reg2bins(start, end) {
+		for _, chunk := range ref.bins[bin] {
+			if chunk.End <= minOffset {
+				continue
+			}
+			ranges = append(ranges, streaming.ByteRange{
+				Start: chunk.Begin.Compressed(),
+				End:   idx.blockEnd(chunk.End, fileSize),
+			})
+		}
+	}
+
+	return streaming.MergeRanges(ranges)
+}
+
+// Unmapped returns the byte range of the decrypted file holding the records
+// without a reference, which are stored after all other records.
+func (idx *BinningIndex) Unmapped(fileSize int64) []streaming.ByteRange {
+	var last VirtualOffset
+	for _, ref := range idx.references {
+		for _, chunks := range ref.bins {
+			for _, chunk := range chunks {
+				last = max(last, chunk.End)
+			}
+		}
+	}
+
+	start := last.Compressed()
+	if last == 0 {
+		start = idx.HeaderEnd(fileSize)
+	}
+	if eof := fileSize - bgzfEOFSize; start < eof {
+		return []streaming.ByteRange{{Start: start, End: eof}}
+	}
+
+	return nil
+}
+
+// HeaderEnd returns the offset of the first block holding records, the file
+// header is stored in the blocks before it.
+func (idx *BinningIndex) HeaderEnd(fileSize int64) int64 {
+	var first VirtualOffset
+	found := false
+	for _, ref := range idx.references {
+		for _, chunks := range ref.bins {
+			for _, chunk := range chunks {
+				if !found || chunk.Begin < first {
+					first, found = chunk.Begin, true
+				}
+			}
+		}
+	}
+	if !found {
+		return fileSize - bgzfEOFSize
+	}
+
+	// A header sharing its last block with the first records needs the whole block
+	return idx.blockEnd(first, fileSize)
+}
+
+// EOF returns the byte range of the empty block terminating the decrypted file.
+func (idx *BinningIndex) EOF(fileSize int64) streaming.ByteRange {
+	return streaming.ByteRange{Start: fileSize - bgzfEOFSize, End: fileSize}
+}
+
+// blockEnd returns the offset just after the block holding the virtual
+// offset, or the offset of the block itself if nothing of it is needed. The
+// end of a block is the start of the next block known from the index.
+func (idx *BinningIndex) blockEnd(offset VirtualOffset, fileSize int64) int64 {
+	if offset.Uncompressed() == 0 {
+		return offset.Compressed()
+	}
+
+	i, found := slices.BinarySearch(idx.offsets, offset.Compressed())
+	if found {
+		i++
+	}
+	if i < len(idx.offsets) {
+		return idx.offsets[i]
+	}
+
+	return fileSize - bgzfEOFSize
+}
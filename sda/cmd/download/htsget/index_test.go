@@ -0,0 +1,209 @@
+package htsget
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/streaming"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// voff builds a virtual offset from a block offset and an offset within the block.
+func voff(block, within int64) VirtualOffset {
+	return VirtualOffset(block<<16 | within)
+}
+
+// byteRange returns the half-open byte range [start, end).
+func byteRange(start, end int64) streaming.ByteRange {
+	return streaming.ByteRange{Start: start, End: end}
+}
+
+// testBin is a bin of a test index.
+type testBin struct {
+	bin    uint32
+	chunks []Chunk
+}
+
+// writeReferences writes the binning and linear indexes of BAI and TBI indexes.
+func writeReferences(t *testing.T, buf *bytes.Buffer, refs [][]testBin, intervals [][]VirtualOffset) {
+	t.Helper()
+	for i, bins := range refs {
+		require.NoError(t, binary.Write(buf, binary.LittleEndian, int32(len(bins))))
+		for _, bin := range bins {
+			require.NoError(t, binary.Write(buf, binary.LittleEndian, bin.bin))
+			require.NoError(t, binary.Write(buf, binary.LittleEndian, int32(len(bin.chunks))))
+			require.NoError(t, binary.Write(buf, binary.LittleEndian, bin.chunks))
+		}
+		require.NoError(t, binary.Write(buf, binary.LittleEndian, int32(len(intervals[i]))))
+		require.NoError(t, binary.Write(buf, binary.LittleEndian, intervals[i]))
+	}
+}
+
+// testIndex returns the references of a test index: the header ends at
+// block 1000, chr1 has records at 10 kb in block 1000 and at 100 kb in
+// block 5000, chr2 has records in block 9000 and unmapped records follow in
+// block 12000.
+func testIndex() ([][]testBin, [][]VirtualOffset) {
+	refs := [][]testBin{
+		{
+			{bin: 4681, chunks: []Chunk{{voff(1000, 0), voff(1000, 500)}}},
+			{bin: 4681 + 6, chunks: []Chunk{{voff(5000, 0), voff(5000, 800)}}},
+			{bin: pseudoBin, chunks: []Chunk{{voff(1000, 0), voff(5000, 800)}, {2, 0}}},
+		},
+		{
+			{bin: 0, chunks: []Chunk{{voff(9000, 10), voff(11000, 0)}}},
+		},
+	}
+	intervals := [][]VirtualOffset{
+		{voff(1000, 0), voff(1000, 0), voff(1000, 0), voff(1000, 0), voff(1000, 0), voff(1000, 0), voff(5000, 0)},
+		{voff(9000, 10)},
+	}
+
+	return refs, intervals
+}
+
+func TestReadBAI(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("BAI\x01")
+	refs, intervals := testIndex()
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(len(refs))))
+	writeReferences(t, &buf, refs, intervals)
+
+	index, err := ReadBAI(&buf)
+	require.NoError(t, err)
+
+	const size = 20000
+	assert.Equal(t, int64(1000), index.HeaderEnd(size))
+	assert.Equal(t, byteRange(size-28, size), index.EOF(size))
+
+	// The records at 10 kb end within block 1000, which ends where the next known block starts
+	assert.Equal(t, []streaming.ByteRange{byteRange(1000, 5000)}, index.Ranges(0, 10000, 20000, size))
+	assert.Equal(t, []streaming.ByteRange{byteRange(5000, 9000)}, index.Ranges(0, 100000, 100001, size))
+	assert.Equal(t, []streaming.ByteRange{byteRange(1000, 9000)}, index.Ranges(0, 0, 0, size))
+	assert.Equal(t, []streaming.ByteRange{byteRange(9000, 11000)}, index.Ranges(1, 0, 0, size))
+	assert.Nil(t, index.Ranges(2, 0, 0, size))
+	assert.Equal(t, []streaming.ByteRange{byteRange(11000, size-28)}, index.Unmapped(size))
+
+	_, err = ReadBAI(bytes.NewReader([]byte("BAM\x01")))
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+}
+
+func TestReadTabix(t *testing.T) {
+	var buf bytes.Buffer
+	refs, intervals := testIndex()
+	names := []byte("chr1\x00chr2\x00")
+	buf.WriteString("TBI\x01")
+	for _, v := range []int32{int32(len(refs)), 2, 1, 2, 0, '#', 0, int32(len(names))} {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, v))
+	}
+	buf.Write(names)
+	writeReferences(t, &buf, refs, intervals)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	index, err := ReadTabix(&compressed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chr2"}, index.Names)
+	assert.Equal(t, []streaming.ByteRange{byteRange(9000, 11000)}, index.Ranges(1, 5, 10, 20000))
+}
+
+func TestReadCRAI(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte("0\t1\t5000\t500\t100\t1000\n" +
+		"0\t5001\t5000\t2000\t100\t1000\n" +
+		"1\t1\t100\t4000\t100\t1000\n" +
+		"-1\t0\t0\t6000\t100\t1000\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	index, err := ReadCRAI(&compressed)
+	require.NoError(t, err)
+	require.Len(t, index.Entries, 4)
+
+	const eofStart = 7000
+	assert.Equal(t, int64(500), index.HeaderEnd(eofStart))
+	assert.Equal(t, []streaming.ByteRange{byteRange(500, 2000)}, index.Ranges(0, 0, 5000, eofStart))
+	assert.Equal(t, []streaming.ByteRange{byteRange(2000, 4000)}, index.Ranges(0, 5000, 6000, eofStart))
+	assert.Equal(t, []streaming.ByteRange{byteRange(500, 4000)}, index.Ranges(0, 0, 0, eofStart))
+	assert.Equal(t, []streaming.ByteRange{byteRange(6000, eofStart)}, index.Ranges(unmappedRefID, 0, 0, eofStart))
+	assert.Empty(t, index.Ranges(1, 200, 300, eofStart))
+}
+
+func TestReadBAMReferences(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("BAM\x01")
+	text := "@HD\tVN:1.6\n"
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(len(text))))
+	buf.WriteString(text)
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(2)))
+	for _, name := range []string{"chr1", "chrM"} {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(len(name)+1)))
+		buf.WriteString(name + "\x00")
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(16569)))
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	names, err := ReadBAMReferences(&compressed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chr1", "chrM"}, names)
+}
+
+func TestReadCRAMHeader(t *testing.T) {
+	text := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:1000\n@SQ\tLN:16569\tSN:chrM\n"
+	var block bytes.Buffer
+	require.NoError(t, binary.Write(&block, binary.LittleEndian, int32(len(text))))
+	block.WriteString(text)
+
+	var buf bytes.Buffer
+	buf.WriteString("CRAM\x03\x00")
+	buf.Write(make([]byte, 20))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, int32(0)))
+	// ref id, start, span, records, record counter, bases, blocks and one landmark
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 1, 1, 0})
+	buf.Write(make([]byte, 4))
+	// Raw file header block, with a size encoded in two bytes
+	buf.Write([]byte{0, 0, 0, 0x80 | byte(block.Len()>>8), byte(block.Len()), 0x80 | byte(block.Len()>>8), byte(block.Len())})
+	buf.Write(block.Bytes())
+
+	header, err := ReadCRAMHeader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), header.MajorVersion)
+	assert.Equal(t, int64(38), header.EOFSize())
+	assert.Equal(t, []string{"chr1", "chrM"}, header.References)
+}
+
+func TestITF8(t *testing.T) {
+	for _, tc := range []struct {
+		encoded []byte
+		value   int32
+	}{
+		{[]byte{0x00}, 0},
+		{[]byte{0x7f}, 127},
+		{[]byte{0x80, 0xff}, 255},
+		{[]byte{0xc0, 0x40, 0x00}, 0x4000},
+		{[]byte{0xe0, 0x20, 0x00, 0x00}, 0x200000},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, -1},
+	} {
+		value, err := readITF8(bufio.NewReader(bytes.NewReader(tc.encoded)))
+		require.NoError(t, err)
+		assert.Equal(t, tc.value, value, "encoded: %x", tc.encoded)
+	}
+
+	value, err := readLTF8(bufio.NewReader(bytes.NewReader([]byte{0xff, 0, 0, 0, 0, 0, 0, 0x01, 0x00})))
+	require.NoError(t, err)
+	assert.Equal(t, int64(256), value)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
@@ -16,13 +17,14 @@ import (
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/database"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/handlers"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/middleware"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/reencrypt"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	internalconfig "github.com/neicnordic/sensitive-data-archive/internal/config/v2"
 	storage "github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 )
@@ -57,6 +59,19 @@ func run() error {
 		return err
 	}
 
+	// Validate decrypted streaming
+	if config.DecryptedEnabled() {
+		if err := validateDecryptedConfig(decryptedConfig{
+			PermissionModel: config.PermissionModel(),
+			AllowedClients:  config.DecryptedAllowedClients(),
+			ServerCert:      config.APIServerCert(),
+			ServerKey:       config.APIServerKey(),
+			ClientCACert:    config.APIClientCACert(),
+		}); err != nil {
+			return err
+		}
+	}
+
 	// Initialize database
 	if err := database.Init(); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
@@ -68,6 +83,9 @@ func run() error {
 		}
 	}()
 
+	// The ARCHIVED checksums used to verify files read from the archive are looked up without caching
+	checksumLookup, _ := database.GetDB().(storage.ChecksumLookup)
+
 	// Wrap database with cache if enabled
 	if config.CacheEnabled() {
 		cachedDB, err := database.NewCachedDB(database.GetDB(), database.CacheConfig{
@@ -95,11 +113,51 @@ func run() error {
 	}
 
 	// Initialize audit logger
-	var auditLogger audit.Logger
-	if config.AuditRequired() {
-		auditLogger = audit.NewStdoutLogger()
-	} else {
-		auditLogger = audit.NoopLogger{}
+	auditConfig := audit.Config{
+		Required: config.AuditRequired(),
+		Sinks:    config.AuditSinks(),
+		File: audit.FileConfig{
+			Path:       config.AuditFilePath(),
+			MaxSize:    int64(config.AuditFileMaxSize()) * 1024 * 1024,
+			MaxBackups: config.AuditFileMaxBackups(),
+		},
+		Syslog: audit.SyslogConfig{
+			Network: config.AuditSyslogNetwork(),
+			Address: config.AuditSyslogAddress(),
+			AppName: config.AuditSyslogAppName(),
+		},
+		AMQP: broker.MQConf{
+			Host:       config.AuditAMQPHost(),
+			Port:       config.AuditAMQPPort(),
+			User:       config.AuditAMQPUser(),
+			Password:   config.AuditAMQPPassword(),
+			Vhost:      config.AuditAMQPVhost(),
+			Exchange:   config.AuditAMQPExchange(),
+			RoutingKey: config.AuditAMQPRoutingKey(),
+			Ssl:        config.AuditAMQPSSL(),
+			VerifyPeer: config.AuditAMQPVerifyPeer(),
+			CACert:     config.AuditAMQPCACert(),
+			ClientCert: config.AuditAMQPClientCert(),
+			ClientKey:  config.AuditAMQPClientKey(),
+			ServerName: config.AuditAMQPServerName(),
+		},
+	}
+	if err := auditConfig.Validate(); err != nil {
+		return err
+	}
+	var auditLogger audit.Logger = audit.NoopLogger{}
+	if len(auditConfig.Sinks) > 0 {
+		sinkLogger, err := audit.NewLogger(auditConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize audit logger: %w", err)
+		}
+		defer func() {
+			if err := sinkLogger.Close(); err != nil {
+				log.Errorf("failed to close audit logger: %v", err)
+			}
+		}()
+		auditLogger = sinkLogger
+		log.Infof("audit events written to: %v", auditConfig.Sinks)
 	}
 
 	// Initialize pagination HMAC secret
@@ -113,7 +171,7 @@ func run() error {
 
 		handlers.SetPaginationSecret(b)
 		log.Warn("pagination.hmac-secret not configured: auto-generated random key. " +
-			"Page tokens will not survive restarts or work across replicas. " +
+			"Page tokens and signed URLs will not survive restarts or work across replicas. " +
 			"Set pagination.hmac-secret for multi-replica deployments.")
 	}
 
@@ -139,6 +197,9 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize storage reader: %w", err)
 	}
+	if checksumLookup != nil {
+		storageReader = storage.NewChecksumVerifyingReader("archive", storageReader, checksumLookup)
+	}
 	log.Info("storage reader initialized")
 
 	// Initialize gRPC reencrypt client
@@ -195,6 +256,10 @@ func run() error {
 	if visaValidator != nil {
 		handlerOpts = append(handlerOpts, handlers.WithVisaValidator(visaValidator))
 	}
+	if config.DecryptedEnabled() {
+		handlerOpts = append(handlerOpts, handlers.WithDecryptedStreaming(config.DecryptedAllowedClients()))
+		log.Warnf("decrypted streaming enabled for clients: %v", config.DecryptedAllowedClients())
+	}
 
 	h, err := handlers.New(handlerOpts...)
 	if err != nil {
@@ -205,6 +270,21 @@ func run() error {
 	// Configure TLS if certificates are provided
 	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
 
+	// Verify client certificates if a client CA is provided, they are only
+	// required by the endpoints streaming decrypted files
+	if config.APIClientCACert() != "" {
+		caCert, err := os.ReadFile(config.APIClientCACert())
+		if err != nil {
+			return fmt.Errorf("failed to read client CA certificate: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			return errors.New("failed to append client CA certificate")
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	srv := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", config.APIHost(), config.APIPort()),
 		Handler:           router,
@@ -314,6 +394,15 @@ type productionConfig struct {
 	GRPCClientKey  string
 }
 
+// decryptedConfig holds the values checked before enabling decrypted streaming.
+type decryptedConfig struct {
+	PermissionModel string
+	AllowedClients  []string
+	ServerCert      string
+	ServerKey       string
+	ClientCACert    string
+}
+
 // validatePermissionModel checks that the permission model is valid and
 // that its dependencies are satisfied.
 func validatePermissionModel(model string, visaEnabled bool) error {
@@ -348,3 +437,22 @@ func validateProductionConfig(cfg productionConfig) error {
 
 	return nil
 }
+
+// validateDecryptedConfig checks that decrypted files can only be streamed
+// over mTLS to the allowed clients. Plaintext access is granted by dataset
+// ownership only, so the visa permission model would deny all requests.
+func validateDecryptedConfig(cfg decryptedConfig) error {
+	if cfg.PermissionModel == "visa" {
+		return errors.New("decrypted.enabled requires permission.model ownership or combined, visas do not grant access to decrypted files")
+	}
+
+	if len(cfg.AllowedClients) == 0 {
+		return errors.New("decrypted.allowed-clients is required when decrypted.enabled is true")
+	}
+
+	if cfg.ServerCert == "" || cfg.ServerKey == "" || cfg.ClientCACert == "" {
+		return errors.New("api.server-cert, api.server-key and api.client-ca-cert are required when decrypted.enabled is true")
+	}
+
+	return nil
+}
@@ -79,3 +79,51 @@ func TestValidateProductionConfig_MissingGRPCKey(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "grpc.client-cert")
 }
+
+func TestValidateDecryptedConfig_Valid(t *testing.T) {
+	cfg := decryptedConfig{
+		PermissionModel: "combined",
+		AllowedClients:  []string{"pipeline"},
+		ServerCert:      "/path/to/cert",
+		ServerKey:       "/path/to/key",
+		ClientCACert:    "/path/to/ca",
+	}
+	require.NoError(t, validateDecryptedConfig(cfg))
+
+	cfg.PermissionModel = "ownership"
+	require.NoError(t, validateDecryptedConfig(cfg))
+}
+
+func TestValidateDecryptedConfig_VisaPermissionModel(t *testing.T) {
+	err := validateDecryptedConfig(decryptedConfig{
+		PermissionModel: "visa",
+		AllowedClients:  []string{"pipeline"},
+		ServerCert:      "/path/to/cert",
+		ServerKey:       "/path/to/key",
+		ClientCACert:    "/path/to/ca",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "permission.model")
+}
+
+func TestValidateDecryptedConfig_NoAllowedClients(t *testing.T) {
+	err := validateDecryptedConfig(decryptedConfig{
+		PermissionModel: "combined",
+		ServerCert:      "/path/to/cert",
+		ServerKey:       "/path/to/key",
+		ClientCACert:    "/path/to/ca",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decrypted.allowed-clients")
+}
+
+func TestValidateDecryptedConfig_NoMTLS(t *testing.T) {
+	err := validateDecryptedConfig(decryptedConfig{
+		PermissionModel: "combined",
+		AllowedClients:  []string{"pipeline"},
+		ServerCert:      "/path/to/cert",
+		ServerKey:       "/path/to/key",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "api.client-ca-cert")
+}
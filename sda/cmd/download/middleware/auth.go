@@ -23,9 +23,9 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
-	"github.com/neicnordic/sensitive-data-archive/cmd/download/audit"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
 	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -53,8 +53,10 @@ type AuthContext struct {
 	Datasets []string
 	// Token is the parsed JWT token (nil for opaque token auth)
 	Token jwt.Token
-	// AuthSource indicates how the user was authenticated ("jwt" or "userinfo")
+	// AuthSource indicates how the user was authenticated ("jwt", "userinfo" or "signed-url")
 	AuthSource string
+	// PublicKey is the public key bound to a signed URL, empty for other auth sources
+	PublicKey string
 }
 
 // Authenticator validates JWT tokens.
@@ -412,6 +414,7 @@ func resolveUserinfoClient(visaValidator *visa.Validator) *visa.UserinfoClient {
 
 // TokenMiddleware performs access token verification and validation.
 // The authenticated user's subject is stored in the request context.
+// Requests to a signed URL are authorized by its signature instead of a token.
 // If db is provided and allow-all-data is disabled, the user's datasets are populated from the database.
 // If visaValidator is provided, GA4GH visa-based access is also computed based on permission.model.
 func TokenMiddleware(db DatasetLookup, visaValidator *visa.Validator, auditLogger audit.Logger) gin.HandlerFunc {
@@ -431,6 +434,29 @@ func TokenMiddleware(db DatasetLookup, visaValidator *visa.Validator, auditLogge
 	}
 
 	return func(c *gin.Context) {
+		// Signed URLs carry their own authorization, they are never cached
+		if signature := c.Query(SignedURLParam); signature != "" {
+			authCtx, err := authenticateSignedURL(c, signature)
+			if err != nil {
+				log.Debugf("signed URL authentication failed: %v", err)
+				c.Header("Content-Type", "application/problem+json")
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"title":  "Unauthorized",
+					"status": http.StatusUnauthorized,
+					"detail": "Invalid or expired signed URL.",
+				})
+				auditDenied(c, http.StatusUnauthorized)
+				c.Abort()
+
+				return
+			}
+
+			c.Set(ContextKey, authCtx)
+			c.Next()
+
+			return
+		}
+
 		// Check for cached session (try configured name, then legacy name)
 		sessionCookie, err := c.Cookie(config.SessionName())
 		if err != nil || sessionCookie == "" {
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/config"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPassportRequestSize bounds the size of a request body carrying passports.
+const maxPassportRequestSize = 1 << 20
+
+// passportRequest is the part of a GA4GH DRS POST request body read by PassportMiddleware.
+type passportRequest struct {
+	Passports []string `json:"passports"`
+}
+
+// PassportMiddleware authorizes POST requests carrying GA4GH passports in their
+// JSON body, as the DRS POST endpoints do. Each passport must be a JWT signed by
+// the configured issuer, whose visas grant access to datasets. Other requests are
+// authorized by TokenMiddleware. The request body is left for the handler to read.
+func PassportMiddleware(db DatasetLookup, visaValidator *visa.Validator, auditLogger audit.Logger) gin.HandlerFunc {
+	tokenAuth := TokenMiddleware(db, visaValidator, auditLogger)
+
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+			tokenAuth(c)
+
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPassportRequestSize))
+		if err != nil {
+			c.Header("Content-Type", "application/problem+json")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"title":  "Bad Request",
+				"status": http.StatusBadRequest,
+				"detail": "Failed to read request body.",
+			})
+			c.Abort()
+
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Invalid bodies are left for the handler to reject
+		var request passportRequest
+		if len(body) == 0 || json.Unmarshal(body, &request) != nil || len(request.Passports) == 0 {
+			tokenAuth(c)
+
+			return
+		}
+
+		authCtx, err := authenticatePassports(c.Request.Context(), db, visaValidator, request.Passports)
+		if err != nil {
+			log.Debugf("passport authentication failed: %v", err)
+			c.Header("Content-Type", "application/problem+json")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"title":  "Unauthorized",
+				"status": http.StatusUnauthorized,
+				"detail": "Invalid or expired passport.",
+			})
+			auditLogger.Log(c.Request.Context(), audit.Event{
+				Event:         audit.EventDenied,
+				CorrelationID: c.GetString("correlationId"),
+				Path:          c.Request.URL.Path,
+				HTTPStatus:    http.StatusUnauthorized,
+			})
+			c.Abort()
+
+			return
+		}
+
+		c.Set(ContextKey, authCtx)
+		log.Debugf("passport authentication successful for subject: %s (datasets: %d)", authCtx.Subject, len(authCtx.Datasets))
+
+		c.Next()
+	}
+}
+
+// authenticatePassports verifies the passports, which must all be issued to the
+// same user, and returns the auth context of the user with the datasets granted
+// by their visas. Passports are not cached, they are verified on every request.
+func authenticatePassports(ctx context.Context, db DatasetLookup, visaValidator *visa.Validator, passports []string) (AuthContext, error) {
+	permModel := config.PermissionModel()
+	if visaValidator == nil || (permModel != "visa" && permModel != "combined") {
+		return AuthContext{}, errors.New("passports require visa support")
+	}
+	if auth == nil {
+		return AuthContext{}, errors.New("authentication not initialized")
+	}
+
+	var authCtx AuthContext
+	var visas []string
+	for i, passport := range passports {
+		token, err := auth.verifyJWT(passport)
+		if err != nil {
+			return AuthContext{}, fmt.Errorf("passport %d: %w", i, err)
+		}
+		if i == 0 {
+			authCtx = AuthContext{Issuer: token.Issuer(), Subject: token.Subject(), AuthSource: "passport"}
+		} else if token.Issuer() != authCtx.Issuer || token.Subject() != authCtx.Subject {
+			return AuthContext{}, errors.New("passports issued to different users")
+		}
+
+		claim, ok := token.PrivateClaims()["ga4gh_passport_v1"].([]any)
+		if !ok {
+			return AuthContext{}, fmt.Errorf("passport %d: missing ga4gh_passport_v1 claim", i)
+		}
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				visas = append(visas, s)
+			}
+		}
+	}
+
+	result, err := visaValidator.GetPassportDatasets(ctx, visa.Identity{Issuer: authCtx.Issuer, Subject: authCtx.Subject}, visas)
+	if err != nil {
+		return AuthContext{}, fmt.Errorf("visa processing failed: %w", err)
+	}
+	authCtx.VisaDatasets = result.Datasets
+
+	if db != nil && !config.JWTAllowAllData() && permModel == "combined" {
+		datasets, err := db.GetDatasetIDsByUser(ctx, authCtx.Subject)
+		if err != nil {
+			log.Warnf("failed to get datasets for user %s: %v", authCtx.Subject, err)
+		} else {
+			authCtx.OwnedDatasets = datasets
+		}
+	}
+
+	authCtx.Datasets = mergeDatasets(authCtx.OwnedDatasets, authCtx.VisaDatasets)
+
+	return authCtx, nil
+}
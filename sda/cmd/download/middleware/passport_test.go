@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPassportRouter returns a router recording the request body seen by the handler.
+func setupPassportRouter(t *testing.T, logger audit.Logger) (*gin.Engine, *string) {
+	t.Helper()
+	ensureTestConfig(t)
+	require.NoError(t, InitAuth())
+
+	var body string
+	r := gin.New()
+	r.Use(PassportMiddleware(nil, nil, logger))
+	handler := func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		body = string(b)
+		c.Status(http.StatusOK)
+	}
+	r.GET("/objects/:objectId", handler)
+	r.POST("/objects/:objectId", handler)
+
+	return r, &body
+}
+
+func TestPassportMiddleware_FallsBackToToken(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		body   string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodPost, `{"expand": true}`},
+		{http.MethodPost, `{"passports": []}`},
+		{http.MethodPost, "{"},
+	} {
+		r, _ := setupPassportRouter(t, audit.NoopLogger{})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, "/objects/file-1", bytes.NewBufferString(tc.body)))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, tc.body)
+		assert.Contains(t, w.Body.String(), "bearer token", tc.body)
+	}
+}
+
+func TestPassportMiddleware_InvalidPassport(t *testing.T) {
+	logger := &capturingLogger{}
+	r, body := setupPassportRouter(t, logger)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/objects/file-1", bytes.NewBufferString(`{"passports": ["not-a-jwt"]}`)))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid or expired passport.")
+	assert.Empty(t, *body, "handler must not run")
+	require.Len(t, logger.events, 1)
+	assert.Equal(t, audit.EventDenied, logger.events[0].Event)
+}
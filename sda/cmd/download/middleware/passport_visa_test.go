@@ -0,0 +1,106 @@
+//go:build visas
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/visa"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassportMiddleware_Passport(t *testing.T) {
+	ensureTestConfig(t)
+	setPermissionModel(t, "visa")
+
+	_, brokerKey := setupAuthAndToken(t, "user-123")
+
+	visaKey, visaPub := newKeyPair(t)
+	jwksServer := newJWKSServer(t, visaPub)
+	t.Cleanup(jwksServer.Close)
+	visaJWT := signVisaJWT(t, visaKey, jwksServer.URL, "visa-kid", "https://visa-issuer.example", "user-123", visaClaim("visa-1"), time.Now().Add(time.Hour))
+
+	cfg := visa.DefaultConfig()
+	cfg.UserinfoURL = "https://userinfo.invalid"
+	cfg.ValidateAsserted = true
+	validator, err := visa.NewValidator(cfg, []visa.TrustedIssuer{{ISS: "https://visa-issuer.example", JKU: jwksServer.URL}},
+		&fakeDatasetChecker{existing: map[string]bool{"visa-1": true}})
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(PassportMiddleware(nil, validator, audit.NoopLogger{}))
+	r.POST("/objects/:objectId", func(c *gin.Context) {
+		authCtx, _ := GetAuthContext(c)
+		c.JSON(http.StatusOK, authResponse{
+			Subject:  authCtx.Subject,
+			Datasets: authCtx.Datasets,
+			Visa:     authCtx.VisaDatasets,
+		})
+	})
+
+	t.Run("valid passport", func(t *testing.T) {
+		passport := signPassportJWT(t, brokerKey, "https://issuer.example", "user-123", []string{visaJWT})
+		body, _ := json.Marshal(map[string]any{"passports": []string{passport}})
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/objects/file-1", bytes.NewReader(body)))
+
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		got := decodeAuthResponse(t, resp.Body)
+		assert.Equal(t, "user-123", got.Subject)
+		assert.Equal(t, []string{"visa-1"}, got.Datasets)
+	})
+
+	t.Run("passports of different users", func(t *testing.T) {
+		first := signPassportJWT(t, brokerKey, "https://issuer.example", "user-123", []string{visaJWT})
+		second := signPassportJWT(t, brokerKey, "https://issuer.example", "user-456", nil)
+		body, _ := json.Marshal(map[string]any{"passports": []string{first, second}})
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/objects/file-1", bytes.NewReader(body)))
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("passport not signed by the broker", func(t *testing.T) {
+		passport := signPassportJWT(t, visaKey, "https://issuer.example", "user-123", []string{visaJWT})
+		body, _ := json.Marshal(map[string]any{"passports": []string{passport}})
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/objects/file-1", bytes.NewReader(body)))
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func signPassportJWT(t *testing.T, priv *rsa.PrivateKey, iss, sub string, visas []string) string {
+	t.Helper()
+
+	token := jwt.New()
+	require.NoError(t, token.Set(jwt.IssuerKey, iss))
+	require.NoError(t, token.Set(jwt.SubjectKey, sub))
+	require.NoError(t, token.Set(jwt.ExpirationKey, time.Now().Add(time.Hour)))
+	require.NoError(t, token.Set(jwt.IssuedAtKey, time.Now().Add(-1*time.Minute)))
+	require.NoError(t, token.Set("ga4gh_passport_v1", append([]string{}, visas...)))
+
+	headers := jws.NewHeaders()
+	require.NoError(t, headers.Set(jwk.KeyIDKey, "test-key"))
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, priv, jws.WithProtectedHeaders(headers)))
+	require.NoError(t, err)
+
+	return string(signed)
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/signing"
+)
+
+// SignedURLParam is the query parameter holding the signature of a signed URL.
+const SignedURLParam = "signature"
+
+// signedURLPurpose separates signed URL claims from other signed tokens.
+const signedURLPurpose = "download-url"
+
+// signedURLRoute is the only route a signed URL authorizes.
+const signedURLRoute = "/files/:fileId"
+
+// SignedURLClaims are the claims of a signed download URL. They bind the URL
+// to a single file, the user it was issued to and the public key the file
+// is re-encrypted with.
+type SignedURLClaims struct {
+	Purpose   string `json:"p"`
+	FileID    string `json:"f"`
+	DatasetID string `json:"d"`
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	PublicKey string `json:"k"`
+	Exp       int64  `json:"e"`
+}
+
+// SignURL returns the signature of a download URL carrying the claims.
+func SignURL(claims SignedURLClaims) (string, error) {
+	claims.Purpose = signedURLPurpose
+
+	return signing.Encode(claims)
+}
+
+// authenticateSignedURL verifies the signature of a signed URL and returns
+// the auth context of the user the URL was issued to.
+func authenticateSignedURL(c *gin.Context, signature string) (AuthContext, error) {
+	var claims SignedURLClaims
+	if err := signing.Decode(signature, &claims); err != nil {
+		return AuthContext{}, fmt.Errorf("failed to verify signed URL: %w", err)
+	}
+
+	switch {
+	case claims.Purpose != signedURLPurpose:
+		return AuthContext{}, errors.New("signature is not a signed URL")
+	case time.Now().Unix() > claims.Exp:
+		return AuthContext{}, errors.New("signed URL expired")
+	case c.FullPath() != signedURLRoute || (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead):
+		return AuthContext{}, fmt.Errorf("signed URL not valid for %s %s", c.Request.Method, c.Request.URL.Path)
+	case c.Param("fileId") != claims.FileID:
+		return AuthContext{}, errors.New("signed URL issued for another file")
+	}
+
+	return AuthContext{
+		Issuer:     claims.Issuer,
+		Subject:    claims.Subject,
+		Datasets:   []string{claims.DatasetID},
+		AuthSource: "signed-url",
+		PublicKey:  claims.PublicKey,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neicnordic/sensitive-data-archive/cmd/download/signing"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSignedURLRouter returns a router recording the auth context set by TokenMiddleware.
+func setupSignedURLRouter(t *testing.T, logger audit.Logger) (*gin.Engine, *AuthContext) {
+	t.Helper()
+	ensureTestConfig(t)
+	signing.SetSecret([]byte("test-secret"))
+
+	var got AuthContext
+	record := func(c *gin.Context) {
+		got, _ = GetAuthContext(c)
+		c.Status(http.StatusOK)
+	}
+
+	r := gin.New()
+	files := r.Group("/files")
+	files.Use(TokenMiddleware(nil, nil, logger))
+	files.GET("/:fileId", record)
+	files.HEAD("/:fileId", record)
+	files.GET("/:fileId/header", record)
+
+	return r, &got
+}
+
+func testSignedURLClaims() SignedURLClaims {
+	return SignedURLClaims{
+		FileID:    "file-1",
+		DatasetID: "dataset-1",
+		Issuer:    "https://issuer.example",
+		Subject:   "user-1",
+		PublicKey: "public-key",
+		Exp:       time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestTokenMiddleware_SignedURL(t *testing.T) {
+	r, got := setupSignedURLRouter(t, audit.NoopLogger{})
+
+	signature, err := SignURL(testSignedURLClaims())
+	require.NoError(t, err)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/files/file-1?signature="+signature, nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, method)
+		assert.Equal(t, "user-1", got.Subject)
+		assert.Equal(t, "https://issuer.example", got.Issuer)
+		assert.Equal(t, []string{"dataset-1"}, got.Datasets)
+		assert.Empty(t, got.OwnedDatasets)
+		assert.Equal(t, "signed-url", got.AuthSource)
+		assert.Equal(t, "public-key", got.PublicKey)
+	}
+}
+
+func TestTokenMiddleware_SignedURL_Rejected(t *testing.T) {
+	expired := testSignedURLClaims()
+	expired.Exp = time.Now().Add(-time.Minute).Unix()
+
+	pageToken, err := signing.Encode(map[string]any{"f": "file-1", "e": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	sign := func(claims SignedURLClaims) string {
+		signature, err := SignURL(claims)
+		require.NoError(t, err)
+
+		return signature
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "expired", path: "/files/file-1?signature=" + sign(expired)},
+		{name: "other file", path: "/files/file-2?signature=" + sign(testSignedURLClaims())},
+		{name: "other route", path: "/files/file-1/header?signature=" + sign(testSignedURLClaims())},
+		{name: "tampered", path: "/files/file-1?signature=" + sign(testSignedURLClaims()) + "x"},
+		{name: "malformed", path: "/files/file-1?signature=garbage"},
+		{name: "other token", path: "/files/file-1?signature=" + pageToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &capturingLogger{}
+			r, _ := setupSignedURLRouter(t, logger)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), "Invalid or expired signed URL.")
+			require.Len(t, logger.events, 1)
+			assert.Equal(t, audit.EventDenied, logger.events[0].Event)
+		})
+	}
+}
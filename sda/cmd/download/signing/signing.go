@@ -0,0 +1,75 @@
+// Package signing signs and verifies the tokens the download service hands
+// out to clients, such as page tokens and signed download URLs, with a
+// secret shared by all replicas.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformed is returned when a token can not be decoded.
+var ErrMalformed = errors.New("malformed token")
+
+// ErrSignature is returned when the signature of a token does not match its payload.
+var ErrSignature = errors.New("invalid token signature")
+
+// secret holds the HMAC key. Set at startup.
+var secret []byte
+
+// SetSecret sets the HMAC signing key.
+func SetSecret(key []byte) {
+	secret = key
+}
+
+// MAC returns the HMAC-SHA256 of data.
+func MAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// Encode returns the signed token of v.
+// Wire format: base64(json) + "." + base64(hmac-sha256(json)).
+func Encode(v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(MAC(payload)), nil
+}
+
+// Decode verifies the signature of the token and decodes its payload into v.
+func Decode(token string, v any) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrMalformed
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	if !hmac.Equal(MAC(payload), sig) {
+		return ErrSignature
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrMalformed
+	}
+
+	return nil
+}
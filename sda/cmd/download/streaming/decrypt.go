@@ -0,0 +1,90 @@
+package streaming
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	crypt4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+)
+
+// decryptingReader decrypts the body of a crypt4gh file. Seeking moves the
+// encrypted body to the segment holding the new position, so that only that
+// segment is decrypted in vain rather than everything before it.
+type decryptingReader struct {
+	header     []byte
+	body       io.ReadSeekCloser
+	privateKey [32]byte
+	reader     *crypt4ghstreaming.Crypt4GHReader
+	pos        int64
+}
+
+// NewDecryptingReader returns a reader of the plaintext of a crypt4gh file,
+// made up of a header the private key can decrypt and the encrypted body as
+// archived, without a header. Closing the reader closes the body.
+func NewDecryptingReader(header []byte, body io.ReadSeekCloser, privateKey [32]byte) (io.ReadSeekCloser, error) {
+	if body == nil {
+		return nil, errors.New("invalid config: body cannot be nil")
+	}
+
+	r := &decryptingReader{header: header, body: body, privateKey: privateKey}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open starts decrypting the body at its current position, which must be the start of a segment.
+func (r *decryptingReader) open() error {
+	reader, err := crypt4ghstreaming.NewCrypt4GHReader(io.MultiReader(bytes.NewReader(r.header), r.body), r.privateKey, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt header: %w", err)
+	}
+	r.reader = reader
+
+	return nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+
+	return n, err
+}
+
+// Seek implements io.Seeker, seeking relative to the end is not supported.
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, errors.New("seeking from end not supported")
+	}
+	if offset < 0 {
+		return r.pos, errors.New("negative position")
+	}
+
+	segment := offset / SegmentSize
+	if _, err := r.body.Seek(segment*EncryptedSegmentSize, io.SeekStart); err != nil {
+		return r.pos, fmt.Errorf("failed to seek body: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return r.pos, err
+	}
+	r.pos = segment * SegmentSize
+
+	if _, err := io.CopyN(io.Discard, r, offset-r.pos); err != nil && !errors.Is(err, io.EOF) {
+		return r.pos, fmt.Errorf("failed to skip plaintext: %w", err)
+	}
+	// Seeking past the end is allowed, reads then return io.EOF
+	r.pos = offset
+
+	return r.pos, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.body.Close()
+}
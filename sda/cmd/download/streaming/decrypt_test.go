@@ -0,0 +1,91 @@
+package streaming
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	crypt4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptTestFile encrypts random plaintext of the given size, returning the
+// plaintext, the header and body of the crypt4gh file and the private key of
+// its recipient.
+func encryptTestFile(t *testing.T, size int) (plaintext, header, body []byte, privateKey [32]byte) {
+	t.Helper()
+	plaintext = make([]byte, size)
+	_, err := rand.Read(plaintext)
+	require.NoError(t, err)
+
+	publicKey, privateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+
+	var encrypted bytes.Buffer
+	writer, err := crypt4ghstreaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{publicKey}, nil)
+	require.NoError(t, err)
+	_, err = writer.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	reader, err := crypt4ghstreaming.NewCrypt4GHReader(bytes.NewReader(encrypted.Bytes()), privateKey, nil)
+	require.NoError(t, err)
+	header = reader.GetHeader()
+
+	return plaintext, header, encrypted.Bytes()[len(header):], privateKey
+}
+
+func TestDecryptingReader(t *testing.T) {
+	plaintext, header, body, privateKey := encryptTestFile(t, 3*SegmentSize+1234)
+
+	reader, err := NewDecryptingReader(header, newReadSeekCloser(body), privateKey)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	for _, offset := range []int64{0, 10, SegmentSize, 2*SegmentSize + 5, int64(len(plaintext)) - 1, int64(len(plaintext))} {
+		pos, err := reader.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+		assert.Equal(t, offset, pos)
+
+		decrypted, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext[offset:], decrypted, "offset: %d", offset)
+	}
+
+	_, err = reader.Seek(0, io.SeekEnd)
+	assert.Error(t, err)
+	require.NoError(t, reader.Close())
+}
+
+func TestDecryptingReader_StreamBodyOnly(t *testing.T) {
+	plaintext, header, body, privateKey := encryptTestFile(t, 2*SegmentSize+100)
+
+	reader, err := NewDecryptingReader(header, newReadSeekCloser(body), privateKey)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	err = StreamBodyOnly(StreamBodyConfig{
+		Writer:          recorder,
+		FileReader:      reader,
+		ArchiveFileSize: int64(len(plaintext)),
+		Range:           &RangeSpec{Start: SegmentSize - 10, End: SegmentSize + 9},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 206, recorder.Code)
+	assert.Equal(t, plaintext[SegmentSize-10:SegmentSize+10], recorder.Body.Bytes())
+}
+
+func TestDecryptingReader_WrongKey(t *testing.T) {
+	_, header, body, _ := encryptTestFile(t, 100)
+	_, otherKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+
+	_, err = NewDecryptingReader(header, newReadSeekCloser(body), otherKey)
+	assert.Error(t, err)
+}
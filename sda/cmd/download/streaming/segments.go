@@ -0,0 +1,180 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Sizes of the data segments of crypt4gh files, see the crypt4gh specification.
+const (
+	// SegmentSize is the size of a decrypted data segment.
+	SegmentSize = 65536
+	// EncryptedSegmentSize is the size of an encrypted data segment: nonce, data and MAC.
+	EncryptedSegmentSize = 12 + SegmentSize + 16
+)
+
+// ByteRange is a half-open byte range [Start, End).
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// MergeRanges sorts the ranges and merges the ones which overlap or are adjacent.
+// Empty ranges are dropped.
+func MergeRanges(ranges []ByteRange) []ByteRange {
+	sorted := slices.DeleteFunc(slices.Clone(ranges), func(r ByteRange) bool { return r.End <= r.Start })
+	slices.SortFunc(sorted, func(a, b ByteRange) int {
+		switch {
+		case a.Start < b.Start:
+			return -1
+		case a.Start > b.Start:
+			return 1
+		}
+
+		return 0
+	})
+
+	var merged []ByteRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// DecryptedSize returns the size of the decrypted data of a crypt4gh file
+// body, the data segments without the crypt4gh header, of the given size.
+func DecryptedSize(encryptedSize int64) int64 {
+	full := encryptedSize / EncryptedSegmentSize
+	size := full * SegmentSize
+	if rest := encryptedSize % EncryptedSegmentSize; rest > EncryptedSegmentSize-SegmentSize {
+		size += rest - (EncryptedSegmentSize - SegmentSize)
+	}
+
+	return size
+}
+
+// SegmentSelection is the part of a crypt4gh file body needed to decrypt byte ranges
+// of the decrypted file.
+type SegmentSelection struct {
+	// Ranges are the byte ranges of the encrypted body, in order, holding the
+	// data segments of the decrypted ranges.
+	Ranges []ByteRange
+	// EditList is the crypt4gh data edit list, alternating lengths of bytes
+	// to skip and to keep, which reduces the decrypted segments to the
+	// requested ranges.
+	EditList []uint64
+}
+
+// SelectSegments returns the data segments of an encrypted body of the given size
+// which hold the decrypted ranges, and the edit list keeping just the ranges
+// once the segments have been decrypted. The ranges must be sorted and must
+// not overlap, as returned by MergeRanges.
+func SelectSegments(ranges []ByteRange, encryptedSize int64) SegmentSelection {
+	var selection SegmentSelection
+	// Segments selected so far, and the segment after the last run of segments
+	selected := int64(0)
+	runEnd := int64(-1)
+	// Position in the decrypted data of the selected segments
+	position := int64(0)
+
+	for _, r := range ranges {
+		first, last := r.Start/SegmentSize, (r.End-1)/SegmentSize
+
+		// Segments already selected by the previous range are not selected again
+		from := max(first, runEnd)
+		if from <= last {
+			if from == runEnd {
+				selection.Ranges[len(selection.Ranges)-1].End = min((last+1)*EncryptedSegmentSize, encryptedSize)
+			} else {
+				selection.Ranges = append(selection.Ranges, ByteRange{
+					Start: from * EncryptedSegmentSize,
+					End:   min((last+1)*EncryptedSegmentSize, encryptedSize),
+				})
+			}
+			selected += last - from + 1
+			runEnd = last + 1
+		}
+
+		// Offset of the range within the decrypted data of the selected segments
+		start := (selected-(last-first+1))*SegmentSize + r.Start%SegmentSize
+		selection.EditList = append(selection.EditList, uint64(start-position), uint64(r.End-r.Start))
+		position = start + r.End - r.Start
+	}
+
+	return selection
+}
+
+// ParseCoordinates parses the startCoordinate and endCoordinate query
+// parameters, a half-open byte range [start, end) of the decrypted file.
+// Either may be empty: the start defaults to the start of the file and the
+// end to the end of the file, an end past the end of the file is clamped.
+// Returns:
+//   - (nil, nil) if neither is given
+//   - (nil, ErrRangeInvalid) if either is not an integer, or the range is empty
+//   - (nil, ErrRangeNotSatisfiable) if the range starts past the end of the file
+func ParseCoordinates(start, end string, decryptedSize int64) (*ByteRange, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+
+	coordinates := ByteRange{End: decryptedSize}
+	var err error
+	if start != "" {
+		if coordinates.Start, err = strconv.ParseInt(start, 10, 64); err != nil || coordinates.Start < 0 {
+			return nil, ErrRangeInvalid
+		}
+	}
+	if end != "" {
+		if coordinates.End, err = strconv.ParseInt(end, 10, 64); err != nil {
+			return nil, ErrRangeInvalid
+		}
+	}
+	if end != "" && coordinates.End <= coordinates.Start {
+		return nil, ErrRangeInvalid
+	}
+	if coordinates.Start >= decryptedSize {
+		return nil, ErrRangeNotSatisfiable
+	}
+	coordinates.End = min(coordinates.End, decryptedSize)
+
+	return &coordinates, nil
+}
+
+// StreamSegments streams a crypt4gh file made up of the new header and the
+// segments of the encrypted body, typically the segments selected by
+// SelectSegments with the edit list of the selection in the header.
+func StreamSegments(w http.ResponseWriter, newHeader []byte, body io.ReadSeekCloser, segments ByteRange) error {
+	if body == nil {
+		return errors.New("invalid config: FileReader cannot be nil")
+	}
+	defer body.Close()
+
+	if segments.Start < 0 || segments.End < segments.Start {
+		return fmt.Errorf("invalid segments: start=%d, end=%d", segments.Start, segments.End)
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", int64(len(newHeader))+segments.End-segments.Start))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if _, err := w.Write(newHeader); err != nil {
+		return fmt.Errorf("failed to stream header: %w", err)
+	}
+	if err := seekOrSkipBody(body, segments.Start); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, body, segments.End-segments.Start); err != nil {
+		return fmt.Errorf("failed to stream body: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package streaming
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/model/headers"
+	crypt4ghstreaming "github.com/neicnordic/crypt4gh/streaming"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeRanges(t *testing.T) {
+	merged := MergeRanges([]ByteRange{{50, 60}, {0, 10}, {10, 20}, {15, 30}, {40, 40}, {55, 58}})
+	assert.Equal(t, []ByteRange{{0, 30}, {50, 60}}, merged)
+	assert.Nil(t, MergeRanges(nil))
+}
+
+func TestDecryptedSize(t *testing.T) {
+	assert.Equal(t, int64(0), DecryptedSize(0))
+	assert.Equal(t, int64(100), DecryptedSize(100+28))
+	assert.Equal(t, int64(SegmentSize), DecryptedSize(EncryptedSegmentSize))
+	assert.Equal(t, int64(2*SegmentSize+1), DecryptedSize(2*EncryptedSegmentSize+1+28))
+}
+
+func TestSelectSegments_Segments(t *testing.T) {
+	encryptedSize := int64(3*EncryptedSegmentSize + 1000)
+
+	selection := SelectSegments([]ByteRange{{0, 100}, {SegmentSize - 10, SegmentSize + 10}, {3*SegmentSize + 10, 3*SegmentSize + 20}}, encryptedSize)
+
+	// The first two ranges share the first segment and are selected as one run of segments
+	assert.Equal(t, []ByteRange{
+		{0, 2 * EncryptedSegmentSize},
+		{3 * EncryptedSegmentSize, encryptedSize},
+	}, selection.Ranges)
+	assert.Equal(t, []uint64{0, 100, SegmentSize - 110, 20, SegmentSize, 10}, selection.EditList)
+}
+
+// TestSelectSegments_Decrypt decrypts the selected segments of a crypt4gh file with
+// the edit list and checks that exactly the requested ranges are returned.
+func TestSelectSegments_Decrypt(t *testing.T) {
+	plaintext, header, body, privateKey := encryptTestFile(t, 5*SegmentSize+1234)
+	require.Equal(t, int64(len(plaintext)), DecryptedSize(int64(len(body))))
+
+	for _, ranges := range [][]ByteRange{
+		{{0, 10}},
+		{{0, 100}, {200, 300}, {SegmentSize - 5, 3*SegmentSize + 5}, {5 * SegmentSize, int64(len(plaintext))}},
+		{{4*SegmentSize + 1, 4*SegmentSize + 2}, {int64(len(plaintext)) - 28, int64(len(plaintext))}},
+	} {
+		selection := SelectSegments(ranges, int64(len(body)))
+
+		concatenated := bytes.NewBuffer(bytes.Clone(header))
+		for _, r := range selection.Ranges {
+			concatenated.Write(body[r.Start:r.End])
+		}
+		editList := &headers.DataEditListHeaderPacket{
+			PacketType:    headers.PacketType{PacketType: headers.DataEditList},
+			NumberLengths: uint32(len(selection.EditList)),
+			Lengths:       selection.EditList,
+		}
+		decrypter, err := crypt4ghstreaming.NewCrypt4GHReader(concatenated, privateKey, editList)
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(decrypter)
+		require.NoError(t, err)
+
+		var expected []byte
+		for _, r := range ranges {
+			expected = append(expected, plaintext[r.Start:r.End]...)
+		}
+		assert.Equal(t, expected, decrypted, "ranges: %v", ranges)
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	for _, tc := range []struct {
+		start, end string
+		expected   *ByteRange
+		err        error
+	}{
+		{"", "", nil, nil},
+		{"10", "20", &ByteRange{10, 20}, nil},
+		{"10", "", &ByteRange{10, 100}, nil},
+		{"", "20", &ByteRange{0, 20}, nil},
+		{"0", "1000", &ByteRange{0, 100}, nil},
+		{"20", "10", nil, ErrRangeInvalid},
+		{"10", "10", nil, ErrRangeInvalid},
+		{"-1", "10", nil, ErrRangeInvalid},
+		{"a", "10", nil, ErrRangeInvalid},
+		{"0", "b", nil, ErrRangeInvalid},
+		{"100", "200", nil, ErrRangeNotSatisfiable},
+	} {
+		coordinates, err := ParseCoordinates(tc.start, tc.end, 100)
+		assert.ErrorIs(t, err, tc.err, "start: %q, end: %q", tc.start, tc.end)
+		assert.Equal(t, tc.expected, coordinates, "start: %q, end: %q", tc.start, tc.end)
+	}
+}
+
+func TestStreamSegments(t *testing.T) {
+	header := []byte("CRYPT4GH_HEADER_DATA")
+	body := []byte("SEGMENT_0SEGMENT_1SEGMENT_2")
+
+	recorder := httptest.NewRecorder()
+	err := StreamSegments(recorder, header, newReadSeekCloser(body), ByteRange{Start: 9, End: 18})
+
+	require.NoError(t, err)
+	assert.Equal(t, "29", recorder.Header().Get("Content-Length"))
+	assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "CRYPT4GH_HEADER_DATASEGMENT_1", recorder.Body.String())
+}
+
+func TestStreamSegments_InvalidSegments(t *testing.T) {
+	err := StreamSegments(httptest.NewRecorder(), nil, newReadSeekCloser(nil), ByteRange{Start: 10, End: 5})
+	assert.Error(t, err)
+
+	err = StreamSegments(httptest.NewRecorder(), nil, nil, ByteRange{})
+	assert.Error(t, err)
+}
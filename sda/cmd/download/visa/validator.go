@@ -100,6 +100,16 @@ func (v *Validator) GetVisaDatasets(ctx context.Context, identity Identity, rawT
 	return v.processVisas(ctx, identity, passport)
 }
 
+// GetPassportDatasets validates visas presented by the client, such as the
+// visas of a passport posted to a DRS endpoint, returning granted dataset IDs.
+func (v *Validator) GetPassportDatasets(ctx context.Context, identity Identity, visas []string) (*VisaResult, error) {
+	if len(visas) == 0 {
+		return &VisaResult{}, nil
+	}
+
+	return v.processVisas(ctx, identity, visas)
+}
+
 // getPassport retrieves visa JWTs from the configured source.
 func (v *Validator) getPassport(rawToken string, authSource string) ([]string, error) {
 	switch v.config.Source {
@@ -27,22 +27,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	mq, err := broker.NewMQ(conf.Broker)
+	mq, err := broker.NewBus(conf.Broker)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
-
-	go func() {
-		connError := mq.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
+	defer func() {
+		if err := mq.Close(); err != nil {
+			log.Error(err)
+		}
 	}()
 
 	go func() {
-		connError := mq.ChannelWatcher()
+		connError := <-mq.NotifyClose()
 		log.Error(connError)
 		forever <- false
 	}()
@@ -50,7 +47,7 @@ func main() {
 	log.Info("Starting intercept service")
 
 	go func() {
-		messages, err := mq.GetMessages(conf.Broker.Queue)
+		messages, err := mq.Consume(conf.Broker.Queue)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -60,7 +57,7 @@ func main() {
 			msgType, err := typeFromMessage(delivered.Body)
 			if err != nil {
 				log.Errorf("Failed to get type for message (%v), reason: %v", msgType, err.Error())
-				if err := delivered.Ack(false); err != nil {
+				if err := delivered.Ack(); err != nil {
 					log.Errorf("Failed acking canceled work, reason: (%v)", err)
 				}
 				// Restart on new message
@@ -80,21 +77,21 @@ func main() {
 
 			if routingKey == "" {
 				log.Infof("Don't know schema for message type (corr-id: %s, msgType: %s, message: %s)",
-					delivered.CorrelationId, msgType, delivered.Body)
+					delivered.CorrelationID, msgType, delivered.Body)
 
-				unknownSchemaErr := mq.SendMessage(delivered.CorrelationId, conf.Broker.Exchange, "unknown_schema", delivered.Body)
+				unknownSchemaErr := mq.SendMessage(delivered.CorrelationID, conf.Broker.Exchange, "unknown_schema", delivered.Body)
 				if unknownSchemaErr != nil {
 					log.Errorf("Failed to publish message with type: %v, to \"unknown_schema\" queue (corr-id: %s, reason: %v)",
-						msgType, delivered.CorrelationId, unknownSchemaErr)
+						msgType, delivered.CorrelationID, unknownSchemaErr)
 
-					deadErr := mq.SendMessage(delivered.CorrelationId, "sda.dead", "dead", delivered.Body)
+					deadErr := mq.SendMessage(delivered.CorrelationID, "sda.dead", "dead", delivered.Body)
 					if deadErr != nil {
 						log.Errorf("Failed to publish message (get file size error), to error queue (corr-id: %s, reason: %v)",
-							delivered.CorrelationId, deadErr)
+							delivered.CorrelationID, deadErr)
 					}
 				}
 
-				if err := delivered.Ack(false); err != nil {
+				if err := delivered.Ack(); err != nil {
 					log.Errorf("Failed to ack message for reason: %v", err)
 				}
 
@@ -102,11 +99,11 @@ func main() {
 				continue
 			}
 
-			log.Infof("Routing message (correlation-id: %s, routingkey: %s)", delivered.CorrelationId, routingKey)
-			if err := mq.SendMessage(delivered.CorrelationId, conf.Broker.Exchange, routingKey, delivered.Body); err != nil {
+			log.Infof("Routing message (correlation-id: %s, routingkey: %s)", delivered.CorrelationID, routingKey)
+			if err := mq.SendMessage(delivered.CorrelationID, conf.Broker.Exchange, routingKey, delivered.Body); err != nil {
 				log.Errorf("failed to publish message, reason: (%v)", err)
 			}
-			if err := delivered.Ack(false); err != nil {
+			if err := delivered.Ack(); err != nil {
 				log.Errorf("failed to ack message for reason: %v", err)
 			}
 		}
@@ -11,7 +11,6 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,22 +23,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	mq, err := broker.NewMQ(conf.Broker)
+	mq, err := broker.NewBus(conf.Broker)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
-
-	go func() {
-		connError := mq.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
+	defer func() {
+		if err := mq.Close(); err != nil {
+			log.Error(err)
+		}
 	}()
 
 	go func() {
-		connError := mq.ChannelWatcher()
+		connError := <-mq.NotifyClose()
 		log.Error(connError)
 		forever <- false
 	}()
@@ -47,7 +43,7 @@ func main() {
 	log.Infof("Starting %s notify service", conf.Broker.Queue)
 
 	go func() {
-		messages, err := mq.GetMessages(conf.Broker.Queue)
+		messages, err := mq.Consume(conf.Broker.Queue)
 		if err != nil {
 			log.Fatalf("Failed to get message from mq (error: %v)", err)
 		}
@@ -70,14 +66,14 @@ func main() {
 			if err := sendEmail(conf.Notify, "THIS SHOULD TAKE A TEMPLATE", user, setSubject(conf.Broker.Queue)); err != nil {
 				log.Errorf("Failed to send email, error %v", err)
 
-				if e := d.Nack(false, false); e != nil {
+				if e := d.Nack(false); e != nil {
 					log.Errorf("Failed to Nack message, error: %v) ", e)
 				}
 
 				continue
 			}
 
-			if err := d.Ack(false); err != nil {
+			if err := d.Ack(); err != nil {
 				log.Errorf("Failed to ack message, error %v", err)
 			}
 		}
@@ -141,7 +137,7 @@ func setSubject(queue string) string {
 	}
 }
 
-func validator(queue, schemaPath string, delivery amqp091.Delivery) error {
+func validator(queue, schemaPath string, delivery broker.Delivery) error {
 	switch queue {
 	case err:
 		if err := schema.ValidateJSON(fmt.Sprintf("%s/info-error.json", schemaPath), delivery.Body); err != nil {
@@ -6,9 +6,9 @@ import (
 	"testing"
 
 	smtpmock "github.com/mocktools/go-smtp-mock"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -62,7 +62,7 @@ func TestSetSubject(t *testing.T) {
 }
 
 func TestValidator(t *testing.T) {
-	d := amqp091.Delivery{}
+	d := broker.Delivery{}
 
 	archivedMsg := schema.IngestionVerification{
 		User:        "JohnDoe",
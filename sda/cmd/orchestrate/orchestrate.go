@@ -13,7 +13,6 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
-	"github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -63,18 +62,21 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	mq, err := broker.NewMQ(conf.Broker)
+	mq, err := broker.NewBus(conf.Broker)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer mq.Channel.Close()
-	defer mq.Connection.Close()
+	defer func() {
+		if err := mq.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
 
 	queues := []string{conf.Orchestrator.QueueInbox, conf.Orchestrator.QueueVerify, conf.Orchestrator.QueueComplete}
 
 	go func() {
-		connError := mq.ConnectionWatcher()
+		connError := <-mq.NotifyClose()
 		log.Error(connError)
 		os.Exit(1)
 	}()
@@ -96,10 +98,10 @@ func main() {
 	<-forever
 }
 
-func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *config.Config) {
+func processQueue(mq broker.Bus, queue string, routingKey string, conf *config.Config) {
 	log.Infof("Monitoring queue: %s", queue)
 
-	messages, err := mq.GetMessages(queue)
+	messages, err := mq.Consume(queue)
 	if err != nil {
 		log.Fatal(err) // nolint # FIXME Fatal should only be called from main
 	}
@@ -111,10 +113,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 		if err != nil {
 			log.Error(err.Error())
 
-			if err := delivered.Ack(false); err != nil {
+			if err := delivered.Ack(); err != nil {
 				log.Errorf("failed to ack message: %v", err)
 			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
+			if err := mq.SendMessage(delivered.CorrelationID, conf.Broker.Exchange, "error", delivered.Body); err != nil {
 				log.Errorf("failed to send error message: %v", err)
 			}
 
@@ -126,10 +128,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 		if err != nil {
 			log.Errorf("Message validation failed (schema: %v, error: %v, message: %s)", schemaType, err, delivered.Body)
 
-			if err := delivered.Ack(false); err != nil {
+			if err := delivered.Ack(); err != nil {
 				log.Errorf("failed to ack message: %v", err)
 			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
+			if err := mq.SendMessage(delivered.CorrelationID, conf.Broker.Exchange, "error", delivered.Body); err != nil {
 				log.Errorf("failed to send error message: %v", err)
 			}
 
@@ -144,10 +146,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 		if err != nil {
 			log.Errorf("Don't know schema for routing key: %v", routingKey)
 
-			if err := delivered.Ack(false); err != nil {
+			if err := delivered.Ack(); err != nil {
 				log.Errorf("failed to ack message: %v", err)
 			}
-			if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, "error", delivered.Body); err != nil {
+			if err := mq.SendMessage(delivered.CorrelationID, conf.Broker.Exchange, "error", delivered.Body); err != nil {
 				log.Errorf("failed to send error message: %v", err)
 			}
 
@@ -157,10 +159,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 		switch routingKey {
 		case conf.Orchestrator.QueueAccession:
 			publishMsg, publishType = finalizeMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
+			err = validateMsg(&delivered, mq, conf.Broker, routingKey, routingSchema, publishMsg, publishType)
 			if err != nil {
 				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
+				if err := delivered.Nack(true); err != nil {
 					log.Errorf("failed to nack message for reason: %v", err)
 				}
 
@@ -168,10 +170,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 			}
 		case conf.Orchestrator.QueueIngest:
 			publishMsg, publishType = ingestMessage(delivered.Body)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
+			err = validateMsg(&delivered, mq, conf.Broker, routingKey, routingSchema, publishMsg, publishType)
 			if err != nil {
 				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
+				if err := delivered.Nack(true); err != nil {
 					log.Errorf("failed to nack message for reason: %v", err)
 				}
 
@@ -179,10 +181,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 			}
 		case conf.Orchestrator.QueueMapping:
 			publishMsg, publishType = mappingMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
+			err = validateMsg(&delivered, mq, conf.Broker, routingKey, routingSchema, publishMsg, publishType)
 			if err != nil {
 				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
+				if err := delivered.Nack(true); err != nil {
 					log.Errorf("failed to nack message for reason: %v", err)
 				}
 
@@ -191,10 +193,10 @@ func processQueue(mq *broker.AMQPBroker, queue string, routingKey string, conf *
 			// let us wait a minute before sending the release message
 			time.Sleep(conf.Orchestrator.ReleaseDelay * time.Minute)
 			publishMsg, publishType = releaseMessage(delivered.Body, conf)
-			err = validateMsg(&delivered, mq, routingKey, routingSchema, publishMsg, publishType)
+			err = validateMsg(&delivered, mq, conf.Broker, routingKey, routingSchema, publishMsg, publishType)
 			if err != nil {
 				log.Errorf("Validation of outgoing message failed, error: %v", err)
-				if err := delivered.Nack(false, true); err != nil {
+				if err := delivered.Nack(true); err != nil {
 					log.Errorf("failed to nack message for reason: %v", err)
 				}
 
@@ -375,19 +377,19 @@ func releaseMessage(body []byte, conf *config.Config) ([]byte, any) {
 	return publish, new(mapping)
 }
 
-func validateMsg(delivered *amqp091.Delivery, mq *broker.AMQPBroker, routingKey string, routingSchema string, publishMsg []byte, publishType any) error {
-	err := schema.ValidateJSON(fmt.Sprintf("%s/%s.json", mq.Conf.SchemasPath, routingSchema), delivered.Body)
+func validateMsg(delivered *broker.Delivery, mq broker.Bus, conf broker.MQConf, routingKey string, routingSchema string, publishMsg []byte, publishType any) error {
+	err := schema.ValidateJSON(fmt.Sprintf("%s/%s.json", conf.SchemasPath, routingSchema), delivered.Body)
 	if err != nil {
 		return err
 	}
 
-	log.Debugf("Routing message (correlation-id: %s, routingkey: %s, message: %s)", delivered.CorrelationId, routingKey, publishMsg)
+	log.Debugf("Routing message (correlation-id: %s, routingkey: %s, message: %s)", delivered.CorrelationID, routingKey, publishMsg)
 
-	if err := mq.SendMessage(delivered.CorrelationId, mq.Conf.Exchange, routingKey, publishMsg); err != nil {
-		// TODO fix resend mechanism
-		log.Errorln("We need to fix this resend stuff ...")
+	// The message is requeued by the caller if it could not be published, so that it is routed again
+	if err := mq.SendMessage(delivered.CorrelationID, conf.Exchange, routingKey, publishMsg); err != nil {
+		return fmt.Errorf("failed to publish message, due to: %v", err)
 	}
-	if err := delivered.Ack(false); err != nil {
+	if err := delivered.Ack(); err != nil {
 		log.Errorf("failed to ack message for reason: %v", err)
 	}
 
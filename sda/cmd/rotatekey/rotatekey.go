@@ -27,7 +27,7 @@ import (
 
 type RotateKey struct {
 	Conf          *config.Config
-	MQ            *broker.AMQPBroker
+	MQ            broker.Bus
 	DB            *database.SDAdb
 	PubKeyEncoded string
 }
@@ -43,8 +43,7 @@ func main() {
 	defer func() {
 		if err := recover(); err != nil {
 			if app.MQ != nil {
-				defer app.MQ.Channel.Close()
-				defer app.MQ.Connection.Close()
+				defer app.MQ.Close()
 			}
 			if app.DB != nil {
 				defer app.DB.Close()
@@ -59,7 +58,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	app.MQ, err = broker.NewMQ(app.Conf.Broker)
+	app.MQ, err = broker.NewBus(app.Conf.Broker)
 	if err != nil {
 		panic(err)
 	}
@@ -76,8 +75,7 @@ func main() {
 	go func() {
 		<-sigc // blocks here until it receives from sigc
 		_, _ = fmt.Println("Interrupt signal received. Shutting down.")
-		defer app.MQ.Channel.Close()
-		defer app.MQ.Connection.Close()
+		defer app.MQ.Close()
 		defer app.DB.Close()
 
 		os.Exit(0) // exit program
@@ -97,13 +95,7 @@ func main() {
 	}
 
 	go func() {
-		connError := app.MQ.ConnectionWatcher()
-		log.Error(connError)
-		forever <- false
-	}()
-
-	go func() {
-		connError := app.MQ.ChannelWatcher()
+		connError := <-app.MQ.NotifyClose()
 		log.Error(connError)
 		forever <- false
 	}()
@@ -116,8 +108,7 @@ func main() {
 		defer func() {
 			if err := recover(); err != nil {
 				if app.MQ != nil {
-					defer app.MQ.Channel.Close()
-					defer app.MQ.Connection.Close()
+					defer app.MQ.Close()
 				}
 				if app.DB != nil {
 					defer app.DB.Close()
@@ -125,13 +116,13 @@ func main() {
 				log.Fatal(err)
 			}
 		}()
-		messages, err := app.MQ.GetMessages(app.Conf.Broker.Queue)
+		messages, err := app.MQ.Consume(app.Conf.Broker.Queue)
 		if err != nil {
 			panic(err)
 		}
 		for delivered := range messages {
 			log.Debugf("Received a message (correlation-id: %s, message: %s)",
-				delivered.CorrelationId,
+				delivered.CorrelationID,
 				delivered.Body)
 
 			err := schema.ValidateJSON(fmt.Sprintf("%s/rotate-key.json", app.Conf.Broker.SchemasPath), delivered.Body)
@@ -145,10 +136,10 @@ func main() {
 					OriginalMessage: string(delivered.Body),
 				}
 				body, _ := json.Marshal(infoErrorMessage)
-				if err := app.MQ.SendMessage(delivered.CorrelationId, app.Conf.Broker.Exchange, "error", body); err != nil {
+				if err := app.MQ.SendMessage(delivered.CorrelationID, app.Conf.Broker.Exchange, "error", body); err != nil {
 					log.Errorf("failed to publish message, reason: (%s)", err.Error())
 				}
-				if err := delivered.Ack(false); err != nil {
+				if err := delivered.Ack(); err != nil {
 					log.Errorf("failed to Ack message, reason: (%s)", err.Error())
 				}
 
@@ -170,7 +161,7 @@ func main() {
 
 			switch ackNack {
 			case "ack":
-				if err := delivered.Ack(false); err != nil {
+				if err := delivered.Ack(); err != nil {
 					log.Errorf("failed to ack message, reason: %v", err)
 				}
 			case "ackSendToError":
@@ -180,19 +171,19 @@ func main() {
 					OriginalMessage: string(delivered.Body),
 				}
 				body, _ := json.Marshal(infoErrorMessage)
-				if err := app.MQ.SendMessage(delivered.CorrelationId, app.Conf.Broker.Exchange, "error", body); err != nil {
+				if err := app.MQ.SendMessage(delivered.CorrelationID, app.Conf.Broker.Exchange, "error", body); err != nil {
 					log.Errorf("failed to publish message, reason: (%s)", err.Error())
 				}
-				if err := delivered.Ack(false); err != nil {
+				if err := delivered.Ack(); err != nil {
 					log.Errorf("failed to Ack message, reason: (%s)", err.Error())
 				}
 			case "nackRequeue":
-				if err := delivered.Nack(false, true); err != nil {
+				if err := delivered.Nack(true); err != nil {
 					log.Errorf("failed to Nack message, reason: %v", err)
 				}
 			default:
 				// will catch `reject`s, failures that should not be requeued.
-				if err := delivered.Reject(false); err != nil {
+				if err := delivered.Reject(); err != nil {
 					log.Errorf("failed to reject message, reason: %v", err)
 				}
 			}
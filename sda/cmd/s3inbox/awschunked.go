@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// isAWSChunked reports whether the body of the request is sent in aws-chunked encoding
+func isAWSChunked(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-")
+}
+
+// decodeAWSChunked returns the object data in the start of a body in aws-chunked encoding
+func decodeAWSChunked(data []byte) []byte {
+	var decoded bytes.Buffer
+	_, _ = (&awsChunkedWriter{w: &decoded}).Write(data)
+
+	return decoded.Bytes()
+}
+
+// maxChunkHeaderSize limits the chunk headers of aws-chunked encoding, which hold the chunk size and signature
+const maxChunkHeaderSize = 4096
+
+// awsChunkedWriter writes the object data of a body in aws-chunked encoding to w, leaving out the chunk headers and
+// trailers, see https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html. Writing stops at malformed
+// encoding, which leaves the object data incomplete, and never fails such that forwarding of the body is not affected.
+type awsChunkedWriter struct {
+	w         io.Writer
+	header    []byte // the part of the current chunk header that has been written
+	remaining int64  // the data of the current chunk that is left
+	skip      int    // the line break after the chunk data that is left
+	done      bool   // the final chunk has been written, or the encoding is malformed
+}
+
+func (c *awsChunkedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && !c.done {
+		switch {
+		case c.skip > 0:
+			skipped := min(c.skip, len(p))
+			c.skip -= skipped
+			p = p[skipped:]
+		case c.remaining > 0:
+			data := int(min(c.remaining, int64(len(p))))
+			_, _ = c.w.Write(p[:data])
+			c.remaining -= int64(data)
+			p = p[data:]
+			if c.remaining == 0 {
+				c.skip = len("\r\n")
+			}
+		default:
+			end := bytes.IndexByte(p, '\n')
+			if end < 0 {
+				c.header = append(c.header, p...)
+				c.done = len(c.header) > maxChunkHeaderSize
+				p = nil
+
+				continue
+			}
+			c.header = append(c.header, p[:end]...)
+			p = p[end+1:]
+
+			size, _, _ := strings.Cut(strings.TrimSpace(string(c.header)), ";")
+			c.header = c.header[:0]
+			remaining, err := strconv.ParseInt(size, 16, 64)
+			c.remaining = max(remaining, 0)
+			c.done = err != nil || remaining <= 0
+		}
+	}
+
+	return n, nil
+}
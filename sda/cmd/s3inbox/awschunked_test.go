@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *ProxyTests) TestAWSChunkedWriter() {
+	encoded := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	assert.Equal(s.T(), []byte("hello world"), decodeAWSChunked([]byte(encoded)))
+
+	// The body may be split anywhere
+	var decoded bytes.Buffer
+	w := &awsChunkedWriter{w: &decoded}
+	for i := range len(encoded) {
+		n, err := w.Write([]byte{encoded[i]})
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), 1, n)
+	}
+	assert.Equal(s.T(), "hello world", decoded.String())
+
+	// Writing stops at malformed encoding
+	assert.Equal(s.T(), []byte("hello"), decodeAWSChunked([]byte("5\r\nhello\r\nnot hex\r\n world")))
+}
+
+func (s *ProxyTests) TestValidCrypt4GHHeader_awsChunked() {
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	proxy := &Proxy{s3Conf: config.S3InboxConf{ValidateHeader: true, C4ghPrivateKeyList: []*[32]byte{&archivePrivateKey}}}
+
+	encrypted := encryptForTest(s.T(), []byte("some genomic data"), archivePublicKey)
+	var body bytes.Buffer
+	for chunk := range slices.Chunk(encrypted, 64) {
+		fmt.Fprintf(&body, "%x;chunk-signature=abc\r\n%s\r\n", len(chunk), chunk)
+	}
+	body.WriteString("0;chunk-signature=def\r\n\r\n")
+
+	r, _ := http.NewRequest("PUT", "/dummy/file", bytes.NewReader(body.Bytes()))
+	r.Header.Set("x-amz-content-sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+	w := httptest.NewRecorder()
+	assert.True(s.T(), proxy.validCrypt4GHHeader(w, r, "dummy", &audit.Event{}))
+
+	// Without the encoding header the chunk headers are taken as the start of the file
+	r, _ = http.NewRequest("PUT", "/dummy/file", bytes.NewReader(body.Bytes()))
+	w = httptest.NewRecorder()
+	assert.False(s.T(), proxy.validCrypt4GHHeader(w, r, "dummy", &audit.Event{}))
+	assert.Equal(s.T(), http.StatusBadRequest, w.Result().StatusCode)
+}
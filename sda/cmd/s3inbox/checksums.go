@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+)
+
+// uploadChecksums are the checksums of the object data in the body of an upload request, computed as the body is
+// forwarded to the backend
+type uploadChecksums struct {
+	md5 hash.Hash
+	// sha256 is the SHA256 of the object up to and including the request, nil when it is not known
+	sha256 hash.Hash
+	size   int64
+}
+
+// newUploadChecksums returns the checksums for an upload request that holds the start of an object if first is set,
+// or that continues the object data hashed into sha256State otherwise. The SHA256 is not computed when neither is
+// known.
+func newUploadChecksums(first bool, sha256State []byte) (*uploadChecksums, error) {
+	checksums := &uploadChecksums{md5: md5.New()}
+	switch {
+	case first:
+		checksums.sha256 = sha256.New()
+	case sha256State != nil:
+		checksums.sha256 = sha256.New()
+		unmarshaler, ok := checksums.sha256.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return nil, errors.New("the sha256 state can not be restored")
+		}
+		if err := unmarshaler.UnmarshalBinary(sha256State); err != nil {
+			return nil, err
+		}
+	}
+
+	return checksums, nil
+}
+
+func (c *uploadChecksums) Write(p []byte) (int, error) {
+	_, _ = c.md5.Write(p)
+	if c.sha256 != nil {
+		_, _ = c.sha256.Write(p)
+	}
+	c.size += int64(len(p))
+
+	return len(p), nil
+}
+
+// sha256Checksum returns the hex encoded SHA256, or an empty string when it is not known
+func (c *uploadChecksums) sha256Checksum() string {
+	if c.sha256 == nil {
+		return ""
+	}
+
+	return hex.EncodeToString(c.sha256.Sum(nil))
+}
+
+// sha256State returns the marshalled state of the SHA256, from which it can be continued by the next part of a
+// multipart upload, or nil when it is not known
+func (c *uploadChecksums) sha256State() ([]byte, error) {
+	if c.sha256 == nil {
+		return nil, nil
+	}
+	marshaler, ok := c.sha256.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.New("the sha256 state can not be saved")
+	}
+
+	return marshaler.MarshalBinary()
+}
+
+// hashBody feeds the object data in the body of the request to the checksums as the body is read
+func hashBody(r *http.Request, checksums *uploadChecksums) {
+	if r.Body == nil {
+		return
+	}
+
+	var w io.Writer = checksums
+	if isAWSChunked(r) {
+		w = &awsChunkedWriter{w: checksums}
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, w), r.Body}
+}
+
+// partChecksums returns the checksums for a part of a multipart upload, where the SHA256 continues from the recorded
+// state of the preceding part. As the state is only recorded once a part has been passed on, the SHA256 is only known
+// for parts uploaded in sequence, a part uploaded before the preceding part, or concurrently with it, has no SHA256.
+func (p *Proxy) partChecksums(ctx context.Context, uploadID string, partNumber int) (*uploadChecksums, error) {
+	if partNumber == 1 {
+		return newUploadChecksums(true, nil)
+	}
+
+	previous, err := p.database.GetInboxUploadPart(ctx, uploadID, partNumber-1)
+	if err != nil || previous == nil {
+		return newUploadChecksums(false, nil)
+	}
+
+	return newUploadChecksums(false, previous.SHA256State)
+}
+
+// multipartChecksums combines the recorded checksums of the parts of a completed multipart upload into the SHA256
+// and the S3 style MD5 of the object, and returns the size of the parts together. The SHA256 is only known when the
+// upload consists of the parts 1 to N uploaded in order, and no checksums are returned unless all parts are recorded.
+func (p *Proxy) multipartChecksums(ctx context.Context, uploadID string, partNumbers []int) (string, string, int64, error) {
+	recorded, err := p.database.GetInboxUploadParts(ctx, uploadID)
+	if err != nil || len(partNumbers) == 0 {
+		return "", "", 0, err
+	}
+
+	parts := make(map[int]database.InboxUploadPart, len(recorded))
+	for _, part := range recorded {
+		parts[part.Number] = part
+	}
+
+	combined := md5.New()
+	inOrder := true
+	var size int64
+	var last database.InboxUploadPart
+	for i, number := range partNumbers {
+		part, ok := parts[number]
+		if !ok {
+			return "", "", 0, nil
+		}
+		digest, err := hex.DecodeString(part.MD5)
+		if err != nil {
+			return "", "", 0, err
+		}
+		_, _ = combined.Write(digest)
+		size += part.Size
+		inOrder = inOrder && number == i+1
+		last = part
+	}
+	md5Checksum := fmt.Sprintf("%x-%d", combined.Sum(nil), len(partNumbers))
+
+	if !inOrder || last.SHA256State == nil {
+		return "", md5Checksum, size, nil
+	}
+
+	checksums, err := newUploadChecksums(false, last.SHA256State)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return checksums.sha256Checksum(), md5Checksum, size, nil
+}
+
+// completedPartNumbers returns the part numbers listed in the body of a CompleteMultipartUpload request, or nil if
+// the body can not be parsed, and leaves the body intact for forwarding
+func completedPartNumbers(r *http.Request) ([]int, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{bytes.NewReader(body), r.Body}
+
+	var complete struct {
+		Parts []struct {
+			PartNumber int `xml:"PartNumber"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		return nil, nil
+	}
+
+	partNumbers := make([]int, 0, len(complete.Parts))
+	for _, part := range complete.Parts {
+		partNumbers = append(partNumbers, part.PartNumber)
+	}
+
+	return partNumbers, nil
+}
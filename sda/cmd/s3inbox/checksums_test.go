@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *ProxyTests) TestUploadChecksums_continued() {
+	first, err := newUploadChecksums(true, nil)
+	assert.NoError(s.T(), err)
+	_, _ = first.Write([]byte("genomic "))
+	state, err := first.sha256State()
+	assert.NoError(s.T(), err)
+
+	second, err := newUploadChecksums(false, state)
+	assert.NoError(s.T(), err)
+	_, _ = second.Write([]byte("data"))
+	expected := sha256.Sum256([]byte("genomic data"))
+	assert.Equal(s.T(), hex.EncodeToString(expected[:]), second.sha256Checksum())
+	assert.Equal(s.T(), int64(4), second.size)
+
+	// Without the state of the preceding part the sha256 is not known
+	unknown, err := newUploadChecksums(false, nil)
+	assert.NoError(s.T(), err)
+	_, _ = unknown.Write([]byte("data"))
+	assert.Equal(s.T(), "", unknown.sha256Checksum())
+	state, err = unknown.sha256State()
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), state)
+
+	_, err = newUploadChecksums(false, []byte("not a sha256 state"))
+	assert.Error(s.T(), err)
+}
+
+func (s *ProxyTests) TestHashBody_awsChunked() {
+	r, _ := http.NewRequest("PUT", "/dummy/file", strings.NewReader("5;chunk-signature=abc\r\nhello\r\n0;chunk-signature=def\r\n\r\n"))
+	r.Header.Set("x-amz-content-sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+	checksums, err := newUploadChecksums(true, nil)
+	assert.NoError(s.T(), err)
+	hashBody(r, checksums)
+
+	// The body is forwarded unchanged
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "5;chunk-signature=abc\r\nhello\r\n0;chunk-signature=def\r\n\r\n", string(body))
+	expected := sha256.Sum256([]byte("hello"))
+	assert.Equal(s.T(), hex.EncodeToString(expected[:]), checksums.sha256Checksum())
+	assert.Equal(s.T(), int64(5), checksums.size)
+}
+
+func (s *ProxyTests) TestCompletedPartNumbers() {
+	body := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>a</ETag></Part><Part><PartNumber>3</PartNumber><ETag>b</ETag></Part></CompleteMultipartUpload>"
+	r, _ := http.NewRequest("POST", "/dummy/file?uploadId=1", strings.NewReader(body))
+	partNumbers, err := completedPartNumbers(r)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []int{1, 3}, partNumbers)
+
+	// The body is left intact for forwarding
+	forwarded, err := io.ReadAll(r.Body)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), body, string(forwarded))
+
+	r, _ = http.NewRequest("POST", "/dummy/file?uploadId=1", strings.NewReader("not xml"))
+	partNumbers, err = completedPartNumbers(r)
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), partNumbers)
+}
+
+func (s *ProxyTests) TestServeHTTP_uploadChecksums() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	messenger, err := broker.NewMQ(s.MQConf)
+	assert.NoError(s.T(), err)
+	defer messenger.Connection.Close()
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, db, new(tls.Config))
+
+	// Single part uploads
+	data := []byte("single part upload")
+	r, _ := http.NewRequest("PUT", "/dummy/checksum-single.c4gh", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+
+	fileID, err := db.GetFileIDInInbox(context.TODO(), "dummy", "checksum-single.c4gh")
+	assert.NoError(s.T(), err)
+	checksum, err := db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(s.T(), err)
+	expected := sha256.Sum256(data)
+	assert.Equal(s.T(), hex.EncodeToString(expected[:]), checksum)
+
+	// Multipart uploads, where all parts but the last have to be at least 5 MiB
+	data = append(bytes.Repeat([]byte("a"), 5*1024*1024), []byte("last part")...)
+	r, _ = http.NewRequest("POST", "/dummy/checksum-multipart.c4gh?uploads", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	assert.NoError(s.T(), xml.Unmarshal(w.Body.Bytes(), &initiated))
+
+	complete := "<CompleteMultipartUpload>"
+	for i, part := range [][]byte{data[:5*1024*1024], data[5*1024*1024:]} {
+		r, _ = http.NewRequest("PUT", fmt.Sprintf("/dummy/checksum-multipart.c4gh?partNumber=%d&uploadId=%s", i+1, initiated.UploadID), bytes.NewReader(part))
+		w = httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		assert.Equal(s.T(), 200, w.Result().StatusCode)
+		complete += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, w.Result().Header.Get("ETag"))
+	}
+	complete += "</CompleteMultipartUpload>"
+
+	parts, err := db.GetInboxUploadParts(context.TODO(), initiated.UploadID)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), parts, 2)
+
+	r, _ = http.NewRequest("POST", "/dummy/checksum-multipart.c4gh?uploadId="+initiated.UploadID, strings.NewReader(complete))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+
+	fileID, err = db.GetFileIDInInbox(context.TODO(), "dummy", "checksum-multipart.c4gh")
+	assert.NoError(s.T(), err)
+	checksum, err = db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(s.T(), err)
+	expected = sha256.Sum256(data)
+	assert.Equal(s.T(), hex.EncodeToString(expected[:]), checksum)
+
+	// The parts are removed once the upload completes
+	parts, err = db.GetInboxUploadParts(context.TODO(), initiated.UploadID)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), parts)
+}
+
+func (s *ProxyTests) TestServeHTTP_uploadChecksums_outOfOrder() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	messenger, err := broker.NewMQ(s.MQConf)
+	assert.NoError(s.T(), err)
+	defer messenger.Connection.Close()
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, db, new(tls.Config))
+
+	data := append(bytes.Repeat([]byte("b"), 5*1024*1024), []byte("last part")...)
+	r, _ := http.NewRequest("POST", "/dummy/checksum-out-of-order.c4gh?uploads", http.NoBody)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	assert.NoError(s.T(), xml.Unmarshal(w.Body.Bytes(), &initiated))
+
+	// The last part is uploaded first, as clients uploading parts in parallel may do
+	parts := [][]byte{data[:5*1024*1024], data[5*1024*1024:]}
+	etags := make([]string, len(parts))
+	combined := md5.New()
+	for _, i := range []int{1, 0} {
+		r, _ = http.NewRequest("PUT", fmt.Sprintf("/dummy/checksum-out-of-order.c4gh?partNumber=%d&uploadId=%s", i+1, initiated.UploadID), bytes.NewReader(parts[i]))
+		w = httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+		assert.Equal(s.T(), 200, w.Result().StatusCode)
+		etags[i] = w.Result().Header.Get("ETag")
+	}
+	complete := "<CompleteMultipartUpload>"
+	for i, part := range parts {
+		complete += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etags[i])
+		digest := md5.Sum(part)
+		_, _ = combined.Write(digest[:])
+	}
+	complete += "</CompleteMultipartUpload>"
+
+	r, _ = http.NewRequest("POST", "/dummy/checksum-out-of-order.c4gh?uploadId="+initiated.UploadID, strings.NewReader(complete))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+
+	// The SHA256 is not known, while the MD5 is combined from the parts
+	fileID, err := db.GetFileIDInInbox(context.TODO(), "dummy", "checksum-out-of-order.c4gh")
+	assert.NoError(s.T(), err)
+	checksum, err := db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), checksum)
+
+	var message []byte
+	assert.NoError(s.T(), db.DB.QueryRow("SELECT message FROM sda.file_event_log WHERE file_id = $1 AND event = 'uploaded' ORDER BY id DESC LIMIT 1;", fileID).Scan(&message))
+	var event Event
+	assert.NoError(s.T(), json.Unmarshal(message, &event))
+	assert.Equal(s.T(), []any{map[string]any{"type": "md5", "value": fmt.Sprintf("%x-2", combined.Sum(nil))}}, event.Checksum)
+}
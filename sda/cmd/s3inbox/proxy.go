@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
@@ -10,19 +12,22 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/minio/minio-go/v6/pkg/signer"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inbox"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 	log "github.com/sirupsen/logrus"
@@ -36,6 +41,19 @@ type Proxy struct {
 	messenger *broker.AMQPBroker
 	database  *database.SDAdb
 	client    *http.Client
+	// auditLogger records uploads and denied requests, set by main
+	auditLogger audit.Logger
+}
+
+// statusRecorder records the status code written to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
 }
 
 // The Event struct
@@ -63,6 +81,13 @@ type ErrorResponse struct {
 	Message string   `xml:"Message"`
 }
 
+// CopyObjectResult is the response to a CopyObject request
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
 // The different types of requests
 const (
 	Unsupported S3RequestType = iota
@@ -76,6 +101,9 @@ const (
 	ListParts
 	AbortMultiPartUpload
 	GetBucketLocation
+	HeadObject
+	CopyObject
+	DeleteObject
 )
 
 // NewProxy creates a new S3Proxy. This implements the ServerHTTP interface.
@@ -84,38 +112,87 @@ func NewProxy(s3conf config.S3InboxConf, s3Client *s3.Client, auth userauth.Auth
 	client := &http.Client{Transport: tr, Timeout: 30 * time.Second}
 
 	return &Proxy{
-		s3Conf:    s3conf,
-		s3Client:  s3Client,
-		auth:      auth,
-		messenger: messenger,
-		database:  db,
-		client:    client,
+		s3Conf:      s3conf,
+		s3Client:    s3Client,
+		auth:        auth,
+		messenger:   messenger,
+		database:    db,
+		client:      client,
+		auditLogger: audit.NoopLogger{},
 	}
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := audit.Event{Path: r.URL.Path}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
 	token, err := p.auth.Authenticate(r)
 	if err != nil {
 		log.Warnf("unauthorized user attempted: method: %s, path: %s, query: %s", r.Method, r.URL.Path, r.URL.RawQuery)
-		reportErrorToClient(http.StatusUnauthorized, "Unauthorized", w)
+		reportErrorToClient(http.StatusUnauthorized, "Unauthorized", rec)
+		event.Event = audit.EventUploadDenied
+		event.ErrorReason = "authentication failed: " + err.Error()
+		p.audit(r.Context(), event, rec)
 
 		return
 	}
+	event.UserID = token.Subject()
 
 	s3RequestType := detectS3RequestType(r)
 	switch s3RequestType {
 	// These actions we just forward to the s3 backend after ensuring that requests have been made user specific by
 	// prepareForwardPathAndQuery
-	case ListObjects, ListObjectsV2, GetBucketLocation, UploadPart, ListMultiPartUploads, AbortMultiPartUpload, ListParts:
-		p.forwardRequest(s3RequestType, w, r, token)
+	case ListObjects, ListObjectsV2, GetBucketLocation, HeadObject, ListMultiPartUploads, AbortMultiPartUpload, ListParts:
+		p.forwardRequest(s3RequestType, rec, r, token)
+		if s3RequestType == AbortMultiPartUpload {
+			if rec.status == http.StatusOK {
+				if err := p.database.DeleteInboxUploadParts(r.Context(), r.URL.Query().Get("uploadId")); err != nil {
+					log.Errorf("user: %s, failed to remove the checksums of aborted upload: %v", token.Subject(), err)
+				}
+			}
+			event.Event = audit.EventUploadAborted
+			p.audit(r.Context(), event, rec)
+		}
+	case UploadPart:
+		p.handleUploadPart(rec, r, token, &event)
+		if event.Event == audit.EventUploadDenied {
+			p.audit(r.Context(), event, rec)
+		}
 	case PutObject, CreateMultiPartUpload, CompleteMultiPartUpload:
-		p.handleUpload(s3RequestType, w, r, token)
+		p.handleUpload(s3RequestType, rec, r, token, &event)
+		if event.Event == audit.EventUploadDenied {
+			p.audit(r.Context(), event, rec)
+
+			return
+		}
+		if s3RequestType != CreateMultiPartUpload {
+			event.Event = audit.EventUploadCompleted
+			if rec.status != http.StatusOK {
+				event.Event = audit.EventUploadFailed
+			}
+			p.audit(r.Context(), event, rec)
+		}
+	case CopyObject:
+		p.handleCopy(rec, r, token, &event)
+		p.audit(r.Context(), event, rec)
+	case DeleteObject:
+		p.handleRemove(rec, r, token, &event)
+		p.audit(r.Context(), event, rec)
 	default:
 		log.Warnf("user: %s, attempted to do not allowed request: method: %s, path: %s, query: %s", token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery)
-		reportErrorToClient(http.StatusForbidden, "Forbidden", w)
+		reportErrorToClient(http.StatusForbidden, "Forbidden", rec)
+		event.Event = audit.EventUploadDenied
+		event.ErrorReason = "request not allowed: " + r.Method + " " + r.URL.RawQuery
+		p.audit(r.Context(), event, rec)
 	}
 }
 
+// audit records the event with the status written to the client
+func (p *Proxy) audit(ctx context.Context, event audit.Event, rec *statusRecorder) {
+	event.HTTPStatus = rec.status
+	p.auditLogger.Log(ctx, event)
+}
+
 // Report 500 to the user, log the original error
 func (p *Proxy) internalServerError(w http.ResponseWriter, tokenSubject, httpMethod, path, query, err string) {
 	log.Errorf("user: %s, method: %s, path: %s, query: %s, encountered internal error: %s", tokenSubject, httpMethod, path, query, err)
@@ -204,7 +281,10 @@ func (p *Proxy) forwardRequest(s3RequestType S3RequestType, w http.ResponseWrite
 
 	_ = s3Response.Body.Close()
 }
-func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter, r *http.Request, token jwt.Token) {
+
+// handleUpload registers and forwards uploads, and announces completed uploads. The file id, path and size of the
+// upload are recorded in the audit event.
+func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter, r *http.Request, token jwt.Token, event *audit.Event) {
 	username := token.Subject()
 
 	var err error
@@ -217,7 +297,7 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 	}
 
 	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
-	filePath, err := formatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
 	if err != nil {
 		log.Warnf("bad request from user %s: %v", token.Subject(), err)
 		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
@@ -225,13 +305,55 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 		return
 	}
 
-	fileID, err := p.database.GetFileIDInInbox(r.Context(), username, filePath)
+	event.Target = filePath
+
+	fileID, ok := p.inboxFileID(w, r, username, filePath, event)
+	if !ok {
+		return
+	}
+
+	// the file that is replaced by a reupload is not counted towards the quota
+	if (s3RequestType == PutObject || s3RequestType == CreateMultiPartUpload) && !p.withinQuota(w, r, username, fileID, requestBodySize(r), true, event) {
+		return
+	}
+
+	if s3RequestType == PutObject && !p.validCrypt4GHHeader(w, r, username, event) {
+		return
+	}
+
+	var checksums *uploadChecksums
+	var partNumbers []int
+	switch s3RequestType {
+	case PutObject:
+		checksums, err = newUploadChecksums(true, nil)
+		if err == nil {
+			hashBody(r, checksums)
+		}
+	case CompleteMultiPartUpload:
+		partNumbers, err = completedPartNumbers(r)
+	}
 	if err != nil {
-		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to check/get existing file id from database: %v", err))
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to prepare checksums: %v", err))
 
 		return
 	}
 
+	// the parts of concurrent uploads are each checked against the usage of the completed uploads only, the upload as
+	// a whole is checked when it completes
+	if s3RequestType == CompleteMultiPartUpload {
+		size, err := p.recordedPartsSize(r.Context(), r.URL.Query().Get("uploadId"), func(partNumber int) bool {
+			return slices.Contains(partNumbers, partNumber)
+		})
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get uploaded parts from database: %v", err))
+
+			return
+		}
+		if !p.withinQuota(w, r, username, fileID, size, true, event) {
+			return
+		}
+	}
+
 	// if this is an upload request
 	if fileID == "" {
 		fileID, err = p.database.RegisterFile(nil, p.s3Conf.Endpoint+"/"+p.s3Conf.Bucket, filePath, username)
@@ -242,6 +364,9 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 		}
 	}
 
+	event.FileID = fileID
+	event.CorrelationID = fileID
+
 	isReupload := false
 	// check if the file already exists when an upload completes, in that case send an overwrite message when the s3 has responded with 200,
 	// so that the FEGA portal is informed that a new version
@@ -267,12 +392,20 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 	// Send message to upstream and set file as uploaded in the database when upload is complete(PutObject / CompleteMultipartUpload)
 	// nolint: nestif
 	if s3Response.StatusCode == 200 && (s3RequestType == PutObject || s3RequestType == CompleteMultiPartUpload) {
-		message, checksum, err := p.CreateMessageFromRequest(r.Context(), token.Subject(), s3FilePath)
+		sha256Checksum, md5Checksum, err := p.uploadedChecksums(r, s3RequestType, s3FilePath, checksums, partNumbers)
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to compute checksums: %v", err))
+
+			return
+		}
+
+		message, checksum, err := p.CreateMessageFromRequest(r.Context(), token.Subject(), s3FilePath, md5Checksum, sha256Checksum)
 		if err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
 
 			return
 		}
+		event.BytesTransferred = message.Filesize
 		jsonMessage, err := json.Marshal(message)
 		if err != nil {
 			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to marshal rabbitmq message to json: %v", err))
@@ -280,39 +413,629 @@ func (p *Proxy) handleUpload(s3RequestType S3RequestType, w http.ResponseWriter,
 			return
 		}
 
-		if err = p.checkAndSendMessage(fileID, jsonMessage); err != nil {
-			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("broker error: %v", err))
+		err = inbox.Uploaded(r.Context(), p.database, p.checkAndSendMessage, inbox.Upload{
+			FileID:      fileID,
+			User:        username,
+			StoragePath: s3FilePath,
+			Size:        message.Filesize,
+			SHA256:      sha256Checksum,
+			Message:     jsonMessage,
+			Reupload:    isReupload,
+		})
+		if err != nil {
+			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
 
 			return
 		}
 
-		if err := p.storeObjectSizeInDB(r.Context(), s3FilePath, fileID); err != nil {
-			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("storeObjectSizeInDB failed because: %v", err))
+		if isReupload {
+			log.Infof("user: %s, reuploaded file: %s, with id: %s, checksum: %s", username, filePath, fileID, checksum)
+		} else {
+			log.Infof("user: %s, uploaded file: %s, with id: %s, checksum: %s", username, filePath, fileID, checksum)
+		}
+	}
+
+	if err := p.forwardResponseToClient(s3Response, w); err != nil {
+		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
+	}
+}
+
+// handleCopy copies an object within the inbox of the user. The copy is registered as a new file, with the checksum
+// of the source, and announced with an inbox-upload message. The source is left as it is, S3 clients rename objects
+// with a copy followed by a delete of the source, which is announced as a rename by handleRemove.
+func (p *Proxy) handleCopy(w http.ResponseWriter, r *http.Request, token jwt.Token, event *audit.Event) {
+	username := token.Subject()
+	event.Event = audit.EventUploadCopied
+
+	// the bucket of the copy source is the inbox of the user, just like the bucket of the destination
+	copySource, _, _ := strings.Cut(r.Header.Get("x-amz-copy-source"), "?")
+	sourcePath, err := url.PathUnescape(copySource)
+	if err == nil {
+		sourcePath, _, err = p.prepareForwardPathAndQuery(CopyObject, "/"+strings.TrimPrefix(sourcePath, "/"), "", username)
+	}
+	if err == nil {
+		r.URL.Path, r.URL.RawQuery, err = p.prepareForwardPathAndQuery(CopyObject, r.URL.Path, r.URL.RawQuery, username)
+	}
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", username, err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	s3SourcePath := strings.Replace(sourcePath, "/"+p.s3Conf.Bucket+"/", "", 1)
+	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
+	sourceFilePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3SourcePath, username))
+	if err == nil {
+		event.Target, err = helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
+	}
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", username, err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+	filePath := event.Target
+
+	if sourceFilePath == filePath {
+		denyRequest(w, http.StatusBadRequest, "InvalidRequest", "the file can not be copied to itself", username, event)
+
+		return
+	}
+
+	sourceID, ok := p.inboxFileID(w, r, username, sourceFilePath, event)
+	if !ok {
+		return
+	}
+	sourceStatus := ""
+	if sourceID != "" {
+		if sourceStatus, err = p.database.GetFileStatus(sourceID); err != nil {
+			p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get file status from database: %v", err))
 
 			return
 		}
+	}
+	if sourceStatus != "uploaded" {
+		denyRequest(w, http.StatusNotFound, "NoSuchKey", fmt.Sprintf("the file %s is not in the inbox", sourceFilePath), username, event)
 
-		if err := p.database.UpdateFileEventLog(fileID, "uploaded", "inbox", "{}", string(jsonMessage)); err != nil {
-			p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("could not connect to db: %v", err))
+		return
+	}
+
+	fileID, ok := p.inboxFileID(w, r, username, filePath, event)
+	if !ok {
+		return
+	}
+	if fileID != "" {
+		status, err := p.database.GetFileStatus(fileID)
+		if err != nil {
+			p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get file status from database: %v", err))
 
 			return
 		}
+		if status != "disabled" {
+			denyRequest(w, http.StatusConflict, "OperationAborted", fmt.Sprintf("the file %s already exists, remove it before copying a file to it", filePath), username, event)
 
-		if isReupload {
-			log.Infof("user: %s, reuploaded file: %s, with id: %s, checksum: %s", username, filePath, fileID, checksum)
-			if err := p.sendMessageOnOverwrite(username, fileID, s3FilePath); err != nil {
-				p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+			return
+		}
+	}
+
+	_, size, err := p.requestInfo(r.Context(), s3SourcePath)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get object info from the backend: %v", err))
+
+		return
+	}
+
+	if !p.withinQuota(w, r, username, "", size, true, event) {
+		return
+	}
+
+	checksum, err := p.database.GetUploadedChecksum(r.Context(), sourceID)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get checksum from database: %v", err))
+
+		return
+	}
+
+	etag, lastModified, err := p.copyObject(r.Context(), s3SourcePath, s3FilePath, size)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to copy object in the backend: %v", err))
+
+		return
+	}
+
+	// the copy is removed again unless it is registered and announced, so that the inbox holds no object that is
+	// unknown to the database
+	registeredID := ""
+	announced := false
+	defer func() {
+		if !announced {
+			p.discardCopy(r.Context(), s3FilePath, registeredID)
+		}
+	}()
+
+	// the record of a file that was removed from the destination earlier is reused, as on a reupload
+	if fileID == "" {
+		fileID, err = p.database.RegisterFile(nil, p.s3Conf.Endpoint+"/"+p.s3Conf.Bucket, filePath, username)
+		if err != nil {
+			p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to register file in database: %v", err))
+
+			return
+		}
+		registeredID = fileID
+	}
+
+	event.FileID = fileID
+	event.CorrelationID = fileID
+
+	message, md5Checksum, err := p.CreateMessageFromRequest(r.Context(), username, s3FilePath, "", checksum)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return
+	}
+	event.BytesTransferred = message.Filesize
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to marshal rabbitmq message to json: %v", err))
+
+		return
+	}
+
+	err = inbox.Uploaded(r.Context(), p.database, p.checkAndSendMessage, inbox.Upload{
+		FileID:      fileID,
+		User:        username,
+		StoragePath: s3FilePath,
+		Size:        message.Filesize,
+		SHA256:      checksum,
+		Message:     jsonMessage,
+		Details:     map[string]string{"copiedFrom": sourceID},
+	})
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return
+	}
+	announced = true
+
+	log.Infof("user: %s, copied file: %s, to: %s, with id: %s, checksum: %s", username, sourceFilePath, filePath, fileID, md5Checksum)
+
+	xmlData, err := xml.Marshal(CopyObjectResult{ETag: etag, LastModified: lastModified})
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to marshal response: %v", err))
+
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	if _, err := w.Write(xmlData); err != nil {
+		log.Error(err)
+	}
+}
+
+// discardCopy removes a copy that could not be registered or announced. The file registered for the copy, if any, is
+// disabled such that its record is reused when the copy is made again.
+func (p *Proxy) discardCopy(ctx context.Context, s3FilePath, registeredID string) {
+	ctx = context.WithoutCancel(ctx)
+	if _, err := p.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(p.s3Conf.Bucket),
+		Key:    aws.String(s3FilePath),
+	}); err != nil {
+		log.Errorf("failed to remove the failed copy %s from the backend: %v", s3FilePath, err)
+	}
+
+	if registeredID == "" {
+		return
+	}
+	if err := p.database.UpdateFileEventLog(registeredID, "disabled", "inbox", `{"reason": "copy failed"}`, "{}"); err != nil {
+		log.Errorf("failed to disable file %s of the failed copy %s: %v", registeredID, s3FilePath, err)
+	}
+}
+
+// handleRemove forwards the removal of an object from the inbox of the user. A removed file is disabled in the
+// database and announced with an inbox-remove message, unless it has been copied before, which is how S3 clients
+// rename objects. In that case the removal is announced as a rename of the file to its copy.
+func (p *Proxy) handleRemove(w http.ResponseWriter, r *http.Request, token jwt.Token, event *audit.Event) {
+	username := token.Subject()
+	event.Event = audit.EventUploadRemoved
+
+	var err error
+	r.URL.Path, r.URL.RawQuery, err = p.prepareForwardPathAndQuery(DeleteObject, r.URL.Path, r.URL.RawQuery, username)
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", username, err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	s3FilePath := strings.Replace(r.URL.Path, "/"+p.s3Conf.Bucket+"/", "", 1)
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(s3FilePath, username))
+	if err != nil {
+		log.Warnf("bad request from user %s: %v", username, err)
+		reportErrorToClient(http.StatusBadRequest, "Bad Request", w)
+
+		return
+	}
+
+	event.Target = filePath
+
+	fileID, ok := p.inboxFileID(w, r, username, filePath, event)
+	if !ok {
+		return
+	}
+
+	event.FileID = fileID
+	event.CorrelationID = fileID
+
+	s3Response, err := p.forwardRequestToBackend(r)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("forwarding error: %v", err))
+
+		return
+	}
+	defer func() {
+		_ = s3Response.Body.Close()
+	}()
+
+	// objects unknown to the database have no file to disable
+	// nolint: nestif
+	if s3Response.StatusCode >= 200 && s3Response.StatusCode <= 299 && fileID != "" {
+		copyID, copyPath, err := p.database.GetInboxFileCopy(r.Context(), fileID)
+		if err != nil {
+			p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get copies of file from database: %v", err))
+
+			return
+		}
+
+		if copyID != "" {
+			if err := p.renamed(r.Context(), username, fileID, s3FilePath, helper.UnanonymizeFilepath(copyPath, username)); err != nil {
+				p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
 
 				return
 			}
+
+			event.Event = audit.EventUploadRenamed
+			event.Target = copyPath
+			log.Infof("user: %s, renamed file: %s, to: %s, with id: %s", username, filePath, copyPath, fileID)
 		} else {
-			log.Infof("user: %s, uploaded file: %s, with id: %s, checksum: %s", username, filePath, fileID, checksum)
+			if err := p.database.UpdateFileEventLog(fileID, "disabled", "inbox", `{"reason": "removed by the user"}`, "{}"); err != nil {
+				p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("could not connect to db: %v", err))
+
+				return
+			}
+
+			if err := p.sendRemoveMessage(username, fileID, s3FilePath); err != nil {
+				p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+				return
+			}
+
+			log.Infof("user: %s, removed file: %s, with id: %s", username, filePath, fileID)
 		}
 	}
 
 	if err := p.forwardResponseToClient(s3Response, w); err != nil {
-		p.internalServerError(w, token.Subject(), r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to forward response to client: %v", err))
+	}
+}
+
+// renamed disables the file that was removed after it was copied to s3CopyPath, and announces the removal with an
+// inbox-rename message
+func (p *Proxy) renamed(ctx context.Context, username, fileID, s3FilePath, s3CopyPath string) error {
+	jsonMessage, err := json.Marshal(schema.InboxRename{
+		User:      username,
+		FilePath:  s3CopyPath,
+		OldPath:   s3FilePath,
+		Operation: "rename",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal rabbitmq message to json: %v", err)
+	}
+
+	if err := p.database.UpdateFileEventLog(fileID, "disabled", "inbox", `{"reason": "renamed by the user"}`, string(jsonMessage)); err != nil {
+		return fmt.Errorf("could not connect to db: %v", err)
+	}
+
+	if err := p.checkAndSendMessage(fileID, jsonMessage); err != nil {
+		return fmt.Errorf("broker error: %v", err)
+	}
+
+	return nil
+}
+
+// inboxFileID returns the id of the inbox file of the user at filePath, or an empty string for objects that are not
+// known to the database. Files that are being ingested can not be changed, such requests are answered with
+// OperationAborted and recorded as denied in the audit event.
+func (p *Proxy) inboxFileID(w http.ResponseWriter, r *http.Request, username, filePath string, event *audit.Event) (string, bool) {
+	fileID, err := inbox.FileID(r.Context(), p.database, username, filePath)
+	switch {
+	case errors.Is(err, inbox.ErrBeingIngested):
+		denyRequest(w, http.StatusConflict, "OperationAborted", fmt.Sprintf("the file %s is being ingested and can not be changed", filePath), username, event)
+
+		return "", false
+	case err != nil:
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return "", false
+	}
+
+	return fileID, true
+}
+
+// maxCopyObjectSize is the largest object that S3 copies with a single request
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// copyPartSize is the part size used to copy larger objects, which allows objects of up to 10 TiB
+const copyPartSize = 1024 * 1024 * 1024
+
+// copyObject copies an object of the given size to a new path in the inbox bucket. The ETag and modification time of
+// the copy are returned.
+func (p *Proxy) copyObject(ctx context.Context, source, destination string, size int64) (string, time.Time, error) {
+	copySource := (&url.URL{Path: p.s3Conf.Bucket + "/" + source}).EscapedPath()
+	if size > maxCopyObjectSize {
+		etag, err := p.copyObjectParts(ctx, copySource, destination, size)
+
+		return etag, time.Now().UTC(), err
+	}
+
+	result, err := p.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(p.s3Conf.Bucket),
+		Key:        aws.String(destination),
+		CopySource: aws.String(copySource),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if result.CopyObjectResult == nil {
+		return "", time.Now().UTC(), nil
+	}
+
+	return aws.ToString(result.CopyObjectResult.ETag), aws.ToTime(result.CopyObjectResult.LastModified), nil
+}
+
+// copyObjectParts copies an object that is too large for a single copy request with a multipart upload
+func (p *Proxy) copyObjectParts(ctx context.Context, copySource, destination string, size int64) (string, error) {
+	upload, err := p.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(p.s3Conf.Bucket),
+		Key:    aws.String(destination),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	abort := func() {
+		if _, err := p.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(p.s3Conf.Bucket),
+			Key:      aws.String(destination),
+			UploadId: upload.UploadId,
+		}); err != nil {
+			log.Errorf("failed to abort multipart copy to %s: %v", destination, err)
+		}
+	}
+
+	var parts []types.CompletedPart
+	for start, partNumber := int64(0), int32(1); start < size; start, partNumber = start+copyPartSize, partNumber+1 {
+		part, err := p.s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(p.s3Conf.Bucket),
+			Key:             aws.String(destination),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, min(start+copyPartSize, size)-1)),
+			PartNumber:      aws.Int32(partNumber),
+			UploadId:        upload.UploadId,
+		})
+		if err != nil {
+			abort()
+
+			return "", err
+		}
+		if part.CopyPartResult == nil {
+			abort()
+
+			return "", errors.New("unexpected response from s3, UploadPartCopy response contains nil information")
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(partNumber)})
+	}
+
+	result, err := p.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(p.s3Conf.Bucket),
+		Key:             aws.String(destination),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+
+		return "", err
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+// handleUploadPart forwards a part of a multipart upload and records its checksums, which are combined into the
+// checksums of the object when the upload completes. Parts that are not allowed are recorded as denied in the audit
+// event.
+func (p *Proxy) handleUploadPart(w *statusRecorder, r *http.Request, token jwt.Token, event *audit.Event) {
+	username := token.Subject()
+	uploadID := r.URL.Query().Get("uploadId")
+	partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+
+	// the parts already uploaded count towards the quota, except a part that is uploaded again
+	uploaded, err := p.recordedPartsSize(r.Context(), uploadID, func(number int) bool { return number != partNumber })
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to get uploaded parts from database: %v", err))
+
+		return
+	}
+
+	// the crypt4gh header is at the start of the first part
+	if !p.withinQuota(w, r, username, "", uploaded+requestBodySize(r), false, event) || (partNumber == 1 && !p.validCrypt4GHHeader(w, r, username, event)) {
+		return
+	}
+
+	checksums, err := p.partChecksums(r.Context(), uploadID, partNumber)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to prepare checksums: %v", err))
+
+		return
+	}
+	hashBody(r, checksums)
+
+	p.forwardRequest(UploadPart, w, r, token)
+
+	// parts that were not forwarded in full are not recorded, which leaves the checksums of the object unknown
+	if w.status != http.StatusOK || checksums.size != requestBodySize(r) {
+		return
 	}
+
+	state, err := checksums.sha256State()
+	if err == nil {
+		err = p.database.AddInboxUploadPart(r.Context(), uploadID, database.InboxUploadPart{
+			Number:      partNumber,
+			Size:        checksums.size,
+			MD5:         hex.EncodeToString(checksums.md5.Sum(nil)),
+			SHA256State: state,
+		})
+	}
+	if err != nil {
+		log.Errorf("user: %s, failed to record the checksums of part %d of upload %s: %v", username, partNumber, uploadID, err)
+	}
+}
+
+// uploadedChecksums returns the SHA256 and the S3 style MD5 of an uploaded object, computed from the request body of a
+// single part upload or combined from the recorded parts of a multipart upload, where a checksum that is not known is
+// returned as an empty string. The checksums are compared to the size and ETag reported by the backend, and the
+// recorded parts are removed.
+func (p *Proxy) uploadedChecksums(r *http.Request, s3RequestType S3RequestType, s3FilePath string, checksums *uploadChecksums, partNumbers []int) (string, string, error) {
+	var sha256Checksum, md5Checksum string
+	var size int64
+	switch s3RequestType {
+	case PutObject:
+		sha256Checksum, md5Checksum, size = checksums.sha256Checksum(), hex.EncodeToString(checksums.md5.Sum(nil)), checksums.size
+	case CompleteMultiPartUpload:
+		uploadID := r.URL.Query().Get("uploadId")
+		var err error
+		sha256Checksum, md5Checksum, size, err = p.multipartChecksums(r.Context(), uploadID, partNumbers)
+		if err != nil {
+			return "", "", err
+		}
+		if err := p.database.DeleteInboxUploadParts(r.Context(), uploadID); err != nil {
+			return "", "", err
+		}
+	}
+	if sha256Checksum == "" && md5Checksum == "" {
+		return "", "", nil
+	}
+
+	etag, objectSize, err := p.requestInfo(r.Context(), s3FilePath)
+	if err != nil {
+		return "", "", err
+	}
+	if size != objectSize {
+		log.Warnf("checksums computed from %d bytes, but the object %s has %d bytes, the checksums are not used", size, s3FilePath, objectSize)
+
+		return "", "", nil
+	}
+	if md5Checksum != etag {
+		log.Debugf("md5 %s of the object %s differs from the ETag %s reported by the backend", md5Checksum, s3FilePath, etag)
+	}
+
+	return sha256Checksum, md5Checksum, nil
+}
+
+// recordedPartsSize returns the size of the recorded parts of a multipart upload for which include returns true
+func (p *Proxy) recordedPartsSize(ctx context.Context, uploadID string, include func(partNumber int) bool) (int64, error) {
+	parts, err := p.database.GetInboxUploadParts(ctx, uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, part := range parts {
+		if include(part.Number) {
+			size += part.Size
+		}
+	}
+
+	return size, nil
+}
+
+// withinQuota checks that storing size more bytes, and a new object if newObject is set, keeps the user and the groups
+// of the user within their inbox quotas. The file replacedFileID is not counted towards the usage. Requests that would
+// exceed a quota are answered with QuotaExceeded and recorded as denied in the audit event.
+func (p *Proxy) withinQuota(w http.ResponseWriter, r *http.Request, username, replacedFileID string, size int64, newObject bool, event *audit.Event) bool {
+	reason, err := inbox.QuotaExceeded(r.Context(), p.database, username, replacedFileID, size, newObject)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, err.Error())
+
+		return false
+	}
+	if reason == "" {
+		return true
+	}
+
+	denyRequest(w, http.StatusForbidden, "QuotaExceeded", reason, username, event)
+
+	return false
+}
+
+// validCrypt4GHHeader checks, when enabled, that the request body starts with a crypt4gh header that can be decrypted
+// with one of the archive keys. The body is left intact for forwarding. Requests that fail the check are answered with
+// InvalidCrypt4GHHeader and recorded as denied in the audit event.
+func (p *Proxy) validCrypt4GHHeader(w http.ResponseWriter, r *http.Request, username string, event *audit.Event) bool {
+	if !p.s3Conf.ValidateHeader {
+		return true
+	}
+
+	prefix, err := peekBody(r, inbox.Crypt4GHHeaderPeekSize)
+	if err != nil {
+		p.internalServerError(w, username, r.Method, r.URL.Path, r.URL.RawQuery, fmt.Sprintf("failed to read request body: %v", err))
+
+		return false
+	}
+
+	// Clients that sign the payload in chunks send the body in aws-chunked encoding, where the data is preceded by the
+	// size and signature of each chunk
+	if isAWSChunked(r) {
+		prefix = decodeAWSChunked(prefix)
+	}
+
+	reason := inbox.InvalidCrypt4GHHeader(prefix, p.s3Conf.C4ghPrivateKeyList)
+	if reason == "" {
+		return true
+	}
+
+	denyRequest(w, http.StatusBadRequest, "InvalidCrypt4GHHeader", reason, username, event)
+
+	return false
+}
+
+// peekBody returns up to n bytes from the start of the request body and leaves the body unread
+func peekBody(r *http.Request, n int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	prefix := make([]byte, n)
+	read, err := io.ReadFull(r.Body, prefix)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	prefix = prefix[:read]
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+
+	return prefix, nil
+}
+
+// requestBodySize returns the size of the object data in the request, which differs from the content length when the
+// payload is sent in aws-chunked encoding
+func requestBodySize(r *http.Request) int64 {
+	if decoded, err := strconv.ParseInt(r.Header.Get("x-amz-decoded-content-length"), 10, 64); err == nil {
+		return decoded
+	}
+
+	return max(r.ContentLength, 0)
 }
 
 // Renew the connection to MQ if necessary, then send message
@@ -426,7 +1149,18 @@ func (p *Proxy) resignHeader(r *http.Request) *http.Request {
 // * PutObject == PUT /${bucket}/${object}
 // For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObject.html
 // partNumber and uploadId query arguments not present
-// We ensure x-amz-copy-source is not present to not allow CopyObject
+// We ensure x-amz-copy-source is not present, which makes it a CopyObject
+//
+// * CopyObject == PUT /${bucket}/${object} with the x-amz-copy-source header
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+// partNumber and uploadId query arguments not present, copies are handled as renames within the inbox of the user
+//
+// * HeadObject == HEAD /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_HeadObject.html
+//
+// * DeleteObject == DELETE /${bucket}/${object}
+// For aws docs see: https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObject.html
+// uploadId query argument not present
 //
 // * UploadPart == PUT /${bucket}/${object}
 // For aws docs see:  https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPart.html
@@ -475,22 +1209,30 @@ func detectS3RequestType(r *http.Request) S3RequestType {
 		return ListObjects
 	case r.Method == http.MethodPut && isObjectPath && !query.Has("partNumber") && !query.Has("uploadId") && r.Header.Get("x-amz-copy-source") == "":
 		return PutObject
+	case r.Method == http.MethodPut && isObjectPath && !query.Has("partNumber") && !query.Has("uploadId") && r.Header.Get("x-amz-copy-source") != "":
+		return CopyObject
 	case r.Method == http.MethodPut && isObjectPath && query.Has("partNumber") && query.Has("uploadId") && r.Header.Get("x-amz-copy-source") == "":
 		return UploadPart
+	case r.Method == http.MethodHead && isObjectPath:
+		return HeadObject
 	case r.Method == http.MethodPost && isObjectPath && query.Has("uploads"):
 		return CreateMultiPartUpload
 	case r.Method == http.MethodPost && isObjectPath && query.Has("uploadId"):
 		return CompleteMultiPartUpload
 	case r.Method == http.MethodDelete && isObjectPath && query.Has("uploadId"):
 		return AbortMultiPartUpload
+	case r.Method == http.MethodDelete && isObjectPath:
+		return DeleteObject
 	default:
 		return Unsupported
 	}
 }
 
 // CreateMessageFromRequest is a function that can take a http request and
-// figure out the correct rabbitmq message to send from it.
-func (p *Proxy) CreateMessageFromRequest(ctx context.Context, username, s3FilePath string) (Event, string, error) {
+// figure out the correct rabbitmq message to send from it. The md5 and sha256
+// checksums computed during the upload are added to the message when known,
+// the ETag reported by the backend is used as the md5 otherwise.
+func (p *Proxy) CreateMessageFromRequest(ctx context.Context, username, s3FilePath, md5Checksum, sha256Checksum string) (Event, string, error) {
 	event := Event{}
 	checksum := Checksum{}
 	var err error
@@ -499,6 +1241,9 @@ func (p *Proxy) CreateMessageFromRequest(ctx context.Context, username, s3FilePa
 	if err != nil {
 		return event, "", fmt.Errorf("could not get checksum information: %s", err)
 	}
+	if md5Checksum != "" {
+		checksum.Value = md5Checksum
+	}
 
 	// Case for simple upload
 	event.Operation = "upload"
@@ -507,6 +1252,9 @@ func (p *Proxy) CreateMessageFromRequest(ctx context.Context, username, s3FilePa
 	event.Username = username
 	checksum.Type = "md5"
 	event.Checksum = []any{checksum}
+	if sha256Checksum != "" {
+		event.Checksum = append(event.Checksum, Checksum{Type: "sha256", Value: sha256Checksum})
+	}
 
 	return event, checksum.Value, nil
 }
@@ -546,47 +1294,14 @@ func (p *Proxy) checkFileExists(ctx context.Context, s3FilePath string) (bool, e
 	return result != nil, err
 }
 
-func (p *Proxy) sendMessageOnOverwrite(username, fileID, s3FilePath string) error {
-	msg := schema.InboxRemove{
-		User:      username,
-		FilePath:  s3FilePath,
-		Operation: "remove",
-	}
-
-	jsonMessage, err := json.Marshal(msg)
+// sendRemoveMessage announces that a file has been removed from the inbox
+func (p *Proxy) sendRemoveMessage(username, fileID, s3FilePath string) error {
+	jsonMessage, err := inbox.RemoveMessage(username, s3FilePath)
 	if err != nil {
 		return err
 	}
 
-	err = p.checkAndSendMessage(fileID, jsonMessage)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// FormatUploadFilePath ensures that path separators are "/", and returns error if the
-// filepath contains a disallowed character matched with regex
-func formatUploadFilePath(filePath string) (string, error) {
-	// Check for mixed "\" and "/" in filepath. Stop and throw an error if true so that
-	// we do not end up with unintended folder structure when applying ReplaceAll below
-	if strings.Contains(filePath, "\\") && strings.Contains(filePath, "/") {
-		return filePath, errors.New("filepath contains mixed '\\' and '/' characters")
-	}
-
-	// make any windows path separators linux compatible
-	outPath := strings.ReplaceAll(filePath, "\\", "/")
-
-	// [\x00-\x1F\x7F] is the control character set
-	re := regexp.MustCompile(`[\\<>"\|\x00-\x1F\x7F\!\*\'\(\)\;\:\@\&\=\+\$\,\?\%\#\[\]]`)
-
-	disallowedChars := re.FindAllString(outPath, -1)
-	if disallowedChars != nil {
-		return outPath, fmt.Errorf("filepath contains disallowed characters: %+v", strings.Join(disallowedChars, ", "))
-	}
-
-	return outPath, nil
+	return p.checkAndSendMessage(fileID, jsonMessage)
 }
 
 // Write the error and its status code to the response
@@ -611,18 +1326,25 @@ func reportErrorToClient(errorCode int, message string, w http.ResponseWriter) {
 	}
 }
 
-func (p *Proxy) storeObjectSizeInDB(ctx context.Context, s3FilePath, fileID string) error {
-	o, err := p.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(p.s3Conf.Bucket),
-		Key:    aws.String(s3FilePath),
-	})
+// reportS3Error writes an S3 error with a code such as QuotaExceeded and a message for the user to the response
+func reportS3Error(status int, code, message string, w http.ResponseWriter) {
+	w.WriteHeader(status)
+	xmlData, err := xml.Marshal(ErrorResponse{Code: code, Message: message})
 	if err != nil {
-		return err
-	}
+		log.Error(err)
 
-	if o.ContentLength == nil {
-		return errors.New("s3 content length not available")
+		return
 	}
+	if _, err := w.Write(xmlData); err != nil {
+		log.Error(err)
+	}
+}
 
-	return p.database.SetSubmissionFileSize(fileID, *o.ContentLength)
+// denyRequest answers a request that is not allowed with an S3 error explaining why, and records it as denied in the
+// audit event
+func denyRequest(w http.ResponseWriter, status int, code, reason, username string, event *audit.Event) {
+	log.Infof("user: %s, request rejected: %s", username, reason)
+	reportS3Error(status, code, reason, w)
+	event.Event = audit.EventUploadDenied
+	event.ErrorReason = reason
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"database/sql"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -23,10 +26,14 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inbox"
 	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -301,11 +308,12 @@ func (s *ProxyTests) TestServeHTTP_disallowed() {
 	assert.Equal(s.T(), 403, w.Result().StatusCode)
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 
-	// Deletion of files are disallowed
+	// Deletion of files of other users are disallowed
+	w = httptest.NewRecorder()
 	r.Method = "DELETE"
 	r.URL, _ = url.Parse("/asdf/asdf")
 	proxy.ServeHTTP(w, r)
-	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 
 	log.Warnf("getting to not allowed stuff")
@@ -343,6 +351,143 @@ func (s *ProxyTests) TestServeHTTP_disallowed() {
 	assert.Equal(s.T(), false, s.fakeServer.PingedAndRestore())
 }
 
+// capturingLogger records audit events for test assertions.
+type capturingLogger struct {
+	events []audit.Event
+}
+
+func (l *capturingLogger) Log(_ context.Context, event audit.Event) {
+	l.events = append(l.events, event)
+}
+
+// nolint:bodyclose
+func (s *ProxyTests) TestServeHTTP_auditDenied() {
+	logger := &capturingLogger{}
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysAllow{}, s.messenger, s.database, new(tls.Config))
+	proxy.auditLogger = logger
+
+	// Disallowed requests are audited with the authenticated user
+	r, _ := http.NewRequest("PUT", "/asdf?policy=rw", http.NoBody)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Len(s.T(), logger.events, 1)
+	assert.Equal(s.T(), audit.EventUploadDenied, logger.events[0].Event)
+	assert.Equal(s.T(), "dummy", logger.events[0].UserID)
+	assert.Equal(s.T(), 403, logger.events[0].HTTPStatus)
+
+	// Unauthenticated requests are audited without a user
+	proxy = NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, new(tls.Config))
+	proxy.auditLogger = logger
+	r, _ = http.NewRequest("GET", "/username/file", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 401, w.Result().StatusCode)
+	assert.Len(s.T(), logger.events, 2)
+	assert.Equal(s.T(), audit.EventUploadDenied, logger.events[1].Event)
+	assert.Empty(s.T(), logger.events[1].UserID)
+	assert.Equal(s.T(), "/username/file", logger.events[1].Path)
+	assert.Equal(s.T(), 401, logger.events[1].HTTPStatus)
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+}
+
+func (s *ProxyTests) TestServeHTTP_quotaExceeded() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	logger := &capturingLogger{}
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), s.messenger, db, new(tls.Config))
+	proxy.auditLogger = logger
+
+	usage, err := db.GetInboxUsage(context.TODO(), "user", "dummy", "")
+	assert.NoError(s.T(), err)
+	maxBytes, maxObjects := usage.Bytes+10, usage.Objects
+	assert.NoError(s.T(), db.SetInboxQuota(context.TODO(), database.InboxQuota{SubjectType: "user", Subject: "dummy", MaxBytes: &maxBytes}))
+	defer func() { _ = db.DeleteInboxQuota(context.TODO(), "user", "dummy") }()
+
+	// Uploads that would exceed the byte quota never reach the backend
+	r, _ := http.NewRequest("PUT", "/dummy/quota-file", strings.NewReader("more than ten bytes"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	var errorResponse ErrorResponse
+	assert.NoError(s.T(), xml.Unmarshal(w.Body.Bytes(), &errorResponse))
+	assert.Equal(s.T(), "QuotaExceeded", errorResponse.Code)
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+	assert.Len(s.T(), logger.events, 1)
+	assert.Equal(s.T(), audit.EventUploadDenied, logger.events[0].Event)
+	assert.Contains(s.T(), logger.events[0].ErrorReason, "bytes for user dummy")
+
+	r, _ = http.NewRequest("PUT", "/dummy/quota-file?partNumber=1&uploadId=1", strings.NewReader("more than ten bytes"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+
+	// A full object quota only stops new objects
+	assert.NoError(s.T(), db.SetInboxQuota(context.TODO(), database.InboxQuota{SubjectType: "user", Subject: "dummy", MaxObjects: &maxObjects}))
+	r, _ = http.NewRequest("POST", "/dummy/quota-file?uploads", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "objects for user dummy")
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+
+	r, _ = http.NewRequest("PUT", "/dummy/quota-file?partNumber=1&uploadId=1", strings.NewReader("more than ten bytes"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.True(s.T(), s.fakeServer.PingedAndRestore())
+}
+
+func (s *ProxyTests) TestServeHTTP_quotaExceededInAggregate() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, helper.NewAlwaysAllow(), s.messenger, db, new(tls.Config))
+
+	usage, err := db.GetInboxUsage(context.TODO(), "user", "dummy", "")
+	assert.NoError(s.T(), err)
+	maxBytes := usage.Bytes + 10
+	assert.NoError(s.T(), db.SetInboxQuota(context.TODO(), database.InboxQuota{SubjectType: "user", Subject: "dummy", MaxBytes: &maxBytes}))
+	defer func() { _ = db.DeleteInboxQuota(context.TODO(), "user", "dummy") }()
+
+	// Each part fits the quota, but not the parts of the upload together
+	r, _ := http.NewRequest("PUT", "/dummy/aggregate-file?partNumber=1&uploadId=aggregate", strings.NewReader("six by"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.True(s.T(), s.fakeServer.PingedAndRestore())
+
+	r, _ = http.NewRequest("PUT", "/dummy/aggregate-file?partNumber=2&uploadId=aggregate", strings.NewReader("six by"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "QuotaExceeded")
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+
+	// A part that is uploaded again replaces the recorded part
+	r, _ = http.NewRequest("PUT", "/dummy/aggregate-file?partNumber=1&uploadId=aggregate", strings.NewReader("six by"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.True(s.T(), s.fakeServer.PingedAndRestore())
+	assert.NoError(s.T(), db.DeleteInboxUploadParts(context.TODO(), "aggregate"))
+
+	// Parts uploaded concurrently are checked together when the upload completes
+	for _, number := range []int{1, 2} {
+		assert.NoError(s.T(), db.AddInboxUploadPart(context.TODO(), "concurrent", database.InboxUploadPart{Number: number, Size: 6, MD5: "md5"}))
+	}
+	defer func() { _ = db.DeleteInboxUploadParts(context.TODO(), "concurrent") }()
+	complete := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>a</ETag></Part><Part><PartNumber>2</PartNumber><ETag>b</ETag></Part></CompleteMultipartUpload>"
+	r, _ = http.NewRequest("POST", "/dummy/aggregate-file?uploadId=concurrent", strings.NewReader(complete))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 403, w.Result().StatusCode)
+	assert.Contains(s.T(), w.Body.String(), "bytes for user dummy")
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+}
+
 func (s *ProxyTests) TestServeHTTPS3Unresponsive() {
 	s3conf := config.S3InboxConf{
 		Endpoint:  "http://localhost:40211",
@@ -545,7 +690,7 @@ func (s *ProxyTests) TestMessageFormatting() {
 	proxy := NewProxy(s.s3Fakeconf, s.s3ClientToFake, &helper.AlwaysDeny{}, s.messenger, s.database, new(tls.Config))
 	s.fakeServer.resp = "<ListBucketResult xmlns=\"http://s3.amazonaws.com/doc/2006-03-01/\"><Name>test</Name><Prefix>/user/new_file.txt</Prefix><KeyCount>1</KeyCount><MaxKeys>2</MaxKeys><Delimiter></Delimiter><IsTruncated>false</IsTruncated><Contents><Key>/user/new_file.txt</Key><LastModified>2020-03-10T13:20:15.000Z</LastModified><ETag>&#34;0a44282bd39178db9680f24813c41aec-1&#34;</ETag><Size>1234</Size><Owner><ID></ID><DisplayName></DisplayName></Owner><StorageClass>STANDARD</StorageClass></Contents></ListBucketResult>"
 	s.fakeServer.headHeaders = map[string]string{"ETag": "\"0a44282bd39178db9680f24813c41aec-1\"", "Content-Length": "1234"}
-	msg, checksumValue, err := proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt")
+	msg, checksumValue, err := proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt", "", "")
 	assert.Nil(s.T(), err)
 	assert.IsType(s.T(), Event{}, msg)
 
@@ -562,11 +707,20 @@ func (s *ProxyTests) TestMessageFormatting() {
 
 	// Test single shot upload
 	r.Method = "PUT"
-	msg, _, err = proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt")
+	msg, _, err = proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt", "", "")
 	assert.Nil(s.T(), err)
 	assert.IsType(s.T(), Event{}, msg)
 	assert.Equal(s.T(), "upload", msg.Operation)
 	assert.Equal(s.T(), "new_file.txt", msg.Filepath)
+
+	// The checksums computed during the upload are used instead of the ETag
+	msg, checksumValue, err = proxy.CreateMessageFromRequest(r.Context(), claims.Subject(), "new_file.txt", "9e107d9d372bb6826bd81d3542a419d6-2", "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "9e107d9d372bb6826bd81d3542a419d6-2", checksumValue)
+	assert.Equal(s.T(), []any{
+		Checksum{Type: "md5", Value: "9e107d9d372bb6826bd81d3542a419d6-2"},
+		Checksum{Type: "sha256", Value: "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592"},
+	}, msg.Checksum)
 }
 
 func (s *ProxyTests) TestDatabaseConnection() {
@@ -616,22 +770,81 @@ func (s *ProxyTests) TestDatabaseConnection() {
 	}
 }
 
-func (s *ProxyTests) TestFormatUploadFilePath() {
-	unixPath := "a/b/c.c4gh"
-	testPath := "a\\b\\c.c4gh"
-	uploadPath, err := formatUploadFilePath(testPath)
+// encryptForTest encrypts data with crypt4gh for the given public key
+func encryptForTest(t *testing.T, data []byte, publicKey [32]byte) []byte {
+	_, privateKey, err := keys.GenerateKeyPair()
+	assert.NoError(t, err)
+
+	var encrypted bytes.Buffer
+	writer, err := streaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{publicKey}, nil)
+	assert.NoError(t, err)
+	_, err = writer.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	return encrypted.Bytes()
+}
+
+func (s *ProxyTests) TestPeekBody() {
+	r, _ := http.NewRequest("PUT", "/dummy/file", strings.NewReader("0123456789"))
+	prefix, err := peekBody(r, 4)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "0123", string(prefix))
+	body, err := io.ReadAll(r.Body)
 	assert.NoError(s.T(), err)
-	assert.Equal(s.T(), unixPath, uploadPath)
+	assert.Equal(s.T(), "0123456789", string(body))
 
-	// mixed "\" and "/"
-	weirdPath := `dq\sw:*?"<>|\t\s/df.c4gh`
-	_, err = formatUploadFilePath(weirdPath)
-	assert.EqualError(s.T(), err, "filepath contains mixed '\\' and '/' characters")
+	r, _ = http.NewRequest("PUT", "/dummy/file", strings.NewReader("01"))
+	prefix, err = peekBody(r, 4)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "01", string(prefix))
+}
+
+func (s *ProxyTests) TestServeHTTP_invalidCrypt4GHHeader() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
 
-	// no mixed "\" and "/" but not allowed
-	weirdPath = `dq\sw:*?"<>|\t\sdf!s'(a);w@4&f=+e$,g#[]d%.c4gh`
-	_, err = formatUploadFilePath(weirdPath)
-	assert.EqualError(s.T(), err, "filepath contains disallowed characters: :, *, ?, \", <, >, |, !, ', (, ), ;, @, &, =, +, $, ,, #, [, ], %")
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	assert.NoError(s.T(), err)
+	s3conf := s.s3Fakeconf
+	s3conf.ValidateHeader = true
+	s3conf.C4ghPrivateKeyList = []*[32]byte{&archivePrivateKey}
+	logger := &capturingLogger{}
+	proxy := NewProxy(s3conf, s.s3ClientToFake, helper.NewAlwaysAllow(), s.messenger, db, new(tls.Config))
+	proxy.auditLogger = logger
+
+	// Unencrypted files never reach the backend
+	r, _ := http.NewRequest("PUT", "/dummy/plain-file", strings.NewReader("some genomic data"))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
+	var errorResponse ErrorResponse
+	assert.NoError(s.T(), xml.Unmarshal(w.Body.Bytes(), &errorResponse))
+	assert.Equal(s.T(), "InvalidCrypt4GHHeader", errorResponse.Code)
+	assert.Contains(s.T(), errorResponse.Message, "not encrypted with crypt4gh")
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+	assert.Len(s.T(), logger.events, 1)
+	assert.Equal(s.T(), audit.EventUploadDenied, logger.events[0].Event)
+
+	// Only the first part of a multipart upload holds the header
+	r, _ = http.NewRequest("PUT", "/dummy/plain-file?partNumber=1&uploadId=1", strings.NewReader("some genomic data"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
+	assert.False(s.T(), s.fakeServer.PingedAndRestore())
+
+	r, _ = http.NewRequest("PUT", "/dummy/plain-file?partNumber=2&uploadId=1", strings.NewReader("some genomic data"))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.True(s.T(), s.fakeServer.PingedAndRestore())
+
+	r, _ = http.NewRequest("PUT", "/dummy/encrypted-file?partNumber=1&uploadId=1", bytes.NewReader(encryptForTest(s.T(), []byte("some genomic data"), archivePublicKey)))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	assert.True(s.T(), s.fakeServer.PingedAndRestore())
 }
 
 func (s *ProxyTests) TestCheckFileExists() {
@@ -693,7 +906,7 @@ func (s *ProxyTests) TestCheckFileExists_unresponsive() {
 	assert.Contains(s.T(), err.Error(), "StatusCode: 403")
 }
 
-func (s *ProxyTests) TestStoreObjectSizeInDB_dbFailure() {
+func (s *ProxyTests) TestUploaded_dbReconnect() {
 	db, err := database.NewSDAdb(s.DBConf)
 	assert.NoError(s.T(), err)
 
@@ -709,53 +922,38 @@ func (s *ProxyTests) TestStoreObjectSizeInDB_dbFailure() {
 	assert.NotNil(s.T(), fileID)
 
 	db.Close()
-	assert.NoError(s.T(), proxy.storeObjectSizeInDB(context.TODO(), "/dummy/file", fileID))
+	assert.NoError(s.T(), inbox.Uploaded(context.TODO(), db, proxy.checkAndSendMessage, inbox.Upload{
+		FileID:      fileID,
+		User:        "test-user",
+		StoragePath: "/dummy/file",
+		Size:        10,
+		Message:     []byte("{}"),
+	}))
 }
 
-func (s *ProxyTests) TestStoreObjectSizeInDB_s3Failure() {
-	db, err := database.NewSDAdb(s.DBConf)
-	assert.NoError(s.T(), err)
-	defer db.Close()
-	mq, err := broker.NewMQ(s.MQConf)
-	assert.NoError(s.T(), err)
-	defer mq.Connection.Close()
-
+func (s *ProxyTests) TestRequestInfo_s3Failure() {
 	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
-	proxy.database = db
-	fileID, err := db.RegisterFile(nil, "/inbox", "/dummy/file", "test-user")
-	assert.NoError(s.T(), err)
-	assert.NotNil(s.T(), fileID)
 
 	// Detect autentication failure
+	var err error
 	proxy.s3Conf.AccessKey = "badKey"
 	proxy.s3Client, err = newS3Client(context.TODO(), proxy.s3Conf)
 	assert.NoError(s.T(), err)
-	assert.Error(s.T(), proxy.storeObjectSizeInDB(context.TODO(), "/dummy/file", fileID))
+	_, _, err = proxy.requestInfo(context.TODO(), "/dummy/file")
+	assert.Error(s.T(), err)
 
 	// Detect unresponsive backend service
 	proxy.s3Conf.Endpoint = "http://127.0.0.1:1234"
 	proxy.s3Client, err = newS3Client(context.TODO(), proxy.s3Conf)
 	assert.NoError(s.T(), err)
-	assert.Error(s.T(), proxy.storeObjectSizeInDB(context.TODO(), "/dummy/file", fileID))
+	_, _, err = proxy.requestInfo(context.TODO(), "/dummy/file")
+	assert.Error(s.T(), err)
 }
 
 // This test is intended to try to catch some issues we sometimes see when a query to the S3 backend
 // happens to fast so that it is not ready and returns a false 404.
-func (s *ProxyTests) TestStoreObjectSizeInDB_fastCheck() {
-	db, err := database.NewSDAdb(s.DBConf)
-	assert.NoError(s.T(), err)
-	defer db.Close()
-
-	mq, err := broker.NewMQ(s.MQConf)
-	assert.NoError(s.T(), err)
-	defer mq.Connection.Close()
-
+func (s *ProxyTests) TestRequestInfo_fastCheck() {
 	p := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
-	p.database = db
-
-	fileID, err := db.RegisterFile(nil, "/inbox", "/test/new_file", "test-user")
-	assert.NoError(s.T(), err)
-	assert.NotNil(s.T(), fileID)
 
 	s3cfg, err := s3config.LoadDefaultConfig(context.TODO(), s3config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.s3Conf.AccessKey, s.s3Conf.SecretKey, "")))
 	if err != nil {
@@ -780,11 +978,221 @@ func (s *ProxyTests) TestStoreObjectSizeInDB_fastCheck() {
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), output, output)
 
-	assert.NoError(s.T(), p.storeObjectSizeInDB(context.TODO(), "/test/new_file", fileID))
-
-	const getObjectSize = "SELECT submission_file_size FROM sda.files WHERE id = $1;"
-	var objectSize int64
 	// If the S3 backend haven't had the time to process the request above correctly this will generate an error.
-	assert.NoError(s.T(), p.database.DB.QueryRow(getObjectSize, fileID).Scan(&objectSize))
+	_, objectSize, err := p.requestInfo(context.TODO(), "/test/new_file")
+	assert.NoError(s.T(), err)
 	assert.Equal(s.T(), int64(10*1024*1024), objectSize)
 }
+
+func (s *ProxyTests) TestDetectS3RequestType() {
+	for _, test := range []struct {
+		method, path, copySource string
+		expected                 S3RequestType
+	}{
+		{"HEAD", "/dummy/file", "", HeadObject},
+		{"HEAD", "/dummy", "", Unsupported},
+		{"PUT", "/dummy/file", "/dummy/other", CopyObject},
+		{"PUT", "/dummy/file?partNumber=1&uploadId=1", "/dummy/other", Unsupported},
+		{"DELETE", "/dummy/file", "", DeleteObject},
+		{"DELETE", "/dummy/file?uploadId=1", "", AbortMultiPartUpload},
+		{"DELETE", "/dummy", "", Unsupported},
+	} {
+		r, _ := http.NewRequest(test.method, test.path, http.NoBody)
+		if test.copySource != "" {
+			r.Header.Set("x-amz-copy-source", test.copySource)
+		}
+		assert.Equal(s.T(), test.expected, detectS3RequestType(r), "%s %s", test.method, test.path)
+	}
+}
+
+// putInboxFile stores an object in the inbox of the dummy user and registers it as uploaded
+func (s *ProxyTests) putInboxFile(db *database.SDAdb, name string) string {
+	_, err := s.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Body:   strings.NewReader("This is a test"),
+		Bucket: aws.String(s.s3Conf.Bucket),
+		Key:    aws.String("dummy/" + name),
+	})
+	assert.NoError(s.T(), err)
+
+	fileID, err := db.RegisterFile(nil, s.s3Conf.Endpoint+"/"+s.s3Conf.Bucket, name, "dummy")
+	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), db.UpdateFileEventLog(fileID, "uploaded", "inbox", "{}", "{}"))
+
+	return fileID
+}
+
+// nolint:bodyclose
+func (s *ProxyTests) TestServeHTTP_copyRenameAndRemove() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	messenger, err := broker.NewMQ(s.MQConf)
+	assert.NoError(s.T(), err)
+	defer messenger.Connection.Close()
+	logger := &capturingLogger{}
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, db, new(tls.Config))
+	proxy.auditLogger = logger
+
+	fileID := s.putInboxFile(db, "rename-source.c4gh")
+	assert.NoError(s.T(), db.SetUploadedChecksum(context.TODO(), fileID, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+
+	// Copies are new files, the source is left as it is
+	r, _ := http.NewRequest("PUT", "/dummy/dir/renamed.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "/dummy/rename-source.c4gh")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	var result CopyObjectResult
+	assert.NoError(s.T(), xml.Unmarshal(w.Body.Bytes(), &result))
+	assert.NotEmpty(s.T(), result.ETag)
+
+	exists, err := proxy.checkFileExists(context.TODO(), "dummy/rename-source.c4gh")
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), exists)
+	exists, err = proxy.checkFileExists(context.TODO(), "dummy/dir/renamed.c4gh")
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), exists)
+
+	copyID, err := db.GetFileIDInInbox(context.TODO(), "dummy", "dir/renamed.c4gh")
+	assert.NoError(s.T(), err)
+	assert.NotEqual(s.T(), fileID, copyID)
+	status, err := db.GetFileStatus(fileID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "uploaded", status)
+	status, err = db.GetFileStatus(copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "uploaded", status)
+	checksum, err := db.GetUploadedChecksum(context.TODO(), copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", checksum)
+	assert.Len(s.T(), logger.events, 1)
+	assert.Equal(s.T(), audit.EventUploadCopied, logger.events[0].Event)
+	assert.Equal(s.T(), copyID, logger.events[0].FileID)
+	assert.Equal(s.T(), "dir/renamed.c4gh", logger.events[0].Target)
+
+	// Removing the source of a copy completes a rename
+	r, _ = http.NewRequest("DELETE", "/dummy/rename-source.c4gh", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	exists, err = proxy.checkFileExists(context.TODO(), "dummy/rename-source.c4gh")
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), exists)
+	status, err = db.GetFileStatus(fileID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "disabled", status)
+	assert.Equal(s.T(), audit.EventUploadRenamed, logger.events[1].Event)
+	assert.Equal(s.T(), fileID, logger.events[1].FileID)
+	assert.Equal(s.T(), "dir/renamed.c4gh", logger.events[1].Target)
+
+	// Files can not be copied onto existing files or from the inbox of other users
+	otherID := s.putInboxFile(db, "rename-other.c4gh")
+	r, _ = http.NewRequest("PUT", "/dummy/dir/renamed.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "/dummy/rename-other.c4gh")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 409, w.Result().StatusCode)
+	assert.Equal(s.T(), audit.EventUploadDenied, logger.events[2].Event)
+
+	r, _ = http.NewRequest("PUT", "/dummy/stolen.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "/other/rename-other.c4gh")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 400, w.Result().StatusCode)
+
+	// Removed files are disabled
+	r, _ = http.NewRequest("DELETE", "/dummy/dir/renamed.c4gh", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	exists, err = proxy.checkFileExists(context.TODO(), "dummy/dir/renamed.c4gh")
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), exists)
+	status, err = db.GetFileStatus(copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "disabled", status)
+	assert.Equal(s.T(), audit.EventUploadRemoved, logger.events[len(logger.events)-1].Event)
+
+	// The record of a removed file is reused when another file is copied to its path
+	r, _ = http.NewRequest("PUT", "/dummy/dir/renamed.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "dummy/rename-other.c4gh")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	status, err = db.GetFileStatus(copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "uploaded", status)
+	status, err = db.GetFileStatus(otherID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "uploaded", status)
+
+	// Files that are being ingested can not be removed
+	ingestedID := s.putInboxFile(db, "remove-ingesting.c4gh")
+	assert.NoError(s.T(), db.UpdateFileEventLog(ingestedID, "submitted", "dummy", "{}", "{}"))
+	r, _ = http.NewRequest("DELETE", "/dummy/remove-ingesting.c4gh", http.NoBody)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 409, w.Result().StatusCode)
+	exists, err = proxy.checkFileExists(context.TODO(), "dummy/remove-ingesting.c4gh")
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), exists)
+}
+
+// nolint:bodyclose
+func (s *ProxyTests) TestServeHTTP_copyNotAnnounced() {
+	db, err := database.NewSDAdb(s.DBConf)
+	assert.NoError(s.T(), err)
+	defer db.Close()
+	s.putInboxFile(db, "unannounced-source.c4gh")
+
+	// Without a broker the copy can not be announced, so it is removed again and its file is disabled
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), nil, db, new(tls.Config))
+	r, _ := http.NewRequest("PUT", "/dummy/unannounced-copy.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "/dummy/unannounced-source.c4gh")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 500, w.Result().StatusCode)
+	exists, err := proxy.checkFileExists(context.TODO(), "dummy/unannounced-copy.c4gh")
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), exists)
+	copyID, err := db.GetFileIDInInbox(context.TODO(), "dummy", "unannounced-copy.c4gh")
+	assert.NoError(s.T(), err)
+	status, err := db.GetFileStatus(copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "disabled", status)
+
+	// The copy can be made again once the broker is back, reusing the record of the failed copy
+	messenger, err := broker.NewMQ(s.MQConf)
+	assert.NoError(s.T(), err)
+	defer messenger.Connection.Close()
+	proxy = NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), messenger, db, new(tls.Config))
+	r, _ = http.NewRequest("PUT", "/dummy/unannounced-copy.c4gh", http.NoBody)
+	r.Header.Set("x-amz-copy-source", "/dummy/unannounced-source.c4gh")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	assert.Equal(s.T(), 200, w.Result().StatusCode)
+	exists, err = proxy.checkFileExists(context.TODO(), "dummy/unannounced-copy.c4gh")
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), exists)
+	status, err = db.GetFileStatus(copyID)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "uploaded", status)
+}
+
+func (s *ProxyTests) TestCopyObjectParts() {
+	proxy := NewProxy(s.s3Conf, s.s3Client, helper.NewAlwaysAllow(), s.messenger, s.database, new(tls.Config))
+	_, err := s.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Body:   strings.NewReader("This is a test"),
+		Bucket: aws.String(s.s3Conf.Bucket),
+		Key:    aws.String("dummy/part-copy-source"),
+	})
+	assert.NoError(s.T(), err)
+
+	etag, err := proxy.copyObjectParts(context.TODO(), s.s3Conf.Bucket+"/dummy/part-copy-source", "dummy/part-copy-destination", 14)
+	assert.NoError(s.T(), err)
+	assert.NotEmpty(s.T(), etag)
+
+	_, size, err := proxy.requestInfo(context.TODO(), "dummy/part-copy-destination")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(14), size)
+}
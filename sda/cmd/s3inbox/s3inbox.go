@@ -22,6 +22,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/config"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
@@ -53,8 +54,8 @@ func run() error {
 		return fmt.Errorf("failed to initialize sda db due to: %v", err)
 	}
 	defer sdaDB.Close()
-	if sdaDB.Version < 23 {
-		return errors.New("database schema v23 is required")
+	if sdaDB.Version < 28 {
+		return errors.New("database schema v28 is required")
 	}
 
 	log.Debugf("Connected to sda-db (v%v)", sdaDB.Version)
@@ -101,6 +102,18 @@ func run() error {
 	}
 	router := mux.NewRouter()
 	proxy := NewProxy(conf.S3Inbox, s3Client, auth, mqBroker, sdaDB, tlsProxy)
+	if len(conf.Audit.Sinks) > 0 {
+		auditLogger, err := audit.NewLogger(conf.Audit)
+		if err != nil {
+			return fmt.Errorf("failed to initialize audit logger due to: %v", err)
+		}
+		defer func() {
+			if err := auditLogger.Close(); err != nil {
+				log.Errorf("failed to close audit logger due to: %v", err)
+			}
+		}()
+		proxy.auditLogger = auditLogger
+	}
 	router.HandleFunc("/", proxy.CheckHealth).Methods("HEAD")
 	router.HandleFunc("/health", proxy.CheckHealth)
 	router.PathPrefix("/").Handler(proxy)
@@ -0,0 +1,242 @@
+// The scrub service continuously re-verifies the files in the archive, starting with the files which have never been
+// scrubbed or were scrubbed the longest time ago, while keeping the amount of archived data read below a configured rate.
+// Files are either re-verified by the verify service, to which a message is sent for each file, or inline by comparing
+// the archived file with its ARCHIVED checksum.
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+// scrubDatabase defines the database methods used by the scrubber
+type scrubDatabase interface {
+	GetFilesToScrub(ctx context.Context, scrubbedBefore time.Time, limit int) ([]*database.ScrubFile, error)
+	SetScrubbed(ctx context.Context, fileID string) error
+	UpdateFileEventLog(fileUUID, event, user, details, message string) error
+}
+
+type scrubber struct {
+	conf          config.ScrubConf
+	db            scrubDatabase
+	archiveReader storage.Reader
+	// publish sends a re-verification message to the verify service, only used in message mode
+	publish     func(fileID string, body []byte) error
+	schemasPath string
+	throttle    *throttle
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf, err := config.NewConfig("scrub")
+	if err != nil {
+		return fmt.Errorf("failed to load config, due to: %v", err)
+	}
+	db, err := database.NewSDAdb(conf.Database)
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db, due to: %v", err)
+	}
+	defer db.Close()
+
+	if db.Version < 24 {
+		return errors.New("database schema v24 is required")
+	}
+
+	s := &scrubber{
+		conf:        conf.Scrub,
+		db:          db,
+		schemasPath: conf.Broker.SchemasPath,
+		throttle:    newThrottle(conf.Scrub.Rate),
+	}
+
+	var mqErr <-chan error
+	switch conf.Scrub.Mode {
+	case "message":
+		mqBroker, err := broker.NewBus(conf.Broker)
+		if err != nil {
+			return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
+		}
+		defer func() {
+			if err := mqBroker.Close(); err != nil {
+				log.Error(err)
+			}
+		}()
+		mqErr = mqBroker.NotifyClose()
+
+		s.publish = func(fileID string, body []byte) error {
+			return mqBroker.SendMessage(fileID, conf.Broker.Exchange, "archived", body)
+		}
+	case "inline":
+		s.archiveReader, err = storage.NewReader(ctx, "archive")
+		if err != nil {
+			return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
+		}
+	}
+
+	scrubErr := make(chan error, 1)
+	log.Infof("starting scrub service in %s mode", conf.Scrub.Mode)
+	go func() {
+		scrubErr <- s.run(ctx)
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	select {
+	case <-sigc:
+	case err := <-mqErr:
+		return err
+	case err := <-scrubErr:
+		return err
+	}
+
+	return nil
+}
+
+// run scrubs batches of files until the context is canceled, waiting for the configured interval whenever there is
+// nothing to scrub or a batch could not be completed
+func (s *scrubber) run(ctx context.Context) error {
+	for {
+		scrubbed, err := s.scrubBatch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Errorf("failed to scrub files, retrying in %s, reason: %v", s.conf.Interval, err)
+		}
+
+		if err != nil || scrubbed == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.conf.Interval):
+			}
+		}
+	}
+}
+
+// scrubBatch scrubs the next batch of files which are due, and returns the number of files scrubbed
+func (s *scrubber) scrubBatch(ctx context.Context) (int, error) {
+	files, err := s.db.GetFilesToScrub(ctx, time.Now().Add(-s.conf.ReverifyAfter), s.conf.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get files to scrub, due to: %v", err)
+	}
+
+	for i, file := range files {
+		if err := s.scrubFile(ctx, file); err != nil {
+			return i, err
+		}
+		if err := s.db.SetScrubbed(ctx, file.FileID); err != nil {
+			return i, fmt.Errorf("failed to set file-id: %s as scrubbed, due to: %v", file.FileID, err)
+		}
+	}
+
+	return len(files), nil
+}
+
+func (s *scrubber) scrubFile(ctx context.Context, file *database.ScrubFile) error {
+	if s.conf.Mode == "inline" {
+		return s.verifyInline(ctx, file)
+	}
+
+	reVerify := schema.IngestionVerification{
+		User:        file.SubmissionUser,
+		FilePath:    file.SubmissionFilePath,
+		FileID:      file.FileID,
+		ArchivePath: file.ArchivePath,
+		EncryptedChecksums: []schema.Checksums{
+			{Type: file.ChecksumType, Value: file.Checksum},
+		},
+		ReVerify: true,
+	}
+	body, _ := json.Marshal(&reVerify)
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", s.schemasPath), body); err != nil {
+		return fmt.Errorf("validation of outgoing (ingestion-verification) failed, file-id: %s, reason: %v", file.FileID, err)
+	}
+	if err := s.publish(file.FileID, body); err != nil {
+		return fmt.Errorf("failed to publish message, file-id: %s, reason: %v", file.FileID, err)
+	}
+	log.Debugf("sent file-id: %s to be re-verified", file.FileID)
+
+	// The verify service reads the archived file, the budget is therefore spent as the message is sent
+	return s.throttle.wait(ctx, file.ArchiveSize)
+}
+
+// verifyInline compares the archived file with its ARCHIVED checksum, and records an error in the file event log on
+// mismatch.
+// Only errors which are expected to be transient are returned, such that the file is scrubbed again later.
+func (s *scrubber) verifyInline(ctx context.Context, file *database.ScrubFile) error {
+	var h hash.Hash
+	switch file.ChecksumType {
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		log.Warnf("unsupported checksum type: %s of file-id: %s, skipping", file.ChecksumType, file.FileID)
+
+		return nil
+	}
+
+	f, err := s.archiveReader.NewFileReader(ctx, file.ArchiveLocation, file.ArchivePath)
+	if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+		return s.recordError(file, "archived file not found", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open archived file, file-id: %s, reason: %v", file.FileID, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err := io.Copy(h, &throttledReader{ctx: ctx, reader: f, throttle: s.throttle}); err != nil {
+		return fmt.Errorf("failed to read archived file, file-id: %s, reason: %v", file.FileID, err)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != file.Checksum {
+		log.Errorf("archived checksum mismatch for file, file-id: %s, expected: %s, got: %s", file.FileID, file.Checksum, actual)
+
+		return s.recordError(file, "archived checksum don't match", &storageerrors.ChecksumMismatchError{Algorithm: file.ChecksumType, Expected: file.Checksum, Actual: actual})
+	}
+	// As for re-verifications by the verify service, a match is not recorded as an event, since the status of the file
+	// is its latest event. The time the file was scrubbed is recorded by the caller.
+	log.Debugf("file-id: %s verified", file.FileID)
+
+	return nil
+}
+
+func (s *scrubber) recordError(file *database.ScrubFile, msg string, reason error) error {
+	details, _ := json.Marshal(map[string]string{"error": msg, "reason": reason.Error()})
+	if err := s.db.UpdateFileEventLog(file.FileID, "error", "scrub", string(details), "{}"); err != nil {
+		return fmt.Errorf("failed to set error status for file-id: %s, reason: %v", file.FileID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ScrubTestSuite struct {
+	suite.Suite
+
+	archiveDir string
+	db         *mockDatabase
+	scrubber   *scrubber
+	published  map[string][]byte
+	slept      []time.Duration
+}
+
+type mockDatabase struct {
+	mock.Mock
+}
+
+func (m *mockDatabase) GetFilesToScrub(_ context.Context, _ time.Time, limit int) ([]*database.ScrubFile, error) {
+	args := m.Called(limit)
+
+	return args.Get(0).([]*database.ScrubFile), args.Error(1)
+}
+
+func (m *mockDatabase) SetScrubbed(_ context.Context, fileID string) error {
+	args := m.Called(fileID)
+
+	return args.Error(0)
+}
+
+func (m *mockDatabase) UpdateFileEventLog(fileUUID, event, user, details, message string) error {
+	args := m.Called(fileUUID, event, user, details, message)
+
+	return args.Error(0)
+}
+
+func TestScrubTestSuite(t *testing.T) {
+	suite.Run(t, new(ScrubTestSuite))
+}
+
+func (ts *ScrubTestSuite) SetupTest() {
+	viper.Reset()
+	ts.archiveDir = ts.T().TempDir()
+	viper.Set("storage.archive.posix", []map[string]any{{"path": ts.archiveDir}})
+
+	archiveReader, err := storage.NewReader(context.TODO(), "archive")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.db = &mockDatabase{}
+	ts.published = map[string][]byte{}
+	ts.slept = nil
+	ts.scrubber = &scrubber{
+		conf: config.ScrubConf{
+			Mode:          "message",
+			Rate:          1000,
+			BatchSize:     10,
+			Interval:      time.Minute,
+			ReverifyAfter: time.Hour,
+		},
+		db:            ts.db,
+		archiveReader: archiveReader,
+		publish: func(fileID string, body []byte) error {
+			ts.published[fileID] = body
+
+			return nil
+		},
+		schemasPath: "../../schemas/isolated",
+		throttle: &throttle{rate: 1000, start: time.Now(), sleep: func(_ context.Context, d time.Duration) error {
+			ts.slept = append(ts.slept, d)
+
+			return nil
+		}},
+	}
+}
+
+func (ts *ScrubTestSuite) archiveFile(name, content string) *database.ScrubFile {
+	if err := os.WriteFile(filepath.Join(ts.archiveDir, name), []byte(content), 0600); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	return &database.ScrubFile{
+		FileID:             name,
+		ArchiveLocation:    ts.archiveDir,
+		ArchivePath:        name,
+		ArchiveSize:        int64(len(content)),
+		SubmissionFilePath: "dir/" + name + ".c4gh",
+		SubmissionUser:     "user",
+		ChecksumType:       "sha256",
+		Checksum:           fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+	}
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_Message() {
+	file := ts.archiveFile("file1", "archived content")
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{file}, nil).Once()
+	ts.db.On("SetScrubbed", "file1").Return(nil).Once()
+
+	scrubbed, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.NoError(err)
+	ts.Equal(1, scrubbed)
+	ts.db.AssertExpectations(ts.T())
+
+	var message schema.IngestionVerification
+	ts.NoError(json.Unmarshal(ts.published["file1"], &message))
+	ts.True(message.ReVerify)
+	ts.Equal("file1", message.ArchivePath)
+	ts.Equal("dir/file1.c4gh", message.FilePath)
+	ts.Equal("user", message.User)
+	ts.Equal([]schema.Checksums{{Type: "sha256", Value: file.Checksum}}, message.EncryptedChecksums)
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_PublishFailed() {
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{ts.archiveFile("file1", "archived content")}, nil).Once()
+	ts.scrubber.publish = func(_ string, _ []byte) error {
+		return errors.New("broker unavailable")
+	}
+
+	scrubbed, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.ErrorContains(err, "broker unavailable")
+	ts.Equal(0, scrubbed)
+	ts.db.AssertNotCalled(ts.T(), "SetScrubbed", mock.Anything)
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_Inline() {
+	ts.scrubber.conf.Mode = "inline"
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{ts.archiveFile("file1", "archived content")}, nil).Once()
+	ts.db.On("SetScrubbed", "file1").Return(nil).Once()
+
+	scrubbed, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.NoError(err)
+	ts.Equal(1, scrubbed)
+	ts.db.AssertExpectations(ts.T())
+	// a match leaves the status of the file, its latest event, as it was
+	ts.db.AssertNotCalled(ts.T(), "UpdateFileEventLog", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	ts.Empty(ts.published)
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_InlineEventLogFailed() {
+	ts.scrubber.conf.Mode = "inline"
+	file := ts.archiveFile("file1", "archived content")
+	file.Checksum = fmt.Sprintf("%x", sha256.Sum256([]byte("original content")))
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{file}, nil).Once()
+	ts.db.On("UpdateFileEventLog", "file1", "error", "scrub", mock.Anything, "{}").Return(errors.New("database unavailable")).Once()
+
+	scrubbed, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.ErrorContains(err, "database unavailable")
+	ts.Equal(0, scrubbed)
+	ts.db.AssertNotCalled(ts.T(), "SetScrubbed", mock.Anything)
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_InlineChecksumMismatch() {
+	ts.scrubber.conf.Mode = "inline"
+	file := ts.archiveFile("file1", "archived content")
+	file.Checksum = fmt.Sprintf("%x", sha256.Sum256([]byte("original content")))
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{file}, nil).Once()
+	ts.db.On("UpdateFileEventLog", "file1", "error", "scrub", mock.MatchedBy(func(details string) bool {
+		var d map[string]string
+
+		return json.Unmarshal([]byte(details), &d) == nil && d["error"] == "archived checksum don't match"
+	}), "{}").Return(nil).Once()
+	ts.db.On("SetScrubbed", "file1").Return(nil).Once()
+
+	_, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.NoError(err)
+	ts.db.AssertExpectations(ts.T())
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_InlineFileMissing() {
+	ts.scrubber.conf.Mode = "inline"
+	file := ts.archiveFile("file1", "archived content")
+	file.ArchivePath = "missing"
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{file}, nil).Once()
+	ts.db.On("UpdateFileEventLog", "file1", "error", "scrub", mock.Anything, "{}").Return(nil).Once()
+	ts.db.On("SetScrubbed", "file1").Return(nil).Once()
+
+	_, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.NoError(err)
+	ts.db.AssertExpectations(ts.T())
+}
+
+func (ts *ScrubTestSuite) TestScrubBatch_DatabaseError() {
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile(nil), errors.New("connection refused")).Once()
+
+	_, err := ts.scrubber.scrubBatch(context.TODO())
+	ts.EqualError(err, "failed to get files to scrub, due to: connection refused")
+}
+
+func (ts *ScrubTestSuite) TestRun_StopsWhenCanceled() {
+	ctx, cancel := context.WithCancel(context.TODO())
+	ts.db.On("GetFilesToScrub", 10).Return([]*database.ScrubFile{}, nil).Run(func(_ mock.Arguments) {
+		cancel()
+	}).Once()
+
+	ts.NoError(ts.scrubber.run(ctx))
+	ts.db.AssertExpectations(ts.T())
+}
+
+func (ts *ScrubTestSuite) TestThrottle() {
+	// Spending 500 bytes at 1000 bytes per second is due in about half a second
+	ts.NoError(ts.scrubber.throttle.wait(context.TODO(), 500))
+	ts.Len(ts.slept, 1)
+	ts.InDelta(500*time.Millisecond, ts.slept[0], float64(50*time.Millisecond))
+
+	// Once behind the budget, the throttle starts over instead of allowing a burst
+	ts.scrubber.throttle.start = time.Now().Add(-time.Hour)
+	ts.NoError(ts.scrubber.throttle.wait(context.TODO(), 100))
+	ts.Len(ts.slept, 1)
+	ts.Equal(uint64(0), ts.scrubber.throttle.spent)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttle keeps the number of bytes spent per second below the rate, unused budget is not saved for later
+type throttle struct {
+	rate  uint64
+	start time.Time
+	spent uint64
+	// sleep is replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newThrottle(rate uint64) *throttle {
+	return &throttle{rate: rate, start: time.Now(), sleep: sleepContext}
+}
+
+// wait spends n bytes of the budget, and blocks until the budget allows for them to have been spent
+func (t *throttle) wait(ctx context.Context, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	// #nosec G115 -- n is checked to be positive above
+	t.spent += uint64(n)
+	due := t.start.Add(time.Duration(float64(t.spent) / float64(t.rate) * float64(time.Second)))
+	if !due.After(now) {
+		// Behind the budget, start over from now
+		t.start = now
+		t.spent = 0
+
+		return nil
+	}
+
+	return t.sleep(ctx, due.Sub(now))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader spends the budget of the throttle for every byte read
+type throttledReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	throttle *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if throttleErr := r.throttle.wait(r.ctx, int64(n)); throttleErr != nil {
+		return n, throttleErr
+	}
+
+	return n, err
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/inbox"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPendingSize limits the data of an upload that is buffered while waiting for data written out of order by the client
+const maxPendingSize = 64 * 1024 * 1024
+
+// userInbox serves the sftp requests of a user, who can upload files to and list the files in their inbox. Files can
+// not be downloaded, removed or renamed.
+type userInbox struct {
+	*Inbox
+	username string
+}
+
+// Fileread refuses downloads
+func (u *userInbox) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	log.Warnf("user: %s, refused download of %s", u.username, r.Filepath)
+
+	return nil, sftp.ErrSSHFxPermissionDenied
+}
+
+// Filewrite starts the upload of a file, which is written to the inbox storage as it is received and registered once
+// the client closes the file. Uploads that would exceed an inbox quota are refused.
+func (u *userInbox) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	event := audit.Event{UserID: u.username, Path: r.Filepath}
+	storagePath := path.Join(strings.Replace(u.username, "@", "_", 1), r.Filepath)
+	filePath, err := helper.FormatUploadFilePath(helper.AnonymizeFilepath(storagePath, u.username))
+	if err != nil || filePath == "" || r.Pflags().Append {
+		log.Warnf("bad request from user %s: upload of %s refused: %v", u.username, r.Filepath, err)
+		u.deny(r.Context(), event, "upload of the path not allowed")
+
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	event.Target = filePath
+
+	fileID, err := inbox.FileID(r.Context(), u.DB, u.username, filePath)
+	switch {
+	case errors.Is(err, inbox.ErrBeingIngested):
+		log.Warnf("user: %s, upload of %s refused: %v", u.username, r.Filepath, err)
+		u.deny(r.Context(), event, err.Error())
+
+		return nil, sftp.ErrSSHFxPermissionDenied
+	case err != nil:
+		log.Errorf("user: %s, upload of %s failed: %v", u.username, r.Filepath, err)
+
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	// the upload replaces the file in the inbox, which is announced as removed once the new file has been registered
+	reupload := false
+	if fileID != "" {
+		status, err := u.DB.GetFileStatus(fileID)
+		if err != nil {
+			log.Errorf("user: %s, failed to get status of file: %s from database: %v", u.username, r.Filepath, err)
+
+			return nil, sftp.ErrSSHFxFailure
+		}
+		reupload = status == "uploaded"
+	}
+
+	// the size of the upload is not known until it has been written, here only a full inbox is refused
+	reason, err := inbox.QuotaExceeded(r.Context(), u.DB, u.username, fileID, 0, true)
+	if err != nil {
+		log.Errorf("user: %s, upload of %s failed: %v", u.username, r.Filepath, err)
+
+		return nil, sftp.ErrSSHFxFailure
+	}
+	if reason != "" {
+		log.Infof("user: %s, upload of %s refused: %s", u.username, r.Filepath, reason)
+		u.deny(r.Context(), event, reason)
+
+		return nil, errors.New(reason)
+	}
+
+	return u.newUpload(r.Context(), storagePath, filePath, fileID, reupload, event), nil
+}
+
+// deny records in the audit log that an upload was refused
+func (u *userInbox) deny(ctx context.Context, event audit.Event, reason string) {
+	event.Event = audit.EventUploadDenied
+	event.ErrorReason = reason
+	u.Audit.Log(ctx, event)
+}
+
+// Filecmd accepts creating directories and setting attributes, which have no meaning in the inbox, and refuses other
+// commands
+func (u *userInbox) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Mkdir", "Setstat":
+		return nil
+	default:
+		log.Warnf("user: %s, refused %s of %s", u.username, r.Method, r.Filepath)
+
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// Filelist lists the directories of the inbox and stats the files in it, the directories are made up of the paths of
+// the files of the user that have not been added to a dataset
+func (u *userInbox) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if r.Method != "List" && r.Method != "Stat" && r.Method != "Lstat" {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+
+	name := strings.TrimPrefix(r.Filepath, "/")
+	files, err := u.DB.GetUserFiles(u.username, name, false)
+	if err != nil {
+		log.Errorf("user: %s, failed to get files from database: %v", u.username, err)
+
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	if r.Method == "List" {
+		var prefix string
+		if name != "" {
+			prefix = name + "/"
+		}
+
+		return listerAt(listDir(files, prefix)), nil
+	}
+
+	if name == "" {
+		return listerAt{dirInfo("/")}, nil
+	}
+	isDir := false
+	for _, file := range files {
+		if file.InboxPath == name {
+			return listerAt{fileInfo{name: path.Base(name), size: file.SubmissionFileSize}}, nil
+		}
+		isDir = isDir || strings.HasPrefix(file.InboxPath, name+"/")
+	}
+	if isDir {
+		return listerAt{dirInfo(path.Base(name))}, nil
+	}
+
+	return nil, sftp.ErrSSHFxNoSuchFile
+}
+
+// listDir returns the files and subdirectories of the directory with the prefix
+func listDir(files []*database.SubmissionFileInfo, prefix string) []os.FileInfo {
+	var entries []os.FileInfo
+	var dirs []string
+	for _, file := range files {
+		if !strings.HasPrefix(file.InboxPath, prefix) {
+			continue
+		}
+		name, _, isDir := strings.Cut(strings.TrimPrefix(file.InboxPath, prefix), "/")
+		switch {
+		case !isDir:
+			entries = append(entries, fileInfo{name: name, size: file.SubmissionFileSize})
+		case !slices.Contains(dirs, name):
+			dirs = append(dirs, name)
+			entries = append(entries, dirInfo(name))
+		}
+	}
+
+	return entries
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// fileInfo describes a file or directory in the inbox
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func dirInfo(name string) fileInfo {
+	return fileInfo{name: name, isDir: true}
+}
+
+func (f fileInfo) Name() string { return f.name }
+func (f fileInfo) Size() int64  { return f.size }
+func (f fileInfo) Mode() fs.FileMode {
+	if f.isDir {
+		return fs.ModeDir | 0o700
+	}
+
+	return 0o600
+}
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.isDir }
+func (f fileInfo) Sys() any           { return nil }
+
+// upload streams the data of a file written over sftp to the inbox storage. Data written out of order, as clients keep
+// several writes in flight, is buffered until the preceding data has been written. When the crypt4gh header is
+// validated, the start of the file is held back until its header has been checked.
+type upload struct {
+	*userInbox
+	storagePath string
+	filePath    string
+	fileID      string
+	reupload    bool
+	event       audit.Event
+
+	mu          sync.Mutex
+	pipe        *io.PipeWriter
+	written     int64
+	pending     map[int64][]byte
+	pendingSize int
+	// header is the start of the file while its crypt4gh header has not been checked, nil when the header is not
+	// validated or has been found
+	header []byte
+	md5    hash.Hash
+	sha256 hash.Hash
+	err    error
+	// denied is set when the upload was stopped as it is not allowed, rather than as it failed
+	denied bool
+
+	location chan string
+	writeErr chan error
+}
+
+// newUpload starts writing the file to the inbox storage, fileID is the id of the file the upload replaces, if any
+func (u *userInbox) newUpload(ctx context.Context, storagePath, filePath, fileID string, reupload bool, event audit.Event) *upload {
+	reader, writer := io.Pipe()
+	up := &upload{
+		userInbox:   u,
+		storagePath: storagePath,
+		filePath:    filePath,
+		fileID:      fileID,
+		reupload:    reupload,
+		event:       event,
+		pipe:        writer,
+		pending:     make(map[int64][]byte),
+		md5:         md5.New(),
+		sha256:      sha256.New(),
+		location:    make(chan string, 1),
+		writeErr:    make(chan error, 1),
+	}
+	if u.ValidateHeader {
+		up.header = []byte{}
+	}
+
+	go func() {
+		location, err := u.Writer.WriteFile(ctx, storagePath, reader)
+		_ = reader.CloseWithError(err)
+		if err != nil {
+			up.writeErr <- err
+
+			return
+		}
+		up.location <- location
+	}()
+
+	return up
+}
+
+// WriteAt writes the data to the storage once all preceding data has been written
+func (up *upload) WriteAt(p []byte, off int64) (int, error) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if up.err != nil {
+		return 0, up.err
+	}
+
+	switch {
+	case off < up.written:
+		up.fail(errors.New("data can only be written once"))
+
+		return 0, up.err
+	case off > up.written:
+		if _, ok := up.pending[off]; ok || up.pendingSize+len(p) > maxPendingSize {
+			up.fail(errors.New("too much data written out of order"))
+
+			return 0, up.err
+		}
+		up.pending[off] = bytes.Clone(p)
+		up.pendingSize += len(p)
+
+		return len(p), nil
+	}
+
+	if err := up.write(p); err != nil {
+		return 0, err
+	}
+	for {
+		next, ok := up.pending[up.written]
+		if !ok {
+			break
+		}
+		delete(up.pending, up.written)
+		up.pendingSize -= len(next)
+		if err := up.write(next); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (up *upload) write(p []byte) error {
+	_, _ = up.md5.Write(p)
+	_, _ = up.sha256.Write(p)
+	up.written += int64(len(p))
+
+	if up.header == nil {
+		return up.flush(p)
+	}
+	up.header = append(up.header, p...)
+
+	return up.checkHeader(false)
+}
+
+// checkHeader writes the held back start of the file to the storage once it holds a crypt4gh header that one of the
+// archive keys can decrypt. The upload is stopped when there is no such header in the start of the file, or in the
+// whole file once it is complete.
+func (up *upload) checkHeader(complete bool) error {
+	if reason := inbox.InvalidCrypt4GHHeader(up.header, up.C4ghPrivateKeyList); reason != "" {
+		if complete || len(up.header) >= inbox.Crypt4GHHeaderPeekSize {
+			up.deny(reason)
+
+			return up.err
+		}
+
+		return nil
+	}
+	header := up.header
+	up.header = nil
+
+	return up.flush(header)
+}
+
+// flush writes the data to the storage
+func (up *upload) flush(p []byte) error {
+	if _, err := up.pipe.Write(p); err != nil {
+		up.fail(err)
+
+		return up.err
+	}
+
+	return nil
+}
+
+// fail stops the upload, the storage discards the data written so far
+func (up *upload) fail(err error) {
+	if up.err == nil {
+		up.err = err
+		_ = up.pipe.CloseWithError(err)
+	}
+}
+
+// deny stops the upload as it is not allowed, the reason is reported to the client
+func (up *upload) deny(reason string) {
+	if up.err == nil {
+		log.Infof("user: %s, upload of %s refused: %s", up.username, up.filePath, reason)
+		up.denied = true
+	}
+	up.fail(errors.New(reason))
+}
+
+// TransferError stops the upload when the connection to the client is lost
+func (up *upload) TransferError(err error) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	up.fail(err)
+}
+
+// Close completes the upload, a file that was written in full is checked against the inbox quotas before it is
+// committed to the storage, and then registered and announced
+func (up *upload) Close() error {
+	up.mu.Lock()
+	if len(up.pending) > 0 {
+		up.fail(errors.New("the file has gaps"))
+	}
+	if up.err == nil && up.header != nil {
+		_ = up.checkHeader(true)
+	}
+	if up.err == nil {
+		up.checkQuota()
+	}
+	if up.err == nil {
+		_ = up.pipe.Close()
+	}
+	up.mu.Unlock()
+
+	var location string
+	var writeErr error
+	select {
+	case location = <-up.location:
+	case writeErr = <-up.writeErr:
+	}
+
+	up.event.BytesTransferred = up.written
+	switch {
+	case up.denied:
+		up.event.Event = audit.EventUploadDenied
+		up.event.ErrorReason = up.err.Error()
+		up.Audit.Log(context.Background(), up.event)
+
+		return up.err
+	case up.err != nil:
+		log.Errorf("user: %s, upload of file: %s failed: %v", up.username, up.filePath, up.err)
+		up.failed(up.err)
+
+		return sftp.ErrSSHFxFailure
+	case writeErr != nil:
+		log.Errorf("user: %s, failed to write file: %s to inbox storage: %v", up.username, up.filePath, writeErr)
+		up.failed(writeErr)
+
+		return sftp.ErrSSHFxFailure
+	}
+
+	if err := up.register(location); err != nil {
+		log.Errorf("user: %s, failed to register file: %s: %v", up.username, up.filePath, err)
+		up.failed(err)
+
+		return sftp.ErrSSHFxFailure
+	}
+	up.event.Event = audit.EventUploadCompleted
+	up.Audit.Log(context.Background(), up.event)
+
+	return nil
+}
+
+// checkQuota stops the upload if storing the written data would exceed an inbox quota, the file replaced by a reupload
+// is not counted
+func (up *upload) checkQuota() {
+	reason, err := inbox.QuotaExceeded(context.Background(), up.DB, up.username, up.fileID, up.written, true)
+	switch {
+	case err != nil:
+		up.fail(err)
+	case reason != "":
+		up.deny(reason)
+	}
+}
+
+// failed records in the audit log that the upload failed
+func (up *upload) failed(err error) {
+	up.event.Event = audit.EventUploadFailed
+	up.event.ErrorReason = err.Error()
+	up.Audit.Log(context.Background(), up.event)
+}
+
+// register registers the uploaded file in the database and announces it with an inbox-upload message, the same way
+// as the uploads to the s3inbox
+func (up *upload) register(location string) error {
+	fileID := up.fileID
+	if fileID == "" {
+		var err error
+		fileID, err = up.DB.RegisterFile(nil, location, up.filePath, up.username)
+		if err != nil {
+			return fmt.Errorf("failed to register file in database: %v", err)
+		}
+	}
+
+	md5Checksum := hex.EncodeToString(up.md5.Sum(nil))
+	sha256Checksum := hex.EncodeToString(up.sha256.Sum(nil))
+	message, err := json.Marshal(schema.InboxUpload{
+		User:      up.username,
+		FilePath:  up.storagePath,
+		Operation: "upload",
+		FileSize:  up.written,
+		EncryptedChecksums: []schema.Checksums{
+			{Type: "md5", Value: md5Checksum},
+			{Type: "sha256", Value: sha256Checksum},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	send := func(fileID string, message []byte) error {
+		return up.MQ.SendMessage(fileID, up.MQConf.Exchange, up.MQConf.RoutingKey, message)
+	}
+	up.event.FileID = fileID
+	err = inbox.Uploaded(context.Background(), up.DB, send, inbox.Upload{
+		FileID:      fileID,
+		User:        up.username,
+		StoragePath: up.storagePath,
+		Size:        up.written,
+		SHA256:      sha256Checksum,
+		Message:     message,
+		Reupload:    up.reupload,
+	})
+	if err != nil {
+		return err
+	}
+
+	if up.reupload {
+		log.Infof("user: %s, reuploaded file: %s, with id: %s, checksum: %s", up.username, up.filePath, fileID, sha256Checksum)
+	} else {
+		log.Infof("user: %s, uploaded file: %s, with id: %s, checksum: %s", up.username, up.filePath, fileID, sha256Checksum)
+	}
+
+	return nil
+}
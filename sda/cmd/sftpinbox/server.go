@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/userauth"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+var errAuthenticationFailed = errors.New("authentication failed")
+
+// Serve accepts sftp connections on the listener until it is closed
+func (app *Inbox) Serve(listener net.Listener, hostKey ssh.Signer) error {
+	serverConfig := app.serverConfig(hostKey)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept connection, due to: %v", err)
+		}
+
+		go app.handleConn(conn, serverConfig)
+	}
+}
+
+// serverConfig returns the ssh configuration of the server, which authenticates the users with their password or one
+// of their public keys as known by CEGA
+func (app *Inbox) serverConfig(hostKey ssh.Signer) *ssh.ServerConfig {
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			user, err := app.cegaUser(conn.User())
+			if err != nil {
+				return nil, err
+			}
+			if !userauth.VerifyPassword(string(password), user.PasswordHash) {
+				log.WithFields(log.Fields{"authType": "password", "user": conn.User()}).Warn("Invalid password entered by user")
+
+				return nil, errAuthenticationFailed
+			}
+			log.WithFields(log.Fields{"authType": "password", "user": conn.User()}).Info("Valid password entered by user")
+
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			user, err := app.cegaUser(conn.User())
+			if err != nil {
+				return nil, err
+			}
+			for _, authorizedKey := range user.SSHPublicKey {
+				publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+				if err != nil {
+					log.Warnf("user: %s, failed to parse public key from cega: %v", conn.User(), err)
+
+					continue
+				}
+				if bytes.Equal(publicKey.Marshal(), key.Marshal()) {
+					log.WithFields(log.Fields{"authType": "publickey", "user": conn.User()}).Info("Valid public key offered by user")
+
+					return nil, nil
+				}
+			}
+
+			return nil, errAuthenticationFailed
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	return serverConfig
+}
+
+// cegaUser returns the credentials of the user from CEGA
+func (app *Inbox) cegaUser(username string) (*userauth.CegaUserResponse, error) {
+	user, err := userauth.GetCegaUser(app.Cega, username)
+	if err != nil {
+		log.WithFields(log.Fields{"authType": "cega", "user": username}).Errorf("Failed to get user from cega: %v", err)
+
+		return nil, errAuthenticationFailed
+	}
+	if user == nil {
+		log.WithFields(log.Fields{"authType": "cega", "user": username}).Warn("Unknown user")
+
+		return nil, errAuthenticationFailed
+	}
+
+	return user, nil
+}
+
+// handleConn authenticates the user of the connection and serves the sftp sessions of the user
+func (app *Inbox) handleConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		log.Infof("ssh handshake with %s failed: %v", conn.RemoteAddr(), err)
+
+		return
+	}
+	defer serverConn.Close()
+	log.Infof("user: %s, connected from %s", serverConn.User(), conn.RemoteAddr())
+
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Errorf("user: %s, failed to accept channel: %v", serverConn.User(), err)
+
+			continue
+		}
+
+		go app.handleSession(serverConn.User(), channel, channelRequests)
+	}
+}
+
+// handleSession serves the sftp subsystem of a session, shells and commands are refused
+func (app *Inbox) handleSession(username string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	started := false
+	for req := range requests {
+		var subsystem struct{ Name string }
+		ok := !started && req.Type == "subsystem" && ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "sftp"
+		_ = req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		started = true
+		go app.serveSFTP(username, channel)
+	}
+
+	if !started {
+		_ = channel.Close()
+	}
+}
+
+// serveSFTP serves the sftp requests of the user on the channel until the client disconnects
+func (app *Inbox) serveSFTP(username string, channel ssh.Channel) {
+	defer channel.Close()
+
+	handler := &userInbox{Inbox: app, username: username}
+	server := sftp.NewRequestServer(channel, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	})
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		log.Errorf("user: %s, sftp session failed: %v", username, err)
+	}
+	_ = server.Close()
+}
@@ -0,0 +1,132 @@
+// The sftpinbox service lets users upload files to the inbox over sftp,
+// authenticating them with their password or ssh key as known by CEGA, and
+// announces the uploaded files with the same inbox-upload messages as the
+// s3inbox.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Inbox serves the inbox of the users over sftp
+type Inbox struct {
+	Audit  audit.Logger
+	Cega   config.CegaConfig
+	DB     *database.SDAdb
+	MQ     broker.Bus
+	MQConf broker.MQConf
+	Writer storage.Writer
+	// ValidateHeader refuses uploads that do not start with a crypt4gh header one of the C4ghPrivateKeyList can decrypt
+	ValidateHeader     bool
+	C4ghPrivateKeyList []*[32]byte
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+func run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf, err := config.NewConfig("sftpinbox")
+	if err != nil {
+		return fmt.Errorf("failed to load config due to: %v", err)
+	}
+
+	hostKeyData, err := os.ReadFile(conf.SFTPInbox.HostKey)
+	if err != nil {
+		return fmt.Errorf("failed to read host key due to: %v", err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse host key due to: %v", err)
+	}
+
+	app := Inbox{
+		Audit:              audit.NoopLogger{},
+		Cega:               conf.SFTPInbox.Cega,
+		MQConf:             conf.Broker,
+		ValidateHeader:     conf.SFTPInbox.ValidateHeader,
+		C4ghPrivateKeyList: conf.SFTPInbox.C4ghPrivateKeyList,
+	}
+	app.DB, err = database.NewSDAdb(conf.Database)
+	if err != nil {
+		return fmt.Errorf("failed to initialize sda db due to: %v", err)
+	}
+	defer app.DB.Close()
+	if app.DB.Version < 28 {
+		return errors.New("database schema v28 is required")
+	}
+
+	app.MQ, err = broker.NewBus(conf.Broker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mq broker due to: %v", err)
+	}
+	defer func() {
+		if err := app.MQ.Close(); err != nil {
+			log.Errorf("failed to close mq broker due to: %v", err)
+		}
+	}()
+
+	if len(conf.Audit.Sinks) > 0 {
+		auditLogger, err := audit.NewLogger(conf.Audit)
+		if err != nil {
+			return fmt.Errorf("failed to initialize audit logger due to: %v", err)
+		}
+		defer func() {
+			if err := auditLogger.Close(); err != nil {
+				log.Errorf("failed to close audit logger due to: %v", err)
+			}
+		}()
+		app.Audit = auditLogger
+	}
+
+	storageLocationBroker, err := locationbroker.NewLocationBroker(app.DB)
+	if err != nil {
+		return fmt.Errorf("failed to initialize location broker due to: %v", err)
+	}
+	app.Writer, err = storage.NewWriter(ctx, "inbox", storageLocationBroker)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inbox writer due to: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.SFTPInbox.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d due to: %v", conf.SFTPInbox.Port, err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- app.Serve(listener, hostKey)
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	select {
+	case <-sigc:
+		return nil
+	case err := <-app.MQ.NotifyClose():
+		return err
+	case err := <-serverErr:
+		return err
+	}
+}
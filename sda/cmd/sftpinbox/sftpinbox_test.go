@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/memory"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+var DBport int
+
+func TestMain(m *testing.M) {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		m.Run()
+	}
+	_, b, _, _ := runtime.Caller(0)
+	rootDir := path.Join(path.Dir(b), "../../../")
+
+	// uses a sensible default on windows (tcp/http) and linux/osx (socket)
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		log.Fatalf("Could not construct pool: %s", err)
+	}
+
+	// uses pool to try to connect to Docker
+	err = pool.Client.Ping()
+	if err != nil {
+		log.Fatalf("Could not connect to Docker: %s", err)
+	}
+
+	// pulls an image, creates a container based on it and runs it
+	postgres, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "15.2-alpine3.17",
+		Env: []string{
+			"POSTGRES_PASSWORD=rootpasswd",
+			"POSTGRES_DB=sda",
+		},
+		Mounts: []string{
+			fmt.Sprintf("%s/postgresql/initdb.d:/docker-entrypoint-initdb.d", rootDir),
+		},
+	}, func(config *docker.HostConfig) {
+		// set AutoRemove to true so that stopped container goes away by itself
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{
+			Name: "no",
+		}
+	})
+	if err != nil {
+		log.Fatalf("Could not start resource: %s", err)
+	}
+
+	dbHostAndPort := postgres.GetHostPort("5432/tcp")
+	DBport, _ = strconv.Atoi(postgres.GetPort("5432/tcp"))
+	databaseURL := fmt.Sprintf("postgres://postgres:rootpasswd@%s/sda?sslmode=disable", dbHostAndPort)
+
+	pool.MaxWait = 120 * time.Second
+	if err = pool.Retry(func() error {
+		db, err := sql.Open("postgres", databaseURL)
+		if err != nil {
+			log.Println(err)
+
+			return err
+		}
+
+		query := "SELECT MAX(version) FROM sda.dbschema_version;"
+		var dbVersion int
+
+		return db.QueryRow(query).Scan(&dbVersion)
+	}); err != nil {
+		log.Fatalf("Could not connect to postgres: %s", err)
+	}
+
+	log.Println("starting tests")
+	code := m.Run()
+
+	log.Println("tests completed")
+	if err := pool.Purge(postgres); err != nil {
+		log.Fatalf("Could not purge resource: %s", err)
+	}
+
+	os.Exit(code)
+}
+
+type SFTPInboxTestSuite struct {
+	suite.Suite
+	app      Inbox
+	audit    *capturingLogger
+	bus      *broker.MemoryBus
+	inbox    *memory.Storage
+	cega     *httptest.Server
+	listener net.Listener
+	hostKey  ssh.Signer
+	userKey  ssh.Signer
+}
+
+// capturingLogger records audit events for test assertions
+type capturingLogger struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (l *capturingLogger) Log(_ context.Context, event audit.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *capturingLogger) Events() []audit.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.Clone(l.events)
+}
+
+func TestSFTPInboxTestSuite(t *testing.T) {
+	suite.Run(t, new(SFTPInboxTestSuite))
+}
+
+func (ts *SFTPInboxTestSuite) SetupSuite() {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	ts.hostKey, err = ssh.NewSignerFromKey(hostKey)
+	ts.Require().NoError(err)
+
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	ts.userKey, err = ssh.NewSignerFromKey(userKey)
+	ts.Require().NoError(err)
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	ts.Require().NoError(err)
+	user, err := json.Marshal(map[string]any{
+		"username":     "dummy@example.org",
+		"passwordHash": string(passwordHash),
+		"sshPublicKey": []string{string(ssh.MarshalAuthorizedKey(ts.userKey.PublicKey()))},
+	})
+	ts.Require().NoError(err)
+	ts.cega = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/dummy@example.org" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		_, _ = w.Write(user)
+	}))
+
+	ts.app.Cega = config.CegaConfig{AuthURL: ts.cega.URL + "/users/", ID: "id", Secret: "secret"}
+	ts.app.DB, err = database.NewSDAdb(database.DBConf{
+		Host:     "localhost",
+		Port:     DBport,
+		User:     "postgres",
+		Password: "rootpasswd",
+		Database: "sda",
+		SslMode:  "disable",
+	})
+	ts.Require().NoError(err)
+	ts.app.MQConf = broker.MQConf{Exchange: "sda", RoutingKey: "inbox"}
+}
+
+func (ts *SFTPInboxTestSuite) TearDownSuite() {
+	ts.cega.Close()
+	ts.app.DB.Close()
+}
+
+func (ts *SFTPInboxTestSuite) SetupTest() {
+	ts.bus = broker.NewMemoryBus(1)
+	ts.bus.Bind("sda", "inbox", "inbox")
+	ts.app.MQ = ts.bus
+	ts.inbox = memory.NewStorage("memory://inbox")
+	ts.app.Writer = ts.inbox
+	ts.audit = &capturingLogger{}
+	ts.app.Audit = ts.audit
+
+	var err error
+	ts.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	ts.Require().NoError(err)
+	go func() {
+		_ = ts.app.Serve(ts.listener, ts.hostKey)
+	}()
+}
+
+func (ts *SFTPInboxTestSuite) TearDownTest() {
+	_ = ts.listener.Close()
+	_ = ts.bus.Close()
+}
+
+// connect logs in to the sftp inbox as the user
+func (ts *SFTPInboxTestSuite) connect(username string, auth ssh.AuthMethod) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", ts.listener.Addr().String(), &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.FixedHostKey(ts.hostKey.PublicKey()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (ts *SFTPInboxTestSuite) TestAuthentication() {
+	client, err := ts.connect("dummy@example.org", ssh.PublicKeys(ts.userKey))
+	ts.NoError(err)
+	ts.NoError(client.Close())
+
+	client, err = ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.NoError(err)
+	ts.NoError(client.Close())
+
+	_, err = ts.connect("dummy@example.org", ssh.Password("wrong"))
+	ts.ErrorContains(err, "unable to authenticate")
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	ts.Require().NoError(err)
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	ts.Require().NoError(err)
+	_, err = ts.connect("dummy@example.org", ssh.PublicKeys(otherSigner))
+	ts.ErrorContains(err, "unable to authenticate")
+
+	_, err = ts.connect("unknown@example.org", ssh.Password("password"))
+	ts.ErrorContains(err, "unable to authenticate")
+}
+
+func (ts *SFTPInboxTestSuite) TestUpload() {
+	client, err := ts.connect("dummy@example.org", ssh.PublicKeys(ts.userKey))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	data := make([]byte, 5*1024*1024)
+	_, err = rand.Read(data)
+	ts.Require().NoError(err)
+
+	ts.NoError(client.MkdirAll("/dir"))
+	f, err := client.Create("/dir/file.c4gh")
+	ts.Require().NoError(err)
+	// the client keeps several writes in flight, which may arrive out of order
+	_, err = f.ReadFrom(bytes.NewReader(data))
+	ts.NoError(err)
+	ts.Require().NoError(f.Close())
+
+	reader, err := ts.inbox.NewFileReader(context.TODO(), "memory://inbox", "dummy_example.org/dir/file.c4gh")
+	ts.Require().NoError(err)
+	stored, err := io.ReadAll(reader)
+	ts.NoError(err)
+	ts.True(bytes.Equal(data, stored), "stored file differs from the uploaded file")
+
+	fileID, err := ts.app.DB.GetFileIDByUserPathAndStatus("dummy@example.org", "dir/file.c4gh", "uploaded")
+	ts.Require().NoError(err)
+	sum := sha256.Sum256(data)
+	checksum, err := ts.app.DB.GetUploadedChecksum(context.TODO(), fileID)
+	ts.NoError(err)
+	ts.Equal(hex.EncodeToString(sum[:]), checksum)
+
+	messages := ts.bus.Messages("inbox")
+	ts.Require().Len(messages, 1)
+	ts.Equal(fileID, messages[0].CorrelationID)
+	ts.NoError(schema.ValidateJSON("../../schemas/isolated/inbox-upload.json", messages[0].Body))
+	var message schema.InboxUpload
+	ts.NoError(json.Unmarshal(messages[0].Body, &message))
+	ts.Equal("dummy@example.org", message.User)
+	ts.Equal("dummy_example.org/dir/file.c4gh", message.FilePath)
+	ts.Equal(int64(len(data)), message.FileSize)
+
+	events := ts.audit.Events()
+	ts.Require().Len(events, 1)
+	ts.Equal(audit.EventUploadCompleted, events[0].Event)
+	ts.Equal("dummy@example.org", events[0].UserID)
+	ts.Equal(fileID, events[0].FileID)
+	ts.Equal("dir/file.c4gh", events[0].Target)
+	ts.Equal(int64(len(data)), events[0].BytesTransferred)
+
+	entries, err := client.ReadDir("/dir")
+	ts.NoError(err)
+	ts.Require().Len(entries, 1)
+	ts.Equal("file.c4gh", entries[0].Name())
+	ts.Equal(int64(len(data)), entries[0].Size())
+
+	info, err := client.Stat("/dir")
+	ts.NoError(err)
+	ts.True(info.IsDir())
+
+	info, err = client.Stat("/dir/file.c4gh")
+	ts.NoError(err)
+	ts.Equal(int64(len(data)), info.Size())
+
+	_, err = client.Stat("/dir/missing.c4gh")
+	ts.ErrorIs(err, os.ErrNotExist)
+}
+
+// upload writes the data to the file in the inbox over sftp
+func (ts *SFTPInboxTestSuite) upload(client *sftp.Client, filePath string, data []byte) error {
+	f, err := client.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func (ts *SFTPInboxTestSuite) TestReupload() {
+	client, err := ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	ts.Require().NoError(ts.upload(client, "/reupload.c4gh", []byte("first")))
+	fileID, err := ts.app.DB.GetFileIDByUserPathAndStatus("dummy@example.org", "reupload.c4gh", "uploaded")
+	ts.Require().NoError(err)
+
+	ts.Require().NoError(ts.upload(client, "/reupload.c4gh", []byte("second")))
+	reuploadedID, err := ts.app.DB.GetFileIDByUserPathAndStatus("dummy@example.org", "reupload.c4gh", "uploaded")
+	ts.Require().NoError(err)
+	ts.Equal(fileID, reuploadedID)
+
+	messages := ts.bus.Messages("inbox")
+	ts.Require().Len(messages, 3)
+	ts.Equal(fileID, messages[2].CorrelationID)
+	ts.NoError(schema.ValidateJSON("../../schemas/isolated/inbox-remove.json", messages[2].Body))
+	var message schema.InboxRemove
+	ts.NoError(json.Unmarshal(messages[2].Body, &message))
+	ts.Equal("dummy_example.org/reupload.c4gh", message.FilePath)
+}
+
+func (ts *SFTPInboxTestSuite) TestUploadOfFileBeingIngested() {
+	client, err := ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	ts.Require().NoError(ts.upload(client, "/ingesting.c4gh", []byte("data")))
+	fileID, err := ts.app.DB.GetFileIDByUserPathAndStatus("dummy@example.org", "ingesting.c4gh", "uploaded")
+	ts.Require().NoError(err)
+	ts.Require().NoError(ts.app.DB.UpdateFileEventLog(fileID, "submitted", "inbox", "{}", "{}"))
+
+	ts.Error(ts.upload(client, "/ingesting.c4gh", []byte("replaced")))
+	ts.Len(ts.bus.Messages("inbox"), 1)
+	events := ts.audit.Events()
+	ts.Require().Len(events, 2)
+	ts.Equal(audit.EventUploadDenied, events[1].Event)
+
+	reader, err := ts.inbox.NewFileReader(context.TODO(), "memory://inbox", "dummy_example.org/ingesting.c4gh")
+	ts.Require().NoError(err)
+	stored, err := io.ReadAll(reader)
+	ts.NoError(err)
+	ts.Equal("data", string(stored))
+}
+
+func (ts *SFTPInboxTestSuite) TestUploadOverQuota() {
+	client, err := ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	// the files uploaded by the other tests count towards the quota
+	usage, err := ts.app.DB.GetInboxUsage(context.TODO(), "user", "dummy@example.org", "")
+	ts.Require().NoError(err)
+	maxBytes := usage.Bytes + 10
+	ts.Require().NoError(ts.app.DB.SetInboxQuota(context.TODO(), database.InboxQuota{SubjectType: "user", Subject: "dummy@example.org", MaxBytes: &maxBytes}))
+	defer func() { _ = ts.app.DB.DeleteInboxQuota(context.TODO(), "user", "dummy@example.org") }()
+
+	// the size of an upload is checked once it has been written, before it is committed to the storage
+	ts.ErrorContains(ts.upload(client, "/quota.c4gh", []byte("more than ten bytes")), "the inbox quota of")
+	ts.Empty(ts.inbox.Files())
+	ts.Empty(ts.bus.Messages("inbox"))
+	_, err = ts.app.DB.GetFileIDByUserPathAndStatus("dummy@example.org", "quota.c4gh", "uploaded")
+	ts.Error(err)
+
+	ts.NoError(ts.upload(client, "/quota.c4gh", []byte("ten bytes")))
+
+	// a full object quota refuses the upload as the file is opened
+	maxObjects := usage.Objects + 1
+	ts.Require().NoError(ts.app.DB.SetInboxQuota(context.TODO(), database.InboxQuota{SubjectType: "user", Subject: "dummy@example.org", MaxObjects: &maxObjects}))
+	_, err = client.Create("/objects.c4gh")
+	ts.ErrorContains(err, "the inbox quota of")
+	ts.Len(ts.inbox.Files(), 1)
+
+	events := ts.audit.Events()
+	ts.Require().Len(events, 3)
+	ts.Equal(audit.EventUploadDenied, events[0].Event)
+	ts.Contains(events[0].ErrorReason, "bytes for user dummy@example.org would be exceeded")
+	ts.Equal(audit.EventUploadCompleted, events[1].Event)
+	ts.Equal(audit.EventUploadDenied, events[2].Event)
+	ts.Contains(events[2].ErrorReason, "objects for user dummy@example.org would be exceeded")
+}
+
+func (ts *SFTPInboxTestSuite) TestUploadWithInvalidHeader() {
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+	ts.app.ValidateHeader = true
+	ts.app.C4ghPrivateKeyList = []*[32]byte{&archivePrivateKey}
+	defer func() {
+		ts.app.ValidateHeader = false
+		ts.app.C4ghPrivateKeyList = nil
+	}()
+
+	client, err := ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	// the header is checked on the start of the file, which is held back from the storage until it has been found
+	data := make([]byte, 1024*1024)
+	_, err = rand.Read(data)
+	ts.Require().NoError(err)
+	f, err := client.Create("/unencrypted.c4gh")
+	ts.Require().NoError(err)
+	_, err = f.Write(data)
+	ts.ErrorContains(err, "not encrypted with crypt4gh")
+	_ = f.Close()
+
+	ts.ErrorContains(ts.upload(client, "/short.c4gh", []byte("plain")), "not encrypted with crypt4gh")
+
+	otherPublicKey, _, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+	ts.ErrorContains(ts.upload(client, "/other.c4gh", ts.encrypt(data, otherPublicKey)), "not encrypted with the public key of the archive")
+
+	ts.Empty(ts.inbox.Files())
+	ts.Empty(ts.bus.Messages("inbox"))
+
+	encrypted := ts.encrypt(data, archivePublicKey)
+	ts.Require().NoError(ts.upload(client, "/encrypted.c4gh", encrypted))
+	reader, err := ts.inbox.NewFileReader(context.TODO(), "memory://inbox", "dummy_example.org/encrypted.c4gh")
+	ts.Require().NoError(err)
+	stored, err := io.ReadAll(reader)
+	ts.NoError(err)
+	ts.True(bytes.Equal(encrypted, stored), "stored file differs from the uploaded file")
+
+	events := ts.audit.Events()
+	ts.Require().Len(events, 4)
+	for _, event := range events[:3] {
+		ts.Equal(audit.EventUploadDenied, event.Event)
+	}
+	ts.Equal("unencrypted.c4gh", events[0].Target)
+	ts.Equal(audit.EventUploadCompleted, events[3].Event)
+}
+
+// encrypt encrypts the data with crypt4gh for the public key
+func (ts *SFTPInboxTestSuite) encrypt(data []byte, publicKey [32]byte) []byte {
+	_, privateKey, err := keys.GenerateKeyPair()
+	ts.Require().NoError(err)
+	var encrypted bytes.Buffer
+	writer, err := streaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{publicKey}, nil)
+	ts.Require().NoError(err)
+	_, err = writer.Write(data)
+	ts.Require().NoError(err)
+	ts.Require().NoError(writer.Close())
+
+	return encrypted.Bytes()
+}
+
+func (ts *SFTPInboxTestSuite) TestRefusedOperations() {
+	client, err := ts.connect("dummy@example.org", ssh.Password("password"))
+	ts.Require().NoError(err)
+	defer client.Close()
+
+	f, err := client.Create("/refused.c4gh")
+	ts.Require().NoError(err)
+	_, err = f.Write([]byte("data"))
+	ts.NoError(err)
+	ts.NoError(f.Close())
+
+	_, err = client.Open("/refused.c4gh")
+	ts.Error(err)
+	ts.Error(client.Remove("/refused.c4gh"))
+	ts.Error(client.Rename("/refused.c4gh", "/renamed.c4gh"))
+	ts.Len(ts.inbox.Files(), 1)
+}
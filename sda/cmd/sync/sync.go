@@ -24,7 +24,6 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
-	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -33,7 +32,8 @@ var (
 	key           *[32]byte
 	db            *database.SDAdb
 	conf          *config.Config
-	mqBroker      *broker.AMQPBroker
+	mqBroker      broker.Bus
+	brokerConf    broker.MQConf
 	archiveReader storage.Reader
 	syncWriter    storage.Writer
 )
@@ -61,23 +61,14 @@ func run() error {
 		return errors.New("database schema v23 is required")
 	}
 
-	mqBroker, err = broker.NewMQ(conf.Broker)
+	brokerConf = conf.Broker
+	mqBroker, err = broker.NewBus(conf.Broker)
 	if err != nil {
 		return fmt.Errorf("failed to initialize mq broker, due to: %v", err)
 	}
 	defer func() {
-		if mqBroker == nil {
-			return
-		}
-		if mqBroker.Channel != nil {
-			if err := mqBroker.Channel.Close(); err != nil {
-				log.Errorf("failed to close mq broker channel due to: %v", err)
-			}
-		}
-		if mqBroker.Connection != nil {
-			if err := mqBroker.Connection.Close(); err != nil {
-				log.Errorf("failed to close mq broker connection due to: %v", err)
-			}
+		if err := mqBroker.Close(); err != nil {
+			log.Error(err)
 		}
 	}()
 
@@ -93,6 +84,7 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize archive reader, due to: %v", err)
 	}
+	archiveReader = storage.NewChecksumVerifyingReader("archive", archiveReader, db)
 
 	key, err = config.GetC4GHKey()
 	if err != nil {
@@ -111,9 +103,7 @@ func run() error {
 
 	select {
 	case <-sigc:
-	case err := <-mqBroker.Connection.NotifyClose(make(chan *amqp.Error)):
-		return err
-	case err := <-mqBroker.Channel.NotifyClose(make(chan *amqp.Error)):
+	case err := <-mqBroker.NotifyClose():
 		return err
 	case err := <-consumeErr:
 		return err
@@ -122,7 +112,7 @@ func run() error {
 	return nil
 }
 func startConsumer(ctx context.Context) error {
-	messages, err := mqBroker.GetMessages(mqBroker.Conf.Queue)
+	messages, err := mqBroker.Consume(brokerConf.Queue)
 	if err != nil {
 		return err
 	}
@@ -133,16 +123,16 @@ func startConsumer(ctx context.Context) error {
 	return nil
 }
 
-func handleMessage(ctx context.Context, delivered amqp.Delivery) {
+func handleMessage(ctx context.Context, delivered broker.Delivery) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	log.Debugf("Received a message (correlation-id: %s, message: %s)",
-		delivered.CorrelationId,
+		delivered.CorrelationID,
 		delivered.Body)
 
-	err := schema.ValidateJSON(fmt.Sprintf("%s/dataset-mapping.json", mqBroker.Conf.SchemasPath), delivered.Body)
+	err := schema.ValidateJSON(fmt.Sprintf("%s/dataset-mapping.json", brokerConf.SchemasPath), delivered.Body)
 	if err != nil {
-		log.Errorf("validation of incoming message (dataset-mapping) failed, correlation-id: %s, reason: (%s)", delivered.CorrelationId, err.Error())
+		log.Errorf("validation of incoming message (dataset-mapping) failed, correlation-id: %s, reason: (%s)", delivered.CorrelationID, err.Error())
 		// Send the message to an error queue so it can be analyzed.
 		infoErrorMessage := broker.InfoError{
 			Error:           "Message validation failed in sync service",
@@ -151,10 +141,10 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 		}
 
 		body, _ := json.Marshal(infoErrorMessage)
-		if err := mqBroker.SendMessage(delivered.CorrelationId, mqBroker.Conf.Exchange, "error", body); err != nil {
+		if err := mqBroker.SendMessage(delivered.CorrelationID, brokerConf.Exchange, "error", body); err != nil {
 			log.Errorf("failed to publish message, reason: (%v)", err)
 		}
-		if err := delivered.Ack(false); err != nil {
+		if err := delivered.Ack(); err != nil {
 			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
 		}
 
@@ -167,7 +157,7 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 
 	if !strings.HasPrefix(message.DatasetID, conf.Sync.CenterPrefix) {
 		log.Infoln("external dataset")
-		if err := delivered.Ack(false); err != nil {
+		if err := delivered.Ack(); err != nil {
 			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
 		}
 
@@ -184,7 +174,7 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 		}
 	}
 	if syncFilesErr != nil {
-		if err := delivered.Nack(false, false); err != nil {
+		if err := delivered.Nack(false); err != nil {
 			log.Errorf("failed to nack following GetFileSize error message")
 		}
 
@@ -198,14 +188,14 @@ func handleMessage(ctx context.Context, delivered amqp.Delivery) {
 	}
 	if err := sendPOST(blob); err != nil {
 		log.Errorf("failed to send POST, Reason: %v", err)
-		if err := delivered.Nack(false, false); err != nil {
+		if err := delivered.Nack(false); err != nil {
 			log.Errorf("failed to nack following sendPOST error message")
 		}
 
 		return
 	}
 
-	if err := delivered.Ack(false); err != nil {
+	if err := delivered.Ack(); err != nil {
 		log.Errorf("failed to Ack message, reason: (%s)", err.Error())
 	}
 }
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Publisher publishes messages to a message broker, as implemented by
+// broker.AMQPBroker.
+type Publisher interface {
+	SendMessage(corrID, exchange, routingKey string, body []byte) error
+	IsConnClosed() bool
+	Close() error
+}
+
+// AMQPSink publishes audit records to an exchange of the message broker.
+type AMQPSink struct {
+	publisher  Publisher
+	exchange   string
+	routingKey string
+}
+
+// NewAMQPSink creates an AMQPSink publishing to exchange with routingKey.
+func NewAMQPSink(publisher Publisher, exchange, routingKey string) *AMQPSink {
+	return &AMQPSink{
+		publisher:  publisher,
+		exchange:   exchange,
+		routingKey: routingKey,
+	}
+}
+
+// Write publishes the record and waits for the broker to confirm it. The
+// correlation ID of the event is used as the message correlation ID.
+func (s *AMQPSink) Write(record []byte) error {
+	var event struct {
+		CorrelationID string `json:"correlationId"`
+	}
+	_ = json.Unmarshal(record, &event)
+
+	return s.publisher.SendMessage(event.CorrelationID, s.exchange, s.routingKey, record)
+}
+
+// Durable reports that records are persisted by the broker.
+func (s *AMQPSink) Durable() bool {
+	return true
+}
+
+// Healthy returns an error if the broker connection is closed.
+func (s *AMQPSink) Healthy() error {
+	if s.publisher.IsConnClosed() {
+		return errors.New("connection to the message broker is closed")
+	}
+
+	return nil
+}
+
+func (s *AMQPSink) Close() error {
+	return s.publisher.Close()
+}
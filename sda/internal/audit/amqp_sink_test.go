@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPublisher struct {
+	corrID, exchange, routingKey string
+	body                         []byte
+	err                          error
+	connClosed                   bool
+}
+
+func (p *mockPublisher) SendMessage(corrID, exchange, routingKey string, body []byte) error {
+	p.corrID, p.exchange, p.routingKey, p.body = corrID, exchange, routingKey, body
+
+	return p.err
+}
+
+func (p *mockPublisher) IsConnClosed() bool { return p.connClosed }
+
+func (p *mockPublisher) Close() error { return nil }
+
+func TestAMQPSink_Publishes(t *testing.T) {
+	publisher := &mockPublisher{}
+	sink := NewAMQPSink(publisher, "sda.audit", "download.audit")
+
+	record := []byte(`{"event":"download.completed","correlationId":"corr-1"}`)
+	require.NoError(t, sink.Write(record))
+	assert.Equal(t, "corr-1", publisher.corrID)
+	assert.Equal(t, "sda.audit", publisher.exchange)
+	assert.Equal(t, "download.audit", publisher.routingKey)
+	assert.Equal(t, record, publisher.body)
+	assert.True(t, sink.Durable())
+
+	publisher.err = errors.New("nacked")
+	assert.Error(t, sink.Write(record))
+}
+
+func TestAMQPSink_Healthy(t *testing.T) {
+	publisher := &mockPublisher{}
+	sink := NewAMQPSink(publisher, "sda.audit", "download.audit")
+	require.NoError(t, sink.Healthy())
+
+	publisher.connClosed = true
+	assert.Error(t, sink.Healthy())
+}
@@ -0,0 +1,110 @@
+// Package audit provides audit logging for the SDA services.
+// It defines the Logger interface and event types used to record
+// downloads, uploads and administrative operations for compliance
+// and monitoring.
+package audit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EventName is a typed string for audit event names.
+type EventName string
+
+const (
+	EventCompleted EventName = "download.completed"
+	EventDenied    EventName = "download.denied"
+	EventFailed    EventName = "download.failed"
+	EventContent   EventName = "download.content"
+	EventHeader    EventName = "download.header"
+	EventHtsget    EventName = "download.htsget"
+	EventDecrypted EventName = "download.decrypted"
+	EventArchive   EventName = "download.archive"
+	EventSignedURL EventName = "download.signed_url"
+
+	// Events of the admin API, the actor is the administrator and the
+	// target the file, dataset, user, group or key hash operated on.
+	EventAdminList           EventName = "admin.list"
+	EventAdminFileIngest     EventName = "admin.file.ingest"
+	EventAdminFileAccession  EventName = "admin.file.accession"
+	EventAdminFileVerify     EventName = "admin.file.verify"
+	EventAdminFileRotateKey  EventName = "admin.file.rotate_key"
+	EventAdminFileDelete     EventName = "admin.file.delete"
+	EventAdminFileDownload   EventName = "admin.file.download"
+	EventAdminDatasetCreate  EventName = "admin.dataset.create"
+	EventAdminDatasetRelease EventName = "admin.dataset.release"
+	EventAdminDatasetVerify  EventName = "admin.dataset.verify"
+	EventAdminDatasetRotate  EventName = "admin.dataset.rotate_key"
+	EventAdminKeyAdd         EventName = "admin.c4gh_key.add"
+	EventAdminKeyDeprecate   EventName = "admin.c4gh_key.deprecate"
+	EventAdminMessagesReplay EventName = "admin.messages.replay"
+	EventAdminQuotaSet       EventName = "admin.quota.set"
+	EventAdminQuotaDelete    EventName = "admin.quota.delete"
+
+	// Events of the s3inbox, the actor is the uploading user.
+	EventUploadDenied    EventName = "upload.denied"
+	EventUploadCompleted EventName = "upload.completed"
+	EventUploadFailed    EventName = "upload.failed"
+	EventUploadAborted   EventName = "upload.aborted"
+	EventUploadCopied    EventName = "upload.copied"
+	EventUploadRenamed   EventName = "upload.renamed"
+	EventUploadRemoved   EventName = "upload.removed"
+)
+
+// Outcomes of audited operations.
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// Event represents an audit event for download operations.
+type Event struct {
+	Type             string    `json:"type"` // routing tag: always "audit"
+	Event            EventName `json:"event"`
+	Timestamp        time.Time `json:"timestamp"`
+	UserID           string    `json:"userId"` // the actor
+	FileID           string    `json:"fileId,omitempty"`
+	DatasetID        string    `json:"datasetId,omitempty"`
+	TargetUser       string    `json:"targetUser,omitempty"` // owner of the file or inbox an administrator acts on
+	Target           string    `json:"target,omitempty"`     // other target, such as an inbox path or a key hash
+	Outcome          string    `json:"outcome,omitempty"`
+	CorrelationID    string    `json:"correlationId"`
+	Path             string    `json:"path"`
+	HTTPStatus       int       `json:"httpStatus"`
+	BytesTransferred int64     `json:"bytesTransferred,omitempty"`
+	AuthType         string    `json:"authType,omitempty"`
+	ClientID         string    `json:"clientId,omitempty"` // common name of the mTLS client certificate
+	ErrorReason      string    `json:"errorReason,omitempty"`
+	PrevHash         string    `json:"prevHash,omitempty"` // SHA-256 of the previous record, set by SinkLogger
+}
+
+// Logger defines the interface for audit logging.
+type Logger interface {
+	Log(ctx context.Context, event Event)
+}
+
+// NoopLogger discards all audit events. Used when audit logging is not required.
+type NoopLogger struct{}
+
+func (NoopLogger) Log(context.Context, Event) {}
+
+// outcome returns the outcome of the event, derived from the event name and
+// HTTP status unless set by the caller.
+func (e Event) outcome() string {
+	switch {
+	case e.Outcome != "":
+		return e.Outcome
+	case strings.HasSuffix(string(e.Event), ".denied"),
+		e.HTTPStatus == http.StatusUnauthorized,
+		e.HTTPStatus == http.StatusForbidden:
+		return OutcomeDenied
+	case strings.HasSuffix(string(e.Event), ".failed"), e.HTTPStatus >= http.StatusBadRequest:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
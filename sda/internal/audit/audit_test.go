@@ -197,3 +197,20 @@ func TestAuditContract_RequiredFieldsPopulated(t *testing.T) {
 	assert.NotEmpty(t, decoded.Path, "Endpoint must be populated")
 	assert.NotZero(t, decoded.HTTPStatus, "HTTPStatus must be populated")
 }
+
+func TestEventOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		event   Event
+		outcome string
+	}{
+		{Event{Event: EventCompleted, HTTPStatus: 200}, OutcomeSuccess},
+		{Event{Event: EventCompleted, HTTPStatus: 206}, OutcomeSuccess},
+		{Event{Event: EventDenied, HTTPStatus: 404}, OutcomeDenied},
+		{Event{Event: EventAdminFileDelete, HTTPStatus: 401}, OutcomeDenied},
+		{Event{Event: EventFailed, HTTPStatus: 200}, OutcomeFailure},
+		{Event{Event: EventAdminDatasetRelease, HTTPStatus: 404}, OutcomeFailure},
+		{Event{Event: EventUploadCompleted, HTTPStatus: 500, Outcome: OutcomeSuccess}, OutcomeSuccess},
+	} {
+		assert.Equal(t, tc.outcome, tc.event.outcome(), "%s %d", tc.event.Event, tc.event.HTTPStatus)
+	}
+}
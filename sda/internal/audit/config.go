@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+)
+
+// Config selects and configures the sinks audit events are written to.
+type Config struct {
+	// Required fails NewLogger unless a durable sink is healthy
+	Required bool
+	// Sinks are the names of the sinks to write to: stdout, file, syslog and/or amqp
+	Sinks  []string
+	File   FileConfig
+	Syslog SyslogConfig
+	// AMQP is the broker connection, events are published to its Exchange with its RoutingKey
+	AMQP broker.MQConf
+}
+
+// FileConfig configures the file sink.
+type FileConfig struct {
+	Path string
+	// MaxSize in bytes at which the file is rotated, zero disables rotation
+	MaxSize    int64
+	MaxBackups int
+}
+
+// SyslogConfig configures the syslog sink.
+type SyslogConfig struct {
+	Network string
+	Address string
+	AppName string
+}
+
+// Validate checks that the configured sinks are known and complete, and that
+// a durable sink is configured when audit logging is required. Stdout is not
+// durable, events are lost with the container log.
+func (cfg Config) Validate() error {
+	durable := false
+	for _, sink := range cfg.Sinks {
+		switch sink {
+		case "stdout":
+		case "file":
+			if cfg.File.Path == "" {
+				return errors.New("audit.file.path is required when audit.sinks includes file")
+			}
+			durable = true
+		case "syslog":
+			switch cfg.Syslog.Network {
+			case "udp", "tcp", "unix", "unixgram":
+			default:
+				return fmt.Errorf("invalid audit.syslog.network %q: must be udp, tcp, unix or unixgram", cfg.Syslog.Network)
+			}
+			durable = true
+		case "amqp":
+			if cfg.AMQP.Host == "" {
+				return errors.New("audit.amqp.host is required when audit.sinks includes amqp")
+			}
+			durable = true
+		default:
+			return fmt.Errorf("invalid audit sink %q: must be stdout, file, syslog or amqp", sink)
+		}
+	}
+
+	if cfg.Required && !durable {
+		return errors.New("audit.required requires a durable audit sink, audit.sinks must include file, syslog or amqp")
+	}
+
+	return nil
+}
+
+// NewLogger validates the configuration, creates the configured sinks and a
+// logger writing to them. The hash chain continues from the last record of
+// the audit log file, if one is configured.
+func NewLogger(cfg Config) (*SinkLogger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var sinks []Sink
+	closeSinks := func() {
+		for _, sink := range sinks {
+			_ = sink.Close()
+		}
+	}
+
+	prevHash := ""
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+		case "file":
+			fileSink, err := NewFileSink(cfg.File.Path, cfg.File.MaxSize, cfg.File.MaxBackups)
+			if err != nil {
+				closeSinks()
+
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+
+			prevHash, err = fileSink.LastRecordHash()
+			if err != nil {
+				closeSinks()
+
+				return nil, fmt.Errorf("failed to read the last audit record: %w", err)
+			}
+		case "syslog":
+			syslogSink, err := NewSyslogSink(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.AppName)
+			if err != nil {
+				closeSinks()
+
+				return nil, err
+			}
+			sinks = append(sinks, syslogSink)
+		case "amqp":
+			mq, err := broker.NewMQ(cfg.AMQP)
+			if err != nil {
+				closeSinks()
+
+				return nil, fmt.Errorf("failed to connect to the audit message broker: %w", err)
+			}
+			sinks = append(sinks, NewAMQPSink(mq, cfg.AMQP.Exchange, cfg.AMQP.RoutingKey))
+		}
+	}
+
+	logger := NewSinkLogger(prevHash, sinks...)
+	if cfg.Required {
+		if err := logger.Healthy(); err != nil {
+			_ = logger.Close()
+
+			return nil, fmt.Errorf("audit.required is set but no audit sink can receive events: %w", err)
+		}
+	}
+
+	return logger, nil
+}
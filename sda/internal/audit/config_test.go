@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate_Valid(t *testing.T) {
+	require.NoError(t, Config{}.Validate())
+
+	cfg := Config{
+		Required: true,
+		Sinks:    []string{"stdout", "file", "syslog", "amqp"},
+		File:     FileConfig{Path: "/var/log/sda/audit.jsonl"},
+		Syslog:   SyslogConfig{Network: "udp"},
+		AMQP:     broker.MQConf{Host: "mq"},
+	}
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConfigValidate_UnknownSink(t *testing.T) {
+	err := Config{Sinks: []string{"kafka"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid audit sink")
+}
+
+func TestConfigValidate_RequiredWithoutDurableSink(t *testing.T) {
+	err := Config{Required: true}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "audit.required")
+
+	err = Config{Required: true, Sinks: []string{"stdout"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "durable")
+}
+
+func TestConfigValidate_IncompleteSinks(t *testing.T) {
+	err := Config{Sinks: []string{"file"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "audit.file.path")
+
+	err = Config{Sinks: []string{"syslog"}, Syslog: SyslogConfig{Network: "http"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "audit.syslog.network")
+
+	err = Config{Sinks: []string{"amqp"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "audit.amqp.host")
+}
+
+func TestNewLogger_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := Config{Required: true, Sinks: []string{"file"}, File: FileConfig{Path: path}}
+
+	logger, err := NewLogger(cfg)
+	require.NoError(t, err)
+	logger.Log(context.Background(), Event{Event: EventAdminFileDelete, UserID: "admin", FileID: "file-1", HTTPStatus: 200})
+	require.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var event Event
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, EventAdminFileDelete, event.Event)
+	assert.Equal(t, OutcomeSuccess, event.Outcome)
+}
+
+func TestNewLogger_RequiredSinkUnavailable(t *testing.T) {
+	cfg := Config{Required: true, Sinks: []string{"file"}, File: FileConfig{Path: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}}
+
+	_, err := NewLogger(cfg)
+	assert.Error(t, err)
+}
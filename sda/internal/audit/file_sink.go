@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink appends audit records as JSON lines to a local file, and rotates
+// the file once it grows beyond maxSize bytes. Rotated files are renamed
+// path.1, path.2 and so on, path.1 being the most recent.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	err  error // last write error, reported by Healthy
+}
+
+// NewFileSink opens, or creates, the audit log at path. A maxSize of zero
+// disables rotation, a maxBackups of zero keeps no rotated files.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+// Write appends the record and syncs the file, so that acknowledged records
+// survive a crash.
+func (s *FileSink) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("audit log is closed")
+	}
+
+	line := append(record[:len(record):len(record)], '\n')
+	var rotateErr error
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		// Keep writing to the current file if rotation fails rather than losing the record
+		if rotateErr = s.rotate(); rotateErr != nil && s.file == nil {
+			if err := s.open(); err != nil {
+				s.err = errors.Join(rotateErr, err)
+
+				return s.err
+			}
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	s.err = errors.Join(rotateErr, err)
+
+	return s.err
+}
+
+// rotate shifts the backups one step, moves the current file to path.1 and
+// opens a new file.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log for rotation: %w", err)
+	}
+	s.file = nil
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("failed to remove rotated audit log: %w", err)
+		}
+
+		return s.open()
+	}
+
+	if err := os.Remove(s.backupName(s.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove oldest audit log: %w", err)
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(s.backupName(i), s.backupName(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(s.path, s.backupName(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+// LastRecordHash returns the hash of the last record in the audit log, used to
+// continue the hash chain after a restart. An empty log, including one just
+// rotated, gives the hash of the last record of the most recent backup.
+func (s *FileSink) LastRecordHash() (string, error) {
+	for _, name := range []string{s.path, s.backupName(1)} {
+		record, err := lastLine(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if record != nil {
+			return hashRecord(record), nil
+		}
+	}
+
+	return "", nil
+}
+
+// lastLine returns the last non-empty line of the file at name.
+func lastLine(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards in chunks until a complete line is found
+	const chunkSize = 64 * 1024
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		n := min(int64(chunkSize), offset)
+		offset -= n
+		chunk := make([]byte, n)
+		if _, err := file.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if offset == 0 && len(trimmed) > 0 {
+			return trimmed, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Durable reports that records written to the file survive restarts.
+func (s *FileSink) Durable() bool {
+	return true
+}
+
+// Healthy returns the error of the last failed write, if any.
+func (s *FileSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("audit log is closed")
+	}
+
+	return s.err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queueSize is the number of events buffered before Log blocks.
+const queueSize = 1024
+
+// maxRecordSize is the largest record VerifyChain accepts.
+const maxRecordSize = 1 << 20
+
+// ErrNoHealthySink is returned by SinkLogger.Healthy when no durable sink can receive events.
+var ErrNoHealthySink = errors.New("no durable audit sink is healthy")
+
+// Sink receives serialized audit records, one JSON document per record.
+type Sink interface {
+	// Write delivers a single record. It is called from one goroutine only.
+	Write(record []byte) error
+	// Durable reports whether records written to the sink survive a restart of the service.
+	Durable() bool
+	// Healthy returns an error if the sink can currently not receive records.
+	Healthy() error
+	Close() error
+}
+
+// SinkLogger writes audit events to one or more sinks. Each record carries the
+// SHA-256 of the previous record in prevHash, so removing or altering a
+// record breaks the chain and is detected by VerifyChain.
+type SinkLogger struct {
+	sinks []Sink
+	queue chan Event
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewSinkLogger creates a SinkLogger writing to sinks. prevHash seeds the hash
+// chain, normally the hash of the last record of an existing log, see
+// FileSink.LastRecordHash.
+func NewSinkLogger(prevHash string, sinks ...Sink) *SinkLogger {
+	l := &SinkLogger{
+		sinks: sinks,
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+	}
+	go l.run(prevHash)
+
+	return l
+}
+
+// Log queues the event for delivery. It blocks when the queue is full rather
+// than dropping events.
+func (l *SinkLogger) Log(_ context.Context, event Event) {
+	// Enforce Type is always "audit"
+	event.Type = "audit"
+	// Always set timestamp at log time to prevent caller manipulation
+	event.Timestamp = time.Now().UTC()
+	event.Outcome = event.outcome()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		log.Errorf("audit event %s (correlation-id: %s) logged after the audit logger was closed", event.Event, event.CorrelationID)
+
+		return
+	}
+	l.queue <- event
+}
+
+// run chains and writes the queued events until the queue is closed.
+func (l *SinkLogger) run(prevHash string) {
+	defer close(l.done)
+
+	for event := range l.queue {
+		event.PrevHash = prevHash
+		record, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("failed to marshal audit event %s (correlation-id: %s): %v", event.Event, event.CorrelationID, err)
+
+			continue
+		}
+		prevHash = hashRecord(record)
+
+		for _, sink := range l.sinks {
+			if err := sink.Write(record); err != nil {
+				log.Errorf("failed to write audit event %s (correlation-id: %s) to %T: %v", event.Event, event.CorrelationID, sink, err)
+			}
+		}
+	}
+}
+
+// Healthy returns nil if at least one durable sink is healthy.
+func (l *SinkLogger) Healthy() error {
+	var errs []error
+	for _, sink := range l.sinks {
+		if !sink.Durable() {
+			continue
+		}
+		err := sink.Healthy()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+	}
+
+	return errors.Join(append([]error{ErrNoHealthySink}, errs...)...)
+}
+
+// Close writes the queued events and closes the sinks.
+func (l *SinkLogger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+
+	var errs []error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// hashRecord returns the hex encoded SHA-256 of a serialized record.
+func hashRecord(record []byte) string {
+	sum := sha256.Sum256(record)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyChain reads JSON lines audit records from r and checks that the
+// prevHash of every record matches the hash of the record before it. The
+// prevHash of the first record can not be checked, to verify a rotated log
+// concatenate the files from the oldest backup to the current file.
+func VerifyChain(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+
+	prevHash := ""
+	line := 0
+	for scanner.Scan() {
+		line++
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(record, &event); err != nil {
+			return fmt.Errorf("line %d: malformed audit record: %w", line, err)
+		}
+		if prevHash != "" && event.PrevHash != prevHash {
+			return fmt.Errorf("line %d: hash chain broken, prevHash %q does not match the previous record", line, event.PrevHash)
+		}
+		prevHash = hashRecord(record)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink keeps written records in memory.
+type memorySink struct {
+	mu       sync.Mutex
+	records  [][]byte
+	durable  bool
+	healthy  error
+	writeErr error
+	closed   bool
+}
+
+func (s *memorySink) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	s.records = append(s.records, append([]byte(nil), record...))
+
+	return nil
+}
+
+func (s *memorySink) Durable() bool { return s.durable }
+
+func (s *memorySink) Healthy() error { return s.healthy }
+
+func (s *memorySink) Close() error {
+	s.closed = true
+
+	return nil
+}
+
+func (s *memorySink) log() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(bytes.Join(s.records, []byte("\n")), '\n')
+}
+
+func TestSinkLogger_ChainsRecords(t *testing.T) {
+	sink := &memorySink{durable: true}
+	logger := NewSinkLogger("", sink)
+
+	for _, id := range []string{"corr-1", "corr-2", "corr-3"} {
+		logger.Log(context.Background(), Event{Type: "other", Event: EventCompleted, CorrelationID: id, PrevHash: "forged"})
+	}
+	require.NoError(t, logger.Close())
+	require.Len(t, sink.records, 3)
+	assert.True(t, sink.closed)
+
+	var first Event
+	require.NoError(t, json.Unmarshal(sink.records[0], &first))
+	assert.Equal(t, "audit", first.Type)
+	assert.Empty(t, first.PrevHash, "first record starts the chain")
+	assert.False(t, first.Timestamp.IsZero())
+
+	for i := 1; i < len(sink.records); i++ {
+		var event Event
+		require.NoError(t, json.Unmarshal(sink.records[i], &event))
+		assert.Equal(t, hashRecord(sink.records[i-1]), event.PrevHash)
+	}
+	assert.NoError(t, VerifyChain(bytes.NewReader(sink.log())))
+}
+
+func TestSinkLogger_SeedsChain(t *testing.T) {
+	sink := &memorySink{durable: true}
+	logger := NewSinkLogger("abc123", sink)
+	logger.Log(context.Background(), Event{Event: EventDenied})
+	require.NoError(t, logger.Close())
+
+	var event Event
+	require.NoError(t, json.Unmarshal(sink.records[0], &event))
+	assert.Equal(t, "abc123", event.PrevHash)
+}
+
+func TestSinkLogger_WritesToAllSinks(t *testing.T) {
+	failing := &memorySink{writeErr: errors.New("disk full")}
+	working := &memorySink{}
+	logger := NewSinkLogger("", failing, working)
+	logger.Log(context.Background(), Event{Event: EventCompleted})
+	logger.Log(context.Background(), Event{Event: EventCompleted})
+	require.NoError(t, logger.Close())
+
+	assert.Len(t, working.records, 2, "a failing sink must not stop delivery to the others")
+}
+
+func TestSinkLogger_LogAfterCloseDoesNotPanic(t *testing.T) {
+	logger := NewSinkLogger("", &memorySink{})
+	require.NoError(t, logger.Close())
+	require.NoError(t, logger.Close())
+	assert.NotPanics(t, func() {
+		logger.Log(context.Background(), Event{Event: EventCompleted})
+	})
+}
+
+func TestSinkLogger_Healthy(t *testing.T) {
+	stdout := &memorySink{}
+	broken := &memorySink{durable: true, healthy: errors.New("connection closed")}
+
+	logger := NewSinkLogger("", stdout)
+	require.ErrorIs(t, logger.Healthy(), ErrNoHealthySink, "non-durable sinks do not count")
+	require.NoError(t, logger.Close())
+
+	logger = NewSinkLogger("", stdout, broken)
+	err := logger.Healthy()
+	require.ErrorIs(t, err, ErrNoHealthySink)
+	assert.Contains(t, err.Error(), "connection closed")
+	require.NoError(t, logger.Close())
+
+	logger = NewSinkLogger("", stdout, broken, &memorySink{durable: true})
+	assert.NoError(t, logger.Healthy())
+	require.NoError(t, logger.Close())
+}
+
+func TestVerifyChain_DetectsTampering(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewSinkLogger("", sink)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		logger.Log(context.Background(), Event{Event: EventCompleted, UserID: user})
+	}
+	require.NoError(t, logger.Close())
+	records := sink.records
+
+	// Altered record
+	altered := strings.Replace(string(sink.log()), "bob", "eve", 1)
+	err := VerifyChain(strings.NewReader(altered))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 3")
+
+	// Removed record
+	removed := string(records[0]) + "\n" + string(records[2]) + "\n"
+	err = VerifyChain(strings.NewReader(removed))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	// Malformed record
+	err = VerifyChain(strings.NewReader("not json\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "malformed")
+}
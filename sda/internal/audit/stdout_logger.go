@@ -33,8 +33,39 @@ func (l *StdoutLogger) Log(_ context.Context, event Event) {
 	event.Type = "audit"
 	// Always set timestamp at log time to prevent caller manipulation
 	event.Timestamp = time.Now().UTC()
+	event.Outcome = event.outcome()
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	_ = l.encoder.Encode(event) // best-effort, don't block HTTP response
 }
+
+// StdoutSink writes audit records as JSON lines to stdout. Records are lost
+// with the container log, so the sink is not durable.
+type StdoutSink struct {
+	w io.Writer
+}
+
+// NewStdoutSink creates a StdoutSink that writes to os.Stdout.
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{w: os.Stdout}
+}
+
+func (s *StdoutSink) Write(record []byte) error {
+	_, err := s.w.Write(append(record[:len(record):len(record)], '\n'))
+
+	return err
+}
+
+// Durable reports that stdout does not keep records across restarts.
+func (s *StdoutSink) Durable() bool {
+	return false
+}
+
+func (s *StdoutSink) Healthy() error {
+	return nil
+}
+
+func (s *StdoutSink) Close() error {
+	return nil
+}
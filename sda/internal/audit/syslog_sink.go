@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// syslogPriority is facility 13 (log audit) and severity 6 (informational)
+	syslogPriority = 13*8 + 6
+	// syslogMsgID is the MSGID field of the RFC5424 header
+	syslogMsgID = "audit"
+	// syslogWriteTimeout bounds how long a write to a stalled syslog server can block
+	syslogWriteTimeout = 5 * time.Second
+)
+
+// syslogSockets are the local syslog sockets tried when no address is configured.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogSink sends audit records to a syslog server as RFC5424 messages.
+type SyslogSink struct {
+	network  string
+	address  string
+	appName  string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+	err  error // last write error, reported by Healthy
+}
+
+// NewSyslogSink connects to the syslog server at address over network (udp,
+// tcp, unix or unixgram). An empty address connects to the local syslog
+// socket.
+func NewSyslogSink(network, address, appName string) (*SyslogSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	if appName == "" {
+		appName = "-"
+	}
+
+	s := &SyslogSink{
+		network:  network,
+		address:  address,
+		appName:  appName,
+		hostname: hostname,
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *SyslogSink) connect() error {
+	if s.address != "" {
+		conn, err := net.DialTimeout(s.network, s.address, syslogWriteTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog at %s://%s: %w", s.network, s.address, err)
+		}
+		s.conn = conn
+
+		return nil
+	}
+
+	for _, socket := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, socket)
+			if err == nil {
+				s.network = network
+				s.conn = conn
+
+				return nil
+			}
+		}
+	}
+
+	return errors.New("failed to connect to the local syslog socket")
+}
+
+// format returns the record as an RFC5424 message, framed for the network.
+func (s *SyslogSink) format(record []byte) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		syslogPriority,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		s.hostname,
+		s.appName,
+		os.Getpid(),
+		syslogMsgID,
+		record,
+	)
+
+	switch s.network {
+	case "tcp", "tcp4", "tcp6":
+		// Octet counting framing, RFC6587
+		return fmt.Appendf(nil, "%d %s", len(msg), msg)
+	case "unix":
+		return []byte(msg + "\n")
+	default:
+		return []byte(msg)
+	}
+}
+
+// Write sends the record, reconnecting once if the connection has been lost.
+func (s *SyslogSink) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.format(record)
+	err := s.write(msg)
+	if err != nil {
+		if s.conn != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+		}
+		if err = s.connect(); err == nil {
+			err = s.write(msg)
+		}
+	}
+	s.err = err
+
+	return err
+}
+
+func (s *SyslogSink) write(msg []byte) error {
+	if s.conn == nil {
+		return errors.New("not connected to syslog")
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(msg)
+
+	return err
+}
+
+// Durable reports that records are handed over to the syslog daemon.
+func (s *SyslogSink) Durable() bool {
+	return true
+}
+
+// Healthy returns the error of the last failed write, if any.
+func (s *SyslogSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return errors.New("not connected to syslog")
+	}
+
+	return s.err
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syslogHeader matches the RFC5424 header written by SyslogSink.
+var syslogHeader = regexp.MustCompile(`^<110>1 \S+ \S+ sda-download (\d+) audit - (.*)$`)
+
+func TestSyslogSink_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslogSink("udp", conn.LocalAddr().String(), "sda-download")
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Write([]byte(`{"event":"download.completed"}`)))
+	require.NoError(t, sink.Healthy())
+
+	buf := make([]byte, 2048)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	match := syslogHeader.FindStringSubmatch(string(buf[:n]))
+	require.NotNil(t, match, string(buf[:n]))
+	assert.Equal(t, strconv.Itoa(os.Getpid()), match[1])
+	assert.Equal(t, `{"event":"download.completed"}`, match[2])
+}
+
+func TestSyslogSink_TCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var msgs []string
+		reader := bufio.NewReader(conn)
+		for range 2 {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+
+	sink, err := NewSyslogSink("tcp", listener.Addr().String(), "sda-download")
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Write([]byte(`{"event":"a"}`)))
+	require.NoError(t, sink.Write([]byte(`{"event":"b"}`)))
+
+	select {
+	case msgs := <-received:
+		require.Len(t, msgs, 2)
+		for i, want := range []string{`{"event":"a"}`, `{"event":"b"}`} {
+			match := syslogHeader.FindStringSubmatch(msgs[i])
+			require.NotNil(t, match, msgs[i])
+			assert.Equal(t, want, match[2])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("syslog messages not received")
+	}
+}
+
+func TestSyslogSink_ConnectFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = NewSyslogSink("tcp", address, "sda-download")
+	assert.Error(t, err)
+}
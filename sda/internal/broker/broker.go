@@ -4,20 +4,36 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
 
+// publishTimeout is how long SendMessage waits for a message to be confirmed, including any time spent reconnecting
+const publishTimeout = 30 * time.Second
+
 // AMQPBroker is a Broker that reads messages from an AMQP broker
 type AMQPBroker struct {
-	Connection   *amqp.Connection
-	Channel      *amqp.Channel
-	Conf         MQConf
-	confirmsChan <-chan amqp.Confirmation
+	Connection *amqp.Connection
+	Channel    *amqp.Channel
+	Conf       MQConf
+
+	// mu guards Connection and Channel, which are replaced when reconnecting
+	mu sync.RWMutex
+	// reconnects is set if the broker reconnects when the connection is lost, see reconnectOnClose
+	reconnects bool
+	// changed is closed, and replaced, whenever a new channel has been opened
+	changed chan struct{}
+	// done is closed by Close
+	done      chan struct{}
+	closeOnce sync.Once
+	// lost receives the error reconnecting failed with, or ErrClosed once closed, if the broker reconnects
+	lost chan error
 }
 
 // MQConf stores information about the message broker
@@ -38,6 +54,14 @@ type MQConf struct {
 	ServerName    string
 	SchemasPath   string
 	PrefetchCount int
+	// Type of the message bus, rabbitmq or nats
+	Type string
+	// Stream is the JetStream stream of the nats broker, defaults to the name of the exchange
+	Stream string
+	// ReconnectTimeout is how long a lost connection is retried before giving up, zero disables reconnecting
+	ReconnectTimeout time.Duration
+	// Retry is the retry budget of messages which fail to be handled, see Retry
+	Retry RetryPolicy
 }
 
 // InfoError struct for sending detailed error messages to analysis.
@@ -115,6 +139,22 @@ func TLSConfigBroker(config MQConf) (*tls.Config, error) {
 
 // NewMQ creates a new Broker that can communicate with a backend amqp server.
 func NewMQ(config MQConf) (*AMQPBroker, error) {
+	broker := &AMQPBroker{
+		Conf:    config,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+		lost:    make(chan error, 1),
+	}
+	if err := broker.connect(); err != nil {
+		return nil, err
+	}
+
+	return broker, nil
+}
+
+// connect dials the broker and opens a channel in confirm mode, replacing the connection and channel of the broker
+func (broker *AMQPBroker) connect() error {
+	config := broker.Conf
 	brokerURI := buildMQURI(config.Host, config.User, config.Password, config.Vhost, config.Port, config.Ssl)
 
 	var connection *amqp.Connection
@@ -125,25 +165,27 @@ func NewMQ(config MQConf) (*AMQPBroker, error) {
 		var tlsConfig *tls.Config
 		tlsConfig, err = TLSConfigBroker(config)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		connection, err = amqp.DialTLS(brokerURI, tlsConfig)
 	} else {
 		connection, err = amqp.Dial(brokerURI)
 	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	channel, err := connection.Channel()
 	if err != nil {
-		return nil, err
+		_ = connection.Close()
+
+		return err
 	}
 
-	if e := channel.Confirm(false); e != nil {
-		_, _ = fmt.Printf("channel could not be put into confirm mode: %s", e)
+	if err := channel.Confirm(false); err != nil {
+		_ = connection.Close()
 
-		return nil, fmt.Errorf("channel could not be put into confirm mode: %s", e)
+		return fmt.Errorf("channel could not be put into confirm mode: %s", err)
 	}
 
 	if config.PrefetchCount > 0 {
@@ -154,9 +196,47 @@ func NewMQ(config MQConf) (*AMQPBroker, error) {
 		}
 	}
 
-	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	// Close may have been called while reconnecting
+	if broker.isClosed() {
+		_ = connection.Close()
 
-	return &AMQPBroker{connection, channel, config, confirms}, nil
+		return amqp.ErrClosed
+	}
+	broker.Connection = connection
+	broker.setChannel(channel)
+
+	return nil
+}
+
+// setChannel replaces the channel of the broker, and wakes up anyone waiting for a new channel. The lock is to be held
+// by the caller.
+func (broker *AMQPBroker) setChannel(channel *amqp.Channel) {
+	broker.Channel = channel
+	if broker.changed != nil {
+		close(broker.changed)
+	}
+	broker.changed = make(chan struct{})
+}
+
+// channel returns the current channel of the broker
+func (broker *AMQPBroker) channel() *amqp.Channel {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+
+	return broker.Channel
+}
+
+// isClosed reports if Close has been called
+func (broker *AMQPBroker) isClosed() bool {
+	select {
+	case <-broker.done:
+		return true
+	default:
+		return false
+	}
 }
 
 // ConnectionWatcher listens to events from the server
@@ -174,8 +254,11 @@ func (broker *AMQPBroker) ChannelWatcher() *amqp.Error {
 
 // GetMessages reads messages from the queue
 func (broker *AMQPBroker) GetMessages(queue string) (<-chan amqp.Delivery, error) {
-	ch := broker.Channel
+	return consume(broker.channel(), queue)
+}
 
+// consume starts a consumer of the queue on the channel
+func consume(ch *amqp.Channel, queue string) (<-chan amqp.Delivery, error) {
 	return ch.Consume(
 		queue, // queue
 		"",    // consumer
@@ -187,19 +270,43 @@ func (broker *AMQPBroker) GetMessages(queue string) (<-chan amqp.Delivery, error
 	)
 }
 
-// SendMessage sends a message to RabbitMQ
+// SendMessage publishes a message to RabbitMQ, and blocks until the broker has confirmed it. If the broker reconnects,
+// a message which is not confirmed because the connection was lost is published again once reconnected, the message
+// may then be delivered twice.
 func (broker *AMQPBroker) SendMessage(corrID, exchange, routingKey string, body []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	return broker.sendMessage(corrID, exchange, routingKey, amqp.Table{}, body)
+}
+
+// sendMessage publishes a message with headers, as SendMessage
+func (broker *AMQPBroker) sendMessage(corrID, exchange, routingKey string, headers amqp.Table, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
 	defer cancel()
 
-	err := broker.Channel.PublishWithContext(
+	for {
+		channel := broker.channel()
+		err := broker.publish(ctx, channel, corrID, exchange, routingKey, headers, body)
+		if !broker.reconnects || !errors.Is(err, amqp.ErrClosed) {
+			return err
+		}
+
+		log.Warnf("connection to broker lost before message (correlation-id: %s) was confirmed, publishing again once reconnected", corrID)
+		if err := broker.waitForNewChannel(ctx, channel); err != nil {
+			return fmt.Errorf("failed to publish message (correlation-id: %s) due to: %v", corrID, err)
+		}
+	}
+}
+
+// publish publishes the message on the channel and waits for the broker to confirm it, amqp.ErrClosed is returned if
+// the channel is closed before the message is confirmed
+func (broker *AMQPBroker) publish(ctx context.Context, channel *amqp.Channel, corrID, exchange, routingKey string, headers amqp.Table, body []byte) error {
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(
 		ctx,
 		exchange,
 		routingKey,
 		false, // mandatory
 		false, // immediate
 		amqp.Publishing{
-			Headers:         amqp.Table{},
+			Headers:         headers,
 			ContentEncoding: "UTF-8",
 			ContentType:     "application/json",
 			DeliveryMode:    amqp.Persistent, // 1=non-persistent, 2=persistent
@@ -214,31 +321,38 @@ func (broker *AMQPBroker) SendMessage(corrID, exchange, routingKey string, body
 		return err
 	}
 
-	confirmed := <-broker.confirmsChan
-	if !confirmed.Ack {
-		return fmt.Errorf("failed delivery of delivery tag: %d", confirmed.DeliveryTag)
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get confirmation of delivery tag: %d due to: %v", confirmation.DeliveryTag, err)
 	}
-	log.Debugf("confirmed delivery with delivery tag: %d", confirmed.DeliveryTag)
+	if !acked {
+		// Pending confirmations are nacked when the channel is closed
+		if channel.IsClosed() {
+			return amqp.ErrClosed
+		}
+
+		return fmt.Errorf("failed delivery of delivery tag: %d", confirmation.DeliveryTag)
+	}
+	log.Debugf("confirmed delivery with delivery tag: %d", confirmation.DeliveryTag)
 
 	return nil
 }
 
 func (broker *AMQPBroker) CreateNewChannel() error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
 	c, err := broker.Connection.Channel()
 	if err != nil {
 		return err
 	}
 
-	confirmsChan := make(chan amqp.Confirmation, 1)
 	if err := c.Confirm(false); err != nil {
-		close(confirmsChan)
-
 		return fmt.Errorf("channel could not be put into confirm mode: %v", err)
 	}
 
 	log.Debugln("reconnected to new channel")
-	broker.Channel = c
-	broker.confirmsChan = c.NotifyPublish(confirmsChan)
+	broker.setChannel(c)
 
 	return nil
 }
@@ -246,3 +360,42 @@ func (broker *AMQPBroker) CreateNewChannel() error {
 func (broker *AMQPBroker) IsConnClosed() bool {
 	return broker.Connection.IsClosed()
 }
+
+// QueueStatus is the number of messages ready in a queue and the number of consumers reading from it
+type QueueStatus struct {
+	Name      string `json:"name"`
+	Messages  int    `json:"messages"`
+	Consumers int    `json:"consumers"`
+	Error     string `json:"error,omitempty"`
+}
+
+// QueueStatus returns the status of the queues. A queue that can not be inspected, e.g. because it does not exist,
+// is reported with the reason in Error.
+func (broker *AMQPBroker) QueueStatus(queues []string) []QueueStatus {
+	statuses := make([]QueueStatus, 0, len(queues))
+	for _, queue := range queues {
+		status := QueueStatus{Name: queue}
+		// A failed passive declare closes the channel, so every queue is inspected on a channel of its own
+		broker.mu.RLock()
+		channel, err := broker.Connection.Channel()
+		broker.mu.RUnlock()
+		if err != nil {
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+
+			continue
+		}
+
+		q, err := channel.QueueDeclarePassive(queue, true, false, false, false, nil)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Messages = q.Messages
+			status.Consumers = q.Consumers
+			_ = channel.Close()
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
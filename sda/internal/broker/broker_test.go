@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -26,7 +27,7 @@ type BrokerTestSuite struct {
 	suite.Suite
 }
 
-var mqPort, tlsPort int
+var mqPort, tlsPort, natsPort int
 var certPath string
 var tMqconf = MQConf{}
 
@@ -96,11 +97,47 @@ func TestMain(m *testing.M) {
 		log.Panicf("Could not connect to rabbitmq: %s", err)
 	}
 
+	nats, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "nats",
+		Tag:        "2.10-alpine",
+		Cmd:        []string{"-js"},
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{
+			Name: "no",
+		}
+	})
+	if err != nil {
+		if err := pool.Purge(rabbitmq); err != nil {
+			log.Panicf("Could not purge resource: %s", err)
+		}
+		log.Panicf("Could not start resource: %s", err)
+	}
+	natsPort, _ = strconv.Atoi(nats.GetPort("4222/tcp"))
+
+	if err := pool.Retry(func() error {
+		bus, err := NewNATS(MQConf{Host: "127.0.0.1", Port: natsPort, Exchange: "sda"})
+		if err != nil {
+			return err
+		}
+
+		return bus.Close()
+	}); err != nil {
+		for _, r := range []*dockertest.Resource{rabbitmq, nats} {
+			if err := pool.Purge(r); err != nil {
+				log.Panicf("Could not purge resource: %s", err)
+			}
+		}
+		log.Panicf("Could not connect to nats: %s", err)
+	}
+
 	code := m.Run()
 
 	log.Println("tests completed")
-	if err := pool.Purge(rabbitmq); err != nil {
-		log.Panicf("Could not purge resource: %s", err)
+	for _, r := range []*dockertest.Resource{rabbitmq, nats} {
+		if err := pool.Purge(r); err != nil {
+			log.Panicf("Could not purge resource: %s", err)
+		}
 	}
 
 	_ = os.RemoveAll(certPath)
@@ -125,6 +162,10 @@ func (ts *BrokerTestSuite) SetupTest() {
 		"mq",
 		"",
 		2,
+		RabbitMQ,
+		"",
+		0,
+		RetryPolicy{},
 	}
 }
 
@@ -289,6 +330,191 @@ func (ts *BrokerTestSuite) TestCreateNewChannel() {
 	assert.False(ts.T(), b.Channel.IsClosed())
 }
 
+func (ts *BrokerTestSuite) TestConsume() {
+	b, err := NewMQ(tMqconf)
+	assert.NoError(ts.T(), err)
+
+	assert.NoError(ts.T(), b.SendMessage("consume", "", "ingest", []byte("consumed message")))
+
+	deliveries, err := b.Consume("ingest")
+	assert.NoError(ts.T(), err)
+
+	for d := range deliveries {
+		if string(d.Body) == "consumed message" {
+			assert.Equal(ts.T(), "consume", d.CorrelationID)
+			assert.Equal(ts.T(), "ingest", d.RoutingKey)
+			assert.NoError(ts.T(), d.Ack())
+
+			break
+		}
+		assert.NoError(ts.T(), d.Ack())
+	}
+
+	assert.NoError(ts.T(), b.Close())
+}
+
+func (ts *BrokerTestSuite) TestNotifyClose() {
+	b, err := NewMQ(tMqconf)
+	assert.NoError(ts.T(), err)
+
+	closed := b.NotifyClose()
+	assert.NoError(ts.T(), b.Close())
+
+	select {
+	case err := <-closed:
+		assert.Error(ts.T(), err)
+	case <-time.After(5 * time.Second):
+		ts.FailNow("no error received when the connection was closed")
+	}
+}
+
+func (ts *BrokerTestSuite) TestNewBus() {
+	conf := tMqconf
+	conf.Type = "kafka"
+	_, err := NewBus(conf)
+	assert.ErrorContains(ts.T(), err, "not supported")
+
+	conf.Type = RabbitMQ
+	b, err := NewBus(conf)
+	assert.NoError(ts.T(), err)
+	assert.IsType(ts.T(), &AMQPBroker{}, b)
+	assert.NoError(ts.T(), b.Close())
+}
+
+func (ts *BrokerTestSuite) TestReconnect() {
+	conf := tMqconf
+	conf.ReconnectTimeout = 30 * time.Second
+	bus, err := NewBus(conf)
+	assert.NoError(ts.T(), err)
+	b, ok := bus.(*AMQPBroker)
+	ts.Require().True(ok)
+
+	deliveries, err := b.Consume("ingest")
+	assert.NoError(ts.T(), err)
+	closed := b.NotifyClose()
+
+	// A channel closed behind the back of the broker is handled as a lost connection
+	assert.NoError(ts.T(), b.channel().Close())
+	assert.NoError(ts.T(), b.SendMessage("reconnect", "", "ingest", []byte("after reconnect")))
+	assert.False(ts.T(), b.IsConnClosed())
+
+	for {
+		select {
+		case d := <-deliveries:
+			assert.NoError(ts.T(), d.Ack())
+			if string(d.Body) != "after reconnect" {
+				continue
+			}
+			assert.Equal(ts.T(), "reconnect", d.CorrelationID)
+		case <-time.After(10 * time.Second):
+			ts.FailNow("consumer did not resume after reconnecting")
+		}
+
+		break
+	}
+
+	select {
+	case err := <-closed:
+		ts.FailNow("broker gave up reconnecting", err)
+	default:
+	}
+
+	assert.NoError(ts.T(), b.Close())
+	assert.ErrorIs(ts.T(), <-closed, amqp.ErrClosed)
+	_, open := <-deliveries
+	assert.False(ts.T(), open, "deliveries should be closed once the broker is closed")
+}
+
+func (ts *BrokerTestSuite) TestReconnect_Timeout() {
+	conf := tMqconf
+	conf.ReconnectTimeout = time.Second
+	bus, err := NewBus(conf)
+	assert.NoError(ts.T(), err)
+	b, ok := bus.(*AMQPBroker)
+	ts.Require().True(ok)
+	closed := b.NotifyClose()
+
+	// The broker can not reconnect with the wrong password
+	b.Conf.Password = "wrong"
+	b.mu.RLock()
+	assert.NoError(ts.T(), b.Connection.Close())
+	b.mu.RUnlock()
+
+	select {
+	case err := <-closed:
+		assert.ErrorContains(ts.T(), err, "gave up reconnecting")
+	case <-time.After(30 * time.Second):
+		ts.FailNow("broker did not give up reconnecting")
+	}
+	assert.NoError(ts.T(), b.Close())
+}
+
+func (ts *BrokerTestSuite) TestRetryAndReplayParked() {
+	conf := tMqconf
+	conf.Exchange = ""
+	conf.Queue = "retry"
+	conf.Retry = RetryPolicy{MaxAttempts: 2, InitialDelay: 100 * time.Millisecond}
+	b, err := NewMQ(conf)
+	ts.Require().NoError(err)
+	defer b.Close()
+
+	for _, queue := range []string{"retry", ParkingLot} {
+		_, err := b.Channel.QueueDeclare(queue, true, false, false, false, nil)
+		ts.Require().NoError(err)
+	}
+	assert.NoError(ts.T(), b.SendMessage("retry", "", "retry", []byte(`{"retry":true}`)))
+
+	deliveries, err := b.Consume("retry")
+	ts.Require().NoError(err)
+	next := func() Delivery {
+		select {
+		case d := <-deliveries:
+			return d
+		case <-time.After(10 * time.Second):
+			ts.FailNow("no message delivered")
+		}
+
+		return Delivery{}
+	}
+
+	d := next()
+	assert.Equal(ts.T(), 1, Attempt(d))
+	assert.NoError(ts.T(), Retry(b, conf, d, errors.New("first attempt failed")))
+
+	// The message is dead-lettered back from the delay queue
+	d = next()
+	assert.Equal(ts.T(), 2, Attempt(d))
+	assert.NoError(ts.T(), Retry(b, conf, d, errors.New("second attempt failed")))
+
+	var parked []ParkedMessage
+	assert.Eventually(ts.T(), func() bool {
+		parked, err = b.ParkedMessages()
+
+		return err == nil && len(parked) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+	ts.Require().Len(parked, 1)
+	assert.Equal(ts.T(), "retry", parked[0].Queue)
+	assert.Equal(ts.T(), "second attempt failed", parked[0].Reason)
+
+	// Listing the parked messages leaves them in the parking lot
+	parked, err = b.ParkedMessages()
+	assert.NoError(ts.T(), err)
+	assert.Len(ts.T(), parked, 1)
+
+	replayed, err := b.ReplayParked(func(m ParkedMessage) bool { return m.CorrelationID == "retry" })
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 1, replayed)
+
+	d = next()
+	assert.Equal(ts.T(), 1, Attempt(d), "a replayed message should have a new retry budget")
+	assert.Equal(ts.T(), `{"retry":true}`, string(d.Body))
+	assert.NoError(ts.T(), d.Ack())
+
+	parked, err = b.ParkedMessages()
+	assert.NoError(ts.T(), err)
+	assert.Empty(ts.T(), parked)
+}
+
 // Helper functions below this line
 
 func writeConf(dest string) error {
@@ -313,3 +539,19 @@ func writeConf(dest string) error {
 
 	return nil
 }
+
+func (ts *BrokerTestSuite) TestQueueStatus() {
+	b, err := NewMQ(tMqconf)
+	assert.NoError(ts.T(), err)
+	defer b.Connection.Close()
+
+	assert.NoError(ts.T(), b.SendMessage("queue-status", "", "ingest", []byte("queued message")))
+
+	statuses := b.QueueStatus([]string{"ingest", "no-such-queue"})
+	assert.Len(ts.T(), statuses, 2)
+	assert.Equal(ts.T(), "ingest", statuses[0].Name)
+	assert.GreaterOrEqual(ts.T(), statuses[0].Messages, 1)
+	assert.Empty(ts.T(), statuses[0].Error)
+	assert.Equal(ts.T(), "no-such-queue", statuses[1].Name)
+	assert.Contains(ts.T(), statuses[1].Error, "NOT_FOUND")
+}
@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported message bus types, selected by broker.type in the config
+const (
+	RabbitMQ = "rabbitmq"
+	NATS     = "nats"
+)
+
+// Bus is a message bus the pipeline services consume messages from and publish messages to
+type Bus interface {
+	// Consume returns the messages delivered from the queue, every message is to be acknowledged with one of Ack, Nack
+	// or Reject once handled
+	Consume(queue string) (<-chan Delivery, error)
+	// SendMessage publishes the message to the exchange with the routing key, and waits for the bus to confirm it
+	SendMessage(corrID, exchange, routingKey string, body []byte) error
+	// NotifyClose returns a channel which receives an error once the connection to the bus has been lost
+	NotifyClose() <-chan error
+	// Close closes the connection to the bus
+	Close() error
+}
+
+// Acknowledger acknowledges a delivered message towards the bus it was delivered by
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+	Reject() error
+}
+
+// Delivery is a message delivered by a Bus
+type Delivery struct {
+	Body          []byte
+	CorrelationID string
+	// RoutingKey is the routing key the message was published with
+	RoutingKey string
+	Headers    map[string]any
+	// Redelivered is set if the message has been delivered before, without being acknowledged
+	Redelivered bool
+
+	Acknowledger Acknowledger `json:"-"`
+}
+
+// Ack acknowledges the message as handled, and removes it from the queue
+func (d Delivery) Ack() error {
+	return d.Acknowledger.Ack()
+}
+
+// Nack negatively acknowledges the message, if requeue is set the message is delivered again, otherwise it is
+// dead-lettered as by Reject
+func (d Delivery) Nack(requeue bool) error {
+	return d.Acknowledger.Nack(requeue)
+}
+
+// Reject rejects the message without it being delivered again, the message is dead-lettered if the bus has
+// dead-lettering configured
+func (d Delivery) Reject() error {
+	return d.Acknowledger.Reject()
+}
+
+// NewBus connects to the message bus of the type in the config. A RabbitMQ broker reconnects when the connection is
+// lost if a reconnect timeout is configured.
+func NewBus(config MQConf) (Bus, error) {
+	switch config.Type {
+	case "", RabbitMQ:
+		mq, err := NewMQ(config)
+		if err != nil {
+			return nil, err
+		}
+		if config.ReconnectTimeout > 0 {
+			mq.reconnectOnClose()
+		}
+
+		return mq, nil
+	case NATS:
+		return NewNATS(config)
+	default:
+		return nil, fmt.Errorf("broker type: %s is not supported", config.Type)
+	}
+}
+
+// Consume returns the messages delivered from the queue. If the broker reconnects, the consumer is declared again on
+// the new channel once reconnected, messages delivered but not acknowledged before the connection was lost are then
+// delivered again, and can no longer be acknowledged.
+func (broker *AMQPBroker) Consume(queue string) (<-chan Delivery, error) {
+	channel := broker.channel()
+	messages, err := consume(channel, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			for m := range messages {
+				deliveries <- Delivery{
+					Body:          m.Body,
+					CorrelationID: m.CorrelationId,
+					RoutingKey:    m.RoutingKey,
+					Headers:       m.Headers,
+					Redelivered:   m.Redelivered,
+					Acknowledger:  amqpAcknowledger{delivery: m, broker: broker, queue: queue},
+				}
+			}
+			if !broker.reconnects {
+				return
+			}
+
+			if err := broker.waitForNewChannel(context.Background(), channel); err != nil {
+				return
+			}
+			channel = broker.channel()
+			messages, err = consume(channel, queue)
+			if err != nil {
+				log.Errorf("failed to consume from queue: %s after reconnecting, reason: %v", queue, err)
+				broker.notifyLost(err)
+
+				return
+			}
+			log.Infof("resumed consuming from queue: %s", queue)
+		}
+	}()
+
+	return deliveries, nil
+}
+
+// NotifyClose returns a channel which receives an error once the connection or the channel to the broker is closed.
+// If the broker reconnects, the error is received once the broker has given up reconnecting, or is closed.
+func (broker *AMQPBroker) NotifyClose() <-chan error {
+	if broker.reconnects {
+		return broker.lost
+	}
+
+	closed := make(chan error, 1)
+	broker.mu.RLock()
+	connClosed := broker.Connection.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := broker.Channel.NotifyClose(make(chan *amqp.Error, 1))
+	broker.mu.RUnlock()
+	go func() {
+		var err *amqp.Error
+		select {
+		case err = <-connClosed:
+		case err = <-channelClosed:
+		}
+		if err == nil {
+			closed <- amqp.ErrClosed
+
+			return
+		}
+		closed <- err
+	}()
+
+	return closed
+}
+
+// Close closes the channel and the connection to the broker, and stops reconnecting
+func (broker *AMQPBroker) Close() error {
+	if broker.done != nil {
+		broker.closeOnce.Do(func() {
+			close(broker.done)
+		})
+	}
+	if broker.reconnects {
+		broker.notifyLost(amqp.ErrClosed)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.Channel != nil {
+		if err := broker.Channel.Close(); err != nil && err != amqp.ErrClosed {
+			return fmt.Errorf("failed to close mq broker channel due to: %v", err)
+		}
+	}
+	if broker.Connection != nil {
+		if err := broker.Connection.Close(); err != nil && err != amqp.ErrClosed {
+			return fmt.Errorf("failed to close mq broker connection due to: %v", err)
+		}
+	}
+
+	return nil
+}
+
+type amqpAcknowledger struct {
+	delivery amqp.Delivery
+	broker   *AMQPBroker
+	// queue is the queue the message was consumed from
+	queue string
+}
+
+func (a amqpAcknowledger) Ack() error {
+	return a.delivery.Ack(false)
+}
+
+func (a amqpAcknowledger) Nack(requeue bool) error {
+	return a.delivery.Nack(false, requeue)
+}
+
+func (a amqpAcknowledger) Reject() error {
+	return a.delivery.Reject(false)
+}
+
+// requeueAfter publishes the message to a delay queue of the queue it was consumed from, which dead-letters the message
+// back to the queue once the delay has passed, and acknowledges the delivery
+func (a amqpAcknowledger) requeueAfter(delay time.Duration, attempt int) error {
+	delayQueue, err := a.broker.declareDelayQueue(a.queue, delay)
+	if err != nil {
+		return err
+	}
+
+	headers := amqp.Table{}
+	for key, value := range a.delivery.Headers {
+		headers[key] = value
+	}
+	headers[RetryAttemptHeader] = int32(attempt) // #nosec G115 -- the number of attempts is small
+	if err := a.broker.sendMessage(a.delivery.CorrelationId, "", delayQueue, headers, a.delivery.Body); err != nil {
+		return err
+	}
+
+	return a.delivery.Ack(false)
+}
@@ -0,0 +1,462 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrMemoryBusClosed is returned when publishing to a MemoryBus which has been closed
+var ErrMemoryBusClosed = errors.New("memory bus is closed")
+
+// MemoryBus is an in-process Bus, intended for tests of services which would otherwise require a message broker.
+//
+// Messages are routed as by RabbitMQ: a message published to the default exchange "" is routed to the queue named as
+// the routing key, and a message published to any other exchange is routed to every queue bound to the exchange with a
+// matching topic pattern, see Bind. Messages which are not routed to any queue are routed through the alternate
+// exchange of the exchange if one is set, otherwise they are kept as unroutable. Rejected messages are kept per queue
+// instead of being dead-lettered. Messages requeued with a delay, see Retry, are added to the back of the queue once the
+// delay has passed. Stream queues are treated as ordinary queues.
+type MemoryBus struct {
+	sync.Mutex
+	// changed is signaled whenever a message becomes ready or is acknowledged
+	changed *sync.Cond
+	done    chan struct{}
+	closed  chan error
+
+	prefetchCount int
+	queues        map[string]*memoryQueue
+	// bindings holds the bindings of each exchange
+	bindings map[string][]memoryBinding
+	// alternates holds the alternate exchange of each exchange
+	alternates map[string]string
+	unroutable []MemoryMessage
+}
+
+// MemoryMessage is a message published to a MemoryBus
+type MemoryMessage struct {
+	CorrelationID string
+	Exchange      string
+	RoutingKey    string
+	Headers       map[string]any
+	Body          []byte
+}
+
+type memoryBinding struct {
+	pattern *regexp.Regexp
+	queue   string
+}
+
+type memoryQueue struct {
+	ready    []*memoryDelivery
+	unacked  int
+	rejected []MemoryMessage
+}
+
+type memoryDelivery struct {
+	message     MemoryMessage
+	redelivered bool
+}
+
+// NewMemoryBus returns an empty MemoryBus, at most prefetchCount messages of a queue are delivered without being
+// acknowledged unless prefetchCount is zero
+func NewMemoryBus(prefetchCount int) *MemoryBus {
+	bus := &MemoryBus{
+		done:          make(chan struct{}),
+		closed:        make(chan error, 1),
+		prefetchCount: prefetchCount,
+		queues:        make(map[string]*memoryQueue),
+		bindings:      make(map[string][]memoryBinding),
+		alternates:    make(map[string]string),
+	}
+	bus.changed = sync.NewCond(&bus.Mutex)
+
+	return bus
+}
+
+// Declare creates the queue unless it exists
+func (bus *MemoryBus) Declare(queue string) {
+	bus.Lock()
+	defer bus.Unlock()
+
+	bus.declare(queue)
+}
+
+func (bus *MemoryBus) declare(queue string) *memoryQueue {
+	q, ok := bus.queues[queue]
+	if !ok {
+		q = &memoryQueue{}
+		bus.queues[queue] = q
+	}
+
+	return q
+}
+
+// Bind declares the queue, and binds it to the exchange with the topic pattern, where "*" matches exactly one word
+// and "#" matches zero or more words of the routing key
+func (bus *MemoryBus) Bind(exchange, pattern, queue string) {
+	bus.Lock()
+	defer bus.Unlock()
+
+	bus.declare(queue)
+	bus.bindings[exchange] = append(bus.bindings[exchange], memoryBinding{pattern: topicPattern(pattern), queue: queue})
+}
+
+// SetAlternateExchange sets the exchange messages which can not be routed by the exchange are routed through
+func (bus *MemoryBus) SetAlternateExchange(exchange, alternate string) {
+	bus.Lock()
+	defer bus.Unlock()
+
+	bus.alternates[exchange] = alternate
+}
+
+// topicPattern compiles a topic binding pattern into a regular expression matching the routing keys of the binding
+func topicPattern(pattern string) *regexp.Regexp {
+	if pattern == "#" {
+		return regexp.MustCompile(".*")
+	}
+
+	words := strings.Split(pattern, ".")
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i, word := range words {
+		switch {
+		case word == "#" && i == 0:
+			expr.WriteString(`([^.]+\.)*`)
+
+			continue
+		case word == "#":
+			expr.WriteString(`(\.[^.]+)*`)
+
+			continue
+		case i > 0 && (i > 1 || words[0] != "#"):
+			expr.WriteString(`\.`)
+		}
+		if word == "*" {
+			expr.WriteString(`[^.]+`)
+		} else {
+			expr.WriteString(regexp.QuoteMeta(word))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
+
+// LoadDefinitions declares the queues and queue bindings, and applies the alternate exchange policies, of a RabbitMQ
+// definitions file, such as rabbitmq/definitions.json
+func (bus *MemoryBus) LoadDefinitions(definitions io.Reader) error {
+	var defs struct {
+		Queues []struct {
+			Name string `json:"name"`
+		} `json:"queues"`
+		Exchanges []struct {
+			Name string `json:"name"`
+		} `json:"exchanges"`
+		Bindings []struct {
+			Source          string `json:"source"`
+			Destination     string `json:"destination"`
+			DestinationType string `json:"destination_type"`
+			RoutingKey      string `json:"routing_key"`
+		} `json:"bindings"`
+		Policies []struct {
+			Pattern    string         `json:"pattern"`
+			ApplyTo    string         `json:"apply-to"`
+			Definition map[string]any `json:"definition"`
+		} `json:"policies"`
+	}
+	if err := json.NewDecoder(definitions).Decode(&defs); err != nil {
+		return fmt.Errorf("failed to decode definitions, due to: %v", err)
+	}
+
+	for _, q := range defs.Queues {
+		bus.Declare(q.Name)
+	}
+	for _, b := range defs.Bindings {
+		if b.DestinationType != "queue" {
+			continue
+		}
+		bus.Bind(b.Source, b.RoutingKey, b.Destination)
+	}
+	for _, p := range defs.Policies {
+		alternate, ok := p.Definition["alternate-exchange"].(string)
+		if !ok || (p.ApplyTo != "exchanges" && p.ApplyTo != "all") {
+			continue
+		}
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("failed to compile policy pattern: %s, due to: %v", p.Pattern, err)
+		}
+		for _, e := range defs.Exchanges {
+			if pattern.MatchString(e.Name) {
+				bus.SetAlternateExchange(e.Name, alternate)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Consume returns the messages delivered from the queue, the queue is declared unless it exists
+func (bus *MemoryBus) Consume(queue string) (<-chan Delivery, error) {
+	bus.Lock()
+	select {
+	case <-bus.done:
+		bus.Unlock()
+
+		return nil, ErrMemoryBusClosed
+	default:
+	}
+	q := bus.declare(queue)
+	bus.Unlock()
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			bus.Lock()
+			for !bus.isClosed() && (len(q.ready) == 0 || (bus.prefetchCount > 0 && q.unacked >= bus.prefetchCount)) {
+				bus.changed.Wait()
+			}
+			if bus.isClosed() {
+				bus.Unlock()
+
+				return
+			}
+			d := q.ready[0]
+			q.ready = q.ready[1:]
+			q.unacked++
+			bus.Unlock()
+
+			headers := make(map[string]any, len(d.message.Headers))
+			for key, value := range d.message.Headers {
+				headers[key] = value
+			}
+			delivery := Delivery{
+				Body:          d.message.Body,
+				CorrelationID: d.message.CorrelationID,
+				RoutingKey:    d.message.RoutingKey,
+				Headers:       headers,
+				Redelivered:   d.redelivered,
+				Acknowledger:  &memoryAcknowledger{bus: bus, queue: q, delivery: d},
+			}
+			select {
+			case deliveries <- delivery:
+			case <-bus.done:
+				return
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+// isClosed reports if the bus has been closed, the lock is to be held by the caller
+func (bus *MemoryBus) isClosed() bool {
+	select {
+	case <-bus.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendMessage routes the message to the queues bound to the exchange with a matching routing key
+func (bus *MemoryBus) SendMessage(corrID, exchange, routingKey string, body []byte) error {
+	bus.Lock()
+	defer bus.Unlock()
+
+	if bus.isClosed() {
+		return ErrMemoryBusClosed
+	}
+
+	message := MemoryMessage{
+		CorrelationID: corrID,
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Body:          append([]byte(nil), body...),
+	}
+	queues := bus.route(exchange, routingKey, map[string]bool{})
+	if len(queues) == 0 {
+		log.Debugf("message to exchange: %s with routing key: %s is unroutable", exchange, routingKey)
+		bus.unroutable = append(bus.unroutable, message)
+
+		return nil
+	}
+	for _, queue := range queues {
+		q := bus.queues[queue]
+		q.ready = append(q.ready, &memoryDelivery{message: message})
+	}
+	bus.changed.Broadcast()
+
+	return nil
+}
+
+// route returns the queues a message to the exchange with the routing key is routed to, visited holds the exchanges
+// already tried to guard against cycles of alternate exchanges
+func (bus *MemoryBus) route(exchange, routingKey string, visited map[string]bool) []string {
+	if exchange == "" {
+		if _, ok := bus.queues[routingKey]; ok {
+			return []string{routingKey}
+		}
+
+		return nil
+	}
+	visited[exchange] = true
+
+	var queues []string
+	matched := make(map[string]bool)
+	for _, binding := range bus.bindings[exchange] {
+		if !matched[binding.queue] && binding.pattern.MatchString(routingKey) {
+			matched[binding.queue] = true
+			queues = append(queues, binding.queue)
+		}
+	}
+	if alternate, ok := bus.alternates[exchange]; ok && len(queues) == 0 && !visited[alternate] {
+		return bus.route(alternate, routingKey, visited)
+	}
+
+	return queues
+}
+
+// Messages returns the messages of the queue which have not yet been delivered
+func (bus *MemoryBus) Messages(queue string) []MemoryMessage {
+	bus.Lock()
+	defer bus.Unlock()
+
+	q, ok := bus.queues[queue]
+	if !ok {
+		return nil
+	}
+	messages := make([]MemoryMessage, 0, len(q.ready))
+	for _, d := range q.ready {
+		messages = append(messages, d.message)
+	}
+
+	return messages
+}
+
+// Rejected returns the messages of the queue which have been rejected, or negatively acknowledged without requeue
+func (bus *MemoryBus) Rejected(queue string) []MemoryMessage {
+	bus.Lock()
+	defer bus.Unlock()
+
+	q, ok := bus.queues[queue]
+	if !ok {
+		return nil
+	}
+
+	return append([]MemoryMessage(nil), q.rejected...)
+}
+
+// Unroutable returns the messages which were not routed to any queue
+func (bus *MemoryBus) Unroutable() []MemoryMessage {
+	bus.Lock()
+	defer bus.Unlock()
+
+	return append([]MemoryMessage(nil), bus.unroutable...)
+}
+
+// NotifyClose returns a channel which receives an error once the bus has been closed
+func (bus *MemoryBus) NotifyClose() <-chan error {
+	return bus.closed
+}
+
+// Close stops the delivery of messages to all consumers
+func (bus *MemoryBus) Close() error {
+	bus.Lock()
+	defer bus.Unlock()
+
+	if bus.isClosed() {
+		return nil
+	}
+	close(bus.done)
+	bus.closed <- ErrMemoryBusClosed
+	bus.changed.Broadcast()
+
+	return nil
+}
+
+type memoryAcknowledger struct {
+	bus      *MemoryBus
+	queue    *memoryQueue
+	delivery *memoryDelivery
+	acked    bool
+}
+
+// settle marks the delivery as acknowledged, a delivery can only be acknowledged once
+func (a *memoryAcknowledger) settle() error {
+	if a.acked {
+		return errors.New("delivery has already been acknowledged")
+	}
+	a.acked = true
+	a.queue.unacked--
+	a.bus.changed.Broadcast()
+
+	return nil
+}
+
+func (a *memoryAcknowledger) Ack() error {
+	a.bus.Lock()
+	defer a.bus.Unlock()
+
+	return a.settle()
+}
+
+func (a *memoryAcknowledger) Nack(requeue bool) error {
+	a.bus.Lock()
+	defer a.bus.Unlock()
+
+	if err := a.settle(); err != nil {
+		return err
+	}
+	if requeue {
+		a.queue.ready = append([]*memoryDelivery{{message: a.delivery.message, redelivered: true}}, a.queue.ready...)
+
+		return nil
+	}
+	a.queue.rejected = append(a.queue.rejected, a.delivery.message)
+
+	return nil
+}
+
+func (a *memoryAcknowledger) Reject() error {
+	return a.Nack(false)
+}
+
+// requeueAfter acknowledges the delivery, and adds the message with the attempt header set to the back of the queue
+// once the delay has passed
+func (a *memoryAcknowledger) requeueAfter(delay time.Duration, attempt int) error {
+	a.bus.Lock()
+	defer a.bus.Unlock()
+
+	if err := a.settle(); err != nil {
+		return err
+	}
+
+	message := a.delivery.message
+	message.Headers = make(map[string]any, len(a.delivery.message.Headers)+1)
+	for key, value := range a.delivery.message.Headers {
+		message.Headers[key] = value
+	}
+	message.Headers[RetryAttemptHeader] = attempt
+	time.AfterFunc(delay, func() {
+		a.bus.Lock()
+		defer a.bus.Unlock()
+
+		if a.bus.isClosed() {
+			return
+		}
+		a.queue.ready = append(a.queue.ready, &memoryDelivery{message: message})
+		a.bus.changed.Broadcast()
+	})
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryBusTestSuite struct {
+	suite.Suite
+	bus *MemoryBus
+}
+
+func TestMemoryBusTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryBusTestSuite))
+}
+
+func (ts *MemoryBusTestSuite) SetupTest() {
+	ts.bus = NewMemoryBus(2)
+}
+
+func (ts *MemoryBusTestSuite) TearDownTest() {
+	ts.NoError(ts.bus.Close())
+}
+
+func (ts *MemoryBusTestSuite) next(deliveries <-chan Delivery) Delivery {
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(5 * time.Second):
+		ts.FailNow("no message delivered")
+	}
+
+	return Delivery{}
+}
+
+func (ts *MemoryBusTestSuite) TestTopicPattern() {
+	for _, tc := range []struct {
+		pattern    string
+		routingKey string
+		match      bool
+	}{
+		{"ingest", "ingest", true},
+		{"ingest", "ingested", false},
+		{"#", "any.routing.key", true},
+		{"files.*", "files.ingest", true},
+		{"files.*", "files", false},
+		{"files.*", "files.ingest.error", false},
+		{"files.#", "files", true},
+		{"files.#", "files.ingest.error", true},
+		{"#.error", "error", true},
+		{"#.error", "files.ingest.error", true},
+		{"files.#.error", "files.error", true},
+		{"files.#.error", "files.ingest.error", true},
+		{"files.#.error", "fileserror", false},
+	} {
+		ts.Equal(tc.match, topicPattern(tc.pattern).MatchString(tc.routingKey), "pattern: %s, routing key: %s", tc.pattern, tc.routingKey)
+	}
+}
+
+func (ts *MemoryBusTestSuite) TestSendAndConsume() {
+	ts.bus.Bind("sda", "ingest", "ingest")
+	ts.bus.Bind("sda", "#", "everything")
+
+	ts.NoError(ts.bus.SendMessage("corr-1", "sda", "ingest", []byte("message 1")))
+	ts.NoError(ts.bus.SendMessage("corr-2", "sda", "archived", []byte("message 2")))
+	// The default exchange routes to the queue named as the routing key
+	ts.NoError(ts.bus.SendMessage("corr-3", "", "ingest", []byte("message 3")))
+
+	ts.Len(ts.bus.Messages("everything"), 2)
+
+	deliveries, err := ts.bus.Consume("ingest")
+	ts.Require().NoError(err)
+	for _, expected := range []string{"corr-1", "corr-3"} {
+		d := ts.next(deliveries)
+		ts.Equal(expected, d.CorrelationID)
+		ts.Equal("ingest", d.RoutingKey)
+		ts.NoError(d.Ack())
+		ts.Error(d.Ack(), "a delivery can only be acknowledged once")
+	}
+	ts.Empty(ts.bus.Messages("ingest"))
+}
+
+func (ts *MemoryBusTestSuite) TestPrefetchCount() {
+	ts.bus.Bind("sda", "ingest", "ingest")
+	for range 3 {
+		ts.NoError(ts.bus.SendMessage("corr", "sda", "ingest", []byte("message")))
+	}
+
+	deliveries, err := ts.bus.Consume("ingest")
+	ts.Require().NoError(err)
+	first := ts.next(deliveries)
+	ts.next(deliveries)
+
+	select {
+	case <-deliveries:
+		ts.Fail("more messages than the prefetch count were delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ts.NoError(first.Ack())
+	ts.next(deliveries)
+}
+
+func (ts *MemoryBusTestSuite) TestNackAndReject() {
+	// With a prefetch count of one the requeued message is delivered before the next message
+	ts.NoError(ts.bus.Close())
+	ts.bus = NewMemoryBus(1)
+	ts.bus.Bind("sda", "verified", "verified")
+	ts.NoError(ts.bus.SendMessage("corr-1", "sda", "verified", []byte("message 1")))
+	ts.NoError(ts.bus.SendMessage("corr-2", "sda", "verified", []byte("message 2")))
+
+	deliveries, err := ts.bus.Consume("verified")
+	ts.Require().NoError(err)
+
+	d := ts.next(deliveries)
+	ts.NoError(d.Nack(true))
+	d = ts.next(deliveries)
+	ts.Equal("corr-1", d.CorrelationID)
+	ts.True(d.Redelivered)
+	ts.NoError(d.Reject())
+
+	d = ts.next(deliveries)
+	ts.Equal("corr-2", d.CorrelationID)
+	ts.NoError(d.Nack(false))
+
+	rejected := ts.bus.Rejected("verified")
+	ts.Require().Len(rejected, 2)
+	ts.Equal("message 1", string(rejected[0].Body))
+	ts.Equal("message 2", string(rejected[1].Body))
+}
+
+func (ts *MemoryBusTestSuite) TestLoadDefinitions() {
+	definitions, err := os.Open("../../../rabbitmq/definitions.json")
+	ts.Require().NoError(err)
+	defer definitions.Close()
+	ts.Require().NoError(ts.bus.LoadDefinitions(definitions))
+
+	ts.NoError(ts.bus.SendMessage("corr-1", "sda", "mappings", []byte("mapping")))
+	ts.Len(ts.bus.Messages("mapping_stream"), 1)
+
+	// Unroutable messages of the sda exchange are routed to its alternate exchange
+	ts.NoError(ts.bus.SendMessage("corr-2", "sda", "no_such_queue", []byte("dead")))
+	ts.Len(ts.bus.Messages("catch_all.dead"), 1)
+
+	ts.NoError(ts.bus.SendMessage("corr-3", "other", "ingest", []byte("unroutable")))
+	ts.Len(ts.bus.Unroutable(), 1)
+}
+
+func (ts *MemoryBusTestSuite) TestClose() {
+	deliveries, err := ts.bus.Consume("ingest")
+	ts.Require().NoError(err)
+
+	ts.NoError(ts.bus.Close())
+	ts.ErrorIs(<-ts.bus.NotifyClose(), ErrMemoryBusClosed)
+	_, open := <-deliveries
+	ts.False(open)
+	ts.ErrorIs(ts.bus.SendMessage("corr", "sda", "ingest", []byte("message")), ErrMemoryBusClosed)
+}
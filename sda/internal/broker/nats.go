@@ -0,0 +1,281 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	log "github.com/sirupsen/logrus"
+)
+
+// CorrelationIDHeader is the header of NATS messages which holds the correlation id
+const CorrelationIDHeader = "Correlation-Id"
+
+// NATSBus is a Bus on top of NATS JetStream.
+//
+// Messages are published to the subject "${EXCHANGE}.${ROUTING_KEY}", which are stored in a work queue stream
+// capturing all subjects of the exchange. A queue is a durable consumer of the stream, named as the queue, which
+// consumes the subject "${EXCHANGE}.${QUEUE}". Rejected messages are dead-lettered to the subject
+// "${EXCHANGE}.dead.${ROUTING_KEY}".
+type NATSBus struct {
+	Conf   MQConf
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	stream jetstream.Stream
+	closed chan error
+
+	sync.Mutex
+	consumers []jetstream.MessagesContext
+}
+
+// NewNATS connects to a NATS server with JetStream enabled, and creates the stream of the exchange unless it exists
+func NewNATS(config MQConf) (*NATSBus, error) {
+	if config.Exchange == "" {
+		return nil, errors.New("an exchange is required for the nats broker")
+	}
+	bus := &NATSBus{Conf: config, closed: make(chan error, 1)}
+
+	scheme := "nats"
+	opts := []nats.Option{
+		nats.ClosedHandler(func(c *nats.Conn) {
+			err := c.LastError()
+			if err == nil {
+				err = nats.ErrConnectionClosed
+			}
+			select {
+			case bus.closed <- err:
+			default:
+			}
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warnf("disconnected from nats broker, reason: %v", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Infof("reconnected to nats broker: %s", c.ConnectedUrlRedacted())
+		}),
+	}
+	if config.User != "" {
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	}
+	// The nats client reconnects by itself, a reconnect is attempted every reconnectMinBackoff with jitter until the
+	// reconnect timeout has passed
+	if config.ReconnectTimeout > 0 {
+		opts = append(opts,
+			nats.ReconnectWait(reconnectMinBackoff),
+			nats.ReconnectJitter(reconnectMinBackoff, reconnectMinBackoff),
+			nats.MaxReconnects(int(config.ReconnectTimeout/reconnectMinBackoff)),
+		)
+	} else {
+		opts = append(opts, nats.NoReconnect())
+	}
+	if config.Ssl {
+		tlsConfig, err := TLSConfigBroker(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+		scheme = "tls"
+	}
+
+	log.Debugf("Connecting to nats broker host: %s:%d with user: %s", config.Host, config.Port, config.User)
+	conn, err := nats.Connect(fmt.Sprintf("%s://%s:%d", scheme, config.Host, config.Port), opts...)
+	if err != nil {
+		return nil, err
+	}
+	bus.conn = conn
+
+	bus.js, err = jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	streamName := config.Stream
+	if streamName == "" {
+		streamName = config.Exchange
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bus.stream, err = bus.js.Stream(ctx, streamName)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		bus.stream, err = bus.js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:      streamName,
+			Subjects:  []string{config.Exchange + ".>"},
+			Retention: jetstream.WorkQueuePolicy,
+			Storage:   jetstream.FileStorage,
+		})
+		// The stream was created by another service in the meantime
+		if errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+			bus.stream, err = bus.js.Stream(ctx, streamName)
+		}
+	}
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("failed to get stream: %s, due to: %v", streamName, err)
+	}
+
+	return bus, nil
+}
+
+// subject returns the subject messages with the routing key are published to in the exchange
+func subject(exchange, routingKey string) string {
+	if exchange == "" {
+		return routingKey
+	}
+
+	return exchange + "." + routingKey
+}
+
+// Consume returns the messages delivered from the queue
+func (bus *NATSBus) Consume(queue string) (<-chan Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	consumerConf := jetstream.ConsumerConfig{
+		Durable:       queue,
+		FilterSubject: subject(bus.Conf.Exchange, queue),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	}
+	if bus.Conf.PrefetchCount > 0 {
+		consumerConf.MaxAckPending = bus.Conf.PrefetchCount
+	}
+	consumer, err := bus.stream.CreateOrUpdateConsumer(ctx, consumerConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer of queue: %s, due to: %v", queue, err)
+	}
+
+	messages, err := consumer.Messages(jetstream.PullMaxMessages(max(bus.Conf.PrefetchCount, 1)))
+	if err != nil {
+		return nil, err
+	}
+	bus.Lock()
+	bus.consumers = append(bus.consumers, messages)
+	bus.Unlock()
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			msg, err := messages.Next()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return
+			}
+			if err != nil {
+				log.Warnf("failed to get next message of queue: %s, reason: %v", queue, err)
+
+				continue
+			}
+			deliveries <- bus.delivery(msg)
+		}
+	}()
+
+	return deliveries, nil
+}
+
+func (bus *NATSBus) delivery(msg jetstream.Msg) Delivery {
+	headers := make(map[string]any, len(msg.Headers()))
+	for key := range msg.Headers() {
+		headers[key] = msg.Headers().Get(key)
+	}
+	var redelivered bool
+	if meta, err := msg.Metadata(); err == nil {
+		redelivered = meta.NumDelivered > 1
+		// JetStream counts the deliveries of a message, which is the attempt at handling it
+		headers[RetryAttemptHeader] = int(meta.NumDelivered) // #nosec G115 -- the number of deliveries is small
+	}
+
+	return Delivery{
+		Body:          msg.Data(),
+		CorrelationID: msg.Headers().Get(CorrelationIDHeader),
+		RoutingKey:    strings.TrimPrefix(msg.Subject(), bus.Conf.Exchange+"."),
+		Headers:       headers,
+		Redelivered:   redelivered,
+		Acknowledger:  &natsAcknowledger{bus: bus, msg: msg},
+	}
+}
+
+// SendMessage publishes the message to the subject of the routing key in the exchange, and waits for the stream to
+// acknowledge it
+func (bus *NATSBus) SendMessage(corrID, exchange, routingKey string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	msg := nats.NewMsg(subject(exchange, routingKey))
+	msg.Header.Set(CorrelationIDHeader, corrID)
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Data = body
+
+	ack, err := bus.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return err
+	}
+	log.Debugf("confirmed delivery with stream sequence: %d", ack.Sequence)
+
+	return nil
+}
+
+// NotifyClose returns a channel which receives an error once the connection has been closed, i.e when the client has
+// given up reconnecting
+func (bus *NATSBus) NotifyClose() <-chan error {
+	return bus.closed
+}
+
+// Close stops the consumers and closes the connection
+func (bus *NATSBus) Close() error {
+	bus.Lock()
+	for _, consumer := range bus.consumers {
+		consumer.Stop()
+	}
+	bus.consumers = nil
+	bus.Unlock()
+
+	bus.conn.Close()
+
+	return nil
+}
+
+type natsAcknowledger struct {
+	bus *NATSBus
+	msg jetstream.Msg
+}
+
+func (a *natsAcknowledger) Ack() error {
+	return a.msg.Ack()
+}
+
+func (a *natsAcknowledger) Nack(requeue bool) error {
+	if requeue {
+		return a.msg.Nak()
+	}
+
+	return a.Reject()
+}
+
+// Reject publishes the message to the dead letter subject, and terminates the delivery of the message
+func (a *natsAcknowledger) Reject() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	deadLetter := nats.NewMsg(subject(a.bus.Conf.Exchange, "dead."+strings.TrimPrefix(a.msg.Subject(), a.bus.Conf.Exchange+".")))
+	deadLetter.Header = a.msg.Headers()
+	deadLetter.Data = a.msg.Data()
+	if _, err := a.bus.js.PublishMsg(ctx, deadLetter); err != nil {
+		return fmt.Errorf("failed to dead-letter message, due to: %v", err)
+	}
+
+	return a.msg.Term()
+}
+
+// requeueAfter negatively acknowledges the message, for JetStream to deliver it again once the delay has passed. The
+// attempt is counted by JetStream.
+func (a *natsAcknowledger) requeueAfter(delay time.Duration, _ int) error {
+	return a.msg.NakWithDelay(delay)
+}
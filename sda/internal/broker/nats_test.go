@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (ts *BrokerTestSuite) natsConf(exchange string) MQConf {
+	return MQConf{
+		Type:          NATS,
+		Host:          "127.0.0.1",
+		Port:          natsPort,
+		Exchange:      exchange,
+		PrefetchCount: 2,
+	}
+}
+
+func (ts *BrokerTestSuite) nextDelivery(deliveries <-chan Delivery) Delivery {
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(10 * time.Second):
+		ts.FailNow("no message delivered")
+	}
+
+	return Delivery{}
+}
+
+func (ts *BrokerTestSuite) TestNewNATS_NoExchange() {
+	_, err := NewNATS(ts.natsConf(""))
+	assert.ErrorContains(ts.T(), err, "exchange is required")
+}
+
+func (ts *BrokerTestSuite) TestNewBus_NATS() {
+	b, err := NewBus(ts.natsConf("newbus"))
+	assert.NoError(ts.T(), err)
+	assert.IsType(ts.T(), &NATSBus{}, b)
+	assert.NoError(ts.T(), b.Close())
+}
+
+func (ts *BrokerTestSuite) TestNATS_SendAndConsume() {
+	b, err := NewNATS(ts.natsConf("consume"))
+	assert.NoError(ts.T(), err)
+	defer b.Close()
+
+	deliveries, err := b.Consume("ingest")
+	assert.NoError(ts.T(), err)
+
+	assert.NoError(ts.T(), b.SendMessage("corr-1", "consume", "ingest", []byte(`{"message": 1}`)))
+	// Messages to other queues are not consumed
+	assert.NoError(ts.T(), b.SendMessage("corr-2", "consume", "archived", []byte(`{"message": 2}`)))
+	assert.NoError(ts.T(), b.SendMessage("corr-3", "consume", "ingest", []byte(`{"message": 3}`)))
+
+	for i, corrID := range []string{"corr-1", "corr-3"} {
+		d := ts.nextDelivery(deliveries)
+		assert.Equal(ts.T(), corrID, d.CorrelationID)
+		assert.Equal(ts.T(), "ingest", d.RoutingKey)
+		assert.Equal(ts.T(), fmt.Sprintf(`{"message": %d}`, 2*i+1), string(d.Body))
+		assert.False(ts.T(), d.Redelivered)
+		assert.NoError(ts.T(), d.Ack())
+	}
+}
+
+func (ts *BrokerTestSuite) TestNATS_NackRequeue() {
+	b, err := NewNATS(ts.natsConf("requeue"))
+	assert.NoError(ts.T(), err)
+	defer b.Close()
+
+	deliveries, err := b.Consume("verify")
+	assert.NoError(ts.T(), err)
+	assert.NoError(ts.T(), b.SendMessage("corr-1", "requeue", "verify", []byte("requeued")))
+
+	d := ts.nextDelivery(deliveries)
+	assert.NoError(ts.T(), d.Nack(true))
+
+	d = ts.nextDelivery(deliveries)
+	assert.Equal(ts.T(), "requeued", string(d.Body))
+	assert.True(ts.T(), d.Redelivered)
+	assert.NoError(ts.T(), d.Ack())
+}
+
+func (ts *BrokerTestSuite) TestNATS_RejectDeadLetters() {
+	b, err := NewNATS(ts.natsConf("reject"))
+	assert.NoError(ts.T(), err)
+	defer b.Close()
+
+	deliveries, err := b.Consume("finalize")
+	assert.NoError(ts.T(), err)
+	dead, err := b.Consume("dead.finalize")
+	assert.NoError(ts.T(), err)
+
+	assert.NoError(ts.T(), b.SendMessage("corr-1", "reject", "finalize", []byte("rejected")))
+
+	d := ts.nextDelivery(deliveries)
+	assert.NoError(ts.T(), d.Reject())
+
+	d = ts.nextDelivery(dead)
+	assert.Equal(ts.T(), "rejected", string(d.Body))
+	assert.Equal(ts.T(), "corr-1", d.CorrelationID)
+	assert.Equal(ts.T(), "dead.finalize", d.RoutingKey)
+	assert.NoError(ts.T(), d.Ack())
+
+	select {
+	case d := <-deliveries:
+		ts.Failf("rejected message was delivered again", "message: %s", d.Body)
+	case <-time.After(time.Second):
+	}
+}
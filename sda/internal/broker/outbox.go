@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxBatchSize is the number of messages relayed from the outbox per transaction
+const outboxBatchSize = 100
+
+// Outbox holds messages which have been committed together with a database state change, and are yet to be published
+type Outbox interface {
+	// RelayOutbox passes up to limit pending messages, oldest first, to publish and removes the ones published. The
+	// number of messages published is returned.
+	RelayOutbox(ctx context.Context, limit int, publish func(corrID, exchange, routingKey string, body []byte) error) (int, error)
+}
+
+// OutboxRelay publishes the pending messages of an outbox to a bus. Messages are published with SendMessage, which
+// waits for the broker to confirm each message, and are only removed from the outbox once confirmed. A message may
+// therefore be published more than once if the relay is interrupted.
+type OutboxRelay struct {
+	outbox   Outbox
+	bus      Bus
+	interval time.Duration
+	wake     chan struct{}
+}
+
+// NewOutboxRelay returns a relay which polls the outbox every interval, and whenever Notify is called
+func NewOutboxRelay(outbox Outbox, bus Bus, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:   outbox,
+		bus:      bus,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay up to publish messages just added to the outbox, without waiting for the next poll
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the outbox until the context is canceled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.relay(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// relay publishes batches of pending messages until the outbox is empty, or a message fails to be published
+func (r *OutboxRelay) relay(ctx context.Context) {
+	for ctx.Err() == nil {
+		published, err := r.outbox.RelayOutbox(ctx, outboxBatchSize, r.bus.SendMessage)
+		if published > 0 {
+			log.Debugf("relayed %d messages from the outbox", published)
+		}
+		if err != nil {
+			log.Errorf("failed to relay outbox, will retry, reason: %v", err)
+
+			return
+		}
+		if published < outboxBatchSize {
+			return
+		}
+	}
+}
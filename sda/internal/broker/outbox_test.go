@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type fakeOutbox struct {
+	sync.Mutex
+	pending []MemoryMessage
+	// failures is the number of publish attempts that fail before messages are published
+	failures int
+}
+
+func (o *fakeOutbox) RelayOutbox(_ context.Context, limit int, publish func(corrID, exchange, routingKey string, body []byte) error) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	published := 0
+	for len(o.pending) > 0 && published < limit {
+		if o.failures > 0 {
+			o.failures--
+
+			return published, errors.New("publish failed")
+		}
+		m := o.pending[0]
+		if err := publish(m.CorrelationID, m.Exchange, m.RoutingKey, m.Body); err != nil {
+			return published, err
+		}
+		o.pending = o.pending[1:]
+		published++
+	}
+
+	return published, nil
+}
+
+func (o *fakeOutbox) add(count int) {
+	o.Lock()
+	defer o.Unlock()
+
+	for i := range count {
+		o.pending = append(o.pending, MemoryMessage{CorrelationID: fmt.Sprintf("corr-%d", i), Exchange: "sda", RoutingKey: "archived", Body: []byte("{}")})
+	}
+}
+
+func (o *fakeOutbox) failing() bool {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.failures > 0
+}
+
+func (ts *MemoryBusTestSuite) TestOutboxRelay() {
+	ts.bus.Declare("archived")
+	ts.bus.Bind("sda", "archived", "archived")
+	outbox := &fakeOutbox{failures: 1}
+	outbox.add(outboxBatchSize + 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := NewOutboxRelay(outbox, ts.bus, time.Hour)
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	// The first relay fails, the messages are published once woken up, in more than one batch
+	ts.Eventually(func() bool { return !outbox.failing() }, 5*time.Second, 10*time.Millisecond)
+	relay.Notify()
+	ts.Eventually(func() bool { return len(ts.bus.Messages("archived")) == outboxBatchSize+1 }, 5*time.Second, 10*time.Millisecond)
+	ts.Equal("corr-0", ts.bus.Messages("archived")[0].CorrelationID)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		ts.Fail("relay did not stop when the context was canceled")
+	}
+}
@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Bounds of the backoff between attempts to reconnect, the backoff doubles for every failed attempt and the wait
+// before an attempt is drawn at random up to the backoff
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// reconnectOnClose makes the broker reconnect whenever the connection or the channel is closed by anything but Close.
+// Consumers are declared again on the new channel, see Consume, and messages not confirmed when the connection was
+// lost are published again, see SendMessage. If the broker fails to reconnect within the reconnect timeout the error
+// is sent to the channel returned by NotifyClose.
+func (broker *AMQPBroker) reconnectOnClose() {
+	broker.reconnects = true
+
+	go func() {
+		for {
+			broker.mu.RLock()
+			connClosed := broker.Connection.NotifyClose(make(chan *amqp.Error, 1))
+			channelClosed := broker.Channel.NotifyClose(make(chan *amqp.Error, 1))
+			broker.mu.RUnlock()
+
+			var reason *amqp.Error
+			select {
+			case <-broker.done:
+				return
+			case reason = <-connClosed:
+			case reason = <-channelClosed:
+			}
+			if broker.isClosed() {
+				return
+			}
+
+			log.Warnf("lost connection to broker, reason: %v, reconnecting", reason)
+			if err := broker.reconnect(); err != nil {
+				log.Errorf("failed to reconnect to broker, reason: %v", err)
+				broker.notifyLost(err)
+
+				return
+			}
+			log.Infof("reconnected to broker host: %s:%d", broker.Conf.Host, broker.Conf.Port)
+		}
+	}()
+}
+
+// reconnect closes the current connection, and connects again with a jittered exponential backoff until connected,
+// closed or the reconnect timeout has passed
+func (broker *AMQPBroker) reconnect() error {
+	broker.mu.RLock()
+	connection := broker.Connection
+	broker.mu.RUnlock()
+	if !connection.IsClosed() {
+		_ = connection.Close()
+	}
+
+	deadline := time.Now().Add(broker.Conf.ReconnectTimeout)
+	backoff := reconnectMinBackoff
+	for attempt := 1; ; attempt++ {
+		// #nosec G404 -- the jitter does not need a secure random source
+		wait := rand.N(backoff)
+		select {
+		case <-broker.done:
+			return amqp.ErrClosed
+		case <-time.After(wait):
+		}
+
+		err := broker.connect()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up reconnecting after %d attempts due to: %v", attempt, err)
+		}
+		log.Warnf("failed to reconnect to broker (attempt: %d), reason: %v", attempt, err)
+		backoff = min(2*backoff, reconnectMaxBackoff)
+	}
+}
+
+// waitForNewChannel waits until the channel of the broker has been replaced by an open channel
+func (broker *AMQPBroker) waitForNewChannel(ctx context.Context, previous *amqp.Channel) error {
+	for {
+		broker.mu.RLock()
+		channel, changed := broker.Channel, broker.changed
+		broker.mu.RUnlock()
+		if channel != previous && !channel.IsClosed() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-broker.done:
+			return amqp.ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifyLost sends the error to the channel returned by NotifyClose, unless an error has already been sent
+func (broker *AMQPBroker) notifyLost(err error) {
+	select {
+	case broker.lost <- err:
+	default:
+	}
+}
@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryAttemptHeader is the message header holding which attempt at handling the message a delivery is, a message
+// without the header is on its first attempt
+const RetryAttemptHeader = "x-sda-attempt"
+
+// ParkingLot is the routing key, and the queue, of messages which have run out of retry attempts
+const ParkingLot = "parked"
+
+// RetryPolicy is the retry budget of messages which fail to be handled
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts at handling a message before it is parked
+	MaxAttempts int
+	// InitialDelay is the delay before the second attempt, the delay doubles for every following attempt
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between attempts
+	MaxDelay time.Duration
+}
+
+// ParkedMessage is published to the parking lot when a message has run out of retry attempts
+type ParkedMessage struct {
+	InfoError
+	CorrelationID string `json:"correlation-id"`
+	// Queue is the queue the message was consumed from, and is replayed to
+	Queue    string `json:"queue"`
+	Attempts int    `json:"attempts"`
+}
+
+// delayedRequeuer is implemented by the acknowledgers of buses which can deliver a message again after a delay
+type delayedRequeuer interface {
+	// requeueAfter delivers the message again, as the attempt, once the delay has passed, and acknowledges the delivery
+	requeueAfter(delay time.Duration, attempt int) error
+}
+
+// Attempt returns which attempt at handling the message the delivery is, starting at one
+func Attempt(delivered Delivery) int {
+	switch attempt := delivered.Headers[RetryAttemptHeader].(type) {
+	case int:
+		return attempt
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case string:
+		if n, err := strconv.Atoi(attempt); err == nil {
+			return n
+		}
+	}
+
+	return 1
+}
+
+// Delay returns the delay before the attempt following the given attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+
+	return delay
+}
+
+// Retry handles a delivery which failed to be handled due to cause, according to the retry policy of the config.
+//
+// If the message has attempts left it is delivered again once the delay of the attempt has passed, and otherwise a
+// ParkedMessage is published to the parking lot of the exchange. The delivery is acknowledged once requeued or parked.
+// Buses which can not delay messages requeue them immediately.
+func Retry(bus Bus, conf MQConf, delivered Delivery, cause error) error {
+	attempt := Attempt(delivered)
+	if attempt < conf.Retry.MaxAttempts {
+		requeuer, ok := delivered.Acknowledger.(delayedRequeuer)
+		if !ok {
+			return delivered.Nack(true)
+		}
+
+		delay := conf.Retry.Delay(attempt)
+		log.Infof("retrying message (correlation-id: %s) in %v, attempt %d of %d failed, reason: %v", delivered.CorrelationID, delay, attempt, conf.Retry.MaxAttempts, cause)
+		if err := requeuer.requeueAfter(delay, attempt+1); err != nil {
+			log.Errorf("failed to requeue message (correlation-id: %s) with delay, reason: %v", delivered.CorrelationID, err)
+
+			return delivered.Nack(true)
+		}
+
+		return nil
+	}
+
+	parked, err := json.Marshal(ParkedMessage{
+		InfoError: InfoError{
+			Error:           fmt.Sprintf("message could not be handled in %d attempts", attempt),
+			Reason:          cause.Error(),
+			OriginalMessage: string(delivered.Body),
+		},
+		CorrelationID: delivered.CorrelationID,
+		Queue:         conf.Queue,
+		Attempts:      attempt,
+	})
+	if err != nil {
+		return err
+	}
+	if err := bus.SendMessage(delivered.CorrelationID, conf.Exchange, ParkingLot, parked); err != nil {
+		if err := delivered.Nack(true); err != nil {
+			log.Errorf("failed to Nack message, reason: %v", err)
+		}
+
+		return fmt.Errorf("failed to park message (correlation-id: %s) due to: %v", delivered.CorrelationID, err)
+	}
+	log.Warnf("parked message (correlation-id: %s) after %d attempts, reason: %v", delivered.CorrelationID, attempt, cause)
+
+	return delivered.Ack()
+}
+
+// declareDelayQueue declares the queue messages of the queue are delayed in, messages expire from the delay queue once
+// the delay has passed and are then dead-lettered back to the queue
+func (broker *AMQPBroker) declareDelayQueue(queue string, delay time.Duration) (string, error) {
+	name := fmt.Sprintf("%s.delay.%d", queue, delay.Milliseconds())
+	_, err := broker.channel().QueueDeclare(
+		name,
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to declare delay queue: %s due to: %v", name, err)
+	}
+
+	return name, nil
+}
+
+// getParked gets all messages of the parking lot on a channel of its own, the messages are requeued once the channel
+// is closed unless acknowledged
+func (broker *AMQPBroker) getParked() (*amqp.Channel, []amqp.Delivery, error) {
+	broker.mu.RLock()
+	channel, err := broker.Connection.Channel()
+	broker.mu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deliveries []amqp.Delivery
+	for {
+		delivery, ok, err := channel.Get(ParkingLot, false)
+		if err != nil {
+			_ = channel.Close()
+
+			return nil, nil, fmt.Errorf("failed to get parked messages due to: %v", err)
+		}
+		if !ok {
+			return channel, deliveries, nil
+		}
+		deliveries = append(deliveries, delivery)
+	}
+}
+
+// ParkedMessages returns the messages of the parking lot, without removing them. Messages being replayed concurrently
+// are not returned.
+func (broker *AMQPBroker) ParkedMessages() ([]ParkedMessage, error) {
+	channel, deliveries, err := broker.getParked()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	parked := make([]ParkedMessage, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		var message ParkedMessage
+		if err := json.Unmarshal(delivery.Body, &message); err != nil {
+			log.Warnf("failed to unmarshal parked message (correlation-id: %s), reason: %v", delivery.CorrelationId, err)
+
+			continue
+		}
+		parked = append(parked, message)
+	}
+
+	return parked, nil
+}
+
+// ReplayParked publishes the parked messages selected by replay to the queue they were parked from, with a new retry
+// budget, and removes them from the parking lot. The number of messages replayed is returned.
+func (broker *AMQPBroker) ReplayParked(replay func(ParkedMessage) bool) (int, error) {
+	channel, deliveries, err := broker.getParked()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	replayed := 0
+	for _, delivery := range deliveries {
+		var message ParkedMessage
+		if err := json.Unmarshal(delivery.Body, &message); err != nil || !replay(message) {
+			continue
+		}
+		original, ok := message.OriginalMessage.(string)
+		if !ok || message.Queue == "" {
+			log.Warnf("parked message (correlation-id: %s) can not be replayed, original message or queue missing", message.CorrelationID)
+
+			continue
+		}
+
+		// The default exchange routes the message directly to the queue
+		if err := broker.SendMessage(message.CorrelationID, "", message.Queue, []byte(original)); err != nil {
+			return replayed, fmt.Errorf("failed to replay message (correlation-id: %s) due to: %v", message.CorrelationID, err)
+		}
+		if err := delivery.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to remove replayed message (correlation-id: %s) from the parking lot due to: %v", message.CorrelationID, err)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
@@ -0,0 +1,52 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func (ts *MemoryBusTestSuite) TestRetryPolicyDelay() {
+	policy := RetryPolicy{MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		ts.Equal(expected, policy.Delay(attempt+1), "attempt: %d", attempt+1)
+	}
+}
+
+func (ts *MemoryBusTestSuite) TestAttempt() {
+	ts.Equal(1, Attempt(Delivery{Headers: map[string]any{}}))
+	ts.Equal(3, Attempt(Delivery{Headers: map[string]any{RetryAttemptHeader: int32(3)}}))
+	ts.Equal(4, Attempt(Delivery{Headers: map[string]any{RetryAttemptHeader: "4"}}))
+}
+
+func (ts *MemoryBusTestSuite) TestRetry() {
+	ts.bus.Bind("sda", "verified", "verified")
+	ts.bus.Bind("sda", ParkingLot, ParkingLot)
+	conf := MQConf{Exchange: "sda", Queue: "verified", Retry: RetryPolicy{MaxAttempts: 2, InitialDelay: 10 * time.Millisecond}}
+	ts.NoError(ts.bus.SendMessage("corr-1", "sda", "verified", []byte(`{"message":1}`)))
+
+	deliveries, err := ts.bus.Consume("verified")
+	ts.Require().NoError(err)
+
+	d := ts.next(deliveries)
+	ts.Equal(1, Attempt(d))
+	ts.NoError(Retry(ts.bus, conf, d, errors.New("database unavailable")))
+	ts.Empty(ts.bus.Messages(ParkingLot))
+
+	// The message is delivered again once the delay has passed
+	d = ts.next(deliveries)
+	ts.Equal(2, Attempt(d))
+	ts.Equal("corr-1", d.CorrelationID)
+	ts.NoError(Retry(ts.bus, conf, d, errors.New("database unavailable")))
+
+	parked := ts.bus.Messages(ParkingLot)
+	ts.Require().Len(parked, 1)
+	var message ParkedMessage
+	ts.Require().NoError(json.Unmarshal(parked[0].Body, &message))
+	ts.Equal("corr-1", message.CorrelationID)
+	ts.Equal("verified", message.Queue)
+	ts.Equal(2, message.Attempts)
+	ts.Equal("database unavailable", message.Reason)
+	ts.Equal(`{"message":1}`, message.OriginalMessage)
+	ts.Empty(ts.bus.Messages("verified"))
+}
@@ -5,10 +5,13 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/sensitive-data-archive/internal/audit"
 	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/pkg/errors"
@@ -34,6 +37,7 @@ type Config struct {
 	Database     database.DBConf
 	Server       ServerConfig
 	S3Inbox      S3InboxConf
+	SFTPInbox    SFTPInboxConf
 	API          APIConf
 	Notify       SMTPConf
 	Orchestrator OrchestratorConf
@@ -42,6 +46,8 @@ type Config struct {
 	ReEncrypt    ReEncConfig
 	Auth         AuthConf
 	RotateKey    RotateKeyConf
+	Scrub        ScrubConf
+	Audit        audit.Config
 }
 
 type Grpc struct {
@@ -67,6 +73,19 @@ type RotateKeyConf struct {
 	PublicKey *[32]byte
 }
 
+type ScrubConf struct {
+	// Mode is either "message", to have the verify service re-verify the files, or "inline"
+	Mode string
+	// Rate is the maximum number of archived bytes scrubbed per second
+	Rate uint64
+	// BatchSize is the number of files fetched from the database at the time
+	BatchSize int
+	// Interval is how long to wait before looking for files to scrub when none are due
+	Interval time.Duration
+	// ReverifyAfter is how long after being scrubbed a file is due to be scrubbed again
+	ReverifyAfter time.Duration
+}
+
 type Sync struct {
 	CenterPrefix   string
 	RemoteHost     string
@@ -92,19 +111,46 @@ type S3InboxConf struct {
 	Region    string `mapstructure:"region"`
 	CaCert    string `mapstructure:"ca_cert"`
 	ReadyPath string `mapstructure:"ready_path"`
+	// ValidateHeader rejects uploads whose crypt4gh header can not be decrypted with one of the C4ghPrivateKeyList
+	ValidateHeader     bool        `mapstructure:"validate_header"`
+	C4ghPrivateKeyList []*[32]byte `mapstructure:"-"`
 }
+
+// SFTPInboxConf is the configuration of the sftp inbox
+type SFTPInboxConf struct {
+	// Port is the port the sftp server listens on
+	Port int
+	// HostKey is the path of the private host key of the sftp server
+	HostKey string
+	// Cega is the CEGA user database the users are authenticated against
+	Cega CegaConfig
+	// ValidateHeader rejects uploads whose crypt4gh header can not be decrypted with one of the C4ghPrivateKeyList
+	ValidateHeader     bool
+	C4ghPrivateKeyList []*[32]byte
+}
+
 type APIConf struct {
-	RBACpolicy  []byte
-	CACert      string
-	ServerCert  string
-	ServerKey   string
-	Host        string
-	Port        int
-	Session     SessionConfig
-	DB          *database.SDAdb
-	MQ          *broker.AMQPBroker
-	Grpc        Grpc
-	AuditLogger *log.Logger
+	RBACpolicy []byte
+	CACert     string
+	ServerCert string
+	ServerKey  string
+	Host       string
+	Port       int
+	Session    SessionConfig
+	DB         *database.SDAdb
+	MQ         *broker.AMQPBroker
+	Grpc       Grpc
+	Pipeline   PipelineStatusConf
+}
+
+// PipelineStatusConf configures the pipeline status endpoint
+type PipelineStatusConf struct {
+	// StuckAfter is how long a file can stay in a state before it is reported as stuck, per file event
+	StuckAfter map[string]time.Duration
+	// MaxStuckFiles is the maximum number of stuck files listed per state
+	MaxStuckFiles int
+	// Queues are the broker queues to report the depth of
+	Queues []string
 }
 
 type SessionConfig struct {
@@ -355,6 +401,26 @@ func NewConfig(app string) (*Config, error) {
 			"s3inbox.bucket",
 			"s3inbox.region",
 		}
+	case "sftpinbox":
+		requiredConfVars = []string{
+			"broker.host",
+			"broker.port",
+			"broker.user",
+			"broker.password",
+			"broker.routingkey",
+			"sftpinbox.hostKey",
+			"sftpinbox.cega.authUrl",
+			"sftpinbox.cega.id",
+			"sftpinbox.cega.secret",
+		}
+	case "scrub":
+		requiredConfVars = []string{
+			"db.host",
+			"db.port",
+			"db.user",
+			"db.password",
+			"db.database",
+		}
 	case "sync":
 		requiredConfVars = []string{
 			"broker.host",
@@ -428,6 +494,14 @@ func NewConfig(app string) (*Config, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		// api.audit predates audit.sinks and still enables audit events on stdout
+		if !viper.IsSet("audit.sinks") && viper.GetBool("api.audit") {
+			viper.Set("audit.sinks", []string{"stdout"})
+		}
+		if err := c.configAudit(app); err != nil {
+			return nil, err
+		}
 	case "auth":
 		c.Auth.Cega.AuthURL = viper.GetString("auth.cega.authUrl")
 		c.Auth.Cega.ID = viper.GetString("auth.cega.id")
@@ -563,10 +637,61 @@ func NewConfig(app string) (*Config, error) {
 			return nil, fmt.Errorf("failed to parse key configurations: %v", err)
 		}
 
+		if c.S3Inbox.ValidateHeader {
+			c.S3Inbox.C4ghPrivateKeyList, err = GetC4GHprivateKeys()
+			if err != nil {
+				return nil, err
+			}
+			if len(c.S3Inbox.C4ghPrivateKeyList) == 0 {
+				return nil, errors.New("s3inbox.validate_header requires c4gh.privateKeys to be set")
+			}
+		}
+
 		err = c.configServer()
 		if err != nil {
 			return nil, err
 		}
+
+		if err := c.configAudit(app); err != nil {
+			return nil, err
+		}
+	case "sftpinbox":
+		if err := c.configBroker(); err != nil {
+			return nil, err
+		}
+
+		if err := c.configDatabase(); err != nil {
+			return nil, err
+		}
+
+		if err := c.configSFTPInbox(); err != nil {
+			return nil, err
+		}
+
+		if err := c.configAudit(app); err != nil {
+			return nil, err
+		}
+	case "scrub":
+		if err := c.configScrub(); err != nil {
+			return nil, err
+		}
+
+		if c.Scrub.Mode == "message" {
+			for _, s := range []string{"broker.host", "broker.port", "broker.user", "broker.password"} {
+				if !viper.IsSet(s) {
+					return nil, fmt.Errorf("%s not set", s)
+				}
+			}
+
+			if err := c.configBroker(); err != nil {
+				return nil, err
+			}
+			c.configSchemas()
+		}
+
+		if err := c.configDatabase(); err != nil {
+			return nil, err
+		}
 	case "sync":
 		if err := c.configBroker(); err != nil {
 			return nil, err
@@ -615,12 +740,20 @@ func (c *Config) configAPI() error {
 	api.ServerKey = viper.GetString("api.serverKey")
 	api.ServerCert = viper.GetString("api.serverCert")
 	api.CACert = viper.GetString("api.CACert")
-	if viper.GetBool("api.audit") {
-		api.AuditLogger = log.New()
-		api.AuditLogger.SetFormatter(&log.JSONFormatter{})
-		api.AuditLogger.SetOutput(os.Stdout)
-		api.AuditLogger.SetLevel(log.InfoLevel)
+
+	api.Pipeline.StuckAfter = make(map[string]time.Duration)
+	for _, state := range []string{"uploaded", "submitted", "archived", "verified"} {
+		stuckAfter := viper.GetDuration("api.pipelineStatus.stuckAfter." + state)
+		if stuckAfter < 0 {
+			return fmt.Errorf("api.pipelineStatus.stuckAfter.%s can not be negative", state)
+		}
+		api.Pipeline.StuckAfter[state] = stuckAfter
+	}
+	api.Pipeline.MaxStuckFiles = viper.GetInt("api.pipelineStatus.maxStuckFiles")
+	if api.Pipeline.MaxStuckFiles < 1 {
+		return errors.New("api.pipelineStatus.maxStuckFiles must be at least 1")
 	}
+	api.Pipeline.Queues = viper.GetStringSlice("api.pipelineStatus.queues")
 
 	c.API = api
 
@@ -636,6 +769,13 @@ func (c *Config) apiDefaults() {
 	viper.SetDefault("api.session.httponly", true)
 	viper.SetDefault("api.session.name", "api_session_key")
 	viper.SetDefault("api.audit", true)
+	// Files wait in uploaded for the submitter and in verified for an accession ID, so those thresholds are longer
+	viper.SetDefault("api.pipelineStatus.stuckAfter.uploaded", "168h")
+	viper.SetDefault("api.pipelineStatus.stuckAfter.submitted", "1h")
+	viper.SetDefault("api.pipelineStatus.stuckAfter.archived", "1h")
+	viper.SetDefault("api.pipelineStatus.stuckAfter.verified", "72h")
+	viper.SetDefault("api.pipelineStatus.maxStuckFiles", 100)
+	viper.SetDefault("api.pipelineStatus.queues", []string{"inbox", "ingest", "archived", "verified", "accession", "mappings", "rotatekey", "catch_all.dead", broker.ParkingLot})
 }
 
 // configBroker provides configuration for the message broker
@@ -643,6 +783,21 @@ func (c *Config) configBroker() error {
 	// Setup broker
 	mq := broker.MQConf{}
 
+	mq.Type = broker.RabbitMQ
+	if viper.IsSet("broker.type") {
+		mq.Type = viper.GetString("broker.type")
+	}
+	switch mq.Type {
+	case broker.RabbitMQ:
+	case broker.NATS:
+		if !viper.IsSet("broker.exchange") {
+			return errors.New("broker.exchange is required when broker.type is nats")
+		}
+		mq.Stream = viper.GetString("broker.stream")
+	default:
+		return fmt.Errorf("broker.type: %s is not supported, supported values are %s and %s", mq.Type, broker.RabbitMQ, broker.NATS)
+	}
+
 	mq.Host = viper.GetString("broker.host")
 	mq.Port = viper.GetInt("broker.port")
 	mq.User = viper.GetString("broker.user")
@@ -696,6 +851,22 @@ func (c *Config) configBroker() error {
 		mq.PrefetchCount = viper.GetInt("broker.prefetchCount")
 	}
 
+	mq.ReconnectTimeout = 5 * time.Minute
+	if viper.IsSet("broker.reconnectTimeout") {
+		mq.ReconnectTimeout = time.Duration(viper.GetInt("broker.reconnectTimeout")) * time.Second
+	}
+
+	mq.Retry = broker.RetryPolicy{MaxAttempts: 5, InitialDelay: 10 * time.Second, MaxDelay: 10 * time.Minute}
+	if viper.IsSet("broker.retry.attempts") {
+		mq.Retry.MaxAttempts = viper.GetInt("broker.retry.attempts")
+	}
+	if viper.IsSet("broker.retry.delay") {
+		mq.Retry.InitialDelay = time.Duration(viper.GetInt("broker.retry.delay")) * time.Second
+	}
+	if viper.IsSet("broker.retry.maxDelay") {
+		mq.Retry.MaxDelay = time.Duration(viper.GetInt("broker.retry.maxDelay")) * time.Second
+	}
+
 	c.Broker = mq
 
 	return nil
@@ -888,6 +1059,47 @@ func (c *Config) configSchemas() {
 	}
 }
 
+// configAudit provides configuration for the audit sinks, the amqp sink
+// publishes on the broker connection of the service
+func (c *Config) configAudit(app string) error {
+	viper.SetDefault("audit.file.maxSize", 100)
+	viper.SetDefault("audit.file.maxBackups", 10)
+	viper.SetDefault("audit.syslog.network", "udp")
+	viper.SetDefault("audit.syslog.appName", "sda-"+app)
+	viper.SetDefault("audit.amqp.exchange", "sda.audit")
+	viper.SetDefault("audit.amqp.routingKey", app+".audit")
+
+	// AUDIT_SINKS is given as a comma separated list
+	sinks := strings.FieldsFunc(strings.Join(viper.GetStringSlice("audit.sinks"), ","), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	c.Audit = audit.Config{
+		Required: viper.GetBool("audit.required"),
+		Sinks:    sinks,
+		File: audit.FileConfig{
+			Path:       viper.GetString("audit.file.path"),
+			MaxSize:    viper.GetInt64("audit.file.maxSize") * 1024 * 1024,
+			MaxBackups: viper.GetInt("audit.file.maxBackups"),
+		},
+		Syslog: audit.SyslogConfig{
+			Network: viper.GetString("audit.syslog.network"),
+			Address: viper.GetString("audit.syslog.address"),
+			AppName: viper.GetString("audit.syslog.appName"),
+		},
+	}
+
+	if slices.Contains(c.Audit.Sinks, "amqp") {
+		if c.Broker.Type != broker.RabbitMQ {
+			return errors.New("the amqp audit sink requires broker.type rabbitmq")
+		}
+		c.Audit.AMQP = c.Broker
+		c.Audit.AMQP.Exchange = viper.GetString("audit.amqp.exchange")
+		c.Audit.AMQP.RoutingKey = viper.GetString("audit.amqp.routingKey")
+	}
+
+	return c.Audit.Validate()
+}
+
 // configNotify provides configuration for the backup storage
 func (c *Config) configSMTP() {
 	c.Notify = SMTPConf{}
@@ -897,6 +1109,63 @@ func (c *Config) configSMTP() {
 	c.Notify.FromAddr = viper.GetString("smtp.from")
 }
 
+// configScrub provides configuration for the scrub service
+func (c *Config) configScrub() error {
+	viper.SetDefault("scrub.mode", "message")
+	viper.SetDefault("scrub.rate", "50MB")
+	viper.SetDefault("scrub.batchSize", 100)
+	viper.SetDefault("scrub.interval", 3600)
+	viper.SetDefault("scrub.reverifyAfter", 30)
+
+	c.Scrub.Mode = viper.GetString("scrub.mode")
+	if c.Scrub.Mode != "message" && c.Scrub.Mode != "inline" {
+		return fmt.Errorf("scrub.mode: %s is not supported, supported values are message and inline", c.Scrub.Mode)
+	}
+
+	rate, err := datasize.ParseString(viper.GetString("scrub.rate"))
+	if err != nil {
+		return fmt.Errorf("failed to parse scrub.rate, due to: %v", err)
+	}
+	if rate.Bytes() == 0 {
+		return errors.New("scrub.rate can not be 0")
+	}
+	c.Scrub.Rate = rate.Bytes()
+
+	c.Scrub.BatchSize = viper.GetInt("scrub.batchSize")
+	if c.Scrub.BatchSize <= 0 {
+		return errors.New("scrub.batchSize must be greater than 0")
+	}
+	c.Scrub.Interval = time.Duration(viper.GetInt("scrub.interval")) * time.Second
+	c.Scrub.ReverifyAfter = time.Duration(viper.GetInt("scrub.reverifyAfter")) * 24 * time.Hour
+
+	return nil
+}
+
+// configSFTPInbox provides configuration for the sftp inbox
+func (c *Config) configSFTPInbox() error {
+	viper.SetDefault("sftpinbox.port", 2222)
+
+	c.SFTPInbox.Port = viper.GetInt("sftpinbox.port")
+	c.SFTPInbox.HostKey = viper.GetString("sftpinbox.hostKey")
+	c.SFTPInbox.Cega.AuthURL = viper.GetString("sftpinbox.cega.authUrl")
+	c.SFTPInbox.Cega.ID = viper.GetString("sftpinbox.cega.id")
+	c.SFTPInbox.Cega.Secret = viper.GetString("sftpinbox.cega.secret")
+	c.SFTPInbox.ValidateHeader = viper.GetBool("sftpinbox.validateHeader")
+
+	if c.SFTPInbox.ValidateHeader {
+		var err error
+		c.SFTPInbox.C4ghPrivateKeyList, err = GetC4GHprivateKeys()
+		if err != nil {
+			return err
+		}
+		if len(c.SFTPInbox.C4ghPrivateKeyList) == 0 {
+			return errors.New("sftpinbox.validateHeader requires c4gh.privateKeys to be set")
+		}
+	}
+
+	return nil
+}
+
 // configSync provides configuration for the sync destination storage
 func (c *Config) configSync() error {
 	c.Sync.RemoteHost = viper.GetString("sync.remote.host")
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
 	"github.com/neicnordic/sensitive-data-archive/internal/helper"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -164,6 +165,38 @@ func (ts *ConfigTestSuite) TestConfigS3Storage() {
 	assert.Equal(ts.T(), "testregion", config.S3Inbox.Region)
 }
 
+func (ts *ConfigTestSuite) TestConfigS3InboxC4ghKeys() {
+	viper.Set("s3inbox.endpoint", "mock-value")
+	viper.Set("s3inbox.access_key", "mock-value")
+	viper.Set("s3inbox.secret_key", "mock-value")
+	viper.Set("s3inbox.bucket", "mock-value")
+	viper.Set("s3inbox.region", "mock-value")
+	keyPath, _ := os.MkdirTemp("", "key")
+	defer os.RemoveAll(keyPath)
+	_, err := helper.CreatePrivateKeyFile(keyPath+"/c4gh.key", "test")
+	assert.NoError(ts.T(), err)
+	viper.Set("c4gh.privateKeys", []C4GHprivateKeyConf{{FilePath: keyPath + "/c4gh.key", Passphrase: "test"}})
+
+	// The keys are only read when the header is validated
+	config, err := NewConfig("s3inbox")
+	assert.NoError(ts.T(), err)
+	assert.False(ts.T(), config.S3Inbox.ValidateHeader)
+	assert.Empty(ts.T(), config.S3Inbox.C4ghPrivateKeyList)
+
+	viper.Set("s3inbox.validate_header", true)
+	config, err = NewConfig("s3inbox")
+	assert.NoError(ts.T(), err)
+	assert.Len(ts.T(), config.S3Inbox.C4ghPrivateKeyList, 1)
+
+	viper.Set("c4gh.privateKeys", []C4GHprivateKeyConf{{FilePath: keyPath + "/c4gh.key", Passphrase: "wrong"}})
+	_, err = NewConfig("s3inbox")
+	assert.Error(ts.T(), err)
+
+	viper.Set("c4gh.privateKeys", []C4GHprivateKeyConf{})
+	_, err = NewConfig("s3inbox")
+	assert.ErrorContains(ts.T(), err, "requires c4gh.privateKeys")
+}
+
 func (ts *ConfigTestSuite) TestConfigBroker() {
 	viper.Set("s3inbox.endpoint", "mock-value")
 	viper.Set("s3inbox.access_key", "mock-value")
@@ -190,6 +223,42 @@ func (ts *ConfigTestSuite) TestConfigBroker() {
 	assert.NotNil(ts.T(), config)
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), "/", config.Broker.Vhost)
+	assert.Equal(ts.T(), 5*time.Minute, config.Broker.ReconnectTimeout)
+
+	viper.Set("broker.reconnectTimeout", 0)
+	config, err = NewConfig("s3inbox")
+	assert.NoError(ts.T(), err)
+	assert.Zero(ts.T(), config.Broker.ReconnectTimeout)
+	assert.Equal(ts.T(), broker.RetryPolicy{MaxAttempts: 5, InitialDelay: 10 * time.Second, MaxDelay: 10 * time.Minute}, config.Broker.Retry)
+
+	viper.Set("broker.retry.attempts", 3)
+	viper.Set("broker.retry.delay", 1)
+	viper.Set("broker.retry.maxDelay", 60)
+	config, err = NewConfig("s3inbox")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), broker.RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute}, config.Broker.Retry)
+}
+
+func (ts *ConfigTestSuite) TestConfigBroker_Type() {
+	viper.Set("inbox.type", nil)
+	config, err := NewConfig("finalize")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "rabbitmq", config.Broker.Type)
+
+	viper.Set("broker.type", "nats")
+	viper.Set("broker.stream", "teststream")
+	config, err = NewConfig("finalize")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "nats", config.Broker.Type)
+	assert.Equal(ts.T(), "teststream", config.Broker.Stream)
+
+	viper.Set("broker.exchange", nil)
+	_, err = NewConfig("finalize")
+	assert.EqualError(ts.T(), err, "broker.exchange is required when broker.type is nats")
+
+	viper.Set("broker.type", "kafka")
+	_, err = NewConfig("finalize")
+	assert.EqualError(ts.T(), err, "broker.type: kafka is not supported, supported values are rabbitmq and nats")
 }
 
 func (ts *ConfigTestSuite) TestTLSConfigBroker() {
@@ -284,6 +353,35 @@ func (ts *ConfigTestSuite) TestAPIConfiguration() {
 	assert.Equal(ts.T(), 60*time.Second, config.API.Session.Expiration)
 }
 
+func (ts *ConfigTestSuite) TestAPIPipelineStatusConfiguration() {
+	viper.Reset()
+	ts.SetupTest()
+	config, err := NewConfig("api")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 168*time.Hour, config.API.Pipeline.StuckAfter["uploaded"])
+	assert.Equal(ts.T(), time.Hour, config.API.Pipeline.StuckAfter["submitted"])
+	assert.Equal(ts.T(), 100, config.API.Pipeline.MaxStuckFiles)
+	assert.Contains(ts.T(), config.API.Pipeline.Queues, "verified")
+
+	viper.Set("api.pipelineStatus.stuckAfter.verified", "30m")
+	viper.Set("api.pipelineStatus.stuckAfter.uploaded", "0")
+	viper.Set("api.pipelineStatus.queues", "ingest verified")
+	config, err = NewConfig("api")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 30*time.Minute, config.API.Pipeline.StuckAfter["verified"])
+	assert.Zero(ts.T(), config.API.Pipeline.StuckAfter["uploaded"])
+	assert.Equal(ts.T(), []string{"ingest", "verified"}, config.API.Pipeline.Queues)
+
+	viper.Set("api.pipelineStatus.stuckAfter.archived", "-1h")
+	_, err = NewConfig("api")
+	assert.ErrorContains(ts.T(), err, "api.pipelineStatus.stuckAfter.archived")
+
+	viper.Set("api.pipelineStatus.stuckAfter.archived", "1h")
+	viper.Set("api.pipelineStatus.maxStuckFiles", 0)
+	_, err = NewConfig("api")
+	assert.ErrorContains(ts.T(), err, "api.pipelineStatus.maxStuckFiles")
+}
+
 func (ts *ConfigTestSuite) TestNotifyConfiguration() {
 	// At this point we should fail because we lack configuration
 	config, err := NewConfig("notify")
@@ -344,6 +442,79 @@ func (ts *ConfigTestSuite) TestSyncConfig() {
 	defer os.RemoveAll(ts.pubKeyPath)
 }
 
+func (ts *ConfigTestSuite) TestSFTPInboxConfig() {
+	_, err := NewConfig("sftpinbox")
+	assert.EqualError(ts.T(), err, "sftpinbox.hostKey not set")
+
+	viper.Set("sftpinbox.hostKey", "/keys/ssh_host_ed25519_key")
+	viper.Set("sftpinbox.cega.authUrl", "http://cega/users/")
+	viper.Set("sftpinbox.cega.id", "id")
+	viper.Set("sftpinbox.cega.secret", "secret")
+	config, err := NewConfig("sftpinbox")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 2222, config.SFTPInbox.Port)
+	assert.Equal(ts.T(), "/keys/ssh_host_ed25519_key", config.SFTPInbox.HostKey)
+	assert.Equal(ts.T(), CegaConfig{AuthURL: "http://cega/users/", ID: "id", Secret: "secret"}, config.SFTPInbox.Cega)
+	assert.Equal(ts.T(), "testhost", config.Broker.Host)
+	assert.Equal(ts.T(), "test", config.Database.Host)
+
+	viper.Set("sftpinbox.port", 22)
+	config, err = NewConfig("sftpinbox")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), 22, config.SFTPInbox.Port)
+	assert.False(ts.T(), config.SFTPInbox.ValidateHeader)
+	assert.Empty(ts.T(), config.SFTPInbox.C4ghPrivateKeyList)
+
+	keyPath, _ := os.MkdirTemp("", "keys")
+	defer os.RemoveAll(keyPath)
+	_, err = helper.CreatePrivateKeyFile(keyPath+"/c4gh.key", "test")
+	assert.NoError(ts.T(), err)
+	viper.Set("sftpinbox.validateHeader", true)
+	_, err = NewConfig("sftpinbox")
+	assert.ErrorContains(ts.T(), err, "requires c4gh.privateKeys")
+
+	viper.Set("c4gh.privateKeys", []C4GHprivateKeyConf{{FilePath: keyPath + "/c4gh.key", Passphrase: "test"}})
+	config, err = NewConfig("sftpinbox")
+	assert.NoError(ts.T(), err)
+	assert.True(ts.T(), config.SFTPInbox.ValidateHeader)
+	assert.Len(ts.T(), config.SFTPInbox.C4ghPrivateKeyList, 1)
+}
+
+func (ts *ConfigTestSuite) TestScrubConfig() {
+	config, err := NewConfig("scrub")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "message", config.Scrub.Mode)
+	assert.Equal(ts.T(), uint64(50*1024*1024), config.Scrub.Rate)
+	assert.Equal(ts.T(), 100, config.Scrub.BatchSize)
+	assert.Equal(ts.T(), time.Hour, config.Scrub.Interval)
+	assert.Equal(ts.T(), 30*24*time.Hour, config.Scrub.ReverifyAfter)
+	assert.Equal(ts.T(), "testhost", config.Broker.Host)
+	assert.Equal(ts.T(), "test", config.Database.Host)
+
+	viper.Set("scrub.mode", "inline")
+	viper.Set("scrub.rate", "1GB")
+	viper.Set("scrub.reverifyAfter", 7)
+	viper.Set("broker.host", nil)
+	config, err = NewConfig("scrub")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "inline", config.Scrub.Mode)
+	assert.Equal(ts.T(), uint64(1024*1024*1024), config.Scrub.Rate)
+	assert.Equal(ts.T(), 7*24*time.Hour, config.Scrub.ReverifyAfter)
+
+	viper.Set("scrub.mode", "message")
+	_, err = NewConfig("scrub")
+	assert.EqualError(ts.T(), err, "broker.host not set")
+
+	viper.Set("scrub.mode", "background")
+	_, err = NewConfig("scrub")
+	assert.EqualError(ts.T(), err, "scrub.mode: background is not supported, supported values are message and inline")
+
+	viper.Set("scrub.mode", "inline")
+	viper.Set("scrub.rate", "0")
+	_, err = NewConfig("scrub")
+	assert.EqualError(ts.T(), err, "scrub.rate can not be 0")
+}
+
 func (ts *ConfigTestSuite) TestRotateKeyConfig() {
 	ts.SetupTest()
 	// At this point we should fail because we lack configuration
@@ -479,6 +650,49 @@ func (ts *ConfigTestSuite) TestGetC4GHprivateKeys_InvalidKey() {
 	defer os.RemoveAll(keyPath)
 }
 
+func (ts *ConfigTestSuite) TestAuditConfiguration() {
+	// api.audit enables audit events on stdout by default
+	config, err := NewConfig("api")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), []string{"stdout"}, config.Audit.Sinks)
+
+	viper.Reset()
+	ts.SetupTest()
+	viper.Set("api.audit", false)
+	config, err = NewConfig("api")
+	assert.NoError(ts.T(), err)
+	assert.Empty(ts.T(), config.Audit.Sinks)
+
+	viper.Reset()
+	ts.SetupTest()
+	viper.Set("audit.required", true)
+	viper.Set("audit.sinks", "file, amqp")
+	viper.Set("audit.file.path", "/var/log/sda/audit.jsonl")
+	config, err = NewConfig("api")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), []string{"file", "amqp"}, config.Audit.Sinks)
+	assert.True(ts.T(), config.Audit.Required)
+	assert.Equal(ts.T(), int64(100*1024*1024), config.Audit.File.MaxSize)
+	assert.Equal(ts.T(), 10, config.Audit.File.MaxBackups)
+	assert.Equal(ts.T(), "sda-api", config.Audit.Syslog.AppName)
+	assert.Equal(ts.T(), config.Broker.Host, config.Audit.AMQP.Host)
+	assert.Equal(ts.T(), "sda.audit", config.Audit.AMQP.Exchange)
+	assert.Equal(ts.T(), "api.audit", config.Audit.AMQP.RoutingKey)
+
+	viper.Reset()
+	ts.SetupTest()
+	viper.Set("audit.required", true)
+	viper.Set("audit.sinks", "stdout")
+	_, err = NewConfig("api")
+	assert.ErrorContains(ts.T(), err, "durable audit sink")
+
+	viper.Reset()
+	ts.SetupTest()
+	viper.Set("audit.sinks", "kafka")
+	_, err = NewConfig("api")
+	assert.ErrorContains(ts.T(), err, "invalid audit sink")
+}
+
 func (ts *ConfigTestSuite) TestConfigSyncAPI() {
 	ts.SetupTest()
 	noConfig, err := NewConfig("sync-api")
@@ -3,7 +3,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -77,11 +79,100 @@ type DatasetInfo struct {
 	Timestamp string `json:"timeStamp"`
 }
 
+// EventFilter selects a page of events from an event log
+type EventFilter struct {
+	// Event only returns events with this title, e.g. "uploaded"
+	Event string
+	// Since and Until limit the events to a time range, zero values are unbounded
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// FileEvent is an entry of the file_event_log
+type FileEvent struct {
+	Event      string          `json:"event"`
+	User       string          `json:"user,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// DatasetEvent is an entry of the dataset_event_log
+type DatasetEvent struct {
+	Event     string          `json:"event"`
+	Message   json.RawMessage `json:"message,omitempty"`
+	Timestamp time.Time       `json:"timeStamp"`
+}
+
+// StuckFile is a file that has been in the same state for too long
+type StuckFile struct {
+	FileID         string    `json:"fileID"`
+	SubmissionUser string    `json:"user"`
+	SubmissionPath string    `json:"inboxPath"`
+	Since          time.Time `json:"since"`
+}
+
+// InboxQuota limits the bytes and objects a user, or the members of a group together, may keep in the inbox.
+// A nil limit is unlimited.
+type InboxQuota struct {
+	SubjectType string `json:"type"`
+	Subject     string `json:"name"`
+	MaxBytes    *int64 `json:"maxBytes"`
+	MaxObjects  *int64 `json:"maxObjects"`
+}
+
+// InboxUsage is the number of bytes and objects kept in the inbox
+type InboxUsage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// InboxUploadPart is a part of a multipart upload to the inbox, with the checksums computed as it was uploaded
+type InboxUploadPart struct {
+	Number int
+	Size   int64
+	MD5    string
+	// SHA256State is the marshalled state of the SHA256 of the object up to and including the part, nil unless the
+	// preceding parts were uploaded before the part
+	SHA256State []byte
+}
+
 type FileDetails struct {
 	User string
 	Path string
 }
 
+// ScrubFile holds what is needed to re-verify an archived file
+type ScrubFile struct {
+	FileID             string
+	ArchiveLocation    string
+	ArchivePath        string
+	ArchiveSize        int64
+	SubmissionFilePath string
+	SubmissionUser     string
+	ChecksumType       string
+	Checksum           string
+}
+
+// execer executes statements, it is implemented by both *sql.DB and *sql.Tx such that a statement can be run on its own
+// or as part of a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// OutboxMessage is a message to be published to the message broker by the outbox relay
+type OutboxMessage struct {
+	CorrelationID string
+	Exchange      string
+	RoutingKey    string
+	Body          []byte
+}
+
 // SchemaName is the name of the remote database schema to query
 var SchemaName = "sda"
 
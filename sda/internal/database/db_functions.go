@@ -14,6 +14,7 @@ import (
 
 	"github.com/lib/pq"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -150,6 +151,57 @@ WHERE id_and_event.event IN ('registered', 'uploaded', 'disabled');`
 	return fileID, nil
 }
 
+// IsFileBeingIngested checks if the inbox file of the user at filePath has been submitted for ingestion and is not
+// yet archived, in which case ingest still reads the file from the inbox
+func (dbs *SDAdb) IsFileBeingIngested(ctx context.Context, submissionUser, filePath string) (bool, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT EXISTS(
+    SELECT 1 FROM sda.files AS f
+    WHERE f.submission_user = $1
+      AND f.submission_file_path = $2
+      AND f.archive_file_path = ''
+      AND (SELECT e.event FROM sda.file_event_log e WHERE e.file_id = f.id ORDER BY e.started_at DESC LIMIT 1) = 'submitted'
+);`
+
+	var beingIngested bool
+	err := dbs.DB.QueryRowContext(ctx, query, submissionUser, filePath).Scan(&beingIngested)
+
+	return beingIngested, err
+}
+
+// GetInboxFileCopy returns the id and path of the inbox file that was copied from the file with sourceID and has not
+// changed since, or empty strings if there is none
+func (dbs *SDAdb) GetInboxFileCopy(ctx context.Context, sourceID string) (string, string, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT f.id, f.submission_file_path
+FROM sda.files AS f
+    CROSS JOIN LATERAL (
+        SELECT e.event, e.details, e.started_at FROM sda.file_event_log AS e
+        WHERE e.file_id = f.id
+        ORDER BY e.started_at DESC LIMIT 1
+    ) AS latest
+WHERE f.submission_user = (SELECT submission_user FROM sda.files WHERE id = $1)
+  AND f.archive_file_path = ''
+  AND latest.event = 'uploaded'
+  AND latest.details->>'copiedFrom' = $1
+ORDER BY latest.started_at DESC LIMIT 1;`
+
+	var fileID, filePath string
+	if err := dbs.DB.QueryRowContext(ctx, query, sourceID).Scan(&fileID, &filePath); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", nil
+		}
+
+		return "", "", err
+	}
+
+	return fileID, filePath, nil
+}
+
 // CheckStableIDOwnedByUser checks if the file a stableID links to belongs to the user
 // Returns true if a file is found by the stableID and user, false if not found
 func (dbs *SDAdb) CheckStableIDOwnedByUser(stableID, user string) (bool, error) {
@@ -299,9 +351,38 @@ func (dbs *SDAdb) rotateHeaderKey(header []byte, keyHash, fileID string) error {
 func (dbs *SDAdb) SetArchived(location string, file FileInfo, fileID string) error {
 	dbs.checkAndReconnectIfNeeded()
 
-	db := dbs.DB
+	return setArchived(context.Background(), dbs.DB, location, file, fileID)
+}
+
+// SetArchivedWithMessage marks the file as 'ARCHIVED' with its archive location, inserts the file event and adds the
+// message to the outbox in one transaction, such that the file is never archived without the event being recorded and
+// the message eventually published
+func (dbs *SDAdb) SetArchivedWithMessage(ctx context.Context, location string, file FileInfo, fileID, event, user, details, message string, outboxMessage OutboxMessage) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback SetArchivedWithMessage transaction, due to: %v", err)
+		}
+	}()
+
+	if err := setArchived(ctx, tx, location, file, fileID); err != nil {
+		return err
+	}
+	if err := addFileEventWithMessage(ctx, tx, fileID, event, user, details, message, outboxMessage); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setArchived(ctx context.Context, db execer, location string, file FileInfo, fileID string) error {
 	const setArchived = "UPDATE sda.files SET archive_location = $1, archive_file_path = $2, archive_file_size = $3 WHERE id = $4;"
-	if _, err := db.Exec(setArchived, location, file.Path, file.Size, fileID); err != nil {
+	if _, err := db.ExecContext(ctx, setArchived, location, file.Path, file.Size, fileID); err != nil {
 		return fmt.Errorf("setArchived error: %s", err.Error())
 	}
 
@@ -310,7 +391,7 @@ func (dbs *SDAdb) SetArchived(location string, file FileInfo, fileID string) err
 VALUES($1, $2, upper($3)::sda.checksum_algorithm, upper('UPLOADED')::sda.checksum_source)
 ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;`
 
-	if _, err := db.Exec(addChecksum, fileID, file.UploadedChecksum, "SHA256"); err != nil {
+	if _, err := db.ExecContext(ctx, addChecksum, fileID, file.UploadedChecksum, "SHA256"); err != nil {
 		return fmt.Errorf("addChecksum error: %s", err.Error())
 	}
 
@@ -489,8 +570,37 @@ func (dbs *SDAdb) SetVerified(file FileInfo, fileID string) error {
 func (dbs *SDAdb) setVerified(file FileInfo, fileID string) error {
 	dbs.checkAndReconnectIfNeeded()
 
+	return setVerified(context.Background(), dbs.DB, file, fileID)
+}
+
+// SetVerifiedWithMessage records the decrypted size and the checksums of the verified file, inserts the file event
+// and adds the message to the outbox in one transaction
+func (dbs *SDAdb) SetVerifiedWithMessage(ctx context.Context, file FileInfo, fileID, event, user, details, message string, outboxMessage OutboxMessage) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback SetVerifiedWithMessage transaction, due to: %v", err)
+		}
+	}()
+
+	if err := setVerified(ctx, tx, file, fileID); err != nil {
+		return err
+	}
+	if err := addFileEventWithMessage(ctx, tx, fileID, event, user, details, message, outboxMessage); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setVerified(ctx context.Context, db execer, file FileInfo, fileID string) error {
 	const verified = "UPDATE sda.files SET decrypted_file_size = $1 WHERE id = $2;"
-	if _, err := dbs.DB.Exec(verified, file.DecryptedSize, fileID); err != nil {
+	if _, err := db.ExecContext(ctx, verified, file.DecryptedSize, fileID); err != nil {
 		return fmt.Errorf("setVerified error: %s", err.Error())
 	}
 
@@ -498,7 +608,7 @@ func (dbs *SDAdb) setVerified(file FileInfo, fileID string) error {
 VALUES($1, $2, upper($3)::sda.checksum_algorithm, upper('ARCHIVED')::sda.checksum_source)
 ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;`
 
-	if _, err := dbs.DB.Exec(addArchiveChecksum, fileID, file.ArchiveChecksum, "SHA256"); err != nil {
+	if _, err := db.ExecContext(ctx, addArchiveChecksum, fileID, file.ArchiveChecksum, "SHA256"); err != nil {
 		return fmt.Errorf("addArchiveChecksum error: %s", err.Error())
 	}
 
@@ -506,7 +616,7 @@ ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.chec
 VALUES($1, $2, upper($3)::sda.checksum_algorithm, upper('UNENCRYPTED')::sda.checksum_source)
 ON CONFLICT ON CONSTRAINT unique_checksum DO UPDATE SET checksum = EXCLUDED.checksum;`
 
-	if _, err := dbs.DB.Exec(addUnencryptedChecksum, fileID, file.DecryptedChecksum, "SHA256"); err != nil {
+	if _, err := db.ExecContext(ctx, addUnencryptedChecksum, fileID, file.DecryptedChecksum, "SHA256"); err != nil {
 		return fmt.Errorf("addUnencryptedChecksum error: %s", err.Error())
 	}
 
@@ -1463,6 +1573,27 @@ func (dbs *SDAdb) GetReVerificationDataFromFileID(fileID string) (schema.Ingesti
 	return reVerify, nil
 }
 
+// GetArchivedChecksum returns the type and value of the ARCHIVED checksum of the file archived at the archive path in
+// the location, empty strings are returned if the file has no ARCHIVED checksum yet
+func (dbs *SDAdb) GetArchivedChecksum(ctx context.Context, location, archivePath string) (string, string, error) {
+	dbs.checkAndReconnectIfNeeded()
+	db := dbs.DB
+
+	const query = "SELECT c.type, c.checksum FROM sda.checksums c JOIN sda.files f ON f.id = c.file_id " +
+		"WHERE f.archive_location = $1 AND f.archive_file_path = $2 AND c.source = 'ARCHIVED';"
+
+	var checksumType, checksum string
+	if err := db.QueryRowContext(ctx, query, location, archivePath).Scan(&checksumType, &checksum); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", nil
+		}
+
+		return "", "", err
+	}
+
+	return strings.ToLower(checksumType), checksum, nil
+}
+
 func (dbs *SDAdb) GetDecryptedChecksum(id string) (string, error) {
 	dbs.checkAndReconnectIfNeeded()
 	db := dbs.DB
@@ -1620,3 +1751,676 @@ func (dbs *SDAdb) SetBackedUp(location, path, fileID string) error {
 
 	return nil
 }
+
+// GetFilesToScrub returns up to limit archived files that have not been scrubbed since scrubbedBefore, the files that
+// have never been scrubbed first followed by the least recently scrubbed files. Disabled files are not returned.
+func (dbs *SDAdb) GetFilesToScrub(ctx context.Context, scrubbedBefore time.Time, limit int) ([]*ScrubFile, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT f.id, f.archive_location, f.archive_file_path, f.archive_file_size, f.submission_file_path, f.submission_user, c.type, c.checksum
+FROM sda.files f
+JOIN sda.checksums c ON c.file_id = f.id AND c.source = 'ARCHIVED'
+WHERE f.archive_file_path != ''
+  AND (f.last_scrubbed_at IS NULL OR f.last_scrubbed_at < $1)
+  AND (SELECT e.event FROM sda.file_event_log e WHERE e.file_id = f.id ORDER BY e.started_at DESC LIMIT 1) != 'disabled'
+ORDER BY f.last_scrubbed_at ASC NULLS FIRST
+LIMIT $2;
+`
+
+	rows, err := dbs.DB.QueryContext(ctx, query, scrubbedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*ScrubFile
+	for rows.Next() {
+		var location sql.NullString
+		var size sql.NullInt64
+		file := &ScrubFile{}
+		if err := rows.Scan(&file.FileID, &location, &file.ArchivePath, &size, &file.SubmissionFilePath, &file.SubmissionUser, &file.ChecksumType, &file.Checksum); err != nil {
+			return nil, err
+		}
+		file.ArchiveLocation = location.String
+		file.ArchiveSize = size.Int64
+		file.ChecksumType = strings.ToLower(file.ChecksumType)
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// SetScrubbed records that the file has been scrubbed
+func (dbs *SDAdb) SetScrubbed(ctx context.Context, fileID string) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const setScrubbed = "UPDATE sda.files SET last_scrubbed_at = now() WHERE id = $1;"
+	r, err := dbs.DB.ExecContext(ctx, setScrubbed, fileID)
+	if err != nil {
+		return fmt.Errorf("setScrubbed error: %s", err.Error())
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("setScrubbed error: %s", err.Error())
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetUpload returns the ongoing multipart upload of the file along with its completed parts, or nil if there is none
+func (dbs *SDAdb) GetUpload(ctx context.Context, fileID string) (*uploadstate.Upload, error) {
+	dbs.checkAndReconnectIfNeeded()
+	db := dbs.DB
+
+	const getUpload = "SELECT upload_id, location, object_key, part_size FROM sda.multipart_uploads WHERE file_id = $1;"
+	upload := &uploadstate.Upload{}
+	if err := db.QueryRowContext(ctx, getUpload, fileID).Scan(&upload.UploadID, &upload.Location, &upload.Key, &upload.PartSize); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	const getParts = "SELECT part_number, etag, size FROM sda.multipart_upload_parts WHERE file_id = $1 ORDER BY part_number;"
+	rows, err := db.QueryContext(ctx, getParts, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var part uploadstate.Part
+		if err := rows.Scan(&part.Number, &part.ETag, &part.Size); err != nil {
+			return nil, err
+		}
+		upload.Parts = append(upload.Parts, part)
+	}
+
+	return upload, rows.Err()
+}
+
+// SaveUpload records a new multipart upload of the file, replacing any previous upload and its parts
+func (dbs *SDAdb) SaveUpload(ctx context.Context, fileID string, upload *uploadstate.Upload) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback SaveUpload transaction, due to: %v", err)
+		}
+	}()
+
+	const deleteUpload = "DELETE FROM sda.multipart_uploads WHERE file_id = $1;"
+	if _, err := tx.ExecContext(ctx, deleteUpload, fileID); err != nil {
+		return fmt.Errorf("failed to delete previous upload (file-id: %s): %v", fileID, err)
+	}
+
+	const insertUpload = "INSERT INTO sda.multipart_uploads(file_id, upload_id, location, object_key, part_size) VALUES($1, $2, $3, $4, $5);"
+	if _, err := tx.ExecContext(ctx, insertUpload, fileID, upload.UploadID, upload.Location, upload.Key, upload.PartSize); err != nil {
+		return fmt.Errorf("failed to insert upload (file-id: %s): %v", fileID, err)
+	}
+
+	return tx.Commit()
+}
+
+// AddPart records a completed part of the ongoing multipart upload of the file
+func (dbs *SDAdb) AddPart(ctx context.Context, fileID string, part uploadstate.Part) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const addPart = "INSERT INTO sda.multipart_upload_parts(file_id, part_number, etag, size) VALUES($1, $2, $3, $4) " +
+		"ON CONFLICT (file_id, part_number) DO UPDATE SET etag = excluded.etag, size = excluded.size;"
+	if _, err := dbs.DB.ExecContext(ctx, addPart, fileID, part.Number, part.ETag, part.Size); err != nil {
+		return fmt.Errorf("addPart error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteUpload removes the multipart upload of the file along with its parts
+func (dbs *SDAdb) DeleteUpload(ctx context.Context, fileID string) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const deleteUpload = "DELETE FROM sda.multipart_uploads WHERE file_id = $1;"
+	if _, err := dbs.DB.ExecContext(ctx, deleteUpload, fileID); err != nil {
+		return fmt.Errorf("deleteUpload error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// UpdateFileEventLogWithMessage inserts a file event, and adds the message to the outbox in the same transaction, such
+// that the message is eventually published if, and only if, the event is recorded
+func (dbs *SDAdb) UpdateFileEventLogWithMessage(ctx context.Context, fileUUID, event, user, details, message string, outboxMessage OutboxMessage) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback UpdateFileEventLogWithMessage transaction, due to: %v", err)
+		}
+	}()
+
+	if err := addFileEventWithMessage(ctx, tx, fileUUID, event, user, details, message, outboxMessage); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addFileEventWithMessage inserts a file event and adds the message to the outbox as part of the transaction
+func addFileEventWithMessage(ctx context.Context, tx *sql.Tx, fileUUID, event, user, details, message string, outboxMessage OutboxMessage) error {
+	const addEvent = "INSERT INTO sda.file_event_log(file_id, event, user_id, details, message) VALUES($1, $2, $3, $4, $5);"
+	if _, err := tx.ExecContext(ctx, addEvent, fileUUID, event, user, details, message); err != nil {
+		// 23503 error code == foreign_key_violation, meaning the files row does not exits
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return sql.ErrNoRows
+		}
+
+		return err
+	}
+
+	const addMessage = "INSERT INTO sda.outbox(correlation_id, exchange, routing_key, body) VALUES($1, $2, $3, $4);"
+	if _, err := tx.ExecContext(ctx, addMessage, outboxMessage.CorrelationID, outboxMessage.Exchange, outboxMessage.RoutingKey, outboxMessage.Body); err != nil {
+		return fmt.Errorf("failed to add message to outbox (file-id: %s): %v", fileUUID, err)
+	}
+
+	return nil
+}
+
+// RelayOutbox passes up to limit pending messages of the outbox, oldest first, to publish, and removes the messages
+// published. Relaying stops at the first message that fails to be published, which is kept in the outbox with the
+// error recorded. Messages being relayed by another relay are skipped. The number of messages published is returned.
+//
+// The messages are removed in a transaction committed after publishing, a message is therefore published again if the
+// transaction fails.
+func (dbs *SDAdb) RelayOutbox(ctx context.Context, limit int, publish func(corrID, exchange, routingKey string, body []byte) error) (int, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback RelayOutbox transaction, due to: %v", err)
+		}
+	}()
+
+	const getPending = "SELECT id, correlation_id, exchange, routing_key, body FROM sda.outbox " +
+		"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED;"
+	rows, err := tx.QueryContext(ctx, getPending, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		id      int64
+		message OutboxMessage
+	}
+	var messages []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.message.CorrelationID, &p.message.Exchange, &p.message.RoutingKey, &p.message.Body); err != nil {
+			rows.Close()
+
+			return 0, err
+		}
+		messages = append(messages, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	for _, p := range messages {
+		if publishErr = publish(p.message.CorrelationID, p.message.Exchange, p.message.RoutingKey, p.message.Body); publishErr != nil {
+			const setFailed = "UPDATE sda.outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1;"
+			if _, err := tx.ExecContext(ctx, setFailed, p.id, publishErr.Error()); err != nil {
+				log.Errorf("failed to record failed publish of outbox message: %d, due to: %v", p.id, err)
+			}
+
+			break
+		}
+
+		const deletePublished = "DELETE FROM sda.outbox WHERE id = $1;"
+		if _, err := tx.ExecContext(ctx, deletePublished, p.id); err != nil {
+			return published, fmt.Errorf("failed to remove published outbox message: %d, due to: %v", p.id, err)
+		}
+		published++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish outbox message, due to: %v", publishErr)
+	}
+
+	return published, nil
+}
+
+// GetFileHistory returns a page of the file_event_log of a file, oldest event first, and whether more events match the
+// filter. sql.ErrNoRows is returned if the file does not exist.
+func (dbs *SDAdb) GetFileHistory(ctx context.Context, fileID string, filter EventFilter) ([]*FileEvent, bool, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	var exists bool
+	if err := dbs.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM sda.files WHERE id = $1);", fileID).Scan(&exists); err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, sql.ErrNoRows
+	}
+
+	const query = `
+SELECT event, user_id, details, message, success, error, started_at, finished_at
+FROM sda.file_event_log
+WHERE file_id = $1
+  AND ($2 = '' OR event = $2)
+  AND ($3::timestamptz IS NULL OR started_at >= $3)
+  AND ($4::timestamptz IS NULL OR started_at < $4)
+ORDER BY started_at, id
+LIMIT $5 OFFSET $6;
+`
+
+	// One extra row is fetched to tell if there are more events
+	rows, err := dbs.DB.QueryContext(ctx, query, fileID, filter.Event, sql.NullTime{Time: filter.Since, Valid: !filter.Since.IsZero()}, sql.NullTime{Time: filter.Until, Valid: !filter.Until.IsZero()}, filter.Limit+1, filter.Offset)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var events []*FileEvent
+	for rows.Next() {
+		var user, errorText sql.NullString
+		var details, message []byte
+		var success sql.NullBool
+		var finishedAt sql.NullTime
+		event := &FileEvent{}
+		if err := rows.Scan(&event.Event, &user, &details, &message, &success, &errorText, &event.StartedAt, &finishedAt); err != nil {
+			return nil, false, err
+		}
+		event.User = user.String
+		event.Details = details
+		event.Message = message
+		event.Error = errorText.String
+		if success.Valid {
+			event.Success = &success.Bool
+		}
+		if finishedAt.Valid {
+			event.FinishedAt = &finishedAt.Time
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(events) > filter.Limit {
+		return events[:filter.Limit], true, nil
+	}
+
+	return events, false, nil
+}
+
+// GetDatasetHistory returns a page of the dataset_event_log of a dataset, oldest event first, and whether more events
+// match the filter. sql.ErrNoRows is returned if the dataset does not exist.
+func (dbs *SDAdb) GetDatasetHistory(ctx context.Context, datasetID string, filter EventFilter) ([]*DatasetEvent, bool, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	var exists bool
+	if err := dbs.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM sda.datasets WHERE stable_id = $1);", datasetID).Scan(&exists); err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, sql.ErrNoRows
+	}
+
+	const query = `
+SELECT event, message, event_date
+FROM sda.dataset_event_log
+WHERE dataset_id = $1
+  AND ($2 = '' OR event = $2)
+  AND ($3::timestamptz IS NULL OR event_date >= $3)
+  AND ($4::timestamptz IS NULL OR event_date < $4)
+ORDER BY event_date, id
+LIMIT $5 OFFSET $6;
+`
+
+	// One extra row is fetched to tell if there are more events
+	rows, err := dbs.DB.QueryContext(ctx, query, datasetID, filter.Event, sql.NullTime{Time: filter.Since, Valid: !filter.Since.IsZero()}, sql.NullTime{Time: filter.Until, Valid: !filter.Until.IsZero()}, filter.Limit+1, filter.Offset)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var events []*DatasetEvent
+	for rows.Next() {
+		var message []byte
+		event := &DatasetEvent{}
+		if err := rows.Scan(&event.Event, &message, &event.Timestamp); err != nil {
+			return nil, false, err
+		}
+		event.Message = message
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(events) > filter.Limit {
+		return events[:filter.Limit], true, nil
+	}
+
+	return events, false, nil
+}
+
+// GetFileStatusCounts returns the number of files per latest file event
+func (dbs *SDAdb) GetFileStatusCounts(ctx context.Context) (map[string]int, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT event, count(*)
+FROM (SELECT DISTINCT ON (file_id) file_id, event FROM sda.file_event_log ORDER BY file_id, started_at DESC) latest
+GROUP BY event;
+`
+
+	rows, err := dbs.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var event string
+		var count int
+		if err := rows.Scan(&event, &count); err != nil {
+			return nil, err
+		}
+		counts[event] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// GetStuckFiles returns up to limit files whose latest file event is status and happened before since, the files that
+// have been stuck the longest first.
+func (dbs *SDAdb) GetStuckFiles(ctx context.Context, status string, since time.Time, limit int) ([]*StuckFile, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT f.id, f.submission_user, f.submission_file_path, latest.started_at
+FROM (SELECT DISTINCT ON (file_id) file_id, event, started_at FROM sda.file_event_log ORDER BY file_id, started_at DESC) latest
+JOIN sda.files f ON f.id = latest.file_id
+WHERE latest.event = $1 AND latest.started_at < $2
+ORDER BY latest.started_at
+LIMIT $3;
+`
+
+	rows, err := dbs.DB.QueryContext(ctx, query, status, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*StuckFile
+	for rows.Next() {
+		file := &StuckFile{}
+		if err := rows.Scan(&file.FileID, &file.SubmissionUser, &file.SubmissionPath, &file.Since); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// SetInboxQuota creates or replaces the inbox quota of a user or group
+func (dbs *SDAdb) SetInboxQuota(ctx context.Context, quota InboxQuota) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+INSERT INTO sda.inbox_quotas(subject_type, subject, max_bytes, max_objects)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (subject_type, subject)
+DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_objects = EXCLUDED.max_objects, last_modified = clock_timestamp();
+`
+	if _, err := dbs.DB.ExecContext(ctx, query, quota.SubjectType, quota.Subject, quota.MaxBytes, quota.MaxObjects); err != nil {
+		return fmt.Errorf("setInboxQuota error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// GetInboxQuota returns the inbox quota of a user or group, sql.ErrNoRows is returned if there is none
+func (dbs *SDAdb) GetInboxQuota(ctx context.Context, subjectType, subject string) (*InboxQuota, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "SELECT subject_type, subject, max_bytes, max_objects FROM sda.inbox_quotas WHERE subject_type = $1 AND subject = $2;"
+
+	quota := &InboxQuota{}
+	if err := dbs.DB.QueryRowContext(ctx, query, subjectType, subject).Scan(&quota.SubjectType, &quota.Subject, &quota.MaxBytes, &quota.MaxObjects); err != nil {
+		return nil, err
+	}
+
+	return quota, nil
+}
+
+// ListInboxQuotas returns all inbox quotas, ordered by type and subject
+func (dbs *SDAdb) ListInboxQuotas(ctx context.Context) ([]*InboxQuota, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "SELECT subject_type, subject, max_bytes, max_objects FROM sda.inbox_quotas ORDER BY subject_type, subject;"
+
+	return dbs.queryInboxQuotas(ctx, query)
+}
+
+// DeleteInboxQuota removes the inbox quota of a user or group, sql.ErrNoRows is returned if there is none
+func (dbs *SDAdb) DeleteInboxQuota(ctx context.Context, subjectType, subject string) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "DELETE FROM sda.inbox_quotas WHERE subject_type = $1 AND subject = $2;"
+	r, err := dbs.DB.ExecContext(ctx, query, subjectType, subject)
+	if err != nil {
+		return fmt.Errorf("deleteInboxQuota error: %s", err.Error())
+	}
+
+	rowsAffected, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleteInboxQuota error: %s", err.Error())
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetApplicableInboxQuotas returns the quota of the user together with the quotas of the groups the user is a member of
+func (dbs *SDAdb) GetApplicableInboxQuotas(ctx context.Context, user string) ([]*InboxQuota, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT q.subject_type, q.subject, q.max_bytes, q.max_objects
+FROM sda.inbox_quotas q
+WHERE (q.subject_type = 'user' AND q.subject = $1)
+   OR (q.subject_type = 'group' AND q.subject IN (SELECT unnest(u.groups) FROM sda.userinfo u WHERE u.id = $1))
+ORDER BY q.subject_type DESC, q.subject;
+`
+
+	return dbs.queryInboxQuotas(ctx, query, user)
+}
+
+func (dbs *SDAdb) queryInboxQuotas(ctx context.Context, query string, args ...any) ([]*InboxQuota, error) {
+	rows, err := dbs.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotas []*InboxQuota
+	for rows.Next() {
+		quota := &InboxQuota{}
+		if err := rows.Scan(&quota.SubjectType, &quota.Subject, &quota.MaxBytes, &quota.MaxObjects); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+
+	return quotas, rows.Err()
+}
+
+// GetInboxUsage returns the bytes and objects kept in the inbox by a user, or by the members of a group together.
+// Files that have been added to a dataset or disabled are no longer in the inbox and are not counted, neither is the
+// file with id excludeFileID, which is about to be replaced by a reupload.
+func (dbs *SDAdb) GetInboxUsage(ctx context.Context, subjectType, subject, excludeFileID string) (InboxUsage, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = `
+SELECT COALESCE(SUM(COALESCE(f.submission_file_size, 0)), 0), COUNT(*)
+FROM sda.files f
+WHERE CASE WHEN $1 = 'group'
+           THEN f.submission_user IN (SELECT u.id FROM sda.userinfo u WHERE $2 = ANY(u.groups))
+           ELSE f.submission_user = $2 END
+  AND f.id::text != $3
+  AND NOT EXISTS (SELECT 1 FROM sda.file_dataset fd WHERE fd.file_id = f.id)
+  AND COALESCE((SELECT e.event FROM sda.file_event_log e WHERE e.file_id = f.id ORDER BY e.started_at DESC LIMIT 1), '') != 'disabled';
+`
+
+	var usage InboxUsage
+	if err := dbs.DB.QueryRowContext(ctx, query, subjectType, subject, excludeFileID).Scan(&usage.Bytes, &usage.Objects); err != nil {
+		return InboxUsage{}, err
+	}
+
+	return usage, nil
+}
+
+// GetInboxUploadPart returns a recorded part of a multipart upload to the inbox, or nil if the part is not recorded
+func (dbs *SDAdb) GetInboxUploadPart(ctx context.Context, uploadID string, partNumber int) (*InboxUploadPart, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "SELECT part_number, size, md5, sha256_state FROM sda.inbox_upload_parts WHERE upload_id = $1 AND part_number = $2;"
+	part := &InboxUploadPart{}
+	if err := dbs.DB.QueryRowContext(ctx, query, uploadID, partNumber).Scan(&part.Number, &part.Size, &part.MD5, &part.SHA256State); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return part, nil
+}
+
+// GetInboxUploadParts returns the recorded parts of a multipart upload to the inbox ordered by part number
+func (dbs *SDAdb) GetInboxUploadParts(ctx context.Context, uploadID string) ([]InboxUploadPart, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "SELECT part_number, size, md5, sha256_state FROM sda.inbox_upload_parts WHERE upload_id = $1 ORDER BY part_number;"
+	rows, err := dbs.DB.QueryContext(ctx, query, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []InboxUploadPart
+	for rows.Next() {
+		var part InboxUploadPart
+		if err := rows.Scan(&part.Number, &part.Size, &part.MD5, &part.SHA256State); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, rows.Err()
+}
+
+// AddInboxUploadPart records a part of a multipart upload to the inbox. A part that is uploaded again replaces the
+// recorded part, and the SHA256 states of the parts after it, which were computed from the replaced data, are cleared.
+func (dbs *SDAdb) AddInboxUploadPart(ctx context.Context, uploadID string, part InboxUploadPart) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	tx, err := dbs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Errorf("failed to rollback AddInboxUploadPart transaction, due to: %v", err)
+		}
+	}()
+
+	const addPart = "INSERT INTO sda.inbox_upload_parts(upload_id, part_number, size, md5, sha256_state) VALUES($1, $2, $3, $4, $5) " +
+		"ON CONFLICT (upload_id, part_number) DO UPDATE SET size = excluded.size, md5 = excluded.md5, sha256_state = excluded.sha256_state, created_at = clock_timestamp();"
+	if _, err := tx.ExecContext(ctx, addPart, uploadID, part.Number, part.Size, part.MD5, part.SHA256State); err != nil {
+		return fmt.Errorf("addInboxUploadPart error: %s", err.Error())
+	}
+
+	const clearStates = "UPDATE sda.inbox_upload_parts SET sha256_state = NULL WHERE upload_id = $1 AND part_number > $2;"
+	if _, err := tx.ExecContext(ctx, clearStates, uploadID, part.Number); err != nil {
+		return fmt.Errorf("addInboxUploadPart error: %s", err.Error())
+	}
+
+	return tx.Commit()
+}
+
+// DeleteInboxUploadParts removes the recorded parts of a multipart upload to the inbox
+func (dbs *SDAdb) DeleteInboxUploadParts(ctx context.Context, uploadID string) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const deleteParts = "DELETE FROM sda.inbox_upload_parts WHERE upload_id = $1;"
+	if _, err := dbs.DB.ExecContext(ctx, deleteParts, uploadID); err != nil {
+		return fmt.Errorf("deleteInboxUploadParts error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// SetUploadedChecksum records the SHA256 checksum of a file computed as it was uploaded to the inbox. An empty
+// checksum removes the checksum recorded for an earlier upload of the file. The checksum is written by
+// sda.set_uploaded_checksum, as the inbox may not write to the checksums table.
+func (dbs *SDAdb) SetUploadedChecksum(ctx context.Context, fileID, checksum string) error {
+	dbs.checkAndReconnectIfNeeded()
+
+	const setChecksum = "SELECT sda.set_uploaded_checksum($1, $2);"
+	if _, err := dbs.DB.ExecContext(ctx, setChecksum, fileID, checksum); err != nil {
+		return fmt.Errorf("setUploadedChecksum error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// GetUploadedChecksum returns the SHA256 checksum of a file recorded as it was uploaded, or an empty string if there
+// is none
+func (dbs *SDAdb) GetUploadedChecksum(ctx context.Context, fileID string) (string, error) {
+	dbs.checkAndReconnectIfNeeded()
+
+	const query = "SELECT checksum FROM sda.checksums WHERE file_id = $1 AND type = 'SHA256' AND source = 'UPLOADED';"
+	var checksum string
+	if err := dbs.DB.QueryRowContext(ctx, query, fileID).Scan(&checksum); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return checksum, nil
+}
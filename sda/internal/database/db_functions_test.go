@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -204,6 +206,36 @@ func (suite *DatabaseTests) TestSetArchived() {
 	db.Close()
 }
 
+func (suite *DatabaseTests) TestSetArchivedWithMessage() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestSetArchivedWithMessage.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+
+	fileInfo := FileInfo{fmt.Sprintf("%x", sha256.New()), 1000, "/Test/SetArchivedWithMessage.c4gh", fmt.Sprintf("%x", sha256.New()), -1, fmt.Sprintf("%x", sha256.New())}
+	message := OutboxMessage{CorrelationID: fileID, Exchange: "sda", RoutingKey: "archived", Body: []byte(`{"type":"archived"}`)}
+
+	// Nothing is recorded if any of the statements fail
+	assert.ErrorContains(suite.T(), db.SetArchivedWithMessage(context.TODO(), "/archive", fileInfo, uuid.NewString(), "archived", "ingest", "{}", "{}", message), "violates foreign key constraint")
+	var pending int
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.outbox WHERE correlation_id = $1;", fileID).Scan(&pending))
+	assert.Equal(suite.T(), 0, pending)
+
+	assert.NoError(suite.T(), db.SetArchivedWithMessage(context.TODO(), "/archive", fileInfo, fileID, "archived", "ingest", "{}", "{}", message))
+
+	status, err := db.GetFileStatus(fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "archived", status)
+	archiveData, err := db.GetArchived(fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "/archive", archiveData.Location)
+	assert.Equal(suite.T(), int64(1000), archiveData.FileSize)
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.outbox WHERE correlation_id = $1;", fileID).Scan(&pending))
+	assert.Equal(suite.T(), 1, pending)
+}
+
 func (suite *DatabaseTests) TestGetFileStatus() {
 	db, err := NewSDAdb(suite.dbConf)
 	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
@@ -285,6 +317,35 @@ func (suite *DatabaseTests) TestSetVerified() {
 	db.Close()
 }
 
+func (suite *DatabaseTests) TestSetVerifiedWithMessage() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestSetVerifiedWithMessage.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+
+	fileInfo := FileInfo{fmt.Sprintf("%x", sha256.New()), 1000, "/testuser/TestSetVerifiedWithMessage.c4gh", fmt.Sprintf("%x", sha256.New()), 948, fmt.Sprintf("%x", sha256.New())}
+	message := OutboxMessage{CorrelationID: fileID, Exchange: "sda", RoutingKey: "verified", Body: []byte(`{"type":"verified"}`)}
+
+	// The checksums are not recorded if the event can not be
+	assert.ErrorIs(suite.T(), db.SetVerifiedWithMessage(context.TODO(), fileInfo, fileID, "not-an-event", "ingest", "{}", "{}", message), sql.ErrNoRows)
+	var checksums int
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.checksums WHERE file_id = $1;", fileID).Scan(&checksums))
+	assert.Equal(suite.T(), 0, checksums)
+
+	assert.NoError(suite.T(), db.SetVerifiedWithMessage(context.TODO(), fileInfo, fileID, "verified", "ingest", "{}", "{}", message))
+
+	status, err := db.GetFileStatus(fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "verified", status)
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.checksums WHERE file_id = $1;", fileID).Scan(&checksums))
+	assert.Equal(suite.T(), 2, checksums)
+	var pending int
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.outbox WHERE correlation_id = $1;", fileID).Scan(&pending))
+	assert.Equal(suite.T(), 1, pending)
+}
+
 func (suite *DatabaseTests) TestGetArchived() {
 	db, err := NewSDAdb(suite.dbConf)
 	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
@@ -1329,6 +1390,137 @@ func (suite *DatabaseTests) TestGetReVerificationData() {
 	db.Close()
 }
 
+func (suite *DatabaseTests) TestGetArchivedChecksum() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestGetArchivedChecksum.c4gh", "testuser")
+	if err != nil {
+		suite.FailNow("failed to register file in database")
+	}
+
+	fileInfo := FileInfo{fmt.Sprintf("%x", sha256.Sum256([]byte("Checksum"))), 2000, "/archive/TestGetArchivedChecksum.c4gh", fmt.Sprintf("%x", sha256.Sum256([]byte("DecryptedChecksum"))), 1987, fmt.Sprintf("%x", sha256.New())}
+	if err = db.SetArchived("/archive", fileInfo, fileID); err != nil {
+		suite.FailNow("failed to archive file")
+	}
+
+	// The ARCHIVED checksum is not known until the file has been verified
+	checksumType, checksum, err := db.GetArchivedChecksum(context.TODO(), "/archive", "/archive/TestGetArchivedChecksum.c4gh")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "", checksumType)
+	assert.Equal(suite.T(), "", checksum)
+
+	if err = db.SetVerified(fileInfo, fileID); err != nil {
+		suite.FailNow("failed to mark file as verified")
+	}
+
+	checksumType, checksum, err = db.GetArchivedChecksum(context.TODO(), "/archive", "/archive/TestGetArchivedChecksum.c4gh")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "sha256", checksumType)
+	assert.Equal(suite.T(), fileInfo.ArchiveChecksum, checksum)
+
+	db.Close()
+}
+
+func (suite *DatabaseTests) TestGetFilesToScrub() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileIDs := make([]string, 3)
+	for i := range fileIDs {
+		fileIDs[i], err = db.RegisterFile(nil, "/inbox", fmt.Sprintf("/testuser/TestGetFilesToScrub-%d.c4gh", i), "testuser")
+		if err != nil {
+			suite.FailNow("failed to register file in database")
+		}
+
+		fileInfo := FileInfo{fmt.Sprintf("%x", sha256.Sum256([]byte(fileIDs[i]))), 2000, fileIDs[i], fmt.Sprintf("%x", sha256.Sum256([]byte("DecryptedChecksum"))), 1987, fmt.Sprintf("%x", sha256.New())}
+		if err = db.SetArchived("/archive", fileInfo, fileIDs[i]); err != nil {
+			suite.FailNow("failed to archive file")
+		}
+		if err = db.SetVerified(fileInfo, fileIDs[i]); err != nil {
+			suite.FailNow("failed to mark file as verified")
+		}
+		if err = db.UpdateFileEventLog(fileIDs[i], "verified", "verify", "{}", "{}"); err != nil {
+			suite.FailNow("failed to update file event log")
+		}
+	}
+
+	// The first file was scrubbed a moment ago, and the second file is disabled
+	assert.NoError(suite.T(), db.SetScrubbed(context.TODO(), fileIDs[0]))
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileIDs[1], "disabled", "testuser", "{}", "{}"))
+
+	files, err := db.GetFilesToScrub(context.TODO(), time.Now().Add(-time.Hour), 100)
+	assert.NoError(suite.T(), err)
+
+	var scrubFileIDs []string
+	for _, f := range files {
+		scrubFileIDs = append(scrubFileIDs, f.FileID)
+		if f.FileID == fileIDs[2] {
+			assert.Equal(suite.T(), "/archive", f.ArchiveLocation)
+			assert.Equal(suite.T(), fileIDs[2], f.ArchivePath)
+			assert.Equal(suite.T(), int64(2000), f.ArchiveSize)
+			assert.Equal(suite.T(), "sha256", f.ChecksumType)
+			assert.Equal(suite.T(), fmt.Sprintf("%x", sha256.Sum256([]byte(fileIDs[2]))), f.Checksum)
+		}
+	}
+	assert.Contains(suite.T(), scrubFileIDs, fileIDs[2])
+	assert.NotContains(suite.T(), scrubFileIDs, fileIDs[0])
+	assert.NotContains(suite.T(), scrubFileIDs, fileIDs[1])
+
+	// Once all files are due, the least recently scrubbed file is returned last
+	files, err = db.GetFilesToScrub(context.TODO(), time.Now().Add(time.Hour), 100)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), fileIDs[0], files[len(files)-1].FileID)
+
+	assert.ErrorIs(suite.T(), db.SetScrubbed(context.TODO(), "00000000-0000-0000-0000-000000000000"), sql.ErrNoRows)
+
+	db.Close()
+}
+
+func (suite *DatabaseTests) TestUploadState() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestUploadState.c4gh", "testuser")
+	if err != nil {
+		suite.FailNow("failed to register file in database")
+	}
+
+	upload, err := db.GetUpload(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), upload)
+
+	assert.NoError(suite.T(), db.SaveUpload(context.TODO(), fileID, &uploadstate.Upload{UploadID: "upload-1", Location: "s3://s3/archive", Key: fileID, PartSize: 100}))
+	assert.NoError(suite.T(), db.AddPart(context.TODO(), fileID, uploadstate.Part{Number: 2, ETag: "etag-2", Size: 100}))
+	assert.NoError(suite.T(), db.AddPart(context.TODO(), fileID, uploadstate.Part{Number: 1, ETag: "etag-1", Size: 100}))
+	// A part uploaded again replaces the previous part
+	assert.NoError(suite.T(), db.AddPart(context.TODO(), fileID, uploadstate.Part{Number: 2, ETag: "etag-2b", Size: 100}))
+
+	upload, err = db.GetUpload(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), &uploadstate.Upload{
+		UploadID: "upload-1",
+		Location: "s3://s3/archive",
+		Key:      fileID,
+		PartSize: 100,
+		Parts:    []uploadstate.Part{{Number: 1, ETag: "etag-1", Size: 100}, {Number: 2, ETag: "etag-2b", Size: 100}},
+	}, upload)
+
+	// A new upload replaces the previous upload along with its parts
+	assert.NoError(suite.T(), db.SaveUpload(context.TODO(), fileID, &uploadstate.Upload{UploadID: "upload-2", Location: "s3://s3/archive", Key: fileID, PartSize: 200}))
+	upload, err = db.GetUpload(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "upload-2", upload.UploadID)
+	assert.Empty(suite.T(), upload.Parts)
+
+	assert.NoError(suite.T(), db.DeleteUpload(context.TODO(), fileID))
+	upload, err = db.GetUpload(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), upload)
+
+	db.Close()
+}
+
 func (suite *DatabaseTests) TestGetReVerificationDataFromFileID() {
 	db, err := NewSDAdb(suite.dbConf)
 	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
@@ -1939,3 +2131,368 @@ func (suite *DatabaseTests) TestGetFileIDInInbox_NotFound() {
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), "", fileIDFromDB)
 }
+
+func (suite *DatabaseTests) TestIsFileBeingIngested() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "TestIsFileBeingIngested.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+
+	beingIngested, err := db.IsFileBeingIngested(context.TODO(), "testuser", "TestIsFileBeingIngested.c4gh")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), beingIngested)
+
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "submitted", "testuser", "{}", "{}"))
+	beingIngested, err = db.IsFileBeingIngested(context.TODO(), "testuser", "TestIsFileBeingIngested.c4gh")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), beingIngested)
+
+	assert.NoError(suite.T(), db.SetArchived("/archive", FileInfo{fmt.Sprintf("%x", sha256.New()), 1000, fileID, fmt.Sprintf("%x", sha256.New()), -1, fmt.Sprintf("%x", sha256.New())}, fileID))
+	beingIngested, err = db.IsFileBeingIngested(context.TODO(), "testuser", "TestIsFileBeingIngested.c4gh")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), beingIngested)
+}
+
+func (suite *DatabaseTests) TestGetInboxFileCopy() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	sourceID, err := db.RegisterFile(nil, "/inbox", "TestGetInboxFileCopy.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+	assert.NoError(suite.T(), db.UpdateFileEventLog(sourceID, "uploaded", "testuser", "{}", "{}"))
+
+	copyID, copyPath, err := db.GetInboxFileCopy(context.TODO(), sourceID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "", copyID)
+	assert.Equal(suite.T(), "", copyPath)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "copy/TestGetInboxFileCopy.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "uploaded", "testuser", fmt.Sprintf(`{"copiedFrom": "%s"}`, sourceID), "{}"))
+
+	copyID, copyPath, err = db.GetInboxFileCopy(context.TODO(), sourceID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), fileID, copyID)
+	assert.Equal(suite.T(), "copy/TestGetInboxFileCopy.c4gh", copyPath)
+
+	// a copy that has been replaced since is no longer a copy of the source
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "uploaded", "testuser", "{}", "{}"))
+	copyID, _, err = db.GetInboxFileCopy(context.TODO(), sourceID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "", copyID)
+}
+
+func (suite *DatabaseTests) TestUpdateFileEventLogWithMessage() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestUpdateFileEventLogWithMessage.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+
+	message := OutboxMessage{CorrelationID: fileID, Exchange: "sda", RoutingKey: "archived", Body: []byte(`{"type":"archived"}`)}
+	assert.ErrorIs(suite.T(), db.UpdateFileEventLogWithMessage(context.TODO(), uuid.NewString(), "archived", "testuser", "{}", "{}", message), sql.ErrNoRows)
+	assert.NoError(suite.T(), db.UpdateFileEventLogWithMessage(context.TODO(), fileID, "archived", "testuser", "{}", "{}", message))
+
+	var pending int
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.outbox WHERE correlation_id = $1;", fileID).Scan(&pending))
+	assert.Equal(suite.T(), 1, pending, "only the message of the recorded event should be in the outbox")
+
+	var published []OutboxMessage
+	relayed, err := db.RelayOutbox(context.TODO(), 100, func(corrID, exchange, routingKey string, body []byte) error {
+		published = append(published, OutboxMessage{CorrelationID: corrID, Exchange: exchange, RoutingKey: routingKey, Body: body})
+
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, relayed)
+	assert.Equal(suite.T(), []OutboxMessage{message}, published)
+
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT COUNT(*) FROM sda.outbox;").Scan(&pending))
+	assert.Equal(suite.T(), 0, pending, "published messages should be removed from the outbox")
+}
+
+func (suite *DatabaseTests) TestRelayOutbox_PublishFailure() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got %v when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestRelayOutbox_PublishFailure.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file in database")
+	for _, routingKey := range []string{"first", "second"} {
+		message := OutboxMessage{CorrelationID: fileID, Exchange: "sda", RoutingKey: routingKey, Body: []byte("{}")}
+		assert.NoError(suite.T(), db.UpdateFileEventLogWithMessage(context.TODO(), fileID, "uploaded", "testuser", "{}", "{}", message))
+	}
+
+	relayed, err := db.RelayOutbox(context.TODO(), 100, func(_, _, _ string, _ []byte) error {
+		return errors.New("broker unavailable")
+	})
+	assert.ErrorContains(suite.T(), err, "broker unavailable")
+	assert.Equal(suite.T(), 0, relayed)
+
+	var attempts int
+	var lastError string
+	assert.NoError(suite.T(), db.DB.QueryRow("SELECT attempts, last_error FROM sda.outbox WHERE routing_key = 'first';").Scan(&attempts, &lastError))
+	assert.Equal(suite.T(), 1, attempts)
+	assert.Equal(suite.T(), "broker unavailable", lastError)
+
+	var routingKeys []string
+	relayed, err = db.RelayOutbox(context.TODO(), 100, func(_, _, routingKey string, _ []byte) error {
+		routingKeys = append(routingKeys, routingKey)
+
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 2, relayed)
+	assert.Equal(suite.T(), []string{"first", "second"}, routingKeys, "messages should be relayed in the order added")
+}
+
+func (suite *DatabaseTests) TestGetFileHistory() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/history/file.c4gh", "history-user")
+	assert.NoError(suite.T(), err, "failed to register file")
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "uploaded", "history-user", "{\"reason\":\"upload\"}", "{}"))
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "submitted", "ingest", "{}", "{\"type\":\"ingest\"}"))
+
+	events, more, err := db.GetFileHistory(context.TODO(), fileID, EventFilter{Limit: 2})
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), more)
+	assert.Len(suite.T(), events, 2)
+	assert.Equal(suite.T(), "registered", events[0].Event)
+	assert.Equal(suite.T(), "uploaded", events[1].Event)
+	assert.Equal(suite.T(), "history-user", events[1].User)
+	assert.JSONEq(suite.T(), `{"reason":"upload"}`, string(events[1].Details))
+
+	events, more, err = db.GetFileHistory(context.TODO(), fileID, EventFilter{Limit: 2, Offset: 2})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), more)
+	assert.Len(suite.T(), events, 1)
+	assert.Equal(suite.T(), "submitted", events[0].Event)
+	assert.JSONEq(suite.T(), `{"type":"ingest"}`, string(events[0].Message))
+
+	events, _, err = db.GetFileHistory(context.TODO(), fileID, EventFilter{Event: "uploaded", Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), events, 1)
+
+	events, _, err = db.GetFileHistory(context.TODO(), fileID, EventFilter{Since: time.Now().Add(time.Hour), Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), events)
+
+	_, _, err = db.GetFileHistory(context.TODO(), "00000000-0000-0000-0000-000000000000", EventFilter{Limit: 10})
+	assert.ErrorIs(suite.T(), err, sql.ErrNoRows)
+}
+
+func (suite *DatabaseTests) TestGetDatasetHistory() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/history/dataset-file.c4gh", "history-user")
+	assert.NoError(suite.T(), err, "failed to register file")
+	assert.NoError(suite.T(), db.SetAccessionID("accession-history-01", fileID))
+	assert.NoError(suite.T(), db.MapFilesToDataset("dataset-history-01", []string{"accession-history-01"}))
+	assert.NoError(suite.T(), db.UpdateDatasetEvent("dataset-history-01", "registered", "{\"type\": \"mapping\"}"))
+	assert.NoError(suite.T(), db.UpdateDatasetEvent("dataset-history-01", "released", "{\"type\": \"release\"}"))
+
+	events, more, err := db.GetDatasetHistory(context.TODO(), "dataset-history-01", EventFilter{Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), more)
+	assert.Len(suite.T(), events, 2)
+	assert.Equal(suite.T(), "registered", events[0].Event)
+	assert.Equal(suite.T(), "released", events[1].Event)
+	assert.JSONEq(suite.T(), `{"type": "release"}`, string(events[1].Message))
+
+	events, more, err = db.GetDatasetHistory(context.TODO(), "dataset-history-01", EventFilter{Event: "released", Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), more)
+	assert.Len(suite.T(), events, 1)
+
+	_, _, err = db.GetDatasetHistory(context.TODO(), "no-such-dataset", EventFilter{Limit: 10})
+	assert.ErrorIs(suite.T(), err, sql.ErrNoRows)
+}
+
+func (suite *DatabaseTests) TestGetFileStatusCounts() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	before, err := db.GetFileStatusCounts(context.TODO())
+	assert.NoError(suite.T(), err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/status/file.c4gh", "status-user")
+	assert.NoError(suite.T(), err, "failed to register file")
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "uploaded", "status-user", "{}", "{}"))
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "submitted", "status-user", "{}", "{}"))
+
+	after, err := db.GetFileStatusCounts(context.TODO())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), before["submitted"]+1, after["submitted"])
+	assert.Equal(suite.T(), before["uploaded"], after["uploaded"])
+	assert.Equal(suite.T(), before["registered"], after["registered"])
+}
+
+func (suite *DatabaseTests) TestGetStuckFiles() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/stuck/file.c4gh", "stuck-user")
+	assert.NoError(suite.T(), err, "failed to register file")
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "archived", "ingest", "{}", "{}"))
+
+	stuck, err := db.GetStuckFiles(context.TODO(), "archived", time.Now().Add(time.Minute), 1000)
+	assert.NoError(suite.T(), err)
+	found := false
+	for _, file := range stuck {
+		if file.FileID == fileID {
+			found = true
+			assert.Equal(suite.T(), "stuck-user", file.SubmissionUser)
+			assert.Equal(suite.T(), "/stuck/file.c4gh", file.SubmissionPath)
+		}
+	}
+	assert.True(suite.T(), found, "archived file not reported as stuck")
+
+	stuck, err = db.GetStuckFiles(context.TODO(), "archived", time.Now().Add(-time.Hour), 1000)
+	assert.NoError(suite.T(), err)
+	for _, file := range stuck {
+		assert.NotEqual(suite.T(), fileID, file.FileID)
+	}
+
+	stuck, err = db.GetStuckFiles(context.TODO(), "verified", time.Now().Add(time.Minute), 1000)
+	assert.NoError(suite.T(), err)
+	for _, file := range stuck {
+		assert.NotEqual(suite.T(), fileID, file.FileID)
+	}
+}
+
+func (suite *DatabaseTests) TestInboxQuotas() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	maxBytes, maxObjects := int64(1000), int64(2)
+	assert.NoError(suite.T(), db.SetInboxQuota(context.TODO(), InboxQuota{SubjectType: "user", Subject: "quota-user", MaxBytes: &maxBytes}))
+	assert.NoError(suite.T(), db.SetInboxQuota(context.TODO(), InboxQuota{SubjectType: "user", Subject: "quota-user", MaxBytes: &maxBytes, MaxObjects: &maxObjects}))
+	assert.NoError(suite.T(), db.SetInboxQuota(context.TODO(), InboxQuota{SubjectType: "group", Subject: "quota-group", MaxObjects: &maxObjects}))
+	assert.NoError(suite.T(), db.SetInboxQuota(context.TODO(), InboxQuota{SubjectType: "group", Subject: "other-group", MaxObjects: &maxObjects}))
+
+	quota, err := db.GetInboxQuota(context.TODO(), "user", "quota-user")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), &InboxQuota{SubjectType: "user", Subject: "quota-user", MaxBytes: &maxBytes, MaxObjects: &maxObjects}, quota)
+
+	_, err = db.GetInboxQuota(context.TODO(), "group", "quota-user")
+	assert.ErrorIs(suite.T(), err, sql.ErrNoRows)
+
+	quotas, err := db.ListInboxQuotas(context.TODO())
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), quotas, 3)
+
+	assert.NoError(suite.T(), db.UpdateUserInfo("quota-user", "Quota User", "quota@example.org", []string{"quota-group"}))
+	quotas, err = db.GetApplicableInboxQuotas(context.TODO(), "quota-user")
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), quotas, 2)
+	assert.Equal(suite.T(), "user", quotas[0].SubjectType)
+	assert.Equal(suite.T(), "quota-group", quotas[1].Subject)
+
+	assert.NoError(suite.T(), db.DeleteInboxQuota(context.TODO(), "group", "other-group"))
+	assert.ErrorIs(suite.T(), db.DeleteInboxQuota(context.TODO(), "group", "other-group"), sql.ErrNoRows)
+
+	db.Close()
+}
+
+func (suite *DatabaseTests) TestGetInboxUsage() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+
+	assert.NoError(suite.T(), db.UpdateUserInfo("usage-user", "Usage User", "usage@example.org", []string{"usage-group"}))
+
+	var fileIDs []string
+	for i := range 3 {
+		fileID, err := db.RegisterFile(nil, "/inbox", fmt.Sprintf("/usage/file%d.c4gh", i), "usage-user")
+		assert.NoError(suite.T(), err, "failed to register file")
+		assert.NoError(suite.T(), db.SetSubmissionFileSize(fileID, 100))
+		assert.NoError(suite.T(), db.UpdateFileEventLog(fileID, "uploaded", "inbox", "{}", "{}"))
+		fileIDs = append(fileIDs, fileID)
+	}
+	assert.NoError(suite.T(), db.UpdateFileEventLog(fileIDs[2], "disabled", "api", "{}", "{}"))
+
+	usage, err := db.GetInboxUsage(context.TODO(), "user", "usage-user", "")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), InboxUsage{Bytes: 200, Objects: 2}, usage)
+
+	usage, err = db.GetInboxUsage(context.TODO(), "user", "usage-user", fileIDs[0])
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), InboxUsage{Bytes: 100, Objects: 1}, usage)
+
+	usage, err = db.GetInboxUsage(context.TODO(), "group", "usage-group", "")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), InboxUsage{Bytes: 200, Objects: 2}, usage)
+
+	usage, err = db.GetInboxUsage(context.TODO(), "group", "no-members", "")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), InboxUsage{}, usage)
+
+	db.Close()
+}
+
+func (suite *DatabaseTests) TestInboxUploadParts() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+	defer db.Close()
+
+	part, err := db.GetInboxUploadPart(context.TODO(), "upload-1", 1)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), part)
+
+	for i := range 3 {
+		assert.NoError(suite.T(), db.AddInboxUploadPart(context.TODO(), "upload-1", InboxUploadPart{Number: i + 1, Size: 10, MD5: fmt.Sprintf("md5-%d", i+1), SHA256State: []byte{byte(i + 1)}}))
+	}
+	assert.NoError(suite.T(), db.AddInboxUploadPart(context.TODO(), "upload-2", InboxUploadPart{Number: 1, Size: 5, MD5: "other"}))
+
+	part, err = db.GetInboxUploadPart(context.TODO(), "upload-1", 2)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), &InboxUploadPart{Number: 2, Size: 10, MD5: "md5-2", SHA256State: []byte{2}}, part)
+
+	// uploading a part again clears the states of the parts after it
+	assert.NoError(suite.T(), db.AddInboxUploadPart(context.TODO(), "upload-1", InboxUploadPart{Number: 2, Size: 20, MD5: "md5-2b", SHA256State: []byte{4}}))
+	parts, err := db.GetInboxUploadParts(context.TODO(), "upload-1")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []InboxUploadPart{
+		{Number: 1, Size: 10, MD5: "md5-1", SHA256State: []byte{1}},
+		{Number: 2, Size: 20, MD5: "md5-2b", SHA256State: []byte{4}},
+		{Number: 3, Size: 10, MD5: "md5-3"},
+	}, parts)
+
+	assert.NoError(suite.T(), db.DeleteInboxUploadParts(context.TODO(), "upload-1"))
+	parts, err = db.GetInboxUploadParts(context.TODO(), "upload-1")
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), parts)
+	parts, err = db.GetInboxUploadParts(context.TODO(), "upload-2")
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), parts, 1)
+}
+
+func (suite *DatabaseTests) TestUploadedChecksum() {
+	db, err := NewSDAdb(suite.dbConf)
+	assert.NoError(suite.T(), err, "got (%v) when creating new connection", err)
+	defer db.Close()
+
+	fileID, err := db.RegisterFile(nil, "/inbox", "/testuser/TestUploadedChecksum.c4gh", "testuser")
+	assert.NoError(suite.T(), err, "failed to register file")
+
+	checksum, err := db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), checksum)
+
+	assert.NoError(suite.T(), db.SetUploadedChecksum(context.TODO(), fileID, "1234"))
+	assert.NoError(suite.T(), db.SetUploadedChecksum(context.TODO(), fileID, "5678"))
+	checksum, err = db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "5678", checksum)
+
+	assert.NoError(suite.T(), db.SetUploadedChecksum(context.TODO(), fileID, ""))
+	checksum, err = db.GetUploadedChecksum(context.TODO(), fileID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), checksum)
+}
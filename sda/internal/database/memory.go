@@ -0,0 +1,648 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
+)
+
+// MemoryDB is an in-process stand-in for the sda database, intended for tests of services which would otherwise
+// require PostgreSQL.
+//
+// Only the queries used by the ingestion pipeline, from the inbox to the release of a dataset, are implemented, with
+// the same results as their SDAdb counterparts. Files, their event log and checksums, encryption keys, datasets,
+// multipart uploads and the outbox are kept in memory. Changes are applied all at once, as if in a transaction, and
+// missing files are reported as sql.ErrNoRows where the database would violate a foreign key.
+type MemoryDB struct {
+	sync.Mutex
+	files map[string]*memoryFile
+	// fileIDs holds the ids of the files in the order they were registered
+	fileIDs   []string
+	keyHashes []C4ghKeyHash
+	// datasets holds the ids of the files of each dataset
+	datasets      map[string][]string
+	datasetEvents map[string][]string
+	outbox        []OutboxMessage
+	uploads       map[string]*uploadstate.Upload
+}
+
+type memoryFile struct {
+	id                 string
+	submissionLocation string
+	submissionFilePath string
+	submissionUser     string
+	submissionFileSize int64
+	// archived is set while the file has an archive location and size
+	archived          bool
+	archiveLocation   string
+	archiveFilePath   string
+	archiveFileSize   int64
+	decryptedFileSize int64
+	backupLocation    string
+	backupPath        string
+	stableID          string
+	keyHash           string
+	header            []byte
+	// checksums holds the sha256 checksums of the file by source, e.g. UPLOADED
+	checksums map[string]string
+	events    []string
+}
+
+// NewMemoryDB returns an empty MemoryDB
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		files:         make(map[string]*memoryFile),
+		datasets:      make(map[string][]string),
+		datasetEvents: make(map[string][]string),
+		uploads:       make(map[string]*uploadstate.Upload),
+	}
+}
+
+// FileEvents returns the events in the file event log of the file, oldest first
+func (m *MemoryDB) FileEvents(fileID string) []string {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		return slices.Clone(f.events)
+	}
+
+	return nil
+}
+
+// DatasetEvents returns the events in the dataset event log of the dataset, oldest first
+func (m *MemoryDB) DatasetEvents(datasetID string) []string {
+	m.Lock()
+	defer m.Unlock()
+
+	return slices.Clone(m.datasetEvents[datasetID])
+}
+
+// RegisterFile registers a file in the inbox, or reregisters the file of the user at uploadPath which has not been
+// archived yet
+func (m *MemoryDB) RegisterFile(fileID *string, inboxLocation, uploadPath, uploadUser string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var f *memoryFile
+	for _, id := range m.fileIDs {
+		if candidate := m.files[id]; candidate.submissionFilePath == uploadPath && candidate.submissionUser == uploadUser && candidate.archiveFilePath == "" {
+			f = candidate
+
+			break
+		}
+	}
+
+	if f == nil {
+		id := uuid.NewString()
+		if fileID != nil && *fileID != "" {
+			if _, err := uuid.Parse(*fileID); err != nil {
+				return "", err
+			}
+			id = *fileID
+		}
+		if _, ok := m.files[id]; ok {
+			return "", fmt.Errorf("file with id %s already exists", id)
+		}
+
+		f = &memoryFile{id: id, checksums: make(map[string]string)}
+		m.files[id] = f
+		m.fileIDs = append(m.fileIDs, id)
+	}
+
+	f.submissionLocation = inboxLocation
+	f.submissionFilePath = uploadPath
+	f.submissionUser = uploadUser
+	f.events = append(f.events, "registered")
+
+	return f.id, nil
+}
+
+// UpdateFileEventLog adds an event to the event log of the file
+func (m *MemoryDB) UpdateFileEventLog(fileUUID, event, _, _, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileUUID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.events = append(f.events, event)
+
+	return nil
+}
+
+// UpdateFileEventLogWithMessage adds an event to the event log of the file and the message to the outbox
+func (m *MemoryDB) UpdateFileEventLogWithMessage(_ context.Context, fileUUID, event, _, _, _ string, outboxMessage OutboxMessage) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileUUID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.events = append(f.events, event)
+	m.outbox = append(m.outbox, outboxMessage)
+
+	return nil
+}
+
+// GetFileStatus returns the latest event of the file
+func (m *MemoryDB) GetFileStatus(fileID string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok || len(f.events) == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return f.events[len(f.events)-1], nil
+}
+
+// GetSubmissionLocation returns the inbox location of the file, or an empty string if the file is not known
+func (m *MemoryDB) GetSubmissionLocation(_ context.Context, fileID string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		return f.submissionLocation, nil
+	}
+
+	return "", nil
+}
+
+// SetSubmissionFileSize sets the size of the file as uploaded to the inbox
+func (m *MemoryDB) SetSubmissionFileSize(fileID string, submissionFileSize int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		f.submissionFileSize = submissionFileSize
+	}
+
+	return nil
+}
+
+// GetUploadedChecksum returns the sha256 checksum of the file as uploaded, or an empty string if it is not known
+func (m *MemoryDB) GetUploadedChecksum(_ context.Context, fileID string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		return f.checksums["UPLOADED"], nil
+	}
+
+	return "", nil
+}
+
+// SetUploadedChecksum sets the sha256 checksum of the file as uploaded, an empty checksum removes it
+func (m *MemoryDB) SetUploadedChecksum(_ context.Context, fileID, checksum string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if checksum == "" {
+		delete(f.checksums, "UPLOADED")
+
+		return nil
+	}
+	f.checksums["UPLOADED"] = checksum
+
+	return nil
+}
+
+// IsFileBeingIngested reports whether the file of the user at filePath has been submitted for ingestion, but is not yet
+// archived
+func (m *MemoryDB) IsFileBeingIngested(_ context.Context, submissionUser, filePath string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, id := range m.fileIDs {
+		f := m.files[id]
+		if f.submissionUser == submissionUser && f.submissionFilePath == filePath && f.archiveFilePath == "" &&
+			len(f.events) > 0 && f.events[len(f.events)-1] == "submitted" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetFileIDInInbox returns the id of the file of the user at filePath which is still in the inbox, or an empty string
+// if there is none
+func (m *MemoryDB) GetFileIDInInbox(_ context.Context, submissionUser, filePath string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var ids []string
+	for _, id := range m.fileIDs {
+		f := m.files[id]
+		if f.submissionUser == submissionUser && f.submissionFilePath == filePath && f.archiveFilePath == "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+
+	// as the database, only the file with the lowest id is considered
+	f := m.files[slices.Min(ids)]
+	if len(f.events) > 0 && slices.Contains([]string{"registered", "uploaded", "disabled"}, f.events[len(f.events)-1]) {
+		return f.id, nil
+	}
+
+	return "", nil
+}
+
+// CancelFile removes the archive information and checksums of the file, and marks it as disabled
+func (m *MemoryDB) CancelFile(_ context.Context, fileID string, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.archived = false
+	f.archiveLocation = ""
+	f.archiveFilePath = ""
+	f.archiveFileSize = 0
+	f.decryptedFileSize = 0
+	f.stableID = ""
+	f.checksums = make(map[string]string)
+	f.events = append(f.events, "disabled")
+
+	return nil
+}
+
+// IsFileInDataset reports whether the file has been mapped to a dataset
+func (m *MemoryDB) IsFileInDataset(_ context.Context, fileID string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, fileIDs := range m.datasets {
+		if slices.Contains(fileIDs, fileID) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// AddKeyHash registers the hash of a crypt4gh public key
+func (m *MemoryDB) AddKeyHash(keyHash, keyDescription string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, h := range m.keyHashes {
+		if h.Hash == keyHash {
+			return errors.New("key hash already exists or no rows were updated")
+		}
+	}
+	m.keyHashes = append(m.keyHashes, C4ghKeyHash{
+		Hash:        keyHash,
+		Description: keyDescription,
+		CreatedAt:   time.Now().UTC().Format(time.DateTime),
+	})
+
+	return nil
+}
+
+// ListKeyHashes lists the registered key hashes, oldest first
+func (m *MemoryDB) ListKeyHashes() ([]C4ghKeyHash, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return append([]C4ghKeyHash{}, m.keyHashes...), nil
+}
+
+// SetKeyHash sets the hash of the key the file is encrypted with
+func (m *MemoryDB) SetKeyHash(keyHash, fileID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return errors.New("something went wrong with the query, zero rows were changed")
+	}
+	f.keyHash = keyHash
+
+	return nil
+}
+
+// StoreHeader stores the crypt4gh header of the file
+func (m *MemoryDB) StoreHeader(header []byte, id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[id]
+	if !ok {
+		return errors.New("something went wrong with the query zero rows were changed")
+	}
+	f.header = slices.Clone(header)
+
+	return nil
+}
+
+// GetHeader returns the crypt4gh header of the file
+func (m *MemoryDB) GetHeader(fileID string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return slices.Clone(f.header), nil
+}
+
+// SetArchivedWithMessage records where the file is archived, its uploaded checksum and the event, and adds the message
+// to the outbox
+func (m *MemoryDB) SetArchivedWithMessage(_ context.Context, location string, file FileInfo, fileID, event, _, _, _ string, outboxMessage OutboxMessage) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.archived = true
+	f.archiveLocation = location
+	f.archiveFilePath = file.Path
+	f.archiveFileSize = file.Size
+	f.checksums["UPLOADED"] = file.UploadedChecksum
+	f.events = append(f.events, event)
+	m.outbox = append(m.outbox, outboxMessage)
+
+	return nil
+}
+
+// GetArchived returns the archive information of the file, or nil if the file has not been archived
+func (m *MemoryDB) GetArchived(fileID string) (*ArchiveData, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok || !f.archived {
+		return nil, nil
+	}
+
+	return &ArchiveData{
+		FilePath:       f.archiveFilePath,
+		Location:       f.archiveLocation,
+		FileSize:       f.archiveFileSize,
+		BackupFilePath: f.backupPath,
+		BackupLocation: f.backupLocation,
+	}, nil
+}
+
+// GetArchiveLocation returns the archive location of the file, or an empty string if it is not known
+func (m *MemoryDB) GetArchiveLocation(fileID string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		return f.archiveLocation, nil
+	}
+
+	return "", nil
+}
+
+// GetFileInfo returns the archive path, size and checksums of the file
+func (m *MemoryDB) GetFileInfo(id string) (FileInfo, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[id]
+	if !ok {
+		return FileInfo{}, sql.ErrNoRows
+	}
+
+	return FileInfo{
+		ArchiveChecksum:   f.checksums["ARCHIVED"],
+		Size:              f.archiveFileSize,
+		Path:              f.archiveFilePath,
+		DecryptedChecksum: f.checksums["UNENCRYPTED"],
+		UploadedChecksum:  f.checksums["UPLOADED"],
+	}, nil
+}
+
+// GetDecryptedChecksum returns the sha256 checksum of the decrypted file
+func (m *MemoryDB) GetDecryptedChecksum(id string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	checksum, ok := f.checksums["UNENCRYPTED"]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return checksum, nil
+}
+
+// SetVerifiedWithMessage records the decrypted size and the checksums of the verified file and the event, and adds the
+// message to the outbox
+func (m *MemoryDB) SetVerifiedWithMessage(_ context.Context, file FileInfo, fileID, event, _, _, _ string, outboxMessage OutboxMessage) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.decryptedFileSize = file.DecryptedSize
+	f.checksums["ARCHIVED"] = file.ArchiveChecksum
+	f.checksums["UNENCRYPTED"] = file.DecryptedChecksum
+	f.events = append(f.events, event)
+	m.outbox = append(m.outbox, outboxMessage)
+
+	return nil
+}
+
+// CheckAccessionIDExists returns "same" if the file already has the accession id, "duplicate" if another file has it,
+// and an empty string otherwise
+func (m *MemoryDB) CheckAccessionIDExists(accessionID, fileID string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f, ok := m.files[fileID]; ok && f.stableID == accessionID {
+		return "same", nil
+	}
+	for _, f := range m.files {
+		if f.stableID == accessionID {
+			return "duplicate", nil
+		}
+	}
+
+	return "", nil
+}
+
+// SetAccessionID sets the accession id of the file
+func (m *MemoryDB) SetAccessionID(accessionID, fileID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return errors.New("something went wrong with the query zero rows were changed")
+	}
+	f.stableID = accessionID
+
+	return nil
+}
+
+// SetBackedUp records where the file is backed up
+func (m *MemoryDB) SetBackedUp(location, path, fileID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f.backupLocation = location
+	f.backupPath = path
+
+	return nil
+}
+
+// GetMappingData returns the submission information of the file with the accession id, or nil if there is none
+func (m *MemoryDB) GetMappingData(accessionID string) (*MappingData, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, id := range m.fileIDs {
+		if f := m.files[id]; f.stableID == accessionID {
+			return &MappingData{
+				FileID:             f.id,
+				User:               f.submissionUser,
+				SubmissionFilePath: f.submissionFilePath,
+				SubmissionLocation: f.submissionLocation,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// MapFilesToDataset adds the files with the accession ids to the dataset, which is created if it does not exist
+func (m *MemoryDB) MapFilesToDataset(datasetID string, accessionIDs []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	fileIDs := slices.Clone(m.datasets[datasetID])
+	for _, accessionID := range accessionIDs {
+		i := slices.IndexFunc(m.fileIDs, func(id string) bool { return m.files[id].stableID == accessionID })
+		if i < 0 {
+			return sql.ErrNoRows
+		}
+		if !slices.Contains(fileIDs, m.fileIDs[i]) {
+			fileIDs = append(fileIDs, m.fileIDs[i])
+		}
+	}
+	m.datasets[datasetID] = fileIDs
+
+	return nil
+}
+
+// UpdateDatasetEvent adds an event to the event log of the dataset
+func (m *MemoryDB) UpdateDatasetEvent(datasetID, status, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.datasets[datasetID]; !ok {
+		return fmt.Errorf("dataset %s does not exist", datasetID)
+	}
+	m.datasetEvents[datasetID] = append(m.datasetEvents[datasetID], status)
+
+	return nil
+}
+
+// RelayOutbox passes up to limit pending messages, oldest first, to publish and removes the ones published
+func (m *MemoryDB) RelayOutbox(_ context.Context, limit int, publish func(corrID, exchange, routingKey string, body []byte) error) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	published := 0
+	for published < limit && published < len(m.outbox) {
+		message := m.outbox[published]
+		if err := publish(message.CorrelationID, message.Exchange, message.RoutingKey, message.Body); err != nil {
+			m.outbox = m.outbox[published:]
+
+			return published, fmt.Errorf("failed to publish outbox message, due to: %v", err)
+		}
+		published++
+	}
+	m.outbox = m.outbox[published:]
+
+	return published, nil
+}
+
+// GetUpload returns the ongoing upload of the file, or nil if there is none
+func (m *MemoryDB) GetUpload(_ context.Context, fileID string) (*uploadstate.Upload, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	upload, ok := m.uploads[fileID]
+	if !ok {
+		return nil, nil
+	}
+	u := *upload
+	u.Parts = slices.Clone(upload.Parts)
+	slices.SortFunc(u.Parts, func(a, b uploadstate.Part) int { return int(a.Number - b.Number) })
+
+	return &u, nil
+}
+
+// SaveUpload records a new upload of the file, replacing any previous upload and its parts
+func (m *MemoryDB) SaveUpload(_ context.Context, fileID string, upload *uploadstate.Upload) error {
+	m.Lock()
+	defer m.Unlock()
+
+	u := *upload
+	u.Parts = nil
+	m.uploads[fileID] = &u
+
+	return nil
+}
+
+// AddPart records a completed part of the ongoing upload of the file
+func (m *MemoryDB) AddPart(_ context.Context, fileID string, part uploadstate.Part) error {
+	m.Lock()
+	defer m.Unlock()
+
+	upload, ok := m.uploads[fileID]
+	if !ok {
+		return fmt.Errorf("addPart error: no upload of file %s", fileID)
+	}
+	upload.Parts = slices.DeleteFunc(upload.Parts, func(p uploadstate.Part) bool { return p.Number == part.Number })
+	upload.Parts = append(upload.Parts, part)
+
+	return nil
+}
+
+// DeleteUpload removes the upload of the file and its parts
+func (m *MemoryDB) DeleteUpload(_ context.Context, fileID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.uploads, fileID)
+
+	return nil
+}
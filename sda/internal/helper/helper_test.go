@@ -193,3 +193,21 @@ func (ts *HelperTest) TestUnanonymizeFilepath_bareFileNameStartingWithUsername()
 	newPath := UnanonymizeFilepath(filePath, userName)
 	assert.Equal(ts.T(), filepath.Join(strings.Replace(userName, "@", "_", 1), filePath), newPath)
 }
+
+func (ts *HelperTest) TestFormatUploadFilePath() {
+	unixPath := "a/b/c.c4gh"
+	testPath := "a\\b\\c.c4gh"
+	uploadPath, err := FormatUploadFilePath(testPath)
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), unixPath, uploadPath)
+
+	// mixed "\" and "/"
+	weirdPath := `dq\sw:*?"<>|\t\s/df.c4gh`
+	_, err = FormatUploadFilePath(weirdPath)
+	assert.EqualError(ts.T(), err, "filepath contains mixed '\\' and '/' characters")
+
+	// no mixed "\" and "/" but not allowed
+	weirdPath = `dq\sw:*?"<>|\t\sdf!s'(a);w@4&f=+e$,g#[]d%.c4gh`
+	_, err = FormatUploadFilePath(weirdPath)
+	assert.EqualError(ts.T(), err, "filepath contains disallowed characters: :, *, ?, \", <, >, |, !, ', (, ), ;, @, &, =, +, $, ,, #, [, ], %")
+}
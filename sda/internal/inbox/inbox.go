@@ -0,0 +1,187 @@
+// Package inbox checks and registers the files uploaded to the inbox of a user, it is shared by the s3inbox and the
+// sftpinbox so that uploads are limited, recorded and announced the same way whichever way they are made.
+package inbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+)
+
+// Crypt4GHHeaderPeekSize is the amount of data from the start of an upload that is read to find the crypt4gh header,
+// it fits the headers of files encrypted for hundreds of recipients
+const Crypt4GHHeaderPeekSize = 64 * 1024
+
+// ErrBeingIngested is returned for files that can not be changed as ingest still reads them from the inbox
+var ErrBeingIngested = errors.New("the file is being ingested and can not be changed")
+
+// Database is the part of the sda database used to register uploads, it is satisfied by *database.SDAdb
+type Database interface {
+	GetFileIDInInbox(ctx context.Context, submissionUser, filePath string) (string, error)
+	IsFileBeingIngested(ctx context.Context, submissionUser, filePath string) (bool, error)
+	SetSubmissionFileSize(fileID string, submissionFileSize int64) error
+	SetUploadedChecksum(ctx context.Context, fileID, checksum string) error
+	UpdateFileEventLog(fileUUID, event, user, details, message string) error
+}
+
+// QuotaDatabase is the part of the sda database used to check uploads against the inbox quotas, it is satisfied by
+// *database.SDAdb
+type QuotaDatabase interface {
+	GetApplicableInboxQuotas(ctx context.Context, user string) ([]*database.InboxQuota, error)
+	GetInboxUsage(ctx context.Context, subjectType, subject, excludeFileID string) (database.InboxUsage, error)
+}
+
+// SendFunc sends a message about the file with fileID to the broker
+type SendFunc func(fileID string, message []byte) error
+
+// Upload is a file that has been written in full to the inbox storage
+type Upload struct {
+	FileID string
+	User   string
+	// StoragePath is the path of the file in the inbox storage, as announced in messages
+	StoragePath string
+	Size        int64
+	// SHA256 is the checksum of the uploaded data, empty when it is not known
+	SHA256 string
+	// Message is the inbox-upload message announcing the file
+	Message []byte
+	// Reupload is set when the upload replaced a file already in the inbox
+	Reupload bool
+	// Details are recorded with the uploaded event of the file, such as the source of a copy
+	Details map[string]string
+}
+
+// FileID returns the id of the file of the user at filePath in the inbox, or an empty string if there is none.
+// ErrBeingIngested is returned for files that have been submitted for ingestion.
+func FileID(ctx context.Context, db Database, user, filePath string) (string, error) {
+	beingIngested, err := db.IsFileBeingIngested(ctx, user, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check file status in database: %v", err)
+	}
+	if beingIngested {
+		return "", ErrBeingIngested
+	}
+
+	fileID, err := db.GetFileIDInInbox(ctx, user, filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check/get existing file id from database: %v", err)
+	}
+
+	return fileID, nil
+}
+
+// Uploaded announces the upload with its inbox-upload message and records the size, checksum and uploaded status of
+// the file. A reupload is also announced with an inbox-remove message, so that the replaced file is known to be gone.
+func Uploaded(ctx context.Context, db Database, send SendFunc, upload Upload) error {
+	details := []byte("{}")
+	if len(upload.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(upload.Details); err != nil {
+			return fmt.Errorf("failed to marshal event details: %v", err)
+		}
+	}
+
+	if err := send(upload.FileID, upload.Message); err != nil {
+		return fmt.Errorf("broker error: %v", err)
+	}
+
+	if err := db.SetSubmissionFileSize(upload.FileID, upload.Size); err != nil {
+		return fmt.Errorf("failed to set file size in database: %v", err)
+	}
+
+	// a reupload without a known checksum removes the checksum of the replaced upload
+	if err := db.SetUploadedChecksum(ctx, upload.FileID, upload.SHA256); err != nil {
+		return fmt.Errorf("failed to store checksum in database: %v", err)
+	}
+
+	if err := db.UpdateFileEventLog(upload.FileID, "uploaded", "inbox", string(details), string(upload.Message)); err != nil {
+		return fmt.Errorf("failed to set file as uploaded in database: %v", err)
+	}
+
+	if !upload.Reupload {
+		return nil
+	}
+
+	message, err := RemoveMessage(upload.User, upload.StoragePath)
+	if err != nil {
+		return err
+	}
+	if err := send(upload.FileID, message); err != nil {
+		return fmt.Errorf("broker error: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveMessage returns the inbox-remove message announcing that the file at storagePath has been removed from the
+// inbox of the user, or replaced by a reupload
+func RemoveMessage(user, storagePath string) ([]byte, error) {
+	message, err := json.Marshal(schema.InboxRemove{
+		User:      user,
+		FilePath:  storagePath,
+		Operation: "remove",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	return message, nil
+}
+
+// QuotaExceeded returns why storing size more bytes, and a new object if newObject is set, would take the user or one
+// of the groups of the user over their inbox quota, or an empty string if it would not. The file replacedFileID is not
+// counted towards the usage.
+func QuotaExceeded(ctx context.Context, db QuotaDatabase, user, replacedFileID string, size int64, newObject bool) (string, error) {
+	quotas, err := db.GetApplicableInboxQuotas(ctx, user)
+	if err != nil {
+		return "", fmt.Errorf("failed to get inbox quotas from database: %v", err)
+	}
+
+	for _, quota := range quotas {
+		if quota.MaxBytes == nil && (quota.MaxObjects == nil || !newObject) {
+			continue
+		}
+
+		usage, err := db.GetInboxUsage(ctx, quota.SubjectType, quota.Subject, replacedFileID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get inbox usage from database: %v", err)
+		}
+
+		switch {
+		case quota.MaxBytes != nil && usage.Bytes+size > *quota.MaxBytes:
+			return fmt.Sprintf("the inbox quota of %d bytes for %s %s would be exceeded", *quota.MaxBytes, quota.SubjectType, quota.Subject), nil
+		case newObject && quota.MaxObjects != nil && usage.Objects+1 > *quota.MaxObjects:
+			return fmt.Sprintf("the inbox quota of %d objects for %s %s would be exceeded", *quota.MaxObjects, quota.SubjectType, quota.Subject), nil
+		}
+	}
+
+	return "", nil
+}
+
+// InvalidCrypt4GHHeader returns why data does not start with a crypt4gh header that one of the keys can decrypt, or
+// an empty string if it does. Users learn at once that a file was encrypted with the wrong key, instead of when
+// ingest fails.
+func InvalidCrypt4GHHeader(data []byte, keyList []*[32]byte) string {
+	if len(data) < len(headers.MagicNumber) || string(data[:len(headers.MagicNumber)]) != headers.MagicNumber {
+		return "the file is not encrypted with crypt4gh, encrypt it with the public key of the archive before uploading"
+	}
+
+	header, err := headers.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Sprintf("the crypt4gh header of the file is malformed: %v", err)
+	}
+
+	for _, key := range keyList {
+		if _, err := headers.NewHeader(bytes.NewReader(header), *key); err == nil {
+			return ""
+		}
+	}
+
+	return "the file is not encrypted with the public key of the archive, encrypt it with the public key of the archive and upload it again"
+}
@@ -0,0 +1,94 @@
+package inbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quotaDatabase holds the quotas of a user and the usage of each quota subject
+type quotaDatabase struct {
+	quotas []*database.InboxQuota
+	usage  map[string]database.InboxUsage
+	err    error
+}
+
+func (db *quotaDatabase) GetApplicableInboxQuotas(_ context.Context, _ string) ([]*database.InboxQuota, error) {
+	return db.quotas, db.err
+}
+
+func (db *quotaDatabase) GetInboxUsage(_ context.Context, _, subject, _ string) (database.InboxUsage, error) {
+	return db.usage[subject], nil
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	maxBytes := int64(100)
+	maxObjects := int64(2)
+	db := &quotaDatabase{
+		quotas: []*database.InboxQuota{
+			{SubjectType: "user", Subject: "dummy", MaxObjects: &maxObjects},
+			{SubjectType: "group", Subject: "project", MaxBytes: &maxBytes},
+		},
+		usage: map[string]database.InboxUsage{
+			"dummy":   {Bytes: 40, Objects: 1},
+			"project": {Bytes: 90, Objects: 5},
+		},
+	}
+
+	reason, err := QuotaExceeded(context.TODO(), db, "dummy", "", 10, true)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	reason, err = QuotaExceeded(context.TODO(), db, "dummy", "", 11, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "the inbox quota of 100 bytes for group project would be exceeded", reason)
+
+	db.usage["dummy"] = database.InboxUsage{Bytes: 40, Objects: 2}
+	reason, err = QuotaExceeded(context.TODO(), db, "dummy", "", 0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "the inbox quota of 2 objects for user dummy would be exceeded", reason)
+
+	// A full object quota only stops new objects
+	reason, err = QuotaExceeded(context.TODO(), db, "dummy", "", 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	db.err = errors.New("connection refused")
+	_, err = QuotaExceeded(context.TODO(), db, "dummy", "", 0, true)
+	assert.ErrorContains(t, err, "failed to get inbox quotas")
+}
+
+func TestInvalidCrypt4GHHeader(t *testing.T) {
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	otherPublicKey, _, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	keyList := []*[32]byte{&archivePrivateKey}
+
+	encrypt := func(publicKey [32]byte) []byte {
+		_, privateKey, err := keys.GenerateKeyPair()
+		require.NoError(t, err)
+		var encrypted bytes.Buffer
+		writer, err := streaming.NewCrypt4GHWriter(&encrypted, privateKey, [][32]byte{publicKey}, nil)
+		require.NoError(t, err)
+		_, err = writer.Write([]byte("some genomic data"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		return encrypted.Bytes()
+	}
+
+	valid := encrypt(archivePublicKey)
+	assert.Empty(t, InvalidCrypt4GHHeader(valid, keyList))
+	assert.Contains(t, InvalidCrypt4GHHeader(encrypt(otherPublicKey), keyList), "not encrypted with the public key of the archive")
+	assert.Contains(t, InvalidCrypt4GHHeader([]byte("some genomic data"), keyList), "not encrypted with crypt4gh")
+	assert.Contains(t, InvalidCrypt4GHHeader(nil, keyList), "not encrypted with crypt4gh")
+	assert.Contains(t, InvalidCrypt4GHHeader(valid[:20], keyList), "header of the file is malformed")
+}
@@ -0,0 +1,265 @@
+// Package finalize handles the messages of the finalize service, which
+// accepts messages with accessionIDs for ingested files and registers them in
+// the database.
+package finalize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Database is the part of the sda database used by finalize, it is satisfied by *database.SDAdb
+type Database interface {
+	CheckAccessionIDExists(accessionID, fileID string) (string, error)
+	GetArchived(fileID string) (*database.ArchiveData, error)
+	GetFileStatus(fileID string) (string, error)
+	SetAccessionID(accessionID, fileID string) error
+	SetBackedUp(location, path, fileID string) error
+	UpdateFileEventLog(fileUUID, event, user, details, message string) error
+	UpdateFileEventLogWithMessage(ctx context.Context, fileUUID, event, user, details, message string, outboxMessage database.OutboxMessage) error
+}
+
+// Finalize holds what the finalize service needs to handle messages, files are backed up when both an archive reader
+// and a backup writer are set
+type Finalize struct {
+	ArchiveReader storage.Reader
+	BackupWriter  storage.Writer
+	DB            Database
+	MQ            broker.Bus
+	MQConf        broker.MQConf
+	Outbox        *broker.OutboxRelay
+}
+
+// StartConsumer handles the messages of the queue of the service until the bus is closed
+func (app *Finalize) StartConsumer(ctx context.Context) error {
+	messages, err := app.MQ.Consume(app.MQConf.Queue)
+	if err != nil {
+		return err
+	}
+	for delivered := range messages {
+		app.HandleMessage(ctx, delivered)
+	}
+
+	return nil
+}
+
+// HandleMessage handles an ingestion-accession message, marking the file as ready
+func (app *Finalize) HandleMessage(ctx context.Context, delivered broker.Delivery) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	log.Debugf("Received a message (correlation-id: %s, message: %s)", delivered.CorrelationID, delivered.Body)
+	if err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession.json", app.MQConf.SchemasPath), delivered.Body); err != nil {
+		log.Errorf("validation of incoming message (ingestion-accession) failed, correlation-id: %s, reason: %v ", delivered.CorrelationID, err)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking canceled work, reason: %v", err)
+		}
+
+		return
+	}
+
+	fileID := delivered.CorrelationID
+	var message schema.IngestionAccession
+	// we unmarshal the message in the validation step so this is safe to do
+	_ = json.Unmarshal(delivered.Body, &message)
+	// If the file has been canceled by the uploader, don't spend time working on it.
+	status, err := app.DB.GetFileStatus(fileID)
+	if err != nil {
+		log.Errorf("failed to get file status, file-id: %s, reason: %v", fileID, err)
+		if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+			log.Errorf("failed to retry message, reason: %v", err)
+		}
+
+		return
+	}
+
+	switch status {
+	case "disabled":
+		log.Infof("file with file-id: %s is disabled, aborting work", fileID)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking canceled work, reason: %v", err)
+		}
+
+		return
+	case "verified", "enabled":
+	case "ready":
+		log.Infof("File with file-id: %s is already marked as ready.", fileID)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking message, reason: %v", err)
+		}
+
+		return
+	default:
+		log.Warnf("file with file-id: %s is not verified yet, aborting work", fileID)
+		if err := broker.Retry(app.MQ, app.MQConf, delivered, fmt.Errorf("file is not verified yet, status: %s", status)); err != nil {
+			log.Errorf("failed to retry message, reason: %v", err)
+		}
+
+		return
+	}
+
+	c := schema.IngestionCompletion{
+		User:               message.User,
+		FilePath:           message.FilePath,
+		AccessionID:        message.AccessionID,
+		DecryptedChecksums: message.DecryptedChecksums,
+	}
+	completeMsg, _ := json.Marshal(&c)
+
+	if err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-completion.json", app.MQConf.SchemasPath), completeMsg); err != nil {
+		log.Errorf("Validation of outgoing message ingestion-completion failed, reason: (%v). Message body: %s\n", err, string(completeMsg))
+
+		return
+	}
+
+	accessionIDExists, err := app.DB.CheckAccessionIDExists(message.AccessionID, fileID)
+	if err != nil {
+		log.Errorf("CheckAccessionIdExists failed, file-id: %s, reason: %v ", fileID, err)
+		if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+			log.Errorf("failed to retry message, reason: %v", err)
+		}
+
+		return
+	}
+
+	switch accessionIDExists {
+	case "duplicate":
+		log.Errorf("accession ID already exists in the system, file-id: %s, accession-id: %s\n", fileID, message.AccessionID)
+		// Send the message to an error queue so it can be analyzed.
+		fileError := broker.InfoError{
+			Error:           "There is a conflict regarding the file accessionID",
+			Reason:          "The Accession ID already exists in the database, skipping marking it ready.",
+			OriginalMessage: message,
+		}
+		body, _ := json.Marshal(fileError)
+
+		// Send the message to an error queue so it can be analyzed.
+		if err := app.MQ.SendMessage(fileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: %v", err)
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed to Ack message, reason: %v", err)
+		}
+
+		return
+	case "same":
+		log.Infof("file already has a stable ID, marking it as ready, file-id: %s", fileID)
+	default:
+		if app.ArchiveReader != nil && app.BackupWriter != nil {
+			if err = app.backupFile(ctx, delivered); err != nil {
+				log.Errorf("failed to backup file, file-id: %s, reason: %v", fileID, err)
+				if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+					log.Errorf("failed to retry message, reason: %v", err)
+				}
+
+				return
+			}
+		}
+
+		if err := app.DB.SetAccessionID(message.AccessionID, fileID); err != nil {
+			log.Errorf("failed to set accessionID for file, file-id: %s, reason: %v", fileID, err)
+			if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+				log.Errorf("failed to retry message, reason: %v", err)
+			}
+
+			return
+		}
+	}
+
+	// Mark file as "ready", the complete message is published by the outbox relay
+	if err := app.DB.UpdateFileEventLogWithMessage(ctx, fileID, "ready", "finalize", "{}", string(delivered.Body), database.OutboxMessage{
+		CorrelationID: fileID,
+		Exchange:      app.MQConf.Exchange,
+		RoutingKey:    app.MQConf.RoutingKey,
+		Body:          completeMsg,
+	}); err != nil {
+		log.Errorf("set status ready failed, file-id: %s, reason: %v", fileID, err)
+		if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+			log.Errorf("failed to retry message, reason: %v", err)
+		}
+
+		return
+	}
+	app.Outbox.Notify()
+
+	if err := delivered.Ack(); err != nil {
+		log.Errorf("failed to Ack message, reason: %v", err)
+	}
+}
+
+func (app *Finalize) backupFile(ctx context.Context, delivered broker.Delivery) error {
+	log.Debug("Backup initiated")
+	fileID := delivered.CorrelationID
+
+	archiveData, err := app.DB.GetArchived(fileID)
+	if err != nil {
+		return fmt.Errorf("failed to get file archive information, reason: %v", err)
+	}
+
+	if archiveData == nil {
+		return fmt.Errorf("file archive data not found in database, file-id: %s", fileID)
+	}
+
+	// Get size on disk, will also give some time for the file to appear if it has not already
+	diskFileSize, err := app.ArchiveReader.GetFileSize(ctx, archiveData.Location, archiveData.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to get size info for archived file, reason: %v", err)
+	}
+
+	if diskFileSize != archiveData.FileSize {
+		return fmt.Errorf("archive file size does not match registered file size, (disk size: %d, db size: %d)", diskFileSize, archiveData.FileSize)
+	}
+
+	file, err := app.ArchiveReader.NewFileReader(ctx, archiveData.Location, archiveData.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archived file, reason: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	contentReader, contentWriter := io.Pipe()
+	go func() {
+		defer func() {
+			_ = contentWriter.Close()
+		}()
+
+		if copiedSize, err := io.Copy(contentWriter, file); err != nil {
+			_ = contentWriter.CloseWithError(fmt.Errorf("failed to copy file, reason: %v", err))
+		} else if copiedSize != archiveData.FileSize {
+			_ = contentWriter.CloseWithError(errors.New("copied size does not match file size"))
+		}
+	}()
+
+	backupLocation, err := app.BackupWriter.WriteFile(ctx, archiveData.FilePath, contentReader)
+	if err != nil {
+		_ = contentReader.Close()
+
+		return fmt.Errorf("failed to write file to backup storage, reason: %v", err)
+	}
+	_ = contentReader.Close()
+
+	// Mark file as "backed up" and populate backup path and location
+	if err := app.DB.SetBackedUp(backupLocation, archiveData.FilePath, fileID); err != nil {
+		return fmt.Errorf("SetBackedUp failed, reason: (%v)", err)
+	}
+
+	if err := app.DB.UpdateFileEventLog(fileID, "backed up", "finalize", "{}", string(delivered.Body)); err != nil {
+		return fmt.Errorf("UpdateFileEventLog failed, reason: (%v)", err)
+	}
+
+	log.Debug("Backup completed")
+
+	return nil
+}
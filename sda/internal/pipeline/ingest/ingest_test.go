@@ -1,4 +1,4 @@
-package main
+package ingest
 
 import (
 	"context"
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,6 +31,7 @@ import (
 	"github.com/neicnordic/sensitive-data-archive/internal/helper"
 	"github.com/neicnordic/sensitive-data-archive/internal/schema"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/memory"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
 	"github.com/spf13/viper"
@@ -45,7 +47,7 @@ func TestMain(m *testing.M) {
 		m.Run()
 	}
 	_, b, _, _ := runtime.Caller(0)
-	rootDir := path.Join(path.Dir(b), "../../../")
+	rootDir := path.Join(path.Dir(b), "../../../../")
 
 	// uses a sensible default on windows (tcp/http) and linux/osx (socket)
 	pool, err := dockertest.NewPool("")
@@ -172,6 +174,7 @@ type TestSuite struct {
 	filePath   string
 	pubKeyList [][32]byte
 	ingest     Ingest
+	db         *database.SDAdb
 	tempDir    string
 	UserName   string
 
@@ -217,26 +220,29 @@ func (ts *TestSuite) SetupSuite() {
 	viper.Set("db.password", "rootpasswd")
 	viper.Set("db.database", "sda")
 	viper.Set("db.sslMode", "disable")
-	viper.Set("schema.path", "../../schemas/isolated/")
+	viper.Set("schema.path", "../../../schemas/isolated/")
 
 	ingestConf, err := config.NewConfig("ingest")
 	if err != nil {
 		ts.FailNowf("failed to init config: %s", err.Error())
 	}
-	ts.ingest.DB, err = database.NewSDAdb(ingestConf.Database)
+	ts.db, err = database.NewSDAdb(ingestConf.Database)
 	if err != nil {
 		ts.FailNowf("failed to setup database connection: %s", err.Error())
 	}
-	ts.ingest.MQ, err = broker.NewMQ(ingestConf.Broker)
+	ts.ingest.DB = ts.db
+	ts.ingest.MQConf = ingestConf.Broker
+	ts.ingest.MQ, err = broker.NewBus(ingestConf.Broker)
 	if err != nil {
 		ts.FailNowf("failed to setup rabbitMQ connection: %s", err.Error())
 	}
+	ts.ingest.Outbox = broker.NewOutboxRelay(ts.db, ts.ingest.MQ, time.Second)
 	ts.ingest.ArchiveKeyList, err = config.GetC4GHprivateKeys()
 	if err != nil {
 		ts.FailNow("no private keys configured")
 	}
 
-	if err := ts.ingest.DB.AddKeyHash(hex.EncodeToString(publicKey[:]), "the test key"); err != nil {
+	if err := ts.db.AddKeyHash(hex.EncodeToString(publicKey[:]), "the test key"); err != nil {
 		ts.FailNow("failed to register the public key")
 	}
 
@@ -308,7 +314,7 @@ storage:
 		ts.FailNow(err.Error())
 	}
 
-	lb, err := locationbroker.NewLocationBroker(ts.ingest.DB)
+	lb, err := locationbroker.NewLocationBroker(ts.db)
 	ts.NoError(err)
 	ts.ingest.ArchiveWriter, err = storage.NewWriter(context.TODO(), "archive", lb)
 	if err != nil {
@@ -397,10 +403,10 @@ func (ts *TestSuite) TestCancelFile_NotYetArchived() {
 	// prepare the DB entries
 	userName := "test-cancel"
 	file1 := fmt.Sprintf("/%v/TestCancelMessage.c4gh", userName)
-	fileID, err := ts.ingest.DB.RegisterFile(nil, "/inbox", file1, userName)
+	fileID, err := ts.db.RegisterFile(nil, "/inbox", file1, userName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -418,14 +424,14 @@ func (ts *TestSuite) TestCancelFile() {
 	// prepare the DB entries
 	userName := "test-cancel"
 	file1 := fmt.Sprintf("/%v/TestCancelMessage.c4gh", userName)
-	fileID, err := ts.ingest.DB.RegisterFile(nil, "/inbox", file1, userName)
+	fileID, err := ts.db.RegisterFile(nil, "/inbox", file1, userName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
-	assert.NoError(ts.T(), ts.ingest.DB.SetArchived(ts.archiveDir, database.FileInfo{
+	assert.NoError(ts.T(), ts.db.SetArchived(ts.archiveDir, database.FileInfo{
 		ArchiveChecksum:   "123",
 		Size:              500,
 		Path:              fileID,
@@ -449,10 +455,10 @@ func (ts *TestSuite) TestCancelFile_wrongCorrelationID() {
 	// prepare the DB entries
 	userName := "test-cancel"
 	file1 := fmt.Sprintf("/%v/TestCancelMessage_wrongCorrelationID.c4gh", userName)
-	fileID, err := ts.ingest.DB.RegisterFile(nil, "/inbox", file1, userName)
+	fileID, err := ts.db.RegisterFile(nil, "/inbox", file1, userName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", userName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -468,10 +474,10 @@ func (ts *TestSuite) TestCancelFile_wrongCorrelationID() {
 // messages of type `ingest`
 func (ts *TestSuite) TestIngestFile() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -486,10 +492,10 @@ func (ts *TestSuite) TestIngestFile() {
 
 func (ts *TestSuite) TestNoSubmissionLocation() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, "/inbox", ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, "/inbox", ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -504,10 +510,10 @@ func (ts *TestSuite) TestNoSubmissionLocation() {
 
 func (ts *TestSuite) TestIngestFile_secondTime() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -533,10 +539,10 @@ func (ts *TestSuite) TestIngestFile_unknownInboxType() {
 }
 func (ts *TestSuite) TestIngestFile_reingestCancelledFile() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -548,7 +554,7 @@ func (ts *TestSuite) TestIngestFile_reingestCancelledFile() {
 
 	assert.Equal(ts.T(), "ack", ts.ingest.ingestFile(context.TODO(), fileID, message))
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -556,10 +562,10 @@ func (ts *TestSuite) TestIngestFile_reingestCancelledFile() {
 }
 func (ts *TestSuite) TestIngestFile_reingestCancelledFileNewChecksum() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -571,7 +577,7 @@ func (ts *TestSuite) TestIngestFile_reingestCancelledFileNewChecksum() {
 
 	assert.Equal(ts.T(), "ack", ts.ingest.ingestFile(context.TODO(), fileID, message))
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -618,7 +624,7 @@ func (ts *TestSuite) TestIngestFile_reingestCancelledFileNewChecksum() {
 	// DB should have the new checksum
 	var dbChecksum string
 	const q = "SELECT checksum from sda.checksums WHERE source = 'UPLOADED' and file_id = $1;"
-	if err := ts.ingest.DB.DB.QueryRow(q, fileID).Scan(&dbChecksum); err != nil {
+	if err := ts.db.DB.QueryRow(q, fileID).Scan(&dbChecksum); err != nil {
 		ts.FailNow("failed to get checksum from database")
 	}
 
@@ -626,10 +632,10 @@ func (ts *TestSuite) TestIngestFile_reingestCancelledFileNewChecksum() {
 }
 func (ts *TestSuite) TestIngestFile_reingestVerifiedFile() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -648,7 +654,7 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedFile() {
 	fi.DecryptedChecksum = hex.EncodeToString(sha256hash.Sum(nil))
 	fi.DecryptedSize = 10 * 1024 * 1024
 	fi.Size = (10 * 1024 * 1024) + 456
-	if err := ts.ingest.DB.SetVerified(fi, fileID); err != nil {
+	if err := ts.db.SetVerified(fi, fileID); err != nil {
 		ts.Fail("failed to mark file as verified")
 	}
 
@@ -656,10 +662,10 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedFile() {
 }
 func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFile() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -678,11 +684,11 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFile() {
 	fi.DecryptedChecksum = hex.EncodeToString(sha256hash.Sum(nil))
 	fi.DecryptedSize = 10 * 1024 * 1024
 	fi.Size = (10 * 1024 * 1024) + 456
-	if err := ts.ingest.DB.SetVerified(fi, fileID); err != nil {
+	if err := ts.db.SetVerified(fi, fileID); err != nil {
 		ts.Fail("failed to mark file as verified")
 	}
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -690,10 +696,10 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFile() {
 }
 func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFileNewChecksum() {
 	// prepare the DB entries
-	fileID, err := ts.ingest.DB.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
+	fileID, err := ts.db.RegisterFile(nil, ts.inboxDir, ts.filePath, ts.UserName)
 	assert.NoError(ts.T(), err, "failed to register file in database")
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -707,7 +713,7 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFileNewChecksum() {
 
 	var firstDbChecksum string
 	const q1 = "SELECT checksum from sda.checksums WHERE source = 'UPLOADED' and file_id = $1;"
-	if err := ts.ingest.DB.DB.QueryRow(q1, fileID).Scan(&firstDbChecksum); err != nil {
+	if err := ts.db.DB.QueryRow(q1, fileID).Scan(&firstDbChecksum); err != nil {
 		ts.FailNow("failed to get checksum from database")
 	}
 
@@ -718,11 +724,11 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFileNewChecksum() {
 	fi.DecryptedChecksum = hex.EncodeToString(verifiedSha256.Sum(nil))
 	fi.DecryptedSize = 10 * 1024 * 1024
 	fi.Size = (10 * 1024 * 1024) + 456
-	if err := ts.ingest.DB.SetVerified(fi, fileID); err != nil {
+	if err := ts.db.SetVerified(fi, fileID); err != nil {
 		ts.Fail("failed to mark file as verified")
 	}
 
-	if err = ts.ingest.DB.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
+	if err = ts.db.UpdateFileEventLog(fileID, "disabled", "ingest", "{}", "{}"); err != nil {
 		ts.Fail("failed to update file event log")
 	}
 
@@ -769,7 +775,7 @@ func (ts *TestSuite) TestIngestFile_reingestVerifiedCancelledFileNewChecksum() {
 	// DB should have the new checksum
 	var dbChecksum string
 	const q = "SELECT checksum from sda.checksums WHERE source = 'UPLOADED' and file_id = $1;"
-	if err := ts.ingest.DB.DB.QueryRow(q, fileID).Scan(&dbChecksum); err != nil {
+	if err := ts.db.DB.QueryRow(q, fileID).Scan(&dbChecksum); err != nil {
 		ts.FailNow("failed to get checksum from database")
 	}
 
@@ -800,16 +806,16 @@ func (ts *TestSuite) TestDetectMisingC4GHKeys() {
 }
 
 func (ts *TestSuite) TestRegisterC4ghKey_newDeployment() {
-	_, err := ts.ingest.DB.DB.Exec("TRUNCATE sda.encryption_keys CASCADE;")
+	_, err := ts.db.DB.Exec("TRUNCATE sda.encryption_keys CASCADE;")
 	assert.NoError(ts.T(), err)
 
 	privateKeys, err := config.GetC4GHprivateKeys()
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), 2, len(privateKeys))
 
-	assert.NoError(ts.T(), ts.ingest.registerC4GHKey())
+	assert.NoError(ts.T(), ts.ingest.RegisterC4GHKey())
 
-	kh, err := ts.ingest.DB.ListKeyHashes()
+	kh, err := ts.db.ListKeyHashes()
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), 2, len(kh))
 }
@@ -819,9 +825,78 @@ func (ts *TestSuite) TestRegisterC4ghKey_existingEntry() {
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), 2, len(privateKeys))
 
-	assert.NoError(ts.T(), ts.ingest.registerC4GHKey())
+	assert.NoError(ts.T(), ts.ingest.RegisterC4GHKey())
 
-	kh, err := ts.ingest.DB.ListKeyHashes()
+	kh, err := ts.db.ListKeyHashes()
 	assert.NoError(ts.T(), err)
 	assert.Equal(ts.T(), 1, len(kh))
 }
+
+func (ts *TestSuite) TestHandleMessage_inMemory() {
+	definitions, err := os.Open("../../../../rabbitmq/definitions.json")
+	ts.Require().NoError(err)
+	defer definitions.Close()
+	bus := broker.NewMemoryBus(1)
+	ts.Require().NoError(bus.LoadDefinitions(definitions))
+	defer bus.Close()
+
+	inbox := memory.NewStorage("memory://inbox")
+	archive := memory.NewStorage("memory://archive")
+
+	app := ts.ingest
+	app.MQ = bus
+	app.MQConf.Exchange = "sda"
+	app.MQConf.RoutingKey = "archived"
+	app.InboxReader = inbox
+	app.ArchiveReader = archive
+	app.ArchiveWriter = archive
+
+	f, err := os.Open(filepath.Join(ts.inboxDir, ts.UserName, ts.filePath))
+	ts.Require().NoError(err)
+	defer f.Close()
+	_, err = inbox.WriteFile(context.TODO(), filepath.Join(ts.UserName, ts.filePath), f)
+	ts.Require().NoError(err)
+
+	fileID, err := ts.db.RegisterFile(nil, inbox.Location(), ts.filePath, ts.UserName)
+	ts.Require().NoError(err)
+	ts.Require().NoError(ts.db.UpdateFileEventLog(fileID, "uploaded", ts.UserName, "{}", "{}"))
+
+	trigger, _ := json.Marshal(schema.IngestionTrigger{Type: "ingest", FilePath: ts.filePath, User: ts.UserName})
+	ts.Require().NoError(bus.SendMessage(fileID, "sda", "ingest", trigger))
+
+	deliveries, err := bus.Consume("ingest")
+	ts.Require().NoError(err)
+	app.HandleMessage(context.TODO(), <-deliveries)
+
+	ts.Equal([]string{fileID}, archive.Files())
+
+	// The archived message is published once the outbox is relayed
+	ts.Empty(bus.Messages("archived"))
+	_, err = ts.db.RelayOutbox(context.TODO(), 1000, bus.SendMessage)
+	ts.Require().NoError(err)
+	var archived []broker.MemoryMessage
+	for _, m := range bus.Messages("archived") {
+		if m.CorrelationID == fileID {
+			archived = append(archived, m)
+		}
+	}
+	ts.Require().Len(archived, 1)
+	var verification schema.IngestionVerification
+	ts.NoError(json.Unmarshal(archived[0].Body, &verification))
+	ts.Equal(fileID, verification.FileID)
+	for _, m := range bus.Messages("error_stream") {
+		ts.NotEqual(fileID, m.CorrelationID)
+	}
+
+	var events []string
+	rows, err := ts.db.DB.Query("SELECT event FROM sda.file_event_log WHERE file_id = $1 ORDER BY id;", fileID)
+	ts.Require().NoError(err)
+	defer rows.Close()
+	for rows.Next() {
+		var event string
+		ts.NoError(rows.Scan(&event))
+		events = append(events, event)
+	}
+	ts.NoError(rows.Err())
+	ts.Equal([]string{"registered", "uploaded", "submitted", "archived"}, events)
+}
@@ -0,0 +1,193 @@
+// Package mapper handles the messages of the mapper service, which registers
+// the mapping of accessionIDs (IDs for files) to datasetIDs.
+package mapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/helper"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Database is the part of the sda database used by mapper, it is satisfied by *database.SDAdb
+type Database interface {
+	GetMappingData(accessionID string) (*database.MappingData, error)
+	MapFilesToDataset(datasetID string, accessionIDs []string) error
+	UpdateDatasetEvent(datasetID, status, message string) error
+}
+
+// Mapper holds what the mapper service needs to handle messages
+type Mapper struct {
+	DB          Database
+	InboxWriter storage.Writer
+	MQ          broker.Bus
+	MQConf      broker.MQConf
+}
+
+// StartConsumer handles the messages of the queue of the service until the bus is closed
+func (app *Mapper) StartConsumer(ctx context.Context) error {
+	messages, err := app.MQ.Consume(app.MQConf.Queue)
+	if err != nil {
+		return fmt.Errorf("failed to get message from mq (error: %v)", err)
+	}
+
+	for delivered := range messages {
+		app.HandleMessage(ctx, delivered)
+	}
+
+	return nil
+}
+
+// HandleMessage handles a dataset mapping, release or deprecate message
+func (app *Mapper) HandleMessage(ctx context.Context, delivered broker.Delivery) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	log.Debugf("received a message: %s", delivered.Body)
+	schemaType, err := schemaFromDatasetOperation(delivered.Body)
+	if err != nil {
+		log.Errorf("%s", err.Error())
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed to ack message: %v", err)
+		}
+		if err := app.MQ.SendMessage(delivered.CorrelationID, app.MQConf.Exchange, "error", delivered.Body); err != nil {
+			log.Errorf("failed to send error message: %v", err)
+		}
+
+		return
+	}
+
+	err = schema.ValidateJSON(fmt.Sprintf("%s/%s.json", app.MQConf.SchemasPath, schemaType), delivered.Body)
+	if err != nil {
+		log.Errorf("validation of incoming message (%s) failed, reason: %v ", schemaType, err)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed acking canceled work, reason: %v", err)
+		}
+
+		return
+	}
+
+	var mappings schema.DatasetMapping
+	// we unmarshal the message in the validation step so this is safe to do
+	_ = json.Unmarshal(delivered.Body, &mappings)
+
+	switch mappings.Type {
+	case "mapping":
+		log.Debug("mapping type operation, mapping files to dataset")
+		if err := app.DB.MapFilesToDataset(mappings.DatasetID, mappings.AccessionIDs); err != nil {
+			log.Errorf("failed to map files to dataset, dataset-id: %s, reason: %v", mappings.DatasetID, err)
+
+			// Nack message so the server gets notified that something is wrong and requeue the message
+			if err := delivered.Nack(true); err != nil {
+				log.Errorf("failed to Nack message, reason: (%v)", err)
+			}
+
+			return
+		}
+
+		for _, aID := range mappings.AccessionIDs {
+			log.Debugf("Mapped file to dataset (correlation-id: %s, datasetid: %s, accessionid: %s)", delivered.CorrelationID, mappings.DatasetID, aID)
+			fileMappingData, err := app.DB.GetMappingData(aID)
+			if err != nil {
+				log.Errorf("failed to get file info for file with stable ID: %s, can not remove file from inbox", aID)
+
+				continue
+			}
+
+			if fileMappingData == nil || fileMappingData.SubmissionLocation == "" {
+				log.Errorf("failed to find submission location for file with stable ID: %s, can not remove file from inbox", aID)
+
+				continue
+			}
+
+			unanonymizedSubmissionFilePath := helper.UnanonymizeFilepath(fileMappingData.SubmissionFilePath, fileMappingData.User)
+			if err := app.InboxWriter.RemoveFile(ctx, fileMappingData.SubmissionLocation, unanonymizedSubmissionFilePath); err != nil {
+				log.Errorf("removal of file id: %s at location: %s, path: %s failed, reason: %v", fileMappingData.FileID, fileMappingData.SubmissionLocation, unanonymizedSubmissionFilePath, err)
+			}
+		}
+
+		if err := app.DB.UpdateDatasetEvent(mappings.DatasetID, "registered", string(delivered.Body)); err != nil {
+			log.Errorf("failed to set dataset status for dataset: %s", mappings.DatasetID)
+			if err = delivered.Nack(false); err != nil {
+				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+	case "release":
+		log.Debug("release type operation, marking dataset as released")
+		if err := app.DB.UpdateDatasetEvent(mappings.DatasetID, "released", string(delivered.Body)); err != nil {
+			log.Errorf("failed to set dataset status for dataset: %s", mappings.DatasetID)
+			if err = delivered.Nack(false); err != nil {
+				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+
+		log.Debug("Forward message to \"foam_integration\" queue")
+		if err := app.MQ.SendMessage(delivered.CorrelationID, app.MQConf.Exchange, "foam_integration", delivered.Body); err != nil {
+			log.Errorln("We need to fix this resend stuff ...")
+		}
+	case "deprecate":
+		log.Debug("deprecate type operation, marking dataset as deprecated")
+		if err := app.DB.UpdateDatasetEvent(mappings.DatasetID, "deprecated", string(delivered.Body)); err != nil {
+			log.Errorf("failed to set dataset status for dataset: %s", mappings.DatasetID)
+			if err = delivered.Nack(false); err != nil {
+				log.Errorf("failed to Nack message, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+	default:
+		log.Errorf("unknown mapping type, %s", mappings.Type)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed to ack message: %v", err)
+		}
+		if err := app.MQ.SendMessage(delivered.CorrelationID, app.MQConf.Exchange, "error", delivered.Body); err != nil {
+			log.Errorf("failed to send error message: %v", err)
+		}
+
+		return
+	}
+
+	if err := delivered.Ack(); err != nil {
+		log.Errorf("failed to Ack message, reason: (%v)", err)
+	}
+}
+
+// schemaFromDatasetOperation returns the operation done with dataset supplied in body of the message
+func schemaFromDatasetOperation(body []byte) (string, error) {
+	message := make(map[string]any)
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return "", err
+	}
+
+	datasetMessageType, ok := message["type"]
+	if !ok {
+		return "", errors.New("malformed message, dataset message type is missing")
+	}
+
+	datasetOpsType, ok := datasetMessageType.(string)
+	if !ok {
+		return "", errors.New("could not cast operation attribute to string")
+	}
+
+	switch datasetOpsType {
+	case "mapping":
+		return "dataset-mapping", nil
+	case "release":
+		return "dataset-release", nil
+	case "deprecate":
+		return "dataset-deprecate", nil
+	default:
+		return "", errors.New("could not recognize mapping operation")
+	}
+}
@@ -0,0 +1,189 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/neicnordic/crypt4gh/keys"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/inbox"
+	"github.com/neicnordic/sensitive-data-archive/internal/pipeline/finalize"
+	"github.com/neicnordic/sensitive-data-archive/internal/pipeline/ingest"
+	"github.com/neicnordic/sensitive-data-archive/internal/pipeline/mapper"
+	"github.com/neicnordic/sensitive-data-archive/internal/pipeline/verify"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schemasPath = "../../schemas/isolated"
+
+// TestPipeline drives a file from its upload to the inbox to the release of its dataset, with every service sharing
+// an in-memory bus, database and storage
+func TestPipeline(t *testing.T) {
+	ctx := context.TODO()
+
+	definitions, err := os.Open("../../../rabbitmq/definitions.json")
+	require.NoError(t, err)
+	defer definitions.Close()
+	bus := broker.NewMemoryBus(1)
+	require.NoError(t, bus.LoadDefinitions(definitions))
+	defer bus.Close()
+
+	db := database.NewMemoryDB()
+	inboxStorage := memory.NewStorage("memory://inbox")
+	archiveStorage := memory.NewStorage("memory://archive")
+	// The relays are never run, the outbox is relayed by the test once each service is done
+	outbox := broker.NewOutboxRelay(db, bus, time.Hour)
+	relay := func() {
+		_, err := db.RelayOutbox(ctx, 100, bus.SendMessage)
+		require.NoError(t, err)
+	}
+
+	archivePublicKey, archivePrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+	_, submitterPrivateKey, err := keys.GenerateKeyPair()
+	require.NoError(t, err)
+
+	var encrypted bytes.Buffer
+	c4ghWriter, err := streaming.NewCrypt4GHWriter(&encrypted, submitterPrivateKey, [][32]byte{archivePublicKey}, nil)
+	require.NoError(t, err)
+	_, err = io.Copy(c4ghWriter, io.LimitReader(rand.Reader, 1024*1024))
+	require.NoError(t, err)
+	require.NoError(t, c4ghWriter.Close())
+
+	user := "dummy"
+	filePath := "dir/file.c4gh"
+	datasetID := "EGAD00000000001"
+	accessionID := "EGAF00000000001"
+
+	// inbox-upload
+	fileID, err := db.RegisterFile(nil, inboxStorage.Location(), filePath, user)
+	require.NoError(t, err)
+	_, err = inboxStorage.WriteFile(ctx, "dummy/dir/file.c4gh", bytes.NewReader(encrypted.Bytes()))
+	require.NoError(t, err)
+	uploadedChecksum := sha256.Sum256(encrypted.Bytes())
+	upload, _ := json.Marshal(schema.InboxUpload{
+		User:               user,
+		FilePath:           filePath,
+		Operation:          "upload",
+		FileSize:           int64(encrypted.Len()),
+		EncryptedChecksums: []schema.Checksums{{Type: "sha256", Value: hex.EncodeToString(uploadedChecksum[:])}},
+	})
+	require.NoError(t, schema.ValidateJSON(schemasPath+"/inbox-upload.json", upload))
+	require.NoError(t, inbox.Uploaded(ctx, db, func(fileID string, message []byte) error {
+		return bus.SendMessage(fileID, "sda", "inbox", message)
+	}, inbox.Upload{
+		FileID:      fileID,
+		User:        user,
+		StoragePath: filePath,
+		Size:        int64(encrypted.Len()),
+		SHA256:      hex.EncodeToString(uploadedChecksum[:]),
+		Message:     upload,
+	}))
+	require.Len(t, bus.Messages("inbox"), 1)
+	assert.Equal(t, []string{"registered", "uploaded"}, db.FileEvents(fileID))
+
+	// ingest
+	trigger, _ := json.Marshal(schema.IngestionTrigger{Type: "ingest", User: user, FilePath: filePath})
+	require.NoError(t, bus.SendMessage(fileID, "sda", "ingest", trigger))
+	ingestApp := ingest.Ingest{
+		ArchiveWriter:  archiveStorage,
+		ArchiveReader:  archiveStorage,
+		ArchiveKeyList: []*[32]byte{&archivePrivateKey},
+		DB:             db,
+		InboxReader:    inboxStorage,
+		MQ:             bus,
+		MQConf:         broker.MQConf{Exchange: "sda", RoutingKey: "archived", SchemasPath: schemasPath},
+		Outbox:         outbox,
+	}
+	require.NoError(t, ingestApp.RegisterC4GHKey())
+	deliveries, err := bus.Consume("ingest")
+	require.NoError(t, err)
+	ingestApp.HandleMessage(ctx, <-deliveries)
+	relay()
+	assert.Equal(t, []string{fileID}, archiveStorage.Files())
+	assert.Equal(t, []string{"registered", "uploaded", "submitted", "archived"}, db.FileEvents(fileID))
+
+	// verify
+	verifyApp := verify.Verify{
+		ArchiveReader:  archiveStorage,
+		ArchiveKeyList: []*[32]byte{&archivePrivateKey},
+		DB:             db,
+		MQ:             bus,
+		MQConf:         broker.MQConf{Exchange: "sda", RoutingKey: "verified", SchemasPath: schemasPath},
+		Outbox:         outbox,
+	}
+	deliveries, err = bus.Consume("archived")
+	require.NoError(t, err)
+	verifyApp.HandleMessage(ctx, <-deliveries)
+	relay()
+	assert.Equal(t, []string{"registered", "uploaded", "submitted", "archived", "verified"}, db.FileEvents(fileID))
+	verified := bus.Messages("verified")
+	require.Len(t, verified, 1)
+	var accessionRequest schema.IngestionAccessionRequest
+	require.NoError(t, json.Unmarshal(verified[0].Body, &accessionRequest))
+
+	// finalize
+	accession, _ := json.Marshal(schema.IngestionAccession{
+		Type:               "accession",
+		User:               user,
+		FilePath:           filePath,
+		AccessionID:        accessionID,
+		DecryptedChecksums: accessionRequest.DecryptedChecksums,
+	})
+	require.NoError(t, bus.SendMessage(fileID, "sda", "accession", accession))
+	finalizeApp := finalize.Finalize{
+		DB:     db,
+		MQ:     bus,
+		MQConf: broker.MQConf{Exchange: "sda", RoutingKey: "completed", SchemasPath: schemasPath},
+		Outbox: outbox,
+	}
+	deliveries, err = bus.Consume("accession")
+	require.NoError(t, err)
+	finalizeApp.HandleMessage(ctx, <-deliveries)
+	relay()
+	assert.Equal(t, []string{"registered", "uploaded", "submitted", "archived", "verified", "ready"}, db.FileEvents(fileID))
+	assert.Len(t, bus.Messages("completed_stream"), 1)
+
+	// dataset-mapping, published straight to the queue of the mapper as the memory bus does not run the shovel from
+	// the mapping stream
+	mapperApp := mapper.Mapper{
+		DB:          db,
+		InboxWriter: inboxStorage,
+		MQ:          bus,
+		MQConf:      broker.MQConf{Exchange: "sda", SchemasPath: schemasPath},
+	}
+	mapping, _ := json.Marshal(schema.DatasetMapping{Type: "mapping", DatasetID: datasetID, AccessionIDs: []string{accessionID}})
+	require.NoError(t, bus.SendMessage(fileID, "", "mappings", mapping))
+	deliveries, err = bus.Consume("mappings")
+	require.NoError(t, err)
+	mapperApp.HandleMessage(ctx, <-deliveries)
+	assert.Empty(t, inboxStorage.Files())
+	assert.Equal(t, []string{"registered"}, db.DatasetEvents(datasetID))
+
+	// dataset-release
+	release, _ := json.Marshal(schema.DatasetRelease{Type: "release", DatasetID: datasetID})
+	require.NoError(t, bus.SendMessage(fileID, "", "mappings", release))
+	mapperApp.HandleMessage(ctx, <-deliveries)
+	assert.Equal(t, []string{"registered", "released"}, db.DatasetEvents(datasetID))
+	assert.Len(t, bus.Messages("foam_integration"), 1)
+
+	// The file events are only added by the ingestion, mapping the file to a dataset and releasing it adds none
+	assert.Equal(t, []string{"registered", "uploaded", "submitted", "archived", "verified", "ready"}, db.FileEvents(fileID))
+	assert.Empty(t, bus.Messages("error_stream"))
+	for _, queue := range []string{"ingest", "archived", "accession", "mappings"} {
+		assert.Empty(t, bus.Rejected(queue), queue)
+	}
+}
@@ -0,0 +1,438 @@
+// Package verify handles the messages of the verify service, which reads and
+// decrypts ingested files from the archive storage and sends accession
+// requests.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/neicnordic/crypt4gh/model/headers"
+	"github.com/neicnordic/crypt4gh/streaming"
+	"github.com/neicnordic/sensitive-data-archive/internal/broker"
+	"github.com/neicnordic/sensitive-data-archive/internal/database"
+	"github.com/neicnordic/sensitive-data-archive/internal/schema"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Database is the part of the sda database used by verify, it is satisfied by *database.SDAdb
+type Database interface {
+	GetArchiveLocation(fileID string) (string, error)
+	GetDecryptedChecksum(id string) (string, error)
+	GetFileInfo(id string) (database.FileInfo, error)
+	GetFileStatus(fileID string) (string, error)
+	GetHeader(fileID string) ([]byte, error)
+	SetVerifiedWithMessage(ctx context.Context, file database.FileInfo, fileID, event, user, details, message string, outboxMessage database.OutboxMessage) error
+	UpdateFileEventLog(fileUUID, event, user, details, message string) error
+	UpdateFileEventLogWithMessage(ctx context.Context, fileUUID, event, user, details, message string, outboxMessage database.OutboxMessage) error
+}
+
+// Verify holds what the verify service needs to handle messages
+type Verify struct {
+	ArchiveReader  storage.Reader
+	ArchiveKeyList []*[32]byte
+	DB             Database
+	MQ             broker.Bus
+	MQConf         broker.MQConf
+	Outbox         *broker.OutboxRelay
+}
+
+// StartConsumer handles the messages of the queue of the service until the bus is closed
+func (app *Verify) StartConsumer(ctx context.Context) error {
+	messages, err := app.MQ.Consume(app.MQConf.Queue)
+	if err != nil {
+		return fmt.Errorf("failed to get messages (error: %v) ", err)
+	}
+	for delivered := range messages {
+		app.HandleMessage(ctx, delivered)
+	}
+
+	return nil
+}
+
+// HandleMessage handles an ingestion-verification message, verifying the archived file
+func (app *Verify) HandleMessage(ctx context.Context, delivered broker.Delivery) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	log.Debugf("received a message (correlation-id: %s, message: %s)", delivered.CorrelationID, delivered.Body)
+	err := schema.ValidateJSON(fmt.Sprintf("%s/ingestion-verification.json", app.MQConf.SchemasPath), delivered.Body)
+	if err != nil {
+		log.Errorf("validation of incoming message (ingestion-verification) failed, correlation-id: %s, reason: (%s)", delivered.CorrelationID, err.Error())
+		// Send the message to an error queue so it can be analyzed.
+		infoErrorMessage := broker.InfoError{
+			Error:           "Message validation failed",
+			Reason:          err.Error(),
+			OriginalMessage: delivered,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+		if err := app.MQ.SendMessage(delivered.CorrelationID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: %v", err)
+		}
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed to Ack message, reason: %v", err)
+		}
+
+		// Restart on new message
+		return
+	}
+
+	var message schema.IngestionVerification
+	// we unmarshal the message in the validation step so this is safe to do
+	_ = json.Unmarshal(delivered.Body, &message)
+
+	log.Infof(
+		"Received work (message.correlation-id: %s, file-id: %s, filepath: %s, user: %s)",
+		delivered.CorrelationID, message.FileID, message.FilePath, message.User,
+	)
+
+	// If the file has been canceled by the uploader, don't spend time working on it.
+	status, err := app.DB.GetFileStatus(message.FileID)
+	if err != nil {
+		log.Errorf("failed to get file status, file-id: %s, reason: (%s)", message.FileID, err.Error())
+		// Send the message to an error queue so it can be analyzed.
+		infoErrorMessage := broker.InfoError{
+			Error:           "Getheader failed",
+			Reason:          err.Error(),
+			OriginalMessage: message,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+	if status == "disabled" {
+		log.Infof("file with file-id: %s is disabled, stopping verification", message.FileID)
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+
+	header, err := app.DB.GetHeader(message.FileID)
+	if err != nil {
+		log.Errorf("GetHeader failed for file with ID: %v, reason: %v", message.FileID, err.Error())
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed to nack following getheader error message")
+		}
+		// store full message info in case we want to fix the db entry and retry
+		infoErrorMessage := broker.InfoError{
+			Error:           "Getheader failed",
+			Reason:          err.Error(),
+			OriginalMessage: message,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+
+		// Send the message to an error queue so it can be analyzed.
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+
+	archiveLocation, err := app.DB.GetArchiveLocation(message.FileID)
+	if err != nil {
+		log.Errorf("failed to get archive location of file: %s, error: %v", message.FileID, err)
+
+		if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+			log.Errorf("failed to retry message, reason: %v", err)
+		}
+
+		return
+	}
+	if archiveLocation == "" {
+		log.Errorf("archive location for file: %s, not known in database", message.FileID)
+		jsonMsg, _ := json.Marshal(map[string]string{"error": "archive location for file not known in database"})
+		if err := app.DB.UpdateFileEventLog(message.FileID, "error", "verify", string(jsonMsg), string(delivered.Body)); err != nil {
+			log.Errorf("failed to set ingestion status for file from message, file-id: %v", message.FileID)
+		}
+
+		// Send the message to an error queue so it can be analyzed.
+		infoErrorMessage := broker.InfoError{
+			Error:           "GetArchiveLocation failed",
+			Reason:          "archive location for file not known in database",
+			OriginalMessage: message,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+
+	var file database.FileInfo
+	file.Size, err = app.ArchiveReader.GetFileSize(ctx, archiveLocation, message.ArchivePath)
+	if err != nil { //nolint:nestif
+		log.Errorf("Failed to get archived file size, file-id: %s, archive-path: %s, reason: (%s)", message.FileID, message.ArchivePath, err.Error())
+		if strings.Contains(err.Error(), "no such file or directory") || strings.Contains(err.Error(), "NoSuchKey:") || strings.Contains(err.Error(), "NotFound:") {
+			jsonMsg, _ := json.Marshal(map[string]string{"error": err.Error()})
+			if err := app.DB.UpdateFileEventLog(message.FileID, "error", "verify", string(jsonMsg), string(delivered.Body)); err != nil {
+				log.Errorf("failed to set ingestion status for file from message, file-id: %v", message.FileID)
+			}
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed to Ack message, reason: (%s)", err.Error())
+		}
+
+		// Send the message to an error queue so it can be analyzed.
+		fileError := broker.InfoError{
+			Error:           "Failed to get archived file size",
+			Reason:          err.Error(),
+			OriginalMessage: message,
+		}
+		body, _ := json.Marshal(fileError)
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+
+	archiveFileHash := sha256.New()
+	f, err := app.ArchiveReader.NewFileReader(ctx, archiveLocation, message.ArchivePath)
+	if err != nil {
+		log.Errorf("Failed to open archived file, file-id: %s, reason: %v ", message.FileID, err.Error())
+		// Send the message to an error queue so it can be analyzed.
+		infoErrorMessage := broker.InfoError{
+			Error:           "Failed to open archived file",
+			Reason:          err.Error(),
+			OriginalMessage: message,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("failed to publish message, reason: (%s)", err.Error())
+		}
+
+		// Restart on new message
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var key *[32]byte
+	for _, k := range app.ArchiveKeyList {
+		size, err := headers.EncryptedSegmentSize(header, *k)
+		if (err == nil) && (size != 0) {
+			key = k
+
+			break
+		}
+	}
+
+	if key == nil {
+		log.Errorf("no matching key found for file, file-id: %s, archive-path: %s", message.FileID, message.ArchivePath)
+
+		return
+	}
+
+	mr := io.MultiReader(bytes.NewReader(header), io.TeeReader(f, archiveFileHash))
+	c4ghr, err := streaming.NewCrypt4GHReader(mr, *key, nil)
+	if err != nil {
+		log.Errorf("failed to open c4gh decryptor stream, file-id: %s, archive-path: %s, reason: %s", message.FileID, message.ArchivePath, err.Error())
+
+		return
+	}
+	defer func() {
+		if err := c4ghr.Close(); err != nil {
+			log.Errorf("failed to close crypt4gh reader, file-id %s, reason: %v", message.FileID, err)
+		}
+	}()
+
+	md5hash := md5.New()
+	sha256hash := sha256.New()
+	stream := io.TeeReader(c4ghr, md5hash)
+
+	if file.DecryptedSize, err = io.Copy(sha256hash, stream); err != nil {
+		log.Errorf("failed to copy decrypted data, file-id: %s, reason: (%s)", message.FileID, err.Error())
+		if errors.Is(err, storageerrors.ErrorChecksumMismatch) {
+			if err := app.DB.UpdateFileEventLog(message.FileID, "error", "verify", `{"error":"archived checksum don't match"}`, string(delivered.Body)); err != nil {
+				log.Errorf("failed to set error status for file, file-id: %s, reason: (%v)", message.FileID, err)
+			}
+		}
+
+		// Send the message to an error queue so it can be analyzed.
+		infoErrorMessage := broker.InfoError{
+			Error:           "Failed to verify archived file",
+			Reason:          err.Error(),
+			OriginalMessage: message,
+		}
+
+		body, _ := json.Marshal(infoErrorMessage)
+		if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+			log.Errorf("Failed to publish error message, reason: (%s)", err.Error())
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed to ack message, reason: (%s)", err.Error())
+		}
+
+		return
+	}
+
+	// At this point we should do checksum comparison
+	file.ArchiveChecksum = fmt.Sprintf("%x", archiveFileHash.Sum(nil))
+	file.DecryptedChecksum = fmt.Sprintf("%x", sha256hash.Sum(nil))
+
+	switch {
+	case message.ReVerify:
+		decrypted, err := app.DB.GetDecryptedChecksum(message.FileID)
+		if err != nil {
+			log.Errorf("failed to get unencrypted checksum for file, file-id: %s, reason: %s", message.FileID, err.Error())
+			if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+				log.Errorf("failed to retry message, reason: %v", err)
+			}
+
+			return
+		}
+
+		if file.DecryptedChecksum != decrypted {
+			log.Errorf("encrypted checksum don't match for file, file-id: %s", message.FileID)
+			if err := app.DB.UpdateFileEventLog(message.FileID, "error", "verify", `{"error":"decrypted checksum don't match"}`, string(delivered.Body)); err != nil {
+				log.Errorf("set status ready failed, file-id: %s, reason: (%v)", message.FileID, err)
+				if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+					log.Errorf("failed to retry message, reason: %v", err)
+				}
+
+				return
+			}
+			if err := delivered.Ack(); err != nil {
+				log.Errorf("Failed to ack message, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+
+		if file.ArchiveChecksum != message.EncryptedChecksums[0].Value {
+			log.Errorf("encrypted checksum mismatch for file, file-id: %s, filepath: %s, expected: %s, got: %s", message.FileID, message.FilePath, message.EncryptedChecksums[0].Value, file.ArchiveChecksum)
+			if err := app.DB.UpdateFileEventLog(message.FileID, "error", "verify", `{"error":"encrypted checksum don't match"}`, string(delivered.Body)); err != nil {
+				log.Errorf("set status ready failed, file-id: %s, reason: (%v)", message.FileID, err)
+				if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+					log.Errorf("failed to retry message, reason: %v", err)
+				}
+
+				return
+			}
+		}
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("Failed to ack message, reason: (%s)", err.Error())
+		}
+
+		return
+	default:
+		c := schema.IngestionAccessionRequest{
+			User:     message.User,
+			FilePath: message.FilePath,
+			DecryptedChecksums: []schema.Checksums{
+				{Type: "sha256", Value: fmt.Sprintf("%x", sha256hash.Sum(nil))},
+				{Type: "md5", Value: fmt.Sprintf("%x", md5hash.Sum(nil))},
+			},
+		}
+
+		verifiedMessage, _ := json.Marshal(&c)
+		err = schema.ValidateJSON(fmt.Sprintf("%s/ingestion-accession-request.json", app.MQConf.SchemasPath), verifiedMessage)
+		if err != nil {
+			log.Errorf("Validation of outgoing (ingestion-accession-request) failed, file-id: %s, reason: (%s)", message.FileID, err.Error())
+			// Logging is in ValidateJSON so just restart on new message
+			return
+		}
+		status, err := app.DB.GetFileStatus(message.FileID)
+		if err != nil {
+			log.Errorf("failed to get file status, file-id: %s, reason: (%s)", message.FileID, err.Error())
+			// Send the message to an error queue so it can be analyzed.
+			infoErrorMessage := broker.InfoError{
+				Error:           "Getheader failed",
+				Reason:          err.Error(),
+				OriginalMessage: message,
+			}
+
+			body, _ := json.Marshal(infoErrorMessage)
+			if err := app.MQ.SendMessage(message.FileID, app.MQConf.Exchange, "error", body); err != nil {
+				log.Errorf("failed to publish message, reason: (%s)", err.Error())
+			}
+
+			if err := delivered.Ack(); err != nil {
+				log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+
+		if status == "disabled" {
+			log.Infof("file with file-id: %s is disabled, stopping verification", message.FileID)
+			if err := delivered.Ack(); err != nil {
+				log.Errorf("Failed acking canceled work, reason: (%s)", err.Error())
+			}
+
+			return
+		}
+
+		fileInfo, err := app.DB.GetFileInfo(message.FileID)
+		if err != nil {
+			log.Errorf("failed to get info for file, file-id: %s", message.FileID)
+			if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+				log.Errorf("failed to retry message, reason: %v", err)
+			}
+
+			return
+		}
+
+		// The verified message is added to the outbox along with the verified event, and published by the outbox relay
+		outboxMessage := database.OutboxMessage{
+			CorrelationID: message.FileID,
+			Exchange:      app.MQConf.Exchange,
+			RoutingKey:    app.MQConf.RoutingKey,
+			Body:          verifiedMessage,
+		}
+		if fileInfo.DecryptedChecksum != fmt.Sprintf("%x", sha256hash.Sum(nil)) {
+			// The checksums are recorded in the same transaction as the event
+			err = app.DB.SetVerifiedWithMessage(ctx, file, message.FileID, "verified", "ingest", "{}", string(verifiedMessage), outboxMessage)
+		} else {
+			log.Infof("file is already verified, file-id: %s", message.FileID)
+			err = app.DB.UpdateFileEventLogWithMessage(ctx, message.FileID, "verified", "ingest", "{}", string(verifiedMessage), outboxMessage)
+		}
+		if err != nil {
+			log.Errorf("failed to set verified status for file, file-id: %s, reason: (%s)", message.FileID, err.Error())
+			if err := broker.Retry(app.MQ, app.MQConf, delivered, err); err != nil {
+				log.Errorf("failed to retry message, reason: %v", err)
+			}
+
+			return
+		}
+		app.Outbox.Notify()
+
+		if err := delivered.Ack(); err != nil {
+			log.Errorf("failed to Ack message, reason: (%s)", err.Error())
+		}
+	}
+	log.Infof("Successfully verified the file, file-id: %s, filepath: %s", message.FileID, message.FilePath)
+}
@@ -115,9 +115,11 @@ type InboxRename struct {
 }
 
 type InboxUpload struct {
-	User      string `json:"user"`
-	FilePath  string `json:"filepath"`
-	Operation string `json:"operation"`
+	User               string      `json:"user"`
+	FilePath           string      `json:"filepath"`
+	Operation          string      `json:"operation"`
+	FileSize           int64       `json:"filesize,omitempty"`
+	EncryptedChecksums []Checksums `json:"encrypted_checksums,omitempty"`
 }
 
 type IngestionAccession struct {
@@ -146,6 +146,14 @@ func TestValidateJSONInboxUpload(t *testing.T) {
 	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/inbox-upload.json", schemaPath), msg))
 	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/isolated/inbox-upload.json", schemaPath), msg))
 
+	okMsg.FileSize = 1024
+	okMsg.EncryptedChecksums = []Checksums{
+		{Type: "md5", Value: "0a44282bd39178db9680f24813c41aec"},
+		{Type: "sha256", Value: "82e4e60e7beb3db2e06a00a079788f7d71f75b61a4b75f28c4c942703dabb6d6"},
+	}
+	msg, _ = json.Marshal(okMsg)
+	assert.Nil(t, ValidateJSON(fmt.Sprintf("%s/federated/inbox-upload.json", schemaPath), msg))
+
 	badMsg := InboxUpload{
 		User:      "JohnDoe",
 		FilePath:  "/",
@@ -0,0 +1,414 @@
+// Package blobclient implements the subset of the Azure Blob Storage REST API used by the azure storage reader and
+// writer, requests are authorized with the shared key of the storage account
+package blobclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiVersion is the version of the Blob Storage REST API sent with each request, supported by Azure and Azurite
+const apiVersion = "2021-08-06"
+
+// Client is a minimal Azure Blob Storage client for one storage account
+type Client struct {
+	endpoint    string
+	accountName string
+	accountKey  []byte
+	httpClient  *http.Client
+}
+
+// New creates a client for the storage account at the endpoint, the endpoint is the blob service url of the account,
+// e.g "https://${ACCOUNT}.blob.core.windows.net" or "http://127.0.0.1:10000/devstoreaccount1" for Azurite
+func New(endpoint, accountName, accountKey string, httpClient *http.Client) (*Client, error) {
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint: %s, due to: %v", endpoint, err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return nil, errors.New("account_key is not valid base64")
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		accountName: accountName,
+		accountKey:  key,
+		httpClient:  httpClient,
+	}, nil
+}
+
+// NewHTTPClient creates a http client trusting the system CAs, and the ca certificate if provided
+func NewHTTPClient(caCert string) (*http.Client, error) {
+	cfg := new(tls.Config)
+
+	// Read system CAs
+	systemCAs, err := x509.SystemCertPool()
+	if err != nil {
+		log.Errorf("failed to read system CAs: %v, using an empty pool as base", err)
+		systemCAs = x509.NewCertPool()
+	}
+	cfg.RootCAs = systemCAs
+
+	if caCert != "" {
+		cert, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append %q to RootCAs, due to: %v", caCert, err)
+		}
+		if ok := cfg.RootCAs.AppendCertsFromPEM(cert); !ok {
+			log.Debug("no certs appended, using system certs only")
+		}
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   cfg,
+		ForceAttemptHTTP2: true,
+	}}, nil
+}
+
+// Location returns the location of a container in an endpoint, formatted as "az://${HOST}/${CONTAINER}"
+func Location(endpoint, container string) string {
+	return locationscheme.AzurePrefix + strings.TrimSuffix(locationscheme.TrimHTTPScheme(endpoint), "/") + "/" + container
+}
+
+// ParseLocation attempts to parse a location to the endpoint host and a container
+// expected format of location is "az://${HOST}/${CONTAINER}", where the host may contain a path
+func ParseLocation(location string) (string, string, error) {
+	if !strings.HasPrefix(location, locationscheme.AzurePrefix) {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+
+	hostAndContainer := strings.TrimPrefix(location, locationscheme.AzurePrefix)
+	i := strings.LastIndex(hostAndContainer, "/")
+	if i <= 0 || i == len(hostAndContainer)-1 {
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+
+	return hostAndContainer[:i], hostAndContainer[i+1:], nil
+}
+
+// MatchesEndpoint reports if the host of a location, as returned by ParseLocation, refers to the endpoint
+func MatchesEndpoint(endpoint, host string) bool {
+	return strings.TrimSuffix(locationscheme.TrimHTTPScheme(endpoint), "/") == host
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type listContainersResponse struct {
+	Containers []struct {
+		Name string `xml:"Name"`
+	} `xml:"Containers>Container"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type listBlobsResponse struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			ContentLength int64 `xml:"Content-Length"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// ListContainers returns the names of all containers in the account with the prefix
+func (c *Client) ListContainers(ctx context.Context, prefix string) ([]string, error) {
+	var containers []string
+	marker := ""
+	for {
+		query := url.Values{"comp": {"list"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		rsp := &listContainersResponse{}
+		if err := c.doXML(ctx, http.MethodGet, "", "", query, rsp); err != nil {
+			return nil, fmt.Errorf("failed to list containers, due to: %v", err)
+		}
+		for _, container := range rsp.Containers {
+			containers = append(containers, container.Name)
+		}
+
+		if rsp.NextMarker == "" {
+			return containers, nil
+		}
+		marker = rsp.NextMarker
+	}
+}
+
+// CreateContainer creates the container, it is not an error if the container already exists
+func (c *Client) CreateContainer(ctx context.Context, container string) error {
+	rsp, err := c.do(ctx, http.MethodPut, container, "", url.Values{"restype": {"container"}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = rsp.Body.Close()
+
+	return nil
+}
+
+// ContainerSizeAndCount returns the accumulated size of, and the amount of, blobs in the container
+func (c *Client) ContainerSizeAndCount(ctx context.Context, container string) (uint64, uint64, error) {
+	var totalSize, totalObjects uint64
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		rsp := &listBlobsResponse{}
+		if err := c.doXML(ctx, http.MethodGet, container, "", query, rsp); err != nil {
+			return 0, 0, fmt.Errorf("failed to list blobs in container: %s, due to: %v", container, err)
+		}
+		for _, blob := range rsp.Blobs {
+			totalObjects++
+			if blob.Properties.ContentLength > 0 {
+				totalSize += uint64(blob.Properties.ContentLength) // #nosec G115 -- ContentLength has been checked to be bigger than 0
+			}
+		}
+
+		if rsp.NextMarker == "" {
+			return totalSize, totalObjects, nil
+		}
+		marker = rsp.NextMarker
+	}
+}
+
+// GetBlob returns a reader of the blob content from the offset, if length is bigger than 0 at most length bytes are read
+func (c *Client) GetBlob(ctx context.Context, container, blob string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	switch {
+	case length > 0:
+		header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("x-ms-range", fmt.Sprintf("bytes=%d-", offset))
+	default:
+	}
+
+	rsp, err := c.do(ctx, http.MethodGet, container, blob, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return rsp.Body, nil
+}
+
+// GetBlobSize returns the size of the blob
+func (c *Client) GetBlobSize(ctx context.Context, container, blob string) (int64, error) {
+	rsp, err := c.do(ctx, http.MethodHead, container, blob, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	_ = rsp.Body.Close()
+
+	size, err := strconv.ParseInt(rsp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse content length of blob: %s, container: %s, due to: %v", blob, container, err)
+	}
+
+	return size, nil
+}
+
+// BlockID returns the block id of the n:th block of a blob, all block ids of a blob need to be of equal length
+func BlockID(n int) string {
+	return base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%010d", n))
+}
+
+// PutBlock uploads a block to be committed as part of the blob by PutBlockList
+func (c *Client) PutBlock(ctx context.Context, container, blob, blockID string, data []byte) error {
+	rsp, err := c.do(ctx, http.MethodPut, container, blob, url.Values{"comp": {"block"}, "blockid": {blockID}}, nil, data)
+	if err != nil {
+		return err
+	}
+	_ = rsp.Body.Close()
+
+	return nil
+}
+
+// PutBlockList commits the uploaded blocks, in order, as the content of the blob
+func (c *Client) PutBlockList(ctx context.Context, container, blob string, blockIDs []string) error {
+	body := bytes.NewBufferString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range blockIDs {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	rsp, err := c.do(ctx, http.MethodPut, container, blob, url.Values{"comp": {"blocklist"}}, header, body.Bytes())
+	if err != nil {
+		return err
+	}
+	_ = rsp.Body.Close()
+
+	return nil
+}
+
+// DeleteBlob deletes the blob
+func (c *Client) DeleteBlob(ctx context.Context, container, blob string) error {
+	rsp, err := c.do(ctx, http.MethodDelete, container, blob, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = rsp.Body.Close()
+
+	return nil
+}
+
+// Ping verifies the account is reachable and the credentials are valid
+func (c *Client) Ping(ctx context.Context) error {
+	rsp := &listContainersResponse{}
+
+	return c.doXML(ctx, http.MethodGet, "", "", url.Values{"comp": {"list"}, "maxresults": {"1"}}, rsp)
+}
+
+func (c *Client) doXML(ctx context.Context, method, container, blob string, query url.Values, v any) error {
+	rsp, err := c.do(ctx, method, container, blob, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if err := xml.NewDecoder(rsp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response, due to: %v", err)
+	}
+
+	return nil
+}
+
+// do sends a signed request, a response with a status code outside of 2xx is returned as an error
+func (c *Client) do(ctx context.Context, method, container, blob string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	reqURL := c.endpoint + "/" + url.PathEscape(container)
+	if blob != "" {
+		segments := strings.Split(blob, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		reqURL += "/" + strings.Join(segments, "/")
+	}
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request, due to: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("Authorization", "SharedKey "+c.accountName+":"+c.signature(req))
+
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to: %s, due to: %v", c.endpoint, err)
+	}
+
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return rsp, nil
+	}
+	defer rsp.Body.Close()
+
+	errRsp := errorResponse{Code: rsp.Header.Get("x-ms-error-code")}
+	if b, err := io.ReadAll(rsp.Body); err == nil && len(b) > 0 {
+		_ = xml.Unmarshal(b, &errRsp)
+	}
+
+	switch {
+	case rsp.StatusCode == http.StatusNotFound && blob != "" && method != http.MethodPut:
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	case rsp.StatusCode == http.StatusConflict && errRsp.Code == "ContainerAlreadyExists":
+		return &http.Response{StatusCode: rsp.StatusCode, Body: http.NoBody}, nil
+	default:
+	}
+
+	return nil, fmt.Errorf("%s %s responded with status: %d, code: %s, message: %s", method, req.URL.Path, rsp.StatusCode, errRsp.Code, errRsp.Message)
+}
+
+// signature computes the shared key signature of the request
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (c *Client) signature(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for k := range req.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-ms-") {
+			msHeaders = append(msHeaders, strings.ToLower(k))
+		}
+	}
+	slices.Sort(msHeaders)
+	canonicalizedHeaders := ""
+	for _, k := range msHeaders {
+		canonicalizedHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+	}
+
+	canonicalizedResource := "/" + c.accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var queryKeys []string
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	slices.Sort(queryKeys)
+	for _, k := range queryKeys {
+		values := query[k]
+		slices.Sort(values)
+		canonicalizedResource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalizedHeaders + canonicalizedResource
+
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(stringToSign))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
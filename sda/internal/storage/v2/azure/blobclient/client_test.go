@@ -0,0 +1,247 @@
+package blobclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/stretchr/testify/suite"
+)
+
+// accountKey is the base64 encoded key of the mocked storage account
+const accountKey = "bW9jay1zdG9yYWdlLWFjY291bnQta2V5"
+
+type ClientTestSuite struct {
+	suite.Suite
+
+	server *httptest.Server
+	client *Client
+
+	lock   sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
+
+// SetupTest starts a mock of the blob service of the devstoreaccount1 account of Azurite, keeping blobs in memory
+func (ts *ClientTestSuite) SetupTest() {
+	ts.blobs = map[string][]byte{}
+	ts.blocks = map[string][]byte{}
+
+	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey devstoreaccount1:") || req.Header.Get("x-ms-version") != apiVersion {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		path := strings.TrimPrefix(req.URL.Path, "/devstoreaccount1/")
+		query := req.URL.Query()
+		switch {
+		case path == "" && query.Get("comp") == "list":
+			_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Containers>`)
+			if query.Get("marker") == "" {
+				_, _ = fmt.Fprint(w, `<Container><Name>archive1</Name></Container></Containers><NextMarker>page2</NextMarker></EnumerationResults>`)
+
+				return
+			}
+			_, _ = fmt.Fprint(w, `<Container><Name>archive2</Name></Container></Containers><NextMarker/></EnumerationResults>`)
+		case query.Get("restype") == "container" && query.Get("comp") == "list":
+			_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+			var names []string
+			for name := range ts.blobs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if strings.HasPrefix(name, path+"/") {
+					_, _ = fmt.Fprintf(w, `<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties></Blob>`, name, len(ts.blobs[name]))
+				}
+			}
+			_, _ = fmt.Fprint(w, `</Blobs><NextMarker/></EnumerationResults>`)
+		case query.Get("restype") == "container" && req.Method == http.MethodPut:
+			if path == "existing" {
+				w.Header().Set("x-ms-error-code", "ContainerAlreadyExists")
+				w.WriteHeader(http.StatusConflict)
+
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case query.Get("comp") == "block":
+			body, _ := io.ReadAll(req.Body)
+			ts.blocks[path+"/"+query.Get("blockid")] = body
+			w.WriteHeader(http.StatusCreated)
+		case query.Get("comp") == "blocklist":
+			blockList := struct {
+				Latest []string `xml:"Latest"`
+			}{}
+			if err := xml.NewDecoder(req.Body).Decode(&blockList); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+			var content []byte
+			for _, id := range blockList.Latest {
+				content = append(content, ts.blocks[path+"/"+id]...)
+			}
+			ts.blobs[path] = content
+			w.WriteHeader(http.StatusCreated)
+		default:
+			content, ok := ts.blobs[path]
+			if !ok {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.</Message></Error>`)
+
+				return
+			}
+			switch req.Method {
+			case http.MethodHead:
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			case http.MethodDelete:
+				delete(ts.blobs, path)
+				w.WriteHeader(http.StatusAccepted)
+			default:
+				var start, end int
+				end = len(content) - 1
+				if r := req.Header.Get("x-ms-range"); r != "" {
+					_, _ = fmt.Sscanf(r, "bytes=%d-%d", &start, &end)
+				}
+				_, _ = w.Write(content[start : end+1])
+			}
+		}
+	}))
+
+	var err error
+	ts.client, err = New(ts.server.URL+"/devstoreaccount1", "devstoreaccount1", accountKey, nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *ClientTestSuite) TearDownTest() {
+	ts.server.Close()
+}
+
+func (ts *ClientTestSuite) TestNew_InvalidAccountKey() {
+	_, err := New("http://127.0.0.1:10000/devstoreaccount1", "devstoreaccount1", "not base64!", nil)
+	ts.EqualError(err, "account_key is not valid base64")
+}
+
+func (ts *ClientTestSuite) TestSignature() {
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:10000/devstoreaccount1/container1/dir/file.c4gh?comp=block&blockid=MDAwMDAwMDAwMA%3D%3D", nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	req.Header.Set("x-ms-date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-range", "bytes=0-9")
+
+	stringToSign := "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Mon, 02 Jan 2006 15:04:05 GMT\nx-ms-range:bytes=0-9\nx-ms-version:" + apiVersion + "\n" +
+		"/devstoreaccount1/devstoreaccount1/container1/dir/file.c4gh\nblockid:MDAwMDAwMDAwMA==\ncomp:block"
+	key, _ := base64.StdEncoding.DecodeString(accountKey)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+
+	ts.Equal(base64.StdEncoding.EncodeToString(mac.Sum(nil)), ts.client.signature(req))
+}
+
+func (ts *ClientTestSuite) TestListContainers() {
+	containers, err := ts.client.ListContainers(context.TODO(), "archive")
+	ts.NoError(err)
+	ts.Equal([]string{"archive1", "archive2"}, containers)
+}
+
+func (ts *ClientTestSuite) TestCreateContainer_AlreadyExists() {
+	ts.NoError(ts.client.CreateContainer(context.TODO(), "existing"))
+}
+
+func (ts *ClientTestSuite) TestPutBlocksAndGetBlob() {
+	ts.NoError(ts.client.PutBlock(context.TODO(), "archive1", "dir/file.c4gh", BlockID(0), []byte("hello ")))
+	ts.NoError(ts.client.PutBlock(context.TODO(), "archive1", "dir/file.c4gh", BlockID(1), []byte("world")))
+	ts.NoError(ts.client.PutBlockList(context.TODO(), "archive1", "dir/file.c4gh", []string{BlockID(0), BlockID(1)}))
+
+	size, err := ts.client.GetBlobSize(context.TODO(), "archive1", "dir/file.c4gh")
+	ts.NoError(err)
+	ts.Equal(int64(11), size)
+
+	r, err := ts.client.GetBlob(context.TODO(), "archive1", "dir/file.c4gh", 6, 0)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	content, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal("world", string(content))
+	_ = r.Close()
+
+	r, err = ts.client.GetBlob(context.TODO(), "archive1", "dir/file.c4gh", 0, 5)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+	content, err = io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal("hello", string(content))
+	_ = r.Close()
+
+	containerSize, count, err := ts.client.ContainerSizeAndCount(context.TODO(), "archive1")
+	ts.NoError(err)
+	ts.Equal(uint64(11), containerSize)
+	ts.Equal(uint64(1), count)
+
+	ts.NoError(ts.client.DeleteBlob(context.TODO(), "archive1", "dir/file.c4gh"))
+	_, err = ts.client.GetBlobSize(context.TODO(), "archive1", "dir/file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ClientTestSuite) TestGetBlob_NotFound() {
+	_, err := ts.client.GetBlob(context.TODO(), "archive1", "missing.c4gh", 0, 0)
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ClientTestSuite) TestPing_InvalidCredentials() {
+	client, err := New(ts.server.URL+"/devstoreaccount1", "otheraccount", accountKey, nil)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.ErrorContains(client.Ping(context.TODO()), "responded with status: 403")
+}
+
+func (ts *ClientTestSuite) TestParseLocation() {
+	for _, test := range []struct {
+		location, expectedHost, expectedContainer string
+		expectedErr                               error
+	}{
+		{location: "az://127.0.0.1:10000/devstoreaccount1/archive1", expectedHost: "127.0.0.1:10000/devstoreaccount1", expectedContainer: "archive1"},
+		{location: "az://account.blob.core.windows.net/archive1", expectedHost: "account.blob.core.windows.net", expectedContainer: "archive1"},
+		{location: "az://account.blob.core.windows.net/", expectedErr: storageerrors.ErrorInvalidLocation},
+		{location: "az://archive1", expectedErr: storageerrors.ErrorInvalidLocation},
+		{location: "https://account.blob.core.windows.net/archive1", expectedErr: storageerrors.ErrorInvalidLocation},
+	} {
+		host, container, err := ParseLocation(test.location)
+		ts.ErrorIs(err, test.expectedErr, test.location)
+		ts.Equal(test.expectedHost, host, test.location)
+		ts.Equal(test.expectedContainer, container, test.location)
+	}
+
+	ts.Equal("az://127.0.0.1:10000/devstoreaccount1/archive1", Location("http://127.0.0.1:10000/devstoreaccount1/", "archive1"))
+	ts.True(MatchesEndpoint("http://127.0.0.1:10000/devstoreaccount1", "127.0.0.1:10000/devstoreaccount1"))
+}
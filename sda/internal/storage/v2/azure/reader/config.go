@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/spf13/viper"
+)
+
+type endpointConfig struct {
+	AccountKey      string `mapstructure:"account_key"` // #nosec G117 -- needs to be exported for unmarshalling
+	AccountName     string `mapstructure:"account_name"`
+	CACert          string `mapstructure:"ca_cert"`
+	ContainerPrefix string `mapstructure:"container_prefix"`
+	Endpoint        string `mapstructure:"endpoint"`
+
+	client *blobclient.Client // cached client for this endpoint, created by getClient
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".azure",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	for _, e := range endpointConf {
+		switch {
+		case e.Endpoint == "":
+			return nil, errors.New("missing required parameter: endpoint")
+		case e.AccountName == "":
+			return nil, errors.New("missing required parameter: account_name")
+		case e.AccountKey == "":
+			return nil, errors.New("missing required parameter: account_key")
+		case !strings.HasPrefix(e.Endpoint, "https:") && !strings.HasPrefix(e.Endpoint, "http:"):
+			return nil, errors.New("unsupported or no scheme in endpoint")
+		default:
+		}
+	}
+
+	return endpointConf, nil
+}
+
+func (endpointConf *endpointConfig) getClient(_ context.Context) (*blobclient.Client, error) {
+	if endpointConf.client != nil {
+		return endpointConf.client, nil
+	}
+
+	httpClient, err := blobclient.NewHTTPClient(endpointConf.CACert)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointConf.client, err = blobclient.New(endpointConf.Endpoint, endpointConf.AccountName, endpointConf.AccountKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpointConf.client, nil
+}
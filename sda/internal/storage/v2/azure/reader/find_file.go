@@ -0,0 +1,40 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (reader *Reader) FindFile(ctx context.Context, filePath string) (string, error) {
+	for _, endpointConf := range reader.endpoints {
+		client, err := endpointConf.getClient(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		containers, err := client.ListContainers(ctx, endpointConf.ContainerPrefix)
+		if err != nil {
+			return "", err
+		}
+		slices.SortFunc(containers, strings.Compare)
+
+		for _, container := range containers {
+			if _, err := client.GetBlobSize(ctx, container, filePath); err != nil {
+				if errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
+					continue
+				}
+
+				return "", err
+			}
+
+			return blobclient.Location(endpointConf.Endpoint, container), nil
+		}
+	}
+
+	return "", storageerrors.ErrorFileNotFoundInLocation
+}
@@ -0,0 +1,15 @@
+package reader
+
+import (
+	"context"
+)
+
+// GetFileSize returns the size of a specific blob
+func (reader *Reader) GetFileSize(ctx context.Context, location, filePath string) (int64, error) {
+	client, container, err := reader.getClientForLocation(ctx, location)
+	if err != nil {
+		return 0, err
+	}
+
+	return client.GetBlobSize(ctx, container, filePath)
+}
@@ -0,0 +1,93 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+)
+
+// azureSeekableReader reads a blob from the current offset with a range request, which is reopened after a seek
+type azureSeekableReader struct {
+	ctx           context.Context
+	client        *blobclient.Client
+	container     string
+	filePath      string
+	objectSize    int64
+	currentOffset int64
+	objectReader  io.ReadCloser
+}
+
+func (reader *Reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	client, container, err := reader.getClientForLocation(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	objectSize, err := client.GetBlobSize(ctx, container, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureSeekableReader{
+		ctx:        ctx,
+		client:     client,
+		container:  container,
+		filePath:   filePath,
+		objectSize: objectSize,
+	}, nil
+}
+
+func (r *azureSeekableReader) Read(p []byte) (int, error) {
+	if r.currentOffset >= r.objectSize {
+		return 0, io.EOF
+	}
+
+	if r.objectReader == nil {
+		objectReader, err := r.client.GetBlob(r.ctx, r.container, r.filePath, r.currentOffset, 0)
+		if err != nil {
+			return 0, err
+		}
+		r.objectReader = objectReader
+	}
+
+	n, err := r.objectReader.Read(p)
+	r.currentOffset += int64(n)
+
+	return n, err
+}
+
+func (r *azureSeekableReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.currentOffset + offset
+	case io.SeekEnd:
+		newOffset = r.objectSize + offset
+	default:
+		return r.currentOffset, errors.New("invalid whence")
+	}
+	if newOffset < 0 {
+		return r.currentOffset, fmt.Errorf("invalid resulting offset: %d", newOffset)
+	}
+
+	if newOffset != r.currentOffset && r.objectReader != nil {
+		_ = r.objectReader.Close()
+		r.objectReader = nil
+	}
+	r.currentOffset = newOffset
+
+	return r.currentOffset, nil
+}
+
+func (r *azureSeekableReader) Close() error {
+	if r.objectReader == nil {
+		return nil
+	}
+
+	return r.objectReader.Close()
+}
@@ -0,0 +1,15 @@
+package reader
+
+import (
+	"context"
+	"io"
+)
+
+func (reader *Reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	client, container, err := reader.getClientForLocation(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetBlob(ctx, container, filePath, 0, 0)
+}
@@ -0,0 +1,70 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+type Reader struct {
+	endpoints []*endpointConfig
+}
+
+func NewReader(ctx context.Context, backendName string) (*Reader, error) {
+	endPoints, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &Reader{
+		endpoints: endPoints,
+	}
+	// Verify endpoint connections
+	if err := backend.Ping(ctx); err != nil {
+		return nil, err
+	}
+	if len(backend.endpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return backend, nil
+}
+
+func (reader *Reader) getClientForLocation(ctx context.Context, location string) (*blobclient.Client, string, error) {
+	host, container, err := blobclient.ParseLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, e := range reader.endpoints {
+		if !blobclient.MatchesEndpoint(e.Endpoint, host) {
+			continue
+		}
+		client, err := e.getClient(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create azure client to endpoint: %s, due to %v", e.Endpoint, err)
+		}
+
+		return client, container, nil
+	}
+
+	return nil, "", storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// Ping verifies all configured azure endpoints are reachable by listing containers.
+func (reader *Reader) Ping(ctx context.Context) error {
+	for _, e := range reader.endpoints {
+		client, err := e.getClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to ping azure endpoint: %s, due to: %v", e.Endpoint, err)
+		}
+
+		if err = client.Ping(ctx); err != nil {
+			return fmt.Errorf("failed to ping azure endpoint: %s, due to: %v", e.Endpoint, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package reader
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReaderTestSuite struct {
+	suite.Suite
+	reader *Reader
+
+	configDir string
+
+	azureMock  *httptest.Server
+	containers map[string]map[string]string // "container name" -> "blob name" -> "content"
+}
+
+func TestReaderTestSuite(t *testing.T) {
+	suite.Run(t, new(ReaderTestSuite))
+}
+
+func (ts *ReaderTestSuite) SetupSuite() {
+	ts.configDir = ts.T().TempDir()
+
+	ts.containers = map[string]map[string]string{
+		"container-1": {"file1.txt": "file 1 content in container 1"},
+		"container-2": {"dir/file2.txt": "file 2 content in container 2, which is read with a seeker"},
+		"other-1":     {"file3.txt": "file 3 content in other container"},
+	}
+
+	ts.azureMock = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		container, blob, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/account/"), "/")
+
+		if container == "" && req.URL.Query().Get("comp") == "list" {
+			_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Containers>`)
+			for _, name := range []string{"container-2", "container-1", "other-1"} {
+				if strings.HasPrefix(name, req.URL.Query().Get("prefix")) {
+					_, _ = fmt.Fprintf(w, `<Container><Name>%s</Name></Container>`, name)
+				}
+			}
+			_, _ = fmt.Fprint(w, `</Containers><NextMarker/></EnumerationResults>`)
+
+			return
+		}
+
+		content, ok := ts.containers[container][blob]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		switch req.Method {
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		case http.MethodGet:
+			start, end := 0, len(content)-1
+			if r := req.Header.Get("x-ms-range"); r != "" {
+				_, _ = fmt.Sscanf(r, "bytes=%d-%d", &start, &end)
+			}
+			_, _ = fmt.Fprint(w, content[start:end+1])
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+
+	if err := os.WriteFile(filepath.Join(ts.configDir, "config.yaml"), []byte(fmt.Sprintf(`
+storage:
+  test:
+    azure:
+    - endpoint: %s/account
+      account_name: account
+      account_key: %s
+      container_prefix: container-
+`, ts.azureMock.URL, base64.StdEncoding.EncodeToString([]byte("mock-account-key")))), 0600); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(filepath.Join(ts.configDir, "config.yaml"))
+
+	if err := viper.ReadInConfig(); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	var err error
+	ts.reader, err = NewReader(context.TODO(), "test")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *ReaderTestSuite) TearDownSuite() {
+	ts.azureMock.Close()
+}
+
+func (ts *ReaderTestSuite) location(container string) string {
+	return blobclient.Location(ts.azureMock.URL+"/account", container)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader() {
+	r, err := ts.reader.NewFileReader(context.TODO(), ts.location("container-1"), "file1.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	content, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal("file 1 content in container 1", string(content))
+	ts.NoError(r.Close())
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_FileNotFound() {
+	_, err := ts.reader.NewFileReader(context.TODO(), ts.location("container-1"), "missing.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReader_LocationNotConfigured() {
+	_, err := ts.reader.NewFileReader(context.TODO(), "az://not.configured.example.com/container-1", "file1.txt")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+}
+
+func (ts *ReaderTestSuite) TestNewFileReadSeeker() {
+	r, err := ts.reader.NewFileReadSeeker(context.TODO(), ts.location("container-2"), "dir/file2.txt")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	start := make([]byte, 6)
+	_, err = io.ReadFull(r, start)
+	ts.NoError(err)
+	ts.Equal("file 2", string(start))
+
+	offset, err := r.Seek(-5, io.SeekEnd)
+	ts.NoError(err)
+	ts.Equal(int64(len("file 2 content in container 2, which is read with a seeker")-5), offset)
+
+	end, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal("eeker", string(end))
+
+	_, err = r.Seek(7, io.SeekStart)
+	ts.NoError(err)
+	_, err = r.Seek(1, io.SeekCurrent)
+	ts.NoError(err)
+	middle := make([]byte, 6)
+	_, err = io.ReadFull(r, middle)
+	ts.NoError(err)
+	ts.Equal("ontent", string(middle))
+
+	_, err = r.Seek(-100, io.SeekStart)
+	ts.Error(err)
+	ts.NoError(r.Close())
+}
+
+func (ts *ReaderTestSuite) TestGetFileSize() {
+	size, err := ts.reader.GetFileSize(context.TODO(), ts.location("container-1"), "file1.txt")
+	ts.NoError(err)
+	ts.Equal(int64(len("file 1 content in container 1")), size)
+}
+
+func (ts *ReaderTestSuite) TestFindFile() {
+	location, err := ts.reader.FindFile(context.TODO(), "dir/file2.txt")
+	ts.NoError(err)
+	ts.Equal(ts.location("container-2"), location)
+}
+
+func (ts *ReaderTestSuite) TestFindFile_NotInPrefixedContainer() {
+	_, err := ts.reader.FindFile(context.TODO(), "file3.txt")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+}
+
+func (ts *ReaderTestSuite) TestPing() {
+	ts.NoError(ts.reader.Ping(context.TODO()))
+}
@@ -0,0 +1,176 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+)
+
+type endpointConfig struct {
+	AccountKey      string `mapstructure:"account_key"` // #nosec G117 -- needs to be exported for unmarshalling
+	AccountName     string `mapstructure:"account_name"`
+	CACert          string `mapstructure:"ca_cert"`
+	ChunkSize       string `mapstructure:"chunk_size"`
+	chunkSizeBytes  uint64
+	ContainerPrefix string `mapstructure:"container_prefix"`
+	Endpoint        string `mapstructure:"endpoint"`
+	MaxContainers   uint64 `mapstructure:"max_containers"`
+	MaxObjects      uint64 `mapstructure:"max_objects"`
+	MaxSize         string `mapstructure:"max_size"`
+	maxSizeBytes    uint64
+	WriterDisabled  bool `mapstructure:"writer_disabled"`
+
+	client *blobclient.Client // cached client for this endpoint, created by getClient
+}
+
+func loadConfig(backendName string) ([]*endpointConfig, error) {
+	var endpointConf []*endpointConfig
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".azure",
+		&endpointConf,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	var enabledEndpoints []*endpointConfig
+	for _, e := range endpointConf {
+		if e.WriterDisabled {
+			continue
+		}
+		switch {
+		case e.Endpoint == "":
+			return nil, errors.New("missing required parameter: endpoint")
+		case e.AccountName == "":
+			return nil, errors.New("missing required parameter: account_name")
+		case e.AccountKey == "":
+			return nil, errors.New("missing required parameter: account_key")
+		case e.ContainerPrefix == "":
+			return nil, errors.New("missing required parameter: container_prefix")
+		case !strings.HasPrefix(e.Endpoint, "https:") && !strings.HasPrefix(e.Endpoint, "http:"):
+			return nil, errors.New("unsupported or no scheme in endpoint")
+		default:
+		}
+
+		e.chunkSizeBytes = 50 * 1024 * 1024
+		if e.ChunkSize != "" {
+			byteSize, err := datasize.ParseString(e.ChunkSize)
+			if err != nil {
+				return nil, errors.New("could not parse chunk_size as a valid data size")
+			}
+			if byteSize < 1*datasize.MB {
+				return nil, errors.New("chunk_size can not be smaller than 1mb")
+			}
+			if byteSize > 1*datasize.GB {
+				return nil, errors.New("chunk_size can not be bigger than 1gb")
+			}
+			e.chunkSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxSize != "" {
+			byteSize, err := datasize.ParseString(e.MaxSize)
+			if err != nil {
+				return nil, errors.New("could not parse max_size as a valid data size")
+			}
+			e.maxSizeBytes = byteSize.Bytes()
+		}
+		if e.MaxContainers == 0 {
+			e.MaxContainers = 1
+		}
+		enabledEndpoints = append(enabledEndpoints, e)
+	}
+
+	return enabledEndpoints, nil
+}
+
+func (endpointConf *endpointConfig) getClient(_ context.Context) (*blobclient.Client, error) {
+	if endpointConf.client != nil {
+		return endpointConf.client, nil
+	}
+
+	httpClient, err := blobclient.NewHTTPClient(endpointConf.CACert)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointConf.client, err = blobclient.New(endpointConf.Endpoint, endpointConf.AccountName, endpointConf.AccountKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpointConf.client, nil
+}
+
+func (endpointConf *endpointConfig) findActiveContainer(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (string, error) {
+	client, err := endpointConf.getClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create azure client to endpoint: %s, due to %v", endpointConf.Endpoint, err)
+	}
+
+	containers, err := client.ListContainers(ctx, endpointConf.ContainerPrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to call azure endpoint: %s, due to %v", endpointConf.Endpoint, err)
+	}
+
+	if len(containers) == 0 {
+		activeContainer := endpointConf.ContainerPrefix + "1"
+		if err := client.CreateContainer(ctx, activeContainer); err != nil {
+			return "", fmt.Errorf("failed to create container: %s at endpoint: %s, due to %v", activeContainer, endpointConf.Endpoint, err)
+		}
+
+		return activeContainer, nil
+	}
+
+	slices.SortFunc(containers, strings.Compare)
+
+	// find first container with available object count and size
+	for _, container := range containers {
+		loc := blobclient.Location(endpointConf.Endpoint, container)
+		count, err := locationBroker.GetObjectCount(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get object count of location %s, due to %v", loc, err)
+		}
+		if count >= endpointConf.MaxObjects && endpointConf.MaxObjects > 0 {
+			continue
+		}
+
+		size, err := locationBroker.GetSize(ctx, backendName, loc)
+		if err != nil {
+			return "", fmt.Errorf("failed to get size of location %s, due to %v", loc, err)
+		}
+		if size >= endpointConf.maxSizeBytes && endpointConf.maxSizeBytes > 0 {
+			continue
+		}
+
+		return container, nil
+	}
+
+	// All created containers are full, check if we should create new one after latest increment
+	if uint64(len(containers)) >= endpointConf.MaxContainers {
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+
+	currentInc, err := strconv.Atoi(strings.TrimPrefix(containers[len(containers)-1], endpointConf.ContainerPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate next container increment after container %s, due to %v", containers[len(containers)-1], err)
+	}
+	activeContainer := fmt.Sprintf("%s%d", endpointConf.ContainerPrefix, currentInc+1)
+	if err := client.CreateContainer(ctx, activeContainer); err != nil {
+		return "", fmt.Errorf("failed to create container: %s at endpoint: %s, due to %v", activeContainer, endpointConf.Endpoint, err)
+	}
+
+	return activeContainer, nil
+}
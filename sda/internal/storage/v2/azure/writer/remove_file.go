@@ -0,0 +1,20 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+)
+
+// RemoveFile removes a blob from a container
+func (writer *Writer) RemoveFile(ctx context.Context, location, filePath string) error {
+	client, container, err := getClientForLocation(ctx, writer.configuredEndpoints, location)
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteBlob(ctx, container, filePath); err != nil {
+		return fmt.Errorf("failed to delete blob: %s, container: %s, location: %s, due to: %v", filePath, container, location, err)
+	}
+
+	return nil
+}
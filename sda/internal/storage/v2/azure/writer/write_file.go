@@ -0,0 +1,85 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
+	// Find endpoint / container that is to be used for writing
+	writer.Lock()
+	var activeContainer string
+	var err error
+	if writer.activeEndpoint != nil {
+		activeContainer, err = writer.activeEndpoint.findActiveContainer(ctx, writer.backendName, writer.locationBroker)
+		if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+			writer.Unlock()
+
+			return "", err
+		}
+	}
+	// Current active endpoint no longer has any free containers, roll over to next endpoint
+	if activeContainer == "" {
+		for _, endpointConf := range writer.configuredEndpoints {
+			if writer.activeEndpoint != nil && endpointConf.Endpoint == writer.activeEndpoint.Endpoint {
+				continue
+			}
+
+			activeContainer, err = endpointConf.findActiveContainer(ctx, writer.backendName, writer.locationBroker)
+			if err != nil {
+				if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+					continue
+				}
+				writer.Unlock()
+
+				return "", err
+			}
+			writer.activeEndpoint = endpointConf
+
+			break
+		}
+	}
+	if activeContainer == "" {
+		writer.Unlock()
+
+		return "", storageerrors.ErrorNoFreeBucket
+	}
+	endpointConf := writer.activeEndpoint
+	writer.Unlock()
+
+	client, err := endpointConf.getClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Upload the content as blocks of chunk size, and commit them as the blob once all blocks are uploaded
+	var blockIDs []string
+	buf := make([]byte, endpointConf.chunkSizeBytes)
+	for {
+		n, readErr := io.ReadFull(fileContent, buf)
+		if n > 0 {
+			blockID := blobclient.BlockID(len(blockIDs))
+			if err := client.PutBlock(ctx, activeContainer, filePath, blockID, buf[:n]); err != nil {
+				return "", fmt.Errorf("failed to upload block of blob: %s, container: %s, endpoint: %s, due to: %v", filePath, activeContainer, endpointConf.Endpoint, err)
+			}
+			blockIDs = append(blockIDs, blockID)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("failed to read content of blob: %s, due to: %v", filePath, readErr)
+		}
+	}
+
+	if err := client.PutBlockList(ctx, activeContainer, filePath, blockIDs); err != nil {
+		return "", fmt.Errorf("failed to commit blob: %s, container: %s, endpoint: %s, due to: %v", filePath, activeContainer, endpointConf.Endpoint, err)
+	}
+
+	return blobclient.Location(endpointConf.Endpoint, activeContainer), nil
+}
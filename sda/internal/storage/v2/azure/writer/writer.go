@@ -0,0 +1,104 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+)
+
+type Writer struct {
+	backendName         string
+	configuredEndpoints []*endpointConfig
+	activeEndpoint      *endpointConfig
+
+	locationBroker locationbroker.LocationBroker
+
+	sync.Mutex
+}
+
+// NewWriter initiates a storage backend
+func NewWriter(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (*Writer, error) {
+	endPointConf, err := loadConfig(backendName)
+	if err != nil {
+		return nil, err
+	}
+
+	if locationBroker == nil {
+		return nil, errors.New("locationBroker is required")
+	}
+
+	writer := &Writer{
+		backendName:    backendName,
+		locationBroker: locationBroker,
+	}
+	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
+		return locationscheme.Is(location, locationscheme.Azure)
+	}, findSizeAndObjectCountOfLocation(endPointConf))
+
+	// Verify endpointConfig connections
+	for _, e := range endPointConf {
+		_, err := e.findActiveContainer(ctx, backendName, writer.locationBroker)
+		if err != nil {
+			if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+				log.Warningf("azure: %s has no available container", e.Endpoint)
+				writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+
+				continue
+			}
+
+			return nil, err
+		}
+		writer.configuredEndpoints = append(writer.configuredEndpoints, e)
+		// Set first active endpoint as current
+		if writer.activeEndpoint == nil {
+			writer.activeEndpoint = e
+		}
+	}
+
+	if len(writer.configuredEndpoints) == 0 {
+		return nil, storageerrors.ErrorNoValidLocations
+	}
+
+	return writer, nil
+}
+
+func getClientForLocation(ctx context.Context, configuredEndpoints []*endpointConfig, location string) (*blobclient.Client, string, error) {
+	host, container, err := blobclient.ParseLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, e := range configuredEndpoints {
+		if !blobclient.MatchesEndpoint(e.Endpoint, host) {
+			continue
+		}
+		client, err := e.getClient(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create azure client to endpoint: %s, due to %v", e.Endpoint, err)
+		}
+
+		return client, container, nil
+	}
+
+	return nil, "", storageerrors.ErrorNoEndpointConfiguredForLocation
+}
+
+// findSizeAndObjectCountOfLocation find the total size and total amount of blobs in a container if we do not store
+// this information in the database
+func findSizeAndObjectCountOfLocation(configuredEndpoints []*endpointConfig) func(ctx context.Context, location string) (uint64, uint64, error) {
+	return func(ctx context.Context, location string) (uint64, uint64, error) {
+		client, container, err := getClientForLocation(ctx, configuredEndpoints, location)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return client.ContainerSizeAndCount(ctx, container)
+	}
+}
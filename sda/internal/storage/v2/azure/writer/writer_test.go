@@ -0,0 +1,325 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/blobclient"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type WriterTestSuite struct {
+	suite.Suite
+	writer *Writer
+
+	configDir string
+
+	azureMock1, azureMock2 *mockAzure
+	locationBrokerMock     *mockLocationBroker
+}
+
+type mockLocationBroker struct {
+	mock.Mock
+}
+
+func (m *mockLocationBroker) GetObjectCount(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	count := args.Int(0)
+	if count < 0 {
+		count = 0
+	}
+	//nolint:gosec // disable G115
+	return uint64(count), args.Error(1)
+}
+
+func (m *mockLocationBroker) GetSize(_ context.Context, _, location string) (uint64, error) {
+	args := m.Called(location)
+	size := args.Int(0)
+	if size < 0 {
+		size = 0
+	}
+	//nolint:gosec // disable G115
+	return uint64(size), args.Error(1)
+}
+func (m *mockLocationBroker) RegisterSizeAndCountFinderFunc(_ string, _ func(string) bool, _ func(context.Context, string) (uint64, uint64, error)) {
+	_ = m.Called()
+}
+
+type mockAzure struct {
+	server     *httptest.Server
+	lock       sync.Mutex
+	containers map[string]map[string]string // "container name" -> "blob name" -> "content"
+	blocks     map[string][]byte            // "container name/blob name/block id" -> "content"
+}
+
+func newMockAzure() *mockAzure {
+	m := &mockAzure{
+		containers: map[string]map[string]string{},
+		blocks:     map[string][]byte{},
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.handler))
+
+	return m
+}
+
+func (m *mockAzure) handler(w http.ResponseWriter, req *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/account/")
+	container, blob, _ := strings.Cut(path, "/")
+	query := req.URL.Query()
+
+	switch {
+	case container == "" && query.Get("comp") == "list":
+		var names []string
+		for name := range m.containers {
+			if strings.HasPrefix(name, query.Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Containers>`)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(w, `<Container><Name>%s</Name></Container>`, name)
+		}
+		_, _ = fmt.Fprint(w, `</Containers><NextMarker/></EnumerationResults>`)
+	case query.Get("restype") == "container" && query.Get("comp") == "list":
+		_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		for name, content := range m.containers[container] {
+			_, _ = fmt.Fprintf(w, `<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties></Blob>`, name, len(content))
+		}
+		_, _ = fmt.Fprint(w, `</Blobs><NextMarker/></EnumerationResults>`)
+	case query.Get("restype") == "container" && req.Method == http.MethodPut:
+		if _, ok := m.containers[container]; ok {
+			w.Header().Set("x-ms-error-code", "ContainerAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+
+			return
+		}
+		m.containers[container] = map[string]string{}
+		w.WriteHeader(http.StatusCreated)
+	case query.Get("comp") == "block":
+		body, _ := io.ReadAll(req.Body)
+		m.blocks[path+"/"+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case query.Get("comp") == "blocklist":
+		blockList := struct {
+			Latest []string `xml:"Latest"`
+		}{}
+		if err := xml.NewDecoder(req.Body).Decode(&blockList); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+		if _, ok := m.containers[container]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		var content []byte
+		for _, id := range blockList.Latest {
+			content = append(content, m.blocks[path+"/"+id]...)
+		}
+		m.containers[container][blob] = string(content)
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodDelete:
+		if _, ok := m.containers[container][blob]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		delete(m.containers[container], blob)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(WriterTestSuite))
+}
+
+func (ts *WriterTestSuite) SetupSuite() {
+	ts.configDir = ts.T().TempDir()
+
+	ts.azureMock1 = newMockAzure()
+	ts.azureMock2 = newMockAzure()
+
+	accountKey := base64.StdEncoding.EncodeToString([]byte("mock-account-key"))
+	if err := os.WriteFile(filepath.Join(ts.configDir, "config.yaml"), []byte(fmt.Sprintf(`
+storage:
+  test:
+    azure:
+    - endpoint: %s/account
+      account_name: account
+      account_key: %s
+      max_objects: 10
+      max_size: 10kb
+      max_containers: 2
+      container_prefix: container-in-1-
+      chunk_size: 1mb
+    - endpoint: %s/account
+      account_name: account
+      account_key: %s
+      max_objects: 5
+      max_size: 5kb
+      container_prefix: container-in-2-
+    - endpoint: http://disabled.example.com
+      writer_disabled: true
+`, ts.azureMock1.server.URL, accountKey, ts.azureMock2.server.URL, accountKey)), 0600); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(filepath.Join(ts.configDir, "config.yaml"))
+
+	if err := viper.ReadInConfig(); err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) TearDownSuite() {
+	ts.azureMock1.server.Close()
+	ts.azureMock2.server.Close()
+}
+
+func (ts *WriterTestSuite) SetupTest() {
+	ts.azureMock1.containers = map[string]map[string]string{}
+	ts.azureMock2.containers = map[string]map[string]string{}
+	ts.locationBrokerMock = &mockLocationBroker{}
+
+	var err error
+	ts.locationBrokerMock.On("RegisterSizeAndCountFinderFunc").Return().Once()
+	ts.writer, err = NewWriter(context.TODO(), "test", ts.locationBrokerMock)
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+}
+
+func (ts *WriterTestSuite) location(m *mockAzure, container string) string {
+	return blobclient.Location(m.server.URL+"/account", container)
+}
+
+func (ts *WriterTestSuite) TestNewWriter_CreatesContainers() {
+	ts.Contains(ts.azureMock1.containers, "container-in-1-1")
+	ts.Contains(ts.azureMock2.containers, "container-in-2-1")
+	ts.Len(ts.writer.configuredEndpoints, 2)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_AllEmpty() {
+	content := "test file 1"
+
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-1")).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", ts.location(ts.azureMock1, "container-in-1-1")).Return(0, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "dir/test_file_1.txt", bytes.NewReader([]byte(content)))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(content, ts.azureMock1.containers["container-in-1-1"]["dir/test_file_1.txt"])
+	ts.Equal(ts.location(ts.azureMock1, "container-in-1-1"), location)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_MultipleBlocks() {
+	content := bytes.Repeat([]byte("a"), 1024*1024+10)
+
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-1")).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", ts.location(ts.azureMock1, "container-in-1-1")).Return(0, nil).Once()
+
+	_, err := ts.writer.WriteFile(context.TODO(), "big_file.txt", bytes.NewReader(content))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(string(content), ts.azureMock1.containers["container-in-1-1"]["big_file.txt"])
+}
+
+func (ts *WriterTestSuite) TestWriteFile_FirstContainerFull() {
+	content := "test file 1"
+
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-1")).Return(11, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", bytes.NewReader([]byte(content)))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(content, ts.azureMock1.containers["container-in-1-2"]["test_file_1.txt"])
+	ts.Equal(ts.location(ts.azureMock1, "container-in-1-2"), location)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_FirstEndpointFull() {
+	content := "test file 2"
+
+	ts.azureMock1.containers["container-in-1-2"] = map[string]string{}
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-1")).Return(11, nil).Once()
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-2")).Return(11, nil).Once()
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock2, "container-in-2-1")).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", ts.location(ts.azureMock2, "container-in-2-1")).Return(0, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "test_file_2.txt", bytes.NewReader([]byte(content)))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(content, ts.azureMock2.containers["container-in-2-1"]["test_file_2.txt"])
+	ts.Equal(ts.location(ts.azureMock2, "container-in-2-1"), location)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_AllFull() {
+	ts.azureMock1.containers["container-in-1-2"] = map[string]string{}
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-1")).Return(11, nil)
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock1, "container-in-1-2")).Return(11, nil)
+	ts.locationBrokerMock.On("GetObjectCount", ts.location(ts.azureMock2, "container-in-2-1")).Return(6, nil)
+
+	_, err := ts.writer.WriteFile(context.TODO(), "test_file.txt", bytes.NewReader([]byte("content")))
+	ts.ErrorIs(err, storageerrors.ErrorNoFreeBucket)
+}
+
+func (ts *WriterTestSuite) TestRemoveFile() {
+	ts.azureMock1.containers["container-in-1-1"]["file_to_be_removed"] = "file to be removed content"
+
+	err := ts.writer.RemoveFile(context.TODO(), ts.location(ts.azureMock1, "container-in-1-1"), "file_to_be_removed")
+	ts.NoError(err)
+
+	_, ok := ts.azureMock1.containers["container-in-1-1"]["file_to_be_removed"]
+	ts.False(ok, "file to be removed still exists")
+}
+
+func (ts *WriterTestSuite) TestRemoveFile_LocationNotConfigured() {
+	err := ts.writer.RemoveFile(context.TODO(), "az://not.configured.example.com/container-in-1-1", "file")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+}
+
+func (ts *WriterTestSuite) TestRemoveFile_InvalidLocation() {
+	err := ts.writer.RemoveFile(context.TODO(), "/posix/path", "file")
+	ts.ErrorIs(err, storageerrors.ErrorInvalidLocation)
+}
+
+func (ts *WriterTestSuite) TestFindSizeAndObjectCountOfLocation() {
+	ts.azureMock2.containers["container-in-2-1"]["file1"] = "12345"
+	ts.azureMock2.containers["container-in-2-1"]["file2"] = "123"
+
+	size, count, err := findSizeAndObjectCountOfLocation(ts.writer.configuredEndpoints)(context.TODO(), ts.location(ts.azureMock2, "container-in-2-1"))
+	ts.NoError(err)
+	ts.Equal(uint64(8), size)
+	ts.Equal(uint64(2), count)
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ChecksumLookup defines the method used by the verifying reader to find the checksum a file is expected to have
+type ChecksumLookup interface {
+	// GetArchivedChecksum returns the algorithm and hex encoded value of the ARCHIVED checksum of the file at the file
+	// path in the location, an empty checksum is to be returned if no checksum has been recorded for the file
+	GetArchivedChecksum(ctx context.Context, location, filePath string) (string, string, error)
+}
+
+type checksumVerifyingReader struct {
+	Reader
+	lookup ChecksumLookup
+}
+
+// NewChecksumVerifyingReader wraps the reader such that files which are read in full, from start to end, are hashed
+// while being read and the final Read returns a *storageerrors.ChecksumMismatchError if the content does not match
+// the checksum returned by the lookup.
+// Verification is opt-in, and the reader is returned as is unless storage.${STORAGE_NAME}.verify_checksums is set.
+func NewChecksumVerifyingReader(backendName string, reader Reader, lookup ChecksumLookup) Reader {
+	if !viper.GetBool("storage." + backendName + ".verify_checksums") {
+		return reader
+	}
+
+	return &checksumVerifyingReader{Reader: reader, lookup: lookup}
+}
+
+func (r *checksumVerifyingReader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	fileReader, err := r.Reader.NewFileReader(ctx, location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := r.newVerifier(ctx, location, filePath)
+	if verifier == nil {
+		return fileReader, nil
+	}
+	verifier.source = fileReader
+
+	return verifier, nil
+}
+
+func (r *checksumVerifyingReader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	fileReadSeeker, err := r.Reader.NewFileReadSeeker(ctx, location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := r.newVerifier(ctx, location, filePath)
+	if verifier == nil {
+		return fileReadSeeker, nil
+	}
+	verifier.source = fileReadSeeker
+
+	return &checksumVerifyingReadSeeker{checksumVerifier: verifier, seeker: fileReadSeeker}, nil
+}
+
+// newVerifier looks up the expected checksum of the file, nil is returned if the file can not be verified
+func (r *checksumVerifyingReader) newVerifier(ctx context.Context, location, filePath string) *checksumVerifier {
+	algorithm, expected, err := r.lookup.GetArchivedChecksum(ctx, location, filePath)
+	if err != nil {
+		log.Warnf("failed to get checksum of file: %s in location: %s, file will not be verified, due to: %v", filePath, location, err)
+
+		return nil
+	}
+	if expected == "" {
+		return nil
+	}
+
+	var h hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		log.Warnf("unsupported checksum algorithm: %s of file: %s in location: %s, file will not be verified", algorithm, filePath, location)
+
+		return nil
+	}
+
+	return &checksumVerifier{algorithm: strings.ToLower(algorithm), expected: strings.ToLower(expected), hash: h, active: true}
+}
+
+// checksumVerifier hashes all content read from the source and compares it to the expected checksum once the source
+// has been read to its end
+type checksumVerifier struct {
+	source    io.ReadCloser
+	algorithm string
+	expected  string
+	hash      hash.Hash
+	// active is false once the content read is known to not be the full file in order, eg after a seek
+	active bool
+}
+
+func (v *checksumVerifier) Read(p []byte) (int, error) {
+	n, err := v.source.Read(p)
+	if !v.active {
+		return n, err
+	}
+	_, _ = v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		v.active = false
+		if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+			return n, &storageerrors.ChecksumMismatchError{Algorithm: v.algorithm, Expected: v.expected, Actual: actual}
+		}
+	}
+
+	return n, err
+}
+
+func (v *checksumVerifier) Close() error {
+	return v.source.Close()
+}
+
+type checksumVerifyingReadSeeker struct {
+	*checksumVerifier
+	seeker io.ReadSeekCloser
+	offset int64
+}
+
+func (v *checksumVerifyingReadSeeker) Read(p []byte) (int, error) {
+	n, err := v.checksumVerifier.Read(p)
+	v.offset += int64(n)
+
+	return n, err
+}
+
+// Seek disables the verification unless the resulting offset is the offset already read to, as the content read would
+// otherwise not be the full file in order
+func (v *checksumVerifyingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	newOffset, err := v.seeker.Seek(offset, whence)
+	if err != nil {
+		return newOffset, err
+	}
+	if newOffset != v.offset {
+		v.active = false
+	}
+	v.offset = newOffset
+
+	return newOffset, nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type ChecksumReaderTestSuite struct {
+	suite.Suite
+
+	reader Reader
+	lookup *mockChecksumLookup
+}
+
+type mockChecksumLookup struct {
+	checksums map[string][2]string
+	err       error
+}
+
+func (m *mockChecksumLookup) GetArchivedChecksum(_ context.Context, location, filePath string) (string, string, error) {
+	if m.err != nil {
+		return "", "", m.err
+	}
+	checksum := m.checksums[location+"/"+filePath]
+
+	return checksum[0], checksum[1], nil
+}
+
+// mockContentReader serves the same content for every file
+type mockContentReader struct {
+	Reader
+	content []byte
+}
+
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error {
+	return nil
+}
+
+func (m *mockContentReader) NewFileReader(_ context.Context, _, _ string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(m.content)), nil
+}
+
+func (m *mockContentReader) NewFileReadSeeker(_ context.Context, _, _ string) (io.ReadSeekCloser, error) {
+	return nopReadSeekCloser{bytes.NewReader(m.content)}, nil
+}
+
+func TestChecksumReaderTestSuite(t *testing.T) {
+	suite.Run(t, new(ChecksumReaderTestSuite))
+}
+
+func (ts *ChecksumReaderTestSuite) SetupTest() {
+	viper.Reset()
+	viper.Set("storage.archive.verify_checksums", true)
+
+	content := []byte("content of the archived file")
+	ts.lookup = &mockChecksumLookup{checksums: map[string][2]string{
+		"/archive/file1":     {"SHA256", fmt.Sprintf("%x", sha256.Sum256(content))},
+		"/archive/file2":     {"md5", fmt.Sprintf("%x", md5.Sum(content))},
+		"/archive/corrupted": {"sha256", fmt.Sprintf("%x", sha256.Sum256([]byte("other content")))},
+	}}
+	ts.reader = NewChecksumVerifyingReader("archive", &mockContentReader{content: content}, ts.lookup)
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewChecksumVerifyingReader_NotEnabled() {
+	r := &mockContentReader{}
+	ts.Equal(r, NewChecksumVerifyingReader("inbox", r, ts.lookup))
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReader() {
+	for _, filePath := range []string{"file1", "file2"} {
+		r, err := ts.reader.NewFileReader(context.TODO(), "/archive", filePath)
+		if err != nil {
+			ts.FailNow(err.Error())
+		}
+
+		content, err := io.ReadAll(r)
+		ts.NoError(err, filePath)
+		ts.Equal("content of the archived file", string(content))
+		ts.NoError(r.Close())
+	}
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReader_ChecksumMismatch() {
+	r, err := ts.reader.NewFileReader(context.TODO(), "/archive", "corrupted")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	_, err = io.ReadAll(r)
+	ts.ErrorIs(err, storageerrors.ErrorChecksumMismatch)
+
+	var mismatchErr *storageerrors.ChecksumMismatchError
+	ts.True(errors.As(err, &mismatchErr))
+	ts.Equal("sha256", mismatchErr.Algorithm)
+	ts.Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("other content"))), mismatchErr.Expected)
+	ts.Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("content of the archived file"))), mismatchErr.Actual)
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReader_NoChecksum() {
+	r, err := ts.reader.NewFileReader(context.TODO(), "/archive", "not-verified-yet")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	_, err = io.ReadAll(r)
+	ts.NoError(err)
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReader_LookupFailed() {
+	ts.lookup.err = errors.New("database unavailable")
+
+	r, err := ts.reader.NewFileReader(context.TODO(), "/archive", "corrupted")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	_, err = io.ReadAll(r)
+	ts.NoError(err)
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReadSeeker_ChecksumMismatch() {
+	r, err := ts.reader.NewFileReadSeeker(context.TODO(), "/archive", "corrupted")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	// Seeking to the offset already read to does not affect the verification
+	_, err = r.Seek(0, io.SeekStart)
+	ts.NoError(err)
+
+	_, err = io.ReadAll(r)
+	ts.ErrorIs(err, storageerrors.ErrorChecksumMismatch)
+}
+
+func (ts *ChecksumReaderTestSuite) TestNewFileReadSeeker_SeekDisablesVerification() {
+	r, err := ts.reader.NewFileReadSeeker(context.TODO(), "/archive", "corrupted")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	_, err = r.Seek(8, io.SeekStart)
+	ts.NoError(err)
+
+	content, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.Equal("of the archived file", string(content))
+}
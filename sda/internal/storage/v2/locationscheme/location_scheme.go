@@ -0,0 +1,65 @@
+// Package locationscheme resolves which storage implementation a location belongs to from the scheme of the location
+package locationscheme
+
+import (
+	"strings"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// Storage implementations a location can belong to, the values match the storage implementation names used in the
+// storage config
+const (
+	S3    = "s3"
+	Posix = "posix"
+	Azure = "azure"
+)
+
+// Scheme prefixes of locations
+const (
+	S3Prefix    = "s3://"
+	PosixPrefix = "file://"
+	AzurePrefix = "az://"
+)
+
+// Parse returns the storage implementation of the location, and the location as expected by that storage
+// implementation.
+//
+// Supported locations are:
+//   - "s3://${HOST}/${BUCKET}", and the legacy "http(s)://${HOST}/${BUCKET}" for s3
+//   - "file://${PATH}", and the legacy absolute "${PATH}" for posix, the returned location is the absolute path
+//   - "az://${HOST}/${CONTAINER}" for azure
+func Parse(location string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(location, PosixPrefix):
+		path := strings.TrimPrefix(location, PosixPrefix)
+		if !strings.HasPrefix(path, "/") {
+			return "", "", storageerrors.ErrorInvalidLocation
+		}
+
+		return Posix, path, nil
+	case strings.HasPrefix(location, "/"):
+		return Posix, location, nil
+	case strings.HasPrefix(location, S3Prefix),
+		strings.HasPrefix(location, "http://"),
+		strings.HasPrefix(location, "https://"):
+		return S3, location, nil
+	case strings.HasPrefix(location, AzurePrefix):
+		return Azure, location, nil
+	default:
+		return "", "", storageerrors.ErrorInvalidLocation
+	}
+}
+
+// Is reports if the location belongs to the storage implementation
+func Is(location, implementation string) bool {
+	impl, _, err := Parse(location)
+
+	return err == nil && impl == implementation
+}
+
+// TrimHTTPScheme removes any http or https scheme from an endpoint, allowing endpoints to be compared with the host
+// part of a scheme based location
+func TrimHTTPScheme(endpoint string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+}
@@ -0,0 +1,41 @@
+package locationscheme
+
+import (
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		location               string
+		expectedImplementation string
+		expectedLocation       string
+		expectedErr            error
+	}{
+		{location: "/archive", expectedImplementation: Posix, expectedLocation: "/archive"},
+		{location: "file:///archive", expectedImplementation: Posix, expectedLocation: "/archive"},
+		{location: "file://archive", expectedErr: storageerrors.ErrorInvalidLocation},
+		{location: "s3://s3.example.com/bucket1", expectedImplementation: S3, expectedLocation: "s3://s3.example.com/bucket1"},
+		{location: "https://s3.example.com/bucket1", expectedImplementation: S3, expectedLocation: "https://s3.example.com/bucket1"},
+		{location: "http://127.0.0.1:9000/bucket1", expectedImplementation: S3, expectedLocation: "http://127.0.0.1:9000/bucket1"},
+		{location: "az://127.0.0.1:10000/devstoreaccount1/container1", expectedImplementation: Azure, expectedLocation: "az://127.0.0.1:10000/devstoreaccount1/container1"},
+		{location: "archive", expectedErr: storageerrors.ErrorInvalidLocation},
+		{location: "", expectedErr: storageerrors.ErrorInvalidLocation},
+	} {
+		t.Run(test.location, func(t *testing.T) {
+			impl, loc, err := Parse(test.location)
+			assert.ErrorIs(t, err, test.expectedErr)
+			assert.Equal(t, test.expectedImplementation, impl)
+			assert.Equal(t, test.expectedLocation, loc)
+		})
+	}
+}
+
+func TestIs(t *testing.T) {
+	assert.True(t, Is("/archive", Posix))
+	assert.True(t, Is("az://host/container", Azure))
+	assert.False(t, Is("az://host/container", S3))
+	assert.False(t, Is("archive", Posix))
+}
@@ -0,0 +1,146 @@
+// Package memory provides an in-process storage backend implementing both storage.Reader and storage.Writer, intended
+// for tests of services which would otherwise require s3 or posix storage
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+)
+
+// Storage keeps the files written to it in memory, at a single location
+type Storage struct {
+	sync.RWMutex
+	location string
+	files    map[string][]byte
+}
+
+// NewStorage returns an empty Storage at the location, the location is returned by WriteFile and expected by the
+// reading methods
+func NewStorage(location string) *Storage {
+	return &Storage{location: location, files: make(map[string][]byte)}
+}
+
+// Location returns the location of the storage
+func (s *Storage) Location() string {
+	return s.location
+}
+
+// WriteFile stores the content of the file, replacing any previous content
+func (s *Storage) WriteFile(_ context.Context, filePath string, fileContent io.Reader) (string, error) {
+	content, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to read content of file: %s, due to: %v", filePath, err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.files[filePath] = content
+
+	return s.location, nil
+}
+
+// RemoveFile removes the file
+func (s *Storage) RemoveFile(_ context.Context, location, filePath string) error {
+	if location != s.location {
+		return storageerrors.ErrorNoEndpointConfiguredForLocation
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.files[filePath]; !ok {
+		return storageerrors.ErrorFileNotFoundInLocation
+	}
+	delete(s.files, filePath)
+
+	return nil
+}
+
+func (s *Storage) content(location, filePath string) ([]byte, error) {
+	if location != s.location {
+		return nil, storageerrors.ErrorNoEndpointConfiguredForLocation
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	content, ok := s.files[filePath]
+	if !ok {
+		return nil, storageerrors.ErrorFileNotFoundInLocation
+	}
+
+	return content, nil
+}
+
+// NewFileReader opens a reader of the content of the file
+func (s *Storage) NewFileReader(_ context.Context, location, filePath string) (io.ReadCloser, error) {
+	content, err := s.content(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// NewFileReadSeeker opens a read seeker of the content of the file
+func (s *Storage) NewFileReadSeeker(_ context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	content, err := s.content(location, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return readSeekNopCloser{bytes.NewReader(content)}, nil
+}
+
+// FindFile returns the location of the storage if the file exists
+func (s *Storage) FindFile(_ context.Context, filePath string) (string, error) {
+	if _, err := s.content(s.location, filePath); err != nil {
+		return "", err
+	}
+
+	return s.location, nil
+}
+
+// GetFileSize returns the size of the content of the file
+func (s *Storage) GetFileSize(_ context.Context, location, filePath string) (int64, error) {
+	content, err := s.content(location, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+
+// Ping always succeeds
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
+}
+
+// Files returns the paths of the files stored, in lexical order
+func (s *Storage) Files() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	files := make([]string, 0, len(s.files))
+	for filePath := range s.files {
+		files = append(files, filePath)
+	}
+	slices.Sort(files)
+
+	return files
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryTestSuite struct {
+	suite.Suite
+	storage *Storage
+}
+
+func TestMemoryTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryTestSuite))
+}
+
+func (ts *MemoryTestSuite) SetupTest() {
+	ts.storage = NewStorage("memory://archive")
+}
+
+func (ts *MemoryTestSuite) TestWriteAndRead() {
+	location, err := ts.storage.WriteFile(context.TODO(), "dir/file.c4gh", strings.NewReader("file content"))
+	ts.Require().NoError(err)
+	ts.Equal("memory://archive", location)
+
+	r, err := ts.storage.NewFileReader(context.TODO(), location, "dir/file.c4gh")
+	ts.Require().NoError(err)
+	content, err := io.ReadAll(r)
+	ts.NoError(err)
+	ts.NoError(r.Close())
+	ts.Equal("file content", string(content))
+
+	rs, err := ts.storage.NewFileReadSeeker(context.TODO(), location, "dir/file.c4gh")
+	ts.Require().NoError(err)
+	_, err = rs.Seek(5, io.SeekStart)
+	ts.NoError(err)
+	content, err = io.ReadAll(rs)
+	ts.NoError(err)
+	ts.NoError(rs.Close())
+	ts.Equal("content", string(content))
+
+	size, err := ts.storage.GetFileSize(context.TODO(), location, "dir/file.c4gh")
+	ts.NoError(err)
+	ts.Equal(int64(12), size)
+
+	found, err := ts.storage.FindFile(context.TODO(), "dir/file.c4gh")
+	ts.NoError(err)
+	ts.Equal(location, found)
+
+	ts.Equal([]string{"dir/file.c4gh"}, ts.storage.Files())
+}
+
+func (ts *MemoryTestSuite) TestRemoveFile() {
+	location, err := ts.storage.WriteFile(context.TODO(), "file.c4gh", strings.NewReader("file content"))
+	ts.Require().NoError(err)
+
+	ts.ErrorIs(ts.storage.RemoveFile(context.TODO(), "memory://inbox", "file.c4gh"), storageerrors.ErrorNoEndpointConfiguredForLocation)
+	ts.NoError(ts.storage.RemoveFile(context.TODO(), location, "file.c4gh"))
+	ts.ErrorIs(ts.storage.RemoveFile(context.TODO(), location, "file.c4gh"), storageerrors.ErrorFileNotFoundInLocation)
+
+	_, err = ts.storage.NewFileReader(context.TODO(), location, "file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+	_, err = ts.storage.FindFile(context.TODO(), "file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorFileNotFoundInLocation)
+	ts.Empty(ts.storage.Files())
+}
+
+func (ts *MemoryTestSuite) TestWrongLocation() {
+	_, err := ts.storage.WriteFile(context.TODO(), "file.c4gh", strings.NewReader("file content"))
+	ts.Require().NoError(err)
+
+	_, err = ts.storage.GetFileSize(context.TODO(), "memory://inbox", "file.c4gh")
+	ts.ErrorIs(err, storageerrors.ErrorNoEndpointConfiguredForLocation)
+}
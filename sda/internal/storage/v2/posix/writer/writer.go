@@ -7,10 +7,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 	log "github.com/sirupsen/logrus"
 )
@@ -39,7 +39,7 @@ func NewWriter(ctx context.Context, backendName string, locationBroker locationb
 		locationBroker: locationBroker,
 	}
 	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
-		return strings.HasPrefix(location, "/")
+		return locationscheme.Is(location, locationscheme.Posix)
 	}, findSizeAndObjectCountInDir)
 
 	// Verify locations
@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"io"
-	"strings"
 
+	azurereader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/reader"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
 	posixreader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/posix/reader"
 	s3reader "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/s3/reader"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
@@ -26,12 +27,12 @@ type Reader interface {
 }
 
 type reader struct {
-	posixReader Reader
-	s3Reader    Reader
+	// readers holds the configured reader of each storage implementation, keyed by the implementation name
+	readers map[string]Reader
 }
 
 func NewReader(ctx context.Context, backendName string) (Reader, error) {
-	r := &reader{}
+	r := &reader{readers: make(map[string]Reader)}
 
 	s3Reader, err := s3reader.NewReader(ctx, backendName)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
@@ -41,66 +42,79 @@ func NewReader(ctx context.Context, backendName string) (Reader, error) {
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
+	azureReader, err := azurereader.NewReader(ctx, backendName)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
 
-	if s3Reader == nil && posixReader == nil {
-		return nil, storageerrors.ErrorNoValidReader
+	if s3Reader != nil {
+		r.readers[locationscheme.S3] = s3Reader
 	}
 	if posixReader != nil {
-		r.posixReader = posixReader
+		r.readers[locationscheme.Posix] = posixReader
 	}
-	if s3Reader != nil {
-		r.s3Reader = s3Reader
+	if azureReader != nil {
+		r.readers[locationscheme.Azure] = azureReader
+	}
+	if len(r.readers) == 0 {
+		return nil, storageerrors.ErrorNoValidReader
 	}
 
 	return r, nil
 }
 
-func (r *reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.NewFileReader(ctx, location, filePath)
+// readerForLocation returns the reader of the storage implementation the location belongs to, and the location as
+// expected by that reader
+func (r *reader) readerForLocation(location string) (Reader, string, error) {
+	implementation, loc, err := locationscheme.Parse(location)
+	if err != nil {
+		return nil, "", err
 	}
 
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.NewFileReader(ctx, location, filePath)
+	implReader, ok := r.readers[implementation]
+	if !ok {
+		return nil, "", storageerrors.ErrorNoValidReader
 	}
 
-	return nil, storageerrors.ErrorNoValidReader
+	return implReader, loc, nil
 }
 
-func (r *reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.NewFileReadSeeker(ctx, location, filePath)
+func (r *reader) NewFileReader(ctx context.Context, location, filePath string) (io.ReadCloser, error) {
+	implReader, loc, err := r.readerForLocation(location)
+	if err != nil {
+		return nil, err
 	}
 
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.NewFileReadSeeker(ctx, location, filePath)
+	return implReader.NewFileReader(ctx, loc, filePath)
+}
+
+func (r *reader) NewFileReadSeeker(ctx context.Context, location, filePath string) (io.ReadSeekCloser, error) {
+	implReader, loc, err := r.readerForLocation(location)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, storageerrors.ErrorNoValidReader
+	return implReader.NewFileReadSeeker(ctx, loc, filePath)
 }
 
 func (r *reader) GetFileSize(ctx context.Context, location, filePath string) (int64, error) {
-	if strings.HasPrefix(location, "/") && r.posixReader != nil {
-		return r.posixReader.GetFileSize(ctx, location, filePath)
-	}
-
-	if !strings.HasPrefix(location, "/") && r.s3Reader != nil {
-		return r.s3Reader.GetFileSize(ctx, location, filePath)
+	implReader, loc, err := r.readerForLocation(location)
+	if err != nil {
+		return 0, err
 	}
 
-	return 0, storageerrors.ErrorNoValidReader
+	return implReader.GetFileSize(ctx, loc, filePath)
 }
 
 func (r *reader) Ping(ctx context.Context) error {
 	var errs []error
 
-	if r.s3Reader != nil {
-		if err := r.s3Reader.Ping(ctx); err != nil {
-			errs = append(errs, err)
+	for _, implementation := range readerPriority {
+		implReader, ok := r.readers[implementation]
+		if !ok {
+			continue
 		}
-	}
-	if r.posixReader != nil {
-		if err := r.posixReader.Ping(ctx); err != nil {
+		if err := implReader.Ping(ctx); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -109,17 +123,12 @@ func (r *reader) Ping(ctx context.Context) error {
 }
 
 func (r *reader) FindFile(ctx context.Context, filePath string) (string, error) {
-	if r.s3Reader != nil {
-		loc, err := r.s3Reader.FindFile(ctx, filePath)
-		if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
-			return "", err
-		}
-		if loc != "" {
-			return loc, nil
+	for _, implementation := range readerPriority {
+		implReader, ok := r.readers[implementation]
+		if !ok {
+			continue
 		}
-	}
-	if r.posixReader != nil {
-		loc, err := r.posixReader.FindFile(ctx, filePath)
+		loc, err := implReader.FindFile(ctx, filePath)
 		if err != nil && !errors.Is(err, storageerrors.ErrorFileNotFoundInLocation) {
 			return "", err
 		}
@@ -130,3 +139,6 @@ func (r *reader) FindFile(ctx context.Context, filePath string) (string, error)
 
 	return "", storageerrors.ErrorFileNotFoundInLocation
 }
+
+// readerPriority is the order in which the storage implementations are searched by FindFile
+var readerPriority = []string{locationscheme.S3, locationscheme.Posix, locationscheme.Azure}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// WriteHints describes the file about to be written, and is used by the routing writer to decide which storage
+// implementation the file is to be written to
+type WriteHints struct {
+	// Size of the file in bytes, a value <= 0 means the size is not known
+	Size int64
+	// User is the user who submitted the file
+	User string
+	// SubmissionPath is the path of the file as submitted by the user, usually prefixed by the dataset directory
+	SubmissionPath string
+}
+
+type writeHintsKey struct{}
+
+// ContextWithWriteHints returns a copy of the context carrying the write hints to be evaluated by the routing writer
+func ContextWithWriteHints(ctx context.Context, hints WriteHints) context.Context {
+	return context.WithValue(ctx, writeHintsKey{}, hints)
+}
+
+// writeHintsFromContext returns the write hints stored in the context, or empty hints if none were set
+func writeHintsFromContext(ctx context.Context) WriteHints {
+	hints, _ := ctx.Value(writeHintsKey{}).(WriteHints)
+
+	return hints
+}
+
+type routingRule struct {
+	Writer        string `mapstructure:"writer"`
+	MinSize       string `mapstructure:"min_size"`
+	minSizeBytes  uint64
+	MaxSize       string `mapstructure:"max_size"`
+	maxSizeBytes  uint64
+	Users         []string `mapstructure:"users"`
+	DatasetPrefix string   `mapstructure:"dataset_prefix"`
+}
+
+func loadRoutingRules(backendName string) ([]*routingRule, error) {
+	var rules []*routingRule
+
+	if err := viper.UnmarshalKey(
+		"storage."+backendName+".routing",
+		&rules,
+		func(config *mapstructure.DecoderConfig) {
+			config.WeaklyTypedInput = true
+			config.ZeroFields = true
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		switch r.Writer {
+		case locationscheme.S3, locationscheme.Posix, locationscheme.Azure:
+		case "":
+			return nil, errors.New("missing required parameter: writer in routing rule")
+		default:
+			return nil, fmt.Errorf("unsupported writer: %s in routing rule", r.Writer)
+		}
+
+		if r.MinSize != "" {
+			byteSize, err := datasize.ParseString(r.MinSize)
+			if err != nil {
+				return nil, errors.New("could not parse min_size as a valid data size")
+			}
+			r.minSizeBytes = byteSize.Bytes()
+		}
+		if r.MaxSize != "" {
+			byteSize, err := datasize.ParseString(r.MaxSize)
+			if err != nil {
+				return nil, errors.New("could not parse max_size as a valid data size")
+			}
+			r.maxSizeBytes = byteSize.Bytes()
+		}
+		if r.maxSizeBytes > 0 && r.minSizeBytes > r.maxSizeBytes {
+			return nil, errors.New("min_size can not be bigger than max_size in routing rule")
+		}
+	}
+
+	return rules, nil
+}
+
+// matches evaluates if the rule applies to a file described by the hints, a rule with a size constraint never
+// matches a file of unknown size
+func (r *routingRule) matches(hints WriteHints) bool {
+	if r.minSizeBytes > 0 || r.maxSizeBytes > 0 {
+		if hints.Size <= 0 {
+			return false
+		}
+		size := uint64(hints.Size) // #nosec G115 -- hints.Size has been checked to be bigger than 0
+		if size < r.minSizeBytes {
+			return false
+		}
+		if r.maxSizeBytes > 0 && size > r.maxSizeBytes {
+			return false
+		}
+	}
+
+	if len(r.Users) > 0 && !slices.Contains(r.Users, hints.User) {
+		return false
+	}
+
+	if r.DatasetPrefix != "" && !strings.HasPrefix(strings.TrimPrefix(hints.SubmissionPath, "/"), strings.TrimPrefix(r.DatasetPrefix, "/")) {
+		return false
+	}
+
+	return true
+}
+
+// routingWriter fronts multiple storage implementations and decides per file which one to write to based on
+// the configured routing rules
+type routingWriter struct {
+	rules   []*routingRule
+	writers map[string]Writer
+}
+
+func newRoutingWriter(rules []*routingRule, writers map[string]Writer) (*routingWriter, error) {
+	if len(rules) == 0 {
+		return nil, storageerrors.ErrorMultipleWritersNotSupported
+	}
+
+	for _, r := range rules {
+		if _, ok := writers[r.Writer]; !ok {
+			return nil, fmt.Errorf("routing rule references writer: %s, which is not configured", r.Writer)
+		}
+	}
+
+	return &routingWriter{
+		rules:   rules,
+		writers: writers,
+	}, nil
+}
+
+// WriteFile writes the file with the writer of the first matching routing rule, if that writer has no free
+// location left the next matching rule is evaluated
+func (w *routingWriter) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
+	hints := writeHintsFromContext(ctx)
+
+	var tried []string
+	for _, rule := range w.rules {
+		if !rule.matches(hints) || slices.Contains(tried, rule.Writer) {
+			continue
+		}
+		tried = append(tried, rule.Writer)
+
+		location, err := w.writers[rule.Writer].WriteFile(ctx, filePath, fileContent)
+		if err != nil {
+			if errors.Is(err, storageerrors.ErrorNoValidLocations) || errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+				log.Warningf("%s writer has no free location for file: %s, evaluating next routing rule", rule.Writer, filePath)
+
+				continue
+			}
+
+			return "", err
+		}
+
+		return location, nil
+	}
+
+	if len(tried) == 0 {
+		return "", storageerrors.ErrorNoRoutingRuleMatched
+	}
+
+	return "", storageerrors.ErrorNoValidLocations
+}
+
+func (w *routingWriter) RemoveFile(ctx context.Context, location, filePath string) error {
+	implementation, loc, err := locationscheme.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	writer, ok := w.writers[implementation]
+	if !ok {
+		return storageerrors.ErrorNoEndpointConfiguredForLocation
+	}
+
+	return writer.RemoveFile(ctx, loc, filePath)
+}
+
+// AbortUpload aborts the recorded upload with each of the writers, as the writer the upload was routed to is not known
+func (w *routingWriter) AbortUpload(ctx context.Context) error {
+	var errs []error
+	for _, writer := range w.writers {
+		if err := AbortUpload(ctx, writer); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type RoutingWriterTestSuite struct {
+	suite.Suite
+
+	s3WriterMock    *mockWriter
+	posixWriterMock *mockWriter
+}
+
+type mockWriter struct {
+	mock.Mock
+}
+
+func (m *mockWriter) RemoveFile(_ context.Context, location, filePath string) error {
+	args := m.Called(location, filePath)
+
+	return args.Error(0)
+}
+
+func (m *mockWriter) WriteFile(_ context.Context, filePath string, _ io.Reader) (string, error) {
+	args := m.Called(filePath)
+
+	return args.String(0), args.Error(1)
+}
+
+func TestRoutingWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(RoutingWriterTestSuite))
+}
+
+func (ts *RoutingWriterTestSuite) SetupTest() {
+	viper.Reset()
+	configDir := ts.T().TempDir()
+
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte(`
+storage:
+  test:
+    routing:
+    - writer: posix
+      users:
+      - posix-user
+    - writer: s3
+      dataset_prefix: DATASET001/
+    - writer: posix
+      max_size: 1kb
+    - writer: s3
+      min_size: 1kb
+    - writer: posix
+`), 0600); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(filepath.Join(configDir, "config.yaml"))
+	if err := viper.ReadInConfig(); err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.s3WriterMock = &mockWriter{}
+	ts.posixWriterMock = &mockWriter{}
+}
+
+func (ts *RoutingWriterTestSuite) newRoutingWriter() *routingWriter {
+	rules, err := loadRoutingRules("test")
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	w, err := newRoutingWriter(rules, map[string]Writer{"s3": ts.s3WriterMock, "posix": ts.posixWriterMock})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	return w
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_RoutedByUser() {
+	ts.posixWriterMock.On("WriteFile", "file1").Return("/posix", nil).Once()
+
+	ctx := ContextWithWriteHints(context.TODO(), WriteHints{Size: 2048, User: "posix-user", SubmissionPath: "DATASET001/file.c4gh"})
+	location, err := ts.newRoutingWriter().WriteFile(ctx, "file1", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("/posix", location)
+	ts.s3WriterMock.AssertNotCalled(ts.T(), "WriteFile", mock.Anything)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_RoutedByDatasetPrefix() {
+	ts.s3WriterMock.On("WriteFile", "file1").Return("http://s3/bucket1", nil).Once()
+
+	ctx := ContextWithWriteHints(context.TODO(), WriteHints{Size: 10, User: "user", SubmissionPath: "/DATASET001/file.c4gh"})
+	location, err := ts.newRoutingWriter().WriteFile(ctx, "file1", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("http://s3/bucket1", location)
+	ts.posixWriterMock.AssertNotCalled(ts.T(), "WriteFile", mock.Anything)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_RoutedBySize() {
+	ts.posixWriterMock.On("WriteFile", "small").Return("/posix", nil).Once()
+	ts.s3WriterMock.On("WriteFile", "big").Return("http://s3/bucket1", nil).Once()
+
+	w := ts.newRoutingWriter()
+
+	location, err := w.WriteFile(ContextWithWriteHints(context.TODO(), WriteHints{Size: 512}), "small", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("/posix", location)
+
+	location, err = w.WriteFile(ContextWithWriteHints(context.TODO(), WriteHints{Size: 2048}), "big", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("http://s3/bucket1", location)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_NoHints() {
+	ts.posixWriterMock.On("WriteFile", "file1").Return("/posix", nil).Once()
+
+	location, err := ts.newRoutingWriter().WriteFile(context.TODO(), "file1", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("/posix", location)
+	ts.s3WriterMock.AssertNotCalled(ts.T(), "WriteFile", mock.Anything)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_FallbackWhenFull() {
+	ts.s3WriterMock.On("WriteFile", "file1").Return("", storageerrors.ErrorNoFreeBucket).Once()
+	ts.posixWriterMock.On("WriteFile", "file1").Return("/posix", nil).Once()
+
+	ctx := ContextWithWriteHints(context.TODO(), WriteHints{Size: 2048})
+	location, err := ts.newRoutingWriter().WriteFile(ctx, "file1", bytes.NewReader([]byte("content")))
+	ts.NoError(err)
+	ts.Equal("/posix", location)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_AllFull() {
+	ts.s3WriterMock.On("WriteFile", "file1").Return("", storageerrors.ErrorNoFreeBucket).Once()
+	ts.posixWriterMock.On("WriteFile", "file1").Return("", storageerrors.ErrorNoValidLocations).Once()
+
+	ctx := ContextWithWriteHints(context.TODO(), WriteHints{Size: 2048})
+	_, err := ts.newRoutingWriter().WriteFile(ctx, "file1", bytes.NewReader([]byte("content")))
+	ts.ErrorIs(err, storageerrors.ErrorNoValidLocations)
+	ts.posixWriterMock.AssertNumberOfCalls(ts.T(), "WriteFile", 1)
+}
+
+func (ts *RoutingWriterTestSuite) TestWriteFile_NoRuleMatched() {
+	rules := []*routingRule{{Writer: "s3", Users: []string{"someone"}}}
+	w, err := newRoutingWriter(rules, map[string]Writer{"s3": ts.s3WriterMock, "posix": ts.posixWriterMock})
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	_, err = w.WriteFile(context.TODO(), "file1", bytes.NewReader([]byte("content")))
+	ts.ErrorIs(err, storageerrors.ErrorNoRoutingRuleMatched)
+}
+
+func (ts *RoutingWriterTestSuite) TestRemoveFile() {
+	ts.posixWriterMock.On("RemoveFile", "/posix", "file1").Return(nil).Once()
+	ts.s3WriterMock.On("RemoveFile", "http://s3/bucket1", "file2").Return(nil).Once()
+
+	w := ts.newRoutingWriter()
+	ts.NoError(w.RemoveFile(context.TODO(), "/posix", "file1"))
+	ts.NoError(w.RemoveFile(context.TODO(), "http://s3/bucket1", "file2"))
+	ts.posixWriterMock.AssertExpectations(ts.T())
+	ts.s3WriterMock.AssertExpectations(ts.T())
+}
+
+func (ts *RoutingWriterTestSuite) TestRemoveFile_SchemeBasedLocation() {
+	ts.posixWriterMock.On("RemoveFile", "/posix", "file1").Return(nil).Once()
+	ts.s3WriterMock.On("RemoveFile", "s3://s3/bucket1", "file2").Return(nil).Once()
+
+	w := ts.newRoutingWriter()
+	ts.NoError(w.RemoveFile(context.TODO(), "file:///posix", "file1"))
+	ts.NoError(w.RemoveFile(context.TODO(), "s3://s3/bucket1", "file2"))
+	ts.ErrorIs(w.RemoveFile(context.TODO(), "az://azure/container1", "file3"), storageerrors.ErrorNoEndpointConfiguredForLocation)
+	ts.ErrorIs(w.RemoveFile(context.TODO(), "posix", "file4"), storageerrors.ErrorInvalidLocation)
+	ts.posixWriterMock.AssertExpectations(ts.T())
+	ts.s3WriterMock.AssertExpectations(ts.T())
+}
+
+func (ts *RoutingWriterTestSuite) TestNewRoutingWriter_NoRules() {
+	_, err := newRoutingWriter(nil, map[string]Writer{"s3": ts.s3WriterMock, "posix": ts.posixWriterMock})
+	ts.ErrorIs(err, storageerrors.ErrorMultipleWritersNotSupported)
+}
+
+func (ts *RoutingWriterTestSuite) TestLoadRoutingRules_InvalidWriter() {
+	viper.Set("storage.invalid.routing", []map[string]any{{"writer": "tape"}})
+
+	_, err := loadRoutingRules("invalid")
+	ts.EqualError(err, "unsupported writer: tape in routing rule")
+}
+
+func (ts *RoutingWriterTestSuite) TestLoadRoutingRules_InvalidSizes() {
+	viper.Set("storage.invalid.routing", []map[string]any{{"writer": "s3", "min_size": "2kb", "max_size": "1kb"}})
+
+	_, err := loadRoutingRules("invalid")
+	ts.EqualError(err, "min_size can not be bigger than max_size in routing rule")
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 )
 
@@ -45,7 +46,7 @@ func NewReader(ctx context.Context, backendName string) (*Reader, error) {
 
 func (reader *Reader) getS3ClientForEndpoint(ctx context.Context, endpoint string) (*s3.Client, *endpointConfig, error) {
 	for _, e := range reader.endpoints {
-		if e.Endpoint != endpoint {
+		if e.Endpoint != endpoint && locationscheme.S3Prefix+locationscheme.TrimHTTPScheme(e.Endpoint) != endpoint {
 			continue
 		}
 		client, err := e.getS3Client(ctx)
@@ -76,7 +77,7 @@ func (reader *Reader) Ping(ctx context.Context) error {
 }
 
 // parseLocation attempts to parse a location to a s3 endpoint, and a bucket
-// expected format of location is "${ENDPOINT}/${BUCKET}
+// expected format of location is "${ENDPOINT}/${BUCKET}" or "s3://${HOST}/${BUCKET}"
 func parseLocation(location string) (string, string, error) {
 	locAsURL, err := url.Parse(location)
 	if err != nil {
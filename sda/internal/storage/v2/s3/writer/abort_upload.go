@@ -0,0 +1,37 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
+)
+
+// AbortUpload aborts the multipart upload recorded for the file in the upload state store of the context, see
+// uploadstate.ContextWithStore, and removes its state. It is to be called when an interrupted upload will not be
+// resumed, such that its parts are not kept in the bucket.
+func (writer *Writer) AbortUpload(ctx context.Context) error {
+	store, fileID := uploadstate.FromContext(ctx)
+	if store == nil {
+		return nil
+	}
+
+	state, err := store.GetUpload(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to get upload state of file: %s, due to: %v", fileID, err)
+	}
+	if state == nil {
+		return nil
+	}
+
+	upload, err := writer.recordedUpload(ctx, store, fileID, state)
+	if err != nil {
+		return err
+	}
+	if upload == nil {
+		return nil
+	}
+	upload.abort(ctx)
+
+	return nil
+}
@@ -39,6 +39,8 @@ type endpointConfig struct {
 	Endpoint       string `mapstructure:"endpoint"`
 	DisableHTTPS   bool   `mapstructure:"disable_https"`
 	WriterDisabled bool   `mapstructure:"writer_disabled"`
+	// UploadConcurrency is the number of parts of a file uploaded in parallel
+	UploadConcurrency int `mapstructure:"upload_concurrency"`
 
 	s3Client *s3.Client // cached s3 client for this endpoint, created by getS3Client
 }
@@ -95,6 +97,14 @@ func loadConfig(backendName string) ([]*endpointConfig, error) {
 					return nil, errors.New("chunk_size can not be bigger than 1gb")
 				}
 				e.chunkSizeBytes = byteSize.Bytes()
+			} else {
+				e.chunkSizeBytes = 50 * datasize.MB.Bytes()
+			}
+			if e.UploadConcurrency < 0 {
+				return nil, errors.New("upload_concurrency can not be negative")
+			}
+			if e.UploadConcurrency == 0 {
+				e.UploadConcurrency = 5
 			}
 			if e.MaxSize != "" {
 				byteSize, err := datasize.ParseString(e.MaxSize)
@@ -115,6 +125,13 @@ func loadConfig(backendName string) ([]*endpointConfig, error) {
 	return endpointConf, nil
 }
 
+// partSize returns the size of the parts files are uploaded in
+func (endpointConf *endpointConfig) partSize() int64 {
+	// Type conversion safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
+	//nolint:gosec // disable G115
+	return int64(endpointConf.chunkSizeBytes)
+}
+
 func (endpointConf *endpointConfig) getS3Client(ctx context.Context) (*s3.Client, error) {
 	if endpointConf.s3Client != nil {
 		return endpointConf.s3Client, nil
@@ -0,0 +1,316 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxParts is the maximum number of parts of a multipart upload allowed by S3
+const maxParts = 10000
+
+type multipartUpload struct {
+	client      *s3.Client
+	bucket      string
+	concurrency int
+	state       *uploadstate.Upload
+	// store persists the state of the upload, the upload is not resumable if nil
+	store  uploadstate.Store
+	fileID string
+
+	// completed holds the parts completed so far, including parts completed before the upload was resumed
+	completed []types.CompletedPart
+}
+
+// findResumableUpload returns the upload of the file recorded in the store if it can be resumed by this writer, or
+// nil if the file is to be uploaded from the start
+func (writer *Writer) findResumableUpload(ctx context.Context, store uploadstate.Store, fileID, filePath string) (*multipartUpload, error) {
+	state, err := store.GetUpload(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload state of object: %s, due to: %v", filePath, err)
+	}
+	if state == nil {
+		return nil, nil
+	}
+	upload, err := writer.recordedUpload(ctx, store, fileID, state)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil {
+		log.Infof("recorded upload of object: %s is to location: %s, which is not configured for this writer, starting over", filePath, state.Location)
+
+		return nil, nil
+	}
+	if state.Key != filePath {
+		// The recorded upload is replaced, it is aborted such that its parts are not kept in the bucket
+		log.Infof("recorded upload of file: %s is of object: %s, not: %s, starting over", fileID, state.Key, filePath)
+		upload.abort(ctx)
+
+		return nil, nil
+	}
+	client, bucket := upload.client, upload.bucket
+
+	// Only the parts which have been recorded as completed, and which are still part of the upload, are resumed
+	recorded := make(map[int32]uploadstate.Part, len(state.Parts))
+	for _, part := range state.Parts {
+		recorded[part.Number] = part
+	}
+	uploaded := make(map[int32]string)
+	paginator := s3.NewListPartsPaginator(client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(filePath),
+		UploadId: aws.String(state.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload" {
+				log.Infof("recorded upload of object: %s no longer exists in location: %s, starting over", filePath, state.Location)
+
+				return nil, nil
+			}
+
+			return nil, fmt.Errorf("failed to list parts of upload of object: %s, location: %s, due to: %v", filePath, state.Location, err)
+		}
+		for _, part := range page.Parts {
+			uploaded[aws.ToInt32(part.PartNumber)] = aws.ToString(part.ETag)
+		}
+	}
+
+	// As the content is read in order, the upload resumes after the first part which is missing
+	for number := int32(1); ; number++ {
+		part, ok := recorded[number]
+		if !ok || part.Size != state.PartSize || uploaded[number] != part.ETag {
+			break
+		}
+		upload.completed = append(upload.completed, types.CompletedPart{PartNumber: aws.Int32(number), ETag: aws.String(part.ETag)})
+	}
+
+	return upload, nil
+}
+
+// recordedUpload returns the recorded upload of the file, or nil if it is not to a location of this writer
+func (writer *Writer) recordedUpload(ctx context.Context, store uploadstate.Store, fileID string, state *uploadstate.Upload) (*multipartUpload, error) {
+	endpoint, bucket, err := parseLocation(state.Location)
+	if err != nil {
+		return nil, nil
+	}
+	var endpointConf *endpointConfig
+	for _, e := range writer.configuredEndpoints {
+		if e.Endpoint == endpoint {
+			endpointConf = e
+
+			break
+		}
+	}
+	if endpointConf == nil {
+		return nil, nil
+	}
+
+	client, err := endpointConf.getS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &multipartUpload{
+		client:      client,
+		bucket:      bucket,
+		concurrency: endpointConf.UploadConcurrency,
+		state:       state,
+		store:       store,
+		fileID:      fileID,
+	}, nil
+}
+
+// resume skips the content of the parts already completed, and uploads the rest of the content
+func (upload *multipartUpload) resume(ctx context.Context, fileContent io.Reader) (string, error) {
+	skip := int64(len(upload.completed)) * upload.state.PartSize
+	if _, err := io.CopyN(io.Discard, fileContent, skip); err != nil {
+		upload.abort(ctx)
+
+		return "", fmt.Errorf("failed to skip the %d bytes already uploaded of object: %s, due to: %v", skip, upload.state.Key, err)
+	}
+	log.Infof("resuming upload of object: %s to location: %s after %d completed parts", upload.state.Key, upload.state.Location, len(upload.completed))
+
+	return upload.run(ctx, fileContent)
+}
+
+// run uploads the content in parts of the part size, by up to concurrency parts in parallel, and completes the upload
+func (upload *multipartUpload) run(ctx context.Context, fileContent io.Reader) (string, error) {
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := max(upload.concurrency, 1)
+	// Part buffers are reused once uploaded, which keeps the memory used to concurrency * part size
+	buffers := make(chan []byte, concurrency)
+	for range concurrency {
+		buffers <- nil
+	}
+
+	type part struct {
+		number int32
+		data   []byte
+		buffer []byte
+	}
+	parts := make(chan part)
+
+	var mu sync.Mutex
+	var uploadErr error
+	// fail records the first error, parts being uploaded are canceled unless the error is from reading the content,
+	// such that they can be resumed
+	fail := func(err error, cancelUploads bool) {
+		mu.Lock()
+		if uploadErr == nil {
+			uploadErr = err
+		}
+		mu.Unlock()
+		if cancelUploads {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				completed, err := upload.uploadPart(uploadCtx, p.number, p.data)
+				if err != nil {
+					fail(err, true)
+
+					continue
+				}
+				mu.Lock()
+				upload.completed = append(upload.completed, completed)
+				mu.Unlock()
+				buffers <- p.buffer
+			}
+		}()
+	}
+
+	// #nosec G115 -- the number of completed parts is bounded by maxParts
+	number := int32(len(upload.completed))
+readLoop:
+	for {
+		var buffer []byte
+		select {
+		case <-uploadCtx.Done():
+			break readLoop
+		case buffer = <-buffers:
+		}
+		if buffer == nil {
+			buffer = make([]byte, upload.state.PartSize)
+		}
+
+		n, err := io.ReadFull(fileContent, buffer)
+		if n == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			fail(fmt.Errorf("failed to read content of object: %s, due to: %v", upload.state.Key, err), false)
+
+			break
+		}
+
+		number++
+		if number > maxParts {
+			fail(fmt.Errorf("object: %s is larger than %d parts of %d bytes", upload.state.Key, maxParts, upload.state.PartSize), true)
+
+			break
+		}
+		parts <- part{number: number, data: buffer[:n], buffer: buffer}
+
+		if err != nil {
+			// The last part was shorter than the part size
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if uploadErr == nil && ctx.Err() != nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr != nil {
+		// The upload is kept to be resumed if its state is persisted
+		if upload.store == nil {
+			upload.abort(ctx)
+		}
+
+		return "", fmt.Errorf("failed to upload object: %s, location: %s, due to: %v", upload.state.Key, upload.state.Location, uploadErr)
+	}
+
+	slices.SortFunc(upload.completed, func(a, b types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+	if _, err := upload.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(upload.bucket),
+		Key:             aws.String(upload.state.Key),
+		UploadId:        aws.String(upload.state.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: upload.completed},
+	}); err != nil {
+		return "", fmt.Errorf("failed to complete multipart upload of object: %s, location: %s, due to: %v", upload.state.Key, upload.state.Location, err)
+	}
+
+	if upload.store != nil {
+		if err := upload.store.DeleteUpload(ctx, upload.fileID); err != nil {
+			log.Warnf("failed to delete upload state of object: %s, due to: %v", upload.state.Key, err)
+		}
+	}
+
+	return upload.state.Location, nil
+}
+
+func (upload *multipartUpload) uploadPart(ctx context.Context, number int32, data []byte) (types.CompletedPart, error) {
+	rsp, err := upload.client.UploadPart(ctx, &s3.UploadPartInput{
+		Body:          bytes.NewReader(data),
+		Bucket:        aws.String(upload.bucket),
+		Key:           aws.String(upload.state.Key),
+		PartNumber:    aws.Int32(number),
+		UploadId:      aws.String(upload.state.UploadID),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return types.CompletedPart{}, fmt.Errorf("failed to upload part: %d, due to: %v", number, err)
+	}
+
+	if upload.store != nil {
+		if err := upload.store.AddPart(ctx, upload.fileID, uploadstate.Part{Number: number, ETag: aws.ToString(rsp.ETag), Size: int64(len(data))}); err != nil {
+			return types.CompletedPart{}, fmt.Errorf("failed to save upload state of part: %d, due to: %v", number, err)
+		}
+	}
+
+	return types.CompletedPart{PartNumber: aws.Int32(number), ETag: rsp.ETag}, nil
+}
+
+// abort aborts the upload, and removes its state from the store, such that the parts uploaded are not kept in the
+// bucket
+func (upload *multipartUpload) abort(ctx context.Context) {
+	// The upload is aborted even if the upload context has been canceled
+	ctx = context.WithoutCancel(ctx)
+	if _, err := upload.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(upload.bucket),
+		Key:      aws.String(upload.state.Key),
+		UploadId: aws.String(upload.state.UploadID),
+	}); err != nil {
+		log.Warnf("failed to abort multipart upload of object: %s, location: %s, due to: %v", upload.state.Key, upload.state.Location, err)
+	}
+	if upload.store != nil {
+		if err := upload.store.DeleteUpload(ctx, upload.fileID); err != nil {
+			log.Warnf("failed to delete upload state of object: %s, due to: %v", upload.state.Key, err)
+		}
+	}
+}
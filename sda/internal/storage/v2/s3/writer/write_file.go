@@ -1,30 +1,115 @@
 package writer
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
 )
 
+// WriteFile uploads the file content to the active bucket, in parts of chunk_size uploaded in parallel unless the
+// content fits in a single part.
+// If the context carries an upload state store, see uploadstate.ContextWithStore, the state of the multipart upload is
+// persisted as parts are completed, and an interrupted upload of the same file is resumed from its completed parts
+// instead of being started over. The content is expected to be identical when an upload is resumed.
 func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
-	// Find endpoint / bucket that is to be used for writing
-	writer.Lock()
-	activeBucket, err := writer.activeEndpoint.findActiveBucket(ctx, writer.backendName, writer.locationBroker)
-	if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
-		writer.Unlock()
+	store, fileID := uploadstate.FromContext(ctx)
+	if store != nil {
+		resumable, err := writer.findResumableUpload(ctx, store, fileID, filePath)
+		if err != nil {
+			return "", err
+		}
+		if resumable != nil {
+			return resumable.resume(ctx, fileContent)
+		}
+	}
 
+	endpointConf, activeBucket, err := writer.findActiveLocation(ctx)
+	if err != nil {
 		return "", err
 	}
+	location := endpointConf.Endpoint + "/" + activeBucket
+
+	client, err := endpointConf.getS3Client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Read the first part to find out if the content is small enough to be uploaded in a single request
+	firstPart := make([]byte, endpointConf.partSize())
+	n, err := io.ReadFull(fileContent, firstPart)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Body:          bytes.NewReader(firstPart[:n]),
+			Bucket:        aws.String(activeBucket),
+			Key:           aws.String(filePath),
+			ContentLength: aws.Int64(int64(n)),
+		}); err != nil {
+			return "", fmt.Errorf("failed to upload object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, endpointConf.Endpoint, err)
+		}
+
+		return location, nil
+	case err != nil:
+		return "", fmt.Errorf("failed to read content of object: %s, due to: %v", filePath, err)
+	}
+
+	createRsp, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(activeBucket),
+		Key:    aws.String(filePath),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload of object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, endpointConf.Endpoint, err)
+	}
+
+	upload := &multipartUpload{
+		client:      client,
+		bucket:      activeBucket,
+		concurrency: endpointConf.UploadConcurrency,
+		store:       store,
+		fileID:      fileID,
+		state: &uploadstate.Upload{
+			UploadID: aws.ToString(createRsp.UploadId),
+			Location: location,
+			Key:      filePath,
+			PartSize: int64(len(firstPart)),
+		},
+	}
+	if store != nil {
+		if err := store.SaveUpload(ctx, fileID, upload.state); err != nil {
+			upload.abort(ctx)
+
+			return "", fmt.Errorf("failed to save upload state of object: %s, due to: %v", filePath, err)
+		}
+	}
+
+	return upload.run(ctx, io.MultiReader(bytes.NewReader(firstPart), fileContent))
+}
+
+// findActiveLocation finds the endpoint and bucket that is to be used for writing
+func (writer *Writer) findActiveLocation(ctx context.Context) (*endpointConfig, string, error) {
+	writer.Lock()
+	defer writer.Unlock()
+
+	var activeBucket string
+	var err error
+	if writer.activeEndpoint != nil {
+		activeBucket, err = writer.activeEndpoint.findActiveBucket(ctx, writer.backendName, writer.locationBroker)
+		if err != nil && !errors.Is(err, storageerrors.ErrorNoFreeBucket) {
+			return nil, "", err
+		}
+	}
 	// Current active endpoint no longer has any free buckets, roll over to next endpoint
 	if activeBucket == "" {
 		for _, endpointConf := range writer.configuredEndpoints {
 			// We dont need to evaluate the currently active bucket as we know it doesnt have any active buckets now
-			if endpointConf.Endpoint == writer.activeEndpoint.Endpoint {
+			if writer.activeEndpoint != nil && endpointConf.Endpoint == writer.activeEndpoint.Endpoint {
 				continue
 			}
 
@@ -33,36 +118,18 @@ func (writer *Writer) WriteFile(ctx context.Context, filePath string, fileConten
 				if errors.Is(err, storageerrors.ErrorNoFreeBucket) {
 					continue
 				}
-				writer.Unlock()
 
-				return "", err
+				return nil, "", err
 			}
 			writer.activeEndpoint = endpointConf
 
 			break
 		}
 	}
-	writer.Unlock()
-
-	client, err := writer.activeEndpoint.getS3Client(ctx)
-	if err != nil {
-		return "", err
-	}
-
-	uploader := transfermanager.New(client, func(u *transfermanager.Options) {
-		// Type conversation safe as chunkSizeBytes checked to be between 5mb and 1gb (in bytes)
-		//nolint:gosec // disable G115
-		u.PartSizeBytes = int64(writer.activeEndpoint.chunkSizeBytes)
-	})
-
-	_, err = uploader.UploadObject(ctx, &transfermanager.UploadObjectInput{
-		Body:   fileContent,
-		Bucket: aws.String(activeBucket),
-		Key:    aws.String(filePath),
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to upload object: %s, bucket: %s, endpoint: %s, due to: %v", filePath, activeBucket, writer.activeEndpoint.Endpoint, err)
+	// None of the configured endpoints has a free bucket
+	if activeBucket == "" {
+		return nil, "", storageerrors.ErrorNoFreeBucket
 	}
 
-	return writer.activeEndpoint.Endpoint + "/" + activeBucket, nil
+	return writer.activeEndpoint, activeBucket, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
 
 	log "github.com/sirupsen/logrus"
@@ -42,7 +43,7 @@ func NewWriter(ctx context.Context, backendName string, locationBroker locationb
 		locationBroker: locationBroker,
 	}
 	writer.locationBroker.RegisterSizeAndCountFinderFunc(backendName, func(location string) bool {
-		return !strings.HasPrefix(location, "/")
+		return locationscheme.Is(location, locationscheme.S3)
 	}, findSizeAndObjectCountOfLocation(endPointConf))
 
 	// Verify endpointConfig connections
@@ -73,7 +74,7 @@ func NewWriter(ctx context.Context, backendName string, locationBroker locationb
 }
 func getS3ClientForEndpoint(ctx context.Context, configuredEndpoints []*endpointConfig, endpoint string) (*s3.Client, error) {
 	for _, e := range configuredEndpoints {
-		if e.Endpoint != endpoint {
+		if e.Endpoint != endpoint && locationscheme.S3Prefix+locationscheme.TrimHTTPScheme(e.Endpoint) != endpoint {
 			continue
 		}
 		client, err := e.getS3Client(ctx)
@@ -88,7 +89,7 @@ func getS3ClientForEndpoint(ctx context.Context, configuredEndpoints []*endpoint
 }
 
 // parseLocation attempts to parse a location to a s3 endpoint, and a bucket
-// expected format of location is "${ENDPOINT}/${BUCKET}
+// expected format of location is "${ENDPOINT}/${BUCKET}" or "s3://${HOST}/${BUCKET}"
 func parseLocation(location string) (string, string, error) {
 	locAsURL, err := url.Parse(location)
 	if err != nil {
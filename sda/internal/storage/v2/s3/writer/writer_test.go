@@ -3,19 +3,24 @@ package writer
 import (
 	"bytes"
 	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/neicnordic/sensitive-data-archive/internal/database"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/uploadstate"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
@@ -61,10 +66,30 @@ func (m *mockLocationBroker) RegisterSizeAndCountFinderFunc(_ string, _ func(str
 type mockS3 struct {
 	server  *httptest.Server
 	buckets map[string]map[string]string // "bucket name" -> "file name" -> "content"
+	uploads map[string]map[int]string    // "upload id" -> "part number" -> "content"
+	// uploadedParts counts the parts uploaded
+	uploadedParts int
+
+	sync.Mutex
 }
 
 func (m *mockS3) handler(w http.ResponseWriter, req *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	query := req.URL.Query()
 	switch {
+	case query.Has("uploads") && req.Method == "POST":
+		m.CreateMultipartUpload(w)
+	case query.Has("partNumber") && req.Method == "PUT":
+		m.UploadPart(w, req)
+	case query.Has("uploadId") && req.Method == "POST":
+		m.CompleteMultipartUpload(w, req)
+	case query.Has("uploadId") && req.Method == "GET":
+		m.ListParts(w, req)
+	case query.Has("uploadId") && req.Method == "DELETE":
+		delete(m.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
 	case strings.HasSuffix(req.RequestURI, "PutObject"):
 		m.PutObject(w, req)
 	case strings.HasSuffix(req.RequestURI, "ListBuckets"):
@@ -175,6 +200,141 @@ func (m *mockS3) CreateBucket(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (m *mockS3) CreateMultipartUpload(w http.ResponseWriter) {
+	uploadID := fmt.Sprintf("upload-%d", len(m.uploads)+1)
+	m.uploads[uploadID] = map[int]string{}
+
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<InitiateMultipartUploadResult><UploadId>` + uploadID + `</UploadId></InitiateMultipartUploadResult>`))
+}
+
+func (m *mockS3) UploadPart(w http.ResponseWriter, req *http.Request) {
+	parts, ok := m.uploads[req.URL.Query().Get("uploadId")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+	partNumber, _ := strconv.Atoi(req.URL.Query().Get("partNumber"))
+
+	content, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	parts[partNumber] = string(content)
+	m.uploadedParts++
+
+	w.Header().Set("ETag", fmt.Sprintf(`"etag-%d-%d"`, partNumber, len(content)))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *mockS3) CompleteMultipartUpload(w http.ResponseWriter, req *http.Request) {
+	bucket := strings.Split(req.URL.Path, "/")[1]
+	fileName := strings.Split(req.URL.Path, "/")[2]
+	uploadID := req.URL.Query().Get("uploadId")
+
+	var completed struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(req.Body).Decode(&completed); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	var b strings.Builder
+	for _, part := range completed.Parts {
+		_, _ = b.WriteString(m.uploads[uploadID][part.PartNumber])
+	}
+	m.buckets[bucket][fileName] = b.String()
+	delete(m.uploads, uploadID)
+
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<CompleteMultipartUploadResult><Bucket>` + bucket + `</Bucket><Key>` + fileName + `</Key></CompleteMultipartUploadResult>`))
+}
+
+func (m *mockS3) ListParts(w http.ResponseWriter, req *http.Request) {
+	parts, ok := m.uploads[req.URL.Query().Get("uploadId")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`))
+
+		return
+	}
+
+	partNumbers := make([]int, 0, len(parts))
+	for partNumber := range parts {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Ints(partNumbers)
+
+	var b strings.Builder
+	_, _ = b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ListPartsResult><IsTruncated>false</IsTruncated>`)
+	for _, partNumber := range partNumbers {
+		_, _ = b.WriteString(fmt.Sprintf(`<Part><PartNumber>%d</PartNumber><ETag>"etag-%d-%d"</ETag><Size>%d</Size></Part>`, partNumber, partNumber, len(parts[partNumber]), len(parts[partNumber])))
+	}
+	_, _ = b.WriteString(`</ListPartsResult>`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// mockUploadStore keeps the upload state in memory
+type mockUploadStore struct {
+	uploads map[string]*uploadstate.Upload
+
+	sync.Mutex
+}
+
+func (m *mockUploadStore) GetUpload(_ context.Context, id string) (*uploadstate.Upload, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.uploads[id], nil
+}
+
+func (m *mockUploadStore) SaveUpload(_ context.Context, id string, upload *uploadstate.Upload) error {
+	m.Lock()
+	defer m.Unlock()
+	m.uploads[id] = &uploadstate.Upload{UploadID: upload.UploadID, Location: upload.Location, Key: upload.Key, PartSize: upload.PartSize}
+
+	return nil
+}
+
+func (m *mockUploadStore) AddPart(_ context.Context, id string, part uploadstate.Part) error {
+	m.Lock()
+	defer m.Unlock()
+	m.uploads[id].Parts = append(m.uploads[id].Parts, part)
+
+	return nil
+}
+
+func (m *mockUploadStore) DeleteUpload(_ context.Context, id string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.uploads, id)
+
+	return nil
+}
+
+// failingReader returns an error once the content has been read
+type failingReader struct {
+	content io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, errors.New("connection reset")
+	}
+
+	return n, err
+}
+
 func TestReaderTestSuite(t *testing.T) {
 	suite.Run(t, new(WriterTestSuite))
 }
@@ -202,6 +362,8 @@ storage:
       max_size: 10kb
       max_buckets: 3
       bucket_prefix: bucket_in_1-
+      chunk_size: 5mb
+      upload_concurrency: 2
     - endpoint: %s
       access_key: access_key2
       secret_key: secret_key2
@@ -226,7 +388,10 @@ storage:
 
 func (ts *WriterTestSuite) SetupTest() {
 	ts.s3Mock1.buckets = map[string]map[string]string{}
+	ts.s3Mock1.uploads = map[string]map[int]string{}
+	ts.s3Mock1.uploadedParts = 0
 	ts.s3Mock2.buckets = map[string]map[string]string{}
+	ts.s3Mock2.uploads = map[string]map[int]string{}
 	ts.locationBrokerMock = &mockLocationBroker{}
 
 	var err error
@@ -365,6 +530,129 @@ func (ts *WriterTestSuite) TestWriteFile_NoMockLocationBroker_FirstBucketFull()
 	ts.Equal(fmt.Sprintf("%s/bucket_in_1-2", ts.s3Mock1.server.URL), location)
 }
 
+// multipartContent returns content which is uploaded in 3 parts of 5mb
+func multipartContent() []byte {
+	return bytes.Repeat([]byte("0123456789"), 1200*1024)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_Multipart() {
+	content := multipartContent()
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	location, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", bytes.NewReader(content))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL), location)
+	ts.Equal(string(content), ts.s3Mock1.buckets["bucket_in_1-1"]["test_file_1.txt"])
+	ts.Equal(3, ts.s3Mock1.uploadedParts)
+	ts.Empty(ts.s3Mock1.uploads)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_Multipart_FailedIsAborted() {
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	_, err := ts.writer.WriteFile(context.TODO(), "test_file_1.txt", &failingReader{content: bytes.NewReader(multipartContent())})
+	ts.ErrorContains(err, "connection reset")
+	ts.Empty(ts.s3Mock1.uploads)
+	ts.Empty(ts.s3Mock1.buckets["bucket_in_1-1"])
+}
+
+func (ts *WriterTestSuite) TestWriteFile_Multipart_Resume() {
+	content := multipartContent()
+	store := &mockUploadStore{uploads: map[string]*uploadstate.Upload{}}
+	ctx := uploadstate.ContextWithStore(context.TODO(), store, "file-id")
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	// The first attempt fails after the first two parts have been read
+	_, err := ts.writer.WriteFile(ctx, "test_file_1.txt", &failingReader{content: bytes.NewReader(content[:10*1024*1024])})
+	ts.ErrorContains(err, "connection reset")
+	ts.Len(ts.s3Mock1.uploads, 1)
+	if ts.Contains(store.uploads, "file-id") {
+		ts.Len(store.uploads["file-id"].Parts, 2)
+	}
+
+	ts.s3Mock1.uploadedParts = 0
+	location, err := ts.writer.WriteFile(ctx, "test_file_1.txt", bytes.NewReader(content))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL), location)
+	ts.Equal(string(content), ts.s3Mock1.buckets["bucket_in_1-1"]["test_file_1.txt"])
+	ts.Equal(1, ts.s3Mock1.uploadedParts, "only the last part is expected to be uploaded again")
+	ts.Empty(ts.s3Mock1.uploads)
+	ts.Empty(store.uploads)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_Multipart_ResumeUploadNoLongerExists() {
+	content := multipartContent()
+	store := &mockUploadStore{uploads: map[string]*uploadstate.Upload{"file-id": {
+		UploadID: "aborted-upload",
+		Location: fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL),
+		Key:      "test_file_1.txt",
+		PartSize: 5 * 1024 * 1024,
+		Parts:    []uploadstate.Part{{Number: 1, ETag: `"etag-1-5242880"`, Size: 5 * 1024 * 1024}},
+	}}}
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	_, err := ts.writer.WriteFile(uploadstate.ContextWithStore(context.TODO(), store, "file-id"), "test_file_1.txt", bytes.NewReader(content))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(string(content), ts.s3Mock1.buckets["bucket_in_1-1"]["test_file_1.txt"])
+	ts.Equal(3, ts.s3Mock1.uploadedParts)
+	ts.Empty(store.uploads)
+}
+
+func (ts *WriterTestSuite) TestWriteFile_Multipart_ReplacedUploadIsAborted() {
+	content := multipartContent()
+	store := &mockUploadStore{uploads: map[string]*uploadstate.Upload{}}
+	ctx := uploadstate.ContextWithStore(context.TODO(), store, "file-id")
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Twice()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Twice()
+
+	_, err := ts.writer.WriteFile(ctx, "test_file_1.txt", &failingReader{content: bytes.NewReader(content[:10*1024*1024])})
+	ts.ErrorContains(err, "connection reset")
+	ts.Len(ts.s3Mock1.uploads, 1)
+
+	// The file is written to another object, the recorded upload is not resumed
+	_, err = ts.writer.WriteFile(ctx, "test_file_2.txt", bytes.NewReader(content))
+	if err != nil {
+		ts.FailNow(err.Error())
+	}
+
+	ts.Equal(string(content), ts.s3Mock1.buckets["bucket_in_1-1"]["test_file_2.txt"])
+	ts.Empty(ts.s3Mock1.uploads)
+	ts.Empty(store.uploads)
+}
+
+func (ts *WriterTestSuite) TestAbortUpload() {
+	store := &mockUploadStore{uploads: map[string]*uploadstate.Upload{}}
+	ctx := uploadstate.ContextWithStore(context.TODO(), store, "file-id")
+	ts.locationBrokerMock.On("GetObjectCount", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+	ts.locationBrokerMock.On("GetSize", fmt.Sprintf("%s/bucket_in_1-1", ts.s3Mock1.server.URL)).Return(0, nil).Once()
+
+	_, err := ts.writer.WriteFile(ctx, "test_file_1.txt", &failingReader{content: bytes.NewReader(multipartContent()[:10*1024*1024])})
+	ts.ErrorContains(err, "connection reset")
+	ts.Len(ts.s3Mock1.uploads, 1)
+	ts.Len(store.uploads, 1)
+
+	ts.NoError(ts.writer.AbortUpload(ctx))
+	ts.Empty(ts.s3Mock1.uploads)
+	ts.Empty(store.uploads)
+
+	// Nothing is recorded for the file anymore
+	ts.NoError(ts.writer.AbortUpload(ctx))
+	ts.NoError(ts.writer.AbortUpload(context.TODO()))
+}
+
 type notImplementedDatabase struct {
 }
 
@@ -1,6 +1,9 @@
 package storageerrors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrorFileNotFoundInLocation = errors.New("file not found in location")
 var ErrorNoValidLocations = errors.New("no valid locations")
@@ -9,4 +12,22 @@ var ErrorNoFreeBucket = errors.New("no free bucket")
 var ErrorNoEndpointConfiguredForLocation = errors.New("no endpoint configured for location")
 var ErrorNoValidWriter = errors.New("no valid writer configured")
 var ErrorNoValidReader = errors.New("no valid reader configured")
-var ErrorMultipleWritersNotSupported = errors.New("s3 writer and posix writer cannot be used at the same time")
+var ErrorMultipleWritersNotSupported = errors.New("multiple writers cannot be used at the same time without routing rules")
+var ErrorNoRoutingRuleMatched = errors.New("no routing rule matched the file")
+var ErrorChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumMismatchError is returned by a verifying reader when the content read does not match the stored checksum
+type ChecksumMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch, expected: %s, got: %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// Is makes errors.Is(err, ErrorChecksumMismatch) report true for a *ChecksumMismatchError
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrorChecksumMismatch
+}
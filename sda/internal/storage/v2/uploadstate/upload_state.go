@@ -0,0 +1,59 @@
+// Package uploadstate defines how the state of multipart uploads is persisted by writers that support resuming an
+// interrupted upload, such that a file which failed to be written part way through can continue from the last
+// completed part the next time it is written
+package uploadstate
+
+import (
+	"context"
+)
+
+// Part is a completed part of a multipart upload
+type Part struct {
+	// Number of the part, starting from 1
+	Number int32
+	ETag   string
+	Size   int64
+}
+
+// Upload is the state of an ongoing multipart upload
+type Upload struct {
+	UploadID string
+	// Location the upload is written to, as would be returned by the writer
+	Location string
+	Key      string
+	PartSize int64
+	// Parts which have been completed, in no particular order
+	Parts []Part
+}
+
+// Store persists the state of multipart uploads, identified by the id of the file being uploaded
+type Store interface {
+	// GetUpload returns the ongoing upload of the file, or nil if there is none
+	GetUpload(ctx context.Context, id string) (*Upload, error)
+	// SaveUpload records a new upload of the file, replacing any previous upload and its parts
+	SaveUpload(ctx context.Context, id string, upload *Upload) error
+	// AddPart records a completed part of the ongoing upload of the file
+	AddPart(ctx context.Context, id string, part Part) error
+	// DeleteUpload removes the upload of the file and its parts, once it has been completed or aborted
+	DeleteUpload(ctx context.Context, id string) error
+}
+
+type storeKey struct{}
+
+type contextStore struct {
+	store Store
+	id    string
+}
+
+// ContextWithStore returns a copy of the context carrying the store and the id of the file about to be written,
+// writers supporting resumable uploads persist the upload state of the file in the store
+func ContextWithStore(ctx context.Context, store Store, id string) context.Context {
+	return context.WithValue(ctx, storeKey{}, contextStore{store: store, id: id})
+}
+
+// FromContext returns the store and file id carried by the context, the store is nil if none were set
+func FromContext(ctx context.Context) (Store, string) {
+	s, _ := ctx.Value(storeKey{}).(contextStore)
+
+	return s.store, s.id
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"io"
 
+	azurewriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/azure/writer"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationbroker"
+	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/locationscheme"
 	posixwriter "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/posix/writer"
 	s3writer "github.com/neicnordic/sensitive-data-archive/internal/storage/v2/s3/writer"
 	"github.com/neicnordic/sensitive-data-archive/internal/storage/v2/storageerrors"
@@ -18,42 +20,87 @@ type Writer interface {
 	WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (location string, err error)
 }
 
+// uploadAborter is implemented by writers which can abort an interrupted upload recorded in an upload state store,
+// see uploadstate.ContextWithStore
+type uploadAborter interface {
+	AbortUpload(ctx context.Context) error
+}
+
+// AbortUpload aborts the upload recorded in the upload state store of the context, if any, such that an interrupted
+// upload which is not going to be resumed does not leave its parts behind. Writers which do not record their uploads
+// have nothing to abort.
+func AbortUpload(ctx context.Context, w Writer) error {
+	aborter, ok := w.(uploadAborter)
+	if !ok {
+		return nil
+	}
+
+	return aborter.AbortUpload(ctx)
+}
+
 type writer struct {
 	writer Writer
 }
 
 func NewWriter(ctx context.Context, backendName string, locationBroker locationbroker.LocationBroker) (Writer, error) {
-	w := &writer{}
+	writers := make(map[string]Writer)
 
-	var err error
 	s3Writer, err := s3writer.NewWriter(ctx, backendName, locationBroker)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
+	if s3Writer != nil {
+		writers[locationscheme.S3] = s3Writer
+	}
 	posixWriter, err := posixwriter.NewWriter(ctx, backendName, locationBroker)
 	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
 		return nil, err
 	}
-
-	if s3Writer != nil && posixWriter != nil {
-		return nil, storageerrors.ErrorMultipleWritersNotSupported
+	if posixWriter != nil {
+		writers[locationscheme.Posix] = posixWriter
 	}
-	switch {
-	case s3Writer != nil:
-		w.writer = s3Writer
-	case posixWriter != nil:
-		w.writer = posixWriter
-	default:
-		return nil, storageerrors.ErrorNoValidWriter
+	azureWriter, err := azurewriter.NewWriter(ctx, backendName, locationBroker)
+	if err != nil && !errors.Is(err, storageerrors.ErrorNoValidLocations) {
+		return nil, err
+	}
+	if azureWriter != nil {
+		writers[locationscheme.Azure] = azureWriter
 	}
 
-	return w, nil
+	switch len(writers) {
+	case 0:
+		return nil, storageerrors.ErrorNoValidWriter
+	case 1:
+		w := &writer{}
+		for _, implWriter := range writers {
+			w.writer = implWriter
+		}
+
+		return w, nil
+	default:
+		rules, err := loadRoutingRules(backendName)
+		if err != nil {
+			return nil, err
+		}
+
+		return newRoutingWriter(rules, writers)
+	}
 }
 
 func (w *writer) RemoveFile(ctx context.Context, location, filePath string) error {
-	return w.writer.RemoveFile(ctx, location, filePath)
+	// Locations with an aliased scheme, e.g "file://", are resolved to the location known by the writer
+	_, loc, err := locationscheme.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	return w.writer.RemoveFile(ctx, loc, filePath)
 }
 
 func (w *writer) WriteFile(ctx context.Context, filePath string, fileContent io.Reader) (string, error) {
 	return w.writer.WriteFile(ctx, filePath, fileContent)
 }
+
+func (w *writer) AbortUpload(ctx context.Context) error {
+	return AbortUpload(ctx, w.writer)
+}
@@ -1,7 +1,8 @@
-package main
+package userauth
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -13,13 +14,8 @@ import (
 // CegaUserResponse captures the response list
 type CegaUserResponse struct {
 	PasswordHash string `json:"passwordHash"`
-}
-
-// EGAIdentity represents an EGA user instance
-type EGAIdentity struct {
-	User    string
-	Token   string
-	ExpDate string
+	// SSHPublicKey holds the public keys the user can log in to the sftp inbox with, in authorized_keys format
+	SSHPublicKey []string `json:"sshPublicKey"`
 }
 
 // Return base64 encoded credentials for basic auth
@@ -29,15 +25,15 @@ func getb64Credentials(username, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(creds))
 }
 
-// Check whether the returned hash corresponds to the given password
-func verifyPassword(password, hash string) bool {
+// VerifyPassword checks whether the returned hash corresponds to the given password
+func VerifyPassword(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 
 	return err == nil
 }
 
-// Authenticate against CEGA
-func authenticateWithCEGA(conf config.CegaConfig, username string) (*http.Response, error) {
+// AuthenticateWithCEGA requests the credentials of the user from CEGA
+func AuthenticateWithCEGA(conf config.CegaConfig, username string) (*http.Response, error) {
 	client := &http.Client{}
 	payload := strings.NewReader("")
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", strings.TrimSuffix(conf.AuthURL, "/"), username), payload)
@@ -53,3 +49,27 @@ func authenticateWithCEGA(conf config.CegaConfig, username string) (*http.Respon
 
 	return res, err
 }
+
+// GetCegaUser returns the credentials of the user from CEGA, or nil if CEGA does not know the user
+func GetCegaUser(conf config.CegaConfig, username string) (*CegaUserResponse, error) {
+	res, err := AuthenticateWithCEGA(conf, username)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("bad response from cega: %s", res.Status)
+	}
+
+	var ur CegaUserResponse
+	if err := json.NewDecoder(res.Body).Decode(&ur); err != nil {
+		return nil, fmt.Errorf("failed to parse cega response: %v", err)
+	}
+
+	return &ur, nil
+}
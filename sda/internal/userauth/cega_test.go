@@ -0,0 +1,74 @@
+package userauth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neicnordic/sensitive-data-archive/internal/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// These are not complete tests of all functions in elixir. New tests should
+// be added as the code is updated.
+
+type CegaTests struct {
+	suite.Suite
+}
+
+func TestCegaTestSuite(t *testing.T) {
+	suite.Run(t, new(CegaTests))
+}
+
+func (ts *CegaTests) TestGetb64Credentials() {
+	user := "testUser"
+	password := "password"
+
+	expected := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+
+	assert.Equal(ts.T(), expected, getb64Credentials(user, password), "base64 encoding of credentials failing")
+}
+
+func (ts *CegaTests) TestVerifyPassword() {
+	password := "password"
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error(err)
+	}
+
+	assert.Equal(ts.T(), true, VerifyPassword(password, string(hash)), "password hash verification failing on correct hash")
+	assert.Equal(ts.T(), false, VerifyPassword(password, "wronghash"), "password hash verification returning true for wrong hash")
+}
+
+func (ts *CegaTests) TestGetCegaUser() {
+	cega := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		switch {
+		case !ok || id != "id" || secret != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/users/dummy":
+			_, _ = w.Write([]byte(`{"username": "dummy", "passwordHash": "hash", "sshPublicKey": ["ssh-ed25519 AAAA dummy"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cega.Close()
+
+	conf := config.CegaConfig{AuthURL: cega.URL + "/users/", ID: "id", Secret: "secret"}
+	user, err := GetCegaUser(conf, "dummy")
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "hash", user.PasswordHash)
+	assert.Equal(ts.T(), []string{"ssh-ed25519 AAAA dummy"}, user.SSHPublicKey)
+
+	user, err = GetCegaUser(conf, "unknown")
+	assert.NoError(ts.T(), err)
+	assert.Nil(ts.T(), user)
+
+	conf.Secret = "wrong"
+	_, err = GetCegaUser(conf, "dummy")
+	assert.ErrorContains(ts.T(), err, "401")
+}